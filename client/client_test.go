@@ -185,6 +185,7 @@ func testJWTAuthenticationHeader(t *testing.T, client *GitlabNetClient) {
 		require.NoError(t, err)
 		require.True(t, token.Valid)
 		require.Equal(t, "gitlab-shell", claims.Issuer)
+		require.Len(t, claims.ID, 32)
 		require.WithinDuration(t, time.Now().Truncate(time.Second), claims.IssuedAt.Time, time.Second)
 		require.WithinDuration(t, time.Now().Truncate(time.Second).Add(time.Minute), claims.ExpiresAt.Time, time.Second)
 	}
@@ -323,3 +324,75 @@ func TestRetryOnFailure(t *testing.T) {
 	require.EqualError(t, err, "Internal API unreachable")
 	require.Equal(t, 3, reqAttempts)
 }
+
+func TestStreamingRequest(t *testing.T) {
+	reqAttempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqAttempts++
+
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/api/v4/internal/stream", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NotEmpty(t, r.Header.Get(apiSecretHeaderName))
+		// The body is sent as it is produced, so its length isn't known upfront
+		require.Equal(t, int64(-1), r.ContentLength)
+
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		if string(b) == "fail" {
+			w.WriteHeader(500)
+			return
+		}
+
+		fmt.Fprint(w, "Echo: "+string(b))
+	}))
+	defer srv.Close()
+
+	httpClient, err := NewHTTPClientWithOpts(srv.URL, "/", "", "", 1, defaultHttpOpts)
+	require.NoError(t, err)
+	client, err := NewGitlabNetClient("", "", secret, httpClient)
+	require.NoError(t, err)
+
+	body := io.MultiReader(strings.NewReader("chunk 1, "), strings.NewReader("chunk 2"))
+	response, err := client.DoStreamingRequest(context.Background(), http.MethodPost, "/api/v4/internal/stream", body)
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, "Echo: chunk 1, chunk 2", string(responseBody))
+
+	_, err = client.DoStreamingRequest(context.Background(), http.MethodPost, "/api/v4/internal/stream", io.MultiReader(strings.NewReader("fail")))
+	require.EqualError(t, err, "Internal API error (500)")
+	require.Equal(t, 2, reqAttempts)
+}
+
+func TestAcceptV2(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == acceptV2Header {
+			w.Header().Set("Content-Type", MediaTypeV2+"; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	defer srv.Close()
+
+	httpClient, err := NewHTTPClientWithOpts(srv.URL, "/", "", "", 1, defaultHttpOpts)
+	require.NoError(t, err)
+	client, err := NewGitlabNetClient("", "", secret, httpClient)
+	require.NoError(t, err)
+
+	response, err := client.Get(context.Background(), "/discover")
+	require.NoError(t, err)
+	response.Body.Close()
+	require.False(t, IsV2Response(response))
+
+	client.AcceptV2()
+
+	response, err = client.Get(context.Background(), "/discover")
+	require.NoError(t, err)
+	response.Body.Close()
+	require.True(t, IsV2Response(response))
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ServerTimeHeader is the time sync hint of the internal API, the RFC
+	// 3339 time of the server when it responded. The Date header is used when
+	// it is missing.
+	ServerTimeHeader = "Gitlab-Server-Time"
+
+	// minClockSkew is the difference with the time of the server that is
+	// ignored, as the Date header is only precise to the second and the
+	// responses take time to arrive
+	minClockSkew = 2 * time.Second
+)
+
+// Clock is the time the requests to the internal API are signed with. It
+// follows the time of the server from the hints of its responses, so that
+// hosts with a drifting clock keep signing valid requests. A nil *Clock is
+// the local time without tolerance.
+type Clock struct {
+	// tolerance widens the validity of the signatures, for the skew between
+	// the hints
+	tolerance time.Duration
+	offset    atomic.Int64
+	now       func() time.Time
+}
+
+func NewClock(tolerance time.Duration) *Clock {
+	return &Clock{tolerance: tolerance, now: time.Now}
+}
+
+// Now returns the time of the server as last hinted
+func (c *Clock) Now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
+
+	return c.now().Add(time.Duration(c.offset.Load()))
+}
+
+// Tolerance returns the clock skew the signatures tolerate
+func (c *Clock) Tolerance() time.Duration {
+	if c == nil {
+		return 0
+	}
+
+	return c.tolerance
+}
+
+// Observe follows the time hinted by response. It returns whether the
+// clock was adjusted by more than the tolerance, which may have made the
+// signature of the request invalid.
+func (c *Clock) Observe(response *http.Response) bool {
+	if c == nil || response == nil {
+		return false
+	}
+
+	serverTime, ok := hintedTime(response)
+	if !ok {
+		return false
+	}
+
+	offset := serverTime.Sub(c.now())
+	if offset > -minClockSkew && offset < minClockSkew {
+		offset = 0
+	}
+
+	previous := time.Duration(c.offset.Swap(int64(offset)))
+	change := offset - previous
+	if change < 0 {
+		change = -change
+	}
+
+	return change > c.tolerance && change >= minClockSkew
+}
+
+func hintedTime(response *http.Response) (time.Time, bool) {
+	if hint := response.Header.Get(ServerTimeHeader); hint != "" {
+		if t, err := time.Parse(time.RFC3339Nano, hint); err == nil {
+			return t, true
+		}
+	}
+
+	if date := response.Header.Get("Date"); date != "" {
+		if t, err := http.ParseTime(date); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockObserve(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewClock(10 * time.Second)
+	clock.now = func() time.Time { return now }
+
+	hint := func(header string, serverTime time.Time) *http.Response {
+		response := &http.Response{Header: http.Header{}}
+		if header == ServerTimeHeader {
+			response.Header.Set(header, serverTime.Format(time.RFC3339Nano))
+		} else {
+			response.Header.Set(header, serverTime.Format(http.TimeFormat))
+		}
+		return response
+	}
+
+	// Small skews are ignored
+	require.False(t, clock.Observe(hint("Date", now.Add(time.Second))))
+	require.Equal(t, now, clock.Now())
+
+	// Skews within the tolerance are followed
+	require.False(t, clock.Observe(hint(ServerTimeHeader, now.Add(5*time.Second))))
+	require.Equal(t, now.Add(5*time.Second), clock.Now())
+
+	// Skews beyond the tolerance are reported
+	require.True(t, clock.Observe(hint("Date", now.Add(-time.Minute))))
+	require.Equal(t, now.Add(-time.Minute), clock.Now())
+
+	// Responses without hints don't change the clock
+	require.False(t, clock.Observe(&http.Response{Header: http.Header{}}))
+	require.Equal(t, now.Add(-time.Minute), clock.Now())
+
+	var nilClock *Clock
+	require.False(t, nilClock.Observe(hint("Date", now)))
+	require.Zero(t, nilClock.Tolerance())
+	require.WithinDuration(t, time.Now(), nilClock.Now(), time.Second)
+}
+
+func TestSigningWithClockSkew(t *testing.T) {
+	// The clock of the server is an hour ahead
+	serverNow := func() time.Time { return time.Now().Add(time.Hour) }
+
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set(ServerTimeHeader, serverNow().Format(time.RFC3339Nano))
+
+		claims := &jwt.RegisteredClaims{}
+		_, err := jwt.ParseWithClaims(r.Header.Get(apiSecretHeaderName), claims, func(token *jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		}, jwt.WithTimeFunc(serverNow), jwt.WithIssuedAt())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"message": "401 Unauthorized"}`)
+			return
+		}
+
+		fmt.Fprint(w, "{}")
+	}))
+	defer srv.Close()
+
+	httpClient, err := NewHTTPClientWithOpts(srv.URL, "/", "", "", 1, []HTTPClientOpt{WithClockSkewTolerance(30 * time.Second)})
+	require.NoError(t, err)
+	client, err := NewGitlabNetClient("", "", secret, httpClient)
+	require.NoError(t, err)
+
+	// The request is signed again with the time of the server
+	response, err := client.Get(context.Background(), "/check")
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, 2, attempts)
+
+	// The next requests are signed with the time of the server right away
+	response, err = client.Get(context.Background(), "/check")
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, 3, attempts)
+}
@@ -0,0 +1,300 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	defaultHealthCheckPath     = "/api/v4/internal/check"
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+	defaultFailureThreshold    = 3
+	defaultRecoveryThreshold   = 2
+)
+
+// FailoverOpts configures the failover between GitLab URLs
+type FailoverOpts struct {
+	// HealthCheckPath is requested from each URL to check its health. A URL
+	// answering with a status below 500 is healthy, even if it rejects the
+	// unauthenticated request. Defaults to /api/v4/internal/check.
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	// FailureThreshold is the number of consecutive failed health checks or
+	// requests marking a URL unhealthy
+	FailureThreshold int
+	// RecoveryThreshold is the number of consecutive passed health checks
+	// marking an unhealthy URL healthy again
+	RecoveryThreshold int
+	// OnFailover is called when the requests move from a URL to another, and
+	// OnHealthChange when a URL becomes healthy or unhealthy. They must not
+	// block.
+	OnFailover     func(from, to string)
+	OnHealthChange func(url string, healthy bool)
+}
+
+// Failover sends the requests to the first healthy one of several GitLab
+// URLs, in order of preference, so that the internal API stays reachable
+// when one of its entry points is down. The URLs are health checked in the
+// background once the first request was made, and the requests fail back to
+// a preferred URL as soon as it's healthy again. The hosts of the URLs are
+// resolved like the one of the GitLab URL.
+type Failover struct {
+	opts FailoverOpts
+	// from is the GitLab URL the requests are made to, which is replaced by
+	// the active one
+	from      string
+	endpoints []*failoverEndpoint
+	transport http.RoundTripper
+
+	mu     sync.Mutex
+	active int
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+}
+
+type failoverEndpoint struct {
+	url       string
+	healthy   bool
+	failures  int
+	successes int
+}
+
+// WithFailover will configure the HttpClient to fail over between urls, in
+// order of preference. It isn't supported with UNIX sockets.
+func WithFailover(urls []string, opts FailoverOpts) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.failoverURLs = urls
+		hcc.failoverOpts = opts
+	}
+}
+
+// newFailover returns a failover of the requests made to from between urls,
+// health checked through transport
+func newFailover(from string, urls []string, transport http.RoundTripper, opts FailoverOpts) (*Failover, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("failover: no GitLab URL")
+	}
+
+	if opts.HealthCheckPath == "" {
+		opts.HealthCheckPath = defaultHealthCheckPath
+	}
+	if opts.HealthCheckInterval <= 0 {
+		opts.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if opts.HealthCheckTimeout <= 0 {
+		opts.HealthCheckTimeout = defaultHealthCheckTimeout
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaultFailureThreshold
+	}
+	if opts.RecoveryThreshold <= 0 {
+		opts.RecoveryThreshold = defaultRecoveryThreshold
+	}
+
+	f := &Failover{
+		opts:      opts,
+		from:      strings.TrimSuffix(from, "/"),
+		transport: transport,
+		stop:      make(chan struct{}),
+	}
+
+	for _, u := range urls {
+		if !strings.HasPrefix(u, httpProtocol) && !strings.HasPrefix(u, httpsProtocol) {
+			return nil, errors.New("failover: only http and https GitLab URLs are supported")
+		}
+
+		f.endpoints = append(f.endpoints, &failoverEndpoint{url: strings.TrimSuffix(u, "/"), healthy: true})
+		if opts.OnHealthChange != nil {
+			opts.OnHealthChange(strings.TrimSuffix(u, "/"), true)
+		}
+	}
+
+	return f, nil
+}
+
+func failoverToHTTPS(urls []string) bool {
+	for _, u := range urls {
+		if strings.HasPrefix(u, httpsProtocol) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Active returns the URL the requests are sent to
+func (f *Failover) Active() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.endpoints[f.active].url
+}
+
+// Close stops the health checks
+func (f *Failover) Close() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+// RoundTripper sends the requests made through next to the active URL
+func (f *Failover) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return &failoverRoundTripper{next: next, failover: f}
+}
+
+type failoverRoundTripper struct {
+	next     http.RoundTripper
+	failover *Failover
+}
+
+func (rt *failoverRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	f := rt.failover
+	f.startOnce.Do(func() { go f.run() })
+
+	index := f.target(isHedge(request.Context()))
+
+	request, err := f.rewrite(request, f.endpoints[index].url)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := rt.next.RoundTrip(request)
+	// Requests canceled by their caller tell nothing about the URL
+	if request.Context().Err() == nil {
+		f.observe(index, err == nil && !unavailable(response.StatusCode))
+	}
+
+	return response, err
+}
+
+// target returns the index of the URL a request is sent to: the active one,
+// or for hedges the next healthy one when there is one
+func (f *Failover) target(hedge bool) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if hedge {
+		for i := range f.endpoints {
+			if next := (f.active + 1 + i) % len(f.endpoints); next != f.active && f.endpoints[next].healthy {
+				return next
+			}
+		}
+	}
+
+	return f.active
+}
+
+// rewrite returns request sent to target rather than to the GitLab URL
+func (f *Failover) rewrite(request *http.Request, target string) (*http.Request, error) {
+	original := request.URL.String()
+	if target == f.from || !strings.HasPrefix(original, f.from) {
+		return request, nil
+	}
+
+	rewritten, err := url.Parse(target + strings.TrimPrefix(original, f.from))
+	if err != nil {
+		return nil, err
+	}
+
+	request = request.Clone(request.Context())
+	request.URL = rewritten
+	request.Host = ""
+
+	return request, nil
+}
+
+func unavailable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// run health checks the URLs until the failover is closed
+func (f *Failover) run() {
+	client := &http.Client{Transport: f.transport, Timeout: f.opts.HealthCheckTimeout}
+
+	ticker := time.NewTicker(f.opts.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		for i := range f.endpoints {
+			f.observe(i, f.check(client, f.endpoints[i].url))
+		}
+
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *Failover) check(client *http.Client, u string) bool {
+	response, err := client.Get(u + f.opts.HealthCheckPath)
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+
+	return response.StatusCode < http.StatusInternalServerError
+}
+
+// observe records whether a health check of or a request to the URL at
+// index passed, and elects the active URL again when its health changed
+func (f *Failover) observe(index int, passed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	e := f.endpoints[index]
+	if passed {
+		e.failures = 0
+		e.successes++
+		if e.healthy || e.successes < f.opts.RecoveryThreshold {
+			return
+		}
+	} else {
+		e.successes = 0
+		e.failures++
+		if !e.healthy || e.failures < f.opts.FailureThreshold {
+			return
+		}
+	}
+
+	e.healthy = passed
+	log.WithFields(log.Fields{"url": e.url, "healthy": e.healthy}).Warn("failover: GitLab URL health changed")
+	if f.opts.OnHealthChange != nil {
+		f.opts.OnHealthChange(e.url, e.healthy)
+	}
+
+	f.elect()
+}
+
+// elect makes the first healthy URL the active one. The active URL is kept
+// when none is healthy.
+func (f *Failover) elect() {
+	for i, e := range f.endpoints {
+		if !e.healthy {
+			continue
+		}
+		if i == f.active {
+			return
+		}
+
+		previous := f.active
+		f.active = i
+
+		from := f.endpoints[previous].url
+		log.WithFields(log.Fields{"from": from, "to": e.url, "failback": i < previous}).Warn("failover: moving the requests to another GitLab URL")
+		if f.opts.OnFailover != nil {
+			f.opts.OnFailover(from, e.url)
+		}
+
+		return
+	}
+}
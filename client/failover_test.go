@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type failoverServer struct {
+	*httptest.Server
+	down     atomic.Bool
+	requests atomic.Int64
+}
+
+func startFailoverServer(t *testing.T) *failoverServer {
+	s := &failoverServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.URL.Path != defaultHealthCheckPath {
+			s.requests.Add(1)
+		}
+		// The health checks aren't authenticated
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestFailover(t *testing.T) {
+	primary := startFailoverServer(t)
+	secondary := startFailoverServer(t)
+
+	var mu sync.Mutex
+	var failovers []string
+	client, err := NewHTTPClientWithOpts(primary.URL, "", "", "", 0, []HTTPClientOpt{
+		WithHTTPRetryOpts(time.Millisecond, time.Millisecond, 0),
+		WithFailover([]string{primary.URL, secondary.URL}, FailoverOpts{
+			HealthCheckInterval: 10 * time.Millisecond,
+			FailureThreshold:    2,
+			RecoveryThreshold:   2,
+			OnFailover: func(from, to string) {
+				mu.Lock()
+				defer mu.Unlock()
+				failovers = append(failovers, from+" -> "+to)
+			},
+		}),
+	})
+	require.NoError(t, err)
+	defer client.Failover.Close()
+
+	get := func() {
+		request, err := http.NewRequestWithContext(context.Background(), http.MethodGet, client.Host+"/api/v4/internal/discover", nil)
+		require.NoError(t, err)
+
+		response, err := client.RetryableHTTP.HTTPClient.Do(request)
+		require.NoError(t, err)
+		response.Body.Close()
+	}
+
+	get()
+	require.Equal(t, primary.URL, client.Failover.Active())
+	require.EqualValues(t, 1, primary.requests.Load())
+
+	primary.down.Store(true)
+	require.Eventually(t, func() bool { return client.Failover.Active() == secondary.URL }, 5*time.Second, 10*time.Millisecond)
+
+	get()
+	require.EqualValues(t, 1, primary.requests.Load())
+	require.EqualValues(t, 1, secondary.requests.Load())
+
+	primary.down.Store(false)
+	require.Eventually(t, func() bool { return client.Failover.Active() == primary.URL }, 5*time.Second, 10*time.Millisecond)
+
+	get()
+	require.EqualValues(t, 2, primary.requests.Load())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{primary.URL + " -> " + secondary.URL, secondary.URL + " -> " + primary.URL}, failovers)
+}
+
+func TestFailoverFailedRequests(t *testing.T) {
+	primary := startFailoverServer(t)
+	secondary := startFailoverServer(t)
+
+	f, err := newFailover(primary.URL, []string{primary.URL, secondary.URL}, http.DefaultTransport, FailoverOpts{
+		HealthCheckInterval: time.Hour,
+		FailureThreshold:    2,
+	})
+	require.NoError(t, err)
+	defer f.Close()
+
+	primary.down.Store(true)
+	rt := f.RoundTripper(http.DefaultTransport)
+	for i := 0; i < 2; i++ {
+		request, err := http.NewRequest(http.MethodGet, primary.URL+"/api/v4/internal/allowed", nil)
+		require.NoError(t, err)
+
+		response, err := rt.RoundTrip(request)
+		require.NoError(t, err)
+		response.Body.Close()
+	}
+
+	require.Equal(t, secondary.URL, f.Active())
+}
+
+func TestFailoverValidation(t *testing.T) {
+	_, err := NewHTTPClientWithOpts("http+unix:///tmp/gitlab.socket", "", "", "", 0, []HTTPClientOpt{
+		WithFailover([]string{"http://gitlab-1.example.com"}, FailoverOpts{}),
+	})
+	require.EqualError(t, err, "failover isn't supported with UNIX sockets")
+
+	_, err = NewHTTPClientWithOpts("http://gitlab-1.example.com", "", "", "", 0, []HTTPClientOpt{
+		WithFailover([]string{"http://gitlab-1.example.com", "http+unix:///tmp/gitlab.socket"}, FailoverOpts{}),
+	})
+	require.EqualError(t, err, "failover: only http and https GitLab URLs are supported")
+}
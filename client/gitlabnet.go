@@ -3,9 +3,12 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"strings"
 	"time"
@@ -21,6 +24,12 @@ const (
 	defaultUserAgent    = "GitLab-Shell"
 	jwtTTL              = time.Minute
 	jwtIssuer           = "gitlab-shell"
+
+	// MediaTypeV2 is the media type of the internal API responses using the
+	// version 2 schema. Versions of Rails that don't support it keep
+	// answering with application/json.
+	MediaTypeV2    = "application/vnd.gitlab-internal.v2+json"
+	acceptV2Header = MediaTypeV2 + ", application/json;q=0.9"
 )
 
 type ErrorResponse struct {
@@ -33,6 +42,7 @@ type GitlabNetClient struct {
 	password   string
 	secret     string
 	userAgent  string
+	accept     string
 }
 
 type ApiError struct {
@@ -123,6 +133,20 @@ func parseError(resp *http.Response, respErr error) error {
 	}
 }
 
+// AcceptV2 asks for the version 2 schema of the responses, for the callers
+// that handle both the version 1 and the version 2 schemas so that gitlab-shell
+// and Rails can be upgraded independently
+func (c *GitlabNetClient) AcceptV2() {
+	c.accept = acceptV2Header
+}
+
+// IsV2Response returns whether the response uses the version 2 schema
+func IsV2Response(response *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+
+	return mediaType == MediaTypeV2
+}
+
 func (c *GitlabNetClient) Get(ctx context.Context, path string) (*http.Response, error) {
 	return c.DoRequest(ctx, http.MethodGet, normalizePath(path), nil)
 }
@@ -133,6 +157,9 @@ func (c *GitlabNetClient) Post(ctx context.Context, path string, data interface{
 
 func (c *GitlabNetClient) Do(request *http.Request) (*http.Response, error) {
 	response, err := c.httpClient.RetryableHTTP.HTTPClient.Do(request)
+	if err == nil {
+		c.httpClient.Clock.Observe(response)
+	}
 	if err := parseError(response, err); err != nil {
 		return nil, err
 	}
@@ -146,30 +173,95 @@ func (c *GitlabNetClient) DoRequest(ctx context.Context, method, path string, da
 		return nil, err
 	}
 
-	user, password := c.user, c.password
-	if user != "" && password != "" {
-		request.SetBasicAuth(user, password)
+	if err := c.setHeaders(request.Request); err != nil {
+		return nil, err
+	}
+
+	response, err := c.httpClient.RetryableHTTP.Do(request)
+
+	// The signature is rejected when the clocks drifted apart, sign it again
+	// with the time of the server
+	if err == nil && response.StatusCode == http.StatusUnauthorized && c.httpClient.Clock.Observe(response) {
+		response.Body.Close()
+
+		if err := c.sign(request.Request); err != nil {
+			return nil, err
+		}
+
+		response, err = c.httpClient.RetryableHTTP.Do(request)
+	}
+
+	if err == nil {
+		c.httpClient.Clock.Observe(response)
+	}
+
+	if err := parseError(response, err); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// DoStreamingRequest is like DoRequest but sends body as it is read instead of
+// buffering it, which means the request is never retried.
+func (c *GitlabNetClient) DoStreamingRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, appendPath(c.httpClient.Host, path), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.setHeaders(request); err != nil {
+		return nil, err
 	}
 
+	return c.Do(request)
+}
+
+// sign signs the request with a JWT, valid within the clock skew tolerance
+// around the time of the server. Its nonce lets the server reject replays.
+func (c *GitlabNetClient) sign(request *http.Request) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	clock := c.httpClient.Clock
+	now := clock.Now()
 	claims := jwt.RegisteredClaims{
 		Issuer:    jwtIssuer,
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(jwtTTL)),
+		ID:        hex.EncodeToString(nonce),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(jwtTTL + clock.Tolerance())),
 	}
+	if tolerance := clock.Tolerance(); tolerance > 0 {
+		claims.NotBefore = jwt.NewNumericDate(now.Add(-tolerance))
+	}
+
 	secretBytes := []byte(strings.TrimSpace(c.secret))
 	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secretBytes)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	request.Header.Set(apiSecretHeaderName, tokenString)
 
+	return nil
+}
+
+func (c *GitlabNetClient) setHeaders(request *http.Request) error {
+	user, password := c.user, c.password
+	if user != "" && password != "" {
+		request.SetBasicAuth(user, password)
+	}
+
+	if err := c.sign(request); err != nil {
+		return err
+	}
+
 	request.Header.Add("Content-Type", "application/json")
 	request.Header.Add("User-Agent", c.userAgent)
-
-	response, err := c.httpClient.RetryableHTTP.Do(request)
-	if err := parseError(response, err); err != nil {
-		return nil, err
+	if c.accept != "" {
+		request.Header.Set("Accept", c.accept)
 	}
 
-	return response, nil
+	return nil
 }
@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HedgingOpts configures the hedging of requests to the internal API
+type HedgingOpts struct {
+	// Delay is how long a request is waited on before it's sent again
+	Delay time.Duration
+	// Paths are the paths of the internal API, e.g. /authorized_keys, whose
+	// GET and HEAD requests are hedged, including the ones below them
+	Paths []string
+	// OnHedge is called when a request was sent again with the path it
+	// matched and whether the hedge answered first. It must not block.
+	OnHedge func(path string, hedgeWon bool)
+}
+
+// WithHedging will configure the HttpClient to send the idempotent requests
+// of some paths again when they haven't been answered after a delay, and to
+// use the first response, to cut the tail latency of the internal API. The
+// hedge goes to another healthy GitLab URL when failing over between several.
+func WithHedging(opts HedgingOpts) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.hedging = opts
+	}
+}
+
+// hedgeContextKey marks the hedges in the context of their requests
+type hedgeContextKey struct{}
+
+func isHedge(ctx context.Context) bool {
+	hedge, _ := ctx.Value(hedgeContextKey{}).(bool)
+	return hedge
+}
+
+type hedgingRoundTripper struct {
+	next http.RoundTripper
+	opts HedgingOpts
+}
+
+func newHedgingRoundTripper(next http.RoundTripper, opts HedgingOpts) http.RoundTripper {
+	if opts.Delay <= 0 || len(opts.Paths) == 0 {
+		return next
+	}
+
+	return &hedgingRoundTripper{next: next, opts: opts}
+}
+
+type hedgeResult struct {
+	response *http.Response
+	err      error
+	hedge    bool
+	cancel   context.CancelFunc
+}
+
+func (rt *hedgingRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	path, ok := rt.hedgedPath(request)
+	if !ok {
+		return rt.next.RoundTrip(request)
+	}
+
+	results := make(chan hedgeResult, 2)
+	send := func(hedge bool) context.CancelFunc {
+		ctx, cancel := context.WithCancel(request.Context())
+		if hedge {
+			ctx = context.WithValue(ctx, hedgeContextKey{}, true)
+		}
+
+		go func() {
+			response, err := rt.next.RoundTrip(request.Clone(ctx))
+			results <- hedgeResult{response: response, err: err, hedge: hedge, cancel: cancel}
+		}()
+
+		return cancel
+	}
+
+	cancels := map[bool]context.CancelFunc{false: send(false)}
+	pending := 1
+
+	timer := time.NewTimer(rt.opts.Delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			cancels[true] = send(true)
+			pending++
+		case result := <-results:
+			pending--
+			// A failed request is returned once the other one failed as
+			// well, or right away when it hasn't been sent
+			if result.err != nil && pending > 0 {
+				result.cancel()
+				continue
+			}
+
+			if _, hedged := cancels[true]; hedged && rt.opts.OnHedge != nil {
+				rt.opts.OnHedge(path, result.hedge)
+			}
+
+			// The request losing the race is canceled
+			if cancel, ok := cancels[!result.hedge]; ok {
+				cancel()
+			}
+			go discard(results, pending)
+
+			if result.err != nil {
+				result.cancel()
+				return nil, result.err
+			}
+
+			result.response.Body = &cancelingBody{ReadCloser: result.response.Body, cancel: result.cancel}
+			return result.response, nil
+		}
+	}
+}
+
+// hedgedPath returns the path of the options the request matches
+func (rt *hedgingRoundTripper) hedgedPath(request *http.Request) (string, bool) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		return "", false
+	}
+
+	_, apiPath, found := strings.Cut(request.URL.Path, internalApiPath)
+	if !found {
+		return "", false
+	}
+
+	for _, path := range rt.opts.Paths {
+		if apiPath == path || strings.HasPrefix(apiPath, strings.TrimSuffix(path, "/")+"/") {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
+// discard closes the responses of the pending requests that lost the race
+func discard(results chan hedgeResult, pending int) {
+	for ; pending > 0; pending-- {
+		result := <-results
+		result.cancel()
+		if result.err == nil {
+			result.response.Body.Close()
+		}
+	}
+}
+
+// cancelingBody cancels the context of the request once its response was
+// read
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
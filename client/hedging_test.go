@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startSlowServer starts a server answering the first request it gets only
+// once it was canceled, and the other ones right away with their number. The
+// health checks of failovers are answered right away.
+func startSlowServer(t *testing.T, canceled chan<- struct{}) (*httptest.Server, *atomic.Int64) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == defaultHealthCheckPath {
+			return
+		}
+
+		n := requests.Add(1)
+		if n == 1 {
+			select {
+			case <-r.Context().Done():
+				canceled <- struct{}{}
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+
+		w.Write([]byte{byte('0' + n)})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func hedgingClient(t *testing.T, gitlabURL string, opts ...HTTPClientOpt) *HttpClient {
+	client, err := NewHTTPClientWithOpts(gitlabURL, "", "", "", 0, append(opts, WithHTTPRetryOpts(time.Millisecond, time.Millisecond, 0)))
+	require.NoError(t, err)
+
+	return client
+}
+
+func hedgingRequest(t *testing.T, client *HttpClient, method, path string) string {
+	request, err := http.NewRequestWithContext(context.Background(), method, client.Host+path, nil)
+	require.NoError(t, err)
+
+	response, err := client.RetryableHTTP.HTTPClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestHedging(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	server, requests := startSlowServer(t, canceled)
+
+	hedges := make(chan bool, 1)
+	client := hedgingClient(t, server.URL, WithHedging(HedgingOpts{
+		Delay:   20 * time.Millisecond,
+		Paths:   []string{"/authorized_keys", "/discover"},
+		OnHedge: func(path string, hedgeWon bool) { hedges <- hedgeWon },
+	}))
+
+	require.Equal(t, "2", hedgingRequest(t, client, http.MethodGet, "/api/v4/internal/authorized_keys?key=key"))
+	require.True(t, <-hedges)
+	<-canceled
+
+	require.Equal(t, "3", hedgingRequest(t, client, http.MethodGet, "/api/v4/internal/discover?username=alex"))
+	require.EqualValues(t, 3, requests.Load())
+	require.Empty(t, hedges)
+}
+
+func TestHedgingUnmatchedRequests(t *testing.T) {
+	for _, tc := range []struct {
+		desc, method, path string
+	}{
+		{desc: "not idempotent", method: http.MethodPost, path: "/api/v4/internal/authorized_keys"},
+		{desc: "other path", method: http.MethodGet, path: "/api/v4/internal/authorized_keys_other"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			var requests atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				time.Sleep(50 * time.Millisecond)
+			}))
+			defer server.Close()
+
+			client := hedgingClient(t, server.URL, WithHedging(HedgingOpts{
+				Delay: time.Millisecond,
+				Paths: []string{"/authorized_keys"},
+			}))
+
+			hedgingRequest(t, client, tc.method, tc.path)
+			require.EqualValues(t, 1, requests.Load())
+		})
+	}
+}
+
+func TestHedgingFailover(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	primary, _ := startSlowServer(t, canceled)
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secondary"))
+	}))
+	defer secondary.Close()
+
+	client := hedgingClient(t, primary.URL,
+		WithFailover([]string{primary.URL, secondary.URL}, FailoverOpts{HealthCheckInterval: time.Hour}),
+		WithHedging(HedgingOpts{Delay: 20 * time.Millisecond, Paths: []string{"/discover"}}),
+	)
+	defer client.Failover.Close()
+
+	require.Equal(t, "secondary", hedgingRequest(t, client, http.MethodGet, "/api/v4/internal/discover?username=alex"))
+	require.Equal(t, primary.URL, client.Failover.Active())
+	<-canceled
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -29,9 +30,20 @@ const (
 
 var ErrCafileNotFound = errors.New("cafile not found")
 
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
 type HttpClient struct {
 	RetryableHTTP *retryablehttp.Client
 	Host          string
+	// Clock is the time the requests are signed with
+	Clock *Clock
+	// Failover is the failover between GitLab URLs, nil without one
+	Failover *Failover
 }
 
 type httpClientCfg struct {
@@ -39,6 +51,13 @@ type httpClientCfg struct {
 	caFile, caPath             string
 	retryWaitMin, retryWaitMax time.Duration
 	retryMax                   int
+	resolver                   *Resolver
+	clockSkewTolerance         time.Duration
+	proxyURL                   *url.URL
+	fips                       bool
+	failoverURLs               []string
+	failoverOpts               FailoverOpts
+	hedging                    HedgingOpts
 }
 
 func (hcc httpClientCfg) HaveCertAndKey() bool { return hcc.keyPath != "" && hcc.certPath != "" }
@@ -63,6 +82,31 @@ func WithHTTPRetryOpts(waitMin, waitMax time.Duration, maxAttempts int) HTTPClie
 	}
 }
 
+// WithResolver will configure the HttpClient to resolve the GitLab host with
+// resolver. It isn't used for UNIX sockets.
+func WithResolver(resolver *Resolver) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.resolver = resolver
+	}
+}
+
+// WithClockSkewTolerance will configure the HttpClient to sign requests that
+// remain valid for the server when the clocks drifted apart by tolerance.
+func WithClockSkewTolerance(tolerance time.Duration) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.clockSkewTolerance = tolerance
+	}
+}
+
+// WithFIPS will configure the HttpClient to only negotiate TLS 1.2 with FIPS
+// approved cipher suites and curves, matching the settings of the validated
+// crypto module of FIPS builds.
+func WithFIPS() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.fips = true
+	}
+}
+
 func validateCaFile(filename string) error {
 	if filename == "" {
 		return nil
@@ -98,9 +142,9 @@ func NewHTTPClientWithOpts(gitlabURL, gitlabRelativeURLRoot, caFile, caPath stri
 	var err error
 	if strings.HasPrefix(gitlabURL, unixSocketProtocol) {
 		transport, host = buildSocketTransport(gitlabURL, gitlabRelativeURLRoot)
-	} else if strings.HasPrefix(gitlabURL, httpProtocol) {
+	} else if strings.HasPrefix(gitlabURL, httpProtocol) && !failoverToHTTPS(hcc.failoverURLs) {
 		transport, host = buildHttpTransport(gitlabURL)
-	} else if strings.HasPrefix(gitlabURL, httpsProtocol) {
+	} else if strings.HasPrefix(gitlabURL, httpsProtocol) || strings.HasPrefix(gitlabURL, httpProtocol) {
 		err = validateCaFile(caFile)
 		if err != nil {
 			return nil, err
@@ -114,6 +158,18 @@ func NewHTTPClientWithOpts(gitlabURL, gitlabRelativeURLRoot, caFile, caPath stri
 		return nil, errors.New("unknown GitLab URL prefix")
 	}
 
+	if !strings.HasPrefix(gitlabURL, unixSocketProtocol) {
+		transport.Proxy = http.ProxyFromEnvironment
+		if hcc.proxyURL != nil {
+			transport.Proxy = http.ProxyURL(hcc.proxyURL)
+		}
+
+		// The resolver resolves the host of the proxy when there is one
+		if hcc.resolver != nil {
+			transport.DialContext = hcc.resolver.DialContext
+		}
+	}
+
 	c := retryablehttp.NewClient()
 	c.RetryMax = hcc.retryMax
 	c.RetryWaitMax = hcc.retryWaitMax
@@ -122,7 +178,22 @@ func NewHTTPClientWithOpts(gitlabURL, gitlabRelativeURLRoot, caFile, caPath stri
 	c.HTTPClient.Transport = NewTransport(transport)
 	c.HTTPClient.Timeout = readTimeout(readTimeoutSeconds)
 
-	client := &HttpClient{RetryableHTTP: c, Host: host}
+	client := &HttpClient{RetryableHTTP: c, Host: host, Clock: NewClock(hcc.clockSkewTolerance)}
+
+	if len(hcc.failoverURLs) > 0 {
+		if strings.HasPrefix(gitlabURL, unixSocketProtocol) {
+			return nil, errors.New("failover isn't supported with UNIX sockets")
+		}
+
+		client.Failover, err = newFailover(host, hcc.failoverURLs, transport, hcc.failoverOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		c.HTTPClient.Transport = client.Failover.RoundTripper(c.HTTPClient.Transport)
+	}
+
+	c.HTTPClient.Transport = newHedgingRoundTripper(c.HTTPClient.Transport, hcc.hedging)
 
 	return client, nil
 }
@@ -147,23 +218,44 @@ func buildSocketTransport(gitlabURL, gitlabRelativeURLRoot string) (*http.Transp
 }
 
 func buildHttpsTransport(hcc httpClientCfg, gitlabURL string) (*http.Transport, string, error) {
+	tlsConfig := NewTLSConfig(hcc.caFile, hcc.caPath, hcc.fips)
+
+	if hcc.HaveCertAndKey() {
+		cert, err := tls.LoadX509KeyPair(hcc.certPath, hcc.keyPath)
+		if err != nil {
+			return nil, "", err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+
+	return transport, gitlabURL, nil
+}
+
+// NewTLSConfig returns a TLS configuration trusting the system CAs along with
+// the certificates of caFile and of the files in caPath. With fips, only TLS
+// 1.2 is negotiated, with the FIPS approved cipher suites and curves.
+func NewTLSConfig(caFile, caPath string, fips bool) *tls.Config {
 	certPool, err := x509.SystemCertPool()
 	if err != nil {
 		certPool = x509.NewCertPool()
 	}
 
-	if hcc.caFile != "" {
-		addCertToPool(certPool, hcc.caFile)
+	if caFile != "" {
+		addCertToPool(certPool, caFile)
 	}
 
-	if hcc.caPath != "" {
-		fis, _ := os.ReadDir(hcc.caPath)
+	if caPath != "" {
+		fis, _ := os.ReadDir(caPath)
 		for _, fi := range fis {
 			if fi.IsDir() {
 				continue
 			}
 
-			addCertToPool(certPool, filepath.Join(hcc.caPath, fi.Name()))
+			addCertToPool(certPool, filepath.Join(caPath, fi.Name()))
 		}
 	}
 	tlsConfig := &tls.Config{
@@ -171,19 +263,13 @@ func buildHttpsTransport(hcc httpClientCfg, gitlabURL string) (*http.Transport,
 		MinVersion: tls.VersionTLS12,
 	}
 
-	if hcc.HaveCertAndKey() {
-		cert, err := tls.LoadX509KeyPair(hcc.certPath, hcc.keyPath)
-		if err != nil {
-			return nil, "", err
-		}
-		tlsConfig.Certificates = []tls.Certificate{cert}
-	}
-
-	transport := &http.Transport{
-		TLSClientConfig: tlsConfig,
+	if fips {
+		tlsConfig.MaxVersion = tls.VersionTLS12
+		tlsConfig.CipherSuites = fipsCipherSuites
+		tlsConfig.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
 	}
 
-	return transport, gitlabURL, err
+	return tlsConfig
 }
 
 func addCertToPool(certPool *x509.CertPool, fileName string) {
@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
@@ -139,3 +140,19 @@ func setupWithRequests(t *testing.T, caFile, caPath, clientCAPath, clientCertPat
 
 	return client, err
 }
+
+func TestFIPSTLSConfig(t *testing.T) {
+	transport, _, err := buildHttpsTransport(httpClientCfg{fips: true}, "https://localhost")
+	require.NoError(t, err)
+
+	tlsConfig := transport.TLSClientConfig
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MaxVersion)
+	require.Equal(t, fipsCipherSuites, tlsConfig.CipherSuites)
+	require.Equal(t, []tls.CurveID{tls.CurveP256, tls.CurveP384}, tlsConfig.CurvePreferences)
+
+	transport, _, err = buildHttpsTransport(httpClientCfg{}, "https://localhost")
+	require.NoError(t, err)
+	require.Zero(t, transport.TLSClientConfig.MaxVersion)
+	require.Nil(t, transport.TLSClientConfig.CipherSuites)
+}
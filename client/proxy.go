@@ -0,0 +1,158 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ParseProxyURL parses the URL of a proxy outbound connections are made
+// through, either an HTTP proxy (http:// or https://) or a SOCKS5 proxy
+// (socks5://)
+func ParseProxyURL(rawURL string) (*url.URL, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %q: %w", rawURL, err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL %q: unknown scheme %q", rawURL, proxyURL.Scheme)
+	}
+
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: no host", rawURL)
+	}
+
+	return proxyURL, nil
+}
+
+// WithProxy will configure the HttpClient to connect to the GitLab host
+// through the proxy at proxyURL rather than the proxy of the environment
+// (HTTPS_PROXY, HTTP_PROXY and NO_PROXY). It isn't used for UNIX sockets.
+func WithProxy(proxyURL *url.URL) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.proxyURL = proxyURL
+	}
+}
+
+// ProxyDialer connects to addresses through a proxy, tunneling with CONNECT
+// through HTTP proxies
+type ProxyDialer struct {
+	proxyURL *url.URL
+	dialer   *net.Dialer
+}
+
+func NewProxyDialer(proxyURL *url.URL) *ProxyDialer {
+	return &ProxyDialer{
+		proxyURL: proxyURL,
+		dialer:   &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+	}
+}
+
+// DialContext connects to addr through the proxy
+func (d *ProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.proxyURL.Scheme == "socks5" {
+		socks, err := proxy.FromURL(d.proxyURL, d.dialer)
+		if err != nil {
+			return nil, err
+		}
+
+		return socks.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	}
+
+	conn, err := d.dialer.DialContext(ctx, "tcp", proxyAddress(d.proxyURL))
+	if err != nil {
+		return nil, err
+	}
+
+	if d.proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		conn = tlsConn
+	}
+
+	tunnel, err := d.connect(ctx, conn, addr)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", d.proxyURL.Redacted(), err)
+	}
+
+	return tunnel, nil
+}
+
+func (d *ProxyDialer) connect(ctx context.Context, conn net.Conn, addr string) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := d.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := request.Write(conn); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT to %s failed: %s", addr, response.Status)
+	}
+
+	// The server may have spoken first, right behind the response
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+
+	return conn, nil
+}
+
+func proxyAddress(proxyURL *url.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+
+	port := "80"
+	if proxyURL.Scheme == "https" {
+		port = "443"
+	}
+
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,300 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// IPPreference is the address family connected to first
+type IPPreference string
+
+const (
+	// IPPreferenceNone keeps the order of the addresses from the DNS
+	IPPreferenceNone IPPreference = ""
+	IPPreferenceIPv4 IPPreference = "ipv4"
+	IPPreferenceIPv6 IPPreference = "ipv6"
+)
+
+// ResolverOpts configures a Resolver
+type ResolverOpts struct {
+	// TTL is how long the addresses are cached for
+	TTL time.Duration
+	// SRV looks up the host as the name of an SRV record listing the
+	// endpoints, e.g. _workhorse._tcp.gitlab.svc.cluster.local
+	SRV bool
+	// IPPreference is the address family connected to first
+	IPPreference IPPreference
+	// FallbackDelay is how long the other address family is waited on before
+	// it is connected to as well (Happy Eyeballs, RFC 8305). Defaults to
+	// 300ms, a negative delay only connects to it once the preferred
+	// addresses failed.
+	FallbackDelay time.Duration
+}
+
+// Resolver resolves the host of the internal API for the HTTP transport,
+// caching the addresses for a TTL. Addresses are resolved again when none of
+// them can be connected to, and kept when the DNS can't be reached, so that
+// DNS hiccups don't fail the requests.
+type Resolver struct {
+	opts ResolverOpts
+
+	dial       func(ctx context.Context, network, addr string) (net.Conn, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+	lookupSRV  func(ctx context.Context, name string) ([]*net.SRV, error)
+	now        func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]*resolvedEndpoints
+}
+
+type resolvedEndpoints struct {
+	endpoints []string
+	expires   time.Time
+}
+
+func NewResolver(opts ResolverOpts) *Resolver {
+	if opts.FallbackDelay == 0 {
+		opts.FallbackDelay = defaultFallbackDelay
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	return &Resolver{
+		opts:       opts,
+		dial:       dialer.DialContext,
+		lookupHost: net.DefaultResolver.LookupHost,
+		lookupSRV: func(ctx context.Context, name string) ([]*net.SRV, error) {
+			_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+			return srvs, err
+		},
+		now:   time.Now,
+		cache: make(map[string]*resolvedEndpoints),
+	}
+}
+
+// DialContext connects to one of the endpoints of addr
+func (r *Resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if net.ParseIP(host) != nil {
+		return r.dial(ctx, network, addr)
+	}
+
+	endpoints, fresh, err := r.endpoints(ctx, addr, false)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := r.dialAny(ctx, network, endpoints)
+	if err == nil || fresh || ctx.Err() != nil {
+		return conn, err
+	}
+
+	log.WithContextFields(ctx, log.Fields{"address": addr}).WithError(err).Warn("resolver: cached endpoints are unreachable, resolving again")
+
+	endpoints, _, err = r.endpoints(ctx, addr, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.dialAny(ctx, network, endpoints)
+}
+
+// dialAny connects to the endpoints of the preferred address family in turn,
+// racing the other family once the fallback delay passed
+func (r *Resolver) dialAny(ctx context.Context, network string, endpoints []string) (net.Conn, error) {
+	primaries, fallbacks := r.partition(endpoints)
+	if len(fallbacks) == 0 {
+		return r.dialSerial(ctx, network, primaries)
+	}
+	if r.opts.FallbackDelay < 0 {
+		return r.dialSerial(ctx, network, append(primaries, fallbacks...))
+	}
+
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult)
+	returned := make(chan struct{})
+	defer close(returned)
+
+	dial := func(endpoints []string, primary bool) {
+		conn, err := r.dialSerial(ctx, network, endpoints)
+		select {
+		case results <- dialResult{conn: conn, err: err, primary: primary}:
+		case <-returned:
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+
+	go dial(primaries, true)
+
+	fallbackTimer := time.NewTimer(r.opts.FallbackDelay)
+	defer fallbackTimer.Stop()
+
+	var primaryErr, fallbackErr error
+	for {
+		select {
+		case <-fallbackTimer.C:
+			go dial(fallbacks, false)
+		case res := <-results:
+			if res.err == nil {
+				return res.conn, nil
+			}
+
+			if res.primary {
+				primaryErr = res.err
+				// Don't wait any longer for the fallbacks
+				if fallbackTimer.Stop() {
+					go dial(fallbacks, false)
+				}
+			} else {
+				fallbackErr = res.err
+			}
+
+			if primaryErr != nil && fallbackErr != nil {
+				return nil, primaryErr
+			}
+		}
+	}
+}
+
+func (r *Resolver) dialSerial(ctx context.Context, network string, endpoints []string) (net.Conn, error) {
+	var err error
+	for _, endpoint := range endpoints {
+		var conn net.Conn
+		if conn, err = r.dial(ctx, network, endpoint); err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// partition splits the endpoints of the preferred address family from the
+// others, which is the family of the first endpoint without a preference
+func (r *Resolver) partition(endpoints []string) ([]string, []string) {
+	var primaries, fallbacks []string
+
+	preferIPv4 := r.opts.IPPreference == IPPreferenceIPv4
+	if r.opts.IPPreference == IPPreferenceNone && len(endpoints) > 0 {
+		preferIPv4 = isIPv4(endpoints[0])
+	}
+
+	for _, endpoint := range endpoints {
+		if isIPv4(endpoint) == preferIPv4 {
+			primaries = append(primaries, endpoint)
+		} else {
+			fallbacks = append(fallbacks, endpoint)
+		}
+	}
+
+	if len(primaries) == 0 {
+		return fallbacks, nil
+	}
+
+	return primaries, fallbacks
+}
+
+func isIPv4(endpoint string) bool {
+	host, _, _ := net.SplitHostPort(endpoint)
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.To4() != nil
+}
+
+// endpoints returns the endpoints of addr and whether they were just
+// resolved. The cached endpoints are returned when resolving fails.
+func (r *Resolver) endpoints(ctx context.Context, addr string, force bool) ([]string, bool, error) {
+	r.mu.Lock()
+	cached := r.cache[addr]
+	r.mu.Unlock()
+
+	if cached != nil && !force && r.now().Before(cached.expires) {
+		return cached.endpoints, false, nil
+	}
+
+	endpoints, err := r.resolve(ctx, addr)
+	if err != nil {
+		if cached != nil {
+			log.WithContextFields(ctx, log.Fields{"address": addr}).WithError(err).Warn("resolver: failed to resolve, using the cached endpoints")
+			return cached.endpoints, false, nil
+		}
+
+		return nil, false, err
+	}
+
+	r.mu.Lock()
+	r.cache[addr] = &resolvedEndpoints{endpoints: endpoints, expires: r.now().Add(r.opts.TTL)}
+	r.mu.Unlock()
+
+	return endpoints, true, nil
+}
+
+func (r *Resolver) resolve(ctx context.Context, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !r.opts.SRV {
+		return r.resolveHost(ctx, host, port)
+	}
+
+	srvs, err := r.lookupSRV(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// The records are sorted by priority and weight already
+	var endpoints []string
+	for _, srv := range srvs {
+		resolved, err := r.resolveHost(ctx, strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		if err != nil {
+			log.WithContextFields(ctx, log.Fields{"address": addr, "target": srv.Target}).WithError(err).Warn("resolver: failed to resolve SRV target")
+			continue
+		}
+
+		endpoints = append(endpoints, resolved...)
+	}
+
+	if len(endpoints) == 0 {
+		return nil, errors.New("no SRV target of " + host + " could be resolved")
+	}
+
+	return endpoints, nil
+}
+
+func (r *Resolver) resolveHost(ctx context.Context, host, port string) ([]string, error) {
+	addrs, err := r.lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		endpoints = append(endpoints, net.JoinHostPort(addr, port))
+	}
+
+	return endpoints, nil
+}
@@ -0,0 +1,239 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDNS struct {
+	hosts   map[string][]string
+	srvs    map[string][]*net.SRV
+	lookups int
+	err     error
+}
+
+func (d *fakeDNS) resolver(opts ResolverOpts) *Resolver {
+	r := NewResolver(opts)
+	r.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		d.lookups++
+		if d.err != nil {
+			return nil, d.err
+		}
+		if addrs, ok := d.hosts[host]; ok {
+			return addrs, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+	r.lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		d.lookups++
+		if d.err != nil {
+			return nil, d.err
+		}
+		return d.srvs[name], nil
+	}
+
+	return r
+}
+
+func listen(t *testing.T) (string, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+
+	return host, port
+}
+
+func TestResolverCachesAddresses(t *testing.T) {
+	host, port := listen(t)
+	dns := &fakeDNS{hosts: map[string][]string{"gitlab.example.com": {host}}}
+	r := dns.resolver(ResolverOpts{TTL: time.Minute})
+
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		conn, err := r.DialContext(context.Background(), "tcp", "gitlab.example.com:"+port)
+		require.NoError(t, err)
+		conn.Close()
+	}
+	require.Equal(t, 1, dns.lookups)
+
+	now = now.Add(2 * time.Minute)
+	conn, err := r.DialContext(context.Background(), "tcp", "gitlab.example.com:"+port)
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, 2, dns.lookups)
+
+	// The cached addresses are used when the DNS can't be reached
+	now = now.Add(2 * time.Minute)
+	dns.err = errors.New("i/o timeout")
+	conn, err = r.DialContext(context.Background(), "tcp", "gitlab.example.com:"+port)
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, 3, dns.lookups)
+}
+
+func TestResolverResolvesAgainWhenUnreachable(t *testing.T) {
+	host, port := listen(t)
+
+	closed, err := net.Listen("tcp", "127.0.0.2:"+port)
+	if err != nil {
+		t.Skip("127.0.0.2 is not available")
+	}
+	closed.Close()
+
+	dns := &fakeDNS{hosts: map[string][]string{"gitlab.example.com": {"127.0.0.2"}}}
+	r := dns.resolver(ResolverOpts{TTL: time.Minute})
+
+	_, err = r.DialContext(context.Background(), "tcp", "gitlab.example.com:"+port)
+	require.Error(t, err)
+	require.Equal(t, 1, dns.lookups)
+
+	dns.hosts["gitlab.example.com"] = []string{host}
+
+	conn, err := r.DialContext(context.Background(), "tcp", "gitlab.example.com:"+port)
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, 2, dns.lookups)
+}
+
+func TestResolverSRV(t *testing.T) {
+	host, port := listen(t)
+	srvPort, err := net.LookupPort("tcp", port)
+	require.NoError(t, err)
+
+	dns := &fakeDNS{
+		hosts: map[string][]string{"workhorse-1.gitlab.svc": {host}},
+		srvs: map[string][]*net.SRV{
+			"_workhorse._tcp.gitlab.svc": {
+				{Target: "unknown.gitlab.svc.", Port: uint16(srvPort)},
+				{Target: "workhorse-1.gitlab.svc.", Port: uint16(srvPort)},
+			},
+		},
+	}
+	r := dns.resolver(ResolverOpts{SRV: true})
+
+	conn, err := r.DialContext(context.Background(), "tcp", "_workhorse._tcp.gitlab.svc:80")
+	require.NoError(t, err)
+	conn.Close()
+
+	_, err = r.DialContext(context.Background(), "tcp", "_unknown._tcp.gitlab.svc:80")
+	require.EqualError(t, err, "no SRV target of _unknown._tcp.gitlab.svc could be resolved")
+}
+
+func TestHTTPClientWithResolver(t *testing.T) {
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+
+	dns := &fakeDNS{hosts: map[string][]string{"gitlab.example.com": {"127.0.0.1"}}}
+	client, err := NewHTTPClientWithOpts("http://gitlab.example.com:"+port, "", "", "", 0, []HTTPClientOpt{WithResolver(dns.resolver(ResolverOpts{TTL: time.Minute}))})
+	require.NoError(t, err)
+
+	response, err := client.RetryableHTTP.Get(client.Host + "/api/v4/internal/check")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, 1, dns.lookups)
+}
+
+func TestResolverPartition(t *testing.T) {
+	endpoints := []string{"[2001:db8::1]:80", "192.0.2.1:80", "[2001:db8::2]:80", "192.0.2.2:80"}
+
+	testCases := []struct {
+		preference IPPreference
+		primaries  []string
+		fallbacks  []string
+	}{
+		{IPPreferenceNone, []string{"[2001:db8::1]:80", "[2001:db8::2]:80"}, []string{"192.0.2.1:80", "192.0.2.2:80"}},
+		{IPPreferenceIPv4, []string{"192.0.2.1:80", "192.0.2.2:80"}, []string{"[2001:db8::1]:80", "[2001:db8::2]:80"}},
+		{IPPreferenceIPv6, []string{"[2001:db8::1]:80", "[2001:db8::2]:80"}, []string{"192.0.2.1:80", "192.0.2.2:80"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.preference), func(t *testing.T) {
+			r := NewResolver(ResolverOpts{IPPreference: tc.preference})
+
+			primaries, fallbacks := r.partition(endpoints)
+			require.Equal(t, tc.primaries, primaries)
+			require.Equal(t, tc.fallbacks, fallbacks)
+		})
+	}
+
+	primaries, fallbacks := NewResolver(ResolverOpts{IPPreference: IPPreferenceIPv6}).partition([]string{"192.0.2.1:80"})
+	require.Equal(t, []string{"192.0.2.1:80"}, primaries)
+	require.Empty(t, fallbacks)
+}
+
+func TestResolverHappyEyeballs(t *testing.T) {
+	dns := &fakeDNS{hosts: map[string][]string{"gitlab.example.com": {"2001:db8::1", "192.0.2.1"}}}
+
+	// IPv6 is blackholed: connecting hangs until the dial is abandoned
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if !isIPv4(addr) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+
+	r := dns.resolver(ResolverOpts{FallbackDelay: 10 * time.Millisecond})
+	r.dial = dial
+
+	started := time.Now()
+	conn, err := r.DialContext(context.Background(), "tcp", "gitlab.example.com:80")
+	require.NoError(t, err)
+	conn.Close()
+	require.Less(t, time.Since(started), 5*time.Second)
+
+	// Without a fallback, the IPv4 address is only tried once IPv6 failed
+	r = dns.resolver(ResolverOpts{FallbackDelay: -1})
+	r.dial = dial
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = r.DialContext(ctx, "tcp", "gitlab.example.com:80")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Preferring IPv4 doesn't wait on IPv6 at all
+	r = dns.resolver(ResolverOpts{IPPreference: IPPreferenceIPv4, FallbackDelay: time.Hour})
+	r.dial = dial
+
+	conn, err = r.DialContext(context.Background(), "tcp", "gitlab.example.com:80")
+	require.NoError(t, err)
+	conn.Close()
+}
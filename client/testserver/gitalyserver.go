@@ -15,6 +15,8 @@ import (
 	"gitlab.com/gitlab-org/labkit/log"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -126,6 +128,7 @@ func doStartTestServer(t *testing.T, network string, path string) (string, *Test
 
 	testServer := TestGitalyServer{}
 	pb.RegisterSSHServiceServer(server, &testServer)
+	healthpb.RegisterHealthServer(server, health.NewServer())
 
 	go func() {
 		require.NoError(t, server.Serve(listener))
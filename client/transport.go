@@ -7,6 +7,8 @@ import (
 	"gitlab.com/gitlab-org/labkit/correlation"
 	"gitlab.com/gitlab-org/labkit/log"
 	"gitlab.com/gitlab-org/labkit/tracing"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logcontext"
 )
 
 type transport struct {
@@ -32,7 +34,7 @@ func (rt *transport) RoundTrip(request *http.Request) (*http.Response, error) {
 		"url":         request.URL.String(),
 		"duration_ms": time.Since(start) / time.Millisecond,
 	}
-	logger := log.WithContextFields(ctx, fields)
+	logger := logcontext.WithContextFields(ctx, fields)
 
 	if err != nil {
 		logger.WithError(err).Error("Internal API unreachable")
@@ -0,0 +1,125 @@
+package command
+
+import (
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/discover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/help"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/lfsauthenticate"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/lfstransfer"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/personalaccesstoken"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/projects"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/receivepack"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorrecover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorverify"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/uploadarchive"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/uploadpack"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/whoami"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// builtins are the built-in commands. The ones with a description are listed
+// by help, in this order.
+var builtins = []command.Registration{
+	{
+		Name: commandargs.Discover,
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &discover.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+	{
+		Name:        commandargs.Whoami,
+		Usage:       "whoami",
+		Description: "Show the user and SSH key you are authenticated with",
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &whoami.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+	{
+		Name:        commandargs.Projects,
+		Usage:       "projects [--page <page>] [--per-page <count>]",
+		Description: "List the projects you are a member of",
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &projects.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+	{
+		Name:        commandargs.PersonalAccessToken,
+		Usage:       "personal_access_token <name> <scope1[,scope2,...]> [ttl_days] | --list | --revoke <token_id>",
+		Description: "Create, list or revoke personal access tokens",
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &personalaccesstoken.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+	{
+		Name:        commandargs.TwoFactorRecover,
+		Usage:       "2fa_recovery_codes",
+		Description: "Generate new two-factor authentication recovery codes",
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &twofactorrecover.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+	{
+		Name:        commandargs.TwoFactorVerify,
+		Usage:       "2fa_verify",
+		Description: "Verify a two-factor authentication one-time password",
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &twofactorverify.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+	{
+		Name:        commandargs.Help,
+		Usage:       "help",
+		Description: "List the available commands",
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &help.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+	{
+		Name:   commandargs.LfsAuthenticate,
+		Writes: isLfsUpload,
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &lfsauthenticate.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+	{
+		Name:   commandargs.LfsTransfer,
+		Writes: isLfsUpload,
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &lfstransfer.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+	{
+		Name:   commandargs.ReceivePack,
+		Writes: func(*commandargs.Shell) bool { return true },
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &receivepack.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+	{
+		Name: commandargs.UploadPack,
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &uploadpack.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+	{
+		Name: commandargs.UploadArchive,
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &uploadarchive.Command{Config: config, Args: args, ReadWriter: readWriter}
+		},
+	},
+}
+
+func init() {
+	for _, registration := range builtins {
+		// The failures are reported at startup, by command.RegistrationErr
+		_ = command.Register(registration)
+	}
+}
+
+// isLfsUpload returns whether the LFS objects of a repository are uploaded:
+// git-lfs-authenticate|git-lfs-transfer <repo> upload
+func isLfsUpload(args *commandargs.Shell) bool {
+	return len(args.SshArgs) > 2 && args.SshArgs[2] == "upload"
+}
@@ -1,18 +1,13 @@
 package command
 
 import (
+	"fmt"
+
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
-	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/discover"
-	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/lfsauthenticate"
-	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/personalaccesstoken"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
-	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/receivepack"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disabledcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
-	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorrecover"
-	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorverify"
-	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/uploadarchive"
-	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/uploadpack"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
 )
@@ -23,11 +18,7 @@ func New(arguments []string, env sshenv.Env, config *config.Config, readWriter *
 		return nil, err
 	}
 
-	if cmd := Build(args, config, readWriter); cmd != nil {
-		return cmd, nil
-	}
-
-	return nil, disallowedcommand.Error
+	return build(args, config, readWriter)
 }
 
 func NewWithKey(gitlabKeyId string, env sshenv.Env, config *config.Config, readWriter *readwriter.ReadWriter) (command.Command, error) {
@@ -37,11 +28,7 @@ func NewWithKey(gitlabKeyId string, env sshenv.Env, config *config.Config, readW
 	}
 
 	args.GitlabKeyId = gitlabKeyId
-	if cmd := Build(args, config, readWriter); cmd != nil {
-		return cmd, nil
-	}
-
-	return nil, disallowedcommand.Error
+	return build(args, config, readWriter)
 }
 
 func NewWithKrb5Principal(gitlabKrb5Principal string, env sshenv.Env, config *config.Config, readWriter *readwriter.ReadWriter) (command.Command, error) {
@@ -51,11 +38,7 @@ func NewWithKrb5Principal(gitlabKrb5Principal string, env sshenv.Env, config *co
 	}
 
 	args.GitlabKrb5Principal = gitlabKrb5Principal
-	if cmd := Build(args, config, readWriter); cmd != nil {
-		return cmd, nil
-	}
-
-	return nil, disallowedcommand.Error
+	return build(args, config, readWriter)
 }
 
 func NewWithUsername(gitlabUsername string, env sshenv.Env, config *config.Config, readWriter *readwriter.ReadWriter) (command.Command, error) {
@@ -81,11 +64,13 @@ func NewWithUsername(gitlabUsername string, env sshenv.Env, config *config.Confi
 	}
 
 	args.GitlabUsername = gitlabUsername
-	if cmd := Build(args, config, readWriter); cmd != nil {
-		return cmd, nil
-	}
+	return build(args, config, readWriter)
+}
 
-	return nil, disallowedcommand.Error
+// NewFromArgs returns the command of args already parsed, e.g. chosen in a
+// menu rather than sent as the SSH command
+func NewFromArgs(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) (command.Command, error) {
+	return build(args, config, readWriter)
 }
 
 func Parse(arguments []string, env sshenv.Env) (*commandargs.Shell, error) {
@@ -98,25 +83,44 @@ func Parse(arguments []string, env sshenv.Env) (*commandargs.Shell, error) {
 	return args, nil
 }
 
+// build returns the command to run, unless it is unknown, disabled by the
+// policy of the instance or writes to a read-only server
+func build(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) (command.Command, error) {
+	registration, ok := command.Lookup(args.CommandType)
+	if !ok {
+		return nil, disallowedcommand.Error
+	}
+
+	if config != nil && config.Commands.IsDisabledFor(string(args.CommandType), string(args.ActorType)) {
+		return nil, disabledcommand.New(args.CommandType, config.Commands.DisabledMessage)
+	}
+
+	if config != nil && config.ReadOnly && registration.IsWrite(args) {
+		return nil, disabledcommand.New(args.CommandType, readOnlyMessage(config.WritableEndpoint))
+	}
+
+	cmd := registration.New(args, config, readWriter)
+	if cmd == nil {
+		return nil, disallowedcommand.Error
+	}
+
+	return cmd, nil
+}
+
+func readOnlyMessage(writableEndpoint string) string {
+	if writableEndpoint == "" {
+		return "This GitLab server is a read-only replica, pushes are not allowed."
+	}
+
+	return fmt.Sprintf("This GitLab server is a read-only replica, push to %s instead.", writableEndpoint)
+}
+
+// Build returns the registered command to run, nil when it's unknown
 func Build(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
-	switch args.CommandType {
-	case commandargs.Discover:
-		return &discover.Command{Config: config, Args: args, ReadWriter: readWriter}
-	case commandargs.TwoFactorRecover:
-		return &twofactorrecover.Command{Config: config, Args: args, ReadWriter: readWriter}
-	case commandargs.TwoFactorVerify:
-		return &twofactorverify.Command{Config: config, Args: args, ReadWriter: readWriter}
-	case commandargs.LfsAuthenticate:
-		return &lfsauthenticate.Command{Config: config, Args: args, ReadWriter: readWriter}
-	case commandargs.ReceivePack:
-		return &receivepack.Command{Config: config, Args: args, ReadWriter: readWriter}
-	case commandargs.UploadPack:
-		return &uploadpack.Command{Config: config, Args: args, ReadWriter: readWriter}
-	case commandargs.UploadArchive:
-		return &uploadarchive.Command{Config: config, Args: args, ReadWriter: readWriter}
-	case commandargs.PersonalAccessToken:
-		return &personalaccesstoken.Command{Config: config, Args: args, ReadWriter: readWriter}
+	registration, ok := command.Lookup(args.CommandType)
+	if !ok {
+		return nil
 	}
 
-	return nil
+	return registration.New(args, config, readWriter)
 }
@@ -1,22 +1,33 @@
 package command_test
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	cmd "gitlab.com/gitlab-org/gitlab-shell/v14/cmd/gitlab-shell/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/discover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/help"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/lfsauthenticate"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/lfstransfer"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/personalaccesstoken"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/projects"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/receivepack"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disabledcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorrecover"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/twofactorverify"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/uploadarchive"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/uploadpack"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/whoami"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/executable"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
 )
@@ -63,6 +74,13 @@ func TestNew(t *testing.T) {
 			config:       basicConfig,
 			expectedType: &lfsauthenticate.Command{},
 		},
+		{
+			desc:         "it returns an LfsTransfer command",
+			executable:   gitlabShellExec,
+			env:          buildEnv("git-lfs-transfer"),
+			config:       basicConfig,
+			expectedType: &lfstransfer.Command{},
+		},
 		{
 			desc:         "it returns a ReceivePack command",
 			executable:   gitlabShellExec,
@@ -91,6 +109,27 @@ func TestNew(t *testing.T) {
 			config:       basicConfig,
 			expectedType: &personalaccesstoken.Command{},
 		},
+		{
+			desc:         "it returns a Projects command",
+			executable:   gitlabShellExec,
+			env:          buildEnv("projects"),
+			config:       basicConfig,
+			expectedType: &projects.Command{},
+		},
+		{
+			desc:         "it returns a Whoami command",
+			executable:   gitlabShellExec,
+			env:          buildEnv("whoami"),
+			config:       basicConfig,
+			expectedType: &whoami.Command{},
+		},
+		{
+			desc:         "it returns a Help command",
+			executable:   gitlabShellExec,
+			env:          buildEnv("help"),
+			config:       basicConfig,
+			expectedType: &help.Command{},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -132,6 +171,119 @@ func TestFailingNew(t *testing.T) {
 	}
 }
 
+func TestNewWithDisabledCommand(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		commands        config.CommandsConfig
+		command         string
+		expectedMessage string
+	}{
+		{
+			desc:            "disabled command",
+			commands:        config.CommandsConfig{Disabled: []string{"personal_access_token"}},
+			command:         "personal_access_token",
+			expectedMessage: "This command has been disabled by your GitLab administrator.",
+		},
+		{
+			desc:            "command missing from the allowed ones",
+			commands:        config.CommandsConfig{Allowed: []string{"discover"}, DisabledMessage: "Only discover is allowed."},
+			command:         "2fa_recovery_codes",
+			expectedMessage: "Only discover is allowed.",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := &config.Config{GitlabUrl: "http+unix://gitlab.socket", Commands: tc.commands}
+
+			command, err := cmd.NewWithKey("1", buildEnv(tc.command), cfg, nil)
+			require.Nil(t, command)
+
+			var disabledErr *disabledcommand.Error
+			require.ErrorAs(t, err, &disabledErr)
+			require.Equal(t, commandargs.CommandType(tc.command), disabledErr.Command)
+			require.EqualError(t, err, tc.expectedMessage)
+			require.Equal(t, errorcode.AccessDenied, errorcode.Classify(err))
+		})
+	}
+
+	// The commands disabled for deploy keys only
+	cfg := &config.Config{
+		GitlabUrl: "http+unix://gitlab.socket",
+		Commands:  config.CommandsConfig{DisabledForActors: map[string][]string{"deploy_key": {"whoami"}}},
+	}
+	args, err := cmd.Parse(nil, buildEnv("whoami"))
+	require.NoError(t, err)
+
+	args.ActorType = commandargs.ActorUser
+	_, err = cmd.NewFromArgs(args, cfg, nil)
+	require.NoError(t, err)
+
+	args.ActorType = commandargs.ActorDeployKey
+	_, err = cmd.NewFromArgs(args, cfg, nil)
+	require.EqualError(t, err, "This command has been disabled by your GitLab administrator.")
+
+	// Unknown commands are reported as such whatever the policy
+	cfg = &config.Config{Commands: config.CommandsConfig{Allowed: []string{"discover"}}}
+	_, err = cmd.New([]string{}, buildEnv("unknown"), cfg, nil)
+	require.Equal(t, disallowedcommand.Error, err)
+}
+
+func TestNewWithReadOnly(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		command          string
+		writableEndpoint string
+		expectedMessage  string
+	}{
+		{
+			desc:             "push",
+			command:          "git-receive-pack group/repo",
+			writableEndpoint: "git@gitlab.example.com",
+			expectedMessage:  "This GitLab server is a read-only replica, push to git@gitlab.example.com instead.",
+		},
+		{
+			desc:            "LFS upload",
+			command:         "git-lfs-authenticate group/repo upload",
+			expectedMessage: "This GitLab server is a read-only replica, pushes are not allowed.",
+		},
+		{
+			desc:            "LFS transfer upload",
+			command:         "git-lfs-transfer group/repo upload",
+			expectedMessage: "This GitLab server is a read-only replica, pushes are not allowed.",
+		},
+		{
+			desc:    "fetch",
+			command: "git-upload-pack group/repo",
+		},
+		{
+			desc:    "LFS download",
+			command: "git-lfs-authenticate group/repo download",
+		},
+		{
+			desc:    "other command",
+			command: "2fa_recovery_codes",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := &config.Config{GitlabUrl: "http+unix://gitlab.socket", ReadOnly: true, WritableEndpoint: tc.writableEndpoint}
+
+			command, err := cmd.NewWithKey("1", buildEnv(tc.command), cfg, nil)
+			if tc.expectedMessage == "" {
+				require.NoError(t, err)
+				require.NotNil(t, command)
+				return
+			}
+
+			require.Nil(t, command)
+			require.EqualError(t, err, tc.expectedMessage)
+			require.Equal(t, errorcode.AccessDenied, errorcode.Classify(err))
+		})
+	}
+}
+
 func buildEnv(command string) sshenv.Env {
 	return sshenv.Env{
 		IsSSHConnection: true,
@@ -290,6 +442,13 @@ func TestParseFailure(t *testing.T) {
 			arguments:     []string{},
 			expectedError: "Invalid SSH command: invalid command line string",
 		},
+		{
+			desc:          "It fails if SSH command has several lines",
+			executable:    &executable.Executable{Name: executable.GitlabShell},
+			env:           sshenv.Env{IsSSHConnection: true, OriginalCommand: "git-receive-pack group/repo\nany command"},
+			arguments:     []string{},
+			expectedError: "Invalid SSH command: newlines aren't allowed in commands",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -361,6 +520,23 @@ func TestNewWithUsername(t *testing.T) {
 					},
 				},
 			},
+		}, {
+			desc:        "git-upload-archive command with namespace",
+			command:     "git-upload-archive 'group/repo'",
+			namespace:   "group",
+			expectedErr: nil,
+			expectedType: &uploadarchive.Command{
+				Args: &commandargs.Shell{
+					CommandType:    commandargs.UploadArchive,
+					GitlabUsername: "username",
+					SshArgs:        []string{"git-upload-archive", "group/repo"},
+					Env: sshenv.Env{
+						IsSSHConnection: true,
+						OriginalCommand: "git-upload-archive 'group/repo'",
+						NamespacePath:   "group",
+					},
+				},
+			},
 		}, {
 			desc:         "non-git command with namespace",
 			command:      "2fa_recovery_codes",
@@ -379,3 +555,50 @@ func TestNewWithUsername(t *testing.T) {
 		})
 	}
 }
+
+func TestHelpListsBuiltinCommands(t *testing.T) {
+	require.NoError(t, command.RegistrationErr())
+
+	output := &bytes.Buffer{}
+	helpCmd, err := cmd.NewWithKey("1", buildEnv("help"), basicConfig, &readwriter.ReadWriter{Out: output})
+	require.NoError(t, err)
+
+	_, err = helpCmd.Execute(context.Background())
+	require.NoError(t, err)
+
+	// The built-in commands with a description, then the ones added by
+	// other packages, like hello in example_command_test.go
+	var listed []string
+	for _, line := range strings.Split(output.String(), "\n") {
+		if strings.HasPrefix(line, "  ") {
+			listed = append(listed, strings.Fields(line)[0])
+		}
+	}
+	require.Equal(t, []string{"whoami", "projects", "personal_access_token", "2fa_recovery_codes", "2fa_verify", "help", "hello"}, listed)
+	require.Contains(t, output.String(), "List the available commands\n")
+}
+
+func TestWriteCommandsAreCheckedAsPushes(t *testing.T) {
+	// The maintenance mode denies the access checks of git-receive-pack,
+	// which must match the commands classified as writes
+	for _, tc := range []struct {
+		command string
+		writes  bool
+	}{
+		{command: "git-receive-pack group/repo", writes: true},
+		{command: "git-lfs-authenticate group/repo upload", writes: true},
+		{command: "git-lfs-transfer group/repo upload", writes: true},
+		{command: "git-upload-pack group/repo"},
+		{command: "git-lfs-authenticate group/repo download"},
+		{command: "git-lfs-transfer group/repo download"},
+	} {
+		t.Run(tc.command, func(t *testing.T) {
+			args, err := cmd.Parse(nil, buildEnv(tc.command))
+			require.NoError(t, err)
+
+			registration, ok := command.Lookup(args.CommandType)
+			require.True(t, ok)
+			require.Equal(t, tc.writes, registration.IsWrite(args))
+		})
+	}
+}
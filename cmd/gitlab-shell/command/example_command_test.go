@@ -0,0 +1,61 @@
+package command_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	cmd "gitlab.com/gitlab-org/gitlab-shell/v14/cmd/gitlab-shell/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// helloCommand is an example of a command added by a downstream build
+// without changing gitlab-shell: it's registered from the init function of
+// its own package, which the main package of the build imports.
+type helloCommand struct {
+	args       *commandargs.Shell
+	readWriter *readwriter.ReadWriter
+}
+
+func init() {
+	// A failure, e.g. the name of a built-in command, is reported by
+	// gitlab-shell and gitlab-sshd at startup
+	_ = command.Register(command.Registration{
+		Name:        "hello",
+		Usage:       "hello",
+		Description: "Say hello",
+		Build: func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) command.Command {
+			return &helloCommand{args: args, readWriter: readWriter}
+		},
+	})
+}
+
+func (c *helloCommand) Execute(ctx context.Context) (context.Context, error) {
+	fmt.Fprintf(c.readWriter.Out, "Hello, key-%s!\n", c.args.GitlabKeyId)
+
+	return ctx, nil
+}
+
+func TestOutOfTreeCommand(t *testing.T) {
+	output := &bytes.Buffer{}
+	readWriter := &readwriter.ReadWriter{Out: output}
+
+	hello, err := cmd.NewWithKey("1", buildEnv("hello"), basicConfig, readWriter)
+	require.NoError(t, err)
+	require.IsType(t, &helloCommand{}, hello)
+
+	_, err = hello.Execute(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "Hello, key-1!\n", output.String())
+
+	// Like the built-in commands, it's subject to the policy of the instance
+	cfg := &config.Config{GitlabUrl: "http+unix://gitlab.socket", Commands: config.CommandsConfig{Disabled: []string{"hello"}}}
+	_, err = cmd.NewWithKey("1", buildEnv("hello"), cfg, readWriter)
+	require.EqualError(t, err, "This command has been disabled by your GitLab administrator.")
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
@@ -8,16 +10,28 @@ import (
 	grpccodes "google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
 
+	"gitlab.com/gitlab-org/labkit/correlation"
 	"gitlab.com/gitlab-org/labkit/fips"
 	"gitlab.com/gitlab-org/labkit/log"
 
 	shellCmd "gitlab.com/gitlab-org/gitlab-shell/v14/cmd/gitlab-shell/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bandwidth"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/diagnose"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/help"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/internalapi"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/executable"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sessionhook"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sessionrecord"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
 )
 
@@ -31,12 +45,20 @@ var (
 func main() {
 	command.CheckForVersionFlag(os.Args, Version, BuildTime)
 
+	countingWriter := &readwriter.CountingWriter{W: os.Stdout}
+	countingReader := &readwriter.CountingReader{R: os.Stdin}
+
 	readWriter := &readwriter.ReadWriter{
-		Out:    &readwriter.CountingWriter{W: os.Stdout},
-		In:     os.Stdin,
+		Out:    countingWriter,
+		In:     countingReader,
 		ErrOut: os.Stderr,
 	}
 
+	if err := command.RegistrationErr(); err != nil {
+		fmt.Fprintf(readWriter.ErrOut, "Failed to register the SSH commands: %v\n", err)
+		os.Exit(1)
+	}
+
 	executable, err := executable.New(executable.GitlabShell)
 	if err != nil {
 		fmt.Fprintln(readWriter.ErrOut, "Failed to determine executable, exiting")
@@ -49,35 +71,119 @@ func main() {
 		os.Exit(1)
 	}
 
+	command.CheckForPrintEffectiveConfigFlag(os.Args, config)
+
 	logCloser := logger.Configure(config)
 	defer logCloser.Close()
 
+	// `gitlab-shell api` and `gitlab-shell diagnose` are run by operators
+	// rather than by the SSH server
+	if len(os.Args) > 1 && os.Args[1] == internalapi.Subcommand {
+		ctx, finished := command.Setup(executable.Name, config)
+		defer finished()
+
+		cmd := &internalapi.Command{Config: config, Args: os.Args[2:], ReadWriter: readWriter}
+		if _, err := cmd.Execute(ctx); err != nil {
+			fmt.Fprintf(readWriter.ErrOut, "%v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == diagnose.Subcommand {
+		ctx, finished := command.Setup(executable.Name, config)
+		defer finished()
+
+		cmd := &diagnose.Command{Config: config, Args: os.Args[2:], ReadWriter: readWriter, Version: Version, BuildTime: BuildTime}
+		if _, err := cmd.Execute(ctx); err != nil {
+			fmt.Fprintf(readWriter.ErrOut, "%v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	// The messages shown to users are formatted for their terminal when
+	// OpenSSH allocated a pty
+	readWriter.ErrOut = console.DetectTerminal(os.Stderr, os.Getenv("TERM"), config.ColorWarnings)
+
 	env := sshenv.NewFromEnv()
 	cmd, err := shellCmd.New(os.Args[1:], env, config, readWriter)
 	if err != nil {
 		// For now this could happen if `SSH_CONNECTION` is not set on
 		// the environment
 		fmt.Fprintf(readWriter.ErrOut, "%v\n", err)
-		os.Exit(1)
+		if errors.Is(err, disallowedcommand.Error) {
+			fmt.Fprintln(readWriter.ErrOut)
+			help.Write(context.Background(), readWriter.ErrOut, config, nil)
+		}
+		errorcode.WriteTrailer(readWriter.ErrOut, errorcode.Classify(err))
+		os.Exit(errorcode.ExitCode(err))
 	}
 
 	ctx, finished := command.Setup(executable.Name, config)
 	defer finished()
+	// The locale is selected by the LANG and LC_* variables sshd accepted
+	ctx = i18n.ContextWithLocale(ctx, i18n.Select(config.Locale, os.Getenv))
+
+	var commandType commandargs.CommandType
+	var recording *sessionrecord.Session
+	var hooked *sessionhook.Session
+	if args, err := shellCmd.Parse(os.Args[1:], env); err == nil {
+		ctx = logger.ContextWithSessionFields(ctx, args.LogFields())
+		commandType = args.CommandType
+		transport, err := config.HTTPTransport()
+		if err != nil {
+			fmt.Fprintf(readWriter.ErrOut, "%v\n", err)
+			os.Exit(1)
+		}
+		recording, readWriter.In = sessionrecord.New(config.SessionRecording, transport).Start(ctx, args, readWriter.In)
+
+		if hooked, err = sessionhook.New(config.SessionHooks).Start(ctx, args, nil); err != nil {
+			console.DisplayWarningMessage(err.Error(), readWriter.ErrOut)
+			console.DisplayReference(correlation.ExtractFromContext(ctx), readWriter.ErrOut)
+			errorcode.WriteTrailer(readWriter.ErrOut, errorcode.Classify(err))
+			os.Exit(errorcode.ExitCode(err))
+		}
+	}
 
 	config.GitalyClient.InitSidechannelRegistry(ctx)
 
+	// Each gitlab-shell process serves a single SSH connection
+	ctx = bandwidth.ContextWithLimiter(ctx, bandwidth.NewLimiter(config.Bandwidth.PerConnection))
+
 	cmdName := reflect.TypeOf(cmd).String()
-	ctxlog := log.ContextLogger(ctx)
-	ctxlog.WithFields(log.Fields{"env": env, "command": cmdName}).Info("gitlab-shell: main: executing command")
+	ctxlog := logger.ContextLogger(ctx)
+	ctxlog.WithFields(log.Fields{"env": env.Redacted(), "command": cmdName}).Info("gitlab-shell: main: executing command")
 	fips.Check()
 
-	if _, err := cmd.Execute(ctx); err != nil {
+	ctxWithLogData, err := command.ExecuteWithTimeout(ctx, cmd, command.Timeout(config, commandType))
+
+	var logData command.LogData
+	if ctxWithLogData != nil {
+		logData, _ = ctxWithLogData.Value("logData").(command.LogData)
+	}
+	recording.Finish(ctx, logData, countingReader.N, countingWriter.N, err)
+	hooked.Finish(ctx, logData, err)
+
+	if err != nil {
 		ctxlog.WithError(err).Warn("gitlab-shell: main: command execution failed")
-		if grpcstatus.Convert(err).Code() != grpccodes.Internal {
+
+		var limitErr *accessverifier.LimitExceededError
+		if errors.As(err, &limitErr) {
+			console.DisplayWarningMessages(limitErr.Messages(), readWriter.ErrOut)
+		} else if grpcstatus.Convert(err).Code() != grpccodes.Internal {
 			console.DisplayWarningMessage(err.Error(), readWriter.ErrOut)
 		}
-		os.Exit(1)
+
+		console.DisplayReference(correlation.ExtractFromContext(ctx), readWriter.ErrOut)
+		errorcode.WriteTrailer(readWriter.ErrOut, errorcode.Classify(err))
+		os.Exit(errorcode.ExitCode(err))
 	}
 
-	ctxlog.Info("gitlab-shell: main: command executed successfully")
+	ctxlog.WithFields(log.Fields{
+		"written_bytes": countingWriter.N,
+		"read_bytes":    countingReader.N,
+	}).Info("gitlab-shell: main: command executed successfully")
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// The flags mirroring settings of the config file, for gitlab-sshd to run
+// without one, e.g. in containers. They take precedence over the config file
+// and the environment.
+var (
+	listen     = flag.String("listen", "", "The address to listen for SSH connections on (sshd.listen)")
+	webListen  = flag.String("web-listen", "", "The address of the monitoring endpoint, empty to disable it (sshd.web_listen)")
+	gitlabURL  = flag.String("gitlab-url", "", "The URL of GitLab (gitlab_url)")
+	secretFile = flag.String("secret-file", "", "The file holding the secret shared with GitLab (secret_file)")
+	logLevel   = flag.String("log-level", "", "The log level: debug, info, warn or error (log_level)")
+	logFormat  = flag.String("log-format", "", "The log format: json or text (log_format)")
+	logFile    = flag.String("log-file", "", "The file logs are written to, stderr when empty (log_file)")
+	hostKeys   stringsFlag
+)
+
+func init() {
+	flag.Var(&hostKeys, "host-key", "A private host key file, can be repeated (sshd.host_key_files)")
+}
+
+// stringsFlag is a flag that can be repeated
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+
+	return nil
+}
+
+// overrideConfigFromFlags applies the flags that were set to cfg
+func overrideConfigFromFlags(flags *flag.FlagSet, cfg *config.Config) error {
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+
+		switch f.Name {
+		case "listen":
+			cfg.Server.Listen = *listen
+			cfg.SetSource("sshd.listen", config.SourceFlag)
+		case "web-listen":
+			cfg.Server.WebListen = *webListen
+			cfg.SetSource("sshd.web_listen", config.SourceFlag)
+		case "host-key":
+			cfg.Server.HostKeyFiles = hostKeys
+			cfg.SetSource("sshd.host_key_files", config.SourceFlag)
+		case "gitlab-url":
+			cfg.GitlabUrl = *gitlabURL
+			cfg.SetSource("gitlab_url", config.SourceFlag)
+		case "secret-file":
+			var path string
+			if path, err = filepath.Abs(*secretFile); err == nil {
+				err = cfg.LoadSecretFile(path)
+			}
+		case "log-level":
+			cfg.LogLevel = *logLevel
+			cfg.SetSource("log_level", config.SourceFlag)
+		case "log-format":
+			cfg.LogFormat = *logFormat
+			cfg.SetSource("log_format", config.SourceFlag)
+		case "log-file":
+			cfg.LogFile = *logFile
+			cfg.SetSource("log_file", config.SourceFlag)
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestOverrideConfigFromFlags(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("flag-secret"), 0o600))
+
+	require.NoError(t, flag.CommandLine.Parse([]string{
+		"-listen", ":2222",
+		"-web-listen", "",
+		"-host-key", "/keys/ssh_host_ed25519_key",
+		"-host-key", "/keys/ssh_host_rsa_key",
+		"-gitlab-url", "http://gitlab.example.com",
+		"-secret-file", secretFile,
+		"-log-level", "debug",
+	}))
+	t.Cleanup(func() { hostKeys = nil })
+
+	cfg := &config.Config{LogLevel: "info", LogFormat: "json"}
+	cfg.Server = config.DefaultServerConfig
+	require.NoError(t, overrideConfigFromFlags(flag.CommandLine, cfg))
+
+	require.Equal(t, ":2222", cfg.Server.Listen)
+	require.Empty(t, cfg.Server.WebListen)
+	require.Equal(t, []string{"/keys/ssh_host_ed25519_key", "/keys/ssh_host_rsa_key"}, cfg.Server.HostKeyFiles)
+	require.Equal(t, "http://gitlab.example.com", cfg.GitlabUrl)
+	require.Equal(t, "flag-secret", cfg.Secret)
+	require.Equal(t, "debug", cfg.LogLevel)
+	// The flags that weren't set leave the config as it was
+	require.Equal(t, "json", cfg.LogFormat)
+
+	_, sources, err := cfg.Sanitized()
+	require.NoError(t, err)
+	require.Equal(t, config.SourceFlag, sources["sshd.listen"])
+	require.Equal(t, config.SourceSecretFile, sources["secret"])
+	require.Equal(t, config.SourceDefault, sources["log_format"])
+
+	require.NoError(t, flag.CommandLine.Parse([]string{"-secret-file", filepath.Join(dir, "missing")}))
+	require.ErrorContains(t, overrideConfigFromFlags(flag.CommandLine, cfg), "no such file or directory")
+}
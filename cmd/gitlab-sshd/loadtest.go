@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/loadtest"
+)
+
+// runLoadTest runs `gitlab-sshd loadtest` and returns its exit code
+func runLoadTest(args []string) int {
+	var cfg loadtest.Config
+
+	flags := flag.NewFlagSet("gitlab-sshd loadtest", flag.ContinueOnError)
+	flags.StringVar(&cfg.Address, "address", "localhost:22", "the host:port of the SSH server")
+	flags.StringVar(&cfg.User, "user", "git", "the SSH user")
+	flags.StringVar(&cfg.IdentityFile, "identity-file", "", "the private key of a user allowed to fetch the repository")
+	flags.StringVar(&cfg.KnownHostsFile, "known-hosts-file", "", "the known hosts verifying the host key, which isn't verified when empty")
+	flags.StringVar(&cfg.Repository, "repository", "", "the path of the test repository, e.g. group/project.git")
+	flags.IntVar(&cfg.Concurrency, "concurrency", 10, "the number of concurrent SSH sessions")
+	flags.IntVar(&cfg.Requests, "requests", 100, "the total number of fetches, or 0 to fetch until the duration elapsed")
+	flags.DurationVar(&cfg.Duration, "duration", 0, "how long to fetch for, e.g. 1m")
+	flags.BoolVar(&cfg.RefsOnly, "refs-only", false, "only list the refs, like git ls-remote, instead of fetching")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 2
+	}
+
+	if cfg.IdentityFile == "" || cfg.Repository == "" {
+		fmt.Fprintln(os.Stderr, "--identity-file and --repository are required")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report, err := loadtest.Run(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	report.Write(os.Stdout)
+	if report.Failures > 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -3,22 +3,29 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/loadtest"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshd"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/watchdog"
 
 	"gitlab.com/gitlab-org/labkit/log"
 	"gitlab.com/gitlab-org/labkit/monitoring"
 )
 
 var (
-	configDir = flag.String("config-dir", "", "The directory the config is in")
+	configDir            = flag.String("config-dir", "", "The directory the config is in")
+	profile              = flag.String("profile", os.Getenv(config.ProfileEnv), "The config profile presetting the defaults: "+strings.Join(config.Profiles(), ", "))
+	printEffectiveConfig = flag.Bool("print-effective-config", false, "Print the settings in effect with their source, then exit")
 
 	// Version is the current version of gitlab-shell
 	Version = "(unknown version)" // Set at build time in the Makefile
@@ -29,36 +36,148 @@ var (
 func overrideConfigFromEnvironment(cfg *config.Config) {
 	if gitlabUrl := os.Getenv("GITLAB_URL"); gitlabUrl != "" {
 		cfg.GitlabUrl = gitlabUrl
+		cfg.SetSource("gitlab_url", config.SourceEnv)
 	}
 	if gitlabTracing := os.Getenv("GITLAB_TRACING"); gitlabTracing != "" {
 		cfg.GitlabTracing = gitlabTracing
+		cfg.SetSource("gitlab_tracing", config.SourceEnv)
 	}
 	if gitlabShellSecret := os.Getenv("GITLAB_SHELL_SECRET"); gitlabShellSecret != "" {
 		cfg.Secret = gitlabShellSecret
+		cfg.SetSource("secret", config.SourceEnv)
 	}
 	if gitlabLogFormat := os.Getenv("GITLAB_LOG_FORMAT"); gitlabLogFormat != "" {
 		cfg.LogFormat = gitlabLogFormat
+		cfg.SetSource("log_format", config.SourceEnv)
 	}
 }
 
-func main() {
-	command.CheckForVersionFlag(os.Args, Version, BuildTime)
+// toggleAPICaptureOnSignal turns the internal API capture on and off whenever
+// SIGUSR1 is received.
+func toggleAPICaptureOnSignal(cfg *config.Config) {
+	sigusr1 := make(chan os.Signal, 1)
+	signal.Notify(sigusr1, syscall.SIGUSR1)
 
-	flag.Parse()
+	for range sigusr1 {
+		cfg.APICapture().Toggle()
+	}
+}
+
+// debugLogDuration is how long SIGUSR2 turns debug logs on for
+const debugLogDuration = 10 * time.Minute
+
+// toggleDebugLogsOnSignal turns debug logs on for debugLogDuration whenever
+// SIGUSR2 is received, or back off when they were turned on temporarily.
+func toggleDebugLogsOnSignal() {
+	sigusr2 := make(chan os.Signal, 1)
+	signal.Notify(sigusr2, syscall.SIGUSR2)
+
+	for range sigusr2 {
+		if !logger.LevelRevertAt().IsZero() {
+			logger.RevertLevel()
+			continue
+		}
+
+		if err := logger.SetLevelFor("debug", debugLogDuration); err != nil {
+			log.WithError(err).Warn("Failed to turn debug logs on")
+			continue
+		}
+		log.WithField("duration_s", debugLogDuration.Seconds()).Info("Debug logs turned on")
+	}
+}
 
+// loadConfig loads the configuration from the config directory or profile,
+// overridden by the environment and the flags
+func loadConfig() (*config.Config, error) {
 	cfg := new(config.Config)
 	if *configDir != "" {
 		var err error
-		cfg, err = config.NewFromDir(*configDir)
+		cfg, err = config.NewFromDirWithProfile(*configDir, *profile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load configuration from specified directory: %w", err)
+		}
+	} else if *profile != "" {
+		var err error
+		cfg, err = config.NewFromProfile(*profile)
 		if err != nil {
-			log.WithError(err).Fatal("failed to load configuration from specified directory")
+			return nil, fmt.Errorf("failed to load configuration profile: %w", err)
 		}
 	}
 
 	overrideConfigFromEnvironment(cfg)
+	if err := overrideConfigFromFlags(flag.CommandLine, cfg); err != nil {
+		return nil, fmt.Errorf("failed to apply the flags: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// reloadConfig loads the configuration again and applies the settings that
+// can change at runtime: the log level and the maintenance mode. The other
+// settings require a restart.
+func reloadConfig(cfg *config.Config) error {
+	reloaded, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if err := reloaded.IsSane(); err != nil {
+		return fmt.Errorf("configuration error: %w", err)
+	}
+
+	if err := logger.SetLevel(reloaded.LogLevel); err != nil {
+		return fmt.Errorf("log_level: %w", err)
+	}
+	cfg.Maintenance().Set(reloaded.MaintenanceMode.Enabled, reloaded.MaintenanceMode.Message)
+
+	return nil
+}
+
+// supervise runs the worker processes of gitlab-sshd until SIGINT or SIGTERM
+// is received, and shuts them down gracefully.
+func supervise(cfg *config.Config) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	supervisor, err := sshd.NewSupervisor(cfg, os.Args[1:])
+	if err != nil {
+		log.WithError(err).Fatal("Failed to start the GitLab built-in sshd supervisor")
+	}
+
+	if err := supervisor.Run(ctx); err != nil {
+		log.WithError(err).Fatal("GitLab built-in sshd supervisor failed to listen for new connections")
+	}
+}
+
+func main() {
+	command.CheckForVersionFlag(os.Args, Version, BuildTime)
+
+	if len(os.Args) > 1 && os.Args[1] == loadtest.Subcommand {
+		os.Exit(runLoadTest(os.Args[2:]))
+	}
+
+	flag.Parse()
+
+	if err := command.RegistrationErr(); err != nil {
+		log.WithError(err).Fatal("failed to register the SSH commands")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.WithError(err).Fatal("failed to load the configuration")
+	}
+
+	if *printEffectiveConfig {
+		if err := cfg.WriteEffective(os.Stdout); err != nil {
+			log.WithError(err).Fatal("failed to print the configuration")
+		}
+
+		return
+	}
+
 	if err := cfg.IsSane(); err != nil {
 		if *configDir == "" {
-			log.WithError(err).Fatal("no config-dir provided, using only environment variables")
+			log.WithError(err).Fatal("no config-dir provided, using only flags and environment variables")
 		} else {
 			log.WithError(err).Fatal("configuration error")
 		}
@@ -68,11 +187,24 @@ func main() {
 
 	logCloser := logger.ConfigureStandalone(cfg)
 	defer logCloser.Close()
+	defer cfg.APICapture().Close()
+
+	if index, ok := sshd.WorkerIndex(); ok {
+		if err := sshd.ConfigureWorker(cfg, index); err != nil {
+			log.WithError(err).Fatal("configuration error")
+		}
+	} else if cfg.Server.Workers > 0 {
+		supervise(cfg)
+		return
+	}
 
 	ctx, finished := command.Setup("gitlab-sshd", cfg)
 	defer finished()
 
-	cfg.GitalyClient.InitSidechannelRegistry(ctx)
+	for _, c := range append([]*config.Config{cfg}, cfg.TenantConfigs()...) {
+		c.GitalyClient.InitSidechannelRegistry(ctx)
+		go c.GitalyClient.StartReaper(ctx, time.Duration(c.Gitaly.ConnectionIdleTimeout))
+	}
 
 	sshd.LoadGSSAPILib(&cfg.Server.GSSAPI)
 
@@ -80,15 +212,40 @@ func main() {
 	if err != nil {
 		log.WithError(err).Fatal("Failed to start GitLab built-in sshd")
 	}
+	server.Version = Version
+	server.BuildTime = BuildTime
+
+	metrics.EnableLabeledMetrics(cfg.Server.LabeledMetrics.TopProjects, cfg.Server.LabeledMetrics.TopUsers)
 
 	// Startup monitoring endpoint.
 	if cfg.Server.WebListen != "" {
+		if cfg.Server.Profiling.Enabled {
+			if err := metrics.RegisterRuntimeMetrics(); err != nil {
+				log.WithError(err).Warn("Failed to register Go runtime metrics")
+			}
+		}
+
+		listener, err := sshd.MonitoringListener(cfg)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to listen on web_listen")
+		}
+		metrics.RegisterBuildInfo(Version, BuildTime)
+
 		go func() {
-			err := monitoring.Start(
-				monitoring.WithListenerAddress(cfg.Server.WebListen),
+			// The metrics are served by the server mux, behind web_auth when
+			// configured. So is pprof when it requires credentials, and it
+			// isn't served at all unless profiling is enabled.
+			opts := []monitoring.Option{
+				monitoring.WithListener(listener),
 				monitoring.WithBuildInformation(Version, BuildTime),
 				monitoring.WithServeMux(server.MonitoringServeMux()),
-			)
+				monitoring.WithoutMetrics(),
+			}
+			if !cfg.Server.Profiling.Enabled || server.ServesPprof() {
+				opts = append(opts, monitoring.WithoutPprof())
+			}
+
+			err := monitoring.Start(opts...)
 
 			log.WithError(err).Fatal("monitoring service raised an error")
 		}()
@@ -97,15 +254,63 @@ func main() {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	go toggleAPICaptureOnSignal(cfg)
+	go toggleDebugLogsOnSignal()
+
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
 
+	drain := make(chan string, 1)
+	go watchdog.New(cfg.Server.Watchdog, func(reason string) { drain <- reason }).Run(ctx)
+
+	if cfg.Server.ControlSocket.Path != "" {
+		listener, err := sshd.ControlListener(cfg)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to listen on the control socket")
+		}
+
+		go func() {
+			err := server.ServeControl(ctx, listener, sshd.ControlOpts{
+				Reload: func() error { return reloadConfig(cfg) },
+				Drain: func(reason string) {
+					select {
+					case drain <- reason:
+					default:
+					}
+				},
+			})
+			if err != nil {
+				log.WithError(err).Error("control socket raised an error")
+			}
+		}()
+	}
+
+	if statsd := cfg.Server.Statsd; statsd.Address != "" {
+		sink, err := metrics.NewStatsdSink(metrics.StatsdOpts{
+			Address:  statsd.Address,
+			Interval: time.Duration(statsd.Interval),
+			Tags:     statsd.Tags,
+		})
+		if err != nil {
+			log.WithError(err).Fatal("Failed to start the statsd sink")
+		}
+
+		go sink.Run(ctx)
+	}
+
 	go func() {
-		sig := <-done
+		fields := log.Fields{}
+		select {
+		case sig := <-done:
+			fields["signal"] = sig.String()
+		case reason := <-drain:
+			fields["reason"] = reason
+		}
 		signal.Reset(syscall.SIGINT, syscall.SIGTERM)
 
 		gracePeriod := time.Duration(cfg.Server.GracePeriod)
-		log.WithContextFields(ctx, log.Fields{"shutdown_timeout_s": gracePeriod.Seconds(), "signal": sig.String()}).Info("Shutdown initiated")
+		fields["shutdown_timeout_s"] = gracePeriod.Seconds()
+		log.WithContextFields(ctx, fields).Info("Shutdown initiated")
 
 		server.Shutdown()
 
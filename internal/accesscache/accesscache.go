@@ -0,0 +1,132 @@
+// Package accesscache caches the verdicts of the internal API allowing read
+// access to a repository, so that repeated fetches, e.g. by CI runners, don't
+// each query it.
+package accesscache
+
+import (
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+const defaultMaxEntries = 10000
+
+// Key identifies an access check. Who is the identity the check is made
+// for: key-<id>, user-<username> or krb5-<principal>.
+type Key struct {
+	Action        string
+	Repo          string
+	Who           string
+	CheckIP       string
+	NamespacePath string
+}
+
+// Cache keeps the responses of access checks for a TTL. A nil *Cache is
+// valid and caches nothing.
+type Cache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[Key]entry
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+type entry struct {
+	body    []byte
+	expires time.Time
+}
+
+// New returns a Cache keeping at most maxEntries responses for ttl, or nil
+// if ttl is zero
+func New(ttl time.Duration, maxEntries int) *Cache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[Key]entry),
+		now:        time.Now,
+	}
+}
+
+// Get returns the response cached for key
+func (c *Cache) Get(key Key) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if ok && !c.now().Before(e.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+
+	if !ok {
+		metrics.AccessCacheRequestsTotal.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+
+	metrics.AccessCacheRequestsTotal.WithLabelValues("hit").Inc()
+
+	return e.body, true
+}
+
+// Add caches the response body of key. Nothing is cached while the cache is
+// full of unexpired responses.
+func (c *Cache) Add(key Key, body []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= c.maxEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+
+		if len(c.entries) >= c.maxEntries {
+			return
+		}
+	}
+
+	c.entries[key] = entry{body: body, expires: now.Add(c.ttl)}
+}
+
+// Invalidate removes the responses cached for repo and who, an empty value
+// matching any of them, and returns how many were removed
+func (c *Cache) Invalidate(repo, who string) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for k := range c.entries {
+		if (repo == "" || k.Repo == repo) && (who == "" || k.Who == who) {
+			delete(c.entries, k)
+			removed++
+		}
+	}
+
+	return removed
+}
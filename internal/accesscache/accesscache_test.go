@@ -0,0 +1,90 @@
+package accesscache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+func TestNilCache(t *testing.T) {
+	c := New(0, 0)
+	require.Nil(t, c)
+
+	c.Add(Key{Repo: "group/project"}, []byte("{}"))
+	_, ok := c.Get(Key{Repo: "group/project"})
+	require.False(t, ok)
+	require.Zero(t, c.Invalidate("", ""))
+}
+
+func TestCache(t *testing.T) {
+	c := New(time.Minute, 0)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	hits := metrics.AccessCacheRequestsTotal.WithLabelValues("hit")
+	misses := metrics.AccessCacheRequestsTotal.WithLabelValues("miss")
+	initialHits, initialMisses := testutil.ToFloat64(hits), testutil.ToFloat64(misses)
+
+	key := Key{Action: "git-upload-pack", Repo: "group/project", Who: "key-1"}
+
+	_, ok := c.Get(key)
+	require.False(t, ok)
+
+	c.Add(key, []byte(`{"status": true}`))
+	body, ok := c.Get(key)
+	require.True(t, ok)
+	require.Equal(t, []byte(`{"status": true}`), body)
+
+	_, ok = c.Get(Key{Action: "git-upload-pack", Repo: "group/project", Who: "key-2"})
+	require.False(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = c.Get(key)
+	require.False(t, ok)
+
+	require.InDelta(t, initialHits+1, testutil.ToFloat64(hits), 0.1)
+	require.InDelta(t, initialMisses+3, testutil.ToFloat64(misses), 0.1)
+}
+
+func TestInvalidate(t *testing.T) {
+	c := New(time.Minute, 0)
+
+	for _, key := range []Key{
+		{Repo: "group/a", Who: "key-1"},
+		{Repo: "group/a", Who: "key-2"},
+		{Repo: "group/b", Who: "key-1"},
+		{Repo: "group/b", Who: "user-root"},
+	} {
+		c.Add(key, []byte("{}"))
+	}
+
+	require.Equal(t, 1, c.Invalidate("group/a", "key-1"))
+	require.Equal(t, 1, c.Invalidate("group/a", ""))
+	require.Equal(t, 1, c.Invalidate("", "user-root"))
+	require.Equal(t, 0, c.Invalidate("group/a", ""))
+	require.Equal(t, 1, c.Invalidate("", ""))
+}
+
+func TestMaxEntries(t *testing.T) {
+	c := New(time.Minute, 2)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Add(Key{Repo: "a"}, []byte("{}"))
+	c.Add(Key{Repo: "b"}, []byte("{}"))
+	c.Add(Key{Repo: "c"}, []byte("{}"))
+
+	_, ok := c.Get(Key{Repo: "c"})
+	require.False(t, ok)
+
+	// Expired responses make room for new ones
+	now = now.Add(time.Minute)
+	c.Add(Key{Repo: "c"}, []byte("{}"))
+
+	_, ok = c.Get(Key{Repo: "c"})
+	require.True(t, ok)
+}
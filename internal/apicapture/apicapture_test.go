@@ -0,0 +1,146 @@
+package apicapture
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		if r.URL.Path == "/stream" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(body)
+			return
+		}
+
+		require.JSONEq(t, `{"key_id":"1","otp_attempt":"123456"}`, string(body))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		io.WriteString(w, `{"success":true,"username":"alex-doe","lfs_token":"sometoken","recovery_codes":["a","b"],"header":{"Authorization":"Basic abc"}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func readEntries(t *testing.T, path string) []*Entry {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var entries []*Entry
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		entry := &Entry{}
+		require.NoError(t, decoder.Decode(entry))
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestCapture(t *testing.T) {
+	server := newServer(t)
+	path := filepath.Join(t.TempDir(), "capture.log")
+	recorder := NewRecorder(path, true)
+	defer recorder.Close()
+
+	client := &http.Client{Transport: NewRoundTripper(http.DefaultTransport, recorder)}
+
+	request, err := http.NewRequest(http.MethodPost, server.URL+"/api/v4/internal/two_factor_otp_check?private_token=abc&page=2", strings.NewReader(`{"key_id":"1","otp_attempt":"123456"}`))
+	require.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Gitlab-Shell-Api-Request", "jwt")
+
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+
+	// The caller still receives the complete response
+	require.Contains(t, string(body), `"lfs_token":"sometoken"`)
+
+	entries := readEntries(t, path)
+	require.Len(t, entries, 1)
+
+	entry := entries[0]
+	require.Equal(t, http.MethodPost, entry.Method)
+	require.Equal(t, server.URL+"/api/v4/internal/two_factor_otp_check?private_token=[REDACTED]&page=2", entry.URL)
+	require.Equal(t, "[REDACTED]", entry.RequestHeaders["Gitlab-Shell-Api-Request"])
+	require.JSONEq(t, `{"key_id":"1","otp_attempt":"[REDACTED]"}`, string(entry.RequestBody.Content))
+
+	require.Equal(t, http.StatusOK, entry.Status)
+	require.Equal(t, "[REDACTED]", entry.ResponseHeaders["Set-Cookie"])
+	require.JSONEq(t, `{"success":true,"username":"alex-doe","lfs_token":"[REDACTED]","recovery_codes":"[REDACTED]","header":{"Authorization":"[REDACTED]"}}`, string(entry.ResponseBody.Content))
+}
+
+func TestCaptureSkipsStreamedBodies(t *testing.T) {
+	server := newServer(t)
+	path := filepath.Join(t.TempDir(), "capture.log")
+	recorder := NewRecorder(path, true)
+	defer recorder.Close()
+
+	client := &http.Client{Transport: NewRoundTripper(http.DefaultTransport, recorder)}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("PACK"))
+		pw.Close()
+	}()
+
+	response, err := client.Post(server.URL+"/stream", "application/octet-stream", pr)
+	require.NoError(t, err)
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	require.Equal(t, "PACK", string(body))
+
+	entries := readEntries(t, path)
+	require.Len(t, entries, 1)
+	require.Equal(t, &Body{Size: -1, Omitted: "streamed"}, entries[0].RequestBody)
+	require.Equal(t, &Body{Size: 4, Omitted: "not JSON"}, entries[0].ResponseBody)
+}
+
+func TestToggle(t *testing.T) {
+	server := newServer(t)
+	path := filepath.Join(t.TempDir(), "capture.log")
+	recorder := NewRecorder(path, false)
+	defer recorder.Close()
+
+	client := &http.Client{Transport: NewRoundTripper(http.DefaultTransport, recorder)}
+	get := func() {
+		response, err := client.Get(server.URL + "/stream")
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+	}
+
+	get()
+	require.NoFileExists(t, path)
+
+	require.True(t, recorder.Toggle())
+	get()
+	require.Len(t, readEntries(t, path), 1)
+
+	require.False(t, recorder.Toggle())
+	get()
+	require.Len(t, readEntries(t, path), 1)
+}
+
+func TestNilRecorder(t *testing.T) {
+	var recorder *Recorder
+
+	require.False(t, recorder.Enabled())
+}
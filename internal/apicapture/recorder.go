@@ -0,0 +1,114 @@
+// Package apicapture records the requests made to the GitLab internal API and
+// the responses received, with secrets redacted, to help debugging issues that
+// can't be reproduced outside of a customer's installation.
+package apicapture
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// Recorder appends captured interactions to a debug log as JSON lines. It can
+// be enabled and disabled at runtime, the log is only opened once something
+// has to be written to it.
+type Recorder struct {
+	path    string
+	enabled atomic.Bool
+
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// Entry is a single captured request/response pair
+type Entry struct {
+	Time            time.Time         `json:"time"`
+	CorrelationID   string            `json:"correlation_id,omitempty"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	RequestBody     *Body             `json:"request_body,omitempty"`
+	Status          int               `json:"status,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	ResponseBody    *Body             `json:"response_body,omitempty"`
+	DurationMs      int64             `json:"duration_ms"`
+	Error           string            `json:"error,omitempty"`
+}
+
+// Body holds a captured body. Content is only set for JSON documents that fit
+// in the capture limit, Omitted tells why other bodies were left out. Size is
+// -1 when unknown.
+type Body struct {
+	Content json.RawMessage `json:"content,omitempty"`
+	Size    int64           `json:"size"`
+	Omitted string          `json:"omitted,omitempty"`
+}
+
+func NewRecorder(path string, enabled bool) *Recorder {
+	r := &Recorder{path: path}
+	r.enabled.Store(enabled)
+
+	return r
+}
+
+// Enabled is safe to call on a nil Recorder, which never records anything
+func (r *Recorder) Enabled() bool {
+	return r != nil && r.enabled.Load()
+}
+
+func (r *Recorder) SetEnabled(enabled bool) {
+	if r.enabled.Swap(enabled) == enabled {
+		return
+	}
+
+	log.WithFields(log.Fields{"enabled": enabled, "capture_log_file": r.path}).Info("Internal API capture toggled")
+}
+
+// Toggle flips the state of the recorder and returns the new one
+func (r *Recorder) Toggle() bool {
+	enabled := !r.Enabled()
+	r.SetEnabled(enabled)
+
+	return enabled
+}
+
+func (r *Recorder) Record(entry *Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.encoder == nil {
+		file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			log.WithError(err).WithField("capture_log_file", r.path).Warn("Unable to open the internal API capture log, disabling the capture")
+			r.enabled.Store(false)
+			return
+		}
+
+		r.file = file
+		r.encoder = json.NewEncoder(file)
+	}
+
+	if err := r.encoder.Encode(entry); err != nil {
+		log.WithError(err).WithField("capture_log_file", r.path).Warn("Unable to write to the internal API capture log")
+	}
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+	r.encoder = nil
+
+	return err
+}
@@ -0,0 +1,221 @@
+package apicapture
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/correlation"
+)
+
+const (
+	// maxBodySize is the largest body captured, bigger ones are omitted
+	maxBodySize = 64 * 1024
+
+	redacted = "[REDACTED]"
+)
+
+// sensitiveHeaders are never written to the capture log
+var sensitiveHeaders = map[string]bool{
+	"Authorization":            true,
+	"Proxy-Authorization":      true,
+	"Cookie":                   true,
+	"Set-Cookie":               true,
+	"Private-Token":            true,
+	"Gitlab-Shared-Secret":     true,
+	"Gitlab-Shell-Api-Request": true,
+}
+
+// sensitiveFields are substrings of the JSON fields and query parameters
+// whose values are redacted
+var sensitiveFields = []string{"token", "secret", "password", "otp", "recovery_codes", "authorization", "private"}
+
+type roundTripper struct {
+	next     http.RoundTripper
+	recorder *Recorder
+}
+
+// NewRoundTripper captures the interactions going through next while the
+// recorder is enabled
+func NewRoundTripper(next http.RoundTripper, recorder *Recorder) http.RoundTripper {
+	return &roundTripper{next: next, recorder: recorder}
+}
+
+func (rt *roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !rt.recorder.Enabled() {
+		return rt.next.RoundTrip(request)
+	}
+
+	entry := &Entry{
+		Time:           time.Now().UTC(),
+		CorrelationID:  correlation.ExtractFromContext(request.Context()),
+		Method:         request.Method,
+		URL:            redactURL(request.URL.String()),
+		RequestHeaders: redactHeaders(request.Header),
+	}
+
+	// Bodies of unknown length are streamed, e.g. pushes proxied by Geo, and
+	// are left alone so that the capture doesn't buffer them. A zero length
+	// with a body means the length is unknown.
+	if request.Body != nil && request.Body != http.NoBody {
+		switch {
+		case request.ContentLength <= 0:
+			entry.RequestBody = &Body{Size: -1, Omitted: "streamed"}
+		case request.ContentLength > maxBodySize:
+			entry.RequestBody = &Body{Size: request.ContentLength, Omitted: "too large"}
+		default:
+			// RoundTrip must not modify the request it was given
+			request = request.Clone(request.Context())
+
+			var body *Body
+			body, request.Body = captureBody(request.Body, request.Header)
+			entry.RequestBody = body
+		}
+	}
+
+	start := time.Now()
+	response, err := rt.next.RoundTrip(request)
+	entry.DurationMs = time.Since(start).Milliseconds()
+
+	if err != nil {
+		entry.Error = err.Error()
+		rt.recorder.Record(entry)
+
+		return response, err
+	}
+
+	entry.Status = response.StatusCode
+	entry.ResponseHeaders = redactHeaders(response.Header)
+
+	if isJSON(response.Header) {
+		var body *Body
+		body, response.Body = captureBody(response.Body, response.Header)
+		entry.ResponseBody = body
+	} else {
+		entry.ResponseBody = &Body{Size: response.ContentLength, Omitted: "not JSON"}
+	}
+
+	rt.recorder.Record(entry)
+
+	return response, nil
+}
+
+// captureBody reads the beginning of body and returns it along with a reader
+// that replays it, so that the caller is not affected by the capture
+func captureBody(body io.ReadCloser, header http.Header) (*Body, io.ReadCloser) {
+	buf, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+
+	replay := &replayBody{Reader: io.MultiReader(bytes.NewReader(buf), body), Closer: body}
+	if err != nil {
+		return &Body{Size: int64(len(buf)), Omitted: "read error: " + err.Error()}, replay
+	}
+
+	captured := &Body{Size: int64(len(buf))}
+	switch {
+	case len(buf) > maxBodySize:
+		captured.Size = -1
+		captured.Omitted = "too large"
+	case len(buf) == 0:
+	case !isJSON(header):
+		captured.Omitted = "not JSON"
+	default:
+		captured.Content = redactJSON(buf)
+	}
+
+	return captured, replay
+}
+
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+func isJSON(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, field := range sensitiveFields {
+		if strings.Contains(name, field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func redactHeaders(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+
+	redactedHeader := make(map[string]string, len(header))
+	for name, values := range header {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] || isSensitive(name) {
+			redactedHeader[name] = redacted
+		} else {
+			redactedHeader[name] = strings.Join(values, ", ")
+		}
+	}
+
+	return redactedHeader
+}
+
+func redactURL(rawURL string) string {
+	base, rawQuery, ok := strings.Cut(rawURL, "?")
+	if !ok {
+		return rawURL
+	}
+
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		if name, _, ok := strings.Cut(param, "="); ok && isSensitive(name) {
+			params[i] = name + "=" + redacted
+		}
+	}
+
+	return base + "?" + strings.Join(params, "&")
+}
+
+func redactJSON(data []byte) json.RawMessage {
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return json.RawMessage(`"[INVALID JSON]"`)
+	}
+
+	redactedData, err := json.Marshal(redactValue(document))
+	if err != nil {
+		return json.RawMessage(`"[INVALID JSON]"`)
+	}
+
+	return redactedData
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, field := range v {
+			if isSensitive(name) {
+				v[name] = redacted
+			} else {
+				v[name] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,252 @@
+// Package bandwidth throttles the data copied between SSH clients and Gitaly.
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// minBurst bounds the size of a single read or write so small limits still
+// allow reasonably sized chunks through.
+const minBurst = 32 * 1024
+
+// Limits holds transfer rates in bytes per second. Upload is data sent by the
+// client (pushes), Download is data sent to the client (fetches). Zero means
+// unlimited.
+type Limits struct {
+	Upload   int64 `yaml:"upload,omitempty" json:"upload"`
+	Download int64 `yaml:"download,omitempty" json:"download"`
+}
+
+func (l Limits) IsZero() bool {
+	return l.Upload <= 0 && l.Download <= 0
+}
+
+// Limiter throttles both transfer directions. A nil *Limiter is valid and
+// doesn't limit anything.
+type Limiter struct {
+	upload   *rate.Limiter
+	download *rate.Limiter
+}
+
+// NewLimiter returns a Limiter enforcing l, or nil if l doesn't limit anything.
+func NewLimiter(l Limits) *Limiter {
+	if l.IsZero() {
+		return nil
+	}
+
+	return &Limiter{upload: newRateLimiter(l.Upload), download: newRateLimiter(l.Download)}
+}
+
+func newRateLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	burst := int(bytesPerSecond)
+	if burst < minBurst {
+		burst = minBurst
+	}
+
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+}
+
+func (l *Limiter) setLimits(limits Limits) {
+	setRateLimit(l.upload, limits.Upload)
+	setRateLimit(l.download, limits.Download)
+}
+
+func setRateLimit(rl *rate.Limiter, bytesPerSecond int64) {
+	if rl == nil || bytesPerSecond <= 0 {
+		return
+	}
+
+	rl.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+type limitersKey struct{}
+
+// ContextWithLimiter returns a copy of ctx which additionally carries l. All
+// limiters carried by a context apply to transfers made with it.
+func ContextWithLimiter(ctx context.Context, l *Limiter) context.Context {
+	if l == nil {
+		return ctx
+	}
+
+	existing := LimitersFromContext(ctx)
+	limiters := make([]*Limiter, 0, len(existing)+1)
+	limiters = append(limiters, existing...)
+	limiters = append(limiters, l)
+
+	return context.WithValue(ctx, limitersKey{}, limiters)
+}
+
+// LimitersFromContext returns the limiters carried by ctx.
+func LimitersFromContext(ctx context.Context) []*Limiter {
+	limiters, _ := ctx.Value(limitersKey{}).([]*Limiter)
+
+	return limiters
+}
+
+// NewReader throttles data read from r, the client upload direction.
+func NewReader(ctx context.Context, r io.Reader, limiters []*Limiter) io.Reader {
+	rls := collect(limiters, func(l *Limiter) *rate.Limiter { return l.upload })
+	if len(rls) == 0 {
+		return r
+	}
+
+	return &reader{ctx: ctx, r: r, limiters: rls, chunk: smallestBurst(rls)}
+}
+
+// NewWriter throttles data written to w, the client download direction.
+func NewWriter(ctx context.Context, w io.Writer, limiters []*Limiter) io.Writer {
+	rls := collect(limiters, func(l *Limiter) *rate.Limiter { return l.download })
+	if len(rls) == 0 {
+		return w
+	}
+
+	return &writer{ctx: ctx, w: w, limiters: rls, chunk: smallestBurst(rls)}
+}
+
+func collect(limiters []*Limiter, direction func(*Limiter) *rate.Limiter) []*rate.Limiter {
+	var rls []*rate.Limiter
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+
+		if rl := direction(l); rl != nil {
+			rls = append(rls, rl)
+		}
+	}
+
+	return rls
+}
+
+func smallestBurst(rls []*rate.Limiter) int {
+	chunk := rls[0].Burst()
+	for _, rl := range rls[1:] {
+		if rl.Burst() < chunk {
+			chunk = rl.Burst()
+		}
+	}
+
+	return chunk
+}
+
+func wait(ctx context.Context, rls []*rate.Limiter, n int) error {
+	for _, rl := range rls {
+		if err := rl.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type reader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rate.Limiter
+	chunk    int
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := wait(r.ctx, r.limiters, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+type writer struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*rate.Limiter
+	chunk    int
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	var written int
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.chunk {
+			chunk = chunk[:w.chunk]
+		}
+
+		if err := wait(w.ctx, w.limiters, len(chunk)); err != nil {
+			return written, err
+		}
+
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+type userLimiter struct {
+	limiter *Limiter
+	refs    int
+}
+
+// Registry hands out limiters shared by all sessions of the same user. The
+// zero value is ready to use.
+type Registry struct {
+	mu    sync.Mutex
+	users map[string]*userLimiter
+}
+
+// Acquire returns the limiter shared by all sessions of user, creating it with
+// limits if necessary. The returned function must be called once the session
+// no longer transfers data. The limiter is nil when limits are zero.
+func (r *Registry) Acquire(user string, limits Limits) (*Limiter, func()) {
+	if user == "" || limits.IsZero() {
+		return nil, func() {}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.users == nil {
+		r.users = make(map[string]*userLimiter)
+	}
+
+	ul := r.users[user]
+	if ul == nil {
+		ul = &userLimiter{limiter: NewLimiter(limits)}
+		r.users[user] = ul
+	} else {
+		ul.limiter.setLimits(limits)
+	}
+	ul.refs++
+
+	var once sync.Once
+	return ul.limiter, func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			ul.refs--
+			if ul.refs == 0 {
+				delete(r.users, user)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLimiter(t *testing.T) {
+	require.Nil(t, NewLimiter(Limits{}))
+
+	l := NewLimiter(Limits{Download: 1024})
+	require.NotNil(t, l)
+	require.Nil(t, l.upload)
+	require.NotNil(t, l.download)
+	require.Equal(t, minBurst, l.download.Burst())
+}
+
+func TestContextWithLimiter(t *testing.T) {
+	ctx := context.Background()
+	require.Empty(t, LimitersFromContext(ctx))
+
+	ctx = ContextWithLimiter(ctx, nil)
+	require.Empty(t, LimitersFromContext(ctx))
+
+	first := NewLimiter(Limits{Upload: 1})
+	second := NewLimiter(Limits{Upload: 2})
+
+	ctx = ContextWithLimiter(ctx, first)
+	childCtx := ContextWithLimiter(ctx, second)
+
+	require.Equal(t, []*Limiter{first}, LimitersFromContext(ctx))
+	require.Equal(t, []*Limiter{first, second}, LimitersFromContext(childCtx))
+}
+
+func TestUnlimitedPassThrough(t *testing.T) {
+	r := strings.NewReader("data")
+	w := &bytes.Buffer{}
+
+	limiters := []*Limiter{nil, NewLimiter(Limits{Upload: 1024})}
+
+	require.Same(t, r, NewReader(context.Background(), r, nil))
+	require.Same(t, w, NewWriter(context.Background(), w, limiters))
+}
+
+func TestWriterThrottles(t *testing.T) {
+	limiter := NewLimiter(Limits{Download: minBurst})
+	out := &bytes.Buffer{}
+	w := NewWriter(context.Background(), out, []*Limiter{limiter})
+
+	// The first burst is free, the second one has to wait roughly a second
+	data := bytes.Repeat([]byte("x"), minBurst*2)
+
+	started := time.Now()
+	n, err := w.Write(data)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	require.Equal(t, data, out.Bytes())
+	require.Greater(t, time.Since(started), 500*time.Millisecond)
+}
+
+func TestReaderStopsOnCancel(t *testing.T) {
+	limiter := NewLimiter(Limits{Upload: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewReader(ctx, strings.NewReader(strings.Repeat("x", minBurst*2)), []*Limiter{limiter})
+
+	_, err := io.ReadFull(r, make([]byte, minBurst))
+	require.NoError(t, err)
+
+	cancel()
+
+	_, err = r.Read(make([]byte, minBurst))
+	require.Error(t, err)
+}
+
+func TestRegistry(t *testing.T) {
+	var r Registry
+
+	l, release := r.Acquire("", Limits{Upload: 1})
+	require.Nil(t, l)
+	release()
+
+	l, release = r.Acquire("user-1", Limits{})
+	require.Nil(t, l)
+	release()
+
+	first, releaseFirst := r.Acquire("user-1", Limits{Download: 1024})
+	second, releaseSecond := r.Acquire("user-1", Limits{Download: 2048})
+	other, releaseOther := r.Acquire("user-2", Limits{Download: 1024})
+
+	require.Same(t, first, second)
+	require.NotSame(t, first, other)
+	require.EqualValues(t, 2048, first.download.Limit())
+
+	releaseFirst()
+	releaseFirst()
+	require.Contains(t, r.users, "user-1")
+
+	releaseSecond()
+	releaseOther()
+	require.Empty(t, r.users)
+}
@@ -0,0 +1,250 @@
+// Package cgroups places the helper processes of every gitlab-sshd session,
+// such as the session hooks, in a cgroup v2 of their own, so that a session
+// can't take the CPU and memory of the whole host and what it used can be
+// accounted for.
+package cgroups
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+const (
+	// cpuPeriod is the period of cpu.max in microseconds
+	cpuPeriod = 100000
+	// The processes left in a cgroup are killed before it's removed, which
+	// may take a moment
+	removeAttempts   = 10
+	removeRetryDelay = 10 * time.Millisecond
+)
+
+// Usage is what the processes of a session cgroup used
+type Usage struct {
+	CPUS            float64 `json:"cpu_s"`
+	MemoryPeakBytes int64   `json:"memory_peak_bytes"`
+	OOMKills        int64   `json:"oom_kills"`
+}
+
+// Manager creates the cgroups of the sessions. A nil *Manager is valid and
+// doesn't create any.
+type Manager struct {
+	cfg      config.SessionCgroupsConfig
+	sessions atomic.Int64
+}
+
+// New returns a Manager, or nil if session cgroups aren't enabled. It
+// enables the controllers the limits need in the parent cgroup.
+func New(cfg config.SessionCgroupsConfig) (*Manager, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if !supported {
+		return nil, errors.New("session cgroups are only supported on Linux")
+	}
+
+	data, err := os.ReadFile(filepath.Join(cfg.Path, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("session cgroups path %q isn't a cgroup v2: %w", cfg.Path, err)
+	}
+	available := strings.Fields(string(data))
+
+	// The memory controller is needed for the accounting even without limit
+	controllers := []string{"memory"}
+	if cfg.CPUPercent > 0 {
+		controllers = append(controllers, "cpu")
+	}
+
+	var enable []string
+	for _, controller := range controllers {
+		if !contains(available, controller) {
+			return nil, fmt.Errorf("the %s controller isn't available in %q", controller, cfg.Path)
+		}
+		enable = append(enable, "+"+controller)
+	}
+
+	if err := writeFile(filepath.Join(cfg.Path, "cgroup.subtree_control"), strings.Join(enable, " ")); err != nil {
+		return nil, fmt.Errorf("failed to enable the controllers of the session cgroups: %w", err)
+	}
+
+	return &Manager{cfg: cfg}, nil
+}
+
+// Create creates the cgroup of a new session with the configured limits
+func (m *Manager) Create() (*Cgroup, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	path := filepath.Join(m.cfg.Path, fmt.Sprintf("session-%d-%d", os.Getpid(), m.sessions.Add(1)))
+	if err := os.Mkdir(path, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create the session cgroup: %w", err)
+	}
+
+	cgroup := &Cgroup{path: path}
+	if err := cgroup.setLimits(m.cfg); err != nil {
+		cgroup.Remove()
+		return nil, err
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		cgroup.Remove()
+		return nil, err
+	}
+	cgroup.dir = dir
+
+	return cgroup, nil
+}
+
+// Cgroup is the cgroup of a session. A nil *Cgroup is valid and leaves the
+// processes in the cgroup of gitlab-sshd.
+type Cgroup struct {
+	path string
+	dir  *os.File
+}
+
+func (c *Cgroup) setLimits(cfg config.SessionCgroupsConfig) error {
+	if cfg.CPUPercent > 0 {
+		if err := writeFile(filepath.Join(c.path, "cpu.max"), cpuMax(cfg.CPUPercent)); err != nil {
+			return fmt.Errorf("failed to limit the CPU of the session cgroup: %w", err)
+		}
+	}
+
+	if cfg.MemoryLimit > 0 {
+		limit := strconv.FormatInt(cfg.MemoryLimit*1024*1024, 10)
+		if err := writeFile(filepath.Join(c.path, "memory.max"), limit); err != nil {
+			return fmt.Errorf("failed to limit the memory of the session cgroup: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// cpuMax returns the cpu.max setting of a limit in percent of a CPU
+func cpuMax(percent int) string {
+	return fmt.Sprintf("%d %d", percent*cpuPeriod/100, cpuPeriod)
+}
+
+// Apply makes the process cmd starts be created in the cgroup
+func (c *Cgroup) Apply(cmd *exec.Cmd) {
+	if c == nil {
+		return
+	}
+
+	setCgroup(cmd, c.dir)
+}
+
+// Usage returns what the processes of the cgroup used so far
+func (c *Cgroup) Usage() (*Usage, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	cpuStat, err := readKeyedFile(filepath.Join(c.path, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	memoryEvents, err := readKeyedFile(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return nil, err
+	}
+
+	// memory.peak requires Linux 5.19
+	var peak int64
+	if data, err := os.ReadFile(filepath.Join(c.path, "memory.peak")); err == nil {
+		peak, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	return &Usage{
+		CPUS:            float64(cpuStat["usage_usec"]) / 1e6,
+		MemoryPeakBytes: peak,
+		OOMKills:        memoryEvents["oom_kill"],
+	}, nil
+}
+
+// Remove kills the processes left in the cgroup, e.g. the children of a
+// hook, and removes it
+func (c *Cgroup) Remove() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.dir != nil {
+		c.dir.Close()
+	}
+
+	// cgroup.kill requires Linux 5.14, the cgroup can't be removed before its
+	// processes exited without it
+	writeFile(filepath.Join(c.path, "cgroup.kill"), "1")
+
+	var err error
+	for i := 0; i < removeAttempts; i++ {
+		if err = os.Remove(c.path); err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		time.Sleep(removeRetryDelay)
+	}
+
+	return fmt.Errorf("failed to remove the session cgroup: %w", err)
+}
+
+// writeFile writes to an interface file of a cgroup, which always exists
+func writeFile(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// readKeyedFile reads an interface file of "key value" lines, e.g. cpu.stat
+func readKeyedFile(path string) (map[string]int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]int64{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			values[key] = n
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
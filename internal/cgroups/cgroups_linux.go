@@ -0,0 +1,20 @@
+package cgroups
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const supported = true
+
+// setCgroup makes the process be created in the cgroup of dir, rather than
+// moved to it once started, so that none of its children escape it
+func setCgroup(cmd *exec.Cmd, dir *os.File) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+}
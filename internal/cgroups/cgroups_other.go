@@ -0,0 +1,12 @@
+//go:build !linux
+
+package cgroups
+
+import (
+	"os"
+	"os/exec"
+)
+
+const supported = false
+
+func setCgroup(cmd *exec.Cmd, dir *os.File) {}
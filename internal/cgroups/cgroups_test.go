@@ -0,0 +1,88 @@
+package cgroups
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestNewDisabled(t *testing.T) {
+	manager, err := New(config.SessionCgroupsConfig{})
+	require.NoError(t, err)
+	require.Nil(t, manager)
+
+	// A nil manager and cgroup leave the processes where they are
+	cgroup, err := manager.Create()
+	require.NoError(t, err)
+	require.Nil(t, cgroup)
+
+	cmd := exec.Command("true")
+	cgroup.Apply(cmd)
+	require.Nil(t, cmd.SysProcAttr)
+
+	usage, err := cgroup.Usage()
+	require.NoError(t, err)
+	require.Nil(t, usage)
+	require.NoError(t, cgroup.Remove())
+}
+
+func TestNew(t *testing.T) {
+	if !supported {
+		t.Skip("session cgroups are only supported on Linux")
+	}
+
+	dir := t.TempDir()
+
+	_, err := New(config.SessionCgroupsConfig{Enabled: true, Path: dir})
+	require.ErrorContains(t, err, "isn't a cgroup v2")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte("cpuset io memory pids\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), nil, 0o644))
+
+	_, err = New(config.SessionCgroupsConfig{Enabled: true, Path: dir, CPUPercent: 50})
+	require.EqualError(t, err, "the cpu controller isn't available in \""+dir+"\"")
+
+	manager, err := New(config.SessionCgroupsConfig{Enabled: true, Path: dir})
+	require.NoError(t, err)
+	require.NotNil(t, manager)
+
+	subtreeControl, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	require.NoError(t, err)
+	require.Equal(t, "+memory", string(subtreeControl))
+}
+
+func TestCPUMax(t *testing.T) {
+	require.Equal(t, "100000 100000", cpuMax(100))
+	require.Equal(t, "50000 100000", cpuMax(50))
+	require.Equal(t, "400000 100000", cpuMax(400))
+}
+
+func TestUsage(t *testing.T) {
+	dir := t.TempDir()
+	cgroup := &Cgroup{path: dir}
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0o644))
+
+	// memory.peak is missing before Linux 5.19
+	usage, err := cgroup.Usage()
+	require.NoError(t, err)
+	require.Equal(t, &Usage{CPUS: 1.5, OOMKills: 1}, usage)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "memory.peak"), []byte("104857600\n"), 0o644))
+
+	usage, err = cgroup.Usage()
+	require.NoError(t, err)
+	require.Equal(t, &Usage{CPUS: 1.5, MemoryPeakBytes: 104857600, OOMKills: 1}, usage)
+
+	for _, name := range []string{"cpu.stat", "memory.events", "memory.peak"} {
+		require.NoError(t, os.Remove(filepath.Join(dir, name)))
+	}
+	require.NoError(t, cgroup.Remove())
+	require.NoDirExists(t, dir)
+}
@@ -47,13 +47,22 @@ func (c *Command) printKeyLine(ctx context.Context) error {
 		return err
 	}
 
-	fmt.Fprintln(c.ReadWriter.Out, keyLine.ToString())
+	if err := keyLine.AddOptions(response.Options); err != nil {
+		return err
+	}
+
+	line, err := keyLine.ToString()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.ReadWriter.Out, line)
 
 	return nil
 }
 
 func (c *Command) getAuthorizedKey(ctx context.Context) (*authorizedkeys.Response, error) {
-	client, err := authorizedkeys.NewClient(c.Config)
+	client, err := authorizedkeys.NewGetter(c.Config)
 	if err != nil {
 		return nil, err
 	}
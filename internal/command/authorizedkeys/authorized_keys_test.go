@@ -26,6 +26,20 @@ var (
 						"key": "public-key",
 					}
 					json.NewEncoder(w).Encode(body)
+				} else if r.URL.Query().Get("key") == "restricted" {
+					body := map[string]interface{}{
+						"id":      2,
+						"key":     "restricted-key",
+						"options": []string{"no-user-rc", `expiry-time="20300101"`},
+					}
+					json.NewEncoder(w).Encode(body)
+				} else if r.URL.Query().Get("key") == "unrestricted" {
+					body := map[string]interface{}{
+						"id":      3,
+						"key":     "unrestricted-key",
+						"options": []string{"pty"},
+					}
+					json.NewEncoder(w).Encode(body)
 				} else if r.URL.Query().Get("key") == "broken-message" {
 					body := map[string]string{
 						"message": "Forbidden!",
@@ -57,6 +71,11 @@ func TestExecute(t *testing.T) {
 			arguments:      &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "user", Key: "key"},
 			expectedOutput: "command=\"/tmp/bin/gitlab-shell key-1\",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty public-key\n",
 		},
+		{
+			desc:           "With options restricting the key",
+			arguments:      &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "user", Key: "restricted"},
+			expectedOutput: "command=\"/tmp/bin/gitlab-shell key-2\",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty,no-user-rc,expiry-time=\"20300101\" restricted-key\n",
+		},
 		{
 			desc:           "When key doesn't match any existing key",
 			arguments:      &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "user", Key: "not-found"},
@@ -91,3 +110,18 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteWithInvalidOption(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	buffer := &bytes.Buffer{}
+	cmd := &Command{
+		Config:     &config.Config{RootDir: "/tmp", GitlabUrl: url},
+		Args:       &commandargs.AuthorizedKeys{ExpectedUser: "user", ActualUser: "user", Key: "unrestricted"},
+		ReadWriter: &readwriter.ReadWriter{Out: buffer},
+	}
+
+	_, err := cmd.Execute(context.Background())
+	require.EqualError(t, err, "Invalid option: pty")
+	require.Empty(t, buffer.String())
+}
@@ -42,7 +42,12 @@ func (c *Command) printPrincipalLine(principal string) error {
 		return err
 	}
 
-	fmt.Fprintln(c.ReadWriter.Out, principalKeyLine.ToString())
+	line, err := principalKeyLine.ToString()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.ReadWriter.Out, line)
 
 	return nil
 }
@@ -24,6 +24,7 @@ type LogMetadata struct {
 type LogData struct {
 	Username     string      `json:"username"`
 	WrittenBytes int64       `json:"written_bytes"`
+	ReadBytes    int64       `json:"read_bytes"`
 	Meta         LogMetadata `json:"meta"`
 }
 
@@ -38,6 +39,19 @@ func CheckForVersionFlag(osArgs []string, version, buildTime string) {
 	}
 }
 
+// CheckForPrintEffectiveConfigFlag prints the settings of cfg in effect and
+// exits when the only argument is -print-effective-config, like the flag of
+// gitlab-sshd.
+func CheckForPrintEffectiveConfigFlag(osArgs []string, cfg *config.Config) {
+	if len(osArgs) == 2 && (osArgs[1] == "-print-effective-config" || osArgs[1] == "--print-effective-config") {
+		if err := cfg.WriteEffective(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print the configuration: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+}
+
 // Setup() initializes tracing from the configuration file and generates a
 // background context from which all other contexts in the process should derive
 // from, as it has a service name and initial correlation ID set.
@@ -5,8 +5,10 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/mattn/go-shellwords"
+	"gitlab.com/gitlab-org/labkit/log"
+
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/pkg/sshcmd"
 )
 
 const (
@@ -14,17 +16,35 @@ const (
 	TwoFactorRecover    CommandType = "2fa_recovery_codes"
 	TwoFactorVerify     CommandType = "2fa_verify"
 	LfsAuthenticate     CommandType = "git-lfs-authenticate"
+	LfsTransfer         CommandType = "git-lfs-transfer"
 	ReceivePack         CommandType = "git-receive-pack"
 	UploadPack          CommandType = "git-upload-pack"
 	UploadArchive       CommandType = "git-upload-archive"
 	PersonalAccessToken CommandType = "personal_access_token"
+	Whoami              CommandType = "whoami"
+	Projects            CommandType = "projects"
+	Help                CommandType = "help"
+)
+
+// ActorType is the type of actor a key belongs to, for the policy of the
+// instance to treat them differently, e.g. to only let deploy keys run Git
+// commands
+type ActorType string
+
+const (
+	ActorUser      ActorType = "user"
+	ActorDeployKey ActorType = "deploy_key"
+	ActorCI        ActorType = "ci"
 )
 
 var (
+	// ActorTypes lists the known actor types
+	ActorTypes = []ActorType{ActorUser, ActorDeployKey, ActorCI}
+
 	whoKeyRegex      = regexp.MustCompile(`\Akey-(?P<keyid>\d+)\z`)
 	whoUsernameRegex = regexp.MustCompile(`\Ausername-(?P<username>\S+)\z`)
 
-	GitCommands = []CommandType{LfsAuthenticate, UploadPack, ReceivePack, UploadArchive}
+	GitCommands = []CommandType{LfsAuthenticate, LfsTransfer, UploadPack, ReceivePack, UploadArchive}
 )
 
 type Shell struct {
@@ -35,6 +55,9 @@ type Shell struct {
 	SshArgs             []string
 	CommandType         CommandType
 	Env                 sshenv.Env
+	// ActorType is the type of the owner of the key used to authenticate,
+	// empty when unknown
+	ActorType ActorType
 }
 
 func (s *Shell) Parse() error {
@@ -51,6 +74,27 @@ func (s *Shell) GetArguments() []string {
 	return s.Arguments
 }
 
+// LogFields returns the fields identifying the requested command and its
+// caller, suitable for attaching to every log line of a session.
+func (s *Shell) LogFields() log.Fields {
+	fields := log.Fields{"command": s.CommandType}
+
+	if s.GitlabKeyId != "" {
+		fields["gl_key_id"] = s.GitlabKeyId
+	}
+	if s.GitlabUsername != "" {
+		fields["username"] = s.GitlabUsername
+	}
+	if s.GitlabKrb5Principal != "" {
+		fields["krb5principal"] = s.GitlabKrb5Principal
+	}
+	if s.ActorType != "" {
+		fields["actor_type"] = s.ActorType
+	}
+
+	return fields
+}
+
 func (s *Shell) validate() error {
 	if !s.Env.IsSSHConnection {
 		return fmt.Errorf("Only SSH allowed")
@@ -103,20 +147,12 @@ func tryParseUsername(argument string) string {
 }
 
 func (s *Shell) ParseCommand(commandString string) error {
-	args, err := shellwords.Parse(commandString)
+	command, err := sshcmd.Parse(commandString)
 	if err != nil {
 		return err
 	}
 
-	// Handle Git for Windows 2.14 using "git upload-pack" instead of git-upload-pack
-	if len(args) > 1 && args[0] == "git" {
-		command := args[0] + "-" + args[1]
-		commandArgs := args[2:]
-
-		args = append([]string{command}, commandArgs...)
-	}
-
-	s.SshArgs = args
+	s.SshArgs = command.Argv()
 
 	s.defineCommandType()
 
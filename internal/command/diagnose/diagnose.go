@@ -0,0 +1,352 @@
+// Package diagnose implements `gitlab-shell diagnose`, which gathers what
+// support needs to investigate an incident into a single tarball: the
+// sanitized configuration, the end of the log, the version, the results of
+// connectivity checks and the fingerprints of the host keys.
+package diagnose
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"gopkg.in/yaml.v3"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitaly"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/healthcheck"
+)
+
+// Subcommand is the argument of gitlab-shell running this command
+const Subcommand = "diagnose"
+
+const (
+	redacted = "[REDACTED]"
+
+	defaultLogLines = 1000
+	// maxLogTail bounds the bytes of the log read to find its last lines
+	maxLogTail   = 10 * 1024 * 1024
+	checkTimeout = 10 * time.Second
+)
+
+type Command struct {
+	Config     *config.Config
+	Args       []string
+	ReadWriter *readwriter.ReadWriter
+	Version    string
+	BuildTime  string
+}
+
+// section is a file of the bundle. Sections that can't be gathered hold the
+// error instead, so that the bundle is produced anyway.
+type section struct {
+	name    string
+	content []byte
+}
+
+func (c *Command) Execute(ctx context.Context) (context.Context, error) {
+	flags := flag.NewFlagSet("gitlab-shell diagnose", flag.ContinueOnError)
+	flags.SetOutput(c.ReadWriter.ErrOut)
+	now := time.Now().UTC()
+	output := flags.String("output", fmt.Sprintf("gitlab-shell-diagnostics-%s.tar.gz", now.Format("20060102-150405")), "the path of the tarball to write")
+	logLines := flags.Int("log-lines", defaultLogLines, "the number of lines of the end of the log to include")
+	if err := flags.Parse(c.Args); err != nil {
+		// The usage was printed by the flags
+		if errors.Is(err, flag.ErrHelp) {
+			return ctx, nil
+		}
+
+		return ctx, err
+	}
+
+	sections := []section{
+		{name: "version.txt", content: c.version()},
+		{name: "config.yml", content: c.config()},
+		{name: "log.txt", content: c.log(*logLines)},
+		{name: "connectivity.txt", content: c.connectivity(ctx)},
+		{name: "host_keys.txt", content: c.hostKeys()},
+	}
+
+	if err := writeTarball(*output, now, sections); err != nil {
+		return ctx, fmt.Errorf("failed to write the diagnostics bundle: %w", err)
+	}
+
+	fmt.Fprintf(c.ReadWriter.Out, "Diagnostics bundle written to %s\n", *output)
+
+	return ctx, nil
+}
+
+func (c *Command) version() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "gitlab-shell: %s-%s\n", c.Version, c.BuildTime)
+	fmt.Fprintf(&b, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if hostname, err := os.Hostname(); err == nil {
+		fmt.Fprintf(&b, "hostname: %s\n", hostname)
+	}
+
+	return b.Bytes()
+}
+
+// config returns the configuration file as YAML, whatever its format, with
+// the values of the secrets, and the passwords of the URLs, redacted
+func (c *Command) config() []byte {
+	path := c.Config.File()
+	raw, err := config.ReadFile(path)
+	if err != nil {
+		return failed(err)
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(raw, &document); err != nil {
+		return failed(fmt.Errorf("failed to parse %s: %w", path, err))
+	}
+	redact(&document)
+
+	sanitized, err := yaml.Marshal(&document)
+	if err != nil {
+		return failed(err)
+	}
+
+	return sanitized
+}
+
+func redact(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if isSecret(key.Value) && value.Kind == yaml.ScalarNode && value.Value != "" {
+				value.Value = redacted
+				value.Tag = "!!str"
+				value.Style = 0
+				continue
+			}
+
+			redact(value)
+		}
+	case yaml.ScalarNode:
+		if u, err := url.Parse(node.Value); err == nil && u.User != nil {
+			node.Value = u.Redacted()
+		}
+	default:
+		for _, child := range node.Content {
+			redact(child)
+		}
+	}
+}
+
+func isSecret(key string) bool {
+	for _, name := range []string{"secret", "password", "token"} {
+		if key == name || strings.HasSuffix(key, "_"+name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// log returns the last lines of the log file
+func (c *Command) log(lines int) []byte {
+	path := c.Config.LogFile
+	info, err := os.Stat(path)
+	if err != nil {
+		return failed(err)
+	}
+	if !info.Mode().IsRegular() {
+		return failed(fmt.Errorf("%s isn't a regular file, collect the log where it's written to", path))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return failed(err)
+	}
+	defer f.Close()
+
+	// The first line read after seeking is likely cut, so it's skipped
+	seeked := info.Size() > maxLogTail
+	if seeked {
+		if _, err := f.Seek(info.Size()-maxLogTail, io.SeekStart); err != nil {
+			return failed(err)
+		}
+	}
+
+	var tail []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxLogTail)
+	for scanner.Scan() {
+		if seeked {
+			seeked = false
+			continue
+		}
+
+		tail = append(tail, scanner.Text())
+		if len(tail) > lines {
+			tail = tail[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return failed(err)
+	}
+
+	if len(tail) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(tail, "\n") + "\n")
+}
+
+// connectivity checks that the internal API, and the Gitaly servers checked
+// by the readiness probe, can be reached
+func (c *Command) connectivity(ctx context.Context) []byte {
+	var b bytes.Buffer
+
+	apiCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	if response, err := checkAPI(apiCtx, c.Config); err != nil {
+		fmt.Fprintf(&b, "Internal API %s: FAILED - %v\n", c.Config.GitlabUrl, err)
+	} else {
+		fmt.Fprintf(&b, "Internal API %s: OK (GitLab %s, revision %s, API %s, Redis available: %t)\n",
+			c.Config.GitlabUrl, response.GitlabVersion, response.GitlabRevision, response.APIVersion, response.Redis)
+	}
+
+	checks := c.Config.Server.ReadinessChecks
+	for _, address := range checks.GitalyAddresses {
+		gitalyCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		err := checkGitaly(gitalyCtx, c.Config, address, checks.GitalyToken)
+		cancel()
+
+		if err != nil {
+			fmt.Fprintf(&b, "Gitaly %s: FAILED - %v\n", address, err)
+		} else {
+			fmt.Fprintf(&b, "Gitaly %s: OK\n", address)
+		}
+	}
+
+	return b.Bytes()
+}
+
+func checkAPI(ctx context.Context, cfg *config.Config) (*healthcheck.Response, error) {
+	client, err := healthcheck.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Check(ctx)
+}
+
+func checkGitaly(ctx context.Context, cfg *config.Config, address, token string) error {
+	conn, err := cfg.GitalyClient.GetConnection(ctx, gitaly.Command{ServiceName: "diagnose", Address: address, Token: token})
+	if err != nil {
+		return err
+	}
+
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+
+	if response.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("Gitaly is %s", response.Status)
+	}
+
+	return nil
+}
+
+// hostKeys returns the fingerprints of the host keys of gitlab-sshd. The
+// public key next to a private key that can't be read is used instead.
+func (c *Command) hostKeys() []byte {
+	var b bytes.Buffer
+	for _, path := range c.Config.Server.HostKeyFiles {
+		key, err := readHostKey(path)
+		if err != nil {
+			fmt.Fprintf(&b, "%s: FAILED - %v\n", path, err)
+			continue
+		}
+
+		fmt.Fprintf(&b, "%s: %s %s\n", path, key.Type(), ssh.FingerprintSHA256(key))
+	}
+
+	return b.Bytes()
+}
+
+func readHostKey(path string) (ssh.PublicKey, error) {
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		return signer.PublicKey(), nil
+	}
+
+	raw, pubErr := os.ReadFile(path + ".pub")
+	if pubErr != nil {
+		return nil, err
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(raw)
+
+	return key, err
+}
+
+func failed(err error) []byte {
+	return []byte(fmt.Sprintf("FAILED - %v\n", err))
+}
+
+func writeTarball(path string, modTime time.Time, sections []section) (err error) {
+	// The bundle holds the configuration, which is only readable by its owner
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		// Incomplete bundles aren't left behind
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	dir := strings.TrimSuffix(filepath.Base(path), ".tar.gz")
+	for _, s := range sections {
+		header := &tar.Header{
+			Name:    dir + "/" + s.name,
+			Mode:    0o600,
+			Size:    int64(len(s.content)),
+			ModTime: modTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(s.content); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
@@ -9,6 +9,7 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/discover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/usermessage"
 )
 
 type Command struct {
@@ -20,17 +21,21 @@ type Command struct {
 func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 	response, err := c.getUserInfo(ctx)
 	if err != nil {
-		return ctx, fmt.Errorf("Failed to get username: %v", err)
+		return ctx, fmt.Errorf("Failed to get username: %w", err)
 	}
 
 	logData := command.LogData{}
+	var welcome string
+	data := usermessage.Data{}
 	if response.IsAnonymous() {
 		logData.Username = "Anonymous"
-		fmt.Fprintf(c.ReadWriter.Out, "Welcome to GitLab, Anonymous!\n")
+		welcome = "Welcome to GitLab, Anonymous!"
 	} else {
 		logData.Username = response.Username
-		fmt.Fprintf(c.ReadWriter.Out, "Welcome to GitLab, @%s!\n", response.Username)
+		data.Username = response.Username
+		welcome = fmt.Sprintf("Welcome to GitLab, @%s!", response.Username)
 	}
+	fmt.Fprintln(c.ReadWriter.Out, usermessage.Render(ctx, c.Config.Messages, usermessage.Welcome, data, welcome))
 
 	ctxWithLogData := context.WithValue(ctx, "logData", logData)
 
@@ -38,7 +43,7 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 }
 
 func (c *Command) getUserInfo(ctx context.Context) (*discover.Response, error) {
-	client, err := discover.NewClient(c.Config)
+	client, err := discover.New(c.Config)
 	if err != nil {
 		return nil, err
 	}
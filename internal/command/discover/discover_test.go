@@ -95,6 +95,35 @@ func TestExecute(t *testing.T) {
 	}
 }
 
+func TestExecuteWithMessageOverride(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	for _, tc := range []struct {
+		desc           string
+		arguments      *commandargs.Shell
+		expectedOutput string
+	}{
+		{desc: "With a known username", arguments: &commandargs.Shell{GitlabUsername: "alex-doe"}, expectedOutput: "Welcome to ACME Git, @alex-doe!\n"},
+		{desc: "With an unknown username", arguments: &commandargs.Shell{GitlabUsername: "unknown"}, expectedOutput: "Welcome to ACME Git, stranger!\n"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			cmd := &Command{
+				Config: &config.Config{
+					GitlabUrl: url,
+					Messages:  map[string]string{"welcome": "Welcome to ACME Git, {{with .Username}}@{{.}}{{else}}stranger{{end}}!"},
+				},
+				Args:       tc.arguments,
+				ReadWriter: &readwriter.ReadWriter{Out: buffer},
+			}
+
+			_, err := cmd.Execute(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOutput, buffer.String())
+		})
+	}
+}
+
 func TestFailingExecute(t *testing.T) {
 	url := testserver.StartSocketHttpServer(t, requests)
 
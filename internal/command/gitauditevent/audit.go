@@ -8,13 +8,14 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/accessverifier"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/gitauditevent"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
 	"gitlab.com/gitlab-org/labkit/log"
 )
 
 // Audit is called conditionally during `git-receive-pack` and `git-upload-pack` to generate streaming audit events.
 // Errors are not propagated since this is more a logging process.
-func Audit(ctx context.Context, commandType commandargs.CommandType, c *config.Config, response *accessverifier.Response, packfileStats *pb.PackfileNegotiationStatistics) {
-	ctxlog := log.WithContextFields(ctx, log.Fields{
+func Audit(ctx context.Context, commandType commandargs.CommandType, c *config.Config, response *accessverifier.Response, packfileStats *pb.PackfileNegotiationStatistics, pushOptions []string) {
+	ctxlog := logger.WithContextFields(ctx, log.Fields{
 		"gl_repository": response.Repo,
 		"command":       commandType,
 		"username":      response.Username,
@@ -28,7 +29,7 @@ func Audit(ctx context.Context, commandType commandargs.CommandType, c *config.C
 		return
 	}
 
-	errOnlyLog = gitAuditClient.Audit(ctx, response.Username, commandType, response.Repo, packfileStats)
+	errOnlyLog = gitAuditClient.Audit(ctx, response.Username, commandType, response.Repo, packfileStats, pushOptions)
 	if errOnlyLog != nil {
 		ctxlog.Errorf("failed to audit git event: %v", errOnlyLog)
 		return
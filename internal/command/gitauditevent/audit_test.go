@@ -51,7 +51,7 @@ func TestGitAudit(t *testing.T) {
 	Audit(context.Background(), s.CommandType, &config.Config{GitlabUrl: url}, &accessverifier.Response{
 		Username: testUsername,
 		Repo:     testRepo,
-	}, nil)
+	}, nil, nil)
 
 	require.True(t, called)
 }
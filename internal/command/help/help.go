@@ -0,0 +1,60 @@
+package help
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+type Command struct {
+	Config     *config.Config
+	Args       *commandargs.Shell
+	ReadWriter *readwriter.ReadWriter
+}
+
+func (c *Command) Execute(ctx context.Context) (context.Context, error) {
+	return ctx, Write(ctx, c.ReadWriter.Out, c.Config, c.Args)
+}
+
+// Write lists the registered commands with a description that the user
+// identified by args can run: those not disabled by the policy of the
+// instance, nor by their feature flag
+func Write(ctx context.Context, out io.Writer, cfg *config.Config, args *commandargs.Shell) error {
+	fmt.Fprint(out, "Available commands:\n")
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, registration := range command.Registered() {
+		if registration.Description == "" {
+			continue
+		}
+		if cfg != nil && cfg.Commands.IsDisabledFor(string(registration.Name), actorType(args)) {
+			continue
+		}
+		if !registration.Enabled(ctx, cfg, args) {
+			continue
+		}
+
+		fmt.Fprintf(w, "  %s\t%s\n", registration.Usage, registration.Description)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprint(out, "\nGit commands, such as git clone and git push, are run by Git.\n")
+
+	return err
+}
+
+func actorType(args *commandargs.Shell) string {
+	if args == nil {
+		return ""
+	}
+
+	return string(args.ActorType)
+}
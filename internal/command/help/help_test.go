@@ -0,0 +1,79 @@
+package help
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// The fake commands registered here stand for the built-in ones, which
+// cmd/gitlab-shell/command registers and tests help with
+func init() {
+	build := func(args *commandargs.Shell, cfg *config.Config, rw *readwriter.ReadWriter) command.Command {
+		return &Command{Config: cfg, Args: args, ReadWriter: rw}
+	}
+
+	for _, registration := range []command.Registration{
+		{Name: "whoami", Usage: "whoami", Description: "Show who you are", Build: build},
+		{Name: "git-upload-pack", Build: build},
+		{Name: "projects", Usage: "projects [--page <page>]", Description: "List your projects", Build: build},
+		{Name: "beta", Usage: "beta", Description: "Try the beta", FeatureFlag: "ssh_beta", Build: build},
+	} {
+		if err := command.Register(registration); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	output := &bytes.Buffer{}
+	cmd := &Command{Config: &config.Config{}, ReadWriter: &readwriter.ReadWriter{Out: output}}
+
+	_, err := cmd.Execute(context.Background())
+	require.NoError(t, err)
+
+	require.Equal(t, `Available commands:
+  whoami                    Show who you are
+  projects [--page <page>]  List your projects
+
+Git commands, such as git clone and git push, are run by Git.
+`, output.String())
+}
+
+func TestWriteWithDisabledCommands(t *testing.T) {
+	output := &bytes.Buffer{}
+	cfg := &config.Config{Commands: config.CommandsConfig{Disabled: []string{"projects"}}}
+
+	require.NoError(t, Write(context.Background(), output, cfg, nil))
+	require.Equal(t, `Available commands:
+  whoami  Show who you are
+
+Git commands, such as git clone and git push, are run by Git.
+`, output.String())
+
+	output.Reset()
+	cfg = &config.Config{Commands: config.CommandsConfig{Allowed: []string{"projects", "git-upload-pack"}}}
+
+	require.NoError(t, Write(context.Background(), output, cfg, nil))
+	require.Equal(t, `Available commands:
+  projects [--page <page>]  List your projects
+
+Git commands, such as git clone and git push, are run by Git.
+`, output.String())
+}
+
+func TestWriteWithFeatureFlags(t *testing.T) {
+	output := &bytes.Buffer{}
+	cfg := &config.Config{GitlabUrl: "http+unix://gitlab.socket"}
+	cfg.FeatureFlags.Defaults = map[string]bool{"ssh_beta": true}
+
+	require.NoError(t, Write(context.Background(), output, cfg, &commandargs.Shell{GitlabKeyId: "1"}))
+	require.Contains(t, output.String(), "  beta                      Try the beta\n")
+}
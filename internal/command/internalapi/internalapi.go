@@ -0,0 +1,202 @@
+// Package internalapi implements `gitlab-shell api`, which lets operators
+// query the internal API the way gitlab-shell does, e.g. to find out why a
+// user can't push, without building the requests by hand.
+package internalapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/authorizedkeys"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/discover"
+)
+
+// Subcommand is the argument of gitlab-shell running this command
+const Subcommand = "api"
+
+const usage = `Usage: gitlab-shell api <command> [flags]
+
+Commands:
+  discover         Look up the user of a key, username or Kerberos principal
+  authorized-keys  Look up an SSH key
+  access           Check whether a user can run a git command on a project
+
+Run gitlab-shell api <command> -h for the flags of a command.`
+
+type Command struct {
+	Config     *config.Config
+	Args       []string
+	ReadWriter *readwriter.ReadWriter
+}
+
+// who are the flags identifying the user a request is made for
+type who struct {
+	keyId         string
+	username      string
+	krb5Principal string
+}
+
+func (c *Command) Execute(ctx context.Context) (context.Context, error) {
+	if len(c.Args) == 0 {
+		return ctx, errors.New(usage)
+	}
+
+	var err error
+	switch c.Args[0] {
+	case "discover":
+		err = c.discover(ctx, c.Args[1:])
+	case "authorized-keys":
+		err = c.authorizedKeys(ctx, c.Args[1:])
+	case "access":
+		err = c.access(ctx, c.Args[1:])
+	default:
+		err = fmt.Errorf("unknown command %q\n\n%s", c.Args[0], usage)
+	}
+
+	// The usage was printed by the flags
+	if errors.Is(err, flag.ErrHelp) {
+		return ctx, nil
+	}
+
+	return ctx, err
+}
+
+func (c *Command) discover(ctx context.Context, args []string) error {
+	flags := c.flagSet("discover")
+	var w who
+	w.register(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	shellArgs, err := w.shellArgs()
+	if err != nil {
+		return err
+	}
+
+	client, err := discover.New(c.Config)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.GetByCommandArgs(ctx, shellArgs)
+	if err != nil {
+		return err
+	}
+
+	return c.print(response)
+}
+
+func (c *Command) authorizedKeys(ctx context.Context, args []string) error {
+	flags := c.flagSet("authorized-keys")
+	key := flags.String("key", "", "the base64-encoded public key, without its type and comment")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *key == "" {
+		return errors.New("--key is required")
+	}
+
+	client, err := authorizedkeys.NewGetter(c.Config)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.GetByKey(ctx, *key)
+	if err != nil {
+		return err
+	}
+
+	return c.print(response)
+}
+
+func (c *Command) access(ctx context.Context, args []string) error {
+	flags := c.flagSet("access")
+	var w who
+	w.register(flags)
+	project := flags.String("project", "", "the full path of the project, e.g. group/project")
+	action := flags.String("action", string(commandargs.UploadPack), "the git command: git-upload-pack, git-receive-pack or git-upload-archive")
+	checkIP := flags.String("check-ip", "", "the IP address the user connects from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *project == "" {
+		return errors.New("--project is required")
+	}
+
+	switch commandargs.CommandType(*action) {
+	case commandargs.UploadPack, commandargs.ReceivePack, commandargs.UploadArchive:
+	default:
+		return fmt.Errorf("unknown --action %q", *action)
+	}
+
+	shellArgs, err := w.shellArgs()
+	if err != nil {
+		return err
+	}
+	shellArgs.Env.RemoteAddr = *checkIP
+
+	client, err := accessverifier.NewClient(c.Config)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Verify(ctx, shellArgs, commandargs.CommandType(*action), *project)
+	if err != nil {
+		return err
+	}
+
+	if response.Gitaly.Token != "" {
+		response.Gitaly.Token = "[REDACTED]"
+	}
+
+	return c.print(response)
+}
+
+func (c *Command) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("gitlab-shell api "+name, flag.ContinueOnError)
+	flags.SetOutput(c.ReadWriter.ErrOut)
+
+	return flags
+}
+
+func (c *Command) print(response interface{}) error {
+	encoder := json.NewEncoder(c.ReadWriter.Out)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(response)
+}
+
+func (w *who) register(flags *flag.FlagSet) {
+	flags.StringVar(&w.keyId, "key-id", "", "the ID of the user's SSH key")
+	flags.StringVar(&w.username, "username", "", "the username")
+	flags.StringVar(&w.krb5Principal, "krb5-principal", "", "the user's Kerberos principal")
+}
+
+func (w *who) shellArgs() (*commandargs.Shell, error) {
+	given := 0
+	for _, value := range []string{w.keyId, w.username, w.krb5Principal} {
+		if value != "" {
+			given++
+		}
+	}
+
+	if given != 1 {
+		return nil, errors.New("exactly one of --key-id, --username and --krb5-principal is required")
+	}
+
+	return &commandargs.Shell{
+		GitlabKeyId:         w.keyId,
+		GitlabUsername:      w.username,
+		GitlabKrb5Principal: w.krb5Principal,
+	}, nil
+}
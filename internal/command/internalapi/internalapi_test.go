@@ -0,0 +1,128 @@
+package internalapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+var requests = []testserver.TestRequestHandler{
+	{
+		Path: "/api/v4/internal/discover",
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("key_id") != "1" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 2, "username": "alex-doe", "name": "Alex Doe"})
+		},
+	},
+	{
+		Path: "/api/v4/internal/authorized_keys",
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 1, "key": "ssh-rsa " + r.URL.Query().Get("key")})
+		},
+	},
+	{
+		Path: "/api/v4/internal/allowed",
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			var request map[string]string
+			json.NewDecoder(r.Body).Decode(&request)
+
+			if request["action"] == "git-receive-pack" {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]interface{}{"status": false, "message": "You are not allowed to push code to this project."})
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":        true,
+				"gl_repository": "project-1",
+				"gl_username":   request["username"],
+				"gitaly":        map[string]interface{}{"address": "unix:gitaly.socket", "token": "gitaly-token"},
+			})
+		},
+	},
+}
+
+func execute(t *testing.T, args ...string) (string, error) {
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	output := &bytes.Buffer{}
+	cmd := &Command{
+		Config:     &config.Config{GitlabUrl: url},
+		Args:       args,
+		ReadWriter: &readwriter.ReadWriter{Out: output, ErrOut: io.Discard},
+	}
+
+	_, err := cmd.Execute(context.Background())
+
+	return output.String(), err
+}
+
+func TestDiscover(t *testing.T) {
+	output, err := execute(t, "discover", "--key-id", "1")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id": 2, "username": "alex-doe", "name": "Alex Doe"}`, output)
+}
+
+func TestAuthorizedKeys(t *testing.T) {
+	output, err := execute(t, "authorized-keys", "--key", "AAAAB3NzaC1yc2E")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id": 1, "key": "ssh-rsa AAAAB3NzaC1yc2E"}`, output)
+
+	_, err = execute(t, "authorized-keys")
+	require.EqualError(t, err, "--key is required")
+}
+
+func TestAccess(t *testing.T) {
+	output, err := execute(t, "access", "--username", "alex-doe", "--project", "group/project")
+	require.NoError(t, err)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(output), &response))
+	require.Equal(t, true, response["status"])
+	require.Equal(t, "alex-doe", response["gl_username"])
+	require.Equal(t, "[REDACTED]", response["gitaly"].(map[string]interface{})["token"])
+
+	_, err = execute(t, "access", "--username", "alex-doe", "--project", "group/project", "--action", "git-receive-pack")
+	require.EqualError(t, err, "You are not allowed to push code to this project.")
+}
+
+func TestInvalidArguments(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		args        []string
+		expectedErr string
+	}{
+		{desc: "no command", expectedErr: usage},
+		{desc: "unknown command", args: []string{"projects"}, expectedErr: "unknown command \"projects\"\n\n" + usage},
+		{desc: "no user", args: []string{"discover"}, expectedErr: "exactly one of --key-id, --username and --krb5-principal is required"},
+		{desc: "several users", args: []string{"discover", "--key-id", "1", "--username", "alex-doe"}, expectedErr: "exactly one of --key-id, --username and --krb5-principal is required"},
+		{desc: "no project", args: []string{"access", "--key-id", "1"}, expectedErr: "--project is required"},
+		{desc: "unknown action", args: []string{"access", "--key-id", "1", "--project", "group/project", "--action", "git-lfs-transfer"}, expectedErr: `unknown --action "git-lfs-transfer"`},
+		{desc: "unknown flag", args: []string{"discover", "--user", "alex-doe"}, expectedErr: "flag provided but not defined: -user"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := execute(t, tc.args...)
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestHelp(t *testing.T) {
+	_, err := execute(t, "access", "-h")
+	require.NoError(t, err)
+}
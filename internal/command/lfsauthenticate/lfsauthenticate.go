@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"gitlab.com/gitlab-org/labkit/log"
 
@@ -15,11 +16,14 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/lfsauthenticate"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
 )
 
 const (
 	downloadOperation = "download"
 	uploadOperation   = "upload"
+
+	transferFlag = "--transfer="
 )
 
 type Command struct {
@@ -28,14 +32,12 @@ type Command struct {
 	ReadWriter *readwriter.ReadWriter
 }
 
-type PayloadHeader struct {
-	Auth string `json:"Authorization"`
-}
-
 type Payload struct {
-	Header    PayloadHeader `json:"header"`
-	Href      string        `json:"href"`
-	ExpiresIn int           `json:"expires_in,omitempty"`
+	Header    map[string]string `json:"header"`
+	Href      string            `json:"href"`
+	ExpiresIn int               `json:"expires_in,omitempty"`
+	ExpiresAt string            `json:"expires_at,omitempty"`
+	Transfer  string            `json:"transfer,omitempty"`
 }
 
 func (c *Command) Execute(ctx context.Context) (context.Context, error) {
@@ -44,9 +46,10 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 		return ctx, disallowedcommand.Error
 	}
 
-	// e.g. git-lfs-authenticate user/repo.git download
+	// e.g. git-lfs-authenticate user/repo.git download [--transfer=basic,ssh]
 	repo := args[1]
 	operation := args[2]
+	transfers := parseTransfers(args[3:])
 
 	action, err := actionFromOperation(operation)
 	if err != nil {
@@ -64,10 +67,10 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 	)
 	ctxWithLogData := context.WithValue(ctx, "logData", logData)
 
-	payload, err := c.authenticate(ctx, operation, repo, accessResponse.UserId)
+	payload, err := c.authenticate(ctx, operation, repo, accessResponse.UserId, transfers)
 	if err != nil {
 		// return nothing just like Ruby's GitlabShell#lfs_authenticate does
-		log.WithContextFields(
+		logger.WithContextFields(
 			ctx,
 			log.Fields{"operation": operation, "repo": repo, "user_id": accessResponse.UserId},
 		).WithError(err).Debug("lfsauthenticate: execute: LFS authentication failed")
@@ -95,28 +98,63 @@ func actionFromOperation(operation string) (commandargs.CommandType, error) {
 	return action, nil
 }
 
+// parseTransfers returns the transfer adapters offered by the client. Unknown
+// arguments are ignored so that newer clients keep working.
+func parseTransfers(args []string) []string {
+	var transfers []string
+
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, transferFlag) {
+			continue
+		}
+
+		for _, transfer := range strings.Split(strings.TrimPrefix(arg, transferFlag), ",") {
+			if transfer != "" {
+				transfers = append(transfers, transfer)
+			}
+		}
+	}
+
+	return transfers
+}
+
 func (c *Command) verifyAccess(ctx context.Context, action commandargs.CommandType, repo string) (*accessverifier.Response, error) {
 	cmd := accessverifier.Command{c.Config, c.Args, c.ReadWriter}
 
 	return cmd.Verify(ctx, action, repo)
 }
 
-func (c *Command) authenticate(ctx context.Context, operation string, repo, userId string) ([]byte, error) {
+func (c *Command) authenticate(ctx context.Context, operation string, repo, userId string, transfers []string) ([]byte, error) {
 	client, err := lfsauthenticate.NewClient(c.Config, c.Args)
 	if err != nil {
 		return nil, err
 	}
 
-	response, err := client.Authenticate(ctx, operation, repo, userId)
+	response, err := client.Authenticate(ctx, operation, repo, userId, transfers)
 	if err != nil {
 		return nil, err
 	}
 
 	basicAuth := base64.StdEncoding.EncodeToString([]byte(response.Username + ":" + response.LfsToken))
+	header := map[string]string{"Authorization": "Basic " + basicAuth}
+
+	// Headers sent along with an href override take precedence, object
+	// storage may expect its own credentials
+	for name, value := range response.Header {
+		header[name] = value
+	}
+
+	href := response.Href
+	if href == "" {
+		href = response.RepoPath + "/info/lfs"
+	}
+
 	payload := &Payload{
-		Header:    PayloadHeader{Auth: "Basic " + basicAuth},
-		Href:      response.RepoPath + "/info/lfs",
+		Header:    header,
+		Href:      href,
 		ExpiresIn: response.ExpiresIn,
+		ExpiresAt: response.ExpiresAt,
+		Transfer:  response.Transfer,
 	}
 
 	return json.Marshal(payload)
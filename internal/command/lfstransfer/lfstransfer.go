@@ -0,0 +1,102 @@
+package lfstransfer
+
+import (
+	"context"
+	"encoding/base64"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/lfsauthenticate"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/lfstransfer"
+)
+
+// Command serves Git LFS objects over SSH, so that clients do not need an
+// HTTPS endpoint to transfer them. The objects are stored by GitLab, which is
+// reached through its LFS API with a token issued by /lfs_authenticate.
+type Command struct {
+	Config     *config.Config
+	Args       *commandargs.Shell
+	ReadWriter *readwriter.ReadWriter
+}
+
+func (c *Command) Execute(ctx context.Context) (context.Context, error) {
+	args := c.Args.SshArgs
+	if len(args) != 3 {
+		return ctx, disallowedcommand.Error
+	}
+
+	// e.g. git-lfs-transfer user/repo.git download
+	repo := args[1]
+	operation := args[2]
+
+	action, err := actionFromOperation(operation)
+	if err != nil {
+		return ctx, err
+	}
+
+	accessResponse, err := c.verifyAccess(ctx, action, repo)
+	if err != nil {
+		return ctx, err
+	}
+
+	logData := command.NewLogData(
+		accessResponse.Gitaly.Repo.GlProjectPath,
+		accessResponse.Username,
+	)
+	ctxWithLogData := context.WithValue(ctx, "logData", logData)
+
+	client, err := c.newClient(ctx, operation, repo, accessResponse.UserId)
+	if err != nil {
+		return ctxWithLogData, err
+	}
+
+	s := newSession(client, operation, c.ReadWriter.In, c.ReadWriter.Out)
+
+	return ctxWithLogData, s.serve(ctx)
+}
+
+func actionFromOperation(operation string) (commandargs.CommandType, error) {
+	switch operation {
+	case lfstransfer.DownloadAction:
+		return commandargs.UploadPack, nil
+	case lfstransfer.UploadAction:
+		return commandargs.ReceivePack, nil
+	}
+
+	return "", disallowedcommand.Error
+}
+
+func (c *Command) verifyAccess(ctx context.Context, action commandargs.CommandType, repo string) (*accessverifier.Response, error) {
+	cmd := accessverifier.Command{Config: c.Config, Args: c.Args, ReadWriter: c.ReadWriter}
+
+	return cmd.Verify(ctx, action, repo)
+}
+
+func (c *Command) newClient(ctx context.Context, operation, repo, userId string) (*lfstransfer.Client, error) {
+	client, err := lfsauthenticate.NewClient(c.Config, c.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.Authenticate(ctx, operation, repo, userId, []string{"basic"})
+	if err != nil {
+		return nil, err
+	}
+
+	basicAuth := base64.StdEncoding.EncodeToString([]byte(response.Username + ":" + response.LfsToken))
+	headers := map[string]string{"Authorization": "Basic " + basicAuth}
+	for name, value := range response.Header {
+		headers[name] = value
+	}
+
+	href := response.Href
+	if href == "" {
+		href = response.RepoPath + "/info/lfs"
+	}
+
+	return lfstransfer.NewClient(c.Config, href, headers)
+}
@@ -0,0 +1,259 @@
+package lfstransfer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/lfsauthenticate"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/lfstransfer"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
+)
+
+const (
+	oid           = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	missingOid    = "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"
+	objectContent = "test"
+)
+
+func setup(t *testing.T) string {
+	var url string
+	var stored bool
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/allowed",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				body := map[string]interface{}{
+					"gl_id":       "user-1",
+					"status":      true,
+					"gl_username": "alex-doe",
+					"gitaly": map[string]interface{}{
+						"repository": map[string]interface{}{
+							"gl_project_path": "group/project-path",
+						},
+					},
+				}
+				require.NoError(t, json.NewEncoder(w).Encode(body))
+			},
+		},
+		{
+			Path: "/api/v4/internal/lfs_authenticate",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var request *lfsauthenticate.Request
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+				require.Equal(t, []string{"basic"}, request.Transfers)
+
+				body := map[string]interface{}{
+					"username":             "john",
+					"lfs_token":            "sometoken",
+					"repository_http_path": url + "/group/repo.git",
+				}
+				require.NoError(t, json.NewEncoder(w).Encode(body))
+			},
+		},
+		{
+			Path: "/group/repo.git/info/lfs/objects/batch",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "Basic am9objpzb21ldG9rZW4=", r.Header.Get("Authorization"))
+
+				var request *lfstransfer.BatchRequest
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+				response := &lfstransfer.BatchResponse{}
+				for _, object := range request.Objects {
+					responseObject := &lfstransfer.ResponseObject{Oid: object.Oid, Size: object.Size}
+
+					switch {
+					case object.Oid == missingOid && request.Operation == "download":
+						responseObject.Error = &lfstransfer.ObjectError{Code: http.StatusNotFound, Message: "Object does not exist"}
+					case object.Oid == oid && request.Operation == "download":
+						responseObject.Size = int64(len(objectContent))
+						responseObject.Actions = map[string]*lfstransfer.Action{"download": {Href: url + "/objects/" + oid}}
+					case object.Oid == oid && !stored:
+						responseObject.Actions = map[string]*lfstransfer.Action{"upload": {Href: url + "/objects/" + oid}}
+					case object.Oid == oid:
+						responseObject.Actions = map[string]*lfstransfer.Action{"verify": {Href: url + "/objects/verify"}}
+					}
+
+					response.Objects = append(response.Objects, responseObject)
+				}
+
+				require.NoError(t, json.NewEncoder(w).Encode(response))
+			},
+		},
+		{
+			Path: "/objects/" + oid,
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPut {
+					b, err := io.ReadAll(r.Body)
+					require.NoError(t, err)
+					require.Equal(t, objectContent, string(b))
+
+					stored = true
+					return
+				}
+
+				io.WriteString(w, objectContent)
+			},
+		},
+		{
+			Path: "/objects/verify",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var object *lfstransfer.Object
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&object))
+				require.Equal(t, &lfstransfer.Object{Oid: oid, Size: 4}, object)
+			},
+		},
+	}
+
+	url = testserver.StartHttpServer(t, requests)
+
+	return url
+}
+
+// packets encodes lines as pkt-lines, "0000" and "0001" are passed on as
+// flush and delimiter packets
+func packets(t *testing.T, lines ...string) string {
+	var buf bytes.Buffer
+
+	for _, line := range lines {
+		switch line {
+		case "0000":
+			require.NoError(t, pktline.WriteFlush(&buf))
+		case "0001":
+			require.NoError(t, pktline.WriteDelim(&buf))
+		default:
+			require.NoError(t, pktline.WriteString(&buf, line))
+		}
+	}
+
+	return buf.String()
+}
+
+func execute(t *testing.T, url, operation, input string) (string, context.Context, error) {
+	output := &bytes.Buffer{}
+	cmd := &Command{
+		Config:     &config.Config{GitlabUrl: url},
+		Args:       &commandargs.Shell{GitlabKeyId: "1", SshArgs: []string{"git-lfs-transfer", "group/repo", operation}},
+		ReadWriter: &readwriter.ReadWriter{ErrOut: output, Out: output, In: bytes.NewBufferString(input)},
+	}
+
+	ctx, err := cmd.Execute(context.Background())
+
+	return output.String(), ctx, err
+}
+
+func TestDownload(t *testing.T) {
+	url := setup(t)
+
+	input := packets(t,
+		"version 1\n", "0000",
+		"batch\n", "hash-algo=sha256\n", "refname=refs/heads/main\n", "0001", oid+" 0\n", "0000",
+		"get-object "+oid+"\n", "0000",
+		"get-object "+missingOid+"\n", "0000",
+		"put-object "+oid+"\n", "size=4\n", "0001", objectContent, "0000",
+		"list-lock\n", "0000",
+		"quit\n", "0000",
+	)
+
+	output, ctx, err := execute(t, url, "download", input)
+	require.NoError(t, err)
+
+	expectedOutput := packets(t,
+		"version=1\n", "0000",
+		"status 200\n", "0000",
+		"status 200\n", "hash-algo=sha256\n", "0001", oid+" 4 download\n", "0000",
+		"status 200\n", "size=4\n", "0001", objectContent, "0000",
+		"status 404\n", "0001", "Object does not exist\n", "0000",
+		"status 403\n", "0001", "objects can only be stored by an upload session\n", "0000",
+		"status 501\n", "0001", "locking is not supported\n", "0000",
+		"status 200\n", "0000",
+	)
+	require.Equal(t, expectedOutput, output)
+
+	data := ctx.Value("logData").(command.LogData)
+	require.Equal(t, "alex-doe", data.Username)
+	require.Equal(t, "group/project-path", data.Meta.Project)
+}
+
+func TestUpload(t *testing.T) {
+	url := setup(t)
+
+	input := packets(t,
+		"version 1\n", "0000",
+		"batch\n", "0001", oid+" 4\n", "0000",
+		"verify-object "+oid+"\n", "size=4\n", "0000",
+		"put-object "+oid+"\n", "size=4\n", "0001", objectContent, "0000",
+		"verify-object "+oid+"\n", "size=4\n", "0000",
+		"put-object "+oid+"\n", "size=four\n", "0001", objectContent, "0000",
+		"unknown\n", "0000",
+	)
+
+	output, _, err := execute(t, url, "upload", input)
+	require.NoError(t, err)
+
+	expectedOutput := packets(t,
+		"version=1\n", "0000",
+		"status 200\n", "0000",
+		"status 200\n", "hash-algo=sha256\n", "0001", oid+" 4 upload\n", "0000",
+		"status 404\n", "0001", "object not found\n", "0000",
+		"status 200\n", "0000",
+		"status 200\n", "0000",
+		"status 400\n", "0001", "invalid size: \"four\"\n", "0000",
+		"status 400\n", "0001", "unknown command\n", "0000",
+	)
+	require.Equal(t, expectedOutput, output)
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	url := setup(t)
+
+	output, _, err := execute(t, url, "download", packets(t, "version 2\n", "0000"))
+	require.EqualError(t, err, `lfstransfer: unsupported protocol version "version 2"`)
+
+	expectedOutput := packets(t,
+		"version=1\n", "0000",
+		"status 400\n", "0001", "unsupported protocol version\n", "0000",
+	)
+	require.Equal(t, expectedOutput, output)
+}
+
+func TestDisallowedCommand(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		sshArgs []string
+	}{
+		{
+			desc:    "With missing arguments",
+			sshArgs: []string{"git-lfs-transfer", "group/repo"},
+		},
+		{
+			desc:    "With unknown operation",
+			sshArgs: []string{"git-lfs-transfer", "group/repo", "unknown"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cmd := &Command{
+				Config:     &config.Config{},
+				Args:       &commandargs.Shell{GitlabKeyId: "1", SshArgs: tc.sshArgs},
+				ReadWriter: &readwriter.ReadWriter{},
+			}
+
+			_, err := cmd.Execute(context.Background())
+			require.EqualError(t, err, "Disallowed command")
+		})
+	}
+}
@@ -0,0 +1,536 @@
+package lfstransfer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/lfstransfer"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
+)
+
+// See https://github.com/git-lfs/git-lfs/blob/main/docs/proposals/ssh_adapter.md
+const (
+	protocolVersion = "1"
+
+	statusOK             = http.StatusOK
+	statusBadRequest     = http.StatusBadRequest
+	statusForbidden      = http.StatusForbidden
+	statusNotFound       = http.StatusNotFound
+	statusInternalError  = http.StatusInternalServerError
+	statusNotImplemented = http.StatusNotImplemented
+)
+
+var errUnexpectedEOF = errors.New("lfstransfer: connection closed before the end of the request")
+
+// request is a single command sent by the client. Data that follows the
+// delimiter packet is left on the scanner for the handler to consume.
+type request struct {
+	command string
+	args    map[string]string
+	hasData bool
+}
+
+type session struct {
+	client    *lfstransfer.Client
+	operation string
+	scanner   *bufio.Scanner
+	out       io.Writer
+}
+
+func newSession(client *lfstransfer.Client, operation string, in io.Reader, out io.Writer) *session {
+	return &session{
+		client:    client,
+		operation: operation,
+		scanner:   pktline.NewScanner(in),
+		out:       out,
+	}
+}
+
+// serve runs the protocol until the client quits. Failures of a single
+// request are reported to the client and do not end the session.
+func (s *session) serve(ctx context.Context) error {
+	if err := s.handshake(); err != nil {
+		return err
+	}
+
+	for {
+		req, err := s.readRequest()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Commands like `get-object <oid>` carry their argument on the same line
+		name, _, _ := strings.Cut(req.command, " ")
+
+		switch name {
+		case "batch":
+			err = s.batch(ctx, req)
+		case "get-object":
+			err = s.getObject(ctx, req)
+		case "put-object":
+			err = s.putObject(ctx, req)
+		case "verify-object":
+			err = s.verifyObject(ctx, req)
+		case "lock", "list-lock", "unlock":
+			err = s.refuse(req, statusNotImplemented, "locking is not supported")
+		case "quit":
+			return s.writeStatus(statusOK)
+		default:
+			err = s.refuse(req, statusBadRequest, "unknown command")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *session) handshake() error {
+	if err := pktline.WriteString(s.out, "version="+protocolVersion+"\n"); err != nil {
+		return err
+	}
+	if err := pktline.WriteFlush(s.out); err != nil {
+		return err
+	}
+
+	req, err := s.readRequest()
+	if err != nil {
+		return err
+	}
+
+	if req.command != "version "+protocolVersion {
+		if err := s.refuse(req, statusBadRequest, "unsupported protocol version"); err != nil {
+			return err
+		}
+
+		return fmt.Errorf("lfstransfer: unsupported protocol version %q", req.command)
+	}
+
+	return s.writeStatus(statusOK)
+}
+
+func (s *session) batch(ctx context.Context, req *request) error {
+	if algo, ok := req.args["hash-algo"]; ok && algo != lfstransfer.HashAlgo {
+		return s.refuse(req, statusBadRequest, "unsupported hash algorithm: "+algo)
+	}
+
+	var objects []*lfstransfer.Object
+	if req.hasData {
+		lines, err := s.readLines()
+		if err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			object, err := parseObject(line)
+			if err != nil {
+				return s.writeError(statusBadRequest, err.Error())
+			}
+
+			objects = append(objects, object)
+		}
+	}
+
+	response, err := s.client.Batch(ctx, s.operation, objects, req.args["refname"])
+	if err != nil {
+		return s.writeRequestError(err)
+	}
+
+	var lines []string
+	for _, object := range response.Objects {
+		if object.Error != nil {
+			return s.writeError(object.Error.Code, object.Error.Message)
+		}
+
+		action := "noop"
+		if _, ok := object.Actions[s.operation]; ok {
+			action = s.operation
+		}
+
+		lines = append(lines, fmt.Sprintf("%s %d %s", object.Oid, object.Size, action))
+	}
+
+	return s.writeResponse(statusOK, []string{"hash-algo=" + lfstransfer.HashAlgo}, lines)
+}
+
+func (s *session) getObject(ctx context.Context, req *request) error {
+	if err := s.skipData(req); err != nil {
+		return err
+	}
+
+	if s.operation != lfstransfer.DownloadAction {
+		return s.writeError(statusForbidden, "objects can only be fetched by a download session")
+	}
+
+	oid, err := commandArgument(req.command)
+	if err != nil {
+		return s.writeError(statusBadRequest, err.Error())
+	}
+
+	object, action, err := s.objectAction(ctx, &lfstransfer.Object{Oid: oid}, lfstransfer.DownloadAction)
+	if err != nil {
+		return s.writeRequestError(err)
+	}
+	if action == nil {
+		return s.writeError(statusNotFound, "object not found")
+	}
+
+	body, err := s.client.Download(ctx, action)
+	if err != nil {
+		return s.writeRequestError(err)
+	}
+	defer body.Close()
+
+	if err := s.writeHeader(statusOK, []string{"size=" + strconv.FormatInt(object.Size, 10)}); err != nil {
+		return err
+	}
+	if err := pktline.WriteDelim(s.out); err != nil {
+		return err
+	}
+
+	buf := make([]byte, pktline.MaxPayloadSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if err := pktline.Write(s.out, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The status has already been sent, so the only way to signal
+			// the failure is to end the session
+			return err
+		}
+	}
+
+	return pktline.WriteFlush(s.out)
+}
+
+func (s *session) putObject(ctx context.Context, req *request) error {
+	data := &dataReader{scanner: s.scanner, done: !req.hasData}
+	err := s.uploadObject(ctx, req, data)
+
+	// The data has to be consumed whatever the outcome to keep the session
+	// in sync with the client
+	if err := data.drain(); err != nil {
+		return err
+	}
+
+	if err != nil {
+		return s.writeRequestError(err)
+	}
+
+	return s.writeStatus(statusOK)
+}
+
+func (s *session) uploadObject(ctx context.Context, req *request, data io.Reader) error {
+	if s.operation != lfstransfer.UploadAction {
+		return &lfstransfer.StatusError{StatusCode: statusForbidden, Message: "objects can only be stored by an upload session"}
+	}
+
+	object, err := requestObject(req)
+	if err != nil {
+		return &lfstransfer.StatusError{StatusCode: statusBadRequest, Message: err.Error()}
+	}
+
+	_, action, err := s.objectAction(ctx, object, lfstransfer.UploadAction)
+	if err != nil {
+		return err
+	}
+
+	// No upload action means the server already has the object
+	if action == nil {
+		return nil
+	}
+
+	return s.client.Upload(ctx, action, data, object.Size)
+}
+
+func (s *session) verifyObject(ctx context.Context, req *request) error {
+	if err := s.skipData(req); err != nil {
+		return err
+	}
+
+	if s.operation != lfstransfer.UploadAction {
+		return s.writeError(statusForbidden, "objects can only be verified by an upload session")
+	}
+
+	object, err := requestObject(req)
+	if err != nil {
+		return s.writeError(statusBadRequest, err.Error())
+	}
+
+	response, err := s.client.Batch(ctx, lfstransfer.UploadAction, []*lfstransfer.Object{object}, "")
+	if err != nil {
+		return s.writeRequestError(err)
+	}
+
+	for _, responseObject := range response.Objects {
+		if responseObject.Error != nil {
+			return s.writeError(responseObject.Error.Code, responseObject.Error.Message)
+		}
+
+		if _, ok := responseObject.Actions[lfstransfer.UploadAction]; ok {
+			return s.writeError(statusNotFound, "object not found")
+		}
+
+		if action, ok := responseObject.Actions[lfstransfer.VerifyAction]; ok {
+			if err := s.client.Verify(ctx, action, object); err != nil {
+				return s.writeRequestError(err)
+			}
+		}
+	}
+
+	return s.writeStatus(statusOK)
+}
+
+// objectAction asks the LFS API how to transfer a single object
+func (s *session) objectAction(ctx context.Context, object *lfstransfer.Object, operation string) (*lfstransfer.ResponseObject, *lfstransfer.Action, error) {
+	response, err := s.client.Batch(ctx, operation, []*lfstransfer.Object{object}, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, responseObject := range response.Objects {
+		if responseObject.Oid != object.Oid {
+			continue
+		}
+
+		if responseObject.Error != nil {
+			return nil, nil, &lfstransfer.StatusError{StatusCode: responseObject.Error.Code, Message: responseObject.Error.Message}
+		}
+
+		return responseObject, responseObject.Actions[operation], nil
+	}
+
+	return nil, nil, &lfstransfer.StatusError{StatusCode: statusNotFound, Message: "object not found"}
+}
+
+func (s *session) refuse(req *request, status int, message string) error {
+	if err := s.skipData(req); err != nil {
+		return err
+	}
+
+	return s.writeError(status, message)
+}
+
+// readRequest reads a command and its arguments up to the delimiter or flush
+// packet. It returns io.EOF when the client closed the connection between
+// requests.
+func (s *session) readRequest() (*request, error) {
+	req := &request{args: map[string]string{}}
+
+	for s.scanner.Scan() {
+		pkt := s.scanner.Bytes()
+
+		switch {
+		case pktline.IsFlush(pkt):
+			if req.command == "" {
+				return nil, errUnexpectedEOF
+			}
+			return req, nil
+		case pktline.IsDelim(pkt):
+			req.hasData = true
+			return req, nil
+		}
+
+		line := strings.TrimSuffix(string(pktline.Payload(pkt)), "\n")
+		if req.command == "" {
+			req.command = line
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, "=")
+		req.args[key] = value
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if req.command != "" {
+		return nil, errUnexpectedEOF
+	}
+
+	return nil, io.EOF
+}
+
+// readLines reads the data section of a request as text lines
+func (s *session) readLines() ([]string, error) {
+	var lines []string
+
+	for s.scanner.Scan() {
+		pkt := s.scanner.Bytes()
+		if pktline.IsFlush(pkt) {
+			return lines, nil
+		}
+
+		lines = append(lines, strings.TrimSuffix(string(pktline.Payload(pkt)), "\n"))
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, errUnexpectedEOF
+}
+
+func (s *session) skipData(req *request) error {
+	if !req.hasData {
+		return nil
+	}
+
+	return (&dataReader{scanner: s.scanner}).drain()
+}
+
+func (s *session) writeRequestError(err error) error {
+	var statusErr *lfstransfer.StatusError
+	if errors.As(err, &statusErr) {
+		return s.writeError(statusErr.StatusCode, statusErr.Message)
+	}
+
+	return s.writeError(statusInternalError, err.Error())
+}
+
+func (s *session) writeStatus(status int) error {
+	return s.writeResponse(status, nil, nil)
+}
+
+func (s *session) writeError(status int, message string) error {
+	return s.writeResponse(status, nil, []string{message})
+}
+
+func (s *session) writeResponse(status int, args []string, lines []string) error {
+	if err := s.writeHeader(status, args); err != nil {
+		return err
+	}
+
+	if len(lines) > 0 {
+		if err := pktline.WriteDelim(s.out); err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			if err := pktline.WriteString(s.out, line+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return pktline.WriteFlush(s.out)
+}
+
+func (s *session) writeHeader(status int, args []string) error {
+	if err := pktline.WriteString(s.out, fmt.Sprintf("status %03d\n", status)); err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		if err := pktline.WriteString(s.out, arg+"\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commandArgument returns the argument of commands like `get-object <oid>`
+func commandArgument(command string) (string, error) {
+	_, arg, ok := strings.Cut(command, " ")
+	if !ok || arg == "" {
+		return "", fmt.Errorf("missing object ID")
+	}
+
+	return arg, nil
+}
+
+func requestObject(req *request) (*lfstransfer.Object, error) {
+	oid, err := commandArgument(req.command)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := strconv.ParseInt(req.args["size"], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid size: %q", req.args["size"])
+	}
+
+	return &lfstransfer.Object{Oid: oid, Size: size}, nil
+}
+
+func parseObject(line string) (*lfstransfer.Object, error) {
+	oid, sizeArg, _ := strings.Cut(line, " ")
+	sizeArg, _, _ = strings.Cut(sizeArg, " ")
+
+	size, err := strconv.ParseInt(sizeArg, 10, 64)
+	if err != nil || oid == "" || size < 0 {
+		return nil, fmt.Errorf("invalid object: %q", line)
+	}
+
+	return &lfstransfer.Object{Oid: oid, Size: size}, nil
+}
+
+// dataReader reads the binary data section of a request up to the flush
+// packet. It is safe for concurrent use as the HTTP transport may still be
+// reading an upload body when the response has already arrived.
+type dataReader struct {
+	mu      sync.Mutex
+	scanner *bufio.Scanner
+	buf     []byte
+	done    bool
+	err     error
+}
+
+func (r *dataReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+
+		if !r.scanner.Scan() {
+			r.err = r.scanner.Err()
+			if r.err == nil {
+				r.err = errUnexpectedEOF
+			}
+			continue
+		}
+
+		pkt := r.scanner.Bytes()
+		if pktline.IsFlush(pkt) {
+			r.done = true
+			continue
+		}
+
+		r.buf = append(r.buf[:0], pktline.Payload(pkt)...)
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+// drain consumes the rest of the data so the next request can be read
+func (r *dataReader) drain() error {
+	_, err := io.Copy(io.Discard, r)
+
+	return err
+}
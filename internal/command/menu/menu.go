@@ -0,0 +1,193 @@
+package menu
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const quitKey = "q"
+
+// Builder returns the command of args, checked against the policy of the
+// instance the way the commands sent over SSH are
+type Builder func(args *commandargs.Shell, readWriter *readwriter.ReadWriter) (command.Command, error)
+
+// Command presents a menu of the commands useful without Git to the users
+// opening a session without a command on a terminal, and runs the ones they
+// choose until they quit
+type Command struct {
+	Config     *config.Config
+	Args       *commandargs.Shell
+	ReadWriter *readwriter.ReadWriter
+	Build      Builder
+}
+
+type item struct {
+	label       string
+	commandType commandargs.CommandType
+	// prompts ask for the arguments of the command, the ones left empty
+	// being omitted
+	prompts []string
+}
+
+var items = []item{
+	{label: "Show who you are", commandType: commandargs.Whoami},
+	{label: "List your projects", commandType: commandargs.Projects},
+	{
+		label:       "Generate a personal access token",
+		commandType: commandargs.PersonalAccessToken,
+		prompts: []string{
+			"Token name: ",
+			"Scopes, comma-separated, e.g. read_repository,write_repository: ",
+			"Days until it expires, empty for the default: ",
+		},
+	},
+	{label: "Verify a two-factor authentication OTP", commandType: commandargs.TwoFactorVerify},
+}
+
+func (c *Command) Execute(ctx context.Context) (context.Context, error) {
+	in := bufio.NewReader(c.ReadWriter.In)
+	rw := &readwriter.ReadWriter{Out: c.ReadWriter.Out, ErrOut: c.ReadWriter.ErrOut, In: in}
+
+	// The welcome identifies the user, its log data the session
+	ctxWithLogData := c.run(ctx, rw, commandargs.Discover, nil)
+
+	available := c.available()
+	for {
+		c.print(available)
+
+		choice, err := readLine(in)
+		if err != nil {
+			return ctxWithLogData, ignoreEOF(err)
+		}
+
+		if choice == "" {
+			continue
+		}
+		if choice == quitKey {
+			return ctxWithLogData, nil
+		}
+
+		chosen, ok := choose(available, choice)
+		if !ok {
+			fmt.Fprintf(rw.Out, "Unknown choice: %s\n", choice)
+			continue
+		}
+
+		args, err := prompt(in, rw.Out, chosen.prompts)
+		if err != nil {
+			return ctxWithLogData, ignoreEOF(err)
+		}
+
+		c.run(ctx, rw, chosen.commandType, args)
+	}
+}
+
+// available returns the items whose commands are registered and enabled
+func (c *Command) available() []item {
+	var available []item
+	for _, item := range items {
+		if _, ok := command.Lookup(item.commandType); !ok {
+			continue
+		}
+		if c.Config.Commands.IsDisabledFor(string(item.commandType), string(c.Args.ActorType)) {
+			continue
+		}
+
+		available = append(available, item)
+	}
+
+	return available
+}
+
+func (c *Command) print(available []item) {
+	var b strings.Builder
+	b.WriteString("\nWhat would you like to do?\n")
+	for i, item := range available {
+		fmt.Fprintf(&b, "  %d) %s\n", i+1, item.label)
+	}
+	fmt.Fprintf(&b, "  %s) Quit\n> ", quitKey)
+
+	fmt.Fprint(c.ReadWriter.Out, b.String())
+}
+
+func choose(available []item, choice string) (item, bool) {
+	for i, item := range available {
+		if choice == fmt.Sprint(i+1) {
+			return item, true
+		}
+	}
+
+	return item{}, false
+}
+
+// prompt asks for the arguments of a command
+func prompt(in *bufio.Reader, out io.Writer, prompts []string) ([]string, error) {
+	var args []string
+	for _, p := range prompts {
+		fmt.Fprint(out, p)
+
+		answer, err := readLine(in)
+		if err != nil {
+			return nil, err
+		}
+		if answer != "" {
+			args = append(args, answer)
+		}
+	}
+
+	return args, nil
+}
+
+// run runs the command, showing its error to the user rather than ending the
+// menu, and returns the context with its log data
+func (c *Command) run(ctx context.Context, rw *readwriter.ReadWriter, commandType commandargs.CommandType, args []string) context.Context {
+	cmdArgs := *c.Args
+	cmdArgs.CommandType = commandType
+	cmdArgs.SshArgs = append([]string{string(commandType)}, args...)
+
+	logger.WithContextFields(ctx, log.Fields{"command": commandType}).Info("menu: run: running command")
+
+	ctxWithLogData := ctx
+	cmd, err := c.Build(&cmdArgs, rw)
+	if err == nil {
+		ctxWithLogData, err = command.ExecuteWithTimeout(ctx, cmd, command.Timeout(c.Config, commandType))
+	}
+
+	if err != nil {
+		logger.ContextLogger(ctx).WithError(err).WithField("command", commandType).Warn("menu: run: command failed")
+		console.DisplayWarningMessage(err.Error(), rw.ErrOut)
+	}
+
+	return ctxWithLogData
+}
+
+func readLine(in *bufio.Reader) (string, error) {
+	line, err := in.ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+// ignoreEOF ends the menu without an error when the user closed the session
+func ignoreEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,90 @@
+package menu
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func init() {
+	for _, commandType := range []commandargs.CommandType{commandargs.Whoami, commandargs.Projects, commandargs.PersonalAccessToken} {
+		command.Register(command.Registration{
+			Name: commandType,
+			Build: func(*commandargs.Shell, *config.Config, *readwriter.ReadWriter) command.Command {
+				return nil
+			},
+		})
+	}
+}
+
+type fakeCommand struct {
+	args       *commandargs.Shell
+	readWriter *readwriter.ReadWriter
+}
+
+func (c *fakeCommand) Execute(ctx context.Context) (context.Context, error) {
+	if c.args.CommandType == commandargs.Projects {
+		return ctx, errors.New("Failed to list the projects")
+	}
+
+	fmt.Fprintf(c.readWriter.Out, "ran %s\n", strings.Join(c.args.SshArgs, " "))
+
+	return ctx, nil
+}
+
+func run(t *testing.T, cfg *config.Config, input string) (string, string) {
+	out := &bytes.Buffer{}
+	errOut := &bytes.Buffer{}
+	cmd := &Command{
+		Config:     cfg,
+		Args:       &commandargs.Shell{GitlabKeyId: "1"},
+		ReadWriter: &readwriter.ReadWriter{Out: out, ErrOut: errOut, In: strings.NewReader(input)},
+		Build: func(args *commandargs.Shell, rw *readwriter.ReadWriter) (command.Command, error) {
+			require.Equal(t, "1", args.GitlabKeyId)
+			return &fakeCommand{args: args, readWriter: rw}, nil
+		},
+	}
+
+	_, err := cmd.Execute(context.Background())
+	require.NoError(t, err)
+
+	return out.String(), errOut.String()
+}
+
+func TestExecute(t *testing.T) {
+	out, errOut := run(t, &config.Config{}, "1\n\n3\nci\nread_api\n\n2\n9\nq\n1\n")
+
+	menu := "\nWhat would you like to do?\n" +
+		"  1) Show who you are\n" +
+		"  2) List your projects\n" +
+		"  3) Generate a personal access token\n" +
+		"  q) Quit\n> "
+	require.Equal(t, "ran discover\n"+
+		menu+"ran whoami\n"+
+		menu+
+		menu+"Token name: Scopes, comma-separated, e.g. read_repository,write_repository: Days until it expires, empty for the default: "+
+		"ran personal_access_token ci read_api\n"+
+		menu+
+		menu+"Unknown choice: 9\n"+
+		menu, out)
+	require.Contains(t, errOut, "remote: Failed to list the projects\n")
+}
+
+func TestExecuteDisabledCommands(t *testing.T) {
+	cfg := &config.Config{Commands: config.CommandsConfig{Disabled: []string{"whoami"}}}
+	out, errOut := run(t, cfg, "1\n")
+
+	require.NotContains(t, out, "Show who you are")
+	require.Contains(t, out, "  1) List your projects\n")
+	require.Contains(t, errOut, "Failed to list the projects")
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"gitlab.com/gitlab-org/labkit/log"
@@ -14,11 +15,19 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/personalaccesstoken"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
 )
 
 const (
-	usageText         = "Usage: personal_access_token <name> <scope1[,scope2,...]> [ttl_days]"
+	usageText = "Usage: personal_access_token <name> <scope1[,scope2,...]> [ttl_days]\n" +
+		"       personal_access_token --list\n" +
+		"       personal_access_token --revoke <token_id>"
 	expiresDateFormat = "2006-01-02"
+
+	// The flags don't clash with the name of a token being created, which
+	// may well be list or revoke
+	listFlag   = "--list"
+	revokeFlag = "--revoke"
 )
 
 type Command struct {
@@ -35,12 +44,20 @@ type tokenArgs struct {
 }
 
 func (c *Command) Execute(ctx context.Context) (context.Context, error) {
+	args := c.Args.SshArgs
+	switch {
+	case len(args) == 2 && args[1] == listFlag:
+		return ctx, c.listTokens(ctx)
+	case len(args) == 3 && args[1] == revokeFlag:
+		return ctx, c.revokeToken(ctx, args[2])
+	}
+
 	err := c.parseTokenArgs()
 	if err != nil {
 		return ctx, err
 	}
 
-	log.WithContextFields(ctx, log.Fields{
+	logger.WithContextFields(ctx, log.Fields{
 		"token_args": c.TokenArgs,
 	}).Info("personalaccesstoken: execute: requesting token")
 
@@ -56,6 +73,71 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 	return ctx, nil
 }
 
+func (c *Command) listTokens(ctx context.Context) error {
+	logger.ContextLogger(ctx).Info("personalaccesstoken: execute: listing tokens")
+
+	client, err := personalaccesstoken.NewClient(c.Config)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.ListPersonalAccessTokens(ctx, c.Args)
+	if err != nil {
+		return err
+	}
+
+	if len(response.Tokens) == 0 {
+		fmt.Fprint(c.ReadWriter.Out, "No active personal access tokens\n")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(c.ReadWriter.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "ID\tName\tScopes\tExpires\tLast used\n")
+	for _, token := range response.Tokens {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+			token.Id,
+			token.Name,
+			strings.Join(token.Scopes, ","),
+			valueOr(token.ExpiresAt, "never"),
+			valueOr(token.LastUsedAt, "never"),
+		)
+	}
+
+	return w.Flush()
+}
+
+func (c *Command) revokeToken(ctx context.Context, rawId string) error {
+	tokenId, err := strconv.ParseInt(rawId, 10, 64)
+	if err != nil || tokenId <= 0 {
+		return fmt.Errorf("Invalid value for token_id: '%s'", rawId)
+	}
+
+	logger.WithContextFields(ctx, log.Fields{
+		"token_id": tokenId,
+	}).Info("personalaccesstoken: execute: revoking token")
+
+	client, err := personalaccesstoken.NewClient(c.Config)
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.RevokePersonalAccessToken(ctx, c.Args, tokenId); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.ReadWriter.Out, "Token %d has been revoked\n", tokenId)
+
+	return nil
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
+
 func (c *Command) parseTokenArgs() error {
 	if len(c.Args.SshArgs) < 3 || len(c.Args.SshArgs) > 4 {
 		return errors.New(usageText)
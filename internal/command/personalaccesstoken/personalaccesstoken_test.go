@@ -59,6 +59,37 @@ func setup(t *testing.T) {
 				}
 			},
 		},
+		{
+			Path: "/api/v4/internal/personal_access_tokens",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var requestBody *personalaccesstoken.ListRequestBody
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&requestBody))
+
+				tokens := []map[string]interface{}{}
+				if requestBody.KeyId == "default" {
+					tokens = append(tokens,
+						map[string]interface{}{"id": 1, "name": "ci", "scopes": []string{"api"}, "expires_at": "9001-11-17", "last_used_at": "2023-06-01"},
+						map[string]interface{}{"id": 12, "name": "backup", "scopes": []string{"read_api", "read_repository"}, "expires_at": nil},
+					)
+				}
+
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "tokens": tokens})
+			},
+		},
+		{
+			Path: "/api/v4/internal/personal_access_token/revoke",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var requestBody *personalaccesstoken.RevokeRequestBody
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&requestBody))
+
+				if requestBody.TokenId != 12 {
+					json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": "Token not found"})
+					return
+				}
+
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+			},
+		},
 	}
 }
 
@@ -147,6 +178,68 @@ func TestExecute(t *testing.T) {
 			},
 			expectedError: "Internal API unreachable",
 		},
+		{
+			desc: "Listing tokens",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname, "--list"},
+			},
+			expectedOutput: "ID  Name    Scopes                    Expires     Last used\n" +
+				"1   ci      api                       9001-11-17  2023-06-01\n" +
+				"12  backup  read_api,read_repository  never       never\n",
+		},
+		{
+			desc: "Listing without tokens",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "empty",
+				SshArgs:     []string{cmdname, "--list"},
+			},
+			expectedOutput: "No active personal access tokens\n",
+		},
+		{
+			desc: "Revoking a token",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname, "--revoke", "12"},
+			},
+			expectedOutput: "Token 12 has been revoked\n",
+		},
+		{
+			desc: "Revoking an unknown token",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname, "--revoke", "13"},
+			},
+			expectedError: "Token not found",
+		},
+		{
+			desc: "Revoking with a bad token_id argument",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname, "--revoke", "latest"},
+			},
+			expectedError: "Invalid value for token_id: 'latest'",
+		},
+		{
+			desc: "Creating a token named list",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname, "list", "api"},
+			},
+			expectedOutput: "Token:   YXuxvUgCEmeePY3G1YAa\n" +
+				"Scopes:  api\n" +
+				"Expires: 9001-11-17\n",
+		},
+		{
+			desc: "Creating a token named revoke",
+			arguments: &commandargs.Shell{
+				GitlabKeyId: "default",
+				SshArgs:     []string{cmdname, "revoke", "api"},
+			},
+			expectedOutput: "Token:   YXuxvUgCEmeePY3G1YAa\n" +
+				"Scopes:  api\n" +
+				"Expires: 9001-11-17\n",
+		},
 		{
 			desc: "Without KeyID or User",
 			arguments: &commandargs.Shell{
@@ -0,0 +1,97 @@
+package projects
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/projects"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+)
+
+const (
+	usageText      = "Usage: projects [--page <page>] [--per-page <count>]"
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+type Command struct {
+	Config     *config.Config
+	Args       *commandargs.Shell
+	ReadWriter *readwriter.ReadWriter
+}
+
+func (c *Command) Execute(ctx context.Context) (context.Context, error) {
+	page, perPage, err := c.parseArgs()
+	if err != nil {
+		return ctx, err
+	}
+
+	logger.WithContextFields(ctx, log.Fields{
+		"page":     page,
+		"per_page": perPage,
+	}).Info("projects: execute: listing projects")
+
+	client, err := projects.NewClient(c.Config)
+	if err != nil {
+		return ctx, err
+	}
+
+	response, err := client.List(ctx, c.Args, page, perPage)
+	if err != nil {
+		return ctx, err
+	}
+
+	if len(response.Projects) == 0 {
+		fmt.Fprint(c.ReadWriter.Out, "No projects found\n")
+		return ctx, nil
+	}
+
+	w := tabwriter.NewWriter(c.ReadWriter.Out, 0, 0, 2, ' ', 0)
+	for _, project := range response.Projects {
+		fmt.Fprintf(w, "%s\t%s\n", project.Path, project.AccessLevel)
+	}
+	if err := w.Flush(); err != nil {
+		return ctx, err
+	}
+
+	if response.NextPage > 0 {
+		fmt.Fprintf(c.ReadWriter.Out, "\nMore projects are available, use --page %d to see them.\n", response.NextPage)
+	}
+
+	return ctx, nil
+}
+
+func (c *Command) parseArgs() (int, int, error) {
+	var args []string
+	if len(c.Args.SshArgs) > 1 {
+		args = c.Args.SshArgs[1:]
+	}
+
+	flags := flag.NewFlagSet(string(commandargs.Projects), flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	page := flags.Int("page", 1, "")
+	perPage := flags.Int("per-page", defaultPerPage, "")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return 0, 0, errors.New(usageText)
+	}
+
+	if *page < 1 {
+		return 0, 0, fmt.Errorf("Invalid value for page: '%d'", *page)
+	}
+
+	if *perPage < 1 || *perPage > maxPerPage {
+		return 0, 0, fmt.Errorf("Invalid value for per-page: '%d', must be between 1 and %d", *perPage, maxPerPage)
+	}
+
+	return *page, *perPage, nil
+}
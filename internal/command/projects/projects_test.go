@@ -0,0 +1,110 @@
+package projects
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+var requests = []testserver.TestRequestHandler{
+	{
+		Path: "/api/v4/internal/projects",
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			projects := []map[string]string{}
+			nextPage := 0
+
+			if r.URL.Query().Get("key_id") == "1" {
+				projects = append(projects,
+					map[string]string{"path_with_namespace": "group/repo", "access_level": "maintainer"},
+					map[string]string{"path_with_namespace": "group/subgroup/other-repo", "access_level": "reporter"},
+				)
+
+				if r.URL.Query().Get("per_page") == "2" {
+					nextPage = 2
+				}
+			}
+
+			json.NewEncoder(w).Encode(map[string]interface{}{"projects": projects, "next_page": nextPage})
+		},
+	},
+}
+
+func TestExecute(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	testCases := []struct {
+		desc           string
+		arguments      *commandargs.Shell
+		expectedOutput string
+		expectedError  string
+	}{
+		{
+			desc:      "Listing projects",
+			arguments: &commandargs.Shell{GitlabKeyId: "1", SshArgs: []string{"projects"}},
+			expectedOutput: "group/repo                 maintainer\n" +
+				"group/subgroup/other-repo  reporter\n",
+		},
+		{
+			desc:      "With more pages",
+			arguments: &commandargs.Shell{GitlabKeyId: "1", SshArgs: []string{"projects", "--per-page", "2"}},
+			expectedOutput: "group/repo                 maintainer\n" +
+				"group/subgroup/other-repo  reporter\n" +
+				"\nMore projects are available, use --page 2 to see them.\n",
+		},
+		{
+			desc:           "Without projects",
+			arguments:      &commandargs.Shell{GitlabKeyId: "2", SshArgs: []string{"projects", "--page=3"}},
+			expectedOutput: "No projects found\n",
+		},
+		{
+			desc:          "With an unknown flag",
+			arguments:     &commandargs.Shell{GitlabKeyId: "1", SshArgs: []string{"projects", "--all"}},
+			expectedError: usageText,
+		},
+		{
+			desc:          "With an extra argument",
+			arguments:     &commandargs.Shell{GitlabKeyId: "1", SshArgs: []string{"projects", "group"}},
+			expectedError: usageText,
+		},
+		{
+			desc:          "With an invalid page",
+			arguments:     &commandargs.Shell{GitlabKeyId: "1", SshArgs: []string{"projects", "--page", "0"}},
+			expectedError: "Invalid value for page: '0'",
+		},
+		{
+			desc:          "With too many projects per page",
+			arguments:     &commandargs.Shell{GitlabKeyId: "1", SshArgs: []string{"projects", "--per-page", "500"}},
+			expectedError: "Invalid value for per-page: '500', must be between 1 and 100",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			output := &bytes.Buffer{}
+			cmd := &Command{
+				Config:     &config.Config{GitlabUrl: url},
+				Args:       tc.arguments,
+				ReadWriter: &readwriter.ReadWriter{Out: output},
+			}
+
+			_, err := cmd.Execute(context.Background())
+
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOutput, output.String())
+		})
+	}
+}
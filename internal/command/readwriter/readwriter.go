@@ -22,3 +22,16 @@ func (cw *CountingWriter) Write(p []byte) (int, error) {
 	cw.N += int64(n)
 	return n, err
 }
+
+// CountingReader wraps an io.Reader and counts all the reads. Accessing
+// the count N is not thread-safe.
+type CountingReader struct {
+	R io.Reader
+	N int64
+}
+
+func (cr *CountingReader) Read(p []byte) (int, error) {
+	n, err := cr.R.Read(p)
+	cr.N += int64(n)
+	return n, err
+}
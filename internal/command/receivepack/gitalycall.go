@@ -2,25 +2,34 @@ package receivepack
 
 import (
 	"context"
+	"io"
 
 	"google.golang.org/grpc"
 
 	"gitlab.com/gitlab-org/gitaly/v16/client"
 	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/accessverifier"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/handler"
 )
 
-func (c *Command) performGitalyCall(ctx context.Context, response *accessverifier.Response) error {
+// performGitalyCall proxies the push to Gitaly, reading it from stdin rather
+// than c.ReadWriter.In so that it can be inspected on the way
+func (c *Command) performGitalyCall(ctx context.Context, response *accessverifier.Response, stdin io.Reader) error {
 	gc := handler.NewGitalyCommand(c.Config, string(commandargs.ReceivePack), response)
 
+	protocol, err := gc.GitProtocol(ctx, c.Args.Env)
+	if err != nil {
+		return err
+	}
+
 	request := &pb.SSHReceivePackRequest{
 		Repository:       &response.Gitaly.Repo,
 		GlId:             response.Who,
 		GlRepository:     response.Repo,
 		GlUsername:       response.Username,
-		GitProtocol:      c.Args.Env.GitProtocolVersion,
+		GitProtocol:      protocol,
 		GitConfigOptions: response.GitConfigOptions,
 	}
 
@@ -28,7 +37,14 @@ func (c *Command) performGitalyCall(ctx context.Context, response *accessverifie
 		ctx, cancel := gc.PrepareContext(ctx, request.Repository, c.Args.Env)
 		defer cancel()
 
-		rw := c.ReadWriter
+		rw := &readwriter.ReadWriter{Out: c.ReadWriter.Out, In: stdin, ErrOut: c.ReadWriter.ErrOut}
+
+		rw, release := gc.LimitBandwidth(ctx, rw)
+		defer release()
+
+		rw, stop := gc.ReportProgress(ctx, rw)
+		defer stop()
+
 		return client.ReceivePack(ctx, conn, rw.In, rw.Out, rw.ErrOut, request)
 	})
 }
@@ -12,6 +12,7 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/customaction"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pushoptions"
 )
 
 type Command struct {
@@ -60,19 +61,24 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 		return ctxWithLogData, customAction.Execute(ctx, response)
 	}
 
-	err = c.performGitalyCall(ctx, response)
+	pushOptions := pushoptions.NewReader(c.ReadWriter.In, pushoptions.Limits{
+		MaxCount: c.Config.PushOptions.MaxCount,
+		MaxSize:  c.Config.PushOptions.MaxSize,
+	})
+
+	err = c.performGitalyCall(ctx, response, pushOptions)
 	if err != nil {
 		return ctxWithLogData, err
 	}
 
 	if response.NeedAudit {
-		gitauditevent.Audit(ctx, c.Args.CommandType, c.Config, response, nil /* keep nil for `git-receive-pack`*/)
+		gitauditevent.Audit(ctx, c.Args.CommandType, c.Config, response, nil /* keep nil for `git-receive-pack`*/, pushOptions.Options())
 	}
 	return ctxWithLogData, nil
 }
 
 func (c *Command) verifyAccess(ctx context.Context, repo string) (*accessverifier.Response, error) {
-	cmd := accessverifier.Command{c.Config, c.Args, c.ReadWriter}
+	cmd := accessverifier.Command{Config: c.Config, Args: c.Args, ReadWriter: c.ReadWriter}
 
 	return cmd.Verify(ctx, c.Args.CommandType, repo)
 }
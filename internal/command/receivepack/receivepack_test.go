@@ -38,6 +38,24 @@ func TestForbiddenAccess(t *testing.T) {
 	require.Equal(t, "Disallowed by API call", err.Error())
 }
 
+func TestMaintenanceMode(t *testing.T) {
+	gitalyAddress, _ := testserver.StartGitalyServer(t, "unix")
+	requests := requesthandlers.BuildAllowedWithGitalyHandlers(t, gitalyAddress)
+
+	cmd, _ := setup(t, "1", requests)
+	cmd.Config.MaintenanceMode = config.MaintenanceModeConfig{Enabled: true, Message: "Pushes are disabled until 18:00 UTC"}
+
+	_, err := cmd.Execute(context.Background())
+	require.EqualError(t, err, "Pushes are disabled until 18:00 UTC")
+
+	cmd, _ = setup(t, "1", requests)
+	cmd.Config.MaintenanceMode = config.MaintenanceModeConfig{Enabled: true, AllowedUsers: []string{"alex-doe"}}
+	cmd.Config.GitalyClient.InitSidechannelRegistry(context.Background())
+
+	_, err = cmd.Execute(context.Background())
+	require.NoError(t, err)
+}
+
 func TestCustomReceivePack(t *testing.T) {
 	cmd, output := setup(t, "1", requesthandlers.BuildAllowedWithCustomActionsHandlers(t))
 
@@ -54,7 +72,7 @@ func setup(t *testing.T, keyId string, requests []testserver.TestRequestHandler)
 
 	cmd := &Command{
 		Config:     &config.Config{GitlabUrl: url},
-		Args:       &commandargs.Shell{GitlabKeyId: keyId, SshArgs: []string{"git-receive-pack", "group/repo"}},
+		Args:       &commandargs.Shell{GitlabKeyId: keyId, CommandType: commandargs.ReceivePack, SshArgs: []string{"git-receive-pack", "group/repo"}},
 		ReadWriter: &readwriter.ReadWriter{ErrOut: output, Out: output, In: input},
 	}
 
@@ -0,0 +1,149 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/featureflags"
+)
+
+// Builder returns the command to run for the parsed SSH command
+type Builder func(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) Command
+
+// Registration describes a command that can be run over SSH. Builds adding
+// their own commands register them from the init function of a package
+// imported by their main package.
+type Registration struct {
+	Name  commandargs.CommandType
+	Build Builder
+	// FeatureFlag, when set, only lets the actors it's enabled for run the
+	// command
+	FeatureFlag string
+	// Writes reports whether the command writes to repositories, which
+	// read-only servers reject. Commands without it never write.
+	Writes func(args *commandargs.Shell) bool
+	// Usage and Description list the command in help, it isn't listed
+	// without a description
+	Usage       string
+	Description string
+}
+
+var (
+	registryMu    sync.RWMutex
+	registry      = map[commandargs.CommandType]Registration{}
+	registryOrder []commandargs.CommandType
+	registryErrs  []error
+)
+
+// Register makes a command available. It fails when the command has no name
+// or builder, or is already registered. As the registrations are done from
+// init functions, the failures are also kept for RegistrationErr to report.
+func Register(r Registration) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	var err error
+	if r.Name == "" || r.Build == nil {
+		err = errors.New("command: Register requires a name and a builder")
+	} else if _, ok := registry[r.Name]; ok {
+		err = fmt.Errorf("command: %s is already registered", r.Name)
+	}
+	if err != nil {
+		registryErrs = append(registryErrs, err)
+		return err
+	}
+
+	registry[r.Name] = r
+	registryOrder = append(registryOrder, r.Name)
+
+	return nil
+}
+
+// RegistrationErr returns the failures of Register, which the main packages
+// report at startup
+func RegistrationErr() error {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return errors.Join(registryErrs...)
+}
+
+// Lookup returns the registration of a command
+func Lookup(name commandargs.CommandType) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	r, ok := registry[name]
+
+	return r, ok
+}
+
+// Registered returns the registered commands, in the order they were
+// registered
+func Registered() []Registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	registrations := make([]Registration, 0, len(registryOrder))
+	for _, name := range registryOrder {
+		registrations = append(registrations, registry[name])
+	}
+
+	return registrations
+}
+
+// IsWrite returns whether running the command with args writes to
+// repositories
+func (r Registration) IsWrite(args *commandargs.Shell) bool {
+	return r.Writes != nil && r.Writes(args)
+}
+
+// New builds the command, which checks its feature flag before running
+func (r Registration) New(args *commandargs.Shell, config *config.Config, readWriter *readwriter.ReadWriter) Command {
+	cmd := r.Build(args, config, readWriter)
+	if cmd == nil || r.FeatureFlag == "" {
+		return cmd
+	}
+
+	return &flaggedCommand{Command: cmd, registration: r, config: config, args: args}
+}
+
+// Enabled returns whether the feature flag of the command, if any, is enabled
+// for the actor identified by args
+func (r Registration) Enabled(ctx context.Context, config *config.Config, args *commandargs.Shell) bool {
+	if r.FeatureFlag == "" {
+		return true
+	}
+	if config == nil {
+		return false
+	}
+
+	client, err := featureflags.NewClient(config)
+	if err != nil {
+		return false
+	}
+
+	return client.Enabled(ctx, args, r.FeatureFlag)
+}
+
+type flaggedCommand struct {
+	Command
+
+	registration Registration
+	config       *config.Config
+	args         *commandargs.Shell
+}
+
+func (c *flaggedCommand) Execute(ctx context.Context) (context.Context, error) {
+	if !c.registration.Enabled(ctx, c.config, c.args) {
+		return ctx, disallowedcommand.Error
+	}
+
+	return c.Command.Execute(ctx)
+}
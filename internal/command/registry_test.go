@@ -0,0 +1,70 @@
+package command
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestRegister(t *testing.T) {
+	build := func(*commandargs.Shell, *config.Config, *readwriter.ReadWriter) Command {
+		return &fakeCommand{execute: func(context.Context) error { return nil }}
+	}
+
+	require.NoError(t, Register(Registration{Name: "registry-test", Build: build, Description: "A test command"}))
+
+	registration, ok := Lookup("registry-test")
+	require.True(t, ok)
+	require.Equal(t, "A test command", registration.Description)
+	require.IsType(t, &fakeCommand{}, registration.New(&commandargs.Shell{}, &config.Config{}, nil))
+	require.Equal(t, commandargs.CommandType("registry-test"), Registered()[len(Registered())-1].Name)
+
+	_, ok = Lookup("unregistered")
+	require.False(t, ok)
+
+	require.NoError(t, RegistrationErr())
+
+	err := Register(Registration{Name: "registry-test", Build: build, Description: "Another test command"})
+	require.EqualError(t, err, "command: registry-test is already registered")
+	require.Equal(t, "A test command", Registered()[len(Registered())-1].Description)
+
+	err = Register(Registration{Name: "registry-test-without-builder"})
+	require.EqualError(t, err, "command: Register requires a name and a builder")
+
+	require.EqualError(t, RegistrationErr(), "command: registry-test is already registered\ncommand: Register requires a name and a builder")
+}
+
+func TestRegistrationFeatureFlag(t *testing.T) {
+	executed := false
+	cmd := &fakeCommand{execute: func(context.Context) error {
+		executed = true
+		return nil
+	}}
+	registration := Registration{
+		Name:        "flagged",
+		FeatureFlag: "ssh_flagged_command",
+		Build: func(*commandargs.Shell, *config.Config, *readwriter.ReadWriter) Command {
+			return cmd
+		},
+	}
+
+	// Without fetching the flags, their configured defaults apply
+	cfg := &config.Config{GitlabUrl: "http+unix://gitlab.socket"}
+	args := &commandargs.Shell{GitlabKeyId: "1"}
+
+	_, err := registration.New(args, cfg, nil).Execute(context.Background())
+	require.Equal(t, disallowedcommand.Error, err)
+	require.False(t, executed)
+
+	cfg.FeatureFlags.Defaults = map[string]bool{"ssh_flagged_command": true}
+
+	_, err = registration.New(args, cfg, nil).Execute(context.Background())
+	require.NoError(t, err)
+	require.True(t, executed)
+}
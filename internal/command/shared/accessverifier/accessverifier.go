@@ -2,23 +2,89 @@ package accessverifier
 
 import (
 	"context"
-	"errors"
+	"fmt"
+
+	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/usermessage"
 )
 
 type Response = accessverifier.Response
 
+// Exit codes of commands denied by a size limit or a plan restriction, so that
+// wrappers can tell them apart from other failures
+const (
+	ExitCodeLimitExceeded         = 10
+	ExitCodeRepositorySizeLimit   = 11
+	ExitCodeNamespaceStorageLimit = 12
+	ExitCodePlanRestriction       = 13
+)
+
+// LimitExceededError is returned when access is denied because of a size limit
+// or a plan restriction
+type LimitExceededError struct {
+	Message string
+	accessverifier.LimitExceeded
+}
+
+func (e *LimitExceededError) Error() string {
+	return e.Message
+}
+
+// Messages returns the explanation displayed to the user
+func (e *LimitExceededError) Messages() []string {
+	messages := []string{e.Message}
+
+	if len(e.Details) > 0 {
+		messages = append(messages, "")
+		messages = append(messages, e.Details...)
+	}
+
+	if e.DocsURL != "" {
+		messages = append(messages, "", fmt.Sprintf("To learn more, see %s", e.DocsURL))
+	}
+
+	return messages
+}
+
+func (e *LimitExceededError) ExitCode() int {
+	switch e.Type {
+	case accessverifier.RepositorySizeLimit:
+		return ExitCodeRepositorySizeLimit
+	case accessverifier.NamespaceStorageLimit:
+		return ExitCodeNamespaceStorageLimit
+	case accessverifier.PlanRestriction:
+		return ExitCodePlanRestriction
+	default:
+		return ExitCodeLimitExceeded
+	}
+}
+
 type Command struct {
 	Config     *config.Config
 	Args       *commandargs.Shell
 	ReadWriter *readwriter.ReadWriter
 }
 
+// accessDenied returns err with the access_denied message override, if any,
+// applied to its message
+func (c *Command) accessDenied(ctx context.Context, err error) error {
+	data := usermessage.Data{Username: c.Args.GitlabUsername, Message: err.Error()}
+	message := usermessage.Render(ctx, c.Config.Messages, usermessage.AccessDenied, data, err.Error())
+	if message == err.Error() {
+		return err
+	}
+
+	return errorcode.New(errorcode.AccessDenied, message)
+}
+
 func (c *Command) Verify(ctx context.Context, action commandargs.CommandType, repo string) (*Response, error) {
 	client, err := accessverifier.NewClient(c.Config)
 	if err != nil {
@@ -27,13 +93,38 @@ func (c *Command) Verify(ctx context.Context, action commandargs.CommandType, re
 
 	response, err := client.Verify(ctx, c.Args, action, repo)
 	if err != nil {
+		if errorcode.Classify(err) == errorcode.AccessDenied {
+			return nil, c.accessDenied(ctx, err)
+		}
+
 		return nil, err
 	}
 
 	c.displayConsoleMessages(response.ConsoleMessages)
 
 	if !response.Success {
-		return nil, errors.New(response.Message)
+		if response.LimitExceeded != nil {
+			return nil, errorcode.Wrap(errorcode.AccessDenied, &LimitExceededError{Message: response.Message, LimitExceeded: *response.LimitExceeded})
+		}
+
+		return nil, c.accessDenied(ctx, errorcode.New(errorcode.AccessDenied, response.Message))
+	}
+
+	logger.AddSessionFields(ctx, log.Fields{
+		"command":         action,
+		"gl_project_path": response.Gitaly.Repo.GlProjectPath,
+		"user_id":         response.UserId,
+		"username":        response.Username,
+		"gl_key_id":       response.KeyId,
+		"gl_key_type":     response.KeyType,
+	})
+
+	// The writes, pushes and LFS uploads, are checked as git-receive-pack,
+	// like the registrations of the commands classify them
+	if action == commandargs.ReceivePack {
+		if err := c.Config.Maintenance().CheckPush(response.Username, c.Args.GitlabKeyId); err != nil {
+			return nil, err
+		}
 	}
 
 	return response, nil
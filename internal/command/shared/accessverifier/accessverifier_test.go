@@ -9,12 +9,15 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
 )
 
 var (
@@ -36,9 +39,24 @@ func setup(t *testing.T) (*Command, *bytes.Buffer, *bytes.Buffer) {
 
 				if requestBody.KeyId == "1" {
 					body := map[string]interface{}{
+						"status":              true,
+						"gl_id":               "user-1",
+						"gl_username":         "alex-doe",
+						"gl_key_id":           1,
 						"gl_console_messages": []string{"console", "message"},
 					}
 					require.NoError(t, json.NewEncoder(w).Encode(body))
+				} else if requestBody.KeyId == "3" {
+					body := map[string]interface{}{
+						"status":  false,
+						"message": "Your push has been rejected, because this repository has exceeded its size limit of 10 GiB by 1 GiB.",
+						"limit_exceeded": map[string]interface{}{
+							"type":     "repository_size",
+							"details":  []string{"Remove large files from the repository history, or request a higher limit."},
+							"docs_url": "https://docs.gitlab.com/ee/user/project/repository/reducing_the_repo_size_using_git.html",
+						},
+					}
+					require.NoError(t, json.NewEncoder(w).Encode(body))
 				} else {
 					body := map[string]interface{}{
 						"status":  false,
@@ -70,6 +88,69 @@ func TestMissingUser(t *testing.T) {
 	require.Equal(t, "missing user", err.Error())
 }
 
+func TestAccessDeniedMessageOverride(t *testing.T) {
+	cmd, _, _ := setup(t)
+
+	cmd.Config.Messages = map[string]string{"access_denied": "{{.Message}}. Contact it@example.com for help."}
+	cmd.Args = &commandargs.Shell{GitlabKeyId: "2"}
+	_, err := cmd.Verify(context.Background(), action, repo)
+
+	require.Equal(t, "missing user. Contact it@example.com for help.", err.Error())
+	require.Equal(t, errorcode.AccessDenied, errorcode.Classify(err))
+}
+
+func TestLimitExceeded(t *testing.T) {
+	cmd, _, _ := setup(t)
+
+	cmd.Args = &commandargs.Shell{GitlabKeyId: "3"}
+	_, err := cmd.Verify(context.Background(), action, repo)
+
+	var limitErr *LimitExceededError
+	require.ErrorAs(t, err, &limitErr)
+	require.Equal(t, "Your push has been rejected, because this repository has exceeded its size limit of 10 GiB by 1 GiB.", err.Error())
+	require.Equal(t, ExitCodeRepositorySizeLimit, limitErr.ExitCode())
+	require.Equal(t, []string{
+		"Your push has been rejected, because this repository has exceeded its size limit of 10 GiB by 1 GiB.",
+		"",
+		"Remove large files from the repository history, or request a higher limit.",
+		"",
+		"To learn more, see https://docs.gitlab.com/ee/user/project/repository/reducing_the_repo_size_using_git.html",
+	}, limitErr.Messages())
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	cmd, _, _ := setup(t)
+
+	cmd.Config.MaintenanceMode = config.MaintenanceModeConfig{Enabled: true, Message: "Pushes are disabled until 18:00 UTC"}
+	cmd.Args = &commandargs.Shell{GitlabKeyId: "1"}
+
+	// Pushes and LFS uploads are both checked as git-receive-pack
+	_, err := cmd.Verify(context.Background(), commandargs.ReceivePack, repo)
+	require.EqualError(t, err, "Pushes are disabled until 18:00 UTC")
+	require.Equal(t, errorcode.AccessDenied, errorcode.Classify(err))
+
+	_, err = cmd.Verify(context.Background(), commandargs.UploadPack, repo)
+	require.NoError(t, err)
+}
+
+func TestLimitExceededExitCode(t *testing.T) {
+	for limitType, exitCode := range map[string]int{
+		accessverifier.RepositorySizeLimit:   ExitCodeRepositorySizeLimit,
+		accessverifier.NamespaceStorageLimit: ExitCodeNamespaceStorageLimit,
+		accessverifier.PlanRestriction:       ExitCodePlanRestriction,
+		"seats":                              ExitCodeLimitExceeded,
+	} {
+		err := &LimitExceededError{LimitExceeded: accessverifier.LimitExceeded{Type: limitType}}
+		require.Equal(t, exitCode, err.ExitCode(), limitType)
+	}
+}
+
+func TestLimitExceededMessagesWithoutDetails(t *testing.T) {
+	err := &LimitExceededError{Message: "Your plan does not include this feature."}
+
+	require.Equal(t, []string{"Your plan does not include this feature."}, err.Messages())
+}
+
 func TestConsoleMessages(t *testing.T) {
 	cmd, errBuf, outBuf := setup(t)
 
@@ -79,3 +160,19 @@ func TestConsoleMessages(t *testing.T) {
 	require.Equal(t, "remote: \nremote: console\nremote: message\nremote: \n", errBuf.String())
 	require.Empty(t, outBuf.String())
 }
+
+func TestSessionLogFields(t *testing.T) {
+	cmd, _, _ := setup(t)
+
+	ctx := logger.ContextWithSessionFields(context.Background(), log.Fields{})
+
+	cmd.Args = &commandargs.Shell{GitlabKeyId: "1"}
+	_, err := cmd.Verify(ctx, action, repo)
+	require.NoError(t, err)
+
+	fields := logger.SessionFields(ctx)
+	require.Equal(t, action, fields["command"])
+	require.Equal(t, "user-1", fields["user_id"])
+	require.Equal(t, "alex-doe", fields["username"])
+	require.Equal(t, 1, fields["gl_key_id"])
+}
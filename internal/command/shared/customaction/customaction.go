@@ -14,13 +14,20 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
 )
 
+// payloadVersion 2 tells the internal API that the result may be streamed
+// back as a raw body instead of being embedded in a JSON response.
+const payloadVersion = 2
+
 type Request struct {
 	SecretToken []byte                           `json:"secret_token"`
 	Data        accessverifier.CustomPayloadData `json:"data"`
-	Output      []byte                           `json:"output"`
+	Version     int                              `json:"version"`
+	// Output must remain the last field, it's streamed after the others.
+	Output []byte `json:"output"`
 }
 
 type Response struct {
@@ -56,75 +63,128 @@ func (c *Command) processApiEndpoints(ctx context.Context, response *accessverif
 	}
 
 	data := response.Payload.Data
-	request := &Request{Data: data}
+	request := &Request{Data: data, Version: payloadVersion}
 	request.Data.UserId = response.Who
 
-	for _, endpoint := range data.ApiEndpoints {
-		ctxlog := log.WithContextFields(ctx, log.Fields{
+	for i, endpoint := range data.ApiEndpoints {
+		ctxlog := logger.WithContextFields(ctx, log.Fields{
 			"primary_repo": data.PrimaryRepo,
 			"endpoint":     endpoint,
 		})
 
 		ctxlog.Info("customaction: processApiEndpoints: Performing custom action")
 
-		response, err := c.performRequest(ctx, client, endpoint, request)
-		if err != nil {
-			return err
-		}
+		if i == 0 {
+			if err := c.performRequest(ctx, client, endpoint, request); err != nil {
+				return err
+			}
 
-		// Print to os.Stdout the result contained in the response
-		//
-		if err = c.displayResult(response.Result); err != nil {
-			return err
+			continue
 		}
 
 		// In the context of the git push sequence of events, it's necessary to read
 		// stdin in order to capture output to pass onto subsequent commands
 		//
-		var output []byte
-
-		if c.EOFSent {
-			output, err = c.readFromStdin()
-			if err != nil {
-				return err
-			}
-		} else {
-			output = c.readFromStdinNoEOF()
+		input := &readwriter.CountingReader{R: c.ReadWriter.In}
+		if err := c.performStreamingRequest(ctx, client, endpoint, request, input); err != nil {
+			return err
 		}
+
 		ctxlog.WithFields(log.Fields{
 			"eof_sent":    c.EOFSent,
-			"stdin_bytes": len(output),
-		}).Debug("customaction: processApiEndpoints: stdin buffered")
-
-		request.Output = output
+			"stdin_bytes": input.N,
+		}).Debug("customaction: processApiEndpoints: stdin streamed")
 	}
 
 	return nil
 }
 
-func (c *Command) performRequest(ctx context.Context, client *client.GitlabNetClient, endpoint string, request *Request) (*Response, error) {
+func (c *Command) performRequest(ctx context.Context, client *client.GitlabNetClient, endpoint string, request *Request) error {
 	response, err := client.DoRequest(ctx, http.MethodPost, endpoint, request)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer response.Body.Close()
+
+	return c.displayResponse(response)
+}
+
+// performStreamingRequest sends the data read from input to the endpoint
+// while it's being read, so large pushes don't have to be held in memory.
+func (c *Command) performStreamingRequest(ctx context.Context, client *client.GitlabNetClient, endpoint string, request *Request, input io.Reader) error {
+	maxInFlight, chunkSize := c.windowSize()
+
+	w := newWindow(maxInFlight)
+	go func() {
+		// Stdin is shared with the next endpoints, so only the data relevant
+		// for this one is consumed
+		if c.EOFSent {
+			w.CloseWithError(c.copyFromStdin(w, input))
+		} else {
+			w.CloseWithError(c.copyFromStdinNoEOF(w, input))
+		}
+	}()
+
+	body, err := streamRequestBody(request, w, chunkSize)
+	if err != nil {
+		w.CloseRead()
+		return err
+	}
+	defer body.Close()
+
+	response, err := client.DoStreamingRequest(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return err
 	}
 	defer response.Body.Close()
 
+	return c.displayResponse(response)
+}
+
+func (c *Command) windowSize() (int, int) {
+	chunkSize := c.Config.CustomAction.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	maxInFlight := c.Config.CustomAction.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+	if maxInFlight < chunkSize {
+		maxInFlight = chunkSize
+	}
+
+	return int(maxInFlight), int(chunkSize)
+}
+
+// displayResponse prints the result of a custom action, which is either
+// streamed as the raw response body or embedded in a JSON document.
+func (c *Command) displayResponse(response *http.Response) error {
+	if response.Header.Get("Content-Type") == streamedResultContentType {
+		_, chunkSize := c.windowSize()
+		_, err := io.CopyBuffer(c.ReadWriter.Out, response.Body, make([]byte, chunkSize))
+
+		return err
+	}
+
 	cr := &Response{}
 	if err := gitlabnet.ParseJSON(response, cr); err != nil {
-		return nil, err
+		return err
 	}
 
-	return cr, nil
+	return c.displayResult(cr.Result)
 }
 
-func (c *Command) readFromStdin() ([]byte, error) {
-	var output []byte
+func (c *Command) copyFromStdin(w io.Writer, in io.Reader) error {
 	var needsPackData bool
 
-	scanner := pktline.NewScanner(c.ReadWriter.In)
+	scanner := pktline.NewScanner(in)
 	for scanner.Scan() {
 		line := scanner.Bytes()
-		output = append(output, line...)
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
 
 		if pktline.IsFlush(line) {
 			break
@@ -136,30 +196,27 @@ func (c *Command) readFromStdin() ([]byte, error) {
 	}
 
 	if needsPackData {
-		packData := new(bytes.Buffer)
-		_, err := io.Copy(packData, c.ReadWriter.In)
-
-		output = append(output, packData.Bytes()...)
-		return output, err
-	} else {
-		return output, nil
+		_, err := io.Copy(w, in)
+		return err
 	}
-}
 
-func (c *Command) readFromStdinNoEOF() []byte {
-	var output []byte
+	return nil
+}
 
-	scanner := pktline.NewScanner(c.ReadWriter.In)
+func (c *Command) copyFromStdinNoEOF(w io.Writer, in io.Reader) error {
+	scanner := pktline.NewScanner(in)
 	for scanner.Scan() {
 		line := scanner.Bytes()
-		output = append(output, line...)
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
 
 		if pktline.IsDone(line) {
 			break
 		}
 	}
 
-	return output
+	return nil
 }
 
 func (c *Command) displayResult(result []byte) error {
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -153,3 +154,81 @@ func TestExecuteNoEOFSent(t *testing.T) {
 	// and "output" string from the second request
 	require.Equal(t, "customoutput", outBuf.String())
 }
+
+func TestExecuteStreamsLargePushes(t *testing.T) {
+	commands := "0032want 343d70886785dc1f98aaf70f3b4ca87c93a5d0dd\n0000"
+	packData := strings.Repeat("PACK", 100*1024)
+	input := commands + packData
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/geo/proxy/info_refs_receive_pack",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var request *Request
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+				require.Equal(t, 2, request.Version)
+
+				err := json.NewEncoder(w).Encode(Response{Result: []byte("custom")})
+				require.NoError(t, err)
+			},
+		},
+		{
+			Path: "/geo/proxy/receive_pack",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var request *Request
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+				require.Equal(t, input, string(request.Output))
+
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write([]byte("streamed output"))
+			},
+		},
+	}
+
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	outBuf := &bytes.Buffer{}
+	response := &accessverifier.Response{
+		Who: "key-1",
+		Payload: accessverifier.CustomPayload{
+			Action: "geo_proxy_to_primary",
+			Data: accessverifier.CustomPayloadData{
+				ApiEndpoints: []string{"/geo/proxy/info_refs_receive_pack", "/geo/proxy/receive_pack"},
+				PrimaryRepo:  "https://repo/path",
+			},
+		},
+	}
+
+	cmd := &Command{
+		Config: &config.Config{
+			GitlabUrl:    url,
+			CustomAction: config.CustomActionConfig{ChunkSize: 1024, MaxInFlight: 4096},
+		},
+		ReadWriter: &readwriter.ReadWriter{ErrOut: io.Discard, Out: outBuf, In: io.MultiReader(strings.NewReader(commands), strings.NewReader(packData))},
+		EOFSent:    true,
+	}
+
+	require.NoError(t, cmd.Execute(context.Background(), response))
+	require.Equal(t, "customstreamed output", outBuf.String())
+}
+
+func TestWindow(t *testing.T) {
+	w := newWindow(4)
+
+	go func() {
+		_, err := w.Write([]byte("0123456789"))
+		w.CloseWithError(err)
+	}()
+
+	data, err := io.ReadAll(w)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(data))
+}
+
+func TestWindowCloseRead(t *testing.T) {
+	w := newWindow(4)
+	w.CloseRead()
+
+	_, err := w.Write([]byte("0123456789"))
+	require.Equal(t, io.ErrClosedPipe, err)
+}
@@ -0,0 +1,151 @@
+package customaction
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	defaultChunkSize   = 64 * 1024
+	defaultMaxInFlight = 1024 * 1024
+
+	streamedResultContentType = "application/octet-stream"
+)
+
+// window is an in-memory pipe that buffers up to size bytes. It lets the data
+// sent by the client be read ahead of the upload to the internal API without
+// holding the whole push in memory.
+type window struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	size int
+
+	writeErr   error
+	readClosed bool
+}
+
+func newWindow(size int) *window {
+	w := &window{size: size}
+	w.cond = sync.NewCond(&w.mu)
+
+	return w
+}
+
+func (w *window) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var written int
+	for len(p) > 0 {
+		for w.buf.Len() >= w.size && !w.readClosed {
+			w.cond.Wait()
+		}
+
+		if w.readClosed {
+			return written, io.ErrClosedPipe
+		}
+
+		n := w.size - w.buf.Len()
+		if n > len(p) {
+			n = len(p)
+		}
+
+		w.buf.Write(p[:n])
+		written += n
+		p = p[n:]
+
+		w.cond.Broadcast()
+	}
+
+	return written, nil
+}
+
+// CloseWithError makes reads return err, or io.EOF if err is nil, once the
+// buffered data has been consumed.
+func (w *window) CloseWithError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err == nil {
+		err = io.EOF
+	}
+	w.writeErr = err
+	w.cond.Broadcast()
+}
+
+func (w *window) Read(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for w.buf.Len() == 0 && w.writeErr == nil {
+		w.cond.Wait()
+	}
+
+	if w.buf.Len() == 0 {
+		return 0, w.writeErr
+	}
+
+	n, _ := w.buf.Read(p)
+	w.cond.Broadcast()
+
+	return n, nil
+}
+
+// CloseRead unblocks any pending writes once nobody reads the window anymore.
+func (w *window) CloseRead() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.readClosed = true
+	w.buf.Reset()
+	w.cond.Broadcast()
+}
+
+// streamRequestBody encodes request as JSON with input as its output field.
+// Input is base64 encoded in chunks as the body is read, which produces the
+// same document as marshalling a fully buffered request would.
+func streamRequestBody(request *Request, input *window, chunkSize int) (io.ReadCloser, error) {
+	prefix, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Output is empty so the document ends with `"output":null}`
+	if !bytes.HasSuffix(prefix, []byte("null}")) {
+		return nil, errors.New("Custom action error: unexpected request encoding")
+	}
+	prefix = append(bytes.TrimSuffix(prefix, []byte("null}")), '"')
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer input.CloseRead()
+
+		err := writeRequestBody(pw, prefix, input, chunkSize)
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+func writeRequestBody(w io.Writer, prefix []byte, input io.Reader, chunkSize int) error {
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+	if _, err := io.CopyBuffer(enc, input, make([]byte, chunkSize)); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, `"}`)
+
+	return err
+}
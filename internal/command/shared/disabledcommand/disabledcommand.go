@@ -0,0 +1,26 @@
+package disabledcommand
+
+import (
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
+)
+
+const defaultMessage = "This command has been disabled by your GitLab administrator."
+
+// Error is returned for commands the policy of the instance doesn't allow
+type Error struct {
+	Command commandargs.CommandType
+	Message string
+}
+
+func New(command commandargs.CommandType, message string) error {
+	if message == "" {
+		message = defaultMessage
+	}
+
+	return errorcode.Wrap(errorcode.AccessDenied, &Error{Command: command, Message: message})
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
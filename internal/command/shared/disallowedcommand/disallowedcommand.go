@@ -1,7 +1,7 @@
 package disallowedcommand
 
-import "errors"
+import "gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
 
 var (
-	Error = errors.New("Disallowed command")
+	Error = errorcode.New(errorcode.AccessDenied, "Disallowed command")
 )
@@ -0,0 +1,51 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// Timeout returns how long commands of the given type may run, or zero when
+// they aren't limited
+func Timeout(cfg *config.Config, commandType commandargs.CommandType) time.Duration {
+	switch commandType {
+	case commandargs.ReceivePack:
+		return time.Duration(cfg.Commands.ReceivePackTimeout)
+	case commandargs.UploadPack:
+		return time.Duration(cfg.Commands.UploadPackTimeout)
+	case commandargs.Discover, commandargs.TwoFactorRecover, commandargs.LfsAuthenticate,
+		commandargs.PersonalAccessToken, commandargs.Projects, commandargs.Whoami:
+		return time.Duration(cfg.Commands.APICommandTimeout)
+	}
+
+	return 0
+}
+
+// ExecuteWithTimeout runs cmd with a deadline when timeout is positive. A
+// command failing because it hit the deadline returns an error telling the
+// client why it was aborted.
+func ExecuteWithTimeout(ctx context.Context, cmd Command, timeout time.Duration) (context.Context, error) {
+	if timeout <= 0 {
+		return cmd.Execute(ctx)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ctxWithLogData, err := cmd.Execute(timeoutCtx)
+	if err != nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		logger.WithContextFields(ctx, log.Fields{"timeout_s": timeout.Seconds()}).WithError(err).Warn("command: ExecuteWithTimeout: command aborted after timing out")
+
+		return ctxWithLogData, errorcode.Wrap(errorcode.Timeout, fmt.Errorf("The command was aborted after running for longer than %v.", timeout))
+	}
+
+	return ctxWithLogData, err
+}
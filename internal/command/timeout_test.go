@@ -0,0 +1,65 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+type fakeCommand struct {
+	execute func(ctx context.Context) error
+}
+
+func (f *fakeCommand) Execute(ctx context.Context) (context.Context, error) {
+	return ctx, f.execute(ctx)
+}
+
+func TestTimeout(t *testing.T) {
+	cfg := &config.Config{
+		Commands: config.CommandsConfig{
+			ReceivePackTimeout: config.YamlDuration(time.Hour),
+			UploadPackTimeout:  config.YamlDuration(30 * time.Minute),
+			APICommandTimeout:  config.YamlDuration(time.Minute),
+		},
+	}
+
+	require.Equal(t, time.Hour, Timeout(cfg, commandargs.ReceivePack))
+	require.Equal(t, 30*time.Minute, Timeout(cfg, commandargs.UploadPack))
+	require.Equal(t, time.Minute, Timeout(cfg, commandargs.Discover))
+	require.Equal(t, time.Minute, Timeout(cfg, commandargs.PersonalAccessToken))
+	require.Zero(t, Timeout(cfg, commandargs.TwoFactorVerify))
+	require.Zero(t, Timeout(cfg, commandargs.LfsTransfer))
+	require.Zero(t, Timeout(&config.Config{}, commandargs.ReceivePack))
+}
+
+func TestExecuteWithTimeout(t *testing.T) {
+	blocking := &fakeCommand{execute: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	_, err := ExecuteWithTimeout(context.Background(), blocking, 10*time.Millisecond)
+	require.EqualError(t, err, "The command was aborted after running for longer than 10ms.")
+
+	failing := &fakeCommand{execute: func(ctx context.Context) error {
+		return errors.New("failed")
+	}}
+
+	_, err = ExecuteWithTimeout(context.Background(), failing, time.Minute)
+	require.EqualError(t, err, "failed")
+
+	succeeding := &fakeCommand{execute: func(ctx context.Context) error {
+		_, hasDeadline := ctx.Deadline()
+		require.False(t, hasDeadline)
+		return nil
+	}}
+
+	_, err = ExecuteWithTimeout(context.Background(), succeeding, 0)
+	require.NoError(t, err)
+}
@@ -2,9 +2,11 @@ package twofactorrecover
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"gitlab.com/gitlab-org/labkit/log"
 
@@ -12,9 +14,40 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/twofactorrecover"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
 )
 
-const readerLimit = 1024
+const (
+	readerLimit = 1024
+	forceFlag   = "--force"
+)
+
+// answerTimeout bounds how long the confirmation prompt waits for the user.
+var answerTimeout = time.Minute
+
+// confirmation is how the regeneration of the codes was confirmed, or not
+type confirmation int
+
+const (
+	declined confirmation = iota
+	confirmed
+	forced
+	timedOut
+)
+
+func (c confirmation) String() string {
+	switch c {
+	case confirmed:
+		return "yes"
+	case forced:
+		return "forced"
+	case timedOut:
+		return "timeout"
+	default:
+		return "no"
+	}
+}
 
 type Command struct {
 	Config     *config.Config
@@ -23,51 +56,96 @@ type Command struct {
 }
 
 func (c *Command) Execute(ctx context.Context) (context.Context, error) {
-	ctxlog := log.ContextLogger(ctx)
-	ctxlog.Debug("twofactorrecover: execute: Waiting for user input")
+	ctxlog := logger.ContextLogger(ctx)
 
-	if c.getUserAnswer(ctx) == "yes" {
-		ctxlog.Debug("twofactorrecover: execute: User chose to continue")
+	force, err := c.parseForce()
+	if err != nil {
+		return ctx, err
+	}
+
+	answer := forced
+	if !force {
+		ctxlog.Debug("twofactorrecover: execute: Waiting for user input")
+		answer = c.getUserAnswer(ctx)
+	}
+
+	ctxlog = ctxlog.WithFields(log.Fields{"forced": force, "confirmation": answer.String()})
+
+	switch answer {
+	case confirmed, forced:
+		ctxlog.Info("twofactorrecover: execute: Regenerating recovery codes")
 		c.displayRecoveryCodes(ctx)
-	} else {
-		ctxlog.Debug("twofactorrecover: execute: User chose not to continue")
-		fmt.Fprintln(c.ReadWriter.Out, "\nNew recovery codes have *not* been generated. Existing codes will remain valid.")
+	case timedOut:
+		ctxlog.Info("twofactorrecover: execute: User did not answer in time")
+		fmt.Fprintln(c.ReadWriter.Out, "\n"+i18n.T(ctx, "No answer received in time. New recovery codes have *not* been generated. Existing codes will remain valid."))
+	default:
+		ctxlog.Info("twofactorrecover: execute: User chose not to continue")
+		fmt.Fprintln(c.ReadWriter.Out, "\n"+i18n.T(ctx, "New recovery codes have *not* been generated. Existing codes will remain valid."))
 	}
 
 	return ctx, nil
 }
 
-func (c *Command) getUserAnswer(ctx context.Context) string {
-	question :=
-		"Are you sure you want to generate new two-factor recovery codes?\n" +
-			"Any existing recovery codes you saved will be invalidated. (yes/no)"
+func (c *Command) parseForce() (bool, error) {
+	switch args := c.Args.SshArgs; {
+	case len(args) <= 1:
+		return false, nil
+	case len(args) == 2 && args[1] == forceFlag:
+		return true, nil
+	default:
+		return false, errors.New("Usage: 2fa_recovery_codes [--force]")
+	}
+}
+
+// getUserAnswer returns whether the user confirmed the prompt, or timedOut
+// when no answer was given within answerTimeout.
+func (c *Command) getUserAnswer(ctx context.Context) confirmation {
+	question := i18n.T(ctx,
+		"Are you sure you want to generate new two-factor recovery codes?\n"+
+			"Any existing recovery codes you saved will be invalidated. (yes/no)")
 	fmt.Fprintln(c.ReadWriter.Out, question)
 
-	var answer string
-	if _, err := fmt.Fscanln(io.LimitReader(c.ReadWriter.In, readerLimit), &answer); err != nil {
-		log.ContextLogger(ctx).WithError(err).Debug("twofactorrecover: getUserAnswer: Failed to get user input")
+	ctx, cancel := context.WithTimeout(ctx, answerTimeout)
+	defer cancel()
+
+	answerCh := make(chan string, 1)
+	go func() {
+		var answer string
+		if _, err := fmt.Fscanln(io.LimitReader(c.ReadWriter.In, readerLimit), &answer); err != nil {
+			logger.ContextLogger(ctx).WithError(err).Debug("twofactorrecover: getUserAnswer: Failed to get user input")
+		}
+		answerCh <- answer
+	}()
+
+	select {
+	case answer := <-answerCh:
+		if answer == "yes" {
+			return confirmed
+		}
+
+		return declined
+	case <-ctx.Done():
+		return timedOut
 	}
-
-	return answer
 }
 
 func (c *Command) displayRecoveryCodes(ctx context.Context) {
-	ctxlog := log.ContextLogger(ctx)
+	ctxlog := logger.ContextLogger(ctx)
 
 	codes, err := c.getRecoveryCodes(ctx)
 
 	if err == nil {
 		ctxlog.Debug("twofactorrecover: displayRecoveryCodes: recovery codes successfully generated")
 		messageWithCodes :=
-			"\nYour two-factor authentication recovery codes are:\n\n" +
-				strings.Join(codes, "\n") +
-				"\n\nDuring sign in, use one of the codes above when prompted for\n" +
-				"your two-factor code. Then, visit your Profile Settings and add\n" +
-				"a new device so you do not lose access to your account again.\n"
+			"\n" + i18n.T(ctx, "Your two-factor authentication recovery codes are:") + "\n\n" +
+				strings.Join(codes, "\n") + "\n\n" +
+				i18n.T(ctx, "During sign in, use one of the codes above when prompted for\n"+
+					"your two-factor code. Then, visit your Profile Settings and add\n"+
+					"a new device so you do not lose access to your account again.") + "\n"
 		fmt.Fprint(c.ReadWriter.Out, messageWithCodes)
 	} else {
 		ctxlog.WithError(err).Error("twofactorrecover: displayRecoveryCodes: failed to generate recovery codes")
-		fmt.Fprintf(c.ReadWriter.Out, "\nAn error occurred while trying to generate new recovery codes.\n%v\n", err)
+		fmt.Fprintf(c.ReadWriter.Out, "\n%v\n%v\n", i18n.T(ctx, "An error occurred while trying to generate new recovery codes."), err)
 	}
 }
 
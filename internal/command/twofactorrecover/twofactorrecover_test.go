@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -112,6 +113,13 @@ func TestExecute(t *testing.T) {
 			expectedOutput: question +
 				"New recovery codes have *not* been generated. Existing codes will remain valid.\n",
 		},
+		{
+			desc:      "With an answer spelling a confirmation outcome",
+			arguments: &commandargs.Shell{GitlabKeyId: "1"},
+			answer:    "forced\n",
+			expectedOutput: question +
+				"New recovery codes have *not* been generated. Existing codes will remain valid.\n",
+		},
 		{
 			desc:      "With some other answer",
 			arguments: &commandargs.Shell{},
@@ -139,3 +147,47 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteWithForce(t *testing.T) {
+	setup(t)
+
+	url := testserver.StartSocketHttpServer(t, requests)
+	output := &bytes.Buffer{}
+
+	cmd := &Command{
+		Config:     &config.Config{GitlabUrl: url},
+		Args:       &commandargs.Shell{GitlabKeyId: "1", SshArgs: []string{"2fa_recovery_codes", "--force"}},
+		ReadWriter: &readwriter.ReadWriter{Out: output, In: &bytes.Buffer{}},
+	}
+
+	_, err := cmd.Execute(context.Background())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(output.String(), "\nYour two-factor authentication recovery codes are:\n\nrecovery\ncodes\n"))
+}
+
+func TestExecuteWithUnknownArguments(t *testing.T) {
+	cmd := &Command{
+		Args:       &commandargs.Shell{GitlabKeyId: "1", SshArgs: []string{"2fa_recovery_codes", "--yes"}},
+		ReadWriter: &readwriter.ReadWriter{Out: &bytes.Buffer{}, In: &bytes.Buffer{}},
+	}
+
+	_, err := cmd.Execute(context.Background())
+	require.EqualError(t, err, "Usage: 2fa_recovery_codes [--force]")
+}
+
+func TestExecuteWithoutAnswer(t *testing.T) {
+	defer func(timeout time.Duration) { answerTimeout = timeout }(answerTimeout)
+	answerTimeout = 10 * time.Millisecond
+
+	output := &bytes.Buffer{}
+	input, _ := io.Pipe()
+
+	cmd := &Command{
+		Args:       &commandargs.Shell{GitlabKeyId: "1"},
+		ReadWriter: &readwriter.ReadWriter{Out: output, In: input},
+	}
+
+	_, err := cmd.Execute(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, question+"No answer received in time. New recovery codes have *not* been generated. Existing codes will remain valid.\n", output.String())
+}
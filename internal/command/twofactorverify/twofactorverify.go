@@ -2,6 +2,7 @@ package twofactorverify
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"time"
@@ -12,11 +13,17 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/twofactorverify"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/usermessage"
 )
 
 const (
-	timeout = 30 * time.Second
-	prompt  = "OTP: "
+	defaultTimeout = 30 * time.Second
+	prompt         = "OTP: "
+
+	methodOTP  = "otp"
+	methodPush = "push"
 )
 
 type Command struct {
@@ -25,66 +32,112 @@ type Command struct {
 	ReadWriter *readwriter.ReadWriter
 }
 
+type result struct {
+	method string
+	err    error
+}
+
+// Execute waits for the user to either type an OTP or approve a push
+// authentication request. Whichever succeeds first wins and the other one is
+// canceled. A failed push authentication doesn't end the command since the
+// user may still type an OTP.
 func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 	client, err := twofactorverify.NewClient(c.Config)
 	if err != nil {
 		return ctx, err
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+	verifyCtx, cancel := context.WithTimeout(ctx, c.timeout())
 	defer cancel()
 
-	fmt.Fprint(c.ReadWriter.Out, prompt)
+	fmt.Fprint(c.ReadWriter.Out, c.render(ctx, usermessage.OTPPrompt, usermessage.Data{}, i18n.T(ctx, prompt)))
 
-	resultCh := make(chan string)
+	resultCh := make(chan result, 2)
 	go func() {
-		err := client.PushAuth(ctx, c.Args)
-		if err == nil {
-			resultCh <- "OTP has been validated by Push Authentication. Git operations are now allowed."
-		}
+		resultCh <- result{method: methodPush, err: client.PushAuth(verifyCtx, c.Args)}
 	}()
 
 	go func() {
-		answer, err := c.getOTP(ctx)
-		if err != nil {
-			resultCh <- formatErr(err)
+		answer, err := c.getOTP(verifyCtx)
+		if err == nil {
+			err = client.VerifyOTP(verifyCtx, c.Args, answer)
 		}
 
-		if err := client.VerifyOTP(ctx, c.Args, answer); err != nil {
-			resultCh <- formatErr(err)
-		} else {
-			resultCh <- "OTP validation successful. Git operations are now allowed."
-		}
+		resultCh <- result{method: methodOTP, err: err}
 	}()
 
-	var message string
-	select {
-	case message = <-resultCh:
-	case <-ctx.Done():
-		message = formatErr(ctx.Err())
-	}
+	res := c.waitForResult(verifyCtx, resultCh)
 
-	log.WithContextFields(ctx, log.Fields{"message": message}).Info("Two factor verify command finished")
+	message := c.formatResult(ctx, res)
+	fields := log.Fields{"message": message, "method": res.method}
+	if res.err == nil {
+		logger.AddSessionFields(ctx, log.Fields{"two_factor_method": res.method})
+	}
+	logger.WithContextFields(ctx, fields).Info("Two factor verify command finished")
 	fmt.Fprintf(c.ReadWriter.Out, "\n%v\n", message)
 
 	return ctx, nil
 }
 
+func (c *Command) waitForResult(ctx context.Context, resultCh <-chan result) result {
+	var pushErr error
+
+	for pending := 2; pending > 0; pending-- {
+		select {
+		case res := <-resultCh:
+			if res.err == nil || res.method == methodOTP {
+				return res
+			}
+
+			pushErr = res.err
+		case <-ctx.Done():
+			return result{err: ctx.Err()}
+		}
+	}
+
+	return result{method: methodPush, err: pushErr}
+}
+
+func (c *Command) timeout() time.Duration {
+	if c.Config.TwoFactor.VerifyTimeout > 0 {
+		return time.Duration(c.Config.TwoFactor.VerifyTimeout)
+	}
+
+	return defaultTimeout
+}
+
 func (c *Command) getOTP(ctx context.Context) (string, error) {
 	var answer string
 	otpLength := int64(64)
 	reader := io.LimitReader(c.ReadWriter.In, otpLength)
 	if _, err := fmt.Fscanln(reader, &answer); err != nil {
-		log.ContextLogger(ctx).WithError(err).Debug("twofactorverify: getOTP: Failed to get user input")
+		logger.ContextLogger(ctx).WithError(err).Debug("twofactorverify: getOTP: Failed to get user input")
 	}
 
 	if answer == "" {
-		return "", fmt.Errorf("OTP cannot be blank.")
+		return "", errors.New(i18n.T(ctx, "OTP cannot be blank."))
 	}
 
 	return answer, nil
 }
 
-func formatErr(err error) string {
-	return fmt.Sprintf("OTP validation failed: %v", err)
+func (c *Command) formatResult(ctx context.Context, res result) string {
+	switch {
+	case res.err != nil:
+		return c.render(ctx, usermessage.OTPFailed, usermessage.Data{Error: res.err.Error()},
+			i18n.Sprintf(ctx, "OTP validation failed: %v", res.err))
+	case res.method == methodPush:
+		return c.render(ctx, usermessage.OTPPushSuccess, usermessage.Data{},
+			i18n.T(ctx, "OTP has been validated by Push Authentication. Git operations are now allowed."))
+	default:
+		return c.render(ctx, usermessage.OTPSuccess, usermessage.Data{},
+			i18n.T(ctx, "OTP validation successful. Git operations are now allowed."))
+	}
+}
+
+// render returns the override of the message id, if any, or message
+func (c *Command) render(ctx context.Context, id usermessage.ID, data usermessage.Data, message string) string {
+	data.Username = c.Args.GitlabUsername
+
+	return usermessage.Render(ctx, c.Config.Messages, id, data, message)
 }
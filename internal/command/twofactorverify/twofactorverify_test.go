@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -15,6 +16,7 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/twofactorverify"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
 )
 
 type blockingReader struct{}
@@ -168,6 +170,28 @@ func TestExecute(t *testing.T) {
 	}
 }
 
+func TestMessageOverrides(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, setup(t))
+
+	output := &bytes.Buffer{}
+	cmd := &Command{
+		Config: &config.Config{
+			GitlabUrl: url,
+			Messages: map[string]string{
+				"otp_prompt":  "Code for {{.Username}}: ",
+				"otp_success": "Verified.",
+			},
+		},
+		Args:       &commandargs.Shell{GitlabKeyId: "verify_via_otp", GitlabUsername: "alex-doe"},
+		ReadWriter: &readwriter.ReadWriter{Out: output, In: bytes.NewBufferString("123456\n")},
+	}
+
+	_, err := cmd.Execute(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, "Code for alex-doe: \nVerified.\n", output.String())
+}
+
 func TestCanceledContext(t *testing.T) {
 	requests := setup(t)
 
@@ -192,3 +216,41 @@ func TestCanceledContext(t *testing.T) {
 	require.NoError(t, <-errCh)
 	require.Equal(t, prompt+"\n"+errorHeader+"context canceled\n", output.String())
 }
+
+func TestTimeout(t *testing.T) {
+	requests := setup(t)
+
+	output := &bytes.Buffer{}
+
+	url := testserver.StartSocketHttpServer(t, requests)
+	cmd := &Command{
+		Config: &config.Config{
+			GitlabUrl: url,
+			TwoFactor: config.TwoFactorConfig{VerifyTimeout: config.YamlDuration(10 * time.Millisecond)},
+		},
+		Args:       &commandargs.Shell{GitlabKeyId: "wait_infinitely"},
+		ReadWriter: &readwriter.ReadWriter{Out: output, In: &blockingReader{}},
+	}
+
+	_, err := cmd.Execute(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, prompt+"\n"+errorHeader+"context deadline exceeded\n", output.String())
+}
+
+func TestSuccessfulMethodIsLogged(t *testing.T) {
+	requests := setup(t)
+
+	url := testserver.StartSocketHttpServer(t, requests)
+	cmd := &Command{
+		Config:     &config.Config{GitlabUrl: url},
+		Args:       &commandargs.Shell{GitlabKeyId: "verify_via_push"},
+		ReadWriter: &readwriter.ReadWriter{Out: io.Discard, In: &blockingReader{}},
+	}
+
+	ctx := logger.ContextWithSessionFields(context.Background(), nil)
+	_, err := cmd.Execute(ctx)
+
+	require.NoError(t, err)
+	require.Equal(t, "push", logger.SessionFields(ctx)["two_factor_method"])
+}
@@ -21,7 +21,9 @@ func (c *Command) performGitalyCall(ctx context.Context, response *accessverifie
 		ctx, cancel := gc.PrepareContext(ctx, request.Repository, c.Args.Env)
 		defer cancel()
 
-		rw := c.ReadWriter
+		rw, release := gc.LimitBandwidth(ctx, c.ReadWriter)
+		defer release()
+
 		return client.UploadArchive(ctx, conn, rw.In, rw.Out, rw.ErrOut, request)
 	})
 }
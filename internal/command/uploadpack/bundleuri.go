@@ -0,0 +1,67 @@
+package uploadpack
+
+import (
+	"fmt"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+const bundleURIProjectPlaceholder = "{project}"
+
+// gitConfigOptions returns the Git configuration of the fetch: the options
+// returned by the internal API, along with the ones advertising the bundles
+// of the repository to Git protocol v2 clients, see bundle.* in
+// git-config(1). Clients enabling transfer.bundleURI download the bundles
+// before fetching the objects they miss from Gitaly.
+func gitConfigOptions(cfg config.BundleURIConfig, response *accessverifier.Response, protocol string) []string {
+	options := response.GitConfigOptions
+
+	if version, err := sshenv.ProtocolVersion(protocol); err != nil || version != 2 {
+		return options
+	}
+
+	uris := bundleURIs(cfg, response)
+	if len(uris) == 0 {
+		return options
+	}
+
+	mode := cfg.Mode
+	if mode == "" {
+		mode = config.BundleURIModeAll
+	}
+
+	// The options of the response aren't modified, they may be cached
+	options = append(options[:len(options):len(options)], "uploadpack.advertiseBundleURIs=true", "bundle.version=1", "bundle.mode="+mode)
+	for i, uri := range uris {
+		options = append(options, fmt.Sprintf("bundle.bundle-%d.uri=%s", i+1, uri))
+	}
+
+	return options
+}
+
+// bundleURIs returns the bundles of the project returned by the internal
+// API, or else the configured ones
+func bundleURIs(cfg config.BundleURIConfig, response *accessverifier.Response) []string {
+	if len(response.BundleURIs) > 0 {
+		return response.BundleURIs
+	}
+
+	project := response.Gitaly.Repo.GlProjectPath
+
+	var uris []string
+	for _, uri := range cfg.URIs {
+		if strings.Contains(uri, bundleURIProjectPlaceholder) {
+			if project == "" {
+				continue
+			}
+			uri = strings.ReplaceAll(uri, bundleURIProjectPlaceholder, project)
+		}
+
+		uris = append(uris, uri)
+	}
+
+	return uris
+}
@@ -0,0 +1,92 @@
+package uploadpack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+)
+
+func TestGitConfigOptions(t *testing.T) {
+	cfg := config.BundleURIConfig{URIs: []string{"https://bundles.example.com/{project}.bundle", "https://bundles.example.com/base.bundle"}}
+
+	testCases := []struct {
+		desc       string
+		cfg        config.BundleURIConfig
+		bundleURIs []string
+		project    string
+		protocol   string
+		expected   []string
+	}{
+		{
+			desc:     "protocol v0",
+			cfg:      cfg,
+			project:  "group/project",
+			expected: []string{"option"},
+		}, {
+			desc:     "invalid protocol",
+			cfg:      cfg,
+			project:  "group/project",
+			protocol: "version=2:",
+			expected: []string{"option"},
+		}, {
+			desc:     "no bundles",
+			project:  "group/project",
+			protocol: "version=2",
+			expected: []string{"option"},
+		}, {
+			desc:     "configured bundles",
+			cfg:      cfg,
+			project:  "group/project",
+			protocol: "version=2",
+			expected: []string{
+				"option",
+				"uploadpack.advertiseBundleURIs=true",
+				"bundle.version=1",
+				"bundle.mode=all",
+				"bundle.bundle-1.uri=https://bundles.example.com/group/project.bundle",
+				"bundle.bundle-2.uri=https://bundles.example.com/base.bundle",
+			},
+		}, {
+			desc:     "configured bundles without a project",
+			cfg:      config.BundleURIConfig{URIs: cfg.URIs, Mode: config.BundleURIModeAny},
+			protocol: "version=2",
+			expected: []string{
+				"option",
+				"uploadpack.advertiseBundleURIs=true",
+				"bundle.version=1",
+				"bundle.mode=any",
+				"bundle.bundle-1.uri=https://bundles.example.com/base.bundle",
+			},
+		}, {
+			desc:       "bundles of the project",
+			cfg:        cfg,
+			bundleURIs: []string{"https://objects.example.com/project.bundle"},
+			project:    "group/project",
+			protocol:   "version=2",
+			expected: []string{
+				"option",
+				"uploadpack.advertiseBundleURIs=true",
+				"bundle.version=1",
+				"bundle.mode=all",
+				"bundle.bundle-1.uri=https://objects.example.com/project.bundle",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			response := &accessverifier.Response{
+				GitConfigOptions: []string{"option"},
+				BundleURIs:       tc.bundleURIs,
+				Gitaly:           accessverifier.Gitaly{Repo: pb.Repository{GlProjectPath: tc.project}},
+			}
+
+			require.Equal(t, tc.expected, gitConfigOptions(tc.cfg, response, tc.protocol))
+			require.Equal(t, []string{"option"}, response.GitConfigOptions)
+		})
+	}
+}
@@ -2,43 +2,107 @@ package uploadpack
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 
 	"gitlab.com/gitlab-org/gitaly/v16/client"
 	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/handler"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 )
 
-func (c *Command) performGitalyCall(ctx context.Context, response *accessverifier.Response) (*pb.PackfileNegotiationStatistics, error) {
+// performGitalyCall proxies the fetch to Gitaly, reading it from stdin rather
+// than c.ReadWriter.In so that it can be inspected on the way
+func (c *Command) performGitalyCall(ctx context.Context, response *accessverifier.Response, stdin io.Reader) (*pb.PackfileNegotiationStatistics, error) {
 	gc := handler.NewGitalyCommand(c.Config, string(commandargs.UploadPack), response)
 
-	request := &pb.SSHUploadPackWithSidechannelRequest{
-		Repository:       &response.Gitaly.Repo,
-		GitProtocol:      c.Args.Env.GitProtocolVersion,
-		GitConfigOptions: response.GitConfigOptions,
+	protocol, err := gc.GitProtocol(ctx, c.Args.Env)
+	if err != nil {
+		return nil, err
 	}
 
 	var stats *pb.PackfileNegotiationStatistics
-	err := gc.RunGitalyCommand(ctx, func(ctx context.Context, conn *grpc.ClientConn) (int32, error) {
-		ctx, cancel := gc.PrepareContext(ctx, request.Repository, c.Args.Env)
+	err = gc.RunGitalyCommand(ctx, func(ctx context.Context, conn *grpc.ClientConn) (int32, error) {
+		ctx, cancel := gc.PrepareContext(ctx, &response.Gitaly.Repo, c.Args.Env)
 		defer cancel()
 
-		registry := c.Config.GitalyClient.SidechannelRegistry
-		rw := c.ReadWriter
+		rw := &readwriter.ReadWriter{Out: c.ReadWriter.Out, In: stdin, ErrOut: c.ReadWriter.ErrOut}
+
+		rw, release := gc.LimitBandwidth(ctx, rw)
+		defer release()
+
+		if c.Config.Gitaly.UploadPackTransport == config.GitalyTransportStreaming {
+			return c.uploadPack(ctx, conn, rw, response, protocol)
+		}
+
+		exitCode, result, err := c.uploadPackWithSidechannel(ctx, conn, rw, response, protocol)
+		if grpcstatus.Code(err) == grpccodes.Unimplemented {
+			// Nothing has been sent to the client yet, so it's safe to retry
+			// over the standard streaming RPC.
+			logger.ContextLogger(ctx).WithError(err).Warn("uploadpack: sidechannel is not supported by Gitaly, falling back to streaming")
 
-		var (
-			result client.UploadPackResult
-			err    error
-		)
-		result, err = client.UploadPackWithSidechannelWithResult(ctx, conn, registry, rw.In, rw.Out, rw.ErrOut, request)
+			return c.uploadPack(ctx, conn, rw, response, protocol)
+		}
 		if err == nil {
 			stats = result.PackfileNegotiationStatistics
 		}
-		return result.ExitCode, err
+
+		return exitCode, err
 	})
 
 	return stats, err
 }
+
+func (c *Command) uploadPackWithSidechannel(ctx context.Context, conn *grpc.ClientConn, rw *readwriter.ReadWriter, response *accessverifier.Response, protocol string) (int32, client.UploadPackResult, error) {
+	request := &pb.SSHUploadPackWithSidechannelRequest{
+		Repository:       &response.Gitaly.Repo,
+		GitProtocol:      protocol,
+		GitConfigOptions: gitConfigOptions(c.Config.Commands.BundleURI, response, protocol),
+	}
+
+	registry := c.Config.GitalyClient.SidechannelRegistry
+	out := &readwriter.CountingWriter{W: rw.Out}
+
+	started := time.Now()
+	result, err := client.UploadPackWithSidechannelWithResult(ctx, conn, registry, rw.In, out, rw.ErrOut, request)
+	if err == nil {
+		observeThroughput(config.GitalyTransportSidechannel, out.N, time.Since(started))
+	}
+
+	return result.ExitCode, result, err
+}
+
+func (c *Command) uploadPack(ctx context.Context, conn *grpc.ClientConn, rw *readwriter.ReadWriter, response *accessverifier.Response, protocol string) (int32, error) {
+	request := &pb.SSHUploadPackRequest{
+		Repository:       &response.Gitaly.Repo,
+		GitProtocol:      protocol,
+		GitConfigOptions: gitConfigOptions(c.Config.Commands.BundleURI, response, protocol),
+	}
+
+	out := &readwriter.CountingWriter{W: rw.Out}
+
+	started := time.Now()
+	exitCode, err := client.UploadPack(ctx, conn, rw.In, out, rw.ErrOut, request)
+	if err == nil {
+		observeThroughput(config.GitalyTransportStreaming, out.N, time.Since(started))
+	}
+
+	return exitCode, err
+}
+
+func observeThroughput(transport string, bytes int64, duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+
+	metrics.GitalyTransferThroughput.WithLabelValues(string(commandargs.UploadPack), transport).Observe(float64(bytes) / duration.Seconds())
+}
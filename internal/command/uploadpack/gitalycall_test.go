@@ -80,3 +80,35 @@ func TestUploadPack(t *testing.T) {
 		})
 	}
 }
+
+func TestUploadPackWithStreamingTransport(t *testing.T) {
+	gitalyAddress, _ := testserver.StartGitalyServer(t, "unix")
+	requests := requesthandlers.BuildAllowedWithGitalyHandlers(t, gitalyAddress)
+	url := testserver.StartHttpServer(t, requests)
+
+	output := &bytes.Buffer{}
+	repo := "group/repo"
+
+	args := &commandargs.Shell{
+		GitlabKeyId: "1",
+		CommandType: commandargs.UploadPack,
+		SshArgs:     []string{"git-upload-pack", repo},
+		Env:         sshenv.Env{IsSSHConnection: true, OriginalCommand: "git-upload-pack " + repo, RemoteAddr: "127.0.0.1"},
+	}
+
+	ctx := correlation.ContextWithClientName(context.Background(), "gitlab-shell-tests")
+
+	cfg := &config.Config{GitlabUrl: url}
+	cfg.Gitaly.UploadPackTransport = config.GitalyTransportStreaming
+	cfg.GitalyClient.InitSidechannelRegistry(ctx)
+
+	cmd := &Command{
+		Config:     cfg,
+		Args:       args,
+		ReadWriter: &readwriter.ReadWriter{ErrOut: output, Out: output, In: &bytes.Buffer{}},
+	}
+
+	_, err := cmd.Execute(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "UploadPack: "+repo, output.String())
+}
@@ -12,6 +12,7 @@ import (
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/customaction"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/partialclone"
 )
 
 type Command struct {
@@ -57,13 +58,15 @@ func (c *Command) Execute(ctx context.Context) (context.Context, error) {
 		return ctxWithLogData, customAction.Execute(ctx, response)
 	}
 
-	stats, err := c.performGitalyCall(ctx, response)
+	filters := partialclone.NewReader(c.ReadWriter.In, c.Config.PartialClone.DeniedFilters)
+
+	stats, err := c.performGitalyCall(ctx, response, filters)
 	if err != nil {
 		return ctxWithLogData, err
 	}
 
 	if response.NeedAudit {
-		gitauditevent.Audit(ctx, c.Args.CommandType, c.Config, response, stats)
+		gitauditevent.Audit(ctx, c.Args.CommandType, c.Config, response, stats, nil)
 	}
 	return ctxWithLogData, nil
 }
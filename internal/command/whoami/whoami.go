@@ -0,0 +1,71 @@
+package whoami
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/discover"
+)
+
+type Command struct {
+	Config     *config.Config
+	Args       *commandargs.Shell
+	ReadWriter *readwriter.ReadWriter
+}
+
+func (c *Command) Execute(ctx context.Context) (context.Context, error) {
+	client, err := discover.New(c.Config)
+	if err != nil {
+		return ctx, err
+	}
+
+	response, err := client.GetByCommandArgs(ctx, c.Args)
+	if err != nil {
+		return ctx, fmt.Errorf("Failed to get user information: %w", err)
+	}
+
+	logData := command.LogData{Username: "Anonymous"}
+	if response.IsAnonymous() {
+		fmt.Fprint(c.ReadWriter.Out, "You are not authenticated as a GitLab user.\n")
+
+		return context.WithValue(ctx, "logData", logData), nil
+	}
+	logData.Username = response.Username
+
+	w := tabwriter.NewWriter(c.ReadWriter.Out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Username:\t@%s\n", response.Username)
+	fmt.Fprintf(w, "Name:\t%s\n", response.Name)
+	fmt.Fprintf(w, "User ID:\t%d\n", response.UserId)
+	if c.Args.GitlabKeyId != "" {
+		fmt.Fprintf(w, "Key ID:\t%s\n", c.Args.GitlabKeyId)
+		fmt.Fprintf(w, "Key expires:\t%s\n", keyExpiry(response))
+	}
+	fmt.Fprintf(w, "Two-factor authentication:\t%s\n", twoFactorStatus(response))
+
+	if err := w.Flush(); err != nil {
+		return ctx, err
+	}
+
+	return context.WithValue(ctx, "logData", logData), nil
+}
+
+func keyExpiry(response *discover.Response) string {
+	if response.KeyExpiresAt == "" {
+		return "never"
+	}
+
+	return response.KeyExpiresAt
+}
+
+func twoFactorStatus(response *discover.Response) string {
+	if response.TwoFactorEnabled {
+		return "enabled"
+	}
+
+	return "disabled"
+}
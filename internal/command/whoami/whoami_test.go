@@ -0,0 +1,118 @@
+package whoami
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+var requests = []testserver.TestRequestHandler{
+	{
+		Path: "/api/v4/internal/discover",
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Query().Get("key_id") == "1":
+				body := map[string]interface{}{
+					"id":                 2,
+					"username":           "alex-doe",
+					"name":               "Alex Doe",
+					"key_expires_at":     "2030-01-01",
+					"two_factor_enabled": true,
+				}
+				json.NewEncoder(w).Encode(body)
+			case r.URL.Query().Get("username") == "alex-doe":
+				body := map[string]interface{}{
+					"id":       2,
+					"username": "alex-doe",
+					"name":     "Alex Doe",
+				}
+				json.NewEncoder(w).Encode(body)
+			case r.URL.Query().Get("username") == "broken":
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				fmt.Fprint(w, "null")
+			}
+		},
+	},
+}
+
+func TestExecute(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	testCases := []struct {
+		desc             string
+		arguments        *commandargs.Shell
+		expectedOutput   string
+		expectedUsername string
+	}{
+		{
+			desc:      "With a known key id",
+			arguments: &commandargs.Shell{GitlabKeyId: "1"},
+			expectedOutput: "Username:                  @alex-doe\n" +
+				"Name:                      Alex Doe\n" +
+				"User ID:                   2\n" +
+				"Key ID:                    1\n" +
+				"Key expires:               2030-01-01\n" +
+				"Two-factor authentication: enabled\n",
+			expectedUsername: "alex-doe",
+		},
+		{
+			desc:      "With a known username",
+			arguments: &commandargs.Shell{GitlabUsername: "alex-doe"},
+			expectedOutput: "Username:                  @alex-doe\n" +
+				"Name:                      Alex Doe\n" +
+				"User ID:                   2\n" +
+				"Two-factor authentication: disabled\n",
+			expectedUsername: "alex-doe",
+		},
+		{
+			desc:             "With an unknown key",
+			arguments:        &commandargs.Shell{GitlabKeyId: "-1"},
+			expectedOutput:   "You are not authenticated as a GitLab user.\n",
+			expectedUsername: "Anonymous",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			buffer := &bytes.Buffer{}
+			cmd := &Command{
+				Config:     &config.Config{GitlabUrl: url},
+				Args:       tc.arguments,
+				ReadWriter: &readwriter.ReadWriter{Out: buffer},
+			}
+
+			ctxWithLogData, err := cmd.Execute(context.Background())
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOutput, buffer.String())
+
+			logData := ctxWithLogData.Value("logData").(command.LogData)
+			require.Equal(t, tc.expectedUsername, logData.Username)
+		})
+	}
+}
+
+func TestFailingExecute(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	cmd := &Command{
+		Config:     &config.Config{GitlabUrl: url},
+		Args:       &commandargs.Shell{GitlabUsername: "broken"},
+		ReadWriter: &readwriter.ReadWriter{Out: &bytes.Buffer{}},
+	}
+
+	_, err := cmd.Execute(context.Background())
+	require.EqualError(t, err, "Failed to get user information: Internal API unreachable")
+}
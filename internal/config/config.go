@@ -2,23 +2,62 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"gopkg.in/yaml.v3"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/accesscache"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/apicapture"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bandwidth"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/faultinject"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitaly"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/maintenance"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/usermessage"
 )
 
 const (
-	configFile            = "config.yml"
-	defaultSecretFileName = ".gitlab_shell_secret"
+	defaultSecretFileName     = ".gitlab_shell_secret"
+	defaultCaptureLogFileName = "gitlab-shell-api-capture.log"
+
+	// GitalyTransportSidechannel transfers pack data over a Gitaly sidechannel.
+	GitalyTransportSidechannel = "sidechannel"
+	// GitalyTransportStreaming transfers pack data over standard gRPC streams.
+	GitalyTransportStreaming = "streaming"
+
+	// InternalAPIClientLegacy decodes the responses of the internal API as
+	// they come, leaving the fields that don't match empty.
+	InternalAPIClientLegacy = "legacy"
+	// InternalAPIClientV2 validates the responses of the internal API against
+	// its OpenAPI spec.
+	InternalAPIClientV2 = "v2"
+
+	// ProtocolV2Allow passes on the Git protocol version requested by clients.
+	ProtocolV2Allow = "allow"
+	// ProtocolV2Deny downgrades the fetches requesting protocol v2 to v0.
+	ProtocolV2Deny = "deny"
+	// ProtocolV2Require rejects the fetches not requesting protocol v2.
+	ProtocolV2Require = "require"
+
+	// PostQuantumKexPrefer advertises the hybrid post-quantum key exchanges
+	// first, when they are supported.
+	PostQuantumKexPrefer = "prefer"
+	// PostQuantumKexDisabled never advertises them.
+	PostQuantumKexDisabled = "disabled"
 )
 
 type YamlDuration time.Duration
@@ -37,6 +76,7 @@ type ServerConfig struct {
 	ProxyAllowed            []string     `yaml:"proxy_allowed,omitempty"`
 	WebListen               string       `yaml:"web_listen,omitempty"`
 	ConcurrentSessionsLimit int64        `yaml:"concurrent_sessions_limit,omitempty"`
+	ConcurrentSessionsWait  YamlDuration `yaml:"concurrent_sessions_wait,omitempty"`
 	ClientAliveInterval     YamlDuration `yaml:"client_alive_interval,omitempty"`
 	GracePeriod             YamlDuration `yaml:"grace_period"`
 	ProxyHeaderTimeout      YamlDuration `yaml:"proxy_header_timeout"`
@@ -49,6 +89,558 @@ type ServerConfig struct {
 	KexAlgorithms           []string     `yaml:"kex_algorithms"`
 	Ciphers                 []string     `yaml:"ciphers"`
 	GSSAPI                  GSSAPIConfig `yaml:"gssapi,omitempty"`
+	// LoginBannerFile holds a notice sent to clients before they authenticate.
+	LoginBannerFile string `yaml:"login_banner_file,omitempty"`
+	// MaxAuthTries is the number of failed authentication attempts after
+	// which a connection is closed. Zero uses the default of 6, a negative
+	// value removes the limit.
+	MaxAuthTries int `yaml:"max_auth_tries,omitempty"`
+	// KeyFilter rejects keys unknown to GitLab without querying the internal API.
+	KeyFilter KeyFilterConfig `yaml:"key_filter,omitempty"`
+	// PortForwarding allows administrators to reach internal targets through
+	// direct-tcpip channels, which are rejected otherwise.
+	PortForwarding PortForwardingConfig `yaml:"port_forwarding,omitempty"`
+	// InteractiveMenu presents a menu of the commands useful without Git to
+	// the users opening a session without a command on a terminal, rather
+	// than welcoming and disconnecting them.
+	InteractiveMenu bool `yaml:"interactive_menu,omitempty"`
+	// WebTLS serves WebListen over TLS.
+	WebTLS WebTLSConfig `yaml:"web_tls,omitempty"`
+	// WebAuth requires credentials for the endpoints served on WebListen.
+	WebAuth WebAuthConfig `yaml:"web_auth,omitempty"`
+	// ControlSocket serves the admin RPCs on a UNIX socket, apart from the
+	// monitoring endpoints of WebListen.
+	ControlSocket ControlSocketConfig `yaml:"control_socket,omitempty"`
+	// Profiling serves pprof and exports detailed Go runtime metrics on
+	// WebListen, and protects them and the admin endpoints.
+	Profiling ProfilingConfig `yaml:"profiling,omitempty"`
+	// Watchdog monitors the resources used by the server.
+	Watchdog WatchdogConfig `yaml:"watchdog,omitempty"`
+	// ReadinessChecks makes the readiness probe fail while dependencies
+	// can't be reached.
+	ReadinessChecks ReadinessChecksConfig `yaml:"readiness_checks,omitempty"`
+	// DetailedProbes makes the probes respond with the version, uptime and
+	// status of the server as JSON, instead of an empty body.
+	DetailedProbes bool `yaml:"detailed_probes,omitempty"`
+	// SessionClasses limit the sessions of each class, keyed by the
+	// session_class the internal API returns for the key used to
+	// authenticate, e.g. ci. The default class applies to the other sessions.
+	SessionClasses map[string]SessionClassConfig `yaml:"session_classes,omitempty"`
+	// SlowClients terminates the sessions of clients transferring data too
+	// slowly, freeing their slot.
+	SlowClients SlowClientsConfig `yaml:"slow_clients,omitempty"`
+	// PostQuantumKex controls the advertisement of the hybrid post-quantum
+	// key exchanges, e.g. sntrup761x25519-sha512@openssh.com, either
+	// "prefer" (the default) or "disabled".
+	PostQuantumKex string `yaml:"post_quantum_kex,omitempty"`
+	// MinRSAKeySize rejects the RSA keys of users with a shorter modulus, in
+	// bits. Zero accepts every size.
+	MinRSAKeySize int `yaml:"min_rsa_key_size,omitempty"`
+	// RejectSHA1Signatures rejects ssh-rsa signatures, which use SHA-1, while
+	// accepting the rsa-sha2-256 and rsa-sha2-512 signatures of RSA keys.
+	RejectSHA1Signatures bool `yaml:"reject_sha1_signatures,omitempty"`
+	// MaxStartups limits the connections that haven't authenticated yet,
+	// like the MaxStartups of OpenSSH: "start:rate:full" drops new connections
+	// with a probability of rate percent once start connections are in their
+	// handshake, rising linearly up to dropping all of them at full. A single
+	// number is a hard limit. Unset means no limit.
+	MaxStartups string `yaml:"max_startups,omitempty"`
+	// Socket tunes the listening socket and the connections it accepts.
+	Socket SocketConfig `yaml:"socket,omitempty"`
+	// Workers runs this many gitlab-sshd worker processes sharing the
+	// listening socket, under a supervisor process that restarts them when
+	// they exit. Zero serves all the connections from a single process.
+	Workers int `yaml:"workers,omitempty"`
+	// Statsd sends the metrics to a DogStatsD agent as well, for the
+	// deployments that don't scrape them from web_listen
+	Statsd StatsdConfig `yaml:"statsd,omitempty"`
+	// LabeledMetrics exposes metrics labeled by project and by user, whose
+	// cardinality is bounded by exposing the top ones only
+	LabeledMetrics LabeledMetricsConfig `yaml:"labeled_metrics,omitempty"`
+	// AllowedEnv are the variables accepted from the env requests of the
+	// clients on top of GIT_PROTOCOL, GITLAB_PREAUTH_TOKEN and the locale
+	// ones, and passed to the session hooks
+	AllowedEnv []string `yaml:"allowed_env,omitempty"`
+	// MaxEnvSize is how many bytes of names and values the env requests of a
+	// session may send in total, the requests above it being rejected. Zero
+	// uses 8 KiB.
+	MaxEnvSize int `yaml:"max_env_size,omitempty"`
+	// KeyExpiryWarningPeriod is how long before their key expires users are
+	// warned about it. Zero uses 7 days.
+	KeyExpiryWarningPeriod YamlDuration `yaml:"key_expiry_warning_period,omitempty"`
+}
+
+// envNameRegexp matches the names of the variables of sshd allowed_env
+var envNameRegexp = regexp.MustCompile(`\A[A-Za-z_][A-Za-z0-9_]*\z`)
+
+// LabeledMetricsConfig sets how many projects and users the labeled metrics
+// expose, the other ones being rolled up. Zero disables a metric.
+type LabeledMetricsConfig struct {
+	// TopProjects is the number of projects fetched the most whose fetched
+	// bytes are exposed
+	TopProjects int `yaml:"top_projects,omitempty"`
+	// TopUsers is the number of users with the most sessions whose sessions
+	// are counted
+	TopUsers int `yaml:"top_users,omitempty"`
+}
+
+// StatsdConfig configures the DogStatsD sink of the metrics, off without an
+// address
+type StatsdConfig struct {
+	// Address is the host:port of the agent, reached over UDP
+	Address  string       `yaml:"address,omitempty"`
+	Interval YamlDuration `yaml:"interval,omitempty"`
+	// Tags are added to all the metrics, e.g. env:production
+	Tags []string `yaml:"tags,omitempty"`
+}
+
+// SocketConfig tunes the TCP socket gitlab-sshd listens on
+type SocketConfig struct {
+	// Backlog is the length of the queue of connections the server didn't
+	// accept yet. Zero uses the limit of the system, e.g. net.core.somaxconn.
+	Backlog int `yaml:"backlog,omitempty"`
+	// DisableNoDelay turns Nagle's algorithm back on for the connections,
+	// which coalesces small writes at the cost of latency. TCP_NODELAY is set
+	// by default.
+	DisableNoDelay bool `yaml:"disable_nodelay,omitempty"`
+	// KeepAlivePeriod is the interval of the TCP keepalive probes of the
+	// connections. Zero uses 15 seconds, a negative value disables them.
+	KeepAlivePeriod YamlDuration `yaml:"keepalive_period,omitempty"`
+	// ReusePort sets SO_REUSEPORT, for several gitlab-sshd processes to listen
+	// on the same address, the kernel spreading the connections among them.
+	ReusePort bool `yaml:"reuseport,omitempty"`
+}
+
+type ReadinessChecksConfig struct {
+	// API checks that the internal API is reachable.
+	API bool `yaml:"api,omitempty"`
+	// GitalyAddresses lists the Gitaly servers whose health is checked, as
+	// their addresses are otherwise only known once the API is called.
+	GitalyAddresses []string `yaml:"gitaly_addresses,omitempty"`
+	GitalyToken     string   `yaml:"gitaly_token,omitempty"`
+	// Interval is how often dependencies are checked, the probe reports the
+	// result of the last check.
+	Interval YamlDuration `yaml:"interval,omitempty"`
+	Timeout  YamlDuration `yaml:"timeout,omitempty"`
+}
+
+type WatchdogConfig struct {
+	Enabled  bool         `yaml:"enabled,omitempty"`
+	Interval YamlDuration `yaml:"interval,omitempty"`
+	// Thresholds, zero means no limit. MaxRSS is in megabytes.
+	MaxGoroutines int   `yaml:"max_goroutines,omitempty"`
+	MaxOpenFiles  int   `yaml:"max_open_files,omitempty"`
+	MaxRSS        int64 `yaml:"max_rss,omitempty"`
+	// Drain gracefully shuts the server down when thresholds stay exceeded,
+	// for its supervisor to restart it.
+	Drain bool `yaml:"drain,omitempty"`
+}
+
+type WebTLSConfig struct {
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+	// ClientCAFile requires clients to present a certificate signed by one
+	// of its CAs when set.
+	ClientCAFile string `yaml:"client_ca_file,omitempty"`
+}
+
+// WebAuthConfig requires either basic auth or a bearer token, whichever is
+// sent, for all the endpoints of WebListen.
+type WebAuthConfig struct {
+	Username    string `yaml:"username,omitempty"`
+	Password    string `yaml:"password,omitempty"`
+	BearerToken string `yaml:"bearer_token,omitempty"`
+	// ExemptProbes serves the readiness and liveness probes without
+	// credentials, for orchestrators that can't send them.
+	ExemptProbes bool `yaml:"exempt_probes,omitempty"`
+}
+
+// ControlSocketConfig configures the UNIX socket the admin RPCs are served
+// on, off without a path. The RPCs require the token as a bearer token.
+type ControlSocketConfig struct {
+	Path  string `yaml:"path,omitempty"`
+	Token string `yaml:"token,omitempty"`
+}
+
+type ProfilingConfig struct {
+	// Enabled defaults to true, pprof having always been served on WebListen
+	Enabled bool `yaml:"enabled"`
+	// Username and Password protect the pprof endpoints with basic auth
+	// when set. The admin endpoints are forbidden without them.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// SessionClassConfig sets the resources shared by all the sessions of a
+// class, so that e.g. CI sessions don't starve the users. Zero means no limit.
+type SessionClassConfig struct {
+	ConcurrentSessionsLimit int64            `yaml:"concurrent_sessions_limit,omitempty"`
+	Bandwidth               bandwidth.Limits `yaml:"bandwidth,omitempty"`
+}
+
+// SlowClientsConfig sets the minimum throughput of clients, in bytes per
+// second, that sessions are terminated below for Period. Disabled when zero.
+type SlowClientsConfig struct {
+	MinThroughput int64        `yaml:"min_throughput,omitempty"`
+	Period        YamlDuration `yaml:"period,omitempty"`
+}
+
+type PortForwardingConfig struct {
+	// AllowedTargets lists the host:port addresses channels can be opened to.
+	AllowedTargets []string `yaml:"allowed_targets,omitempty"`
+	// AllowedKeyIDs lists the IDs of the keys allowed to forward ports.
+	AllowedKeyIDs []string `yaml:"allowed_key_ids,omitempty"`
+}
+
+type KeyFilterConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// RefreshInterval is how often the fingerprints of valid keys are
+	// fetched. Keys added in the meantime are rejected until the next refresh.
+	RefreshInterval YamlDuration `yaml:"refresh_interval,omitempty"`
+	// FalsePositiveRate is the share of unknown keys still looked up through
+	// the internal API. Lower rates use more memory.
+	FalsePositiveRate float64 `yaml:"false_positive_rate,omitempty"`
+}
+
+// LogRotationConfig rolls the log file of gitlab-sshd over, when it runs
+// without workers. The other processes sharing the file don't rotate it.
+type LogRotationConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// MaxSize is the size in megabytes at which the log file is rolled over.
+	MaxSize    int64        `yaml:"max_size,omitempty"`
+	MaxAge     YamlDuration `yaml:"max_age,omitempty"`
+	MaxBackups int          `yaml:"max_backups,omitempty"`
+	Compress   bool         `yaml:"compress,omitempty"`
+}
+
+type GitalyConfig struct {
+	// ConnectionIdleTimeout is the time after which unused pooled Gitaly
+	// connections are closed. Zero keeps them open for the process lifetime.
+	ConnectionIdleTimeout YamlDuration `yaml:"connection_idle_timeout,omitempty"`
+	// UploadPackTransport selects how git-upload-pack data is transferred,
+	// either "sidechannel" (the default) or "streaming".
+	UploadPackTransport string `yaml:"upload_pack_transport,omitempty"`
+	// ProxyURL is an HTTP or SOCKS5 proxy connections to Gitaly are made
+	// through, instead of the proxy of the environment
+	ProxyURL string `yaml:"proxy_url,omitempty"`
+}
+
+type BandwidthConfig struct {
+	Global        bandwidth.Limits `yaml:"global,omitempty"`
+	PerConnection bandwidth.Limits `yaml:"per_connection,omitempty"`
+	PerUser       bandwidth.Limits `yaml:"per_user,omitempty"`
+}
+
+type TwoFactorConfig struct {
+	// VerifyTimeout bounds how long 2fa_verify waits for either an OTP or a
+	// push authentication approval.
+	VerifyTimeout YamlDuration `yaml:"verify_timeout,omitempty"`
+}
+
+type CustomActionConfig struct {
+	// ChunkSize is the size in bytes of the chunks streamed to and from the
+	// internal API.
+	ChunkSize int64 `yaml:"chunk_size,omitempty"`
+	// MaxInFlight bounds the bytes read from the client ahead of what has been
+	// sent to the internal API.
+	MaxInFlight int64 `yaml:"max_in_flight,omitempty"`
+}
+
+type FeatureFlagsConfig struct {
+	// Enabled fetches the state of feature flags from the internal API.
+	// Otherwise only Defaults apply.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// CacheTTL is how long fetched flags are reused for an actor.
+	CacheTTL YamlDuration `yaml:"cache_ttl,omitempty"`
+	// Defaults holds the state of flags unknown to the internal API or when
+	// it can't be reached.
+	Defaults map[string]bool `yaml:"defaults,omitempty"`
+}
+
+type DebugConfig struct {
+	// CaptureAPI records sanitized internal API requests and responses to
+	// CaptureLogFile. gitlab-sshd can also toggle it at runtime.
+	CaptureAPI     bool   `yaml:"capture_api,omitempty"`
+	CaptureLogFile string `yaml:"capture_log_file,omitempty"`
+}
+
+// FaultInjectionConfig injects latency and errors into the calls to the
+// internal API and Gitaly, to test the resilience of gitlab-shell. It must
+// never be enabled in production.
+type FaultInjectionConfig struct {
+	Enabled bool              `yaml:"enabled,omitempty"`
+	Rules   []FaultRuleConfig `yaml:"rules,omitempty"`
+}
+
+// FaultRuleConfig injects a fault into a percentage of the calls to a target,
+// gitlabnet or gitaly, whose path or gRPC method starts with Match
+type FaultRuleConfig struct {
+	Target     string       `yaml:"target"`
+	Match      string       `yaml:"match,omitempty"`
+	Percentage float64      `yaml:"percentage"`
+	Latency    YamlDuration `yaml:"latency,omitempty"`
+	Error      string       `yaml:"error,omitempty"`
+}
+
+// CommandsConfig bounds how long commands run, so that a runaway hook can't
+// hold a session open indefinitely. Zero means no limit. It also restricts the
+// commands users may run on this instance.
+type CommandsConfig struct {
+	ReceivePackTimeout YamlDuration `yaml:"receive_pack_timeout,omitempty"`
+	UploadPackTimeout  YamlDuration `yaml:"upload_pack_timeout,omitempty"`
+	// APICommandTimeout applies to the commands only calling the internal
+	// API, such as discover or personal_access_token.
+	APICommandTimeout YamlDuration `yaml:"api_command_timeout,omitempty"`
+
+	// Allowed lists the only commands that can be run, all when empty.
+	Allowed  []string `yaml:"allowed,omitempty"`
+	Disabled []string `yaml:"disabled,omitempty"`
+	// DisabledForActors lists more commands disabled for the keys of some
+	// types of actors, keyed by commandargs.ActorType, e.g. the commands
+	// other than Git ones for deploy keys.
+	DisabledForActors map[string][]string `yaml:"disabled_for_actors,omitempty"`
+	// DisabledMessage is shown to users running a command that isn't allowed.
+	DisabledMessage string `yaml:"disabled_message,omitempty"`
+
+	// ProgressInterval is how long a push may not transfer anything before
+	// the user is told that it's still being processed. It's a generic
+	// notice, not the progress of the hooks. Disabled when zero.
+	ProgressInterval YamlDuration `yaml:"progress_interval,omitempty"`
+
+	// ProtocolV2 is whether fetches may, must or must not use Git protocol
+	// v2: allow (the default), require or deny.
+	ProtocolV2 string `yaml:"protocol_v2,omitempty"`
+
+	// BundleURI advertises bundles of the repositories to the fetches using
+	// Git protocol v2, for clones to download most of their objects from
+	// object storage rather than from Gitaly.
+	BundleURI BundleURIConfig `yaml:"bundle_uri,omitempty"`
+}
+
+const (
+	BundleURIModeAll = "all"
+	BundleURIModeAny = "any"
+)
+
+// BundleURIConfig lists the bundles advertised to the clients, the ones
+// returned by the internal API for a project taking precedence.
+type BundleURIConfig struct {
+	// URIs are the URIs of the bundles, {project} being replaced by the path
+	// of the project, e.g. https://bundles.example.com/{project}.bundle.
+	URIs []string `yaml:"uris,omitempty"`
+	// Mode is whether the clients need all the bundles, or any of them: all
+	// (the default) or any.
+	Mode string `yaml:"mode,omitempty"`
+}
+
+// IsDisabled returns whether the policy of this instance prevents running the
+// given command
+func (c CommandsConfig) IsDisabled(command string) bool {
+	for _, disabled := range c.Disabled {
+		if disabled == command {
+			return true
+		}
+	}
+
+	if len(c.Allowed) == 0 {
+		return false
+	}
+
+	for _, allowed := range c.Allowed {
+		if allowed == command {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsDisabledFor returns whether the policy of this instance prevents the
+// actors of the given type from running the given command
+func (c CommandsConfig) IsDisabledFor(command, actorType string) bool {
+	if c.IsDisabled(command) {
+		return true
+	}
+
+	for _, disabled := range c.DisabledForActors[actorType] {
+		if disabled == command {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isActorType(actorType string) bool {
+	for _, known := range commandargs.ActorTypes {
+		if string(known) == actorType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SessionRecordingConfig sets where a record of every git command executed is
+// delivered, for compliance purposes. There is no Kafka sink, the spool
+// directory is shipped by a log collector instead.
+type SessionRecordingConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// SpoolDir receives a JSON file per record.
+	SpoolDir string `yaml:"spool_dir,omitempty"`
+	// WebhookURL receives a POST request per record, authenticated with
+	// WebhookToken as a bearer token when set.
+	WebhookURL     string       `yaml:"webhook_url,omitempty"`
+	WebhookToken   string       `yaml:"webhook_token,omitempty"`
+	WebhookTimeout YamlDuration `yaml:"webhook_timeout,omitempty"`
+}
+
+// SessionHooksConfig sets programs run around every command executed, to
+// enforce local policies such as maintenance freeze windows. They receive a
+// JSON description of the session on stdin.
+type SessionHooksConfig struct {
+	// PreSession is run before the command is executed, which is denied
+	// when the program exits with a non-zero status or doesn't complete in
+	// time. What it writes to stdout is shown to the user.
+	PreSession string `yaml:"pre_session,omitempty"`
+	// PostSession is run once the command completed
+	PostSession string       `yaml:"post_session,omitempty"`
+	Timeout     YamlDuration `yaml:"timeout,omitempty"`
+}
+
+// SessionCgroupsConfig places the helper processes of every gitlab-sshd
+// session, such as the session hooks, in a cgroup v2 of their own on Linux,
+// with limits on their CPU and memory. Their usage is recorded with the
+// session.
+type SessionCgroupsConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Path is the cgroup the session cgroups are created in. It must be
+	// delegated to the user gitlab-sshd runs as, without gitlab-sshd itself
+	// in it, e.g. a systemd slice with Delegate=yes.
+	Path string `yaml:"path,omitempty"`
+	// CPUPercent limits the CPU of a session, 100 being a whole CPU. Zero
+	// means no limit.
+	CPUPercent int `yaml:"cpu_percent,omitempty"`
+	// MemoryLimit is the memory in megabytes a session can use before its
+	// processes are killed. Zero means no limit.
+	MemoryLimit int64 `yaml:"memory_limit,omitempty"`
+}
+
+// MaintenanceModeConfig rejects pushes and LFS uploads with a message while
+// still allowing fetches. gitlab-sshd can also be put in maintenance mode at runtime.
+type MaintenanceModeConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	Message string `yaml:"message,omitempty"`
+	// AllowedUsers and AllowedKeyIDs list the usernames and the IDs of the
+	// keys that can still push.
+	AllowedUsers  []string `yaml:"allowed_users,omitempty"`
+	AllowedKeyIDs []string `yaml:"allowed_key_ids,omitempty"`
+}
+
+// InternalAPIConfig selects the client of the internal API, the legacy one by
+// default.
+type InternalAPIConfig struct {
+	Client string `yaml:"client,omitempty"`
+}
+
+// AccessCacheConfig sets how long the internal API allowing a fetch is
+// trusted for, to spare it repeated fetches of the same repository.
+type AccessCacheConfig struct {
+	TTL        YamlDuration `yaml:"ttl,omitempty"`
+	MaxEntries int          `yaml:"max_entries,omitempty"`
+}
+
+// PushOptionsConfig limits the options sent with `git push -o`. Zero means
+// no limit.
+type PushOptionsConfig struct {
+	MaxCount int `yaml:"max_count,omitempty"`
+	// MaxSize is the size of each option, in bytes
+	MaxSize int `yaml:"max_size,omitempty"`
+}
+
+// PartialCloneConfig restricts the object filters of partial clones.
+type PartialCloneConfig struct {
+	// DeniedFilters are filter specs, e.g. tree:0, or kinds of filters, e.g.
+	// blob:limit or tree, that fetches are rejected for.
+	DeniedFilters []string `yaml:"denied_filters,omitempty"`
+}
+
+// PreauthorizationConfig allows fetches presenting a token signed by GitLab,
+// e.g. for CI runners, without checking the access through the internal API.
+type PreauthorizationConfig struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// MaxTTL bounds the lifetime of the tokens accepted. Defaults to 5m.
+	MaxTTL YamlDuration `yaml:"max_ttl,omitempty"`
+	// PublicKeyFile is the PEM encoded Ed25519 public key of the key
+	// dedicated to signing the tokens, which gitlab-shell can't issue.
+	PublicKeyFile string `yaml:"public_key_file,omitempty"`
+	// GitalyTokenFile holds the token authenticating the Gitaly calls, which
+	// the tokens don't carry.
+	GitalyTokenFile string `yaml:"gitaly_token_file,omitempty"`
+}
+
+func (c PreauthorizationConfig) isSane() error {
+	if c.Enabled && (c.PublicKeyFile == "" || c.GitalyTokenFile == "") {
+		return errors.New("preauthorization requires public_key_file and gitaly_token_file")
+	}
+
+	return nil
+}
+
+// EventsConfig sets the webhook receiving the lifecycle events published by
+// gitlab-sshd.
+type EventsConfig struct {
+	WebhookURL string `yaml:"webhook_url,omitempty"`
+	// Secret signs the events with HMAC-SHA256 when set.
+	Secret     string       `yaml:"secret,omitempty"`
+	Timeout    YamlDuration `yaml:"timeout,omitempty"`
+	MaxRetries int          `yaml:"max_retries,omitempty"`
+
+	NATS  EventsNATSConfig  `yaml:"nats,omitempty"`
+	Kafka EventsKafkaConfig `yaml:"kafka,omitempty"`
+}
+
+// EventsNATSConfig publishes the lifecycle events to a NATS subject, which
+// avoids the overhead of an HTTP request per event.
+type EventsNATSConfig struct {
+	Servers []string `yaml:"servers,omitempty"`
+	// Subject is suffixed with the event type, e.g. gitlab_shell.events.auth_failed.
+	Subject  string          `yaml:"subject,omitempty"`
+	Username string          `yaml:"username,omitempty"`
+	Password string          `yaml:"password,omitempty"`
+	Token    string          `yaml:"token,omitempty"`
+	TLS      EventsTLSConfig `yaml:"tls,omitempty"`
+}
+
+// EventsKafkaConfig produces the lifecycle events to a Kafka topic, keyed by
+// the hostname so that the events of a server stay ordered.
+type EventsKafkaConfig struct {
+	Brokers []string `yaml:"brokers,omitempty"`
+	// Topic defaults to gitlab_shell.events.
+	Topic string           `yaml:"topic,omitempty"`
+	TLS   EventsTLSConfig  `yaml:"tls,omitempty"`
+	SASL  EventsSASLConfig `yaml:"sasl,omitempty"`
+}
+
+const (
+	SASLMechanismPlain       = "plain"
+	SASLMechanismSCRAMSHA256 = "scram-sha-256"
+	SASLMechanismSCRAMSHA512 = "scram-sha-512"
+)
+
+type EventsSASLConfig struct {
+	// Mechanism is one of plain, scram-sha-256 and scram-sha-512. SASL is
+	// disabled when empty.
+	Mechanism string `yaml:"mechanism,omitempty"`
+	Username  string `yaml:"username,omitempty"`
+	Password  string `yaml:"password,omitempty"`
+}
+
+type EventsTLSConfig struct {
+	Enabled  bool   `yaml:"enabled,omitempty"`
+	CAFile   string `yaml:"ca_file,omitempty"`
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
 }
 
 type HttpSettingsConfig struct {
@@ -57,29 +649,191 @@ type HttpSettingsConfig struct {
 	ReadTimeoutSeconds uint64 `yaml:"read_timeout"`
 	CaFile             string `yaml:"ca_file"`
 	CaPath             string `yaml:"ca_path"`
+	// ClockSkewTolerance widens the validity of the signatures of the
+	// requests, for hosts whose clock drifts from the one of GitLab
+	ClockSkewTolerance YamlDuration `yaml:"clock_skew_tolerance,omitempty"`
+	// ProxyURL is an HTTP or SOCKS5 proxy the requests are sent through,
+	// instead of the proxy of the environment
+	ProxyURL string `yaml:"proxy_url,omitempty"`
+
+	DNS DNSConfig `yaml:"dns"`
+	// Hedging sends the idempotent requests of latency sensitive endpoints
+	// again when they haven't been answered after a delay
+	Hedging HedgingConfig `yaml:"hedging,omitempty"`
+}
+
+// HedgingConfig configures the hedging of the requests to the internal API,
+// off without a delay. Paths default to the endpoints authenticating users.
+type HedgingConfig struct {
+	Delay YamlDuration `yaml:"delay,omitempty"`
+	Paths []string     `yaml:"paths,omitempty"`
+}
+
+var defaultHedgingPaths = []string{"/authorized_keys", "/discover"}
+
+// APITimeoutsConfig bounds the calls to each endpoint of the internal API, so
+// that a slow endpoint doesn't use up the time left to the session. The calls
+// are still bounded by the http_settings read_timeout, which is all that
+// bounds the calls without a timeout.
+type APITimeoutsConfig struct {
+	Discover            YamlDuration `yaml:"discover,omitempty"`
+	Allowed             YamlDuration `yaml:"allowed,omitempty"`
+	AuthorizedKeys      YamlDuration `yaml:"authorized_keys,omitempty"`
+	TwoFactor           YamlDuration `yaml:"two_factor,omitempty"`
+	PersonalAccessToken YamlDuration `yaml:"personal_access_token,omitempty"`
+	LfsAuthenticate     YamlDuration `yaml:"lfs_authenticate,omitempty"`
+	Projects            YamlDuration `yaml:"projects,omitempty"`
+	AuditEvents         YamlDuration `yaml:"audit_events,omitempty"`
+	FeatureFlags        YamlDuration `yaml:"feature_flags,omitempty"`
+}
+
+// AuthorizedKeysConfig configures the lines printed for OpenSSH by
+// gitlab-shell-authorized-keys-check and gitlab-shell-authorized-principals-check
+type AuthorizedKeysConfig struct {
+	// Template is a text/template of the lines, for layouts where OpenSSH
+	// can't run gitlab-shell directly, e.g. when it runs in a container. It
+	// is executed with .Executable, .Argument, .Command (the executable
+	// followed by its argument), .Options and .Value (the key or principal).
+	Template string `yaml:"template,omitempty"`
+}
+
+// DNSConfig configures how the host of gitlab_url is resolved and connected to
+type DNSConfig struct {
+	// CacheTTL is how long the addresses are cached for
+	CacheTTL YamlDuration `yaml:"cache_ttl"`
+	// SRV looks up the host as the name of an SRV record
+	SRV bool `yaml:"srv"`
+	// IPPreference is the address family connected to first, ipv4 or ipv6
+	IPPreference string `yaml:"ip_preference"`
+	// FallbackDelay is how long the preferred address family is waited on
+	// before the other one is tried as well
+	FallbackDelay YamlDuration `yaml:"fallback_delay"`
+}
+
+// GitlabFailoverConfig configures the health checks of gitlab_urls. The zero
+// values are the defaults of client.FailoverOpts.
+type GitlabFailoverConfig struct {
+	HealthCheckPath     string       `yaml:"health_check_path,omitempty"`
+	HealthCheckInterval YamlDuration `yaml:"health_check_interval,omitempty"`
+	HealthCheckTimeout  YamlDuration `yaml:"health_check_timeout,omitempty"`
+	// FailureThreshold is the number of consecutive failed health checks or
+	// requests marking a URL unhealthy
+	FailureThreshold int `yaml:"failure_threshold,omitempty"`
+	// RecoveryThreshold is the number of consecutive passed health checks
+	// marking it healthy again
+	RecoveryThreshold int `yaml:"recovery_threshold,omitempty"`
+}
+
+func (d DNSConfig) enabled() bool {
+	return d.CacheTTL > 0 || d.SRV || d.IPPreference != "" || d.FallbackDelay != 0
 }
 
 type Config struct {
 	User                  string `yaml:"user,omitempty"`
 	RootDir               string
-	LogFile               string `yaml:"log_file,omitempty"`
-	LogFormat             string `yaml:"log_format,omitempty"`
-	LogLevel              string `yaml:"log_level,omitempty"`
-	GitlabUrl             string `yaml:"gitlab_url"`
-	GitlabRelativeURLRoot string `yaml:"gitlab_relative_url_root"`
-	GitlabTracing         string `yaml:"gitlab_tracing"`
+	LogFile               string            `yaml:"log_file,omitempty"`
+	LogFormat             string            `yaml:"log_format,omitempty"`
+	LogLevel              string            `yaml:"log_level,omitempty"`
+	LogRotation           LogRotationConfig `yaml:"log_rotation,omitempty"`
+	GitlabUrl             string            `yaml:"gitlab_url"`
+	GitlabRelativeURLRoot string            `yaml:"gitlab_relative_url_root"`
+	// GitlabUrls lists http(s) URLs of the internal API in order of
+	// preference, the requests failing over between them. gitlab_url
+	// defaults to the first one, and is preferred over them when set.
+	GitlabUrls     []string             `yaml:"gitlab_urls,omitempty"`
+	GitlabFailover GitlabFailoverConfig `yaml:"gitlab_failover,omitempty"`
+	GitlabTracing  string               `yaml:"gitlab_tracing"`
 	// SecretFilePath is only for parsing. Application code should always use Secret.
-	SecretFilePath string             `yaml:"secret_file"`
-	Secret         string             `yaml:"secret"`
-	SslCertDir     string             `yaml:"ssl_cert_dir"`
-	HttpSettings   HttpSettingsConfig `yaml:"http_settings"`
-	Server         ServerConfig       `yaml:"sshd"`
+	SecretFilePath   string                 `yaml:"secret_file"`
+	Secret           string                 `yaml:"secret"`
+	SslCertDir       string                 `yaml:"ssl_cert_dir"`
+	HttpSettings     HttpSettingsConfig     `yaml:"http_settings"`
+	Server           ServerConfig           `yaml:"sshd"`
+	Gitaly           GitalyConfig           `yaml:"gitaly"`
+	Bandwidth        BandwidthConfig        `yaml:"bandwidth_limits"`
+	CustomAction     CustomActionConfig     `yaml:"custom_action"`
+	TwoFactor        TwoFactorConfig        `yaml:"two_factor"`
+	FeatureFlags     FeatureFlagsConfig     `yaml:"feature_flags"`
+	Debug            DebugConfig            `yaml:"debug"`
+	Commands         CommandsConfig         `yaml:"commands"`
+	SessionRecording SessionRecordingConfig `yaml:"session_recording"`
+	SessionHooks     SessionHooksConfig     `yaml:"session_hooks"`
+	SessionCgroups   SessionCgroupsConfig   `yaml:"session_cgroups"`
+	Events           EventsConfig           `yaml:"events"`
+	Tenants          []TenantConfig         `yaml:"tenants"`
+	AccessCache      AccessCacheConfig      `yaml:"access_cache"`
+	Preauthorization PreauthorizationConfig `yaml:"preauthorization"`
+	PushOptions      PushOptionsConfig      `yaml:"push_options"`
+	PartialClone     PartialCloneConfig     `yaml:"partial_clone"`
+	MaintenanceMode  MaintenanceModeConfig  `yaml:"maintenance_mode"`
+	InternalAPI      InternalAPIConfig      `yaml:"internal_api"`
+	APITimeouts      APITimeoutsConfig      `yaml:"api_timeouts"`
+	AuthorizedKeys   AuthorizedKeysConfig   `yaml:"authorized_keys"`
+	FaultInjection   FaultInjectionConfig   `yaml:"fault_injection"`
+	// ReadOnly rejects the commands writing to repositories before any API
+	// call, for replicas serving fetches. Users are told to push to the
+	// WritableEndpoint instead, e.g. git@gitlab.example.com.
+	ReadOnly         bool   `yaml:"read_only,omitempty"`
+	WritableEndpoint string `yaml:"writable_endpoint,omitempty"`
+	// FIPSMode restricts the crypto to FIPS approved algorithms and refuses
+	// to start gitlab-sshd with settings or host keys that aren't compliant
+	FIPSMode bool `yaml:"fips_mode,omitempty"`
+	// StrictConfig rejects the config file when it has settings that don't
+	// exist, e.g. misspelled ones, rather than ignoring them
+	StrictConfig bool `yaml:"strict_config,omitempty"`
+	// Locale is the locale of the messages shown to the users who don't
+	// select one with LANG or LC_*, e.g. de. English by default.
+	Locale string `yaml:"locale,omitempty"`
+	// Messages overrides the text of messages shown to users, keyed by the
+	// usermessage.ID, e.g. welcome
+	Messages map[string]string `yaml:"messages,omitempty"`
+	// ColorWarnings colorizes the warnings shown to users on terminals that
+	// do colors
+	ColorWarnings bool `yaml:"color_warnings,omitempty"`
+	// Include lists glob patterns of more config files, relative to the
+	// directory of the config file, whose settings are merged into it. See
+	// readWithIncludes for the order.
+	Include []string `yaml:"include,omitempty"`
 
 	httpClient     *client.HttpClient
 	httpClientErr  error
 	httpClientOnce sync.Once
 
-	GitalyClient gitaly.Client
+	faultInjector *faultinject.Injector
+
+	apiCapture     *apicapture.Recorder
+	apiCaptureOnce sync.Once
+
+	accessCache     *accesscache.Cache
+	accessCacheOnce sync.Once
+
+	maintenance     *maintenance.Mode
+	maintenanceOnce sync.Once
+
+	globalBandwidthLimiter     *bandwidth.Limiter
+	globalBandwidthLimiterOnce sync.Once
+	userBandwidthLimiters      bandwidth.Registry
+
+	// fileValues holds the settings read from the config file, and sources
+	// the settings overridden after it was read, to report where each setting
+	// comes from.
+	fileValues map[string]interface{}
+	sources    map[string]string
+
+	// profile is the config profile the defaults come from, and
+	// profileValues the settings it changes
+	profile       string
+	profileValues map[string]interface{}
+	// file is the path of the config file
+	file string
+
+	// tenants are the configs of the additional GitLab instances, which
+	// share the process-wide state of their parent
+	tenants    []*Config
+	tenantName string
+	parent     *Config
+
+	GitalyClient gitaly.Client `yaml:"-"`
 }
 
 // The defaults to apply before parsing the config file(s).
@@ -89,9 +843,14 @@ var (
 		LogFormat: "json",
 		LogLevel:  "info",
 		Server:    DefaultServerConfig,
+		Gitaly:    DefaultGitalyConfig,
 		User:      "git",
 	}
 
+	DefaultGitalyConfig = GitalyConfig{
+		ConnectionIdleTimeout: YamlDuration(10 * time.Minute),
+	}
+
 	DefaultServerConfig = ServerConfig{
 		Listen:                  "[::]:22",
 		WebListen:               "localhost:9122",
@@ -102,6 +861,7 @@ var (
 		LoginGraceTime:          YamlDuration(60 * time.Second),
 		ReadinessProbe:          "/start",
 		LivenessProbe:           "/health",
+		Profiling:               ProfilingConfig{Enabled: true},
 		HostKeyFiles: []string{
 			"/run/secrets/ssh-hostkeys/ssh_host_rsa_key",
 			"/run/secrets/ssh-hostkeys/ssh_host_ecdsa_key",
@@ -121,6 +881,10 @@ func (d *YamlDuration) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+func (d YamlDuration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
 func (c *Config) ApplyGlobalState() {
 	if c.SslCertDir != "" {
 		os.Setenv("SSL_CERT_DIR", c.SslCertDir)
@@ -129,20 +893,82 @@ func (c *Config) ApplyGlobalState() {
 
 func (c *Config) HttpClient() (*client.HttpClient, error) {
 	c.httpClientOnce.Do(func() {
+		opts := []client.HTTPClientOpt{client.WithClockSkewTolerance(time.Duration(c.HttpSettings.ClockSkewTolerance))}
+		if c.FIPSMode {
+			opts = append(opts, client.WithFIPS())
+		}
+		if c.HttpSettings.ProxyURL != "" {
+			proxyURL, err := client.ParseProxyURL(c.HttpSettings.ProxyURL)
+			if err != nil {
+				c.httpClientErr = err
+				return
+			}
+
+			opts = append(opts, client.WithProxy(proxyURL))
+		}
+		if dns := c.HttpSettings.DNS; dns.enabled() {
+			opts = append(opts, client.WithResolver(client.NewResolver(client.ResolverOpts{
+				TTL:           time.Duration(dns.CacheTTL),
+				SRV:           dns.SRV,
+				IPPreference:  client.IPPreference(dns.IPPreference),
+				FallbackDelay: time.Duration(dns.FallbackDelay),
+			})))
+		}
+
+		if hedging := c.HttpSettings.Hedging; hedging.Delay > 0 {
+			paths := hedging.Paths
+			if len(paths) == 0 {
+				paths = defaultHedgingPaths
+			}
+
+			opts = append(opts, client.WithHedging(client.HedgingOpts{
+				Delay: time.Duration(hedging.Delay),
+				Paths: paths,
+				OnHedge: func(path string, hedgeWon bool) {
+					winner := "original"
+					if hedgeWon {
+						winner = "hedge"
+					}
+					metrics.HttpHedgedRequestsTotal.WithLabelValues(path, winner).Inc()
+				},
+			}))
+		}
+		if len(c.GitlabUrls) > 0 {
+			failover := c.GitlabFailover
+			opts = append(opts, client.WithFailover(c.gitlabURLs(), client.FailoverOpts{
+				HealthCheckPath:     failover.HealthCheckPath,
+				HealthCheckInterval: time.Duration(failover.HealthCheckInterval),
+				HealthCheckTimeout:  time.Duration(failover.HealthCheckTimeout),
+				FailureThreshold:    failover.FailureThreshold,
+				RecoveryThreshold:   failover.RecoveryThreshold,
+				OnFailover: func(from, to string) {
+					metrics.HttpFailoversTotal.WithLabelValues(from, to).Inc()
+				},
+				OnHealthChange: func(url string, healthy bool) {
+					up := 0.0
+					if healthy {
+						up = 1
+					}
+					metrics.HttpGitlabURLHealthy.WithLabelValues(url).Set(up)
+				},
+			}))
+		}
+
 		client, err := client.NewHTTPClientWithOpts(
 			c.GitlabUrl,
 			c.GitlabRelativeURLRoot,
 			c.HttpSettings.CaFile,
 			c.HttpSettings.CaPath,
 			c.HttpSettings.ReadTimeoutSeconds,
-			nil,
+			opts,
 		)
 		if err != nil {
 			c.httpClientErr = err
 			return
 		}
 
-		tr := client.RetryableHTTP.HTTPClient.Transport
+		tr := faultinject.NewRoundTripper(client.RetryableHTTP.HTTPClient.Transport, c.faultInjector)
+		tr = apicapture.NewRoundTripper(tr, c.APICapture())
 		client.RetryableHTTP.HTTPClient.Transport = metrics.NewRoundTripper(tr)
 
 		c.httpClient = client
@@ -151,10 +977,120 @@ func (c *Config) HttpClient() (*client.HttpClient, error) {
 	return c.httpClient, c.httpClientErr
 }
 
+// HTTPTransport returns a transport for the HTTP clients of the services other
+// than the internal API, e.g. the LFS API and the webhooks. Like the internal API client, it
+// trusts the CAs of http_settings, goes through its proxy and only negotiates
+// the FIPS approved ciphers in FIPS mode.
+func (c *Config) HTTPTransport() (*http.Transport, error) {
+	transport := client.DefaultTransport().(*http.Transport)
+	transport.TLSClientConfig = client.NewTLSConfig(c.HttpSettings.CaFile, c.HttpSettings.CaPath, c.FIPSMode)
+
+	if c.HttpSettings.ProxyURL != "" {
+		proxyURL, err := client.ParseProxyURL(c.HttpSettings.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return transport, nil
+}
+
+// gitlabURLs returns the URLs the requests fail over between: gitlab_url
+// followed by the other gitlab_urls
+func (c *Config) gitlabURLs() []string {
+	urls := []string{c.GitlabUrl}
+	for _, u := range c.GitlabUrls {
+		if u != c.GitlabUrl {
+			urls = append(urls, u)
+		}
+	}
+
+	return urls
+}
+
+// APICapture returns the recorder of internal API interactions shared by the
+// whole process.
+func (c *Config) APICapture() *apicapture.Recorder {
+	if c.parent != nil {
+		return c.parent.APICapture()
+	}
+
+	c.apiCaptureOnce.Do(func() {
+		path := c.Debug.CaptureLogFile
+		if path == "" {
+			path = defaultCaptureLogFileName
+		}
+		if !filepath.IsAbs(path) && c.RootDir != "" {
+			path = filepath.Join(c.RootDir, path)
+		}
+
+		c.apiCapture = apicapture.NewRecorder(path, c.Debug.CaptureAPI)
+	})
+
+	return c.apiCapture
+}
+
+// Maintenance returns the maintenance mode shared by the whole process, the
+// users and keys allowed to push being the ones of the tenant
+func (c *Config) Maintenance() *maintenance.Mode {
+	c.maintenanceOnce.Do(func() {
+		m := c.MaintenanceMode
+		if c.parent != nil {
+			// Tenants are in maintenance along with the default instance,
+			// allowing their own users and keys
+			c.maintenance = c.parent.Maintenance().WithAllowed(m.AllowedUsers, m.AllowedKeyIDs)
+			return
+		}
+
+		c.maintenance = maintenance.New(m.Enabled, m.Message, m.AllowedUsers, m.AllowedKeyIDs)
+	})
+
+	return c.maintenance
+}
+
+// AccessCheckCache returns the cache of access checks shared by the whole process.
+// It is nil when caching is disabled.
+func (c *Config) AccessCheckCache() *accesscache.Cache {
+	c.accessCacheOnce.Do(func() {
+		c.accessCache = accesscache.New(time.Duration(c.AccessCache.TTL), c.AccessCache.MaxEntries)
+	})
+
+	return c.accessCache
+}
+
+// GlobalBandwidthLimiter returns the limiter shared by every transfer of the
+// process. It is nil when no global limits are configured.
+func (c *Config) GlobalBandwidthLimiter() *bandwidth.Limiter {
+	if c.parent != nil {
+		return c.parent.GlobalBandwidthLimiter()
+	}
+
+	c.globalBandwidthLimiterOnce.Do(func() {
+		c.globalBandwidthLimiter = bandwidth.NewLimiter(c.Bandwidth.Global)
+	})
+
+	return c.globalBandwidthLimiter
+}
+
+// UserBandwidthLimiter returns the limiter shared by all sessions of user in
+// the process, which only spans several sessions with gitlab-sshd. The limits
+// hinted by the internal API take precedence over the configured ones.
+// The returned function must be called once the transfer is done.
+func (c *Config) UserBandwidthLimiter(user string, hint *bandwidth.Limits) (*bandwidth.Limiter, func()) {
+	limits := c.Bandwidth.PerUser
+	if hint != nil {
+		limits = *hint
+	}
+
+	return c.userBandwidthLimiters.Acquire(user, limits)
+}
+
 // NewFromDirExternal returns a new config from a given root dir. It also applies defaults appropriate for
-// gitlab-shell running in an external SSH server.
+// gitlab-shell running in an external SSH server, and the ones of the profile selected by the environment.
 func NewFromDirExternal(dir string) (*Config, error) {
-	cfg, err := newFromFile(filepath.Join(dir, configFile))
+	cfg, err := newFromFile(findConfigFile(dir), profileFromEnv())
 	if err != nil {
 		return nil, err
 	}
@@ -164,20 +1100,29 @@ func NewFromDirExternal(dir string) (*Config, error) {
 	return cfg, nil
 }
 
-// NewFromDir returns a new config given a root directory. It looks for the config file name in the
-// given directory and reads the config from it. It doesn't apply any defaults. New code should prefer
+// NewFromDir returns a new config given a root directory. It looks for config.yml, config.toml or
+// config.json in the given directory and reads the config from it. It doesn't apply any defaults. New code should prefer
 // this over NewFromDirIntegrated and apply the right default via one of the Apply... functions.
+// The defaults are the ones of the profile selected by the environment.
 func NewFromDir(dir string) (*Config, error) {
-	return newFromFile(filepath.Join(dir, configFile))
+	return newFromFile(findConfigFile(dir), profileFromEnv())
 }
 
-// newFromFile reads a new Config instance from the given file path. It doesn't apply any defaults.
-func newFromFile(path string) (*Config, error) {
-	cfg := &Config{}
-	*cfg = DefaultConfig
+// NewFromDirWithProfile is like NewFromDir with the defaults of profile
+func NewFromDirWithProfile(dir, profile string) (*Config, error) {
+	return newFromFile(findConfigFile(dir), profile)
+}
+
+// newFromFile reads a new Config instance from the given file path on top of the defaults of profile.
+func newFromFile(path, profile string) (*Config, error) {
+	cfg, err := newDefaults(profile)
+	if err != nil {
+		return nil, err
+	}
 	cfg.RootDir = filepath.Dir(path)
+	cfg.file = path
 
-	configBytes, err := os.ReadFile(path)
+	configBytes, fragments, err := readWithIncludes(path)
 	if err != nil {
 		return nil, err
 	}
@@ -186,6 +1131,16 @@ func newFromFile(path string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := yaml.Unmarshal(configBytes, &cfg.fileValues); err != nil {
+		return nil, err
+	}
+
+	if cfg.StrictConfig {
+		if err := checkFragments(fragments); err != nil {
+			return nil, err
+		}
+	}
+
 	if cfg.GitlabUrl != "" {
 		// This is only done for historic reasons, don't implement it for new config sources.
 		unescapedUrl, err := url.PathUnescape(cfg.GitlabUrl)
@@ -195,15 +1150,35 @@ func newFromFile(path string) (*Config, error) {
 
 		cfg.GitlabUrl = unescapedUrl
 	}
+	if cfg.GitlabUrl == "" && len(cfg.GitlabUrls) > 0 {
+		cfg.GitlabUrl = cfg.GitlabUrls[0]
+	}
 
 	if err := parseSecret(cfg); err != nil {
 		return nil, err
 	}
 
+	if err := parseGitalyProxyURL(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := parseFaultInjection(cfg); err != nil {
+		return nil, err
+	}
+
 	if len(cfg.LogFile) > 0 && cfg.LogFile[0] != '/' && cfg.RootDir != "" {
 		cfg.LogFile = filepath.Join(cfg.RootDir, cfg.LogFile)
 	}
 
+	for _, tenant := range cfg.Tenants {
+		tenantCfg, err := newTenantConfig(cfg, configBytes, tenant)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", tenant.Name, err)
+		}
+
+		cfg.tenants = append(cfg.tenants, tenantCfg)
+	}
+
 	return cfg, nil
 }
 
@@ -226,10 +1201,104 @@ func parseSecret(cfg *Config) error {
 		return err
 	}
 	cfg.Secret = string(secretFileContent)
+	cfg.SetSource("secret", SourceSecretFile)
 
 	return nil
 }
 
+// LoadSecretFile replaces the secret with the content of path, e.g. given
+// on the command line
+func (c *Config) LoadSecretFile(path string) error {
+	c.Secret = ""
+	c.SecretFilePath = path
+
+	return parseSecret(c)
+}
+
+func parseGitalyProxyURL(cfg *Config) error {
+	if cfg.Gitaly.ProxyURL == "" {
+		return nil
+	}
+
+	proxyURL, err := client.ParseProxyURL(cfg.Gitaly.ProxyURL)
+	if err != nil {
+		return fmt.Errorf("gitaly proxy_url: %w", err)
+	}
+	cfg.GitalyClient.ProxyURL = proxyURL
+
+	return nil
+}
+
+func parseFaultInjection(cfg *Config) error {
+	if !cfg.FaultInjection.Enabled {
+		return nil
+	}
+
+	rules := make([]faultinject.Rule, 0, len(cfg.FaultInjection.Rules))
+	for _, rule := range cfg.FaultInjection.Rules {
+		rules = append(rules, faultinject.Rule{
+			Target:     rule.Target,
+			Match:      rule.Match,
+			Percentage: rule.Percentage,
+			Latency:    time.Duration(rule.Latency),
+			Error:      rule.Error,
+		})
+	}
+
+	injector, err := faultinject.New(rules)
+	if err != nil {
+		return fmt.Errorf("fault_injection: %w", err)
+	}
+	cfg.faultInjector = injector
+	cfg.GitalyClient.FaultInjector = injector
+
+	return nil
+}
+
+// MaxStartups is the parsed sshd max_startups setting. A zero Start means
+// no limit.
+type MaxStartups struct {
+	Start int
+	Rate  int
+	Full  int
+}
+
+// ParseMaxStartups parses a max_startups setting, either "start:rate:full"
+// or a single number being a hard limit
+func ParseMaxStartups(value string) (MaxStartups, error) {
+	if value == "" {
+		return MaxStartups{}, nil
+	}
+
+	invalid := fmt.Errorf("invalid sshd max_startups %q: expected start:rate:full, e.g. 10:30:100, or a single limit", value)
+
+	fields := strings.Split(value, ":")
+	numbers := make([]int, len(fields))
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return MaxStartups{}, invalid
+		}
+		numbers[i] = n
+	}
+
+	var m MaxStartups
+	switch len(numbers) {
+	case 1:
+		m = MaxStartups{Start: numbers[0], Rate: 100, Full: numbers[0]}
+	case 3:
+		m = MaxStartups{Start: numbers[0], Rate: numbers[1], Full: numbers[2]}
+	default:
+		return MaxStartups{}, invalid
+	}
+
+	if m.Start == 0 || m.Rate > 100 || m.Full < m.Start {
+		return MaxStartups{}, invalid
+	}
+
+	return m, nil
+}
+
 // IsSane checks if the given config fulfills the minimum requirements to be able to run.
 // Any error returned by this function should be a startup error. On the other hand
 // if this function returns nil, this doesn't guarantee the config will work, but it's
@@ -241,5 +1310,147 @@ func (cfg *Config) IsSane() error {
 	if cfg.Secret == "" {
 		return errors.New("secret or secret_file_path is required")
 	}
+	switch cfg.Gitaly.UploadPackTransport {
+	case "", GitalyTransportSidechannel, GitalyTransportStreaming:
+	default:
+		return fmt.Errorf("unknown gitaly upload_pack_transport %q", cfg.Gitaly.UploadPackTransport)
+	}
+	switch cfg.Commands.BundleURI.Mode {
+	case "", BundleURIModeAll, BundleURIModeAny:
+	default:
+		return fmt.Errorf("unknown commands bundle_uri mode %q", cfg.Commands.BundleURI.Mode)
+	}
+	for _, uri := range cfg.Commands.BundleURI.URIs {
+		if parsed, err := url.Parse(uri); err != nil || parsed.Scheme == "" {
+			return fmt.Errorf("invalid commands bundle_uri %q, an absolute URI is required", uri)
+		}
+	}
+	switch cfg.Commands.ProtocolV2 {
+	case "", ProtocolV2Allow, ProtocolV2Deny, ProtocolV2Require:
+	default:
+		return fmt.Errorf("unknown commands protocol_v2 %q", cfg.Commands.ProtocolV2)
+	}
+	for actorType := range cfg.Commands.DisabledForActors {
+		if !isActorType(actorType) {
+			return fmt.Errorf("unknown actor type %q in commands disabled_for_actors", actorType)
+		}
+	}
+	switch cfg.Server.PostQuantumKex {
+	case "", PostQuantumKexPrefer, PostQuantumKexDisabled:
+	default:
+		return fmt.Errorf("unknown sshd post_quantum_kex %q", cfg.Server.PostQuantumKex)
+	}
+	if _, err := ParseMaxStartups(cfg.Server.MaxStartups); err != nil {
+		return err
+	}
+	if cfg.Server.Workers < 0 {
+		return errors.New("sshd workers can't be negative")
+	}
+	switch cfg.InternalAPI.Client {
+	case "", InternalAPIClientLegacy, InternalAPIClientV2:
+	default:
+		return fmt.Errorf("unknown internal_api client %q", cfg.InternalAPI.Client)
+	}
+	if cfg.SessionRecording.Enabled && cfg.SessionRecording.SpoolDir == "" && cfg.SessionRecording.WebhookURL == "" {
+		return errors.New("session_recording requires a spool_dir or a webhook_url")
+	}
+	if cfg.SessionCgroups.Enabled && !filepath.IsAbs(cfg.SessionCgroups.Path) {
+		return errors.New("session_cgroups requires an absolute path")
+	}
+	if cfg.SessionCgroups.CPUPercent < 0 || cfg.SessionCgroups.MemoryLimit < 0 {
+		return errors.New("session_cgroups limits can't be negative")
+	}
+	for _, hook := range []string{cfg.SessionHooks.PreSession, cfg.SessionHooks.PostSession} {
+		if hook != "" && !filepath.IsAbs(hook) {
+			return fmt.Errorf("session_hooks program %q must be an absolute path", hook)
+		}
+	}
+	if cfg.HttpSettings.ProxyURL != "" {
+		if _, err := client.ParseProxyURL(cfg.HttpSettings.ProxyURL); err != nil {
+			return fmt.Errorf("http_settings proxy_url: %w", err)
+		}
+	}
+	switch client.IPPreference(cfg.HttpSettings.DNS.IPPreference) {
+	case client.IPPreferenceNone, client.IPPreferenceIPv4, client.IPPreferenceIPv6:
+	default:
+		return fmt.Errorf("unknown http_settings dns ip_preference %q", cfg.HttpSettings.DNS.IPPreference)
+	}
+	if labeled := cfg.Server.LabeledMetrics; labeled.TopProjects < 0 || labeled.TopUsers < 0 {
+		return errors.New("sshd labeled_metrics top_projects and top_users can't be negative")
+	}
+	for _, name := range cfg.Server.AllowedEnv {
+		if !envNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid variable name %q in sshd allowed_env", name)
+		}
+	}
+	if cfg.Server.MaxEnvSize < 0 {
+		return errors.New("sshd max_env_size can't be negative")
+	}
+	if cfg.Server.KeyExpiryWarningPeriod < 0 {
+		return errors.New("sshd key_expiry_warning_period can't be negative")
+	}
+	if cfg.Locale != "" {
+		if _, ok := i18n.Supported(cfg.Locale); !ok {
+			return fmt.Errorf("unsupported locale %q, supported: %s", cfg.Locale, strings.Join(i18n.Locales(), ", "))
+		}
+	}
+	if err := usermessage.Validate(cfg.Messages); err != nil {
+		return fmt.Errorf("messages: %w", err)
+	}
+	if webTLS := cfg.Server.WebTLS; (webTLS.CertFile == "") != (webTLS.KeyFile == "") {
+		return errors.New("sshd web_tls requires both cert_file and key_file")
+	}
+	if webTLS := cfg.Server.WebTLS; webTLS.ClientCAFile != "" && webTLS.CertFile == "" {
+		return errors.New("sshd web_tls client_ca_file requires cert_file and key_file")
+	}
+	if webAuth := cfg.Server.WebAuth; (webAuth.Username == "") != (webAuth.Password == "") {
+		return errors.New("sshd web_auth requires both username and password")
+	}
+	if control := cfg.Server.ControlSocket; control.Path != "" && control.Token == "" {
+		return errors.New("sshd control_socket requires a token")
+	}
+	if address := cfg.Server.Statsd.Address; address != "" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("sshd statsd address: %w", err)
+		}
+	}
+	if cfg.HttpSettings.Hedging.Delay < 0 {
+		return errors.New("http_settings hedging delay can't be negative")
+	}
+	for _, path := range cfg.HttpSettings.Hedging.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("http_settings hedging path %q must start with /", path)
+		}
+	}
+	if len(cfg.GitlabUrls) > 0 {
+		for _, u := range cfg.gitlabURLs() {
+			if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+				return fmt.Errorf("gitlab_urls only supports http:// and https:// URLs, got %q", u)
+			}
+		}
+	}
+	if f := cfg.GitlabFailover; f.FailureThreshold < 0 || f.RecoveryThreshold < 0 {
+		return errors.New("gitlab_failover failure_threshold and recovery_threshold can't be negative")
+	}
+	if tls := cfg.Events.NATS.TLS; (tls.CertFile == "") != (tls.KeyFile == "") {
+		return errors.New("events nats tls requires both cert_file and key_file")
+	}
+	if tls := cfg.Events.Kafka.TLS; (tls.CertFile == "") != (tls.KeyFile == "") {
+		return errors.New("events kafka tls requires both cert_file and key_file")
+	}
+	switch cfg.Events.Kafka.SASL.Mechanism {
+	case "", SASLMechanismPlain, SASLMechanismSCRAMSHA256, SASLMechanismSCRAMSHA512:
+	default:
+		return fmt.Errorf("unknown events kafka sasl mechanism %q", cfg.Events.Kafka.SASL.Mechanism)
+	}
+	if rate := cfg.Server.KeyFilter.FalsePositiveRate; rate < 0 || rate >= 1 {
+		return errors.New("sshd key_filter false_positive_rate must be between 0 and 1")
+	}
+	if err := cfg.Preauthorization.isSane(); err != nil {
+		return err
+	}
+	if err := cfg.tenantsAreSane(); err != nil {
+		return err
+	}
 	return nil
 }
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -43,7 +48,7 @@ func TestCustomPrometheusMetrics(t *testing.T) {
 	require.NoError(t, err)
 
 	var actualNames []string
-	for _, m := range ms[0:9] {
+	for _, m := range ms[0:14] {
 		actualNames = append(actualNames, m.GetName())
 	}
 
@@ -51,10 +56,15 @@ func TestCustomPrometheusMetrics(t *testing.T) {
 		"gitlab_shell_http_in_flight_requests",
 		"gitlab_shell_http_request_duration_seconds",
 		"gitlab_shell_http_requests_total",
+		"gitlab_shell_sshd_auth_probes_total",
 		"gitlab_shell_sshd_concurrent_limited_sessions_total",
+		"gitlab_shell_sshd_dropped_startups_total",
 		"gitlab_shell_sshd_in_flight_connections",
+		"gitlab_shell_sshd_in_flight_handshakes",
 		"gitlab_shell_sshd_session_duration_seconds",
 		"gitlab_shell_sshd_session_established_duration_seconds",
+		"gitlab_shell_sshd_tcp_retransmits",
+		"gitlab_shell_sshd_tcp_rtt_seconds",
 		"gitlab_sli:shell_sshd_sessions:errors_total",
 		"gitlab_sli:shell_sshd_sessions:total",
 	}
@@ -98,3 +108,568 @@ func TestYAMLDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestIsSaneUploadPackTransport(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	for _, transport := range []string{"", GitalyTransportSidechannel, GitalyTransportStreaming} {
+		cfg.Gitaly.UploadPackTransport = transport
+		require.NoError(t, cfg.IsSane())
+	}
+
+	cfg.Gitaly.UploadPackTransport = "carrier-pigeon"
+	require.EqualError(t, cfg.IsSane(), `unknown gitaly upload_pack_transport "carrier-pigeon"`)
+}
+
+func TestIsSaneProtocolV2(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	for _, policy := range []string{"", ProtocolV2Allow, ProtocolV2Deny, ProtocolV2Require} {
+		cfg.Commands.ProtocolV2 = policy
+		require.NoError(t, cfg.IsSane())
+	}
+
+	cfg.Commands.ProtocolV2 = "force"
+	require.EqualError(t, cfg.IsSane(), `unknown commands protocol_v2 "force"`)
+}
+
+func TestIsSanePostQuantumKex(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	for _, policy := range []string{"", PostQuantumKexPrefer, PostQuantumKexDisabled} {
+		cfg.Server.PostQuantumKex = policy
+		require.NoError(t, cfg.IsSane())
+	}
+
+	cfg.Server.PostQuantumKex = "require"
+	require.EqualError(t, cfg.IsSane(), `unknown sshd post_quantum_kex "require"`)
+}
+
+func TestIsSaneWorkers(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	for _, workers := range []int{0, 4} {
+		cfg.Server.Workers = workers
+		require.NoError(t, cfg.IsSane())
+	}
+
+	cfg.Server.Workers = -1
+	require.EqualError(t, cfg.IsSane(), "sshd workers can't be negative")
+}
+
+func TestIsSaneEnv(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.Server.AllowedEnv = []string{"GIT_TRACE_ID", "_X1"}
+	cfg.Server.MaxEnvSize = 1024
+	require.NoError(t, cfg.IsSane())
+
+	cfg.Server.AllowedEnv = []string{"GIT TRACE"}
+	require.EqualError(t, cfg.IsSane(), `invalid variable name "GIT TRACE" in sshd allowed_env`)
+
+	cfg.Server.AllowedEnv = []string{"1X"}
+	require.EqualError(t, cfg.IsSane(), `invalid variable name "1X" in sshd allowed_env`)
+
+	cfg.Server.AllowedEnv = nil
+	cfg.Server.MaxEnvSize = -1
+	require.EqualError(t, cfg.IsSane(), "sshd max_env_size can't be negative")
+}
+
+func TestIsSaneKeyExpiryWarningPeriod(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.Server.KeyExpiryWarningPeriod = YamlDuration(-time.Hour)
+	require.EqualError(t, cfg.IsSane(), "sshd key_expiry_warning_period can't be negative")
+
+	cfg.Server.KeyExpiryWarningPeriod = YamlDuration(14 * 24 * time.Hour)
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneBundleURI(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.Commands.BundleURI = BundleURIConfig{URIs: []string{"https://bundles.example.com/{project}.bundle"}, Mode: BundleURIModeAny}
+	require.NoError(t, cfg.IsSane())
+
+	cfg.Commands.BundleURI.Mode = "some"
+	require.EqualError(t, cfg.IsSane(), `unknown commands bundle_uri mode "some"`)
+
+	cfg.Commands.BundleURI = BundleURIConfig{URIs: []string{"{project}.bundle"}}
+	require.EqualError(t, cfg.IsSane(), `invalid commands bundle_uri "{project}.bundle", an absolute URI is required`)
+}
+
+func TestIsSaneInternalAPIClient(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	for _, client := range []string{"", InternalAPIClientLegacy, InternalAPIClientV2} {
+		cfg.InternalAPI.Client = client
+		require.NoError(t, cfg.IsSane())
+	}
+
+	cfg.InternalAPI.Client = "v3"
+	require.EqualError(t, cfg.IsSane(), `unknown internal_api client "v3"`)
+}
+
+func TestIsSaneKeyFilterFalsePositiveRate(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	for _, rate := range []float64{0, 0.001, 0.5} {
+		cfg.Server.KeyFilter.FalsePositiveRate = rate
+		require.NoError(t, cfg.IsSane())
+	}
+
+	for _, rate := range []float64{-0.1, 1, 2} {
+		cfg.Server.KeyFilter.FalsePositiveRate = rate
+		require.EqualError(t, cfg.IsSane(), "sshd key_filter false_positive_rate must be between 0 and 1")
+	}
+}
+
+func TestIsSaneEventsNATSTLS(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	cfg.Events.NATS.TLS = EventsTLSConfig{Enabled: true, CertFile: "/tmp/client.crt"}
+	require.EqualError(t, cfg.IsSane(), "events nats tls requires both cert_file and key_file")
+
+	cfg.Events.NATS.TLS.KeyFile = "/tmp/client.key"
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneEventsKafka(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	cfg.Events.Kafka.TLS = EventsTLSConfig{Enabled: true, KeyFile: "/tmp/client.key"}
+	require.EqualError(t, cfg.IsSane(), "events kafka tls requires both cert_file and key_file")
+
+	cfg.Events.Kafka.TLS.CertFile = "/tmp/client.crt"
+	cfg.Events.Kafka.SASL = EventsSASLConfig{Mechanism: "gssapi"}
+	require.EqualError(t, cfg.IsSane(), `unknown events kafka sasl mechanism "gssapi"`)
+
+	cfg.Events.Kafka.SASL.Mechanism = SASLMechanismSCRAMSHA512
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSanePreauthorization(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	cfg.Preauthorization = PreauthorizationConfig{Enabled: true, PublicKeyFile: "/etc/gitlab-shell/preauthorization.pub"}
+	require.EqualError(t, cfg.IsSane(), "preauthorization requires public_key_file and gitaly_token_file")
+
+	cfg.Preauthorization.GitalyTokenFile = "/etc/gitlab-shell/gitaly_token"
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneSessionRecording(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	cfg.SessionRecording = SessionRecordingConfig{Enabled: true}
+	require.EqualError(t, cfg.IsSane(), "session_recording requires a spool_dir or a webhook_url")
+
+	cfg.SessionRecording.SpoolDir = "/var/spool/gitlab-shell"
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneSessionHooks(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	cfg.SessionHooks = SessionHooksConfig{PreSession: "/opt/hooks/pre-session", PostSession: "hooks/post-session"}
+	require.EqualError(t, cfg.IsSane(), `session_hooks program "hooks/post-session" must be an absolute path`)
+
+	cfg.SessionHooks.PostSession = "/opt/hooks/post-session"
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneSessionCgroups(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://socket", Secret: "secret"}
+
+	cfg.SessionCgroups = SessionCgroupsConfig{Enabled: true, Path: "gitlab-shell.slice"}
+	require.EqualError(t, cfg.IsSane(), "session_cgroups requires an absolute path")
+
+	cfg.SessionCgroups.Path = "/sys/fs/cgroup/gitlab-shell.slice"
+	cfg.SessionCgroups.MemoryLimit = -1
+	require.EqualError(t, cfg.IsSane(), "session_cgroups limits can't be negative")
+
+	cfg.SessionCgroups.MemoryLimit = 1024
+	cfg.SessionCgroups.CPUPercent = 100
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestCommandsIsDisabled(t *testing.T) {
+	commands := CommandsConfig{}
+	require.False(t, commands.IsDisabled("personal_access_token"))
+
+	commands.Disabled = []string{"personal_access_token", "2fa_recovery_codes"}
+	require.True(t, commands.IsDisabled("personal_access_token"))
+	require.True(t, commands.IsDisabled("2fa_recovery_codes"))
+	require.False(t, commands.IsDisabled("git-upload-pack"))
+
+	commands.Allowed = []string{"git-upload-pack", "personal_access_token"}
+	require.False(t, commands.IsDisabled("git-upload-pack"))
+	require.True(t, commands.IsDisabled("personal_access_token"))
+	require.True(t, commands.IsDisabled("git-receive-pack"))
+}
+
+func TestSanitized(t *testing.T) {
+	dir := t.TempDir()
+	configFile := dir + "/config.yml"
+	require.NoError(t, os.WriteFile(configFile, []byte("gitlab_url: http://gitlab.example.com\nsecret: file-secret\nsshd:\n  listen: \":2222\"\n  profiling:\n    password: pprof\n"), 0644))
+
+	cfg, err := newFromFile(configFile, "")
+	require.NoError(t, err)
+	cfg.SetSource("log_format", SourceEnv)
+
+	values, sources, err := cfg.Sanitized()
+	require.NoError(t, err)
+
+	require.Equal(t, "http://gitlab.example.com", values["gitlab_url"])
+	require.Equal(t, "[REDACTED]", values["secret"])
+	require.Equal(t, ":2222", values["sshd"].(map[string]interface{})["listen"])
+	require.Equal(t, "[REDACTED]", values["sshd"].(map[string]interface{})["profiling"].(map[string]interface{})["password"])
+	require.Equal(t, "10s", values["sshd"].(map[string]interface{})["grace_period"])
+
+	require.Equal(t, SourceFile, sources["gitlab_url"])
+	require.Equal(t, SourceFile, sources["sshd.listen"])
+	require.Equal(t, SourceFile, sources["secret"])
+	require.Equal(t, SourceEnv, sources["log_format"])
+	require.Equal(t, SourceDefault, sources["sshd.web_listen"])
+}
+
+func TestTenants(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(dir+"/.gitlab_shell_secret_b", []byte("secret-b"), 0600))
+	require.NoError(t, os.WriteFile(dir+"/config.yml", []byte(`user: git
+gitlab_url: http://gitlab-a.example.com
+secret: secret-a
+http_settings:
+  user: user-a
+  password: password-a
+  ca_file: /etc/ssl/gitlab-a.pem
+sshd:
+  listen: ":2222"
+maintenance_mode:
+  allowed_users: ["root"]
+tenants:
+  - name: gitlab-b
+    username_suffix: "+b"
+    gitlab_url: http%3A%2F%2Fgitlab-b.example.com
+    secret_file: .gitlab_shell_secret_b
+    http_settings:
+      user: user-b
+      password: password-b
+    maintenance_mode:
+      allowed_users: ["admin-b"]
+  - name: gitlab-c
+    gitlab_url: http://gitlab-c.example.com
+    secret: secret-c
+`), 0644))
+
+	cfg, err := NewFromDir(dir)
+	require.NoError(t, err)
+	require.NoError(t, cfg.IsSane())
+	require.Empty(t, cfg.TenantName())
+	require.Len(t, cfg.TenantConfigs(), 2)
+	require.Nil(t, cfg.Tenant("unknown"))
+
+	tenant := cfg.Tenant("gitlab-b")
+	require.Equal(t, "gitlab-b", tenant.TenantName())
+	require.Equal(t, "git+b", tenant.User)
+	require.Equal(t, "http://gitlab-b.example.com", tenant.GitlabUrl)
+	require.Equal(t, "secret-b", tenant.Secret)
+	require.Equal(t, ":2222", tenant.Server.Listen)
+	require.Empty(t, tenant.TenantConfigs())
+	require.Same(t, cfg.APICapture(), tenant.APICapture())
+	require.Same(t, cfg.GlobalBandwidthLimiter(), tenant.GlobalBandwidthLimiter())
+	require.Equal(t, "user-b", tenant.HttpSettings.User)
+	require.Equal(t, "password-b", tenant.HttpSettings.Password)
+	require.Empty(t, tenant.HttpSettings.CaFile)
+
+	cfg.Maintenance().Set(true, "")
+	require.NoError(t, tenant.Maintenance().CheckPush("admin-b", ""))
+	require.Error(t, tenant.Maintenance().CheckPush("root", ""))
+	cfg.Maintenance().Set(false, "")
+
+	tenant = cfg.Tenant("gitlab-c")
+	require.Equal(t, "git+gitlab-c", tenant.User)
+	require.Equal(t, "secret-c", tenant.Secret)
+	require.Empty(t, tenant.HttpSettings.User)
+	require.Empty(t, tenant.HttpSettings.Password)
+}
+
+func TestIsSaneTenants(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		tenants []TenantConfig
+		err     string
+	}{
+		{
+			desc:    "missing name",
+			tenants: []TenantConfig{{UsernameSuffix: "+b"}},
+			err:     "tenants require a name",
+		}, {
+			desc:    "duplicate name",
+			tenants: []TenantConfig{{Name: "b", UsernameSuffix: "+b"}, {Name: "b", UsernameSuffix: "+c"}},
+			err:     `tenant "b" is configured more than once`,
+		}, {
+			desc:    "username suffix used by the default of another tenant",
+			tenants: []TenantConfig{{Name: "b"}, {Name: "c", UsernameSuffix: "+b"}},
+			err:     `tenant "c": username_suffix "+b" is already used`,
+		}, {
+			desc:    "duplicate username suffix",
+			tenants: []TenantConfig{{Name: "b", UsernameSuffix: "+b"}, {Name: "c", UsernameSuffix: "+b"}},
+			err:     `tenant "c": username_suffix "+b" is already used`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret", Tenants: tc.tenants}
+			require.EqualError(t, cfg.IsSane(), tc.err)
+		})
+	}
+
+	parent := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+	tenant, err := newTenantConfig(parent, []byte("{}"), TenantConfig{Name: "b", UsernameSuffix: "+b", GitlabUrl: "http://gitlab-b"})
+	require.NoError(t, err)
+	parent.tenants = []*Config{tenant}
+	require.EqualError(t, parent.IsSane(), `tenant "b": secret or secret_file is required`)
+}
+
+func TestIsSaneDNSIPPreference(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.HttpSettings.DNS.IPPreference = "ipv5"
+	require.EqualError(t, cfg.IsSane(), `unknown http_settings dns ip_preference "ipv5"`)
+
+	cfg.HttpSettings.DNS.IPPreference = "ipv6"
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneProxyURL(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.HttpSettings.ProxyURL = "ftp://proxy"
+	require.EqualError(t, cfg.IsSane(), `http_settings proxy_url: invalid proxy URL "ftp://proxy": unknown scheme "ftp"`)
+
+	cfg.HttpSettings.ProxyURL = "socks5://proxy:1080"
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneLabeledMetrics(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.Server.LabeledMetrics.TopUsers = -1
+	require.EqualError(t, cfg.IsSane(), "sshd labeled_metrics top_projects and top_users can't be negative")
+
+	cfg.Server.LabeledMetrics.TopUsers = 20
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneStatsd(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.Server.Statsd.Address = "localhost"
+	require.EqualError(t, cfg.IsSane(), "sshd statsd address: address localhost: missing port in address")
+
+	cfg.Server.Statsd.Address = "localhost:8125"
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneWebTLSAndAuth(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.Server.WebTLS.CertFile = "/etc/gitlab-shell/web.crt"
+	require.EqualError(t, cfg.IsSane(), "sshd web_tls requires both cert_file and key_file")
+
+	cfg.Server.WebTLS = WebTLSConfig{ClientCAFile: "/etc/gitlab-shell/ca.crt"}
+	require.EqualError(t, cfg.IsSane(), "sshd web_tls client_ca_file requires cert_file and key_file")
+
+	cfg.Server.WebTLS = WebTLSConfig{CertFile: "/etc/gitlab-shell/web.crt", KeyFile: "/etc/gitlab-shell/web.key"}
+	cfg.Server.WebAuth.Username = "prometheus"
+	require.EqualError(t, cfg.IsSane(), "sshd web_auth requires both username and password")
+
+	cfg.Server.WebAuth.Password = "secret"
+	require.NoError(t, cfg.IsSane())
+
+	cfg.Server.WebAuth = WebAuthConfig{BearerToken: "token"}
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneControlSocket(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.Server.ControlSocket.Path = "/run/gitlab-shell/control.sock"
+	require.EqualError(t, cfg.IsSane(), "sshd control_socket requires a token")
+
+	cfg.Server.ControlSocket.Token = "token"
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneLocale(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.Locale = "tlh"
+	require.EqualError(t, cfg.IsSane(), `unsupported locale "tlh", supported: en, de, es, fr, ja, pt_BR`)
+
+	cfg.Locale = "de_DE.UTF-8"
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneDisabledForActors(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.Commands.DisabledForActors = map[string][]string{"robot": {"whoami"}}
+	require.EqualError(t, cfg.IsSane(), `unknown actor type "robot" in commands disabled_for_actors`)
+
+	cfg.Commands.DisabledForActors = map[string][]string{"deploy_key": {"whoami"}}
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsDisabledFor(t *testing.T) {
+	c := CommandsConfig{
+		Disabled:          []string{"personal_access_token"},
+		DisabledForActors: map[string][]string{"deploy_key": {"whoami"}},
+	}
+
+	require.True(t, c.IsDisabledFor("personal_access_token", "user"))
+	require.False(t, c.IsDisabledFor("whoami", "user"))
+	require.False(t, c.IsDisabledFor("whoami", ""))
+	require.True(t, c.IsDisabledFor("whoami", "deploy_key"))
+	require.False(t, c.IsDisabledFor("git-upload-pack", "deploy_key"))
+}
+
+func TestIsSaneMessages(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.Messages = map[string]string{"welcome": "Welcome {{.Username"}
+	require.ErrorContains(t, cfg.IsSane(), "messages: message welcome: template: welcome:1: unclosed action")
+
+	cfg.Messages = map[string]string{"welcome": "Welcome to ACME Git, @{{.Username}}!"}
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneHedging(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http://localhost", Secret: "secret"}
+
+	cfg.HttpSettings.Hedging.Delay = YamlDuration(-time.Second)
+	require.EqualError(t, cfg.IsSane(), "http_settings hedging delay can't be negative")
+
+	cfg.HttpSettings.Hedging = HedgingConfig{Delay: YamlDuration(time.Second), Paths: []string{"discover"}}
+	require.EqualError(t, cfg.IsSane(), `http_settings hedging path "discover" must start with /`)
+
+	cfg.HttpSettings.Hedging.Paths = []string{"/discover"}
+	require.NoError(t, cfg.IsSane())
+}
+
+func TestIsSaneGitlabUrls(t *testing.T) {
+	cfg := &Config{GitlabUrl: "http+unix://%2Ftmp%2Fgitlab.socket", Secret: "secret"}
+
+	cfg.GitlabUrls = []string{"https://gitlab-b.example.com"}
+	require.EqualError(t, cfg.IsSane(), `gitlab_urls only supports http:// and https:// URLs, got "http+unix://%2Ftmp%2Fgitlab.socket"`)
+
+	cfg.GitlabUrl = "https://gitlab-a.example.com"
+	require.NoError(t, cfg.IsSane())
+
+	cfg.GitlabFailover.FailureThreshold = -1
+	require.EqualError(t, cfg.IsSane(), "gitlab_failover failure_threshold and recovery_threshold can't be negative")
+}
+
+func TestGitlabUrls(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+
+	require.NoError(t, os.WriteFile(path, []byte("secret: s\ngitlab_urls:\n  - https://gitlab-a.example.com\n  - https://gitlab-b.example.com\n"), 0600))
+	cfg, err := newFromFile(path, "")
+	require.NoError(t, err)
+	require.Equal(t, "https://gitlab-a.example.com", cfg.GitlabUrl)
+	require.Equal(t, []string{"https://gitlab-a.example.com", "https://gitlab-b.example.com"}, cfg.gitlabURLs())
+
+	cfg.GitlabUrl = "https://gitlab-b.example.com"
+	require.Equal(t, []string{"https://gitlab-b.example.com", "https://gitlab-a.example.com"}, cfg.gitlabURLs())
+
+	client, err := cfg.HttpClient()
+	require.NoError(t, err)
+	require.NotNil(t, client.Failover)
+	require.Equal(t, "https://gitlab-b.example.com", client.Failover.Active())
+}
+
+func TestHTTPTransport(t *testing.T) {
+	cfg := &Config{FIPSMode: true, HttpSettings: HttpSettingsConfig{ProxyURL: "http://proxy:3128"}}
+
+	transport, err := cfg.HTTPTransport()
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), transport.TLSClientConfig.MaxVersion)
+	require.NotEmpty(t, transport.TLSClientConfig.CipherSuites)
+
+	proxyURL, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "lfs.example.com"}})
+	require.NoError(t, err)
+	require.Equal(t, "http://proxy:3128", proxyURL.String())
+
+	cfg.HttpSettings.ProxyURL = "ftp://proxy"
+	_, err = cfg.HTTPTransport()
+	require.Error(t, err)
+}
+
+func TestGitalyProxyURL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+
+	require.NoError(t, os.WriteFile(path, []byte("secret: s\ngitaly:\n  proxy_url: socks5://proxy:1080\n"), 0600))
+	cfg, err := newFromFile(path, "")
+	require.NoError(t, err)
+	require.Equal(t, "socks5://proxy:1080", cfg.GitalyClient.ProxyURL.String())
+
+	require.NoError(t, os.WriteFile(path, []byte("secret: s\ngitaly:\n  proxy_url: ftp://proxy\n"), 0600))
+	_, err = newFromFile(path, "")
+	require.EqualError(t, err, `gitaly proxy_url: invalid proxy URL "ftp://proxy": unknown scheme "ftp"`)
+}
+
+func TestFaultInjection(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+
+	rules := "  rules:\n    - target: gitaly\n      percentage: 50\n      latency: 100ms\n"
+	require.NoError(t, os.WriteFile(path, []byte("secret: s\nfault_injection:\n"+rules), 0600))
+	cfg, err := newFromFile(path, "")
+	require.NoError(t, err)
+	require.Nil(t, cfg.GitalyClient.FaultInjector)
+
+	require.NoError(t, os.WriteFile(path, []byte("secret: s\nfault_injection:\n  enabled: true\n"+rules), 0600))
+	cfg, err = newFromFile(path, "")
+	require.NoError(t, err)
+	require.NotNil(t, cfg.GitalyClient.FaultInjector)
+	require.Same(t, cfg.faultInjector, cfg.GitalyClient.FaultInjector)
+
+	require.NoError(t, os.WriteFile(path, []byte("secret: s\nfault_injection:\n  enabled: true\n  rules:\n    - target: redis\n      percentage: 50\n"), 0600))
+	_, err = newFromFile(path, "")
+	require.EqualError(t, err, `fault_injection: rule 0: unknown target "redis"`)
+}
+
+func TestParseMaxStartups(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected MaxStartups
+		err      bool
+	}{
+		{value: ""},
+		{value: "10:30:100", expected: MaxStartups{Start: 10, Rate: 30, Full: 100}},
+		{value: "50", expected: MaxStartups{Start: 50, Rate: 100, Full: 50}},
+		{value: "10:30", err: true},
+		{value: "10:130:100", err: true},
+		{value: "100:30:10", err: true},
+		{value: "0:30:10", err: true},
+		{value: "-1", err: true},
+		{value: "ten", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			maxStartups, err := ParseMaxStartups(tc.value)
+			if tc.err {
+				require.EqualError(t, err, fmt.Sprintf("invalid sshd max_startups %q: expected start:rate:full, e.g. 10:30:100, or a single limit", tc.value))
+				require.ErrorContains(t, (&Config{GitlabUrl: "http://localhost", Secret: "s", Server: ServerConfig{MaxStartups: tc.value}}).IsSane(), "invalid sshd max_startups")
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, maxStartups)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// The age identities decrypting the encrypted values of the config files,
+// either inline or from a file
+const (
+	AgeKeyEnv     = "GITLAB_SHELL_AGE_KEY"
+	AgeKeyFileEnv = "GITLAB_SHELL_AGE_KEY_FILE"
+)
+
+const (
+	// sopsKey holds the metadata of the files encrypted with sops
+	sopsKey = "sops"
+	// sopsNonceSize is the size of the IVs of the values sops encrypts
+	sopsNonceSize = 32
+)
+
+var sopsValueRegexp = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// decryptValues returns the config file data with its encrypted values
+// decrypted, as YAML. The values are either encrypted with age and armored,
+// or encrypted by sops with age keys, the file keeping the metadata of sops.
+// Only the values of sops files are decrypted, the MAC of the whole file
+// isn't verified.
+func decryptValues(path string, data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte(armor.Header)) && !bytes.Contains(data, []byte("ENC[AES256_GCM,")) {
+		return data, nil
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return data, nil
+	}
+
+	d := &decrypter{root: document.Content[0]}
+	if err := d.decrypt(d.root, nil); err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+	// The markers may only be in comments
+	if !d.decrypted {
+		return data, nil
+	}
+
+	return yaml.Marshal(&document)
+}
+
+// ageIdentities returns the identities of the environment
+func ageIdentities() ([]age.Identity, error) {
+	var keys io.Reader
+	switch {
+	case os.Getenv(AgeKeyEnv) != "":
+		keys = strings.NewReader(os.Getenv(AgeKeyEnv))
+	case os.Getenv(AgeKeyFileEnv) != "":
+		f, err := os.Open(os.Getenv(AgeKeyFileEnv))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		keys = f
+	default:
+		return nil, fmt.Errorf("neither %s nor %s is set", AgeKeyEnv, AgeKeyFileEnv)
+	}
+
+	return age.ParseIdentities(keys)
+}
+
+type decrypter struct {
+	// identities are loaded when the first encrypted value is met
+	identities []age.Identity
+	root       *yaml.Node
+	decrypted  bool
+	// sopsDataKey is the key of the values encrypted by sops, decrypted
+	// from the metadata when the first one is met
+	sopsDataKey []byte
+}
+
+func (d *decrypter) decrypt(node *yaml.Node, path []string) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if node == d.root && key == sopsKey {
+				continue
+			}
+
+			if err := d.decrypt(node.Content[i+1], append(path, key)); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := d.decrypt(item, path); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		switch {
+		case strings.HasPrefix(node.Value, "ENC[AES256_GCM,"):
+			return d.decryptSopsValue(node, path)
+		case strings.HasPrefix(strings.TrimSpace(node.Value), armor.Header):
+			identities, err := d.ageIdentities()
+			if err != nil {
+				return fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+			}
+
+			plaintext, err := decryptAge(node.Value, identities)
+			if err != nil {
+				return fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+			}
+
+			d.setScalar(node, string(plaintext), "!!str")
+		}
+	}
+
+	return nil
+}
+
+func (d *decrypter) ageIdentities() ([]age.Identity, error) {
+	if d.identities == nil {
+		identities, err := ageIdentities()
+		if err != nil {
+			return nil, err
+		}
+		d.identities = identities
+	}
+
+	return d.identities, nil
+}
+
+func (d *decrypter) decryptSopsValue(node *yaml.Node, path []string) error {
+	match := sopsValueRegexp.FindStringSubmatch(node.Value)
+	if match == nil {
+		return fmt.Errorf("%s: invalid sops value", strings.Join(path, "."))
+	}
+
+	if d.sopsDataKey == nil {
+		key, err := d.decryptSopsDataKey()
+		if err != nil {
+			return err
+		}
+		d.sopsDataKey = key
+	}
+
+	var parts [3][]byte
+	for i, encoded := range match[1:4] {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("%s: invalid sops value: %w", strings.Join(path, "."), err)
+		}
+		parts[i] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(d.sopsDataKey)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, sopsNonceSize)
+	if err != nil {
+		return err
+	}
+
+	// The path of the value is authenticated, for values not to be swapped
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(strings.Join(path, ":")+":"))
+	if err != nil {
+		return fmt.Errorf("%s: failed to decrypt the sops value: %w", strings.Join(path, "."), err)
+	}
+
+	switch valueType := match[4]; valueType {
+	case "str", "bytes":
+		d.setScalar(node, string(plaintext), "!!str")
+	case "int":
+		d.setScalar(node, string(plaintext), "!!int")
+	case "float":
+		d.setScalar(node, string(plaintext), "!!float")
+	case "bool":
+		d.setScalar(node, strings.ToLower(string(plaintext)), "!!bool")
+	default:
+		return fmt.Errorf("%s: unsupported sops value type %q", strings.Join(path, "."), valueType)
+	}
+
+	return nil
+}
+
+// decryptSopsDataKey decrypts the data key of sops with the age identities,
+// trying the key encrypted for each of the age recipients of the file
+func (d *decrypter) decryptSopsDataKey() ([]byte, error) {
+	var metadata struct {
+		Age []struct {
+			Enc string `yaml:"enc"`
+		} `yaml:"age"`
+	}
+
+	for i := 0; i+1 < len(d.root.Content); i += 2 {
+		if d.root.Content[i].Value == sopsKey {
+			if err := d.root.Content[i+1].Decode(&metadata); err != nil {
+				return nil, fmt.Errorf("invalid sops metadata: %w", err)
+			}
+		}
+	}
+	if len(metadata.Age) == 0 {
+		return nil, errors.New("the sops metadata has no age key, only age is supported")
+	}
+
+	identities, err := d.ageIdentities()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, recipient := range metadata.Age {
+		var key []byte
+		if key, err = decryptAge(recipient.Enc, identities); err == nil {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to decrypt the sops data key: %w", err)
+}
+
+func decryptAge(armored string, identities []age.Identity) ([]byte, error) {
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(strings.TrimSpace(armored))), identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+func (d *decrypter) setScalar(node *yaml.Node, value, tag string) {
+	d.decrypted = true
+	node.Value = value
+	node.Tag = tag
+	node.Style = 0
+}
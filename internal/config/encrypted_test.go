@@ -0,0 +1,134 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/require"
+)
+
+func ageEncrypt(t *testing.T, plaintext []byte, recipient age.Recipient) string {
+	var b bytes.Buffer
+	armored := armor.NewWriter(&b)
+	w, err := age.Encrypt(armored, recipient)
+	require.NoError(t, err)
+	_, err = w.Write(plaintext)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, armored.Close())
+
+	return b.String()
+}
+
+// sopsEncrypt encrypts a value like sops does, path being its keys
+func sopsEncrypt(t *testing.T, dataKey []byte, value, valueType string, path ...string) string {
+	block, err := aes.NewCipher(dataKey)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCMWithNonceSize(block, sopsNonceSize)
+	require.NoError(t, err)
+
+	iv := make([]byte, sopsNonceSize)
+	_, err = rand.Read(iv)
+	require.NoError(t, err)
+
+	out := gcm.Seal(nil, iv, []byte(value), []byte(strings.Join(path, ":")+":"))
+	encode := base64.StdEncoding.EncodeToString
+
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		encode(out[:len(out)-aes.BlockSize]), encode(iv), encode(out[len(out)-aes.BlockSize:]), valueType)
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(strings.TrimSpace(s), "\n", "\n"+prefix)
+}
+
+func TestAgeEncryptedValues(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte(`strict_config: true
+gitlab_url: http://gitlab.example.com
+secret: |
+`+indent(ageEncrypt(t, []byte("age-secret"), identity.Recipient()), "  ")+`
+sshd:
+  listen: ":2222"
+`), 0o644))
+
+	_, err = NewFromDir(dir)
+	require.EqualError(t, err, "failed to decrypt "+filepath.Join(dir, "config.yml")+": secret: neither GITLAB_SHELL_AGE_KEY nor GITLAB_SHELL_AGE_KEY_FILE is set")
+
+	keyFile := filepath.Join(dir, "keys.txt")
+	require.NoError(t, os.WriteFile(keyFile, []byte("# created for the test\n"+identity.String()+"\n"), 0o600))
+	t.Setenv(AgeKeyFileEnv, keyFile)
+
+	cfg, err := NewFromDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, "age-secret", cfg.Secret)
+	require.Equal(t, ":2222", cfg.Server.Listen)
+
+	other, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	t.Setenv(AgeKeyEnv, other.String())
+
+	_, err = NewFromDir(dir)
+	require.ErrorContains(t, err, "failed to decrypt "+filepath.Join(dir, "config.yml")+": secret: no identity matched any of the recipients")
+}
+
+func TestSopsEncryptedValues(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	t.Setenv(AgeKeyEnv, identity.String())
+
+	dataKey := make([]byte, 32)
+	_, err = rand.Read(dataKey)
+	require.NoError(t, err)
+
+	content := fmt.Sprintf(`strict_config: true
+gitlab_url: http://gitlab.example.com
+secret: %s
+sshd:
+  listen: ":2222"
+  concurrent_sessions_limit: %s
+  proxy_protocol: %s
+sops:
+  age:
+    - recipient: %s
+      enc: |
+%s
+  lastmodified: "2024-01-01T00:00:00Z"
+  mac: ENC[AES256_GCM,data:AAAA,iv:AAAA,tag:AAAA,type:str]
+  version: 3.8.1
+`,
+		sopsEncrypt(t, dataKey, "sops-secret", "str", "secret"),
+		sopsEncrypt(t, dataKey, "25", "int", "sshd", "concurrent_sessions_limit"),
+		sopsEncrypt(t, dataKey, "True", "bool", "sshd", "proxy_protocol"),
+		identity.Recipient(),
+		indent(ageEncrypt(t, dataKey, identity.Recipient()), "        "))
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte(content), 0o644))
+
+	cfg, err := NewFromDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, "sops-secret", cfg.Secret)
+	require.Equal(t, int64(25), cfg.Server.ConcurrentSessionsLimit)
+	require.True(t, cfg.Server.ProxyProtocol)
+
+	// A value moved to another key doesn't decrypt
+	swapped := sopsEncrypt(t, dataKey, "sops-secret", "str", "sshd", "listen")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte(strings.Replace(content, `listen: ":2222"`, "listen: "+swapped, 1)+"gitlab_tracing: "+swapped+"\n"), 0o644))
+
+	_, err = NewFromDir(dir)
+	require.ErrorContains(t, err, "gitlab_tracing: failed to decrypt the sops value")
+}
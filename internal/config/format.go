@@ -0,0 +1,67 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configFiles are the names the config file can have in each supported
+// format, by precedence. Whatever the format, the settings have the same
+// names, defaults and validation as in YAML.
+var configFiles = []string{"config.yml", "config.toml", "config.json"}
+
+// findConfigFile returns the path of the config file of dir, config.yml when
+// there's none for the error to mention it
+func findConfigFile(dir string) string {
+	for _, name := range configFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return filepath.Join(dir, configFiles[0])
+}
+
+// ReadFile reads the config file at path, converted to YAML when it's in
+// another format as told by its extension
+func ReadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]interface{}
+	switch filepath.Ext(path) {
+	case ".toml":
+		if err := toml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	default:
+		return data, nil
+	}
+
+	return yaml.Marshal(values)
+}
+
+// File returns the path of the config file the config was read from, or
+// the one of its root directory when it wasn't read from a file
+func (c *Config) File() string {
+	switch {
+	case c.parent != nil:
+		return c.parent.File()
+	case c.file == "" && c.RootDir != "":
+		return findConfigFile(c.RootDir)
+	}
+
+	return c.file
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	yamlConfig = `gitlab_url: http://gitlab.example.com
+secret: secret-a
+log_level: debug
+sshd:
+  listen: ":2222"
+  grace_period: 30s
+  client_alive_interval: 20
+  host_key_files:
+    - /keys/ssh_host_ed25519_key
+tenants:
+  - name: gitlab-b
+    gitlab_url: http://gitlab-b.example.com
+    secret: secret-b
+`
+	tomlConfig = `gitlab_url = "http://gitlab.example.com"
+secret = "secret-a"
+log_level = "debug"
+
+[sshd]
+listen = ":2222"
+grace_period = "30s"
+client_alive_interval = 20
+host_key_files = ["/keys/ssh_host_ed25519_key"]
+
+[[tenants]]
+name = "gitlab-b"
+gitlab_url = "http://gitlab-b.example.com"
+secret = "secret-b"
+`
+	jsonConfig = `{
+  "gitlab_url": "http://gitlab.example.com",
+  "secret": "secret-a",
+  "log_level": "debug",
+  "sshd": {
+    "listen": ":2222",
+    "grace_period": "30s",
+    "client_alive_interval": 20,
+    "host_key_files": ["/keys/ssh_host_ed25519_key"]
+  },
+  "tenants": [
+    {"name": "gitlab-b", "gitlab_url": "http://gitlab-b.example.com", "secret": "secret-b"}
+  ]
+}
+`
+)
+
+func TestConfigFileFormats(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.yml":  yamlConfig,
+		"config.toml": tomlConfig,
+		"config.json": jsonConfig,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+
+			cfg, err := NewFromDir(dir)
+			require.NoError(t, err)
+			require.Equal(t, filepath.Join(dir, name), cfg.File())
+
+			require.Equal(t, "http://gitlab.example.com", cfg.GitlabUrl)
+			require.Equal(t, "secret-a", cfg.Secret)
+			require.Equal(t, "debug", cfg.LogLevel)
+			require.Equal(t, ":2222", cfg.Server.Listen)
+			require.Equal(t, YamlDuration(30*time.Second), cfg.Server.GracePeriod)
+			require.Equal(t, YamlDuration(20*time.Second), cfg.Server.ClientAliveInterval)
+			require.Equal(t, []string{"/keys/ssh_host_ed25519_key"}, cfg.Server.HostKeyFiles)
+			// The defaults apply to the settings the file doesn't have
+			require.Equal(t, DefaultServerConfig.WebListen, cfg.Server.WebListen)
+			require.NoError(t, cfg.IsSane())
+
+			require.Len(t, cfg.TenantConfigs(), 1)
+			require.Equal(t, "http://gitlab-b.example.com", cfg.TenantConfigs()[0].GitlabUrl)
+			require.Equal(t, ":2222", cfg.TenantConfigs()[0].Server.Listen)
+
+			_, sources, err := cfg.Sanitized()
+			require.NoError(t, err)
+			require.Equal(t, SourceFile, sources["sshd.listen"])
+			require.Equal(t, SourceDefault, sources["sshd.web_listen"])
+		})
+	}
+}
+
+func TestConfigFilePrecedence(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"secret": "json"}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.toml"), []byte(`secret = "toml"`), 0o644))
+
+	cfg, err := NewFromDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, "toml", cfg.Secret)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte(`secret: yaml`), 0o644))
+
+	cfg, err = NewFromDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, "yaml", cfg.Secret)
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewFromDir(dir)
+	require.ErrorContains(t, err, filepath.Join(dir, "config.yml"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.toml"), []byte(`secret = `), 0o644))
+	_, err = NewFromDir(dir)
+	require.ErrorContains(t, err, "failed to parse "+filepath.Join(dir, "config.toml"))
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "config.toml")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"secret": `), 0o644))
+	_, err = NewFromDir(dir)
+	require.ErrorContains(t, err, "failed to parse "+filepath.Join(dir, "config.json"))
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFragment is a config file, either the main one or one it includes
+type configFragment struct {
+	path  string
+	bytes []byte
+}
+
+// readWithIncludes reads the config file at path and the files its include
+// setting matches, and returns the settings of all of them merged as YAML
+// with their encrypted values decrypted, and the files as they were read.
+// The included files are merged in the order of the patterns, the files
+// matching a pattern in the lexical order of their paths. Each file
+// overrides the settings of the ones before it: mappings are merged key by
+// key, while lists and other values are replaced.
+func readWithIncludes(path string) ([]byte, []configFragment, error) {
+	raw, err := ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	fragments := []configFragment{{path: path, bytes: raw}}
+
+	configBytes, err := decryptValues(path, raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var main struct {
+		Include []string `yaml:"include"`
+	}
+	if err := yaml.Unmarshal(configBytes, &main); err != nil {
+		return nil, nil, err
+	}
+	if len(main.Include) == 0 {
+		return configBytes, fragments, nil
+	}
+
+	merged := map[string]interface{}{}
+	if err := yaml.Unmarshal(configBytes, &merged); err != nil {
+		return nil, nil, err
+	}
+
+	for _, pattern := range main.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid include %q: %w", pattern, err)
+		}
+
+		for _, match := range matches {
+			fragment, err := ReadFile(match)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			decrypted, err := decryptValues(match, fragment)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			values := map[string]interface{}{}
+			if err := yaml.Unmarshal(decrypted, &values); err != nil {
+				return nil, nil, fmt.Errorf("failed to parse %s: %w", match, err)
+			}
+			if _, ok := values["include"]; ok {
+				return nil, nil, fmt.Errorf("%s: include is only supported in the main config file", match)
+			}
+
+			mergeSettings(merged, values)
+			fragments = append(fragments, configFragment{path: match, bytes: fragment})
+		}
+	}
+
+	configBytes, err = yaml.Marshal(merged)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return configBytes, fragments, nil
+}
+
+// mergeSettings merges the settings of src into dst
+func mergeSettings(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeSettings(dstMap, srcMap)
+			continue
+		}
+
+		dst[key] = value
+	}
+}
+
+// checkFragments checks every config file for unknown settings, for their
+// lines to be reported
+func checkFragments(fragments []configFragment) error {
+	var errs []error
+	for _, fragment := range fragments {
+		if err := checkUnknownSettings(fragment.path, fragment.bytes); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "conf.d"), 0o755))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte(`include:
+  - conf.d/*.yml
+  - secrets.toml
+gitlab_url: http://gitlab.example.com
+log_level: info
+sshd:
+  listen: ":2222"
+  host_key_files:
+    - /keys/ssh_host_rsa_key
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "10-sshd.yml"), []byte(`sshd:
+  concurrent_sessions_limit: 100
+  host_key_files:
+    - /keys/ssh_host_ed25519_key
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "20-logging.yml"), []byte("log_level: debug\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "05-logging.yml"), []byte("log_level: warn\nlog_format: text\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secrets.toml"), []byte(`secret = "included-secret"`), 0o600))
+
+	cfg, err := NewFromDir(dir)
+	require.NoError(t, err)
+
+	require.Equal(t, "http://gitlab.example.com", cfg.GitlabUrl)
+	require.Equal(t, "included-secret", cfg.Secret)
+	require.Equal(t, "debug", cfg.LogLevel)
+	require.Equal(t, "text", cfg.LogFormat)
+	// Mappings are merged, lists are replaced
+	require.Equal(t, ":2222", cfg.Server.Listen)
+	require.Equal(t, int64(100), cfg.Server.ConcurrentSessionsLimit)
+	require.Equal(t, []string{"/keys/ssh_host_ed25519_key"}, cfg.Server.HostKeyFiles)
+
+	_, sources, err := cfg.Sanitized()
+	require.NoError(t, err)
+	require.Equal(t, SourceFile, sources["sshd.concurrent_sessions_limit"])
+}
+
+func TestIncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	write("config.yml", "secret: s\ninclude: [\"extra.yml\"]\n")
+	write("extra.yml", "include: [\"more.yml\"]\n")
+	_, err := NewFromDir(dir)
+	require.EqualError(t, err, filepath.Join(dir, "extra.yml")+": include is only supported in the main config file")
+
+	write("extra.yml", "sshd: [")
+	_, err = NewFromDir(dir)
+	require.ErrorContains(t, err, "failed to parse "+filepath.Join(dir, "extra.yml"))
+
+	// The unknown settings of each file are reported with their lines
+	write("config.yml", "strict_config: true\nsecret: s\ninclude: [\"extra.yml\"]\n")
+	write("extra.yml", "log_levl: debug\n")
+	_, err = NewFromDir(dir)
+	require.EqualError(t, err, "unknown settings in "+filepath.Join(dir, "extra.yml")+":\n  line 1: log_levl, did you mean log_level?")
+
+	// Patterns without match are fine
+	write("config.yml", "secret: s\ninclude: [\"conf.d/*.yml\"]\n")
+	_, err = NewFromDir(dir)
+	require.NoError(t, err)
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProfileEnv is the environment variable selecting the config profile when
+// it isn't set otherwise, e.g. GITLAB_SHELL_PROFILE=development
+const ProfileEnv = "GITLAB_SHELL_PROFILE"
+
+// Config profiles preset the defaults for the environment gitlab-shell runs
+// in. The settings of the config file take precedence over them.
+const (
+	ProfileProduction  = "production"
+	ProfileDevelopment = "development"
+	ProfileTest        = "test"
+)
+
+// profiles hold the defaults each profile changes, as a config file would.
+// Production keeps the defaults of DefaultConfig.
+var profiles = map[string]string{
+	ProfileProduction: ``,
+	// A local GitLab, e.g. the GDK, with readable logs on stderr and
+	// timeouts relaxed for debuggers
+	ProfileDevelopment: `
+gitlab_url: http://localhost:3000
+log_file: ""
+log_format: text
+log_level: debug
+http_settings:
+  read_timeout: 600
+sshd:
+  listen: localhost:2222
+  grace_period: 1s
+  login_grace_time: 10m
+`,
+	// Quick to fail and to shut down, on ports that don't conflict
+	ProfileTest: `
+log_file: ""
+log_format: text
+log_level: debug
+http_settings:
+  read_timeout: 10
+sshd:
+  listen: 127.0.0.1:0
+  web_listen: ""
+  grace_period: 0s
+  login_grace_time: 10s
+`,
+}
+
+// Profiles returns the names of the config profiles
+func Profiles() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewFromProfile returns the defaults of profile, to run without a config
+// file
+func NewFromProfile(profile string) (*Config, error) {
+	return newDefaults(profile)
+}
+
+// newDefaults returns the defaults of profile, the production ones when it's
+// empty
+func newDefaults(profile string) (*Config, error) {
+	cfg := &Config{}
+	*cfg = DefaultConfig
+
+	if profile == "" {
+		return cfg, nil
+	}
+
+	values, ok := profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown config profile %q, expected one of %s", profile, strings.Join(Profiles(), ", "))
+	}
+
+	if err := yaml.Unmarshal([]byte(values), cfg); err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal([]byte(values), &cfg.profileValues); err != nil {
+		return nil, err
+	}
+	cfg.profile = profile
+
+	return cfg, nil
+}
+
+// profileFromEnv returns the profile selected by the environment
+func profileFromEnv() string {
+	return os.Getenv(ProfileEnv)
+}
+
+// WriteEffective writes the settings in effect as YAML, with their secrets
+// redacted and the source of each setting as a comment
+func (c *Config) WriteEffective(w io.Writer) error {
+	values, sources, err := c.Sanitized()
+	if err != nil {
+		return err
+	}
+
+	var node yaml.Node
+	if err := node.Encode(values); err != nil {
+		return err
+	}
+	annotateSources(&node, "", sources)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+func annotateSources(node *yaml.Node, prefix string, sources map[string]string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := prefix + key.Value
+
+		if value.Kind == yaml.MappingNode {
+			annotateSources(value, path+".", sources)
+			continue
+		}
+
+		if source, ok := sources[path]; ok {
+			key.LineComment = source
+		}
+	}
+}
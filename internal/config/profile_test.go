@@ -0,0 +1,87 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiles(t *testing.T) {
+	require.Equal(t, []string{ProfileDevelopment, ProfileProduction, ProfileTest}, Profiles())
+
+	_, err := NewFromProfile("staging")
+	require.EqualError(t, err, `unknown config profile "staging", expected one of development, production, test`)
+
+	cfg, err := NewFromProfile(ProfileProduction)
+	require.NoError(t, err)
+	require.Equal(t, DefaultConfig.LogFormat, cfg.LogFormat)
+	require.Equal(t, DefaultServerConfig.Listen, cfg.Server.Listen)
+
+	cfg, err = NewFromProfile(ProfileDevelopment)
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:3000", cfg.GitlabUrl)
+	require.Empty(t, cfg.LogFile)
+	require.Equal(t, "text", cfg.LogFormat)
+	require.Equal(t, "debug", cfg.LogLevel)
+	require.Equal(t, "localhost:2222", cfg.Server.Listen)
+	require.Equal(t, YamlDuration(10*time.Minute), cfg.Server.LoginGraceTime)
+	// The settings the profile doesn't change keep their defaults
+	require.Equal(t, DefaultServerConfig.ReadinessProbe, cfg.Server.ReadinessProbe)
+
+	cfg, err = NewFromProfile(ProfileTest)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:0", cfg.Server.Listen)
+	require.Empty(t, cfg.Server.WebListen)
+}
+
+func TestNewFromDirWithProfile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte("secret: file-secret\nlog_level: warn\nsshd:\n  listen: \":2022\"\n"), 0o644))
+
+	cfg, err := NewFromDirWithProfile(dir, ProfileDevelopment)
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:3000", cfg.GitlabUrl)
+	require.Equal(t, "warn", cfg.LogLevel)
+	require.Equal(t, ":2022", cfg.Server.Listen)
+
+	_, sources, err := cfg.Sanitized()
+	require.NoError(t, err)
+	require.Equal(t, SourceProfile, sources["gitlab_url"])
+	require.Equal(t, SourceFile, sources["log_level"])
+	require.Equal(t, SourceFile, sources["sshd.listen"])
+	require.Equal(t, SourceProfile, sources["sshd.grace_period"])
+	require.Equal(t, SourceDefault, sources["sshd.readiness_probe"])
+
+	t.Setenv(ProfileEnv, ProfileTest)
+	cfg, err = NewFromDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, cfg.Server.WebListen)
+
+	t.Setenv(ProfileEnv, "staging")
+	_, err = NewFromDir(dir)
+	require.ErrorContains(t, err, `unknown config profile "staging"`)
+}
+
+func TestWriteEffective(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte("secret: file-secret\nsshd:\n  listen: \":2022\"\n"), 0o644))
+
+	cfg, err := NewFromDirWithProfile(dir, ProfileDevelopment)
+	require.NoError(t, err)
+	cfg.SetSource("log_format", SourceEnv)
+
+	var b bytes.Buffer
+	require.NoError(t, cfg.WriteEffective(&b))
+
+	out := b.String()
+	require.Contains(t, out, "gitlab_url: http://localhost:3000 # profile\n")
+	require.Contains(t, out, "secret: '[REDACTED]' # file\n")
+	require.Contains(t, out, "log_format: text # env\n")
+	require.Contains(t, out, "\n  listen: :2022 # file\n")
+	require.Contains(t, out, "\n  readiness_probe: /start # default\n")
+	require.NotContains(t, out, "file-secret")
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Where the value of a setting comes from
+const (
+	SourceDefault    = "default"
+	SourceProfile    = "profile"
+	SourceFile       = "file"
+	SourceSecretFile = "secret_file"
+	SourceEnv        = "env"
+	SourceFlag       = "flag"
+)
+
+const redacted = "[REDACTED]"
+
+// SetSource records that the setting at path, e.g. "sshd.listen", was set
+// from source rather than from the config file
+func (c *Config) SetSource(path, source string) {
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+
+	c.sources[path] = source
+}
+
+// Sanitized returns the settings in effect with their secrets redacted, and
+// the source of each setting keyed by its path
+func (c *Config) Sanitized() (map[string]interface{}, map[string]string, error) {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(out, &values); err != nil {
+		return nil, nil, err
+	}
+
+	sources := map[string]string{}
+	c.sanitize(values, "", sources)
+
+	return values, sources, nil
+}
+
+func (c *Config) sanitize(values map[string]interface{}, prefix string, sources map[string]string) {
+	for key, value := range values {
+		path := prefix + key
+
+		if nested, ok := value.(map[string]interface{}); ok {
+			c.sanitize(nested, path+".", sources)
+			continue
+		}
+
+		if isSecret(key) && value != nil && value != "" {
+			values[key] = redacted
+		}
+
+		sources[path] = c.source(path)
+	}
+}
+
+func (c *Config) source(path string) string {
+	if source, ok := c.sources[path]; ok {
+		return source
+	}
+
+	switch {
+	case hasSetting(c.fileValues, path):
+		return SourceFile
+	case hasSetting(c.profileValues, path):
+		return SourceProfile
+	}
+
+	return SourceDefault
+}
+
+// hasSetting reports whether the setting at path is in values
+func hasSetting(values map[string]interface{}, path string) bool {
+	keys := strings.Split(path, ".")
+	for i, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			return false
+		}
+
+		if i == len(keys)-1 {
+			return true
+		}
+
+		if values, ok = value.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+
+	return false
+}
+
+func isSecret(key string) bool {
+	for _, secret := range []string{"secret", "password", "token"} {
+		if key == secret || strings.HasSuffix(key, "_"+secret) {
+			return true
+		}
+	}
+
+	return false
+}
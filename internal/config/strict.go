@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ignoredSettings are accepted by the strict mode without being used, as
+// they're in existing config files for other reasons
+var ignoredSettings = map[string]bool{
+	// Read by GitLab to find the authorized_keys file
+	"auth_file": true,
+	// Obsolete, no longer read by gitlab-shell
+	"audit_usernames": true,
+	// Generated by the config templates of GitLab, host certificates aren't
+	// supported yet
+	"sshd.host_key_certs": true,
+	// The metadata of the files encrypted with sops
+	sopsKey: true,
+}
+
+// unknownSetting is a setting of a config file that doesn't exist
+type unknownSetting struct {
+	line       int
+	path       string
+	suggestion string
+}
+
+func (s unknownSetting) String() string {
+	var b strings.Builder
+	if s.line > 0 {
+		fmt.Fprintf(&b, "line %d: ", s.line)
+	}
+	b.WriteString(s.path)
+	if s.suggestion != "" {
+		fmt.Fprintf(&b, ", did you mean %s?", s.suggestion)
+	}
+
+	return b.String()
+}
+
+// checkUnknownSettings returns an error listing the settings of the config
+// file at path that don't exist. The lines are only known for YAML files,
+// the other formats being converted to YAML.
+func checkUnknownSettings(path string, configBytes []byte) error {
+	var document yaml.Node
+	if err := yaml.Unmarshal(configBytes, &document); err != nil {
+		return err
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+
+	var unknown []unknownSetting
+	findUnknownSettings(document.Content[0], reflect.TypeOf(Config{}), "", &unknown)
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	if ext := filepath.Ext(path); ext != ".yml" && ext != ".yaml" {
+		for i := range unknown {
+			unknown[i].line = 0
+		}
+	}
+
+	lines := make([]string, 0, len(unknown))
+	for _, setting := range unknown {
+		lines = append(lines, "  "+setting.String())
+	}
+
+	return fmt.Errorf("unknown settings in %s:\n%s", path, strings.Join(lines, "\n"))
+}
+
+func findUnknownSettings(node *yaml.Node, t reflect.Type, prefix string, unknown *[]unknownSetting) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			field, ok := fields[key.Value]
+			if !ok && ignoredSettings[prefix+key.Value] {
+				continue
+			}
+			if !ok {
+				*unknown = append(*unknown, unknownSetting{
+					line:       key.Line,
+					path:       prefix + key.Value,
+					suggestion: closestName(key.Value, fields),
+				})
+				continue
+			}
+
+			findUnknownSettings(value, field, prefix+key.Value+".", unknown)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			findUnknownSettings(node.Content[i+1], t.Elem(), prefix+node.Content[i].Value+".", unknown)
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			findUnknownSettings(item, t.Elem(), fmt.Sprintf("%s%d.", prefix, i), unknown)
+		}
+	}
+}
+
+// yamlFields returns the types of the fields of struct t by their names in
+// YAML
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(field.Name)
+		}
+
+		fields[name] = field.Type
+	}
+
+	return fields
+}
+
+// closestName returns the name of fields closest to name when it looks like
+// a typo of it
+func closestName(name string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", len(name)/3+1
+	for candidate := range fields {
+		distance := levenshtein(name, candidate)
+		if distance < bestDistance || (distance == bestDistance && best != "" && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+
+	return best
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if deletion := previous[j] + 1; deletion < current[j] {
+				current[j] = deletion
+			}
+			if insertion := current[j-1] + 1; insertion < current[j] {
+				current[j] = insertion
+			}
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
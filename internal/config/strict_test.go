@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrictConfig(t *testing.T) {
+	dir := t.TempDir()
+	content := `strict_config: true
+secret: s
+sshd:
+  listen: ":2222"
+  proxy_protocl: true
+  session_classes:
+    ci:
+      concurent_sessions_limit: 5
+tenants:
+  - name: b
+    gitlab_ur: http://gitlab-b.example.com
+frobnicate: 3
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte(content), 0o644))
+
+	_, err := NewFromDir(dir)
+	require.EqualError(t, err, "unknown settings in "+filepath.Join(dir, "config.yml")+`:
+  line 5: sshd.proxy_protocl, did you mean proxy_protocol?
+  line 8: sshd.session_classes.ci.concurent_sessions_limit, did you mean concurrent_sessions_limit?
+  line 11: tenants.0.gitlab_ur, did you mean gitlab_url?
+  line 12: frobnicate`)
+
+	// Unknown settings are ignored unless strict
+	nonStrict := content[len("strict_config: true\n"):]
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte(nonStrict), 0o644))
+
+	cfg, err := NewFromDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, ":2222", cfg.Server.Listen)
+}
+
+func TestStrictConfigWithoutLines(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.toml"), []byte("strict_config = true\nsecret = \"s\"\n\n[sshd]\nlisten = \":2222\"\nproxy_protocl = true\n"), 0o644))
+
+	_, err := NewFromDir(dir)
+	require.EqualError(t, err, "unknown settings in "+filepath.Join(dir, "config.toml")+":\n  sshd.proxy_protocl, did you mean proxy_protocol?")
+}
+
+func TestStrictConfigExample(t *testing.T) {
+	dir := t.TempDir()
+	example, err := os.ReadFile("../../config.yml.example")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), example, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".gitlab_shell_secret"), []byte("s"), 0o600))
+
+	cfg, err := NewFromDir(dir)
+	require.NoError(t, err)
+	require.True(t, cfg.StrictConfig)
+}
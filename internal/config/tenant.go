@@ -0,0 +1,168 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TenantConfig is an additional GitLab instance served by gitlab-sshd.
+// Connections for the SSH user followed by UsernameSuffix, git+<name>@host by
+// default, are authenticated and authorized against it.
+type TenantConfig struct {
+	Name                  string `yaml:"name"`
+	UsernameSuffix        string `yaml:"username_suffix"`
+	GitlabUrl             string `yaml:"gitlab_url"`
+	GitlabRelativeURLRoot string `yaml:"gitlab_relative_url_root"`
+	SecretFilePath        string `yaml:"secret_file"`
+	Secret                string `yaml:"secret"`
+	// HttpSettings are the credentials and the CAs of the internal API of
+	// the tenant, the ones of the default instance are never used for it
+	HttpSettings TenantHttpSettingsConfig `yaml:"http_settings,omitempty"`
+	// MaintenanceMode lists the users and keys of the tenant that can still
+	// push during maintenance. The ones of the default instance don't apply,
+	// usernames and key IDs not being unique across instances.
+	MaintenanceMode TenantMaintenanceModeConfig `yaml:"maintenance_mode,omitempty"`
+	// Preauthorization trusts the tokens signed by the tenant, the key of the
+	// default instance is never trusted for it
+	Preauthorization PreauthorizationConfig `yaml:"preauthorization,omitempty"`
+}
+
+type TenantHttpSettingsConfig struct {
+	User     string `yaml:"user,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	CaFile   string `yaml:"ca_file,omitempty"`
+	CaPath   string `yaml:"ca_path,omitempty"`
+}
+
+type TenantMaintenanceModeConfig struct {
+	AllowedUsers  []string `yaml:"allowed_users,omitempty"`
+	AllowedKeyIDs []string `yaml:"allowed_key_ids,omitempty"`
+}
+
+// newTenantConfig reads the config again so that a tenant shares every
+// setting of parent but the GitLab instance it talks to
+func newTenantConfig(parent *Config, configBytes []byte, tenant TenantConfig) (*Config, error) {
+	cfg, err := newDefaults(parent.profile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.RootDir = parent.RootDir
+
+	if err := yaml.Unmarshal(configBytes, cfg); err != nil {
+		return nil, err
+	}
+
+	cfg.User = parent.User + tenant.usernameSuffix()
+	cfg.GitlabRelativeURLRoot = tenant.GitlabRelativeURLRoot
+	cfg.LogFile = parent.LogFile
+	cfg.Tenants = nil
+	cfg.tenantName = tenant.Name
+	cfg.parent = parent
+
+	cfg.GitlabUrl = tenant.GitlabUrl
+	cfg.GitlabUrls = nil
+	if cfg.GitlabUrl != "" {
+		unescapedUrl, err := url.PathUnescape(cfg.GitlabUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.GitlabUrl = unescapedUrl
+	}
+
+	// The credentials of the default instance must never be sent to a
+	// tenant, nor its CAs trusted for it
+	cfg.Secret = tenant.Secret
+	cfg.SecretFilePath = tenant.SecretFilePath
+	if cfg.Secret == "" && cfg.SecretFilePath != "" {
+		if err := parseSecret(cfg); err != nil {
+			return nil, err
+		}
+	}
+	cfg.HttpSettings.User = tenant.HttpSettings.User
+	cfg.HttpSettings.Password = tenant.HttpSettings.Password
+	cfg.HttpSettings.CaFile = tenant.HttpSettings.CaFile
+	cfg.HttpSettings.CaPath = tenant.HttpSettings.CaPath
+	cfg.Preauthorization = tenant.Preauthorization
+
+	cfg.MaintenanceMode.AllowedUsers = tenant.MaintenanceMode.AllowedUsers
+	cfg.MaintenanceMode.AllowedKeyIDs = tenant.MaintenanceMode.AllowedKeyIDs
+
+	if err := parseGitalyProxyURL(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := parseFaultInjection(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (t TenantConfig) usernameSuffix() string {
+	if t.UsernameSuffix != "" {
+		return t.UsernameSuffix
+	}
+
+	return "+" + t.Name
+}
+
+// TenantConfigs returns the configs of the additional GitLab instances
+func (c *Config) TenantConfigs() []*Config {
+	return c.tenants
+}
+
+// Tenant returns the config of the named tenant, or nil if there is none
+func (c *Config) Tenant(name string) *Config {
+	for _, tenant := range c.tenants {
+		if tenant.tenantName == name {
+			return tenant
+		}
+	}
+
+	return nil
+}
+
+// TenantName returns the name of the tenant the config is for, which is
+// empty for the default GitLab instance
+func (c *Config) TenantName() string {
+	return c.tenantName
+}
+
+func (c *Config) tenantsAreSane() error {
+	names := map[string]bool{}
+	suffixes := map[string]bool{}
+
+	for _, tenant := range c.Tenants {
+		if tenant.Name == "" {
+			return errors.New("tenants require a name")
+		}
+		if names[tenant.Name] {
+			return fmt.Errorf("tenant %q is configured more than once", tenant.Name)
+		}
+		names[tenant.Name] = true
+
+		suffix := tenant.usernameSuffix()
+		if suffixes[suffix] {
+			return fmt.Errorf("tenant %q: username_suffix %q is already used", tenant.Name, suffix)
+		}
+		suffixes[suffix] = true
+	}
+
+	for _, tenant := range c.tenants {
+		if tenant.GitlabUrl == "" {
+			return fmt.Errorf("tenant %q: gitlab_url is required", tenant.tenantName)
+		}
+		if tenant.Secret == "" {
+			return fmt.Errorf("tenant %q: secret or secret_file is required", tenant.tenantName)
+		}
+		if err := tenant.Preauthorization.isSane(); err != nil {
+			return fmt.Errorf("tenant %q: %w", tenant.tenantName, err)
+		}
+	}
+
+	return nil
+}
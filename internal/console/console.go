@@ -6,6 +6,11 @@ import (
 	"strings"
 )
 
+const (
+	linePrefix    = "remote: "
+	dividerLength = 72
+)
+
 func DisplayWarningMessage(message string, out io.Writer) {
 	DisplayWarningMessages([]string{message}, out)
 }
@@ -14,6 +19,22 @@ func DisplayInfoMessage(message string, out io.Writer) {
 	DisplayInfoMessages([]string{message}, out)
 }
 
+// DisplayProgressMessage writes a single line, e.g. to tell the user that a
+// long operation is still running
+func DisplayProgressMessage(message string, out io.Writer) {
+	fmt.Fprint(out, formatMessage(out, message, false))
+}
+
+// DisplayReference writes the correlation ID of a failed session, for users
+// to quote when reporting the failure and support to find its logs
+func DisplayReference(correlationID string, out io.Writer) {
+	if correlationID == "" {
+		return
+	}
+
+	fmt.Fprint(out, formatMessage(out, "reference: "+correlationID, false))
+}
+
 func DisplayWarningMessages(messages []string, out io.Writer) {
 	DisplayMessages(messages, out, true)
 }
@@ -30,7 +51,7 @@ func DisplayMessages(messages []string, out io.Writer, displayDivider bool) {
 	displayBlankLineOrDivider(out, displayDivider)
 
 	for _, msg := range messages {
-		fmt.Fprintf(out, formatLine(msg))
+		fmt.Fprint(out, formatMessage(out, msg, displayDivider))
 	}
 
 	displayBlankLineOrDivider(out, displayDivider)
@@ -51,14 +72,34 @@ func noMessages(messages []string) bool {
 }
 
 func formatLine(message string) string {
-	return fmt.Sprintf("remote: %v\n", message)
+	return fmt.Sprintf("%s%v\n", linePrefix, message)
+}
+
+// formatMessage formats message as a line, or as several ones wrapped to the
+// width of the terminal out writes to, warnings being colorized when the
+// terminal does colors
+func formatMessage(out io.Writer, message string, warning bool) string {
+	t, ok := out.(*TerminalWriter)
+	if !ok {
+		return formatLine(message)
+	}
+
+	var b strings.Builder
+	for _, line := range wrap(message, t.Terminal.Width-len(linePrefix)) {
+		if warning && t.Terminal.Color && strings.TrimSpace(line) != "" {
+			line = colorWarning + line + colorReset
+		}
+		b.WriteString(formatLine(line))
+	}
+
+	return b.String()
 }
 
 func displayBlankLineOrDivider(out io.Writer, displayDivider bool) {
 	if displayDivider {
-		fmt.Fprintf(out, divider())
+		fmt.Fprint(out, divider(terminalOf(out).Width))
 	} else {
-		fmt.Fprintf(out, blankLine())
+		fmt.Fprint(out, blankLine())
 	}
 }
 
@@ -66,8 +107,16 @@ func blankLine() string {
 	return formatLine("")
 }
 
-func divider() string {
-	ruler := strings.Repeat("=", 72)
+// divider returns a ruler fitting within width columns, if set
+func divider(width int) string {
+	length := dividerLength
+	if available := width - len(linePrefix); width > 0 && available < length {
+		length = available
+	}
+	if length < 1 {
+		length = 1
+	}
+	ruler := strings.Repeat("=", length)
 
 	return fmt.Sprintf("%v%v%v", blankLine(), formatLine(ruler), blankLine())
 }
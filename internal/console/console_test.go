@@ -187,6 +187,15 @@ func Test_noMessages(t *testing.T) {
 	}
 }
 
+func TestDisplayReference(t *testing.T) {
+	out := &bytes.Buffer{}
+	DisplayReference("", out)
+	require.Empty(t, out.String())
+
+	DisplayReference("01HV2K5X", out)
+	require.Equal(t, "remote: reference: 01HV2K5X\n", out.String())
+}
+
 func Test_formatLine(t *testing.T) {
 	require.Equal(t, "remote: something\n", formatLine("something"))
 }
@@ -197,5 +206,7 @@ remote: ========================================================================
 remote: 
 `
 
-	require.Equal(t, want, divider())
+	require.Equal(t, want, divider(0))
+	require.Equal(t, want, divider(100))
+	require.Equal(t, "remote: \nremote: ============\nremote: \n", divider(20))
 }
@@ -0,0 +1,81 @@
+package console
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	colorWarning = "\x1b[33m"
+	colorReset   = "\x1b[0m"
+)
+
+// Terminal describes the terminal of the user the messages are shown on
+type Terminal struct {
+	// Width is the number of columns the messages are wrapped to, 0 not to
+	// wrap them
+	Width int
+	// Color colorizes the warnings
+	Color bool
+}
+
+// NewTerminal returns the terminal of type term, e.g. xterm-256color, which
+// is width columns wide. Its warnings are colorized when color is set and the
+// terminal does colors.
+func NewTerminal(term string, width int, color bool) Terminal {
+	return Terminal{
+		Width: width,
+		Color: color && term != "" && term != "dumb",
+	}
+}
+
+// TerminalWriter writes to the terminal of the user. The messages displayed
+// to it are formatted for the terminal, while the ones displayed to other
+// writers are left as plain text for scripts.
+type TerminalWriter struct {
+	io.Writer
+	Terminal Terminal
+}
+
+func terminalOf(out io.Writer) Terminal {
+	if t, ok := out.(*TerminalWriter); ok {
+		return t.Terminal
+	}
+
+	return Terminal{}
+}
+
+// wrap splits message into lines at most width runes long, breaking the lines
+// between words. The words longer than width, e.g. URLs, aren't broken.
+func wrap(message string, width int) []string {
+	var lines []string
+	for _, line := range strings.Split(message, "\n") {
+		lines = append(lines, wrapLine(line, width)...)
+	}
+
+	return lines
+}
+
+func wrapLine(line string, width int) []string {
+	if width <= 0 || utf8.RuneCountInString(line) <= width {
+		return []string{line}
+	}
+
+	var lines []string
+	var current string
+	for i, word := range strings.Split(line, " ") {
+		if current != "" && utf8.RuneCountInString(current)+1+utf8.RuneCountInString(word) > width {
+			lines = append(lines, current)
+			current = word
+			continue
+		}
+
+		if i > 0 {
+			current += " "
+		}
+		current += word
+	}
+
+	return append(lines, current)
+}
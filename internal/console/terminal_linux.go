@@ -0,0 +1,19 @@
+package console
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// DetectTerminal returns f as a TerminalWriter of type term when it's a
+// terminal, e.g. the stderr of an OpenSSH session with a pty, or f otherwise
+func DetectTerminal(f *os.File, term string, color bool) io.Writer {
+	size, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return f
+	}
+
+	return &TerminalWriter{Writer: f, Terminal: NewTerminal(term, int(size.Col), color)}
+}
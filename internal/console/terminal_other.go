@@ -0,0 +1,13 @@
+//go:build !linux
+
+package console
+
+import (
+	"io"
+	"os"
+)
+
+// DetectTerminal returns f, terminals are only detected on Linux
+func DetectTerminal(f *os.File, term string, color bool) io.Writer {
+	return f
+}
@@ -0,0 +1,57 @@
+package console
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTerminal(t *testing.T) {
+	require.Equal(t, Terminal{Width: 80, Color: true}, NewTerminal("xterm-256color", 80, true))
+	require.Equal(t, Terminal{Width: 80}, NewTerminal("xterm-256color", 80, false))
+	require.Equal(t, Terminal{Width: 80}, NewTerminal("dumb", 80, true))
+	require.Equal(t, Terminal{Width: 80}, NewTerminal("", 80, true))
+}
+
+func TestDisplayToTerminal(t *testing.T) {
+	message := "Your push has been rejected, because this repository has exceeded its size limit."
+
+	t.Run("wrapped", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		DisplayWarningMessage(message, &TerminalWriter{Writer: out, Terminal: Terminal{Width: 40}})
+
+		require.Equal(t, `remote: 
+remote: ================================
+remote: 
+remote: Your push has been rejected,
+remote: because this repository has
+remote: exceeded its size limit.
+remote: 
+remote: ================================
+remote: 
+`, out.String())
+	})
+
+	t.Run("colorized", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		DisplayWarningMessages([]string{"ERROR: denied", ""}, &TerminalWriter{Writer: out, Terminal: Terminal{Color: true}})
+
+		require.Contains(t, out.String(), "remote: \x1b[33mERROR: denied\x1b[0m\nremote: \n")
+	})
+
+	t.Run("info messages aren't colorized", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		DisplayInfoMessage("info", &TerminalWriter{Writer: out, Terminal: Terminal{Color: true}})
+
+		require.Equal(t, "remote: \nremote: info\nremote: \n", out.String())
+	})
+}
+
+func TestWrap(t *testing.T) {
+	require.Equal(t, []string{"a b c"}, wrap("a b c", 0))
+	require.Equal(t, []string{"a b", "c"}, wrap("a b c", 3))
+	require.Equal(t, []string{"first", "line", "second"}, wrap("first line\nsecond", 6))
+	require.Equal(t, []string{"see", "https://docs.gitlab.com/ee/", "for help"}, wrap("see https://docs.gitlab.com/ee/ for help", 8))
+	require.Equal(t, []string{"ERROR: x", ""}, wrap("ERROR: x\n", 40))
+}
@@ -0,0 +1,122 @@
+// Package errorcode classifies the errors commands fail with, so that wrappers
+// and tests can tell failure modes apart without parsing the messages. The
+// code of a failed command is written as a final "GITLAB-SHELL-ERROR: <code>"
+// line on stderr and selects the exit code of the process.
+package errorcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+)
+
+type Code string
+
+const (
+	AuthFailed     Code = "auth_failed"
+	AccessDenied   Code = "access_denied"
+	APIUnavailable Code = "api_unavailable"
+	Timeout        Code = "timeout"
+	Internal       Code = "internal"
+)
+
+const TrailerPrefix = "GITLAB-SHELL-ERROR: "
+
+var exitCodes = map[Code]int{
+	Internal:       1,
+	AuthFailed:     2,
+	AccessDenied:   3,
+	APIUnavailable: 4,
+	Timeout:        5,
+}
+
+// ExitCode returns the exit code of processes failing with c
+func (c Code) ExitCode() int {
+	if exitCode, ok := exitCodes[c]; ok {
+		return exitCode
+	}
+
+	return exitCodes[Internal]
+}
+
+// Error attaches a code to an error whose cause can't be told from its type
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func New(code Code, message string) error {
+	return &Error{Code: code, Err: errors.New(message)}
+}
+
+func Wrap(code Code, err error) error {
+	return &Error{Code: code, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns the code of err, Internal when nothing more specific is
+// known about it
+func Classify(err error) Code {
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Timeout
+	}
+
+	var apiErr *client.ApiError
+	if errors.As(err, &apiErr) {
+		// The API responding with a message means it denied the request
+		if apiErr.Msg == "Internal API unreachable" || strings.HasPrefix(apiErr.Msg, "Internal API error (5") {
+			return APIUnavailable
+		}
+
+		return AccessDenied
+	}
+
+	if status, ok := grpcstatus.FromError(err); ok {
+		switch status.Code() {
+		case grpccodes.DeadlineExceeded:
+			return Timeout
+		case grpccodes.PermissionDenied:
+			return AccessDenied
+		case grpccodes.Unauthenticated:
+			return AuthFailed
+		}
+	}
+
+	return Internal
+}
+
+// ExitCode returns the exit code of processes failing with err. Errors may
+// refine the exit code of their class by implementing ExitCode() int.
+func ExitCode(err error) int {
+	var withExitCode interface{ ExitCode() int }
+	if errors.As(err, &withExitCode) {
+		return withExitCode.ExitCode()
+	}
+
+	return Classify(err).ExitCode()
+}
+
+// WriteTrailer writes the line identifying the failure, it must be the last
+// line written to stderr
+func WriteTrailer(w io.Writer, code Code) {
+	fmt.Fprintf(w, "%s%s\n", TrailerPrefix, code)
+}
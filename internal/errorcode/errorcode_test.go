@@ -0,0 +1,59 @@
+package errorcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		err      error
+		expected Code
+	}{
+		{desc: "coded error", err: New(AuthFailed, "the key is deny-listed"), expected: AuthFailed},
+		{desc: "wrapped coded error", err: fmt.Errorf("failed: %w", Wrap(Timeout, errors.New("aborted"))), expected: Timeout},
+		{desc: "deadline exceeded", err: fmt.Errorf("request: %w", context.DeadlineExceeded), expected: Timeout},
+		{desc: "unreachable API", err: &client.ApiError{Msg: "Internal API unreachable"}, expected: APIUnavailable},
+		{desc: "API server error", err: &client.ApiError{Msg: "Internal API error (502)"}, expected: APIUnavailable},
+		{desc: "API denial", err: fmt.Errorf("Failed to get username: %w", &client.ApiError{Msg: "Forbidden!"}), expected: AccessDenied},
+		{desc: "Gitaly deadline exceeded", err: grpcstatus.Error(grpccodes.DeadlineExceeded, "deadline"), expected: Timeout},
+		{desc: "Gitaly permission denied", err: grpcstatus.Error(grpccodes.PermissionDenied, "denied"), expected: AccessDenied},
+		{desc: "Gitaly unauthenticated", err: grpcstatus.Error(grpccodes.Unauthenticated, "unauthenticated"), expected: AuthFailed},
+		{desc: "Gitaly internal error", err: grpcstatus.Error(grpccodes.Internal, "internal"), expected: Internal},
+		{desc: "unknown error", err: errors.New("something went wrong"), expected: Internal},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			require.Equal(t, tc.expected, Classify(tc.err))
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	seen := map[int]Code{}
+	for _, code := range []Code{AuthFailed, AccessDenied, APIUnavailable, Timeout, Internal} {
+		exitCode := code.ExitCode()
+		require.NotZero(t, exitCode)
+		require.NotContains(t, seen, exitCode, "%s and %s share an exit code", code, seen[exitCode])
+
+		seen[exitCode] = code
+	}
+
+	require.Equal(t, 1, Code("unknown").ExitCode())
+}
+
+func TestWriteTrailer(t *testing.T) {
+	out := &bytes.Buffer{}
+	WriteTrailer(out, AccessDenied)
+
+	require.Equal(t, "GITLAB-SHELL-ERROR: access_denied\n", out.String())
+}
@@ -0,0 +1,225 @@
+// Package events publishes the lifecycle events of gitlab-sshd, such as
+// sessions starting and ending, to pluggable sinks (a webhook, NATS or Kafka)
+// so that external automation can react to shell activity.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/correlation"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+type Type string
+
+const (
+	SessionStarted  Type = "session_started"
+	SessionEnded    Type = "session_ended"
+	AuthFailed      Type = "auth_failed"
+	ShutdownStarted Type = "shutdown_started"
+)
+
+const (
+	defaultTimeout    = 5 * time.Second
+	defaultMaxRetries = 3
+
+	// queueSize bounds the events waiting to be delivered to each sink, newer
+	// events are dropped when a sink can't keep up
+	queueSize = 1000
+)
+
+type Event struct {
+	Type          Type                   `json:"type"`
+	Time          time.Time              `json:"time"`
+	Hostname      string                 `json:"hostname,omitempty"`
+	CorrelationID string                 `json:"correlation_id,omitempty"`
+	Data          map[string]interface{} `json:"data,omitempty"`
+}
+
+// Sink delivers the JSON encoded events to an external system
+type Sink interface {
+	Name() string
+	Send(event *Event, body []byte) error
+	Close() error
+}
+
+// Publisher delivers events in the background to every sink, in the order
+// they were published. A nil *Publisher is valid and drops every event.
+type Publisher struct {
+	hostname string
+	workers  []*worker
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type worker struct {
+	sink  Sink
+	queue chan *Event
+	done  chan struct{}
+
+	// stop makes the worker drop the pending events
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a Publisher, or nil if no sink is configured. The webhook is
+// sent with transport, whose TLS configuration the NATS and Kafka sinks start
+// from, see config.Config.HTTPTransport.
+func New(cfg config.EventsConfig, transport *http.Transport) *Publisher {
+	var sinks []Sink
+	if cfg.WebhookURL != "" {
+		sinks = append(sinks, newWebhookSink(cfg, transport))
+	}
+	if len(cfg.NATS.Servers) > 0 {
+		sink, err := newNATSSink(cfg, transport.TLSClientConfig)
+		if err != nil {
+			log.WithError(err).Warn("events: failed to configure the NATS sink")
+		} else {
+			sinks = append(sinks, sink)
+		}
+	}
+	if len(cfg.Kafka.Brokers) > 0 {
+		sink, err := newKafkaSink(cfg, transport.TLSClientConfig)
+		if err != nil {
+			log.WithError(err).Warn("events: failed to configure the Kafka sink")
+		} else {
+			sinks = append(sinks, sink)
+		}
+	}
+
+	return NewWithSinks(sinks...)
+}
+
+// NewWithSinks returns a Publisher delivering to the given sinks, or nil if
+// there are none
+func NewWithSinks(sinks ...Sink) *Publisher {
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+
+	p := &Publisher{hostname: hostname}
+	for _, sink := range sinks {
+		w := &worker{
+			sink:  sink,
+			queue: make(chan *Event, queueSize),
+			done:  make(chan struct{}),
+			stop:  make(chan struct{}),
+		}
+		p.workers = append(p.workers, w)
+
+		go w.run()
+	}
+
+	return p
+}
+
+// Publish queues an event without blocking
+func (p *Publisher) Publish(ctx context.Context, eventType Type, data map[string]interface{}) {
+	if p == nil {
+		return
+	}
+
+	event := &Event{
+		Type:          eventType,
+		Time:          time.Now().UTC(),
+		Hostname:      p.hostname,
+		CorrelationID: correlation.ExtractFromContext(ctx),
+		Data:          data,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	for _, w := range p.workers {
+		select {
+		case w.queue <- event:
+		default:
+			logger.WithContextFields(ctx, log.Fields{"event": eventType, "sink": w.sink.Name()}).Warn("events: queue is full, dropping event")
+			metrics.EventsTotal.WithLabelValues(w.sink.Name(), string(eventType), "dropped").Inc()
+		}
+	}
+}
+
+// Close stops accepting events and waits up to timeout for the queued ones to
+// be delivered. The events still pending then are dropped, and each sink is
+// closed once its worker returned.
+func (p *Publisher) Close(timeout time.Duration) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, w := range p.workers {
+			close(w.queue)
+		}
+	}
+	p.mu.Unlock()
+
+	// The workers deliver concurrently, so they share the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, w := range p.workers {
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			log.WithFields(log.Fields{"sink": w.sink.Name(), "pending_events": len(w.queue)}).Warn("events: gave up delivering pending events")
+			w.stopOnce.Do(func() { close(w.stop) })
+		}
+	}
+}
+
+func (w *worker) run() {
+	defer close(w.done)
+	defer w.closeSink()
+
+	for event := range w.queue {
+		select {
+		case <-w.stop:
+			metrics.EventsTotal.WithLabelValues(w.sink.Name(), string(event.Type), "dropped").Inc()
+			continue
+		default:
+		}
+
+		if err := w.deliver(event); err != nil {
+			log.WithFields(log.Fields{"event": event.Type, "sink": w.sink.Name(), "correlation_id": event.CorrelationID}).WithError(err).Warn("events: failed to deliver event")
+			metrics.EventsTotal.WithLabelValues(w.sink.Name(), string(event.Type), "failed").Inc()
+			continue
+		}
+
+		metrics.EventsTotal.WithLabelValues(w.sink.Name(), string(event.Type), "delivered").Inc()
+	}
+}
+
+func (w *worker) closeSink() {
+	if err := w.sink.Close(); err != nil {
+		log.WithFields(log.Fields{"sink": w.sink.Name()}).WithError(err).Warn("events: failed to close sink")
+	}
+}
+
+func (w *worker) deliver(event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return w.sink.Send(event, body)
+}
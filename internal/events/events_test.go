@@ -0,0 +1,189 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/correlation"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestPublish(t *testing.T) {
+	var mu sync.Mutex
+	var received []*Event
+	attempts := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// The first attempt fails to exercise the retries
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		timestamp := r.Header.Get(TimestampHeader)
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now(), time.Unix(unix, 0), SignatureTolerance)
+		require.Equal(t, Sign("secret", timestamp, body), r.Header.Get(SignatureHeader))
+
+		event := &Event{}
+		require.NoError(t, json.Unmarshal(body, event))
+		require.Equal(t, string(event.Type), r.Header.Get(EventHeader))
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	sink := newWebhookSink(config.EventsConfig{WebhookURL: server.URL, Secret: "secret"}, nil)
+	sink.client.RetryWaitMin = time.Millisecond
+	sink.client.RetryWaitMax = time.Millisecond
+	publisher := NewWithSinks(sink)
+
+	ctx := correlation.ContextWithCorrelation(context.Background(), "abc123")
+	publisher.Publish(ctx, SessionStarted, map[string]interface{}{"remote_addr": "127.0.0.1"})
+	publisher.Publish(ctx, SessionEnded, nil)
+	publisher.Close(time.Second)
+
+	// Events published once closed are dropped
+	publisher.Publish(ctx, ShutdownStarted, nil)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, received, 2)
+	require.Equal(t, SessionStarted, received[0].Type)
+	require.Equal(t, "abc123", received[0].CorrelationID)
+	require.Equal(t, map[string]interface{}{"remote_addr": "127.0.0.1"}, received[0].Data)
+	require.NotZero(t, received[0].Time)
+	require.Equal(t, SessionEnded, received[1].Type)
+}
+
+func TestSign(t *testing.T) {
+	require.Equal(t, "sha256=42ac6f0448c1d9c3e1e82b9726248f58fef84afffcbad5188246e96070e0ea46", Sign("secret", "1700000000", []byte("body")))
+
+	// The timestamp is signed along with the body
+	require.NotEqual(t, Sign("secret", "1700000000", []byte("body")), Sign("secret", "1700000001", []byte("body")))
+}
+
+func TestNilPublisher(t *testing.T) {
+	publisher := New(config.EventsConfig{}, nil)
+
+	require.Nil(t, publisher)
+	publisher.Publish(context.Background(), ShutdownStarted, nil)
+	publisher.Close(time.Second)
+}
+
+type blockingSink struct {
+	release chan struct{}
+
+	mu       sync.Mutex
+	sent     int
+	sending  bool
+	closed   bool
+	closedIn bool
+}
+
+func (s *blockingSink) Name() string { return "blocking" }
+
+func (s *blockingSink) Send(*Event, []byte) error {
+	s.mu.Lock()
+	s.sending = true
+	s.mu.Unlock()
+
+	<-s.release
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sending = false
+	s.sent++
+
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.closedIn = s.sending
+
+	return nil
+}
+
+func TestSlowSinkDoesNotBlockOthers(t *testing.T) {
+	var mu sync.Mutex
+	var received []*Event
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &Event{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(event))
+
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	blocking := &blockingSink{release: make(chan struct{})}
+	publisher := NewWithSinks(blocking, newWebhookSink(config.EventsConfig{WebhookURL: server.URL}, nil))
+
+	publisher.Publish(context.Background(), AuthFailed, nil)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 1
+	}, time.Second, time.Millisecond)
+
+	close(blocking.release)
+	publisher.Close(time.Second)
+}
+
+func TestCloseGivesUpOnSlowSinks(t *testing.T) {
+	slow := []*blockingSink{{release: make(chan struct{})}, {release: make(chan struct{})}}
+	publisher := NewWithSinks(slow[0], slow[1])
+
+	for i := 0; i < 3; i++ {
+		publisher.Publish(context.Background(), AuthFailed, nil)
+	}
+
+	start := time.Now()
+	publisher.Close(50 * time.Millisecond)
+	require.Less(t, time.Since(start), time.Second)
+
+	for _, sink := range slow {
+		sink.mu.Lock()
+		require.False(t, sink.closed, "the sink is closed while its worker is still sending")
+		sink.mu.Unlock()
+
+		close(sink.release)
+	}
+
+	for _, sink := range slow {
+		require.Eventually(t, func() bool {
+			sink.mu.Lock()
+			defer sink.mu.Unlock()
+			return sink.closed
+		}, time.Second, time.Millisecond)
+
+		// The events still pending are dropped
+		sink.mu.Lock()
+		require.Equal(t, 1, sink.sent)
+		require.False(t, sink.closedIn)
+		sink.mu.Unlock()
+	}
+}
@@ -0,0 +1,107 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+const defaultKafkaTopic = "gitlab_shell.events"
+
+// kafkaSink produces every event to a Kafka topic, waiting for the leader of
+// the partition to acknowledge it
+type kafkaSink struct {
+	writer   *kafka.Writer
+	hostname string
+}
+
+func newKafkaSink(cfg config.EventsConfig, baseTLSConfig *tls.Config) (*kafkaSink, error) {
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	maxRetries := cfg.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries
+	}
+
+	transport := &kafka.Transport{DialTimeout: timeout, ClientID: "gitlab-sshd"}
+	if cfg.Kafka.TLS.Enabled {
+		tlsConfig, err := newTLSConfig("Kafka", baseTLSConfig, cfg.Kafka.TLS)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.TLS = tlsConfig
+	}
+
+	mechanism, err := newSASLMechanism(cfg.Kafka.SASL)
+	if err != nil {
+		return nil, err
+	}
+	transport.SASL = mechanism
+
+	topic := cfg.Kafka.Topic
+	if topic == "" {
+		topic = defaultKafkaTopic
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &kafkaSink{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Kafka.Brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireOne,
+			MaxAttempts:  maxRetries + 1,
+			// The events are sent one at a time, they aren't batched
+			BatchSize:    1,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+			Transport:    transport,
+		},
+		hostname: hostname,
+	}, nil
+}
+
+func newSASLMechanism(cfg config.EventsSASLConfig) (sasl.Mechanism, error) {
+	switch cfg.Mechanism {
+	case "":
+		return nil, nil
+	case config.SASLMechanismPlain:
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}, nil
+	case config.SASLMechanismSCRAMSHA256:
+		return scram.Mechanism(scram.SHA256, cfg.Username, cfg.Password)
+	case config.SASLMechanismSCRAMSHA512:
+		return scram.Mechanism(scram.SHA512, cfg.Username, cfg.Password)
+	default:
+		return nil, fmt.Errorf("unknown Kafka SASL mechanism %q", cfg.Mechanism)
+	}
+}
+
+func (s *kafkaSink) Name() string {
+	return "kafka"
+}
+
+func (s *kafkaSink) Send(event *Event, body []byte) error {
+	return s.writer.WriteMessages(context.Background(), kafka.Message{
+		Key:     []byte(s.hostname),
+		Value:   body,
+		Headers: []kafka.Header{{Key: EventHeader, Value: []byte(event.Type)}},
+	})
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,76 @@
+package events
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestNewKafkaSink(t *testing.T) {
+	sink, err := newKafkaSink(config.EventsConfig{
+		MaxRetries: 2,
+		Kafka: config.EventsKafkaConfig{
+			Brokers: []string{"kafka-1:9092", "kafka-2:9092"},
+			TLS:     config.EventsTLSConfig{Enabled: true},
+			SASL:    config.EventsSASLConfig{Mechanism: config.SASLMechanismSCRAMSHA256, Username: "user", Password: "password"},
+		},
+	}, nil)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.Equal(t, "kafka", sink.Name())
+	require.Equal(t, defaultKafkaTopic, sink.writer.Topic)
+	require.Equal(t, "kafka-1:9092,kafka-2:9092", sink.writer.Addr.String())
+	require.Equal(t, 3, sink.writer.MaxAttempts)
+	require.Equal(t, defaultTimeout, sink.writer.WriteTimeout)
+
+	transport := sink.writer.Transport.(*kafka.Transport)
+	require.NotNil(t, transport.TLS)
+	require.Equal(t, "SCRAM-SHA-256", transport.SASL.Name())
+}
+
+func TestNewSASLMechanism(t *testing.T) {
+	for _, tc := range []struct {
+		mechanism string
+		expected  string
+	}{
+		{mechanism: config.SASLMechanismPlain, expected: "PLAIN"},
+		{mechanism: config.SASLMechanismSCRAMSHA256, expected: "SCRAM-SHA-256"},
+		{mechanism: config.SASLMechanismSCRAMSHA512, expected: "SCRAM-SHA-512"},
+	} {
+		t.Run(tc.mechanism, func(t *testing.T) {
+			mechanism, err := newSASLMechanism(config.EventsSASLConfig{Mechanism: tc.mechanism, Username: "user", Password: "password"})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, mechanism.Name())
+		})
+	}
+
+	mechanism, err := newSASLMechanism(config.EventsSASLConfig{})
+	require.NoError(t, err)
+	require.Nil(t, mechanism)
+
+	_, err = newSASLMechanism(config.EventsSASLConfig{Mechanism: "gssapi"})
+	require.EqualError(t, err, `unknown Kafka SASL mechanism "gssapi"`)
+}
+
+func TestKafkaSinkUnreachableBroker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	sink, err := newKafkaSink(config.EventsConfig{
+		Timeout:    config.YamlDuration(100 * time.Millisecond),
+		MaxRetries: 1,
+		Kafka:      config.EventsKafkaConfig{Brokers: []string{address}},
+	}, nil)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.Error(t, sink.Send(&Event{Type: AuthFailed}, []byte("{}")))
+}
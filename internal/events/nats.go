@@ -0,0 +1,169 @@
+package events
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+const (
+	defaultNATSSubject = "gitlab_shell.events"
+	natsRetryWait      = 100 * time.Millisecond
+)
+
+// natsSink publishes every event to a NATS subject. Each event is flushed so
+// that it is only reported as delivered once the server has processed it.
+type natsSink struct {
+	servers    string
+	subject    string
+	opts       []nats.Option
+	timeout    time.Duration
+	maxRetries int
+	retryWait  time.Duration
+
+	mu   sync.Mutex
+	conn *nats.Conn
+}
+
+func newNATSSink(cfg config.EventsConfig, baseTLSConfig *tls.Config) (*natsSink, error) {
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	maxRetries := cfg.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries
+	}
+
+	// The client reconnects on its own once connected, the events published
+	// meanwhile being buffered
+	opts := []nats.Option{
+		nats.Name("gitlab-sshd"),
+		nats.Timeout(timeout),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(natsRetryWait),
+	}
+	if cfg.NATS.Username != "" {
+		opts = append(opts, nats.UserInfo(cfg.NATS.Username, cfg.NATS.Password))
+	}
+	if cfg.NATS.Token != "" {
+		opts = append(opts, nats.Token(cfg.NATS.Token))
+	}
+	if cfg.NATS.TLS.Enabled {
+		tlsConfig, err := newTLSConfig("NATS", baseTLSConfig, cfg.NATS.TLS)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+
+	subject := cfg.NATS.Subject
+	if subject == "" {
+		subject = defaultNATSSubject
+	}
+
+	return &natsSink{
+		servers:    strings.Join(cfg.NATS.Servers, ","),
+		subject:    subject,
+		opts:       opts,
+		timeout:    timeout,
+		maxRetries: maxRetries,
+		retryWait:  natsRetryWait,
+	}, nil
+}
+
+// newTLSConfig returns the TLS configuration of the sink named, trusting the
+// CA and presenting the client certificate when set. It starts from base, e.g.
+// for the FIPS restrictions of the HTTP clients to apply.
+func newTLSConfig(name string, base *tls.Config, cfg config.EventsTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base != nil {
+		tlsConfig = base.Clone()
+	}
+
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s CA file: %w", name, err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s CA file %s", name, cfg.CAFile)
+		}
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s client certificate: %w", name, err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (s *natsSink) Name() string {
+	return "nats"
+}
+
+func (s *natsSink) Send(event *Event, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subject := s.subject + "." + string(event.Type)
+
+	var err error
+	for attempt := 0; attempt <= s.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(s.retryWait)
+		}
+
+		if err = s.publish(subject, body); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (s *natsSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return nil
+}
+
+func (s *natsSink) publish(subject string, body []byte) error {
+	if s.conn == nil {
+		conn, err := nats.Connect(s.servers, s.opts...)
+		if err != nil {
+			return err
+		}
+
+		s.conn = conn
+	}
+
+	if err := s.conn.Publish(subject, body); err != nil {
+		return err
+	}
+
+	return s.conn.FlushTimeout(s.timeout)
+}
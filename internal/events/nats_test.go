@@ -0,0 +1,183 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+type natsConnect struct {
+	Name string `json:"name"`
+	User string `json:"user"`
+	Pass string `json:"pass"`
+}
+
+type natsMessage struct {
+	subject string
+	payload []byte
+}
+
+// startNATSServer accepts connections speaking enough of the NATS protocol to
+// receive published messages. The first failConnections connections are
+// closed straight after the handshake.
+func startNATSServer(t *testing.T, failConnections int) (string, <-chan natsConnect, <-chan natsMessage) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	connects := make(chan natsConnect, 10)
+	messages := make(chan natsMessage, 10)
+
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveNATS(conn, i < failConnections, connects, messages)
+		}
+	}()
+
+	return listener.Addr().String(), connects, messages
+}
+
+func serveNATS(conn net.Conn, fail bool, connects chan<- natsConnect, messages chan<- natsMessage) {
+	defer conn.Close()
+
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := readNATSLine(reader)
+		if err != nil {
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			var connect natsConnect
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &connect)
+			connects <- connect
+		case line == "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case strings.HasPrefix(line, "PUB "):
+			if fail {
+				return
+			}
+
+			fields := strings.Fields(line)
+			size, _ := strconv.Atoi(fields[2])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+
+			messages <- natsMessage{subject: fields[1], payload: payload[:size]}
+		}
+	}
+}
+
+func TestNATSSink(t *testing.T) {
+	address, connects, messages := startNATSServer(t, 1)
+
+	sink, err := newNATSSink(config.EventsConfig{
+		NATS: config.EventsNATSConfig{
+			Servers:  []string{address},
+			Subject:  "shell.events",
+			Username: "user",
+			Password: "password",
+		},
+	}, nil)
+	require.NoError(t, err)
+	sink.retryWait = time.Millisecond
+
+	publisher := NewWithSinks(sink)
+	publisher.Publish(context.Background(), SessionStarted, map[string]interface{}{"remote_addr": "127.0.0.1"})
+	publisher.Publish(context.Background(), SessionEnded, nil)
+	publisher.Close(time.Second)
+
+	connect := <-connects
+	require.Equal(t, "user", connect.User)
+	require.Equal(t, "password", connect.Pass)
+	require.Equal(t, "gitlab-sshd", connect.Name)
+
+	// The first connection is dropped before acknowledging the event, which
+	// is then published again on a new connection
+	message := <-messages
+	require.Equal(t, "shell.events.session_started", message.subject)
+
+	event := &Event{}
+	require.NoError(t, json.Unmarshal(message.payload, event))
+	require.Equal(t, SessionStarted, event.Type)
+	require.Equal(t, map[string]interface{}{"remote_addr": "127.0.0.1"}, event.Data)
+
+	message = <-messages
+	require.Equal(t, "shell.events.session_ended", message.subject)
+	require.Len(t, connects, 1)
+}
+
+func TestNATSSinkServerError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			// The credentials are rejected once the CONNECT is received
+			fmt.Fprint(conn, "INFO {}\r\n")
+			readNATSLine(bufio.NewReader(conn))
+			fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+			conn.Close()
+		}
+	}()
+
+	sink, err := newNATSSink(config.EventsConfig{MaxRetries: 1, NATS: config.EventsNATSConfig{Servers: []string{listener.Addr().String()}}}, nil)
+	require.NoError(t, err)
+	sink.retryWait = time.Millisecond
+
+	err = sink.Send(&Event{Type: AuthFailed}, []byte("{}"))
+	require.ErrorIs(t, err, nats.ErrAuthorization)
+	require.NoError(t, sink.Close())
+}
+
+func readNATSLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	base := client.NewTLSConfig("", "", true)
+
+	tlsConfig, err := newTLSConfig("NATS", base, config.EventsTLSConfig{Enabled: true})
+	require.NoError(t, err)
+	require.NotSame(t, base, tlsConfig)
+	require.Equal(t, base.CipherSuites, tlsConfig.CipherSuites)
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MaxVersion)
+
+	_, err = newTLSConfig("NATS", base, config.EventsTLSConfig{Enabled: true, CAFile: "/nonexistent"})
+	require.ErrorContains(t, err, "failed to read NATS CA file")
+}
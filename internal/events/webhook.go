@@ -0,0 +1,97 @@
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+const (
+	SignatureHeader = "X-Gitlab-Shell-Signature"
+	TimestampHeader = "X-Gitlab-Shell-Timestamp"
+	EventHeader     = "X-Gitlab-Shell-Event"
+
+	// SignatureTolerance is how far from their clock receivers should accept
+	// the timestamp of a signed event, rejecting older ones as replays. It
+	// covers the retries of the delivery as well as the clock skew.
+	SignatureTolerance = 5 * time.Minute
+)
+
+// webhookSink POSTs every event to a URL, signed when a secret is configured
+type webhookSink struct {
+	url    string
+	secret string
+	client *retryablehttp.Client
+}
+
+func newWebhookSink(cfg config.EventsConfig, transport http.RoundTripper) *webhookSink {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.HTTPClient.Transport = transport
+	client.RetryMax = cfg.MaxRetries
+	if client.RetryMax <= 0 {
+		client.RetryMax = defaultMaxRetries
+	}
+	client.HTTPClient.Timeout = time.Duration(cfg.Timeout)
+	if client.HTTPClient.Timeout <= 0 {
+		client.HTTPClient.Timeout = defaultTimeout
+	}
+
+	return &webhookSink{url: cfg.WebhookURL, secret: cfg.Secret, client: client}
+}
+
+func (s *webhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *webhookSink) Send(event *Event, body []byte) error {
+	request, err := retryablehttp.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, string(event.Type))
+	if s.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(TimestampHeader, timestamp)
+		request.Header.Set(SignatureHeader, Sign(s.secret, timestamp, body))
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
+
+// Sign returns the signature sent in SignatureHeader, for receivers to verify
+// that events come from gitlab-sshd. It covers the Unix timestamp sent in
+// TimestampHeader, followed by a dot and body, so that a captured event can't
+// be replayed once SignatureTolerance elapsed.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
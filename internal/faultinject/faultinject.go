@@ -0,0 +1,171 @@
+// Package faultinject injects latency and errors into the calls to the
+// internal API and to Gitaly, to test how gitlab-shell copes with degraded
+// dependencies in integration tests and game days. It's only active when
+// explicitly enabled in the configuration.
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	// TargetGitlabnet matches the requests to the internal API by their path
+	TargetGitlabnet = "gitlabnet"
+	// TargetGitaly matches the Gitaly RPCs by their full method name, e.g.
+	// /gitaly.SSHService/SSHUploadPackWithSidechannel
+	TargetGitaly = "gitaly"
+)
+
+// Rule injects a fault into a percentage of the calls of a target
+type Rule struct {
+	Target string
+	// Match is the prefix of the paths or methods of the calls the rule
+	// applies to, all of the target when empty
+	Match string
+	// Percentage is the share of the matching calls the fault is injected
+	// into, from 0 to 100
+	Percentage float64
+	// Latency delays the calls
+	Latency time.Duration
+	// Error fails the calls with this message after the latency, if any
+	Error string
+}
+
+// Injector injects the faults of its rules. A nil *Injector injects nothing.
+type Injector struct {
+	rules []Rule
+	// random returns a number in [0, 100)
+	random func() float64
+}
+
+// New returns an injector of the faults of rules
+func New(rules []Rule) (*Injector, error) {
+	for i, rule := range rules {
+		switch rule.Target {
+		case TargetGitlabnet, TargetGitaly:
+		default:
+			return nil, fmt.Errorf("rule %d: unknown target %q", i, rule.Target)
+		}
+
+		if rule.Percentage <= 0 || rule.Percentage > 100 {
+			return nil, fmt.Errorf("rule %d: the percentage must be above 0 and at most 100", i)
+		}
+
+		if rule.Latency <= 0 && rule.Error == "" {
+			return nil, fmt.Errorf("rule %d: either a latency or an error is required", i)
+		}
+	}
+
+	return &Injector{rules: rules, random: func() float64 { return rand.Float64() * 100 }}, nil
+}
+
+// Inject applies the first rule matching the call of target named name that
+// fires. It returns the error of the rule, or ctx's error when ctx is done
+// during the latency.
+func (i *Injector) Inject(ctx context.Context, target, name string) error {
+	if i == nil {
+		return nil
+	}
+
+	for _, rule := range i.rules {
+		if rule.Target != target || !strings.HasPrefix(name, rule.Match) {
+			continue
+		}
+		if i.random() >= rule.Percentage {
+			continue
+		}
+
+		log.WithContextFields(ctx, log.Fields{
+			"target":     target,
+			"name":       name,
+			"latency_ms": rule.Latency.Milliseconds(),
+			"error":      rule.Error,
+		}).Warn("faultinject: injecting a fault")
+
+		if rule.Latency > 0 {
+			timer := time.NewTimer(rule.Latency)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		if rule.Error != "" {
+			return errors.New(rule.Error)
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+type roundTripper struct {
+	next     http.RoundTripper
+	injector *Injector
+}
+
+// NewRoundTripper injects the faults of the gitlabnet rules into the
+// requests made through next. Injected errors are transport errors, which
+// the HTTP client retries like the ones of an unreachable API.
+func NewRoundTripper(next http.RoundTripper, injector *Injector) http.RoundTripper {
+	if injector == nil {
+		return next
+	}
+
+	return &roundTripper{next: next, injector: injector}
+}
+
+func (rt *roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	if err := rt.injector.Inject(request.Context(), TargetGitlabnet, request.URL.Path); err != nil {
+		return nil, err
+	}
+
+	return rt.next.RoundTrip(request)
+}
+
+// UnaryClientInterceptor injects the faults of the gitaly rules into unary
+// RPCs. Injected errors have the Unavailable code.
+func UnaryClientInterceptor(injector *Injector) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := injector.Inject(ctx, TargetGitaly, method); err != nil {
+			return grpcError(err)
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor injects the faults of the gitaly rules into the
+// start of streaming RPCs. Injected errors have the Unavailable code.
+func StreamClientInterceptor(injector *Injector) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if err := injector.Inject(ctx, TargetGitaly, method); err != nil {
+			return nil, grpcError(err)
+		}
+
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func grpcError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+
+	return status.Error(codes.Unavailable, err.Error())
+}
@@ -0,0 +1,131 @@
+package faultinject
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// newInjector returns an injector of rules whose faults fire when roll is
+// below their percentage
+func newInjector(t *testing.T, roll float64, rules ...Rule) *Injector {
+	injector, err := New(rules)
+	require.NoError(t, err)
+	injector.random = func() float64 { return roll }
+
+	return injector
+}
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		desc string
+		rule Rule
+		err  string
+	}{
+		{desc: "valid", rule: Rule{Target: TargetGitaly, Percentage: 100, Error: "failure"}},
+		{desc: "unknown target", rule: Rule{Target: "redis", Percentage: 10, Error: "failure"}, err: `rule 0: unknown target "redis"`},
+		{desc: "no percentage", rule: Rule{Target: TargetGitlabnet, Error: "failure"}, err: "rule 0: the percentage must be above 0 and at most 100"},
+		{desc: "percentage too high", rule: Rule{Target: TargetGitlabnet, Percentage: 101, Error: "failure"}, err: "rule 0: the percentage must be above 0 and at most 100"},
+		{desc: "no fault", rule: Rule{Target: TargetGitlabnet, Percentage: 10}, err: "rule 0: either a latency or an error is required"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := New([]Rule{tc.rule})
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestInject(t *testing.T) {
+	ctx := context.Background()
+	rules := []Rule{
+		{Target: TargetGitlabnet, Match: "/api/v4/internal/allowed", Percentage: 20, Error: "allowed failure"},
+		{Target: TargetGitlabnet, Percentage: 50, Latency: 10 * time.Millisecond},
+	}
+
+	var injector *Injector
+	require.NoError(t, injector.Inject(ctx, TargetGitlabnet, "/api/v4/internal/allowed"))
+
+	injector = newInjector(t, 10, rules...)
+	require.EqualError(t, injector.Inject(ctx, TargetGitlabnet, "/api/v4/internal/allowed"), "allowed failure")
+	require.NoError(t, injector.Inject(ctx, TargetGitaly, "/api/v4/internal/allowed"))
+
+	// The first rule doesn't fire, the second one delays the call
+	injector = newInjector(t, 30, rules...)
+	start := time.Now()
+	require.NoError(t, injector.Inject(ctx, TargetGitlabnet, "/api/v4/internal/allowed"))
+	require.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+
+	injector = newInjector(t, 50, rules...)
+	require.NoError(t, injector.Inject(ctx, TargetGitlabnet, "/api/v4/internal/allowed"))
+
+	injector = newInjector(t, 0, Rule{Target: TargetGitlabnet, Percentage: 100, Latency: time.Hour})
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, injector.Inject(canceledCtx, TargetGitlabnet, "/"), context.Canceled)
+}
+
+func TestRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	injector := newInjector(t, 0, Rule{Target: TargetGitlabnet, Match: "/api/v4/internal/check", Percentage: 100, Error: "injected failure"})
+	client := &http.Client{Transport: NewRoundTripper(http.DefaultTransport, injector)}
+
+	_, err := client.Get(server.URL + "/api/v4/internal/check")
+	require.ErrorContains(t, err, "injected failure")
+
+	response, err := client.Get(server.URL + "/api/v4/internal/discover")
+	require.NoError(t, err)
+	response.Body.Close()
+
+	require.Equal(t, http.DefaultTransport, NewRoundTripper(http.DefaultTransport, nil))
+}
+
+func TestInterceptors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(l)
+	defer server.Stop()
+
+	injector := newInjector(t, 0, Rule{Target: TargetGitaly, Match: "/grpc.health.v1.Health/Check", Percentage: 100, Error: "injected failure"})
+	conn, err := grpc.Dial(l.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(injector)),
+		grpc.WithStreamInterceptor(StreamClientInterceptor(injector)),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, "injected failure", status.Convert(err).Message())
+
+	injector.rules[0].Match = "/grpc.health.v1.Health/Watch"
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	_, err = client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,51 @@
+package featureflags
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	flags     map[string]bool
+	expiresAt time.Time
+}
+
+type cache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+func newCache() *cache {
+	return &cache{entries: map[string]cacheEntry{}, now: time.Now}
+}
+
+func (c *cache) get(key string) (map[string]bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expiresAt) {
+		return nil, false
+	}
+
+	return entry.flags, true
+}
+
+func (c *cache) set(key string, flags map[string]bool, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	// Drop expired entries so that actors seen once don't stay around forever
+	for k, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = cacheEntry{flags: flags, expiresAt: now.Add(ttl)}
+}
@@ -0,0 +1,154 @@
+// Package featureflags fetches the state of feature flags from the internal
+// API, so that behaviors can be rolled out gradually per actor from GitLab
+// Rails instead of being toggled for the whole installation in the config.
+package featureflags
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+)
+
+const (
+	defaultCacheTTL = time.Minute
+	// failureCacheTTL is how long the defaults are used for an actor once
+	// fetching their flags failed, sparing an unavailable internal API a
+	// request per session
+	failureCacheTTL = 10 * time.Second
+)
+
+// flagsCache is shared by all clients of the process so that a long-running
+// gitlab-sshd doesn't query the flags on every session
+var flagsCache = newCache()
+
+type Client struct {
+	config *config.Config
+	client *client.GitlabNetClient
+}
+
+type Response struct {
+	Flags map[string]bool `json:"feature_flags"`
+}
+
+func NewClient(config *config.Config) (*Client, error) {
+	client, err := gitlabnet.GetClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating http client: %v", err)
+	}
+
+	return &Client{config: config, client: client}, nil
+}
+
+// Enabled reports whether the flag is on for the actor identified by args.
+// The configured default applies when the internal API doesn't know the flag
+// or can't be reached.
+func (c *Client) Enabled(ctx context.Context, args *commandargs.Shell, name string) bool {
+	flags, err := c.Flags(ctx, args)
+	if err != nil {
+		logger.WithContextFields(ctx, log.Fields{"feature_flag": name}).WithError(err).Warn("featureflags: failed to fetch feature flags, using the default")
+	}
+
+	if enabled, ok := flags[name]; ok {
+		return enabled
+	}
+
+	return c.config.FeatureFlags.Defaults[name]
+}
+
+// Flags returns the state of the flags for the actor identified by args. Only
+// the configured defaults are used when fetching flags is disabled, in which
+// case no flags are returned.
+func (c *Client) Flags(ctx context.Context, args *commandargs.Shell) (map[string]bool, error) {
+	if !c.config.FeatureFlags.Enabled {
+		return nil, nil
+	}
+
+	params := actorParams(args)
+	key := c.config.GitlabUrl + "?" + params.Encode()
+
+	if flags, ok := flagsCache.get(key); ok {
+		return flags, nil
+	}
+
+	path := "/feature_flags"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	flags, err := c.fetch(ctx, path)
+	if err != nil {
+		// The caller giving up says nothing about the internal API
+		if ctx.Err() == nil {
+			flagsCache.set(key, nil, c.failureCacheTTL())
+		}
+
+		return nil, err
+	}
+
+	flagsCache.set(key, flags, c.cacheTTL())
+
+	return flags, nil
+}
+
+func (c *Client) fetch(ctx context.Context, path string) (map[string]bool, error) {
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.FeatureFlags)
+	defer cancel()
+
+	response, err := c.client.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	parsed := &Response{}
+	if err := gitlabnet.ParseJSON(response, parsed); err != nil {
+		return nil, err
+	}
+
+	return parsed.Flags, nil
+}
+
+func (c *Client) cacheTTL() time.Duration {
+	if ttl := time.Duration(c.config.FeatureFlags.CacheTTL); ttl > 0 {
+		return ttl
+	}
+
+	return defaultCacheTTL
+}
+
+// failureCacheTTL doesn't keep failures longer than the flags themselves
+func (c *Client) failureCacheTTL() time.Duration {
+	if ttl := c.cacheTTL(); ttl < failureCacheTTL {
+		return ttl
+	}
+
+	return failureCacheTTL
+}
+
+// actorParams identifies the actor flags are evaluated for. Without an actor
+// the internal API returns the global state of the flags.
+func actorParams(args *commandargs.Shell) url.Values {
+	params := url.Values{}
+	if args == nil {
+		return params
+	}
+
+	if args.GitlabUsername != "" {
+		params.Add("username", args.GitlabUsername)
+	} else if args.GitlabKeyId != "" {
+		params.Add("key_id", args.GitlabKeyId)
+	} else if args.GitlabKrb5Principal != "" {
+		params.Add("krb5principal", args.GitlabKrb5Principal)
+	}
+
+	return params
+}
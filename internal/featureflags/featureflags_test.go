@@ -0,0 +1,147 @@
+package featureflags
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func setup(t *testing.T) (string, *int) {
+	var requests int
+
+	handlers := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/feature_flags",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				requests++
+
+				switch r.URL.Query().Get("key_id") {
+				case "1":
+					json.NewEncoder(w).Encode(map[string]interface{}{"feature_flags": map[string]bool{"new_command": true, "sidechannel": false}})
+				case "forbidden":
+					w.WriteHeader(http.StatusForbidden)
+				default:
+					json.NewEncoder(w).Encode(map[string]interface{}{"feature_flags": map[string]bool{"new_command": false}})
+				}
+			},
+		},
+	}
+
+	t.Cleanup(func() { flagsCache = newCache() })
+
+	return testserver.StartSocketHttpServer(t, handlers), &requests
+}
+
+func newClient(t *testing.T, url string, flagsConfig config.FeatureFlagsConfig) *Client {
+	client, err := NewClient(&config.Config{GitlabUrl: url, FeatureFlags: flagsConfig})
+	require.NoError(t, err)
+
+	return client
+}
+
+func TestEnabled(t *testing.T) {
+	url, _ := setup(t)
+	client := newClient(t, url, config.FeatureFlagsConfig{
+		Enabled:  true,
+		Defaults: map[string]bool{"sidechannel": true, "keyboard_interactive_2fa": true},
+	})
+
+	testCases := []struct {
+		desc     string
+		args     *commandargs.Shell
+		flag     string
+		expected bool
+	}{
+		{
+			desc:     "Enabled for the actor",
+			args:     &commandargs.Shell{GitlabKeyId: "1"},
+			flag:     "new_command",
+			expected: true,
+		},
+		{
+			desc:     "Disabled for the actor despite the default",
+			args:     &commandargs.Shell{GitlabKeyId: "1"},
+			flag:     "sidechannel",
+			expected: false,
+		},
+		{
+			desc:     "Unknown to the API",
+			args:     &commandargs.Shell{GitlabKeyId: "1"},
+			flag:     "keyboard_interactive_2fa",
+			expected: true,
+		},
+		{
+			desc:     "Without an actor",
+			args:     &commandargs.Shell{},
+			flag:     "new_command",
+			expected: false,
+		},
+		{
+			desc:     "When the API fails",
+			args:     &commandargs.Shell{GitlabKeyId: "forbidden"},
+			flag:     "sidechannel",
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			require.Equal(t, tc.expected, client.Enabled(context.Background(), tc.args, tc.flag))
+		})
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	url, requests := setup(t)
+	client := newClient(t, url, config.FeatureFlagsConfig{Defaults: map[string]bool{"new_command": true}})
+
+	require.True(t, client.Enabled(context.Background(), &commandargs.Shell{GitlabKeyId: "2"}, "new_command"))
+	require.Zero(t, *requests)
+}
+
+func TestFlagsAreCached(t *testing.T) {
+	url, requests := setup(t)
+	client := newClient(t, url, config.FeatureFlagsConfig{Enabled: true, CacheTTL: config.YamlDuration(time.Minute)})
+
+	now := time.Now()
+	flagsCache.now = func() time.Time { return now }
+
+	args := &commandargs.Shell{GitlabKeyId: "1"}
+	for i := 0; i < 3; i++ {
+		require.True(t, client.Enabled(context.Background(), args, "new_command"))
+	}
+	require.Equal(t, 1, *requests)
+
+	// Other actors are cached separately
+	require.False(t, client.Enabled(context.Background(), &commandargs.Shell{GitlabKeyId: "2"}, "new_command"))
+	require.Equal(t, 2, *requests)
+
+	now = now.Add(time.Minute)
+	require.True(t, client.Enabled(context.Background(), args, "new_command"))
+	require.Equal(t, 3, *requests)
+
+	// Failures are cached briefly, the defaults being used meanwhile
+	forbidden := &commandargs.Shell{GitlabKeyId: "forbidden"}
+	require.False(t, client.Enabled(context.Background(), forbidden, "new_command"))
+	require.False(t, client.Enabled(context.Background(), forbidden, "new_command"))
+	require.Equal(t, 4, *requests)
+
+	now = now.Add(failureCacheTTL)
+	client.Enabled(context.Background(), forbidden, "new_command")
+	require.Equal(t, 5, *requests)
+
+	// Unless the caller gave up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client.Enabled(ctx, &commandargs.Shell{GitlabKeyId: "3"}, "new_command")
+	client.Enabled(context.Background(), &commandargs.Shell{GitlabKeyId: "3"}, "new_command")
+	require.Equal(t, 6, *requests)
+}
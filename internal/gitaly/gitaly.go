@@ -3,10 +3,15 @@ package gitaly
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/url"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	gitalyauth "gitlab.com/gitlab-org/gitaly/v16/auth"
 	"gitlab.com/gitlab-org/gitaly/v16/client"
@@ -16,23 +21,64 @@ import (
 	"gitlab.com/gitlab-org/labkit/log"
 	grpctracing "gitlab.com/gitlab-org/labkit/tracing/grpc"
 
+	shellclient "gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/faultinject"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logcontext"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 )
 
+// clientNameMetadataKey matches the key used by LabKit's correlation interceptors.
+const clientNameMetadataKey = "x-gitlab-client-name"
+
 type Command struct {
 	ServiceName string
 	Address     string
 	Token       string
 }
 
+// connectionKey identifies a pooled connection. Connections are shared by
+// every command talking to the same Gitaly with the same credentials.
+type connectionKey struct {
+	address string
+	token   string
+}
+
+// pooledConnection counts its users atomically, so that a cached connection
+// can be acquired and released under the read lock. The reaper holds the
+// write lock.
+type pooledConnection struct {
+	conn  *grpc.ClientConn
+	inUse atomic.Int64
+	// lastUsed is in Unix nanoseconds
+	lastUsed atomic.Int64
+}
+
+func (pc *pooledConnection) acquire() {
+	pc.inUse.Add(1)
+	pc.lastUsed.Store(time.Now().UnixNano())
+}
+
+func (pc *pooledConnection) release() {
+	pc.inUse.Add(-1)
+	pc.lastUsed.Store(time.Now().UnixNano())
+}
+
 type connectionsCache struct {
 	sync.RWMutex
-
-	connections map[Command]*grpc.ClientConn
+	connections map[connectionKey]*pooledConnection
 }
 
+type serviceNameKey struct{}
+
 type Client struct {
 	SidechannelRegistry *gitalyclient.SidechannelRegistry
+	// ProxyURL is the proxy connections to Gitaly are made through. When it's
+	// nil, gRPC uses the proxy of the environment (HTTPS_PROXY and NO_PROXY).
+	// It isn't used for UNIX sockets.
+	ProxyURL *url.URL
+	// FaultInjector injects faults into the RPCs for resilience testing. It's
+	// nil unless fault injection is enabled.
+	FaultInjector *faultinject.Injector
 
 	cache connectionsCache
 }
@@ -41,34 +87,122 @@ func (c *Client) InitSidechannelRegistry(ctx context.Context) {
 	c.SidechannelRegistry = gitalyclient.NewSidechannelRegistry(log.ContextLogger(ctx))
 }
 
+// ContextWithServiceName returns a context that makes calls on a pooled
+// connection report the given service name to Gitaly.
+func ContextWithServiceName(ctx context.Context, serviceName string) context.Context {
+	return context.WithValue(ctx, serviceNameKey{}, serviceName)
+}
+
+// GetConnection returns a pooled connection for cmd, dialing a new one when
+// none exists yet. Callers holding on to the connection for a long-running call
+// should use AcquireConnection so the connection is not reaped while in use.
 func (c *Client) GetConnection(ctx context.Context, cmd Command) (*grpc.ClientConn, error) {
+	conn, release, err := c.AcquireConnection(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	release()
+
+	return conn, nil
+}
+
+// AcquireConnection is like GetConnection but marks the connection as in use
+// until the returned release function is called.
+func (c *Client) AcquireConnection(ctx context.Context, cmd Command) (*grpc.ClientConn, func(), error) {
+	key := connectionKey{address: cmd.Address, token: cmd.Token}
+
 	c.cache.RLock()
-	conn := c.cache.connections[cmd]
+	pc := c.cache.connections[key]
+	if pc != nil {
+		pc.acquire()
+	}
 	c.cache.RUnlock()
 
-	if conn != nil {
-		return conn, nil
+	if pc != nil {
+		metrics.GitalyConnectionCacheTotal.WithLabelValues("hit").Inc()
+
+		return pc.conn, c.releaseFunc(pc), nil
 	}
 
 	c.cache.Lock()
 	defer c.cache.Unlock()
 
-	if conn := c.cache.connections[cmd]; conn != nil {
-		return conn, nil
+	pc = c.cache.connections[key]
+	if pc != nil {
+		metrics.GitalyConnectionCacheTotal.WithLabelValues("hit").Inc()
+	} else {
+		metrics.GitalyConnectionCacheTotal.WithLabelValues("miss").Inc()
+
+		conn, err := c.newConnection(ctx, cmd)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if c.cache.connections == nil {
+			c.cache.connections = make(map[connectionKey]*pooledConnection)
+		}
+
+		pc = &pooledConnection{conn: conn}
+		c.cache.connections[key] = pc
 	}
 
-	conn, err := c.newConnection(ctx, cmd)
-	if err != nil {
-		return nil, err
+	pc.acquire()
+
+	return pc.conn, c.releaseFunc(pc), nil
+}
+
+// releaseFunc returns a function releasing pc once, however many times it's
+// called. The read lock keeps the reaper away while pc is released.
+func (c *Client) releaseFunc(pc *pooledConnection) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			c.cache.RLock()
+			defer c.cache.RUnlock()
+
+			pc.release()
+		})
 	}
+}
 
-	if c.cache.connections == nil {
-		c.cache.connections = make(map[Command]*grpc.ClientConn)
+// StartReaper periodically closes pooled connections that have not been used
+// for longer than idleTimeout. It returns when ctx is done. A zero idleTimeout
+// disables reaping.
+func (c *Client) StartReaper(ctx context.Context, idleTimeout time.Duration) {
+	if idleTimeout <= 0 {
+		return
 	}
 
-	c.cache.connections[cmd] = conn
+	ticker := time.NewTicker(idleTimeout / 2)
+	defer ticker.Stop()
 
-	return conn, nil
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.reapIdle(ctx, idleTimeout)
+		}
+	}
+}
+
+func (c *Client) reapIdle(ctx context.Context, idleTimeout time.Duration) {
+	c.cache.Lock()
+	defer c.cache.Unlock()
+
+	for key, pc := range c.cache.connections {
+		if pc.inUse.Load() > 0 || time.Since(time.Unix(0, pc.lastUsed.Load())) < idleTimeout {
+			continue
+		}
+
+		delete(c.cache.connections, key)
+		metrics.GitalyConnectionsReapedTotal.WithLabelValues("idle").Inc()
+
+		if err := pc.conn.Close(); err != nil {
+			logcontext.WithContextFields(ctx, log.Fields{"gitaly_address": key.address}).WithError(err).Warn("Failed to close idle Gitaly connection")
+		}
+	}
 }
 
 func (c *Client) newConnection(ctx context.Context, cmd Command) (conn *grpc.ClientConn, err error) {
@@ -84,32 +218,29 @@ func (c *Client) newConnection(ctx context.Context, cmd Command) (conn *grpc.Cli
 		return nil, fmt.Errorf("no gitaly_address given")
 	}
 
-	serviceName := correlation.ExtractClientNameFromContext(ctx)
-	if serviceName == "" {
-		serviceName = "gitlab-shell-unknown"
-
-		log.WithContextFields(ctx, log.Fields{"service_name": serviceName}).Warn("No gRPC service name specified, defaulting to gitlab-shell-unknown")
+	// The client name is reported by each RPC, but warning once per
+	// connection is enough
+	if correlation.ExtractClientNameFromContext(ctx) == "" {
+		logcontext.WithContextFields(ctx, log.Fields{"service_name": "gitlab-shell-unknown"}).Warn("No gRPC service name specified, defaulting to gitlab-shell-unknown")
 	}
 
-	serviceName = fmt.Sprintf("%s-%s", serviceName, cmd.ServiceName)
-
 	connOpts := client.DefaultDialOpts
 	connOpts = append(
 		connOpts,
 		grpc.WithChainStreamInterceptor(
 			grpctracing.StreamClientTracingInterceptor(),
 			grpc_prometheus.StreamClientInterceptor,
-			grpccorrelation.StreamClientCorrelationInterceptor(
-				grpccorrelation.WithClientName(serviceName),
-			),
+			grpccorrelation.StreamClientCorrelationInterceptor(),
+			streamClientNameInterceptor(cmd.ServiceName),
+			faultinject.StreamClientInterceptor(c.FaultInjector),
 		),
 
 		grpc.WithChainUnaryInterceptor(
 			grpctracing.UnaryClientTracingInterceptor(),
 			grpc_prometheus.UnaryClientInterceptor,
-			grpccorrelation.UnaryClientCorrelationInterceptor(
-				grpccorrelation.WithClientName(serviceName),
-			),
+			grpccorrelation.UnaryClientCorrelationInterceptor(),
+			unaryClientNameInterceptor(cmd.ServiceName),
+			faultinject.UnaryClientInterceptor(c.FaultInjector),
 		),
 
 		// In https://gitlab.com/groups/gitlab-org/-/epics/8971, we added DNS discovery support to Praefect. This was
@@ -129,5 +260,45 @@ func (c *Client) newConnection(ctx context.Context, cmd Command) (conn *grpc.Cli
 		)
 	}
 
+	if c.ProxyURL != nil {
+		dialer := shellclient.NewProxyDialer(c.ProxyURL)
+		connOpts = append(connOpts,
+			grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "tcp", addr)
+			}),
+		)
+	}
+
 	return client.DialSidechannel(ctx, cmd.Address, c.SidechannelRegistry, connOpts)
 }
+
+// clientName builds the client name reported to Gitaly. As connections are
+// shared between commands, it is derived from the context of each call rather
+// than fixed when dialing.
+func clientName(ctx context.Context, defaultServiceName string) string {
+	name := correlation.ExtractClientNameFromContext(ctx)
+	if name == "" {
+		name = "gitlab-shell-unknown"
+	}
+
+	serviceName, ok := ctx.Value(serviceNameKey{}).(string)
+	if !ok || serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	return fmt.Sprintf("%s-%s", name, serviceName)
+}
+
+func unaryClientNameInterceptor(defaultServiceName string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, clientNameMetadataKey, clientName(ctx, defaultServiceName))
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func streamClientNameInterceptor(defaultServiceName string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx = metadata.AppendToOutgoingContext(ctx, clientNameMetadataKey, clientName(ctx, defaultServiceName))
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
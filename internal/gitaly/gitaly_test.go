@@ -3,6 +3,7 @@ package gitaly
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
@@ -52,8 +53,82 @@ func TestCachedConnections(t *testing.T) {
 	require.Len(t, c.cache.connections, 2)
 }
 
+func TestCachedConnectionsOnlyTakeTheReadLock(t *testing.T) {
+	c := newClient()
+
+	cmd := Command{ServiceName: "git-upload-pack", Address: "tcp://localhost:9999"}
+	conn, err := c.GetConnection(context.Background(), cmd)
+	require.NoError(t, err)
+
+	c.cache.RLock()
+	defer c.cache.RUnlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		sameConn, release, err := c.AcquireConnection(context.Background(), cmd)
+		require.NoError(t, err)
+		require.Equal(t, conn, sameConn)
+		release()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("acquiring a cached connection waited for the write lock")
+	}
+}
+
 func newClient() *Client {
 	c := &Client{}
 	c.InitSidechannelRegistry(context.Background())
 	return c
 }
+
+func TestPooledConnectionsAreSharedBetweenServices(t *testing.T) {
+	c := newClient()
+
+	conn, err := c.GetConnection(context.Background(), Command{ServiceName: "git-upload-pack", Address: "tcp://localhost:9999", Token: "token"})
+	require.NoError(t, err)
+
+	sameConn, err := c.GetConnection(context.Background(), Command{ServiceName: "git-receive-pack", Address: "tcp://localhost:9999", Token: "token"})
+	require.NoError(t, err)
+	require.Equal(t, conn, sameConn)
+	require.Len(t, c.cache.connections, 1)
+
+	otherConn, err := c.GetConnection(context.Background(), Command{ServiceName: "git-upload-pack", Address: "tcp://localhost:9999", Token: "other"})
+	require.NoError(t, err)
+	require.NotEqual(t, conn, otherConn)
+	require.Len(t, c.cache.connections, 2)
+}
+
+func TestReapIdleConnections(t *testing.T) {
+	metrics.GitalyConnectionsReapedTotal.Reset()
+
+	c := newClient()
+
+	idleCmd := Command{ServiceName: "git-upload-pack", Address: "tcp://localhost:9999"}
+	_, err := c.GetConnection(context.Background(), idleCmd)
+	require.NoError(t, err)
+
+	busyCmd := Command{ServiceName: "git-upload-pack", Address: "tcp://localhost:9998"}
+	_, release, err := c.AcquireConnection(context.Background(), busyCmd)
+	require.NoError(t, err)
+
+	for _, pc := range c.cache.connections {
+		pc.lastUsed.Store(time.Now().Add(-time.Hour).UnixNano())
+	}
+
+	c.reapIdle(context.Background(), time.Minute)
+
+	require.Len(t, c.cache.connections, 1)
+	require.Contains(t, c.cache.connections, connectionKey{address: busyCmd.Address})
+	require.InDelta(t, 1, testutil.ToFloat64(metrics.GitalyConnectionsReapedTotal.WithLabelValues("idle")), 0.1)
+
+	release()
+	c.cache.connections[connectionKey{address: busyCmd.Address}].lastUsed.Store(time.Now().Add(-time.Hour).UnixNano())
+	c.reapIdle(context.Background(), time.Minute)
+
+	require.Empty(t, c.cache.connections)
+}
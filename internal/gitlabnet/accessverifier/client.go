@@ -1,15 +1,24 @@
 package accessverifier
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 
 	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/accesscache"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bandwidth"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+
+	"gitlab.com/gitlab-org/labkit/log"
 )
 
 const (
@@ -18,7 +27,10 @@ const (
 )
 
 type Client struct {
-	client *client.GitlabNetClient
+	client  *client.GitlabNetClient
+	cache   *accesscache.Cache
+	preauth *preauthorizer
+	timeout config.YamlDuration
 }
 
 type Request struct {
@@ -75,6 +87,25 @@ type Response struct {
 	StatusCode       int
 	// NeedAudit indicates whether git event should be audited to rails.
 	NeedAudit bool `json:"need_audit"`
+	// BandwidthLimits overrides the configured per-user bandwidth limits.
+	BandwidthLimits *bandwidth.Limits `json:"bandwidth_limits,omitempty"`
+	// LimitExceeded explains a denial caused by a size limit or a plan restriction.
+	LimitExceeded *LimitExceeded `json:"limit_exceeded,omitempty"`
+	// BundleURIs are the bundles of the project advertised to the fetches
+	// using Git protocol v2, rather than the configured ones.
+	BundleURIs []string `json:"bundle_uris,omitempty"`
+}
+
+const (
+	RepositorySizeLimit   = "repository_size"
+	NamespaceStorageLimit = "namespace_storage"
+	PlanRestriction       = "plan"
+)
+
+type LimitExceeded struct {
+	Type    string   `json:"type"`
+	Details []string `json:"details"`
+	DocsURL string   `json:"docs_url"`
 }
 
 func NewClient(config *config.Config) (*Client, error) {
@@ -82,8 +113,14 @@ func NewClient(config *config.Config) (*Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error creating http client: %v", err)
 	}
+	client.AcceptV2()
 
-	return &Client{client: client}, nil
+	preauth, err := newPreauthorizer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{client: client, cache: config.AccessCheckCache(), preauth: preauth, timeout: config.APITimeouts.Allowed}, nil
 }
 
 func (c *Client) Verify(ctx context.Context, args *commandargs.Shell, action commandargs.CommandType, repo string) (*Response, error) {
@@ -105,30 +142,103 @@ func (c *Client) Verify(ctx context.Context, args *commandargs.Shell, action com
 
 	request.CheckIp = gitlabnet.ParseIP(args.Env.RemoteAddr)
 
+	key := cacheKey(request)
+
+	if token := args.Env.PreauthToken; token != "" && c.preauth != nil {
+		response, err := c.preauth.verify(token, action, repo, key.Who, request.CheckIp)
+		if err == nil {
+			metrics.PreauthorizationsTotal.WithLabelValues("accepted").Inc()
+			return withWho(response, args, http.StatusOK), nil
+		}
+
+		metrics.PreauthorizationsTotal.WithLabelValues("rejected").Inc()
+		logger.WithContextFields(ctx, log.Fields{"gl_project_path": repo}).WithError(err).Warn("Pre-authorization token rejected, checking the access through the internal API")
+	}
+
+	// Only fetches are cached, pushes are always checked
+	cacheable := action == commandargs.UploadPack
+	if cacheable {
+		if body, ok := c.cache.Get(key); ok {
+			return parse(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(body))}, args)
+		}
+	}
+
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
 	response, err := c.client.Post(ctx, "/allowed", request)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
-	return parse(response, args)
+	if !cacheable || c.cache == nil {
+		return parse(response, args)
+	}
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, gitlabnet.ParsingError
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	parsed, err := parse(response, args)
+	if err == nil && parsed.Success && parsed.StatusCode == http.StatusOK {
+		// The cached responses are parsed with the version 1 schema
+		if client.IsV2Response(response) {
+			body, err = json.Marshal(parsed)
+		}
+		if err == nil {
+			c.cache.Add(key, body)
+		}
+	}
+
+	return parsed, err
+}
+
+func cacheKey(request *Request) accesscache.Key {
+	key := accesscache.Key{
+		Action:        string(request.Action),
+		Repo:          request.Repo,
+		CheckIP:       request.CheckIp,
+		NamespacePath: request.NamespacePath,
+	}
+
+	switch {
+	case request.Username != "":
+		key.Who = "user-" + request.Username
+	case request.Krb5Principal != "":
+		key.Who = "krb5-" + request.Krb5Principal
+	default:
+		key.Who = "key-" + request.KeyId
+	}
+
+	return key
 }
 
 func parse(hr *http.Response, args *commandargs.Shell) (*Response, error) {
+	if client.IsV2Response(hr) {
+		return parseV2(hr, args)
+	}
+
 	response := &Response{}
 	if err := gitlabnet.ParseJSON(hr, response); err != nil {
 		return nil, err
 	}
 
+	return withWho(response, args, hr.StatusCode), nil
+}
+
+func withWho(response *Response, args *commandargs.Shell, statusCode int) *Response {
 	if args.GitlabKeyId != "" {
 		response.Who = "key-" + args.GitlabKeyId
 	} else {
 		response.Who = response.UserId
 	}
 
-	response.StatusCode = hr.StatusCode
+	response.StatusCode = statusCode
 
-	return response, nil
+	return response
 }
 
 func (r *Response) IsCustomAction() bool {
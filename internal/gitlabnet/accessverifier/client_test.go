@@ -8,9 +8,11 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
@@ -239,6 +241,103 @@ func TestCheckIP(t *testing.T) {
 	}
 }
 
+func TestAccessCache(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+	allowed := responseBody(t, testRoot, "allowed.json")
+
+	var calls int
+	status := http.StatusOK
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/allowed",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					w.Write(allowed)
+				} else {
+					w.Write([]byte(`{"status": false, "message": "denied"}`))
+				}
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		GitlabUrl:   testserver.StartSocketHttpServer(t, requests),
+		AccessCache: config.AccessCacheConfig{TTL: config.YamlDuration(time.Minute)},
+	}
+	client, err := NewClient(cfg)
+	require.NoError(t, err)
+
+	args := &commandargs.Shell{GitlabKeyId: "1"}
+
+	for i := 0; i < 3; i++ {
+		result, err := client.Verify(context.Background(), args, uploadPackAction, repo)
+		require.NoError(t, err)
+		require.Equal(t, buildExpectedResponse("key-1"), result)
+	}
+	require.Equal(t, 1, calls)
+
+	// Pushes are always checked
+	for i := 0; i < 2; i++ {
+		_, err := client.Verify(context.Background(), args, receivePackAction, repo)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, calls)
+
+	// Other users and projects are checked separately
+	_, err = client.Verify(context.Background(), &commandargs.Shell{GitlabKeyId: "2"}, uploadPackAction, repo)
+	require.NoError(t, err)
+	require.Equal(t, 4, calls)
+
+	// Denials aren't cached
+	require.Equal(t, 1, cfg.AccessCheckCache().Invalidate(repo, "key-1"))
+	status = http.StatusForbidden
+	for i := 0; i < 2; i++ {
+		_, err := client.Verify(context.Background(), args, uploadPackAction, repo)
+		require.Error(t, err)
+	}
+	require.Equal(t, 6, calls)
+}
+
+func TestV2Responses(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+	allowed := responseBody(t, testRoot, "allowed_v2.json")
+
+	var calls int
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/allowed",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				require.Contains(t, r.Header.Get("Accept"), client.MediaTypeV2)
+
+				w.Header().Set("Content-Type", client.MediaTypeV2)
+				w.Write(allowed)
+			},
+		},
+	}
+
+	cfg := &config.Config{
+		GitlabUrl:   testserver.StartSocketHttpServer(t, requests),
+		AccessCache: config.AccessCacheConfig{TTL: config.YamlDuration(time.Minute)},
+	}
+	c, err := NewClient(cfg)
+	require.NoError(t, err)
+
+	expected := buildExpectedResponse("key-1")
+	expected.KeyType = "key"
+	expected.BundleURIs = []string{"https://bundles.example.com/project-26.bundle"}
+
+	// The cached response is parsed the same
+	for i := 0; i < 2; i++ {
+		result, err := c.Verify(context.Background(), &commandargs.Shell{GitlabKeyId: "1"}, uploadPackAction, repo)
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+	}
+	require.Equal(t, 1, calls)
+}
+
 type testResponse struct {
 	body   []byte
 	status int
@@ -0,0 +1,188 @@
+package accessverifier
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+const (
+	preauthIssuer        = "gitlab"
+	preauthAudience      = "gitlab-shell"
+	defaultPreauthMaxTTL = 5 * time.Minute
+)
+
+// preauthClaims are issued by GitLab to allow a fetch from the client IP
+// without checking the access through the internal API. They only carry
+// what's needed to reach the repository on Gitaly.
+type preauthClaims struct {
+	jwt.RegisteredClaims
+	Project  string `json:"project"`
+	Action   string `json:"action"`
+	RemoteIP string `json:"ip"`
+
+	Repo           string `json:"gl_repository"`
+	UserId         string `json:"gl_id"`
+	Username       string `json:"gl_username"`
+	GitalyAddress  string `json:"gitaly_address"`
+	GitalyStorage  string `json:"gitaly_storage"`
+	GitalyRepoPath string `json:"gitaly_relative_path"`
+}
+
+// preauthorizer validates the tokens pre-authorizing fetches. A nil
+// *preauthorizer rejects every token.
+type preauthorizer struct {
+	publicKey   ed25519.PublicKey
+	maxTTL      time.Duration
+	gitalyToken string
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+func newPreauthorizer(cfg *config.Config) (*preauthorizer, error) {
+	if !cfg.Preauthorization.Enabled {
+		return nil, nil
+	}
+
+	publicKey, err := readPreauthPublicKey(preauthPath(cfg, cfg.Preauthorization.PublicKeyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	gitalyToken, err := os.ReadFile(preauthPath(cfg, cfg.Preauthorization.GitalyTokenFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the pre-authorization Gitaly token: %w", err)
+	}
+
+	maxTTL := time.Duration(cfg.Preauthorization.MaxTTL)
+	if maxTTL <= 0 {
+		maxTTL = defaultPreauthMaxTTL
+	}
+
+	return &preauthorizer{
+		publicKey:   publicKey,
+		maxTTL:      maxTTL,
+		gitalyToken: strings.TrimSpace(string(gitalyToken)),
+		now:         time.Now,
+	}, nil
+}
+
+func preauthPath(cfg *config.Config, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(cfg.RootDir, path)
+}
+
+// readPreauthPublicKey reads the PEM encoded Ed25519 public key of the key
+// GitLab signs the tokens with, which is dedicated to them
+func readPreauthPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the pre-authorization public key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the pre-authorization public key: %w", err)
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("the pre-authorization public key is a %T, not an Ed25519 key", key)
+	}
+
+	return publicKey, nil
+}
+
+// verify returns the response built from token if it allows action on repo
+// for who, connected from remoteIP
+func (p *preauthorizer) verify(token string, action commandargs.CommandType, repo, who, remoteIP string) (*Response, error) {
+	if p == nil {
+		return nil, errors.New("pre-authorization is disabled")
+	}
+
+	// Pushes are always checked through the internal API
+	if action != commandargs.UploadPack {
+		return nil, fmt.Errorf("%s can't be pre-authorized", action)
+	}
+
+	claims := &preauthClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return p.publicKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(preauthIssuer),
+		jwt.WithAudience(preauthAudience),
+		jwt.WithSubject(who),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(p.now),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.IssuedAt == nil || claims.ExpiresAt.Sub(claims.IssuedAt.Time) > p.maxTTL {
+		return nil, fmt.Errorf("token lifetime exceeds %s", p.maxTTL)
+	}
+	if claims.Action != string(action) {
+		return nil, fmt.Errorf("token is for %q", claims.Action)
+	}
+	if normalizeRepo(claims.Project) != normalizeRepo(repo) {
+		return nil, fmt.Errorf("token is for project %q", claims.Project)
+	}
+	if !sameIP(claims.RemoteIP, remoteIP) {
+		return nil, fmt.Errorf("token is for IP %q", claims.RemoteIP)
+	}
+	if claims.GitalyAddress == "" || claims.GitalyStorage == "" || claims.GitalyRepoPath == "" {
+		return nil, errors.New("token doesn't locate the repository")
+	}
+
+	return &Response{
+		Success:  true,
+		Repo:     claims.Repo,
+		UserId:   claims.UserId,
+		Username: claims.Username,
+		Gitaly: Gitaly{
+			Repo: pb.Repository{
+				StorageName:   claims.GitalyStorage,
+				RelativePath:  claims.GitalyRepoPath,
+				GlRepository:  claims.Repo,
+				GlProjectPath: normalizeRepo(claims.Project),
+			},
+			Address: claims.GitalyAddress,
+			Token:   p.gitalyToken,
+		},
+	}, nil
+}
+
+func sameIP(a, b string) bool {
+	ip := net.ParseIP(a)
+
+	return ip != nil && ip.Equal(net.ParseIP(b))
+}
+
+func normalizeRepo(repo string) string {
+	return strings.TrimSuffix(strings.Trim(repo, "/"), ".git")
+}
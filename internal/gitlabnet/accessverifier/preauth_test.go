@@ -0,0 +1,204 @@
+package accessverifier
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+func preauthKeys(t *testing.T) (ed25519.PrivateKey, string) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "preauthorization.pub")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	return privateKey, path
+}
+
+func preauthToken(t *testing.T, key ed25519.PrivateKey, modify func(*preauthClaims)) string {
+	t.Helper()
+
+	now := time.Now()
+	claims := &preauthClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    preauthIssuer,
+			Audience:  jwt.ClaimStrings{preauthAudience},
+			Subject:   "key-1",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+		Project:        repo,
+		Action:         string(commandargs.UploadPack),
+		RemoteIP:       "192.0.2.10",
+		Repo:           "project-26",
+		UserId:         "user-1",
+		GitalyAddress:  "unix:gitaly.socket",
+		GitalyStorage:  "default",
+		GitalyRepoPath: "@hashed/26.git",
+	}
+	if modify != nil {
+		modify(claims)
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(key)
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestPreauthorization(t *testing.T) {
+	var calls int
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/allowed",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Write([]byte(`{"status": true, "gl_id": "user-1", "gitaly": {"token": "from-api"}}`))
+			},
+		},
+	}
+
+	key, publicKeyFile := preauthKeys(t)
+	otherKey, _ := preauthKeys(t)
+
+	gitalyTokenFile := filepath.Join(t.TempDir(), "gitaly_token")
+	require.NoError(t, os.WriteFile(gitalyTokenFile, []byte("gitaly-token\n"), 0600))
+
+	client, err := NewClient(&config.Config{
+		GitlabUrl: testserver.StartSocketHttpServer(t, requests),
+		Secret:    "secret",
+		Preauthorization: config.PreauthorizationConfig{
+			Enabled:         true,
+			PublicKeyFile:   publicKeyFile,
+			GitalyTokenFile: gitalyTokenFile,
+		},
+	})
+	require.NoError(t, err)
+
+	verify := func(token string, action commandargs.CommandType, project string) *Response {
+		args := &commandargs.Shell{GitlabKeyId: "1", Env: sshenv.Env{PreauthToken: token, RemoteAddr: "192.0.2.10:2222"}}
+		response, err := client.Verify(context.Background(), args, action, project)
+		require.NoError(t, err)
+		return response
+	}
+
+	response := verify(preauthToken(t, key, nil), uploadPackAction, "/"+repo+".git")
+	require.Equal(t, 0, calls)
+	require.True(t, response.Success)
+	require.Equal(t, "project-26", response.Repo)
+	require.Equal(t, "user-1", response.UserId)
+	require.Equal(t, "unix:gitaly.socket", response.Gitaly.Address)
+	require.Equal(t, "default", response.Gitaly.Repo.StorageName)
+	require.Equal(t, "@hashed/26.git", response.Gitaly.Repo.RelativePath)
+	require.Equal(t, "project-26", response.Gitaly.Repo.GlRepository)
+	require.Equal(t, repo, response.Gitaly.Repo.GlProjectPath)
+	require.Equal(t, "gitaly-token", response.Gitaly.Token)
+	require.Equal(t, "key-1", response.Who)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	testCases := []struct {
+		desc    string
+		token   string
+		action  commandargs.CommandType
+		project string
+	}{
+		{
+			desc:  "other signing key",
+			token: preauthToken(t, otherKey, nil),
+		}, {
+			desc: "signed with the shared secret",
+			token: func() string {
+				token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "key-1"}).SignedString([]byte("secret"))
+				require.NoError(t, err)
+				return token
+			}(),
+		}, {
+			desc:  "other IP",
+			token: preauthToken(t, key, func(c *preauthClaims) { c.RemoteIP = "192.0.2.11" }),
+		}, {
+			desc:  "no IP",
+			token: preauthToken(t, key, func(c *preauthClaims) { c.RemoteIP = "" }),
+		}, {
+			desc:  "other key",
+			token: preauthToken(t, key, func(c *preauthClaims) { c.Subject = "key-2" }),
+		}, {
+			desc:    "other project",
+			token:   preauthToken(t, key, nil),
+			project: "group/other",
+		}, {
+			desc:   "push",
+			token:  preauthToken(t, key, func(c *preauthClaims) { c.Action = string(commandargs.ReceivePack) }),
+			action: receivePackAction,
+		}, {
+			desc:  "expired",
+			token: preauthToken(t, key, func(c *preauthClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Second)) }),
+		}, {
+			desc:  "too long lived",
+			token: preauthToken(t, key, func(c *preauthClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour)) }),
+		}, {
+			desc:  "other audience",
+			token: preauthToken(t, key, func(c *preauthClaims) { c.Audience = jwt.ClaimStrings{"gitlab-workhorse"} }),
+		}, {
+			desc:  "no repository",
+			token: preauthToken(t, key, func(c *preauthClaims) { c.GitalyRepoPath = "" }),
+		}, {
+			desc:  "malformed",
+			token: "token",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if tc.action == "" {
+				tc.action = uploadPackAction
+			}
+			if tc.project == "" {
+				tc.project = repo
+			}
+
+			calls = 0
+			response := verify(tc.token, tc.action, tc.project)
+			require.Equal(t, 1, calls)
+			require.Equal(t, "from-api", response.Gitaly.Token)
+		})
+	}
+}
+
+func TestPreauthorizationDisabled(t *testing.T) {
+	p, err := newPreauthorizer(&config.Config{Secret: "secret"})
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	key, _ := preauthKeys(t)
+	_, err = p.verify(preauthToken(t, key, nil), uploadPackAction, repo, "key-1", "192.0.2.10")
+	require.EqualError(t, err, "pre-authorization is disabled")
+}
+
+func TestPreauthorizationInvalidPublicKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "preauthorization.pub")
+	require.NoError(t, os.WriteFile(path, []byte("ssh-ed25519 AAAA"), 0600))
+
+	_, err := newPreauthorizer(&config.Config{Preauthorization: config.PreauthorizationConfig{Enabled: true, PublicKeyFile: path}})
+	require.EqualError(t, err, "no PEM data found in "+path)
+}
@@ -0,0 +1,60 @@
+package accessverifier
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+)
+
+// responseV2 holds the fields of the version 2 schema of the response that
+// differ from the version 1 schema, which are grouped by user, key and
+// repository. The others keep their names.
+type responseV2 struct {
+	User struct {
+		Id       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Key struct {
+		Id   int    `json:"id"`
+		Type string `json:"type"`
+	} `json:"key"`
+	Repository struct {
+		GlRepository     string   `json:"gl_repository"`
+		GitConfigOptions []string `json:"git_config_options"`
+		GitProtocol      string   `json:"git_protocol"`
+		BundleURIs       []string `json:"bundle_uris"`
+	} `json:"repository"`
+	ConsoleMessages []string `json:"console_messages"`
+}
+
+func parseV2(hr *http.Response, args *commandargs.Shell) (*Response, error) {
+	body, err := io.ReadAll(hr.Body)
+	if err != nil {
+		return nil, gitlabnet.ParsingError
+	}
+
+	response := &Response{}
+	if err := json.Unmarshal(body, response); err != nil {
+		return nil, gitlabnet.ParsingError
+	}
+
+	grouped := &responseV2{}
+	if err := json.Unmarshal(body, grouped); err != nil {
+		return nil, gitlabnet.ParsingError
+	}
+
+	response.UserId = grouped.User.Id
+	response.Username = grouped.User.Username
+	response.KeyId = grouped.Key.Id
+	response.KeyType = grouped.Key.Type
+	response.Repo = grouped.Repository.GlRepository
+	response.GitConfigOptions = grouped.Repository.GitConfigOptions
+	response.GitProtocol = grouped.Repository.GitProtocol
+	response.BundleURIs = grouped.Repository.BundleURIs
+	response.ConsoleMessages = grouped.ConsoleMessages
+
+	return withWho(response, args, hr.StatusCode), nil
+}
@@ -0,0 +1,71 @@
+// Package apiv2 is a client of the internal API whose responses are validated
+// against the OpenAPI spec of openapi.yaml, so that schema drift between
+// gitlab-shell and Rails fails loudly instead of leaving fields empty.
+package apiv2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+)
+
+type Client struct {
+	client   *client.GitlabNetClient
+	timeouts config.APITimeoutsConfig
+}
+
+func NewClient(config *config.Config) (*Client, error) {
+	client, err := gitlabnet.GetClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating http client: %v", err)
+	}
+
+	return &Client{client: client, timeouts: config.APITimeouts}, nil
+}
+
+// Discover looks up a user, returning nil when it is anonymous
+func (c *Client) Discover(ctx context.Context, params url.Values) (*DiscoverResponse, error) {
+	response := &DiscoverResponse{}
+	null, err := c.get(ctx, "/discover?"+params.Encode(), c.timeouts.Discover, discoverResponseSchema, response)
+	if err != nil || null {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// AuthorizedKey looks up a key users authenticate with
+func (c *Client) AuthorizedKey(ctx context.Context, key string) (*AuthorizedKeyResponse, error) {
+	params := url.Values{}
+	params.Set("key", key)
+
+	response := &AuthorizedKeyResponse{}
+	if _, err := c.get(ctx, "/authorized_keys?"+params.Encode(), c.timeouts.AuthorizedKeys, authorizedKeyResponseSchema, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, timeout config.YamlDuration, s *schema, v interface{}) (bool, error) {
+	ctx, cancel := gitlabnet.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	response, err := c.client.Get(ctx, path)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return false, err
+	}
+
+	return s.decode(body, v)
+}
@@ -0,0 +1,76 @@
+package apiv2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func setup(t *testing.T) *Client {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Query().Get("username") {
+				case "jane":
+					fmt.Fprint(w, `{"id": 1, "name": "Jane Doe", "username": "jane"}`)
+				case "drifted":
+					fmt.Fprint(w, `{"id": 1, "full_name": "Jane Doe", "username": "jane"}`)
+				default:
+					fmt.Fprint(w, "null")
+				}
+			},
+		},
+		{
+			Path: "/api/v4/internal/authorized_keys",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("key") != "key" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				fmt.Fprint(w, `{"id": 1, "key": "public-key", "deny_listed": true}`)
+			},
+		},
+	}
+
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	client, err := NewClient(&config.Config{GitlabUrl: url})
+	require.NoError(t, err)
+
+	return client
+}
+
+func TestDiscover(t *testing.T) {
+	client := setup(t)
+
+	user, err := client.Discover(context.Background(), url.Values{"username": {"jane"}})
+	require.NoError(t, err)
+	require.Equal(t, &DiscoverResponse{ID: 1, Name: "Jane Doe", Username: "jane"}, user)
+
+	user, err = client.Discover(context.Background(), url.Values{"username": {"anonymous"}})
+	require.NoError(t, err)
+	require.Nil(t, user)
+
+	_, err = client.Discover(context.Background(), url.Values{"username": {"drifted"}})
+	require.EqualError(t, err, `internal API response doesn't match the DiscoverResponse schema: field "name": is missing`)
+}
+
+func TestAuthorizedKey(t *testing.T) {
+	client := setup(t)
+
+	key, err := client.AuthorizedKey(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, &AuthorizedKeyResponse{ID: 1, Key: "public-key", DenyListed: true}, key)
+
+	_, err = client.AuthorizedKey(context.Background(), "unknown")
+	require.Error(t, err)
+}
@@ -0,0 +1,218 @@
+// Command gen generates the types of the apiv2 package and their schemas from
+// the OpenAPI spec of the internal API.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+type spec struct {
+	Components struct {
+		Schemas map[string]schema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+type schema struct {
+	Description string     `yaml:"description"`
+	Type        string     `yaml:"type"`
+	Nullable    bool       `yaml:"nullable"`
+	Required    []string   `yaml:"required"`
+	Properties  properties `yaml:"properties"`
+	Items       *schema    `yaml:"items"`
+}
+
+type property struct {
+	Name string
+	schema
+}
+
+// properties keeps the order of the properties in the spec
+type properties []property
+
+func (p *properties) UnmarshalYAML(node *yaml.Node) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		prop := property{Name: node.Content[i].Value}
+		if err := node.Content[i+1].Decode(&prop.schema); err != nil {
+			return err
+		}
+
+		*p = append(*p, prop)
+	}
+
+	return nil
+}
+
+type field struct {
+	Name        string
+	GoName      string
+	GoType      string
+	Kind        string
+	Nullable    bool
+	Description string
+}
+
+type goType struct {
+	Name        string
+	Description string
+	Nullable    bool
+	Required    []string
+	Fields      []field
+}
+
+var goTypes = map[string]string{
+	"string":  "string",
+	"integer": "int64",
+	"boolean": "bool",
+	"number":  "float64",
+}
+
+var initialisms = map[string]string{"id": "ID", "url": "URL", "ip": "IP", "api": "API"}
+
+var source = template.Must(template.New("types").Funcs(template.FuncMap{"unexported": unexported}).Parse(`// Code generated by gen/main.go from openapi.yaml; DO NOT EDIT.
+
+package apiv2
+{{range .}}
+{{if .Description}}// {{.Description}}
+{{end}}type {{.Name}} struct {
+{{- range .Fields}}
+	{{if .Description}}// {{.Description}}
+	{{end}}{{.GoName}} {{.GoType}} ` + "`" + `json:"{{.Name}}{{if .Nullable}},omitempty{{end}}"` + "`" + `
+{{- end}}
+}
+
+var {{unexported .Name}}Schema = &schema{
+	name:     "{{.Name}}",
+	nullable: {{.Nullable}},
+	required: []string{ {{- range $i, $r := .Required}}{{if $i}}, {{end}}"{{$r}}"{{end -}} },
+	properties: map[string]property{
+{{- range .Fields}}
+		"{{.Name}}": { kind: {{.Kind}}, nullable: {{.Nullable}} },
+{{- end}}
+	},
+}
+{{end}}`))
+
+func main() {
+	specPath := flag.String("spec", "openapi.yaml", "OpenAPI spec of the internal API")
+	out := flag.String("out", "types.gen.go", "generated Go file")
+	flag.Parse()
+
+	raw, err := os.ReadFile(*specPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	generated, err := generate(raw)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile(*out, generated, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func generate(raw []byte) ([]byte, error) {
+	var s spec
+	if err := yaml.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(s.Components.Schemas))
+	for name := range s.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var types []goType
+	for _, name := range names {
+		t, err := newGoType(name, s.Components.Schemas[name])
+		if err != nil {
+			return nil, err
+		}
+
+		types = append(types, t)
+	}
+
+	var buf bytes.Buffer
+	if err := source.Execute(&buf, types); err != nil {
+		return nil, err
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+func newGoType(name string, s schema) (goType, error) {
+	if s.Type != "object" {
+		return goType{}, fmt.Errorf("schema %s: only objects are supported, got %q", name, s.Type)
+	}
+
+	t := goType{Name: name, Description: s.Description, Nullable: s.Nullable, Required: s.Required}
+	for _, prop := range s.Properties {
+		typ, err := propertyGoType(prop.schema)
+		if err != nil {
+			return goType{}, fmt.Errorf("schema %s: property %s: %w", name, prop.Name, err)
+		}
+
+		t.Fields = append(t.Fields, field{
+			Name:        prop.Name,
+			GoName:      goName(prop.Name),
+			GoType:      typ,
+			Kind:        "kind" + goName(prop.Type),
+			Nullable:    prop.Nullable,
+			Description: prop.Description,
+		})
+	}
+
+	return t, nil
+}
+
+func propertyGoType(s schema) (string, error) {
+	if s.Type == "array" {
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+
+		item, err := propertyGoType(*s.Items)
+		if err != nil {
+			return "", err
+		}
+
+		return "[]" + item, nil
+	}
+
+	typ, ok := goTypes[s.Type]
+	if !ok {
+		return "", fmt.Errorf("unsupported type %q", s.Type)
+	}
+
+	return typ, nil
+}
+
+func unexported(name string) string {
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// goName converts snake_case to an exported Go name
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if initialism, ok := initialisms[part]; ok {
+			b.WriteString(initialism)
+		} else if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratedTypesAreUpToDate(t *testing.T) {
+	spec, err := os.ReadFile("../openapi.yaml")
+	require.NoError(t, err)
+
+	expected, err := os.ReadFile("../types.gen.go")
+	require.NoError(t, err)
+
+	generated, err := generate(spec)
+	require.NoError(t, err)
+	require.Equal(t, string(expected), string(generated), "run `go generate ./internal/gitlabnet/apiv2`")
+}
+
+func TestGoName(t *testing.T) {
+	require.Equal(t, "ID", goName("id"))
+	require.Equal(t, "KeyExpiresAt", goName("key_expires_at"))
+	require.Equal(t, "GitalyURL", goName("gitaly_url"))
+}
@@ -0,0 +1,141 @@
+package apiv2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+//go:generate go run ./gen -spec openapi.yaml -out types.gen.go
+
+type kind string
+
+const (
+	kindString  kind = "string"
+	kindInteger kind = "integer"
+	kindNumber  kind = "number"
+	kindBoolean kind = "boolean"
+	kindArray   kind = "array"
+)
+
+// schema describes a response of the internal API as specified by
+// openapi.yaml. Unknown fields are ignored so that Rails can add fields
+// before gitlab-shell uses them.
+type schema struct {
+	name       string
+	nullable   bool
+	required   []string
+	properties map[string]property
+}
+
+type property struct {
+	kind     kind
+	nullable bool
+}
+
+// SchemaError is returned when a response doesn't match its schema
+type SchemaError struct {
+	Schema string
+	Field  string
+	Reason string
+}
+
+func (e *SchemaError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("internal API response doesn't match the %s schema: %s", e.Schema, e.Reason)
+	}
+
+	return fmt.Sprintf("internal API response doesn't match the %s schema: field %q: %s", e.Schema, e.Field, e.Reason)
+}
+
+// decode validates body against the schema before decoding it into v. It
+// returns whether body was null.
+func (s *schema) decode(body []byte, v interface{}) (bool, error) {
+	body = bytes.TrimSpace(body)
+
+	if bytes.Equal(body, []byte("null")) {
+		if !s.nullable {
+			return false, s.errorf("", "is null")
+		}
+
+		return true, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return false, s.errorf("", "is not a valid JSON object")
+	}
+
+	for _, name := range s.required {
+		if _, ok := fields[name]; !ok {
+			return false, s.errorf(name, "is missing")
+		}
+	}
+
+	for name, raw := range fields {
+		prop, ok := s.properties[name]
+		if !ok {
+			continue
+		}
+
+		got := jsonKind(raw)
+		if got == "null" {
+			if !prop.nullable {
+				return false, s.errorf(name, "is null")
+			}
+			continue
+		}
+
+		if !prop.accepts(got) {
+			return false, s.errorf(name, "expected %s, got %s", prop.kind, got)
+		}
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return false, s.errorf("", "%v", err)
+	}
+
+	return false, nil
+}
+
+func (s *schema) errorf(field, format string, args ...interface{}) error {
+	return &SchemaError{Schema: s.name, Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (p property) accepts(got kind) bool {
+	switch {
+	case p.kind == got:
+		return true
+	case p.kind == kindNumber && got == kindInteger:
+		return true
+	default:
+		return false
+	}
+}
+
+// jsonKind returns the kind of a valid JSON value
+func jsonKind(raw json.RawMessage) kind {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return "null"
+	}
+
+	switch raw[0] {
+	case '"':
+		return kindString
+	case 't', 'f':
+		return kindBoolean
+	case '[':
+		return kindArray
+	case '{':
+		return "object"
+	case 'n':
+		return "null"
+	}
+
+	if bytes.ContainsAny(raw, ".eE") {
+		return kindNumber
+	}
+
+	return kindInteger
+}
@@ -0,0 +1,93 @@
+package apiv2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaDecode(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		body          string
+		expected      *DiscoverResponse
+		expectedNull  bool
+		expectedError string
+	}{
+		{
+			desc:     "valid",
+			body:     `{"id": 1, "name": "Jane Doe", "username": "jane", "key_expires_at": null, "two_factor_enabled": true}`,
+			expected: &DiscoverResponse{ID: 1, Name: "Jane Doe", Username: "jane", TwoFactorEnabled: true},
+		},
+		{
+			desc:     "unknown fields are ignored",
+			body:     `{"id": 1, "name": "Jane Doe", "username": "jane", "avatar": {}}`,
+			expected: &DiscoverResponse{ID: 1, Name: "Jane Doe", Username: "jane"},
+		},
+		{
+			desc:         "null",
+			body:         "null\n",
+			expected:     &DiscoverResponse{},
+			expectedNull: true,
+		},
+		{
+			desc:          "missing field",
+			body:          `{"id": 1, "name": "Jane Doe"}`,
+			expectedError: `internal API response doesn't match the DiscoverResponse schema: field "username": is missing`,
+		},
+		{
+			desc:          "wrong type",
+			body:          `{"id": "1", "name": "Jane Doe", "username": "jane"}`,
+			expectedError: `internal API response doesn't match the DiscoverResponse schema: field "id": expected integer, got string`,
+		},
+		{
+			desc:          "null field",
+			body:          `{"id": 1, "name": null, "username": "jane"}`,
+			expectedError: `internal API response doesn't match the DiscoverResponse schema: field "name": is null`,
+		},
+		{
+			desc:          "not an object",
+			body:          `[]`,
+			expectedError: "internal API response doesn't match the DiscoverResponse schema: is not a valid JSON object",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			response := &DiscoverResponse{}
+			null, err := discoverResponseSchema.decode([]byte(tc.body), response)
+
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				require.IsType(t, &SchemaError{}, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedNull, null)
+			require.Equal(t, tc.expected, response)
+		})
+	}
+
+	_, err := authorizedKeyResponseSchema.decode([]byte("null"), &AuthorizedKeyResponse{})
+	require.EqualError(t, err, "internal API response doesn't match the AuthorizedKeyResponse schema: is null")
+}
+
+func TestJSONKind(t *testing.T) {
+	for raw, expected := range map[string]kind{
+		`"a"`:  kindString,
+		`12`:   kindInteger,
+		`-1`:   kindInteger,
+		`1.5`:  kindNumber,
+		`1e3`:  kindNumber,
+		`true`: kindBoolean,
+		`[1]`:  kindArray,
+		`{}`:   "object",
+		`null`: "null",
+	} {
+		require.Equal(t, expected, jsonKind([]byte(raw)), raw)
+	}
+
+	require.True(t, property{kind: kindNumber}.accepts(kindInteger))
+	require.False(t, property{kind: kindInteger}.accepts(kindNumber))
+}
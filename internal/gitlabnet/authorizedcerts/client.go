@@ -39,6 +39,9 @@ func (c *Client) GetByKey(ctx context.Context, userId, fingerprint string) (*Res
 		return nil, err
 	}
 
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.AuthorizedKeys)
+	defer cancel()
+
 	response, err := c.client.Get(ctx, path)
 	if err != nil {
 		return nil, err
@@ -12,6 +12,7 @@ import (
 
 const (
 	AuthorizedKeysPath = "/authorized_keys"
+	FingerprintsPath   = "/authorized_keys/fingerprints"
 )
 
 type Client struct {
@@ -22,6 +23,47 @@ type Client struct {
 type Response struct {
 	Id  int64  `json:"id"`
 	Key string `json:"key"`
+	// Banner is a message for the owner of the key, such as a warning that
+	// it is about to expire. It is displayed when they log in.
+	Banner string `json:"banner,omitempty"`
+	// ExpiresAt is the RFC 3339 expiry time of the key, empty when it
+	// doesn't expire.
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// DenyListed is set when the key is known to be compromised, for example
+	// because it was found in a public breach corpus. DenyMessage optionally
+	// tells its owner what to do about it.
+	DenyListed  bool   `json:"deny_listed,omitempty"`
+	DenyMessage string `json:"deny_message,omitempty"`
+	// SessionClass groups the sessions of the key, e.g. ci for the keys
+	// of CI runners, for them to share the resources of their class.
+	SessionClass string `json:"session_class,omitempty"`
+	// ActorType is the type of the owner of the key, one of
+	// commandargs.ActorTypes, e.g. deploy_key. Empty for older GitLab
+	// versions.
+	ActorType string `json:"actor_type,omitempty"`
+	// Options are OpenSSH authorized_keys options restricting the key, e.g.
+	// no-port-forwarding or expiry-time="20300101".
+	Options []string `json:"options,omitempty"`
+}
+
+// FingerprintsResponse lists the SHA256 fingerprints of all the keys that can
+// be used to authenticate, in the format of ssh.FingerprintSHA256.
+type FingerprintsResponse struct {
+	Fingerprints []string `json:"fingerprints"`
+}
+
+// Getter looks up the keys users authenticate with
+type Getter interface {
+	GetByKey(ctx context.Context, key string) (*Response, error)
+}
+
+// NewGetter returns the client of the configured version of the internal API
+func NewGetter(cfg *config.Config) (Getter, error) {
+	if cfg.InternalAPI.Client == config.InternalAPIClientV2 {
+		return newV2Client(cfg)
+	}
+
+	return NewClient(cfg)
 }
 
 func NewClient(config *config.Config) (*Client, error) {
@@ -39,6 +81,9 @@ func (c *Client) GetByKey(ctx context.Context, key string) (*Response, error) {
 		return nil, err
 	}
 
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.AuthorizedKeys)
+	defer cancel()
+
 	response, err := c.client.Get(ctx, path)
 	if err != nil {
 		return nil, err
@@ -53,6 +98,24 @@ func (c *Client) GetByKey(ctx context.Context, key string) (*Response, error) {
 	return parsedResponse, nil
 }
 
+func (c *Client) GetFingerprints(ctx context.Context) (*FingerprintsResponse, error) {
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.AuthorizedKeys)
+	defer cancel()
+
+	response, err := c.client.Get(ctx, FingerprintsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	parsedResponse := &FingerprintsResponse{}
+	if err := gitlabnet.ParseJSON(response, parsedResponse); err != nil {
+		return nil, err
+	}
+
+	return parsedResponse, nil
+}
+
 func pathWithKey(key string) (string, error) {
 	u, err := url.Parse(AuthorizedKeysPath)
 	if err != nil {
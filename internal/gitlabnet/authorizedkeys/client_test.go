@@ -42,6 +42,12 @@ func init() {
 				}
 			},
 		},
+		{
+			Path: "/api/v4/internal/authorized_keys/fingerprints",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(&FingerprintsResponse{Fingerprints: []string{"SHA256:fingerprint"}})
+			},
+		},
 	}
 }
 
@@ -53,6 +59,14 @@ func TestGetByKey(t *testing.T) {
 	require.Equal(t, &Response{Id: 1, Key: "public-key"}, result)
 }
 
+func TestGetFingerprints(t *testing.T) {
+	client := setup(t)
+
+	result, err := client.GetFingerprints(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &FingerprintsResponse{Fingerprints: []string{"SHA256:fingerprint"}}, result)
+}
+
 func TestGetByKeyErrorResponses(t *testing.T) {
 	client := setup(t)
 
@@ -101,3 +115,18 @@ func setup(t *testing.T) *Client {
 
 	return client
 }
+
+func TestV2Client(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	client, err := NewGetter(&config.Config{GitlabUrl: url, InternalAPI: config.InternalAPIConfig{Client: config.InternalAPIClientV2}})
+	require.NoError(t, err)
+	require.IsType(t, &v2Client{}, client)
+
+	result, err := client.GetByKey(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, &Response{Id: 1, Key: "public-key"}, result)
+
+	_, err = client.GetByKey(context.Background(), "broken-message")
+	require.EqualError(t, err, "Not allowed!")
+}
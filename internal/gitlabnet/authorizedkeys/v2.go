@@ -0,0 +1,40 @@
+package authorizedkeys
+
+import (
+	"context"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/apiv2"
+)
+
+type v2Client struct {
+	client *apiv2.Client
+}
+
+func newV2Client(cfg *config.Config) (*v2Client, error) {
+	client, err := apiv2.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v2Client{client: client}, nil
+}
+
+func (c *v2Client) GetByKey(ctx context.Context, key string) (*Response, error) {
+	res, err := c.client.AuthorizedKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		Id:           res.ID,
+		Key:          res.Key,
+		Banner:       res.Banner,
+		ExpiresAt:    res.ExpiresAt,
+		DenyListed:   res.DenyListed,
+		DenyMessage:  res.DenyMessage,
+		SessionClass: res.SessionClass,
+		ActorType:    res.ActorType,
+		Options:      res.Options,
+	}, nil
+}
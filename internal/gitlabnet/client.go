@@ -1,10 +1,12 @@
 package gitlabnet
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"time"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 
@@ -28,6 +30,17 @@ func GetClient(config *config.Config) (*client.GitlabNetClient, error) {
 	return client.NewGitlabNetClient(config.HttpSettings.User, config.HttpSettings.Password, config.Secret, httpClient)
 }
 
+// WithTimeout bounds a call to the internal API by its configured timeout,
+// within the deadline of ctx, e.g. of the session. A zero timeout leaves the
+// call bounded by the read timeout of the HTTP client only.
+func WithTimeout(ctx context.Context, timeout config.YamlDuration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, time.Duration(timeout))
+}
+
 func ParseJSON(hr *http.Response, response interface{}) error {
 	if err := json.NewDecoder(hr.Body).Decode(response); err != nil {
 		return ParsingError
@@ -0,0 +1,65 @@
+package gitlabnet
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestTenantRequestsCarryNoParentCredentials(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	url := testserver.StartHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/check",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				requests <- r
+			},
+		},
+	})
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yml"), []byte(`gitlab_url: http://gitlab-a.example.com
+secret: secret-a
+http_settings:
+  user: parent-user
+  password: parent-password
+preauthorization:
+  enabled: true
+  public_key_file: parent.pub
+  gitaly_token_file: parent-gitaly-token
+tenants:
+  - name: b
+    gitlab_url: `+url+`
+    secret: secret-b
+`), 0600))
+
+	cfg, err := config.NewFromDir(dir)
+	require.NoError(t, err)
+
+	tenant := cfg.Tenant("b")
+	require.Equal(t, config.PreauthorizationConfig{}, tenant.Preauthorization)
+
+	client, err := GetClient(tenant)
+	require.NoError(t, err)
+
+	response, err := client.Get(context.Background(), "/check")
+	require.NoError(t, err)
+	response.Body.Close()
+
+	r := <-requests
+	_, _, ok := r.BasicAuth()
+	require.False(t, ok)
+
+	_, err = jwt.Parse(r.Header.Get("Gitlab-Shell-Api-Request"), func(*jwt.Token) (interface{}, error) {
+		return []byte("secret-b"), nil
+	})
+	require.NoError(t, err)
+}
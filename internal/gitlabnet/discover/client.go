@@ -21,6 +21,37 @@ type Response struct {
 	UserId   int64  `json:"id"`
 	Name     string `json:"name"`
 	Username string `json:"username"`
+	// KeyExpiresAt is only set when looking up a user by an expiring key.
+	KeyExpiresAt     string `json:"key_expires_at,omitempty"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled,omitempty"`
+}
+
+// responseV2 is the version 2 schema of the response, which groups the
+// fields of the user and of the key. The user is null when anonymous.
+type responseV2 struct {
+	User *struct {
+		Id               int64  `json:"id"`
+		Name             string `json:"name"`
+		Username         string `json:"username"`
+		TwoFactorEnabled bool   `json:"two_factor_enabled"`
+	} `json:"user"`
+	Key *struct {
+		ExpiresAt string `json:"expires_at"`
+	} `json:"key"`
+}
+
+// Discoverer looks up users
+type Discoverer interface {
+	GetByCommandArgs(ctx context.Context, args *commandargs.Shell) (*Response, error)
+}
+
+// New returns the client of the configured version of the internal API
+func New(cfg *config.Config) (Discoverer, error) {
+	if cfg.InternalAPI.Client == config.InternalAPIClientV2 {
+		return newV2Client(cfg)
+	}
+
+	return NewClient(cfg)
 }
 
 func NewClient(config *config.Config) (*Client, error) {
@@ -28,11 +59,21 @@ func NewClient(config *config.Config) (*Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error creating http client: %v", err)
 	}
+	client.AcceptV2()
 
 	return &Client{config: config, client: client}, nil
 }
 
 func (c *Client) GetByCommandArgs(ctx context.Context, args *commandargs.Shell) (*Response, error) {
+	params, err := commandArgsParams(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.getResponse(ctx, params)
+}
+
+func commandArgsParams(args *commandargs.Shell) (url.Values, error) {
 	params := url.Values{}
 	if args.GitlabUsername != "" {
 		params.Add("username", args.GitlabUsername)
@@ -46,12 +87,15 @@ func (c *Client) GetByCommandArgs(ctx context.Context, args *commandargs.Shell)
 		return nil, fmt.Errorf("who='' is invalid")
 	}
 
-	return c.getResponse(ctx, params)
+	return params, nil
 }
 
 func (c *Client) getResponse(ctx context.Context, params url.Values) (*Response, error) {
 	path := "/discover?" + params.Encode()
 
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.Discover)
+	defer cancel()
+
 	response, err := c.client.Get(ctx, path)
 	if err != nil {
 		return nil, err
@@ -62,6 +106,10 @@ func (c *Client) getResponse(ctx context.Context, params url.Values) (*Response,
 }
 
 func parse(hr *http.Response) (*Response, error) {
+	if client.IsV2Response(hr) {
+		return parseV2(hr)
+	}
+
 	response := &Response{}
 	if err := gitlabnet.ParseJSON(hr, response); err != nil {
 		return nil, err
@@ -70,6 +118,26 @@ func parse(hr *http.Response) (*Response, error) {
 	return response, nil
 }
 
+func parseV2(hr *http.Response) (*Response, error) {
+	parsed := &responseV2{}
+	if err := gitlabnet.ParseJSON(hr, parsed); err != nil {
+		return nil, err
+	}
+
+	response := &Response{}
+	if user := parsed.User; user != nil {
+		response.UserId = user.Id
+		response.Name = user.Name
+		response.Username = user.Username
+		response.TwoFactorEnabled = user.TwoFactorEnabled
+	}
+	if parsed.Key != nil {
+		response.KeyExpiresAt = parsed.Key.ExpiresAt
+	}
+
+	return response, nil
+}
+
 func (r *Response) IsAnonymous() bool {
 	return r.UserId < 1
 }
@@ -7,11 +7,13 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 
 	"github.com/stretchr/testify/require"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 )
 
@@ -148,3 +150,73 @@ func setup(t *testing.T) *Client {
 
 	return client
 }
+
+func TestV2Client(t *testing.T) {
+	url := testserver.StartSocketHttpServer(t, requests)
+
+	client, err := New(&config.Config{GitlabUrl: url, InternalAPI: config.InternalAPIConfig{Client: config.InternalAPIClientV2}})
+	require.NoError(t, err)
+	require.IsType(t, &v2Client{}, client)
+
+	result, err := client.GetByCommandArgs(context.Background(), &commandargs.Shell{GitlabKeyId: "1"})
+	require.NoError(t, err)
+	require.Equal(t, &Response{UserId: 2, Username: "alex-doe", Name: "Alex Doe"}, result)
+
+	result, err = client.GetByCommandArgs(context.Background(), &commandargs.Shell{GitlabUsername: "missing"})
+	require.NoError(t, err)
+	require.True(t, result.IsAnonymous())
+
+	_, err = client.GetByCommandArgs(context.Background(), &commandargs.Shell{GitlabUsername: "broken_message"})
+	require.EqualError(t, err, "Not allowed!")
+}
+
+func TestV2Responses(t *testing.T) {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Contains(t, r.Header.Get("Accept"), client.MediaTypeV2)
+
+				w.Header().Set("Content-Type", client.MediaTypeV2)
+				if r.URL.Query().Get("key_id") == "1" {
+					fmt.Fprint(w, `{"user": {"id": 2, "name": "Alex Doe", "username": "alex-doe", "two_factor_enabled": true}, "key": {"expires_at": "2030-01-01T00:00:00Z"}}`)
+				} else {
+					fmt.Fprint(w, `{"user": null, "key": null}`)
+				}
+			},
+		},
+	}
+
+	c, err := NewClient(&config.Config{GitlabUrl: testserver.StartSocketHttpServer(t, requests)})
+	require.NoError(t, err)
+
+	result, err := c.GetByCommandArgs(context.Background(), &commandargs.Shell{GitlabKeyId: "1"})
+	require.NoError(t, err)
+	require.Equal(t, &Response{UserId: 2, Username: "alex-doe", Name: "Alex Doe", TwoFactorEnabled: true, KeyExpiresAt: "2030-01-01T00:00:00Z"}, result)
+
+	result, err = c.GetByCommandArgs(context.Background(), &commandargs.Shell{GitlabKeyId: "2"})
+	require.NoError(t, err)
+	require.True(t, result.IsAnonymous())
+}
+
+func TestTimeout(t *testing.T) {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+		},
+	}
+
+	c, err := NewClient(&config.Config{
+		GitlabUrl:   testserver.StartSocketHttpServer(t, requests),
+		APITimeouts: config.APITimeoutsConfig{Discover: config.YamlDuration(50 * time.Millisecond)},
+	})
+	require.NoError(t, err)
+
+	started := time.Now()
+	_, err = c.GetByCommandArgs(context.Background(), &commandargs.Shell{GitlabKeyId: "1"})
+	require.EqualError(t, err, "Internal API unreachable")
+	require.Less(t, time.Since(started), 5*time.Second)
+}
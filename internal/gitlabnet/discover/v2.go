@@ -0,0 +1,46 @@
+package discover
+
+import (
+	"context"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/apiv2"
+)
+
+type v2Client struct {
+	client *apiv2.Client
+}
+
+func newV2Client(cfg *config.Config) (*v2Client, error) {
+	client, err := apiv2.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v2Client{client: client}, nil
+}
+
+func (c *v2Client) GetByCommandArgs(ctx context.Context, args *commandargs.Shell) (*Response, error) {
+	params, err := commandArgsParams(args)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := c.client.Discover(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return &Response{}, nil
+	}
+
+	return &Response{
+		UserId:           user.ID,
+		Name:             user.Name,
+		Username:         user.Username,
+		KeyExpiresAt:     user.KeyExpiresAt,
+		TwoFactorEnabled: user.TwoFactorEnabled,
+	}, nil
+}
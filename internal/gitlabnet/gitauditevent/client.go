@@ -33,17 +33,22 @@ type Request struct {
 	Repo          string                            `json:"gl_repository"`
 	Username      string                            `json:"username"`
 	PackfileStats *pb.PackfileNegotiationStatistics `json:"packfile_stats,omitempty"`
+	PushOptions   []string                          `json:"push_options,omitempty"`
 }
 
-func (c *Client) Audit(ctx context.Context, username string, action commandargs.CommandType, repo string, packfileStats *pb.PackfileNegotiationStatistics) error {
+func (c *Client) Audit(ctx context.Context, username string, action commandargs.CommandType, repo string, packfileStats *pb.PackfileNegotiationStatistics, pushOptions []string) error {
 	request := &Request{
 		Action:        action,
 		Repo:          repo,
 		Protocol:      "ssh",
 		Username:      username,
 		PackfileStats: packfileStats,
+		PushOptions:   pushOptions,
 	}
 
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.AuditEvents)
+	defer cancel()
+
 	response, err := c.client.Post(ctx, uri, request)
 	if err != nil {
 		return err
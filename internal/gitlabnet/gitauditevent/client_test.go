@@ -28,7 +28,7 @@ func TestAudit(t *testing.T) {
 	err := client.Audit(context.Background(), testUsername, testAction, testRepo, &pb.PackfileNegotiationStatistics{
 		Wants: testPackfileWants,
 		Haves: testPackfileHaves,
-	})
+	}, []string{"ci.skip"})
 	require.NoError(t, err)
 }
 
@@ -38,7 +38,7 @@ func TestAuditFailed(t *testing.T) {
 	err := client.Audit(context.Background(), testUsername, testAction, testRepo, &pb.PackfileNegotiationStatistics{
 		Wants: testPackfileWants,
 		Haves: testPackfileHaves,
-	})
+	}, []string{"ci.skip"})
 	require.Error(t, err)
 }
 
@@ -59,6 +59,7 @@ func setup(t *testing.T, responseStatus int) *Client {
 				require.Equal(t, "ssh", request.Protocol)
 				require.Equal(t, testPackfileWants, request.PackfileStats.Wants)
 				require.Equal(t, testPackfileHaves, request.PackfileStats.Haves)
+				require.Equal(t, []string{"ci.skip"}, request.PushOptions)
 
 				w.WriteHeader(responseStatus)
 			},
@@ -19,10 +19,11 @@ type Client struct {
 }
 
 type Request struct {
-	Operation string `json:"operation"`
-	Repo      string `json:"project"`
-	KeyId     string `json:"key_id,omitempty"`
-	UserId    string `json:"user_id,omitempty"`
+	Operation string   `json:"operation"`
+	Repo      string   `json:"project"`
+	KeyId     string   `json:"key_id,omitempty"`
+	UserId    string   `json:"user_id,omitempty"`
+	Transfers []string `json:"transfers,omitempty"`
 }
 
 type Response struct {
@@ -30,6 +31,14 @@ type Response struct {
 	LfsToken  string `json:"lfs_token"`
 	RepoPath  string `json:"repository_http_path"`
 	ExpiresIn int    `json:"expires_in"`
+	ExpiresAt string `json:"expires_at"`
+	// Href overrides the LFS endpoint derived from RepoPath, e.g. to point
+	// the client directly at object storage
+	Href string `json:"href"`
+	// Header holds additional headers the client has to send to Href
+	Header map[string]string `json:"header"`
+	// Transfer is the transfer adapter picked from the ones the client offered
+	Transfer string `json:"transfer"`
 }
 
 func NewClient(config *config.Config, args *commandargs.Shell) (*Client, error) {
@@ -41,14 +50,17 @@ func NewClient(config *config.Config, args *commandargs.Shell) (*Client, error)
 	return &Client{config: config, client: client, args: args}, nil
 }
 
-func (c *Client) Authenticate(ctx context.Context, operation, repo, userId string) (*Response, error) {
-	request := &Request{Operation: operation, Repo: repo}
+func (c *Client) Authenticate(ctx context.Context, operation, repo, userId string, transfers []string) (*Response, error) {
+	request := &Request{Operation: operation, Repo: repo, Transfers: transfers}
 	if c.args.GitlabKeyId != "" {
 		request.KeyId = c.args.GitlabKeyId
 	} else {
 		request.UserId = strings.TrimPrefix(userId, "user-")
 	}
 
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.LfsAuthenticate)
+	defer cancel()
+
 	response, err := c.client.Post(ctx, "/lfs_authenticate", request)
 	if err != nil {
 		return nil, err
@@ -40,6 +40,20 @@ func setup(t *testing.T) []testserver.TestRequestHandler {
 						"expires_in":           1800,
 					}
 					require.NoError(t, json.NewEncoder(w).Encode(body))
+				case "object-storage":
+					require.Equal(t, []string{"basic", "ssh"}, request.Transfers)
+
+					body := map[string]interface{}{
+						"username":             "john",
+						"lfs_token":            "sometoken",
+						"repository_http_path": "https://gitlab.com/repo/path",
+						"expires_in":           7200,
+						"expires_at":           "2023-06-01T12:00:00Z",
+						"href":                 "https://storage.example.com/lfs",
+						"header":               map[string]string{"X-Storage-Token": "signed"},
+						"transfer":             "basic",
+					}
+					require.NoError(t, json.NewEncoder(w).Encode(body))
 				case "forbidden":
 					w.WriteHeader(http.StatusForbidden)
 				case "broken":
@@ -85,7 +99,7 @@ func TestFailedRequests(t *testing.T) {
 
 			operation := tc.args.SshArgs[2]
 
-			_, err = client.Authenticate(context.Background(), operation, repo, "")
+			_, err = client.Authenticate(context.Background(), operation, repo, "", nil)
 			require.Error(t, err)
 
 			require.Equal(t, tc.expectedOutput, err.Error())
@@ -118,7 +132,7 @@ func TestSuccessfulRequests(t *testing.T) {
 			client, err := NewClient(&config.Config{GitlabUrl: url}, args)
 			require.NoError(t, err)
 
-			response, err := client.Authenticate(context.Background(), operation, repo, "")
+			response, err := client.Authenticate(context.Background(), operation, repo, "", nil)
 			require.NoError(t, err)
 
 			expectedResponse := &Response{
@@ -132,3 +146,28 @@ func TestSuccessfulRequests(t *testing.T) {
 		})
 	}
 }
+
+func TestSuccessfulRequestWithOverrides(t *testing.T) {
+	requests := setup(t)
+	url := testserver.StartHttpServer(t, requests)
+
+	args := &commandargs.Shell{GitlabKeyId: "object-storage", CommandType: commandargs.LfsAuthenticate}
+	client, err := NewClient(&config.Config{GitlabUrl: url}, args)
+	require.NoError(t, err)
+
+	response, err := client.Authenticate(context.Background(), "upload", repo, "", []string{"basic", "ssh"})
+	require.NoError(t, err)
+
+	expectedResponse := &Response{
+		Username:  "john",
+		LfsToken:  "sometoken",
+		RepoPath:  "https://gitlab.com/repo/path",
+		ExpiresIn: 7200,
+		ExpiresAt: "2023-06-01T12:00:00Z",
+		Href:      "https://storage.example.com/lfs",
+		Header:    map[string]string{"X-Storage-Token": "signed"},
+		Transfer:  "basic",
+	}
+
+	require.Equal(t, expectedResponse, response)
+}
@@ -0,0 +1,228 @@
+package lfstransfer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+)
+
+const (
+	contentType = "application/vnd.git-lfs+json"
+
+	// HashAlgo is the only object hash algorithm supported by GitLab
+	HashAlgo = "sha256"
+
+	DownloadAction = "download"
+	UploadAction   = "upload"
+	VerifyAction   = "verify"
+)
+
+// Client talks to the Git LFS API of a single repository. Href is the LFS
+// endpoint returned by /lfs_authenticate and Headers carry its credentials.
+type Client struct {
+	Href    string
+	Headers map[string]string
+
+	httpClient *http.Client
+}
+
+// NewClient returns a Client sending its requests with the HTTP settings of
+// cfg
+func NewClient(cfg *config.Config, href string, headers map[string]string) (*Client, error) {
+	transport, err := cfg.HTTPTransport()
+	if err != nil {
+		return nil, fmt.Errorf("Error creating http client: %v", err)
+	}
+
+	return &Client{
+		Href:       href,
+		Headers:    headers,
+		httpClient: &http.Client{Transport: client.NewTransport(transport)},
+	}, nil
+}
+
+type Object struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+type Ref struct {
+	Name string `json:"name"`
+}
+
+type BatchRequest struct {
+	Operation string    `json:"operation"`
+	Transfers []string  `json:"transfers"`
+	Ref       *Ref      `json:"ref,omitempty"`
+	Objects   []*Object `json:"objects"`
+	HashAlgo  string    `json:"hash_algo"`
+}
+
+type Action struct {
+	Href      string            `json:"href"`
+	Header    map[string]string `json:"header"`
+	ExpiresIn int               `json:"expires_in"`
+}
+
+type ObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type ResponseObject struct {
+	Oid     string             `json:"oid"`
+	Size    int64              `json:"size"`
+	Actions map[string]*Action `json:"actions"`
+	Error   *ObjectError       `json:"error"`
+}
+
+type BatchResponse struct {
+	Transfer string            `json:"transfer"`
+	Objects  []*ResponseObject `json:"objects"`
+	HashAlgo string            `json:"hash_algo"`
+}
+
+// StatusError is returned when the LFS API responds with an error status, the
+// code is passed on to the client as the status of the failed request
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+func (c *Client) Batch(ctx context.Context, operation string, objects []*Object, refname string) (*BatchResponse, error) {
+	batchRequest := &BatchRequest{
+		Operation: operation,
+		Transfers: []string{"basic"},
+		Objects:   objects,
+		HashAlgo:  HashAlgo,
+	}
+	if refname != "" {
+		batchRequest.Ref = &Ref{Name: refname}
+	}
+
+	body, err := json.Marshal(batchRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Href+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("Accept", contentType)
+
+	response, err := c.do(request, c.Headers)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	batchResponse := &BatchResponse{}
+	if err := json.NewDecoder(response.Body).Decode(batchResponse); err != nil {
+		return nil, fmt.Errorf("Parsing failed")
+	}
+
+	return batchResponse, nil
+}
+
+// Download returns the content of the object the download action points at
+func (c *Client) Download(ctx context.Context, action *Action) (io.ReadCloser, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, action.Href, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.do(request, action.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Body, nil
+}
+
+// Upload sends size bytes read from body to the upload action
+func (c *Client) Upload(ctx context.Context, action *Action, body io.Reader, size int64) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, action.Href, body)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = size
+	request.Header.Set("Content-Type", "application/octet-stream")
+
+	response, err := c.do(request, action.Header)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+// Verify confirms to the verify action that object has been uploaded
+func (c *Client) Verify(ctx context.Context, action *Action, object *Object) error {
+	body, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, action.Href, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("Accept", contentType)
+
+	response, err := c.do(request, action.Header)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+func (c *Client) do(request *http.Request, headers map[string]string) (*http.Response, error) {
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		logger.WithContextFields(request.Context(), log.Fields{
+			"method": request.Method,
+			"host":   request.URL.Host,
+			"path":   request.URL.Path,
+		}).WithError(err).Error("lfstransfer: LFS API unreachable")
+
+		return nil, &StatusError{StatusCode: http.StatusBadGateway, Message: "LFS API unreachable"}
+	}
+
+	if response.StatusCode >= 400 {
+		defer response.Body.Close()
+
+		parsedResponse := &errorResponse{}
+		if err := json.NewDecoder(response.Body).Decode(parsedResponse); err != nil || parsedResponse.Message == "" {
+			parsedResponse.Message = fmt.Sprintf("LFS API error (%v)", response.StatusCode)
+		}
+
+		return nil, &StatusError{StatusCode: response.StatusCode, Message: parsedResponse.Message}
+	}
+
+	return response, nil
+}
@@ -26,6 +26,25 @@ type Response struct {
 	Message   string   `json:"message"`
 }
 
+type Token struct {
+	Id         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt string   `json:"last_used_at"`
+}
+
+type ListResponse struct {
+	Success bool    `json:"success"`
+	Tokens  []Token `json:"tokens"`
+	Message string  `json:"message"`
+}
+
+type RevokeResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
 type RequestBody struct {
 	KeyId     string   `json:"key_id,omitempty"`
 	UserId    int64    `json:"user_id,omitempty"`
@@ -34,6 +53,17 @@ type RequestBody struct {
 	ExpiresAt string   `json:"expires_at,omitempty"`
 }
 
+type ListRequestBody struct {
+	KeyId  string `json:"key_id,omitempty"`
+	UserId int64  `json:"user_id,omitempty"`
+}
+
+type RevokeRequestBody struct {
+	KeyId   string `json:"key_id,omitempty"`
+	UserId  int64  `json:"user_id,omitempty"`
+	TokenId int64  `json:"token_id"`
+}
+
 func NewClient(config *config.Config) (*Client, error) {
 	client, err := gitlabnet.GetClient(config)
 	if err != nil {
@@ -49,6 +79,9 @@ func (c *Client) GetPersonalAccessToken(ctx context.Context, args *commandargs.S
 		return nil, err
 	}
 
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.PersonalAccessToken)
+	defer cancel()
+
 	response, err := c.client.Post(ctx, "/personal_access_token", requestBody)
 	if err != nil {
 		return nil, err
@@ -58,6 +91,64 @@ func (c *Client) GetPersonalAccessToken(ctx context.Context, args *commandargs.S
 	return parse(response)
 }
 
+// ListPersonalAccessTokens returns the active tokens of the user.
+func (c *Client) ListPersonalAccessTokens(ctx context.Context, args *commandargs.Shell) (*ListResponse, error) {
+	keyId, userId, err := c.identify(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.PersonalAccessToken)
+	defer cancel()
+
+	response, err := c.client.Post(ctx, "/personal_access_tokens", &ListRequestBody{KeyId: keyId, UserId: userId})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	parsed := &ListResponse{}
+	if err := gitlabnet.ParseJSON(response, parsed); err != nil {
+		return nil, err
+	}
+
+	if !parsed.Success {
+		return nil, errors.New(parsed.Message)
+	}
+
+	return parsed, nil
+}
+
+// RevokePersonalAccessToken revokes the token of the user with the given ID.
+func (c *Client) RevokePersonalAccessToken(ctx context.Context, args *commandargs.Shell, tokenId int64) (*RevokeResponse, error) {
+	keyId, userId, err := c.identify(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	requestBody := &RevokeRequestBody{KeyId: keyId, UserId: userId, TokenId: tokenId}
+
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.PersonalAccessToken)
+	defer cancel()
+
+	response, err := c.client.Post(ctx, "/personal_access_token/revoke", requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	parsed := &RevokeResponse{}
+	if err := gitlabnet.ParseJSON(response, parsed); err != nil {
+		return nil, err
+	}
+
+	if !parsed.Success {
+		return nil, errors.New(parsed.Message)
+	}
+
+	return parsed, nil
+}
+
 func parse(hr *http.Response) (*Response, error) {
 	response := &Response{}
 	if err := gitlabnet.ParseJSON(hr, response); err != nil {
@@ -72,23 +163,30 @@ func parse(hr *http.Response) (*Response, error) {
 }
 
 func (c *Client) getRequestBody(ctx context.Context, args *commandargs.Shell, name string, scopes *[]string, expiresAt string) (*RequestBody, error) {
-	client, err := discover.NewClient(c.config)
+	keyId, userId, err := c.identify(ctx, args)
 	if err != nil {
 		return nil, err
 	}
 
-	requestBody := &RequestBody{Name: name, Scopes: *scopes, ExpiresAt: expiresAt}
+	return &RequestBody{KeyId: keyId, UserId: userId, Name: name, Scopes: *scopes, ExpiresAt: expiresAt}, nil
+}
+
+// identify returns the key ID of the caller or, when they authenticated
+// without a key, their user ID.
+func (c *Client) identify(ctx context.Context, args *commandargs.Shell) (string, int64, error) {
 	if args.GitlabKeyId != "" {
-		requestBody.KeyId = args.GitlabKeyId
+		return args.GitlabKeyId, 0, nil
+	}
 
-		return requestBody, nil
+	client, err := discover.New(c.config)
+	if err != nil {
+		return "", 0, err
 	}
 
 	userInfo, err := client.GetByCommandArgs(ctx, args)
 	if err != nil {
-		return nil, err
+		return "", 0, err
 	}
-	requestBody.UserId = userInfo.UserId
 
-	return requestBody, nil
+	return "", userInfo.UserId, nil
 }
@@ -70,6 +70,48 @@ func initialize(t *testing.T) {
 				}
 			},
 		},
+		{
+			Path: "/api/v4/internal/personal_access_tokens",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var requestBody *ListRequestBody
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&requestBody))
+
+				switch {
+				case requestBody.KeyId == "0" || requestBody.UserId == 1:
+					body := map[string]interface{}{
+						"success": true,
+						"tokens": []map[string]interface{}{
+							{"id": 7, "name": "ci", "scopes": []string{"api"}, "expires_at": "9001-11-17", "last_used_at": nil},
+						},
+					}
+					json.NewEncoder(w).Encode(body)
+				default:
+					body := map[string]interface{}{
+						"success": false,
+						"message": "missing user",
+					}
+					json.NewEncoder(w).Encode(body)
+				}
+			},
+		},
+		{
+			Path: "/api/v4/internal/personal_access_token/revoke",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				var requestBody *RevokeRequestBody
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&requestBody))
+
+				if requestBody.KeyId == "0" && requestBody.TokenId == 7 {
+					json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+					return
+				}
+
+				body := map[string]interface{}{
+					"success": false,
+					"message": "Token not found",
+				}
+				json.NewEncoder(w).Encode(body)
+			},
+		},
 		{
 			Path: "/api/v4/internal/discover",
 			Handler: func(w http.ResponseWriter, r *http.Request) {
@@ -162,6 +204,36 @@ func TestErrorResponses(t *testing.T) {
 	}
 }
 
+func TestListPersonalAccessTokens(t *testing.T) {
+	client := setup(t)
+
+	expected := &ListResponse{
+		Success: true,
+		Tokens:  []Token{{Id: 7, Name: "ci", Scopes: []string{"api"}, ExpiresAt: "9001-11-17"}},
+	}
+
+	for _, args := range []*commandargs.Shell{{GitlabKeyId: "0"}, {GitlabUsername: "jane-doe"}} {
+		result, err := client.ListPersonalAccessTokens(context.Background(), args)
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+	}
+
+	_, err := client.ListPersonalAccessTokens(context.Background(), &commandargs.Shell{GitlabKeyId: "1"})
+	require.EqualError(t, err, "missing user")
+}
+
+func TestRevokePersonalAccessToken(t *testing.T) {
+	client := setup(t)
+
+	args := &commandargs.Shell{GitlabKeyId: "0"}
+	result, err := client.RevokePersonalAccessToken(context.Background(), args, 7)
+	require.NoError(t, err)
+	require.Equal(t, &RevokeResponse{Success: true}, result)
+
+	_, err = client.RevokePersonalAccessToken(context.Background(), args, 8)
+	require.EqualError(t, err, "Token not found")
+}
+
 func setup(t *testing.T) *Client {
 	initialize(t)
 	url := testserver.StartSocketHttpServer(t, requests)
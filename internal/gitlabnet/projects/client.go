@@ -0,0 +1,71 @@
+package projects
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+)
+
+type Client struct {
+	config *config.Config
+	client *client.GitlabNetClient
+}
+
+type Project struct {
+	Path        string `json:"path_with_namespace"`
+	AccessLevel string `json:"access_level"`
+}
+
+type Response struct {
+	Projects []Project `json:"projects"`
+	// NextPage is zero when there are no more projects.
+	NextPage int `json:"next_page"`
+}
+
+func NewClient(config *config.Config) (*Client, error) {
+	client, err := gitlabnet.GetClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating http client: %v", err)
+	}
+
+	return &Client{config: config, client: client}, nil
+}
+
+// List returns a page of the projects the caller can access over SSH.
+func (c *Client) List(ctx context.Context, args *commandargs.Shell, page, perPage int) (*Response, error) {
+	params := url.Values{}
+	if args.GitlabUsername != "" {
+		params.Add("username", args.GitlabUsername)
+	} else if args.GitlabKeyId != "" {
+		params.Add("key_id", args.GitlabKeyId)
+	} else if args.GitlabKrb5Principal != "" {
+		params.Add("krb5principal", args.GitlabKrb5Principal)
+	} else {
+		return nil, fmt.Errorf("who='' is invalid")
+	}
+
+	params.Add("page", strconv.Itoa(page))
+	params.Add("per_page", strconv.Itoa(perPage))
+
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.Projects)
+	defer cancel()
+
+	response, err := c.client.Get(ctx, "/projects?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	parsed := &Response{}
+	if err := gitlabnet.ParseJSON(response, parsed); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
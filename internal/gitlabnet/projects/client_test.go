@@ -0,0 +1,59 @@
+package projects
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestList(t *testing.T) {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/projects",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				query := r.URL.Query()
+				require.Equal(t, "2", query.Get("page"))
+				require.Equal(t, "1", query.Get("per_page"))
+
+				if query.Get("key_id") != "1" && query.Get("username") != "jane-doe" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				body := map[string]interface{}{
+					"projects":  []map[string]string{{"path_with_namespace": "group/repo", "access_level": "developer"}},
+					"next_page": 3,
+				}
+				json.NewEncoder(w).Encode(body)
+			},
+		},
+	}
+
+	url := testserver.StartSocketHttpServer(t, requests)
+	client, err := NewClient(&config.Config{GitlabUrl: url})
+	require.NoError(t, err)
+
+	expected := &Response{
+		Projects: []Project{{Path: "group/repo", AccessLevel: "developer"}},
+		NextPage: 3,
+	}
+
+	for _, args := range []*commandargs.Shell{{GitlabKeyId: "1"}, {GitlabUsername: "jane-doe"}} {
+		result, err := client.List(context.Background(), args, 2, 1)
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+	}
+
+	_, err = client.List(context.Background(), &commandargs.Shell{GitlabKeyId: "2"}, 2, 1)
+	require.EqualError(t, err, "Internal API error (404)")
+
+	_, err = client.List(context.Background(), &commandargs.Shell{}, 2, 1)
+	require.EqualError(t, err, "who='' is invalid")
+}
@@ -45,6 +45,9 @@ func (c *Client) GetRecoveryCodes(ctx context.Context, args *commandargs.Shell)
 		return nil, err
 	}
 
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.TwoFactor)
+	defer cancel()
+
 	response, err := c.client.Post(ctx, "/two_factor_recovery_codes", requestBody)
 	if err != nil {
 		return nil, err
@@ -68,7 +71,7 @@ func parse(hr *http.Response) ([]string, error) {
 }
 
 func (c *Client) getRequestBody(ctx context.Context, args *commandargs.Shell) (*RequestBody, error) {
-	client, err := discover.NewClient(c.config)
+	client, err := discover.New(c.config)
 
 	if err != nil {
 		return nil, err
@@ -44,6 +44,9 @@ func (c *Client) VerifyOTP(ctx context.Context, args *commandargs.Shell, otp str
 		return err
 	}
 
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.TwoFactor)
+	defer cancel()
+
 	response, err := c.client.Post(ctx, "/two_factor_manual_otp_check", requestBody)
 	if err != nil {
 		return err
@@ -59,6 +62,9 @@ func (c *Client) PushAuth(ctx context.Context, args *commandargs.Shell) error {
 		return err
 	}
 
+	ctx, cancel := gitlabnet.WithTimeout(ctx, c.config.APITimeouts.TwoFactor)
+	defer cancel()
+
 	response, err := c.client.Post(ctx, "/two_factor_push_otp_check", requestBody)
 	if err != nil {
 		return err
@@ -82,7 +88,7 @@ func parse(hr *http.Response) error {
 }
 
 func (c *Client) getRequestBody(ctx context.Context, args *commandargs.Shell, otp string) (*RequestBody, error) {
-	client, err := discover.NewClient(c.config)
+	client, err := discover.New(c.config)
 
 	if err != nil {
 		return nil, err
@@ -11,9 +11,12 @@ import (
 	"google.golang.org/grpc/metadata"
 	grpcstatus "google.golang.org/grpc/status"
 
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bandwidth"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitaly"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
 
 	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
@@ -63,16 +66,18 @@ func processGitalyError(statusErr error) error {
 // through GitLab-Shell. It ensures that logging, tracing and other
 // common concerns are configured before executing the `handler`.
 func (gc *GitalyCommand) RunGitalyCommand(ctx context.Context, handler GitalyHandlerFunc) error {
-	// We leave the connection open for future reuse
-	conn, err := gc.getConn(ctx)
+	// The connection is pooled and left open for reuse by other sessions
+	conn, release, err := gc.Config.GitalyClient.AcquireConnection(ctx, gc.Command)
 	if err != nil {
-		log.ContextLogger(ctx).WithError(fmt.Errorf("RunGitalyCommand: %v", err)).Error("Failed to get connection to execute Git command")
+		logger.ContextLogger(ctx).WithError(fmt.Errorf("RunGitalyCommand: %v", err)).Error("Failed to get connection to execute Git command")
 
 		return err
 	}
+	defer release()
 
 	childCtx := withOutgoingMetadata(ctx, gc.Response.Gitaly.Features)
-	ctxlog := log.ContextLogger(childCtx)
+	childCtx = gitaly.ContextWithServiceName(childCtx, gc.Command.ServiceName)
+	ctxlog := logger.ContextLogger(childCtx)
 	exitStatus, err := handler(childCtx, conn)
 
 	if err != nil {
@@ -106,6 +111,25 @@ func (gc *GitalyCommand) PrepareContext(ctx context.Context, repository *pb.Repo
 	return ctx, cancel
 }
 
+// LimitBandwidth wraps rw so that transfers are throttled by the global,
+// per-connection and per-user bandwidth limits. The returned function must be
+// called once the transfer is done.
+func (gc *GitalyCommand) LimitBandwidth(ctx context.Context, rw *readwriter.ReadWriter) (*readwriter.ReadWriter, func()) {
+	userLimiter, release := gc.Config.UserBandwidthLimiter(gc.Response.UserId, gc.Response.BandwidthLimits)
+
+	// The slice of the context is shared with the other transfers, copy it
+	fromContext := bandwidth.LimitersFromContext(ctx)
+	limiters := make([]*bandwidth.Limiter, 0, len(fromContext)+2)
+	limiters = append(limiters, fromContext...)
+	limiters = append(limiters, gc.Config.GlobalBandwidthLimiter(), userLimiter)
+
+	return &readwriter.ReadWriter{
+		Out:    bandwidth.NewWriter(ctx, rw.Out, limiters),
+		In:     bandwidth.NewReader(ctx, rw.In, limiters),
+		ErrOut: rw.ErrOut,
+	}, release
+}
+
 func (gc *GitalyCommand) LogExecution(ctx context.Context, repository *pb.Repository, env sshenv.Env) {
 	fields := log.Fields{
 		"command":         gc.Command.ServiceName,
@@ -119,7 +143,7 @@ func (gc *GitalyCommand) LogExecution(ctx context.Context, repository *pb.Reposi
 		"gl_key_id":       gc.Response.KeyId,
 	}
 
-	log.WithContextFields(ctx, fields).Info("executing git command")
+	logger.WithContextFields(ctx, fields).Info("executing git command")
 }
 
 func withOutgoingMetadata(ctx context.Context, features map[string]string) context.Context {
@@ -133,7 +157,3 @@ func withOutgoingMetadata(ctx context.Context, features map[string]string) conte
 
 	return metadata.NewOutgoingContext(ctx, md)
 }
-
-func (gc *GitalyCommand) getConn(ctx context.Context) (*grpc.ClientConn, error) {
-	return gc.Config.GitalyClient.GetConnection(ctx, gc.Command)
-}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"testing"
@@ -12,7 +13,9 @@ import (
 	grpcstatus "google.golang.org/grpc/status"
 
 	pb "gitlab.com/gitlab-org/gitaly/v16/proto/go/gitalypb"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bandwidth"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
@@ -47,30 +50,6 @@ func TestRunGitalyCommand(t *testing.T) {
 	require.Equal(t, err, expectedErr)
 }
 
-func TestCachingOfGitalyConnections(t *testing.T) {
-	ctx := context.Background()
-	cfg := newConfig()
-	response := &accessverifier.Response{
-		Username: "user",
-		Gitaly: accessverifier.Gitaly{
-			Address: "tcp://localhost:9999",
-			Token:   "token",
-		},
-	}
-
-	cmd := NewGitalyCommand(cfg, string(commandargs.UploadPack), response)
-
-	conn, err := cmd.getConn(ctx)
-	require.NoError(t, err)
-
-	// Reuses connection for different users
-	response.Username = "another-user"
-	cmd = NewGitalyCommand(cfg, string(commandargs.UploadPack), response)
-	newConn, err := cmd.getConn(ctx)
-	require.NoError(t, err)
-	require.Equal(t, conn, newConn)
-}
-
 func TestMissingGitalyAddress(t *testing.T) {
 	cmd := GitalyCommand{Config: newConfig()}
 
@@ -242,3 +221,31 @@ func errWithDetail(t *testing.T, detail proto.Message) error {
 
 	return grpcstatus.ErrorProto(proto)
 }
+
+func TestLimitBandwidth(t *testing.T) {
+	rw := &readwriter.ReadWriter{Out: &bytes.Buffer{}, In: &bytes.Buffer{}, ErrOut: &bytes.Buffer{}}
+	response := &accessverifier.Response{UserId: "user-1"}
+
+	cfg := newConfig()
+	cmd := NewGitalyCommand(cfg, string(commandargs.UploadPack), response)
+
+	limited, release := cmd.LimitBandwidth(context.Background(), rw)
+	require.Same(t, rw.Out, limited.Out)
+	require.Same(t, rw.In, limited.In)
+	release()
+
+	cfg.Bandwidth.PerUser = bandwidth.Limits{Download: 1024}
+
+	limited, release = cmd.LimitBandwidth(context.Background(), rw)
+	require.NotSame(t, rw.Out, limited.Out)
+	require.Same(t, rw.In, limited.In)
+	require.Same(t, rw.ErrOut, limited.ErrOut)
+	release()
+
+	response.BandwidthLimits = &bandwidth.Limits{Upload: 1024}
+
+	limited, release = cmd.LimitBandwidth(context.Background(), rw)
+	require.Same(t, rw.Out, limited.Out)
+	require.NotSame(t, rw.In, limited.In)
+	release()
+}
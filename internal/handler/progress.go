@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+)
+
+// progress tracks when data was last transferred, and serializes the writes
+// to stderr with the notices
+type progress struct {
+	lastActivity atomic.Int64
+
+	mu     sync.Mutex
+	errOut io.Writer
+}
+
+type progressReader struct {
+	io.Reader
+	p *progress
+}
+
+type progressWriter struct {
+	io.Writer
+	p *progress
+}
+
+type progressErrWriter struct {
+	p *progress
+}
+
+// ReportProgress wraps rw so that a notice is written to stderr whenever
+// nothing was transferred for the configured interval, so that users don't
+// think a long push hangs while it's processed, e.g. by server hooks. The
+// returned function must be called once the transfer is done.
+//
+// The notice only tells that the push is still alive. The messages of git and
+// of the server hooks are already relayed by Gitaly as they are written, while
+// the messages of the internal API, e.g. of PostReceive, are only known once
+// the API call completed, so there is no earlier progress to relay.
+func (gc *GitalyCommand) ReportProgress(ctx context.Context, rw *readwriter.ReadWriter) (*readwriter.ReadWriter, func()) {
+	interval := time.Duration(gc.Config.Commands.ProgressInterval)
+	if interval <= 0 {
+		return rw, func() {}
+	}
+
+	p := &progress{errOut: rw.ErrOut}
+	p.touch()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.run(ctx, interval, time.Now())
+	}()
+
+	return &readwriter.ReadWriter{
+		Out:    &progressWriter{Writer: rw.Out, p: p},
+		In:     &progressReader{Reader: rw.In, p: p},
+		ErrOut: &progressErrWriter{p: p},
+	}, func() {
+		cancel()
+		<-done
+	}
+}
+
+func (p *progress) run(ctx context.Context, interval time.Duration, started time.Time) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		idle := time.Since(time.Unix(0, p.lastActivity.Load()))
+		if idle >= interval {
+			p.mu.Lock()
+			console.DisplayProgressMessage(fmt.Sprintf("Still processing, %s elapsed...", time.Since(started).Round(time.Second)), p.errOut)
+			p.mu.Unlock()
+
+			p.touch()
+			idle = 0
+		}
+
+		timer.Reset(interval - idle)
+	}
+}
+
+func (p *progress) touch() {
+	p.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if n > 0 {
+		r.p.touch()
+	}
+
+	return n, err
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.p.touch()
+
+	return w.Writer.Write(b)
+}
+
+func (w *progressErrWriter) Write(b []byte) (int, error) {
+	w.p.touch()
+
+	w.p.mu.Lock()
+	defer w.p.mu.Unlock()
+
+	return w.p.errOut.Write(b)
+}
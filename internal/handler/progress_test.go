@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+)
+
+func TestReportProgressDisabled(t *testing.T) {
+	rw := &readwriter.ReadWriter{Out: &bytes.Buffer{}, In: &bytes.Buffer{}, ErrOut: &bytes.Buffer{}}
+	cmd := NewGitalyCommand(newConfig(), string(commandargs.ReceivePack), &accessverifier.Response{})
+
+	reporting, stop := cmd.ReportProgress(context.Background(), rw)
+	defer stop()
+
+	require.Same(t, rw, reporting)
+}
+
+func TestReportProgress(t *testing.T) {
+	errOut := &bytes.Buffer{}
+	rw := &readwriter.ReadWriter{Out: &bytes.Buffer{}, In: bytes.NewBufferString("push"), ErrOut: errOut}
+
+	cfg := newConfig()
+	cfg.Commands.ProgressInterval = config.YamlDuration(20 * time.Millisecond)
+	cmd := NewGitalyCommand(cfg, string(commandargs.ReceivePack), &accessverifier.Response{})
+
+	reporting, stop := cmd.ReportProgress(context.Background(), rw)
+
+	data, err := io.ReadAll(reporting.In)
+	require.NoError(t, err)
+	require.Equal(t, "push", string(data))
+
+	_, err = reporting.ErrOut.Write([]byte("remote: Resolving deltas\n"))
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	stop()
+
+	output := errOut.String()
+	require.Contains(t, output, "remote: Resolving deltas\n")
+	require.Regexp(t, `remote: Still processing, \d+s elapsed\.\.\.\n`, output)
+}
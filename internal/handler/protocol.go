@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+// ErrProtocolV2Required is returned for fetches not using Git protocol v2
+// when the config requires it
+var ErrProtocolV2Required = errors.New("Git protocol v2 is required for fetches, please upgrade Git or set protocol.version=2")
+
+// GitProtocol returns the GIT_PROTOCOL to pass on to Gitaly, applying the
+// protocol_v2 policy of the config. Only fetches use protocol v2, so the
+// policy doesn't apply to the other commands. Invalid values are dropped.
+func (gc *GitalyCommand) GitProtocol(ctx context.Context, env sshenv.Env) (string, error) {
+	command := gc.Command.ServiceName
+
+	version, err := sshenv.ProtocolVersion(env.GitProtocolVersion)
+	if err != nil {
+		metrics.GitProtocolRequestsTotal.WithLabelValues(command, "invalid").Inc()
+		logger.ContextLogger(ctx).WithError(err).Warn("Ignoring the Git protocol requested")
+
+		return "", nil
+	}
+
+	metrics.GitProtocolRequestsTotal.WithLabelValues(command, strconv.Itoa(version)).Inc()
+
+	if command != string(commandargs.UploadPack) {
+		return env.GitProtocolVersion, nil
+	}
+
+	switch gc.Config.Commands.ProtocolV2 {
+	case config.ProtocolV2Deny:
+		if version == 2 {
+			return "", nil
+		}
+	case config.ProtocolV2Require:
+		if version != 2 {
+			return "", ErrProtocolV2Required
+		}
+	}
+
+	return env.GitProtocolVersion, nil
+}
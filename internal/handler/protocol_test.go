@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+func TestGitProtocol(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		command          commandargs.CommandType
+		policy           string
+		requested        string
+		expectedProtocol string
+		expectedErr      error
+	}{
+		{desc: "v2 allowed", command: commandargs.UploadPack, requested: "version=2", expectedProtocol: "version=2"},
+		{desc: "v0 allowed", command: commandargs.UploadPack, policy: config.ProtocolV2Allow, expectedProtocol: ""},
+		{desc: "v2 denied", command: commandargs.UploadPack, policy: config.ProtocolV2Deny, requested: "version=2", expectedProtocol: ""},
+		{desc: "v1 with v2 denied", command: commandargs.UploadPack, policy: config.ProtocolV2Deny, requested: "version=1", expectedProtocol: "version=1"},
+		{desc: "v2 required", command: commandargs.UploadPack, policy: config.ProtocolV2Require, requested: "version=2", expectedProtocol: "version=2"},
+		{desc: "v1 with v2 required", command: commandargs.UploadPack, policy: config.ProtocolV2Require, requested: "version=1", expectedErr: ErrProtocolV2Required},
+		{desc: "push with v2 required", command: commandargs.ReceivePack, policy: config.ProtocolV2Require, expectedProtocol: ""},
+		{desc: "invalid", command: commandargs.UploadPack, requested: "version=3", expectedProtocol: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg := newConfig()
+			cfg.Commands.ProtocolV2 = tc.policy
+			cmd := NewGitalyCommand(cfg, string(tc.command), &accessverifier.Response{})
+
+			protocol, err := cmd.GitProtocol(context.Background(), sshenv.Env{GitProtocolVersion: tc.requested})
+			require.Equal(t, tc.expectedErr, err)
+			require.Equal(t, tc.expectedProtocol, protocol)
+		})
+	}
+}
+
+func TestGitProtocolMetrics(t *testing.T) {
+	v2 := metrics.GitProtocolRequestsTotal.WithLabelValues(string(commandargs.UploadPack), "2")
+	invalid := metrics.GitProtocolRequestsTotal.WithLabelValues(string(commandargs.UploadPack), "invalid")
+	initialV2, initialInvalid := testutil.ToFloat64(v2), testutil.ToFloat64(invalid)
+
+	cmd := NewGitalyCommand(newConfig(), string(commandargs.UploadPack), &accessverifier.Response{})
+
+	_, err := cmd.GitProtocol(context.Background(), sshenv.Env{GitProtocolVersion: "version=2"})
+	require.NoError(t, err)
+	_, err = cmd.GitProtocol(context.Background(), sshenv.Env{GitProtocolVersion: "version=9"})
+	require.NoError(t, err)
+
+	require.Equal(t, initialV2+1, testutil.ToFloat64(v2))
+	require.Equal(t, initialInvalid+1, testutil.ToFloat64(invalid))
+}
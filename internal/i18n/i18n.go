@@ -0,0 +1,145 @@
+// Package i18n translates the messages shown to users into the locale they
+// select with the LC_ALL, LC_MESSAGES or LANG environment variables, falling
+// back to the default locale of the instance, then to English. The catalogs
+// are embedded in the binary, one YAML file per locale mapping the English
+// messages to their translation. Messages missing from a catalog are shown in
+// English, as are the messages sent by GitLab.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultLocale is the locale of the messages in the source code
+const DefaultLocale = "en"
+
+// LocaleEnvs are the environment variables selecting the locale, by
+// precedence
+var LocaleEnvs = []string{"LC_ALL", "LC_MESSAGES", "LANG"}
+
+//go:embed locales/*.yml
+var catalogFiles embed.FS
+
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	files, err := catalogFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	catalogs := make(map[string]map[string]string, len(files))
+	for _, file := range files {
+		data, err := catalogFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		catalog := map[string]string{}
+		if err := yaml.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalog %s: %v", file.Name(), err))
+		}
+
+		catalogs[strings.TrimSuffix(file.Name(), path.Ext(file.Name()))] = catalog
+	}
+
+	return catalogs
+}
+
+// Locales returns the locales messages can be translated into
+func Locales() []string {
+	locales := []string{DefaultLocale}
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales[1:])
+
+	return locales
+}
+
+// Supported returns the locale with a catalog matching locale, e.g. pt for
+// pt_BR.UTF-8, and whether there's one
+func Supported(locale string) (string, bool) {
+	// Strip the codeset and modifier, e.g. de_DE.UTF-8@euro
+	locale, _, _ = strings.Cut(locale, ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	locale = strings.ReplaceAll(locale, "-", "_")
+
+	if locale == "" || locale == "C" || locale == "POSIX" {
+		return "", false
+	}
+
+	language, _, _ := strings.Cut(locale, "_")
+	if language == DefaultLocale {
+		return DefaultLocale, true
+	}
+
+	for _, candidate := range []string{locale, language} {
+		if _, ok := catalogs[candidate]; ok {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+// Select returns the locale of the first of the values of LocaleEnvs
+// supported, the default locale of the instance otherwise
+func Select(instanceDefault string, getenv func(string) string) string {
+	for _, env := range LocaleEnvs {
+		if locale, ok := Supported(getenv(env)); ok {
+			return locale
+		}
+	}
+
+	if locale, ok := Supported(instanceDefault); ok {
+		return locale
+	}
+
+	return DefaultLocale
+}
+
+type localeKey struct{}
+
+// ContextWithLocale returns a copy of ctx whose messages are translated into
+// locale
+func ContextWithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// LocaleFromContext returns the locale of ctx, DefaultLocale when none is set
+func LocaleFromContext(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeKey{}).(string); ok {
+		return locale
+	}
+
+	return DefaultLocale
+}
+
+// Translate returns message translated into locale, e.g. de_DE.UTF-8, or
+// message when it isn't translated
+func Translate(locale, message string) string {
+	locale, _ = Supported(locale)
+	if translation, ok := catalogs[locale][message]; ok && translation != "" {
+		return translation
+	}
+
+	return message
+}
+
+// T returns message translated into the locale of ctx
+func T(ctx context.Context, message string) string {
+	return Translate(LocaleFromContext(ctx), message)
+}
+
+// Sprintf formats according to format translated into the locale of ctx
+func Sprintf(ctx context.Context, format string, args ...interface{}) string {
+	return fmt.Sprintf(T(ctx, format), args...)
+}
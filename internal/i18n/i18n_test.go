@@ -0,0 +1,78 @@
+package i18n
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupported(t *testing.T) {
+	for _, tc := range []struct {
+		locale   string
+		expected string
+	}{
+		{locale: "de_DE.UTF-8", expected: "de"},
+		{locale: "de-AT", expected: "de"},
+		{locale: "fr_FR.UTF-8@euro", expected: "fr"},
+		{locale: "pt_BR.UTF-8", expected: "pt_BR"},
+		{locale: "en_US.UTF-8", expected: "en"},
+		{locale: "pt_PT.UTF-8"},
+		{locale: "C.UTF-8"},
+		{locale: "POSIX"},
+		{locale: ""},
+	} {
+		t.Run(tc.locale, func(t *testing.T) {
+			locale, ok := Supported(tc.locale)
+			require.Equal(t, tc.expected, locale)
+			require.Equal(t, tc.expected != "", ok)
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	env := func(values map[string]string) func(string) string {
+		return func(name string) string { return values[name] }
+	}
+
+	require.Equal(t, "ja", Select("de", env(map[string]string{"LC_ALL": "ja_JP.UTF-8", "LANG": "fr_FR.UTF-8"})))
+	require.Equal(t, "fr", Select("de", env(map[string]string{"LC_ALL": "C", "LANG": "fr_FR.UTF-8"})))
+	require.Equal(t, "de", Select("de", env(map[string]string{"LANG": "pt_PT.UTF-8"})))
+	require.Equal(t, "en", Select("", env(nil)))
+}
+
+func TestTranslate(t *testing.T) {
+	ctx := ContextWithLocale(context.Background(), "de")
+
+	require.Equal(t, "Einmalpasswort: ", T(ctx, "OTP: "))
+	require.Equal(t, "Ihr SSH-Schlüssel läuft in 3 Tagen ab. Fügen Sie einen neuen Schlüssel hinzu, um den Zugriff nicht zu verlieren.",
+		Sprintf(ctx, "Your SSH key expires in %d days. Add a new key to avoid losing access.", 3))
+	require.Equal(t, "Not translated", T(ctx, "Not translated"))
+	require.Equal(t, "OTP: ", T(context.Background(), "OTP: "))
+}
+
+var verbRegexp = regexp.MustCompile(`%[a-z]`)
+
+// TestCatalogs checks the catalogs translate the same messages, keeping
+// their placeholders and newlines
+func TestCatalogs(t *testing.T) {
+	reference := catalogs["de"]
+	require.NotEmpty(t, reference)
+
+	for locale, catalog := range catalogs {
+		t.Run(locale, func(t *testing.T) {
+			require.Len(t, catalog, len(reference))
+
+			for message, translation := range catalog {
+				require.Contains(t, reference, message)
+				require.Equal(t, verbRegexp.FindAllString(message, -1), verbRegexp.FindAllString(translation, -1), message)
+				require.Equal(t, message[len(message)-1] == '\n', translation[len(translation)-1] == '\n', message)
+			}
+		})
+	}
+}
+
+func TestLocales(t *testing.T) {
+	require.Equal(t, []string{"en", "de", "es", "fr", "ja", "pt_BR"}, Locales())
+}
@@ -0,0 +1,63 @@
+package keyfilter
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// bloom is a Bloom filter of SHA256 digests. The digests are uniformly
+// distributed already, so their halves are used as the two base hashes of
+// the double hashing scheme instead of hashing them again.
+type bloom struct {
+	bits   []uint64
+	size   uint64
+	hashes uint64
+}
+
+// newBloom returns a filter sized for n digests with the given false
+// positive rate
+func newBloom(n int, falsePositiveRate float64) *bloom {
+	if n < 1 {
+		n = 1
+	}
+
+	size := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if size < 64 {
+		size = 64
+	}
+
+	hashes := uint64(math.Round(float64(size) / float64(n) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+
+	return &bloom{bits: make([]uint64, (size+63)/64), size: size, hashes: hashes}
+}
+
+func (b *bloom) add(digest []byte) {
+	h1, h2 := baseHashes(digest)
+
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % b.size
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *bloom) mayContain(digest []byte) bool {
+	h1, h2 := baseHashes(digest)
+
+	for i := uint64(0); i < b.hashes; i++ {
+		bit := (h1 + i*h2) % b.size
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func baseHashes(digest []byte) (uint64, uint64) {
+	// An odd second hash can't be a multiple of a power of two sized filter,
+	// which would make every probe hit the same bit
+	return binary.BigEndian.Uint64(digest[0:8]), binary.BigEndian.Uint64(digest[8:16]) | 1
+}
@@ -0,0 +1,42 @@
+package keyfilter
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func digest(i int) []byte {
+	sum := sha256.Sum256([]byte(strconv.Itoa(i)))
+
+	return sum[:]
+}
+
+func TestBloom(t *testing.T) {
+	const n = 10000
+
+	b := newBloom(n, 0.01)
+	for i := 0; i < n; i++ {
+		b.add(digest(i))
+	}
+
+	for i := 0; i < n; i++ {
+		require.True(t, b.mayContain(digest(i)))
+	}
+
+	falsePositives := 0
+	for i := n; i < 2*n; i++ {
+		if b.mayContain(digest(i)) {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, n*2/100)
+}
+
+func TestEmptyBloom(t *testing.T) {
+	b := newBloom(0, 0.01)
+
+	require.False(t, b.mayContain(digest(1)))
+}
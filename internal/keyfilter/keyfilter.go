@@ -0,0 +1,166 @@
+// Package keyfilter keeps a Bloom filter of the keys that can be used to
+// authenticate, so that keys unknown to GitLab, such as the ones offered by
+// scanners, are rejected without querying the internal API.
+package keyfilter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/authorizedkeys"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	defaultRefreshInterval   = time.Minute
+	defaultFalsePositiveRate = 0.01
+
+	// maxMissedRefreshes bounds how long a filter that can't be refreshed is
+	// used, so that keys added in the meantime aren't rejected indefinitely
+	maxMissedRefreshes = 3
+)
+
+// Filter tells whether a key may be known to GitLab. A nil *Filter is valid
+// and lets every key through.
+type Filter struct {
+	client            *authorizedkeys.Client
+	refreshInterval   time.Duration
+	falsePositiveRate float64
+
+	mu          sync.RWMutex
+	bloom       *bloom
+	refreshedAt time.Time
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// New returns a Filter using client to fetch the fingerprints of valid keys,
+// or nil if the filter isn't enabled
+func New(cfg config.KeyFilterConfig, client *authorizedkeys.Client) *Filter {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	f := &Filter{
+		client:            client,
+		refreshInterval:   time.Duration(cfg.RefreshInterval),
+		falsePositiveRate: cfg.FalsePositiveRate,
+		now:               time.Now,
+	}
+
+	if f.refreshInterval <= 0 {
+		f.refreshInterval = defaultRefreshInterval
+	}
+	if f.falsePositiveRate <= 0 {
+		f.falsePositiveRate = defaultFalsePositiveRate
+	}
+
+	return f
+}
+
+// Run refreshes the filter periodically until ctx is done
+func (f *Filter) Run(ctx context.Context) {
+	if f == nil {
+		return
+	}
+
+	ticker := time.NewTicker(f.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.Refresh(ctx); err != nil {
+			logger.WithContextFields(ctx, log.Fields{}).WithError(err).Warn("keyfilter: failed to refresh the key fingerprints")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh replaces the filter with one built from the fingerprints currently
+// returned by the internal API. The previous filter is kept on failure.
+func (f *Filter) Refresh(ctx context.Context) error {
+	response, err := f.client.GetFingerprints(ctx)
+	if err != nil {
+		metrics.SshdKeyFilterRefreshesTotal.WithLabelValues("failure").Inc()
+		return err
+	}
+
+	b := newBloom(len(response.Fingerprints), f.falsePositiveRate)
+	invalid := 0
+	for _, fingerprint := range response.Fingerprints {
+		digest, ok := parseFingerprint(fingerprint)
+		if !ok {
+			invalid++
+			continue
+		}
+
+		b.add(digest)
+	}
+
+	if invalid > 0 {
+		logger.WithContextFields(ctx, log.Fields{"invalid_fingerprints": invalid}).Warn("keyfilter: ignored invalid key fingerprints")
+	}
+
+	f.mu.Lock()
+	f.bloom = b
+	f.refreshedAt = f.now()
+	f.mu.Unlock()
+
+	metrics.SshdKeyFilterRefreshesTotal.WithLabelValues("success").Inc()
+
+	return nil
+}
+
+// MayContain returns false when key is certainly unknown to GitLab. Every key
+// is let through until the filter has been fetched, or when it's stale.
+func (f *Filter) MayContain(key ssh.PublicKey) bool {
+	if f == nil {
+		return true
+	}
+
+	f.mu.RLock()
+	b := f.bloom
+	stale := f.now().Sub(f.refreshedAt) > maxMissedRefreshes*f.refreshInterval
+	f.mu.RUnlock()
+
+	if b == nil || stale {
+		metrics.SshdKeyFilterChecksTotal.WithLabelValues("unavailable").Inc()
+		return true
+	}
+
+	digest := sha256.Sum256(key.Marshal())
+	if !b.mayContain(digest[:]) {
+		metrics.SshdKeyFilterChecksTotal.WithLabelValues("rejected").Inc()
+		return false
+	}
+
+	metrics.SshdKeyFilterChecksTotal.WithLabelValues("passed").Inc()
+
+	return true
+}
+
+// parseFingerprint decodes a fingerprint in the format of
+// ssh.FingerprintSHA256
+func parseFingerprint(fingerprint string) ([]byte, bool) {
+	digest, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(fingerprint, "SHA256:"))
+	if err != nil || len(digest) != sha256.Size {
+		return nil, false
+	}
+
+	return digest, true
+}
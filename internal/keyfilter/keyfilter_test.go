@@ -0,0 +1,90 @@
+package keyfilter
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/authorizedkeys"
+)
+
+func publicKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	return key
+}
+
+func setup(t *testing.T, fingerprints *[]string) *Filter {
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/authorized_keys/fingerprints",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				if *fingerprints == nil {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+
+				json.NewEncoder(w).Encode(&authorizedkeys.FingerprintsResponse{Fingerprints: *fingerprints})
+			},
+		},
+	}
+
+	cfg := &config.Config{GitlabUrl: testserver.StartSocketHttpServer(t, requests)}
+	client, err := authorizedkeys.NewClient(cfg)
+	require.NoError(t, err)
+
+	return New(config.KeyFilterConfig{Enabled: true}, client)
+}
+
+func TestMayContain(t *testing.T) {
+	known := publicKey(t)
+	unknown := publicKey(t)
+
+	fingerprints := []string{ssh.FingerprintSHA256(known), "invalid"}
+	filter := setup(t, &fingerprints)
+
+	now := time.Now()
+	filter.now = func() time.Time { return now }
+
+	// Every key is let through until the fingerprints are fetched
+	require.True(t, filter.MayContain(unknown))
+
+	require.NoError(t, filter.Refresh(context.Background()))
+	require.True(t, filter.MayContain(known))
+	require.False(t, filter.MayContain(unknown))
+
+	// The previous filter is kept when it can't be refreshed
+	fingerprints = nil
+	require.Error(t, filter.Refresh(context.Background()))
+	require.False(t, filter.MayContain(unknown))
+
+	// Until it becomes stale
+	now = now.Add(maxMissedRefreshes*defaultRefreshInterval + time.Second)
+	require.True(t, filter.MayContain(unknown))
+
+	fingerprints = []string{ssh.FingerprintSHA256(unknown)}
+	require.NoError(t, filter.Refresh(context.Background()))
+	require.False(t, filter.MayContain(known))
+	require.True(t, filter.MayContain(unknown))
+}
+
+func TestDisabledFilter(t *testing.T) {
+	filter := New(config.KeyFilterConfig{}, nil)
+
+	require.Nil(t, filter)
+	require.True(t, filter.MayContain(publicKey(t)))
+	filter.Run(context.Background())
+}
@@ -6,6 +6,7 @@ import (
 	"path"
 	"regexp"
 	"strings"
+	"text/template"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/executable"
@@ -19,13 +20,36 @@ const (
 	PublicKeyPrefix = "key"
 	PrincipalPrefix = "username"
 	SshOptions      = "no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty"
+
+	// DefaultTemplate is the template of the lines when no other is configured
+	DefaultTemplate = `command="{{.Command}}",{{.Options}} {{.Value}}`
 )
 
+// restrictingOptions are the options that can be added to the line of a key,
+// keyed by their lowercase name and telling whether they take a value. They
+// only restrict what the key can be used for, so that neither the forced
+// command nor the default options can be overridden.
+var restrictingOptions = map[string]bool{
+	"no-port-forwarding":  false,
+	"no-x11-forwarding":   false,
+	"no-agent-forwarding": false,
+	"no-pty":              false,
+	"no-user-rc":          false,
+	"restrict":            false,
+	"verify-required":     false,
+	"expiry-time":         true,
+	"from":                true,
+	"permitopen":          true,
+	"permitlisten":        true,
+}
+
 type KeyLine struct {
 	Id     string // This can be either an ID of a Key or username
 	Value  string // This can be either a public key or a principal name
 	Prefix string
 	Config *config.Config
+	// Options are added to the default SshOptions, e.g. expiry-time="20300101"
+	Options []string
 }
 
 func NewPublicKeyLine(id, publicKey string, config *config.Config) (*KeyLine, error) {
@@ -36,10 +60,92 @@ func NewPrincipalKeyLine(keyId, principal string, config *config.Config) (*KeyLi
 	return newKeyLine(keyId, principal, PrincipalPrefix, config)
 }
 
-func (k *KeyLine) ToString() string {
-	command := fmt.Sprintf("%s %s-%s", path.Join(k.Config.RootDir, executable.BinDir, executable.GitlabShell), k.Prefix, k.Id)
+// templateData is what the template of the lines is executed with
+type templateData struct {
+	// Executable is the path to gitlab-shell
+	Executable string
+	// Argument tells gitlab-shell which key or user logged in, e.g. key-1
+	Argument string
+	// Command is the executable followed by its argument
+	Command string
+	// Options restrict what the key can be used for
+	Options string
+	// Value is the public key or the principal
+	Value string
+}
+
+// ToString renders the line with the configured authorized_keys template
+func (k *KeyLine) ToString() (string, error) {
+	text := k.Config.AuthorizedKeys.Template
+	if text == "" {
+		text = DefaultTemplate
+	}
+
+	tmpl, err := template.New("authorized_keys").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorized_keys template: %w", err)
+	}
+
+	options := SshOptions
+	for _, option := range k.Options {
+		if !strings.Contains(","+strings.ToLower(SshOptions)+",", ","+strings.ToLower(option)+",") {
+			options += "," + option
+		}
+	}
+
+	data := templateData{
+		Executable: path.Join(k.Config.RootDir, executable.BinDir, executable.GitlabShell),
+		Argument:   fmt.Sprintf("%s-%s", k.Prefix, k.Id),
+		Options:    options,
+		Value:      k.Value,
+	}
+	data.Command = data.Executable + " " + data.Argument
+
+	var line strings.Builder
+	if err := tmpl.Execute(&line, data); err != nil {
+		return "", fmt.Errorf("invalid authorized_keys template: %w", err)
+	}
+
+	if strings.ContainsAny(line.String(), "\r\n") {
+		return "", errors.New("invalid authorized_keys template: the line must not span several lines")
+	}
 
-	return fmt.Sprintf(`command="%s",%s %s`, command, SshOptions, k.Value)
+	return line.String(), nil
+}
+
+// AddOptions adds options restricting the key, which OpenSSH enforces
+func (k *KeyLine) AddOptions(options []string) error {
+	for _, option := range options {
+		if err := validateOption(option); err != nil {
+			return err
+		}
+	}
+
+	k.Options = append(k.Options, options...)
+
+	return nil
+}
+
+func validateOption(option string) error {
+	name, value, hasValue := strings.Cut(option, "=")
+
+	takesValue, ok := restrictingOptions[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("Invalid option: %s", option)
+	}
+	if takesValue != hasValue {
+		return fmt.Errorf("Invalid option: %s", option)
+	}
+
+	if hasValue {
+		unquoted, quoted := strings.CutPrefix(value, `"`)
+		unquoted, closed := strings.CutSuffix(unquoted, `"`)
+		if !quoted || !closed || unquoted == "" || strings.ContainsAny(unquoted, "\"\\\r\n") {
+			return fmt.Errorf("Invalid option: %s", option)
+		}
+	}
+
+	return nil
 }
 
 func newKeyLine(id, value, prefix string, config *config.Config) (*KeyLine, error) {
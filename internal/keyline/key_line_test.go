@@ -77,6 +77,58 @@ func TestToString(t *testing.T) {
 		Config: &config.Config{RootDir: "/tmp"},
 	}
 
-	result := keyLine.ToString()
+	result, err := keyLine.ToString()
+	require.NoError(t, err)
 	require.Equal(t, `command="/tmp/bin/gitlab-shell key-1",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty public-key`, result)
 }
+
+func TestAddOptions(t *testing.T) {
+	keyLine := &KeyLine{
+		Id:     "1",
+		Value:  "public-key",
+		Prefix: "key",
+		Config: &config.Config{RootDir: "/tmp"},
+	}
+
+	require.NoError(t, keyLine.AddOptions([]string{"no-X11-forwarding", "no-user-rc", `expiry-time="20300101"`, `from="10.0.0.0/8,!10.1.0.0/16"`}))
+
+	result, err := keyLine.ToString()
+	require.NoError(t, err)
+	require.Equal(t, `command="/tmp/bin/gitlab-shell key-1",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty,no-user-rc,expiry-time="20300101",from="10.0.0.0/8,!10.1.0.0/16" public-key`, result)
+
+	for _, option := range []string{
+		`command="/bin/sh"`,
+		`environment="PATH=/tmp"`,
+		"pty",
+		"expiry-time",
+		"no-pty=yes",
+		`expiry-time=20300101`,
+		`from="10.0.0.1" command="/bin/sh"`,
+		"from=\"10.0.0.1\"\nssh-rsa",
+	} {
+		require.EqualError(t, keyLine.AddOptions([]string{option}), "Invalid option: "+option)
+	}
+	require.Len(t, keyLine.Options, 4)
+}
+
+func TestToStringWithTemplate(t *testing.T) {
+	keyLine := &KeyLine{
+		Id:     "1",
+		Value:  "public-key",
+		Prefix: "key",
+		Config: &config.Config{RootDir: "/opt/gitlab-shell"},
+	}
+
+	keyLine.Config.AuthorizedKeys.Template = `command="env GITLAB_SHELL_DIR=/srv {{.Executable}} {{.Argument}}",{{.Options}} {{.Value}}`
+	result, err := keyLine.ToString()
+	require.NoError(t, err)
+	require.Equal(t, `command="env GITLAB_SHELL_DIR=/srv /opt/gitlab-shell/bin/gitlab-shell key-1",no-port-forwarding,no-X11-forwarding,no-agent-forwarding,no-pty public-key`, result)
+
+	keyLine.Config.AuthorizedKeys.Template = `command="{{.Command}}" {{.Unknown}}`
+	_, err = keyLine.ToString()
+	require.ErrorContains(t, err, "invalid authorized_keys template")
+
+	keyLine.Config.AuthorizedKeys.Template = `command="{{.Command}}"{{"\n"}}{{.Value}}`
+	_, err = keyLine.ToString()
+	require.EqualError(t, err, "invalid authorized_keys template: the line must not span several lines")
+}
@@ -0,0 +1,338 @@
+// Package loadtest implements `gitlab-sshd loadtest`, which generates SSH
+// traffic against a server to validate tuning changes: concurrent clients
+// connect and fetch a test repository with git-upload-pack, and the latencies
+// of the fetches are reported.
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
+)
+
+// Subcommand is the argument of gitlab-sshd running the load test
+const Subcommand = "loadtest"
+
+const dialTimeout = 30 * time.Second
+
+// percentiles are the latency percentiles reported
+var percentiles = []float64{50, 90, 95, 99}
+
+// Config configures a load test
+type Config struct {
+	// Address is the host:port of the SSH server
+	Address string
+	User    string
+	// IdentityFile is the private key the clients authenticate with
+	IdentityFile string
+	// KnownHostsFile verifies the host key of the server. The host key isn't
+	// verified when it's empty.
+	KnownHostsFile string
+	// Repository is the path of the repository to fetch, e.g. group/project.git
+	Repository string
+	// Concurrency is the number of concurrent clients
+	Concurrency int
+	// Requests is the total number of fetches. The clients keep fetching until
+	// Duration elapsed when it's zero.
+	Requests int
+	Duration time.Duration
+	// RefsOnly stops after the refs are advertised, like git ls-remote,
+	// instead of fetching a packfile
+	RefsOnly bool
+}
+
+// Report holds the results of a load test
+type Report struct {
+	Requests  int
+	Failures  int
+	Bytes     int64
+	Elapsed   time.Duration
+	Latencies []time.Duration
+	// Errors counts the failures by error message
+	Errors map[string]int
+}
+
+type result struct {
+	latency time.Duration
+	bytes   int64
+	err     error
+}
+
+// Run runs the load test until the requests were made, or the duration
+// elapsed, or ctx is done
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	clientConfig, err := clientConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Concurrency <= 0 {
+		return nil, errors.New("the concurrency must be positive")
+	}
+	if cfg.Requests <= 0 && cfg.Duration <= 0 {
+		return nil, errors.New("either a number of requests or a duration is required")
+	}
+
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	var started int64
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				if cfg.Requests > 0 && atomic.AddInt64(&started, 1) > int64(cfg.Requests) {
+					return
+				}
+
+				start := time.Now()
+				n, err := fetch(ctx, cfg, clientConfig)
+				// Fetches interrupted by the end of the test aren't counted
+				if ctx.Err() != nil {
+					return
+				}
+
+				results <- result{latency: time.Since(start), bytes: n, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	start := time.Now()
+	report := &Report{Errors: map[string]int{}}
+	for r := range results {
+		report.Requests++
+		if r.err != nil {
+			report.Failures++
+			report.Errors[r.err.Error()]++
+			continue
+		}
+
+		report.Bytes += r.bytes
+		report.Latencies = append(report.Latencies, r.latency)
+	}
+	report.Elapsed = time.Since(start)
+
+	sort.Slice(report.Latencies, func(i, j int) bool { return report.Latencies[i] < report.Latencies[j] })
+
+	return report, nil
+}
+
+func clientConfig(cfg Config) (*ssh.ClientConfig, error) {
+	key, err := os.ReadFile(cfg.IdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the identity file: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the identity file: %w", err)
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if cfg.KnownHostsFile != "" {
+		hostKeyCallback, err = knownhosts.New(cfg.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the known hosts: %w", err)
+		}
+	}
+
+	return &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         dialTimeout,
+	}, nil
+}
+
+// fetch connects to the server and fetches the default branch of the
+// repository, returning the bytes received
+func fetch(ctx context.Context, cfg Config, clientConfig *ssh.ClientConfig) (int64, error) {
+	client, err := ssh.Dial("tcp", cfg.Address, clientConfig)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	// Closing the connection interrupts the fetch when ctx is done
+	fetched := make(chan struct{})
+	defer close(fetched)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-fetched:
+		}
+	}()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := session.Start(uploadPackCommand(cfg.Repository)); err != nil {
+		return 0, err
+	}
+
+	counter := &countingReader{r: stdout}
+	if err := negotiate(counter, stdin, cfg.RefsOnly); err != nil {
+		return counter.n, err
+	}
+
+	return counter.n, session.Wait()
+}
+
+// negotiate reads the refs advertised by git-upload-pack and, unless only
+// the refs are wanted, asks for the commit of the first one, which is HEAD
+// for non-empty repositories, and reads the packfile
+func negotiate(r io.Reader, w io.WriteCloser, refsOnly bool) error {
+	scanner := pktline.NewScanner(r)
+
+	var want string
+	for scanner.Scan() {
+		pkt := scanner.Bytes()
+		if pktline.IsFlush(pkt) {
+			break
+		}
+
+		if want == "" {
+			line := string(pktline.Payload(pkt))
+			if strings.HasPrefix(line, "ERR ") {
+				return errors.New(strings.TrimSpace(line))
+			}
+
+			oid, _, _ := strings.Cut(line, " ")
+			want = oid
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if want == "" {
+		return errors.New("git-upload-pack didn't advertise any ref")
+	}
+
+	if refsOnly || strings.Trim(want, "0") == "" {
+		if err := pktline.WriteFlush(w); err != nil {
+			return err
+		}
+
+		return w.Close()
+	}
+
+	if err := pktline.WriteString(w, "want "+want+"\n"); err != nil {
+		return err
+	}
+	if err := pktline.WriteFlush(w); err != nil {
+		return err
+	}
+	if _, err := w.Write(pktline.PktDone()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	// The NAK is followed by the packfile, up to the end of the output
+	_, err := io.Copy(io.Discard, r)
+
+	return err
+}
+
+func uploadPackCommand(repository string) string {
+	quoted := strings.ReplaceAll(strings.TrimPrefix(repository, "/"), "'", `'\''`)
+
+	return fmt.Sprintf("git-upload-pack '%s'", quoted)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// Percentile returns the latency below which p percent of the successful
+// requests completed, using the nearest-rank method
+func (r *Report) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p/100*float64(len(r.Latencies)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(r.Latencies) {
+		rank = len(r.Latencies) - 1
+	}
+
+	return r.Latencies[rank]
+}
+
+// Write writes the report in a human readable form
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "Requests:   %d (%d failed)\n", r.Requests, r.Failures)
+	if r.Elapsed > 0 {
+		fmt.Fprintf(w, "Throughput: %.1f requests/s, %.1f KiB/s\n",
+			float64(r.Requests)/r.Elapsed.Seconds(), float64(r.Bytes)/1024/r.Elapsed.Seconds())
+	}
+
+	if len(r.Latencies) > 0 {
+		fmt.Fprintf(w, "Latency:   ")
+		for _, p := range percentiles {
+			fmt.Fprintf(w, " p%g=%s", p, r.Percentile(p).Round(time.Millisecond))
+		}
+		fmt.Fprintf(w, " max=%s\n", r.Latencies[len(r.Latencies)-1].Round(time.Millisecond))
+	}
+
+	messages := make([]string, 0, len(r.Errors))
+	for message := range r.Errors {
+		messages = append(messages, message)
+	}
+	sort.Strings(messages)
+
+	for _, message := range messages {
+		fmt.Fprintf(w, "Error:      %dx %s\n", r.Errors[message], message)
+	}
+}
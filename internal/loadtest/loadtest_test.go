@@ -0,0 +1,194 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
+)
+
+const (
+	oid          = "3b13818e8330f68625d80d9bf5d8049c41fbe197"
+	fakePackfile = "PACK\x00\x00\x00\x02\x00\x00\x00\x00"
+)
+
+// startServer starts an SSH server answering git-upload-pack of project.git
+// like git does, and returns its address and the identity file of a client
+func startServer(t *testing.T) (string, string) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+
+	srvCfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) { return nil, nil },
+	}
+	srvCfg.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go serve(conn, srvCfg)
+		}
+	}()
+
+	_, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	require.NoError(t, err)
+
+	identityFile := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(identityFile, pem.EncodeToMemory(block), 0o600))
+
+	return l.Addr().String(), identityFile
+}
+
+func serve(conn net.Conn, srvCfg *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, srvCfg)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		for req := range requests {
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(req.Type == "exec", nil)
+
+			status := uploadPack(channel, payload.Command)
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			channel.Close()
+		}
+	}
+}
+
+func uploadPack(channel ssh.Channel, command string) uint32 {
+	if command != "git-upload-pack 'project.git'" {
+		pktline.WriteString(channel, "ERR Repository not found\n")
+		return 1
+	}
+
+	pktline.WriteString(channel, oid+" HEAD\x00multi_ack side-band-64k\n")
+	pktline.WriteString(channel, oid+" refs/heads/main\n")
+	pktline.WriteFlush(channel)
+
+	request, _ := io.ReadAll(channel)
+	switch string(request) {
+	case "0000":
+	case "0032want " + oid + "\n0000" + string(pktline.PktDone()):
+		pktline.WriteString(channel, "NAK\n")
+		io.WriteString(channel, fakePackfile)
+	default:
+		return 128
+	}
+
+	return 0
+}
+
+func TestRun(t *testing.T) {
+	address, identityFile := startServer(t)
+	cfg := Config{
+		Address:      address,
+		User:         "git",
+		IdentityFile: identityFile,
+		Repository:   "/project.git",
+		Concurrency:  3,
+		Requests:     10,
+	}
+
+	report, err := Run(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Equal(t, 10, report.Requests)
+	require.Zero(t, report.Failures)
+	require.Len(t, report.Latencies, 10)
+	require.EqualValues(t, 10*(int64(len("0031"+oid+" HEAD\x00multi_ack side-band-64k\n")+len("003f"+oid+" refs/heads/main\n"))+
+		int64(len("0000")+len("0008NAK\n")+len(fakePackfile))), report.Bytes)
+
+	cfg.RefsOnly = true
+	report, err = Run(context.Background(), cfg)
+	require.NoError(t, err)
+	require.Zero(t, report.Failures)
+	require.EqualValues(t, 10*int64(len("0031"+oid+" HEAD\x00multi_ack side-band-64k\n")+len("003f"+oid+" refs/heads/main\n")+len("0000")), report.Bytes)
+}
+
+func TestRunDuration(t *testing.T) {
+	address, identityFile := startServer(t)
+
+	report, err := Run(context.Background(), Config{
+		Address:      address,
+		User:         "git",
+		IdentityFile: identityFile,
+		Repository:   "missing.git",
+		Concurrency:  2,
+		Duration:     200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.Positive(t, report.Requests)
+	require.Equal(t, report.Requests, report.Failures)
+	require.Equal(t, map[string]int{"ERR Repository not found": report.Requests}, report.Errors)
+}
+
+func TestRunValidation(t *testing.T) {
+	_, identityFile := startServer(t)
+
+	_, err := Run(context.Background(), Config{IdentityFile: identityFile, Requests: 1})
+	require.EqualError(t, err, "the concurrency must be positive")
+
+	_, err = Run(context.Background(), Config{IdentityFile: identityFile, Concurrency: 1})
+	require.EqualError(t, err, "either a number of requests or a duration is required")
+
+	_, err = Run(context.Background(), Config{IdentityFile: filepath.Join(t.TempDir(), "missing"), Concurrency: 1, Requests: 1})
+	require.ErrorContains(t, err, "failed to read the identity file")
+}
+
+func TestReport(t *testing.T) {
+	report := &Report{
+		Requests: 5,
+		Failures: 1,
+		Bytes:    4096,
+		Elapsed:  2 * time.Second,
+		Latencies: []time.Duration{
+			10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 400 * time.Millisecond,
+		},
+		Errors: map[string]int{"ssh: handshake failed: EOF": 1},
+	}
+
+	require.Equal(t, 20*time.Millisecond, report.Percentile(50))
+	require.Equal(t, 400*time.Millisecond, report.Percentile(99))
+	require.Equal(t, 10*time.Millisecond, report.Percentile(0))
+
+	var b bytes.Buffer
+	report.Write(&b)
+	require.Equal(t, `Requests:   5 (1 failed)
+Throughput: 2.5 requests/s, 2.0 KiB/s
+Latency:    p50=20ms p90=400ms p95=400ms p99=400ms max=400ms
+Error:      1x ssh: handshake failed: EOF
+`, b.String())
+}
@@ -0,0 +1,83 @@
+// Package logcontext attaches log fields to the context of a session. It
+// doesn't depend on the configuration, so that the packages config imports,
+// like client, can log them too. The others use the logger package.
+package logcontext
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+type sessionFieldsKey struct{}
+
+// sessionFields is shared by every context derived from the one it was
+// attached to, so fields added deep inside a command are also visible to the
+// session that started it.
+type sessionFields struct {
+	mu     sync.RWMutex
+	fields log.Fields
+}
+
+// ContextWithSessionFields returns a copy of ctx that carries a set of log fields
+// for the current session. The fields are included by ContextLogger and
+// WithContextFields in addition to the correlation ID.
+func ContextWithSessionFields(ctx context.Context, fields log.Fields) context.Context {
+	sf := &sessionFields{fields: log.Fields{}}
+	for k, v := range fields {
+		sf.fields[k] = v
+	}
+
+	return context.WithValue(ctx, sessionFieldsKey{}, sf)
+}
+
+// AddSessionFields adds fields to the session carried by ctx. It is a no-op
+// when ctx has no session fields attached.
+func AddSessionFields(ctx context.Context, fields log.Fields) {
+	sf, ok := ctx.Value(sessionFieldsKey{}).(*sessionFields)
+	if !ok {
+		return
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	for k, v := range fields {
+		sf.fields[k] = v
+	}
+}
+
+// SessionFields returns a copy of the session fields carried by ctx.
+func SessionFields(ctx context.Context) log.Fields {
+	fields := log.Fields{}
+	if ctx == nil {
+		return fields
+	}
+
+	sf, ok := ctx.Value(sessionFieldsKey{}).(*sessionFields)
+	if !ok {
+		return fields
+	}
+
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+
+	for k, v := range sf.fields {
+		fields[k] = v
+	}
+
+	return fields
+}
+
+// ContextLogger returns a log entry with the correlation ID and the session
+// fields carried by ctx.
+func ContextLogger(ctx context.Context) *logrus.Entry {
+	return log.ContextLogger(ctx).WithFields(SessionFields(ctx))
+}
+
+// WithContextFields is like ContextLogger but also adds the given fields.
+func WithContextFields(ctx context.Context, fields log.Fields) *logrus.Entry {
+	return ContextLogger(ctx).WithFields(fields)
+}
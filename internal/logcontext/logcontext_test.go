@@ -0,0 +1,38 @@
+package logcontext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/correlation"
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+func TestSessionFields(t *testing.T) {
+	ctx := correlation.ContextWithCorrelation(context.Background(), "a-correlation-id")
+	ctx = ContextWithSessionFields(ctx, log.Fields{"gl_key_id": "1"})
+
+	// Fields added through a derived context are visible to the parent
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	AddSessionFields(childCtx, log.Fields{"command": "git-upload-pack"})
+
+	require.Equal(t, log.Fields{"gl_key_id": "1", "command": "git-upload-pack"}, SessionFields(ctx))
+
+	entry := WithContextFields(ctx, log.Fields{"extra": true})
+	require.Equal(t, "a-correlation-id", entry.Data[correlation.FieldName])
+	require.Equal(t, "1", entry.Data["gl_key_id"])
+	require.Equal(t, "git-upload-pack", entry.Data["command"])
+	require.Equal(t, true, entry.Data["extra"])
+}
+
+func TestSessionFieldsWithoutSession(t *testing.T) {
+	ctx := context.Background()
+
+	AddSessionFields(ctx, log.Fields{"command": "discover"})
+
+	require.Empty(t, SessionFields(ctx))
+	require.Empty(t, SessionFields(nil))
+	require.NotContains(t, ContextLogger(ctx).Data, "command")
+}
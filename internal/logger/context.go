@@ -0,0 +1,39 @@
+package logger
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logcontext"
+)
+
+// ContextWithSessionFields returns a copy of ctx that carries a set of log fields
+// for the current session. The fields are included by ContextLogger and
+// WithContextFields in addition to the correlation ID.
+func ContextWithSessionFields(ctx context.Context, fields log.Fields) context.Context {
+	return logcontext.ContextWithSessionFields(ctx, fields)
+}
+
+// AddSessionFields adds fields to the session carried by ctx. It is a no-op
+// when ctx has no session fields attached.
+func AddSessionFields(ctx context.Context, fields log.Fields) {
+	logcontext.AddSessionFields(ctx, fields)
+}
+
+// SessionFields returns a copy of the session fields carried by ctx.
+func SessionFields(ctx context.Context) log.Fields {
+	return logcontext.SessionFields(ctx)
+}
+
+// ContextLogger returns a log entry with the correlation ID and the session
+// fields carried by ctx.
+func ContextLogger(ctx context.Context) *logrus.Entry {
+	return logcontext.ContextLogger(ctx)
+}
+
+// WithContextFields is like ContextLogger but also adds the given fields.
+func WithContextFields(ctx context.Context, fields log.Fields) *logrus.Entry {
+	return logcontext.WithContextFields(ctx, fields)
+}
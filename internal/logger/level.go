@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+var levelState struct {
+	mu sync.Mutex
+	// revert restores the level in effect before the temporary change
+	// pending, if any
+	revert   *time.Timer
+	revertAt time.Time
+	previous logrus.Level
+	// generation identifies the latest change, for the revert of a change
+	// since overridden to be skipped
+	generation uint64
+}
+
+// Level returns the level of the logging singleton
+func Level() string {
+	return logrus.GetLevel().String()
+}
+
+// LevelRevertAt returns when the temporary level in effect is reverted, zero
+// when the level isn't temporary
+func LevelRevertAt() time.Time {
+	levelState.mu.Lock()
+	defer levelState.mu.Unlock()
+
+	return levelState.revertAt
+}
+
+// SetLevel changes the level of the logging singleton at runtime, an empty
+// level being info. It cancels the pending revert of a temporary level.
+func SetLevel(level string) error {
+	return SetLevelFor(level, 0)
+}
+
+// SetLevelFor changes the level of the logging singleton for duration, e.g.
+// debug for 10 minutes during an incident, then restores the level in effect
+// before. Changing the level again while a temporary level is in effect
+// keeps the level to restore. A zero duration changes the level for good.
+func SetLevelFor(level string, duration time.Duration) error {
+	parsed, err := logrus.ParseLevel(logLevel(level))
+	if err != nil {
+		return err
+	}
+
+	levelState.mu.Lock()
+	defer levelState.mu.Unlock()
+
+	previous := logrus.GetLevel()
+	if levelState.revert != nil {
+		levelState.revert.Stop()
+		previous = levelState.previous
+		levelState.revert = nil
+		levelState.revertAt = time.Time{}
+	}
+
+	logrus.SetLevel(parsed)
+	levelState.generation++
+
+	if duration > 0 {
+		generation := levelState.generation
+		levelState.previous = previous
+		levelState.revertAt = time.Now().Add(duration)
+		levelState.revert = time.AfterFunc(duration, func() { revertLevel(generation) })
+	}
+
+	return nil
+}
+
+// RevertLevel restores the level in effect before the temporary one, if any
+func RevertLevel() {
+	levelState.mu.Lock()
+	generation := levelState.generation
+	levelState.mu.Unlock()
+
+	revertLevel(generation)
+}
+
+func revertLevel(generation uint64) {
+	levelState.mu.Lock()
+	defer levelState.mu.Unlock()
+
+	// The level was changed again since, or isn't temporary
+	if levelState.generation != generation || levelState.revert == nil {
+		return
+	}
+
+	levelState.revert.Stop()
+	logrus.SetLevel(levelState.previous)
+	levelState.revert = nil
+	levelState.revertAt = time.Time{}
+
+	log.WithField("level", levelState.previous.String()).Info("temporary log level reverted")
+}
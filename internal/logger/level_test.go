@@ -0,0 +1,45 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetLevel(t *testing.T) {
+	defer SetLevel(Level())
+
+	require.NoError(t, SetLevel("debug"))
+	require.Equal(t, "debug", Level())
+
+	require.NoError(t, SetLevel(""))
+	require.Equal(t, "info", Level())
+
+	require.Error(t, SetLevel("verbose"))
+	require.Equal(t, "info", Level())
+}
+
+func TestSetLevelFor(t *testing.T) {
+	defer SetLevel(Level())
+	require.NoError(t, SetLevel("warn"))
+
+	require.NoError(t, SetLevelFor("debug", 50*time.Millisecond))
+	require.Equal(t, "debug", Level())
+	require.False(t, LevelRevertAt().IsZero())
+
+	// The level in effect before the first temporary change is restored
+	require.NoError(t, SetLevelFor("trace", 50*time.Millisecond))
+	require.Eventually(t, func() bool { return Level() == "warning" }, time.Second, 5*time.Millisecond)
+	require.True(t, LevelRevertAt().IsZero())
+
+	require.NoError(t, SetLevelFor("debug", time.Hour))
+	RevertLevel()
+	require.Equal(t, "warning", Level())
+
+	// A permanent change cancels the revert
+	require.NoError(t, SetLevelFor("debug", 20*time.Millisecond))
+	require.NoError(t, SetLevel("error"))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, "error", Level())
+}
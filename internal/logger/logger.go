@@ -5,6 +5,9 @@ import (
 	"io"
 	"log/syslog"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"gitlab.com/gitlab-org/labkit/log"
@@ -47,15 +50,96 @@ func buildOpts(cfg *config.Config) []log.LoggerOption {
 	}
 }
 
+// isRotatable reports whether the log file is a regular path we can roll over,
+// as opposed to one of the special outputs understood by LabKit.
+func isRotatable(cfg *config.Config) bool {
+	if !cfg.LogRotation.Enabled {
+		return false
+	}
+
+	switch logFile(cfg.LogFile) {
+	case "stdout", "stderr", os.DevNull:
+		return false
+	}
+
+	return true
+}
+
+// initialize sets up the logging singleton, using a rotating writer when
+// rotate is set and log rotation is enabled, and plain LabKit file handling,
+// which reopens the file on SIGHUP, otherwise.
+func initialize(cfg *config.Config, rotate bool) (io.Closer, error) {
+	stopReopening()
+
+	if !rotate || !isRotatable(cfg) {
+		return log.Initialize(buildOpts(cfg)...)
+	}
+
+	writer, err := newRotatingWriter(logFile(cfg.LogFile), cfg.LogRotation)
+	if err != nil {
+		return io.NopCloser(nil), err
+	}
+
+	opts := append(buildOpts(cfg), log.WithWriter(writer))
+	if _, err := log.Initialize(opts...); err != nil {
+		writer.Close()
+		return io.NopCloser(nil), err
+	}
+
+	reopenOnSignalHangup(writer)
+
+	return writer, nil
+}
+
+var (
+	reopeningMu sync.Mutex
+	// reopening receives the SIGHUP signals reopening the rotating writer
+	reopening chan os.Signal
+)
+
+// reopenOnSignalHangup reopens w on SIGHUP until stopReopening is called
+func reopenOnSignalHangup(w *rotatingWriter) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	reopeningMu.Lock()
+	reopening = sighup
+	reopeningMu.Unlock()
+
+	go func() {
+		for range sighup {
+			if err := w.Reopen(); err != nil {
+				log.WithError(err).WithField("log_file", w.path).Warn("Unable to reopen log file")
+			}
+		}
+	}()
+}
+
+// stopReopening stops reopening the previous rotating writer, if any, when
+// the logging singleton is initialized again
+func stopReopening() {
+	reopeningMu.Lock()
+	defer reopeningMu.Unlock()
+
+	if reopening == nil {
+		return
+	}
+
+	signal.Stop(reopening)
+	close(reopening)
+	reopening = nil
+}
+
 // Configure configures the logging singleton for operation inside a remote TTY (like SSH). In this
 // mode an empty LogFile is not accepted and syslog is used as a fallback when LogFile could not be
-// opened for writing.
+// opened for writing. The log file isn't rotated by these short-lived processes, which only reopen
+// it on SIGHUP.
 func Configure(cfg *config.Config) io.Closer {
 	var closer io.Closer = io.NopCloser(nil)
 	err := fmt.Errorf("No logfile specified")
 
 	if cfg.LogFile != "" {
-		closer, err = log.Initialize(buildOpts(cfg)...)
+		closer, err = initialize(cfg, false)
 	}
 
 	if err != nil {
@@ -81,9 +165,10 @@ func Configure(cfg *config.Config) io.Closer {
 
 // ConfigureStandalone configures the logging singleton for standalone operation. In this mode an
 // empty LogFile is treated as logging to stderr, and standard output is used as a fallback
-// when LogFile could not be opened for writing.
+// when LogFile could not be opened for writing. The log file is rotated when enabled, unless
+// several worker processes share it.
 func ConfigureStandalone(cfg *config.Config) io.Closer {
-	closer, err1 := log.Initialize(buildOpts(cfg)...)
+	closer, err1 := initialize(cfg, cfg.Server.Workers == 0)
 	if err1 != nil {
 		var err2 error
 
@@ -0,0 +1,285 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+const (
+	megabyte            = 1024 * 1024
+	backupTimeFormat    = "20060102T150405.000000000"
+	compressedSuffix    = ".gz"
+	defaultMaxSizeBytes = 100 * megabyte
+)
+
+// rotatingWriter is an io.WriteCloser that writes to a file and rolls it
+// over once it grows past maxSize. Rolled over files are renamed with a
+// timestamp suffix, optionally compressed, and pruned by age and count.
+type rotatingWriter struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+func newRotatingWriter(path string, cfg config.LogRotationConfig) (*rotatingWriter, error) {
+	maxSize := cfg.MaxSize * megabyte
+	if maxSize <= 0 {
+		maxSize = defaultMaxSizeBytes
+	}
+
+	w := &rotatingWriter{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     time.Duration(cfg.MaxAge),
+		maxBackups: cfg.MaxBackups,
+		compress:   cfg.Compress,
+		now:        time.Now,
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			metrics.LoggerRotationsTotal.WithLabelValues("fail").Inc()
+			return 0, err
+		}
+		metrics.LoggerRotationsTotal.WithLabelValues("ok").Inc()
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Reopen closes and reopens the current log file. It mirrors the behaviour
+// LabKit provides on SIGHUP so external tooling keeps working.
+func (w *rotatingWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+
+	return w.open()
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.closeFile()
+}
+
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.file = f
+	w.size = info.Size()
+
+	return nil
+}
+
+func (w *rotatingWriter) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+
+	return err
+}
+
+func (w *rotatingWriter) rotate() error {
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+
+	backup := w.newBackupName()
+	if err := os.Rename(w.path, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	// Compression and pruning don't affect the active file, so they run in the
+	// background to keep Write latency low.
+	go w.postRotate(backup)
+
+	return nil
+}
+
+// newBackupName returns the name of a backup that doesn't exist yet, the
+// clock possibly not having moved since the previous rotation
+func (w *rotatingWriter) newBackupName() string {
+	t := w.now()
+	for {
+		backup := w.backupName(t)
+		if !exists(backup) && !exists(backup+compressedSuffix) {
+			return backup
+		}
+
+		t = t.Add(time.Nanosecond)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+
+	return !os.IsNotExist(err)
+}
+
+func (w *rotatingWriter) backupName(t time.Time) string {
+	dir, base := filepath.Split(w.path)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext)
+
+	return filepath.Join(dir, fmt.Sprintf("%s-%s%s", prefix, t.UTC().Format(backupTimeFormat), ext))
+}
+
+func (w *rotatingWriter) postRotate(backup string) {
+	if w.compress {
+		if err := compressFile(backup); err != nil {
+			metrics.LoggerRotationsTotal.WithLabelValues("compress_fail").Inc()
+		}
+	}
+
+	w.prune()
+}
+
+// backups returns the rotated files belonging to this writer, newest first.
+func (w *rotatingWriter) backups() []string {
+	dir, base := filepath.Split(w.path)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), compressedSuffix), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+
+		files = append(files, filepath.Join(dir, name))
+	}
+
+	// The timestamp format sorts lexically in chronological order
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files
+}
+
+func (w *rotatingWriter) prune() {
+	cutoff := time.Time{}
+	if w.maxAge > 0 {
+		cutoff = w.now().Add(-w.maxAge)
+	}
+
+	for i, file := range w.backups() {
+		remove := w.maxBackups > 0 && i >= w.maxBackups
+
+		if !remove && !cutoff.IsZero() {
+			if info, err := os.Stat(file); err == nil && info.ModTime().Before(cutoff) {
+				remove = true
+			}
+		}
+
+		if remove {
+			os.Remove(file)
+		}
+	}
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+compressedSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + compressedSuffix)
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + compressedSuffix)
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
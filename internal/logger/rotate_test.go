@@ -0,0 +1,149 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestRotatingWriterRollsOverOnSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gitlab-shell.log")
+
+	w, err := newRotatingWriter(path, config.LogRotationConfig{Enabled: true})
+	require.NoError(t, err)
+	defer w.Close()
+
+	w.maxSize = 10
+
+	_, err = w.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("abc"))
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(data))
+
+	require.Eventually(t, func() bool { return len(w.backups()) == 1 }, time.Second, 10*time.Millisecond)
+
+	backup, err := os.ReadFile(w.backups()[0])
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(backup))
+}
+
+func TestRotatingWriterCompressesAndPrunes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gitlab-shell.log")
+
+	w, err := newRotatingWriter(path, config.LogRotationConfig{Enabled: true, MaxBackups: 2, Compress: true})
+	require.NoError(t, err)
+	defer w.Close()
+
+	w.maxSize = 1
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		now = now.Add(time.Second)
+		w.now = func() time.Time { return now }
+
+		_, err = w.Write([]byte("x"))
+		require.NoError(t, err)
+
+		// Let the background compression finish before rotating again
+		expected := i
+		if expected > 2 {
+			expected = 2
+		}
+		require.Eventually(t, func() bool {
+			backups := w.backups()
+			if len(backups) != expected {
+				return false
+			}
+			for _, b := range backups {
+				if !strings.HasSuffix(b, compressedSuffix) {
+					return false
+				}
+			}
+			return true
+		}, time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestRotatingWriterUniqueBackupNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gitlab-shell.log")
+
+	w, err := newRotatingWriter(path, config.LogRotationConfig{Enabled: true})
+	require.NoError(t, err)
+	defer w.Close()
+
+	w.maxSize = 1
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		_, err = w.Write([]byte("x"))
+		require.NoError(t, err)
+	}
+
+	// The clock didn't move, yet no backup was overwritten
+	require.Len(t, w.backups(), 3)
+}
+
+func TestConfigureStandaloneWithLogRotation(t *testing.T) {
+	tmpFile := createTempFile(t)
+
+	config := config.Config{
+		LogFile:     tmpFile,
+		LogFormat:   "json",
+		LogRotation: config.LogRotationConfig{Enabled: true},
+	}
+
+	closer := ConfigureStandalone(&config)
+	defer closer.Close()
+
+	_, ok := closer.(*rotatingWriter)
+	require.True(t, ok)
+	require.NotNil(t, reopening)
+
+	log.Info("this is a rotated test")
+
+	data, err := os.ReadFile(tmpFile)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"msg":"this is a rotated test"`)
+}
+
+func TestIsRotatable(t *testing.T) {
+	require.False(t, isRotatable(&config.Config{LogFile: "/tmp/file.log"}))
+	require.False(t, isRotatable(&config.Config{LogRotation: config.LogRotationConfig{Enabled: true}}))
+	require.False(t, isRotatable(&config.Config{LogFile: "stdout", LogRotation: config.LogRotationConfig{Enabled: true}}))
+	require.True(t, isRotatable(&config.Config{LogFile: "/tmp/file.log", LogRotation: config.LogRotationConfig{Enabled: true}}))
+}
+
+func TestLogRotationOnlyInSingleProcess(t *testing.T) {
+	cfg := config.Config{LogFile: createTempFile(t), LogRotation: config.LogRotationConfig{Enabled: true}}
+
+	closer := ConfigureStandalone(&cfg)
+	defer closer.Close()
+	require.IsType(t, &rotatingWriter{}, closer)
+	require.NotNil(t, reopening)
+
+	// The commands run by OpenSSH only reopen the file, and stop the
+	// previous writer from being reopened
+	closer = Configure(&cfg)
+	defer closer.Close()
+	_, ok := closer.(*rotatingWriter)
+	require.False(t, ok)
+	require.Nil(t, reopening)
+
+	cfg.Server.Workers = 2
+	closer = ConfigureStandalone(&cfg)
+	defer closer.Close()
+	_, ok = closer.(*rotatingWriter)
+	require.False(t, ok)
+}
@@ -0,0 +1,104 @@
+// Package maintenance rejects pushes during maintenance windows while still
+// allowing fetches, so that the SSH endpoint doesn't have to be taken down.
+package maintenance
+
+import (
+	"sync"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+const DefaultMessage = "GitLab is undergoing maintenance, pushes are disabled. Please try again later."
+
+// Mode tracks whether maintenance mode is on. It can be toggled at runtime.
+// A nil *Mode is valid and never rejects anything.
+type Mode struct {
+	allowedUsers  map[string]bool
+	allowedKeyIDs map[string]bool
+
+	state *state
+}
+
+// state is shared by the modes of all the GitLab instances served
+type state struct {
+	mu      sync.RWMutex
+	enabled bool
+	message string
+}
+
+// New returns a Mode. The users and keys allowed can still push during
+// maintenance, e.g. to deploy fixes.
+func New(enabled bool, message string, allowedUsers, allowedKeyIDs []string) *Mode {
+	m := newMode(&state{}, allowedUsers, allowedKeyIDs)
+	m.Set(enabled, message)
+
+	return m
+}
+
+// WithAllowed returns a Mode turned on and off along with m, allowing other
+// users and keys to push, e.g. the ones of another GitLab instance
+func (m *Mode) WithAllowed(allowedUsers, allowedKeyIDs []string) *Mode {
+	return newMode(m.state, allowedUsers, allowedKeyIDs)
+}
+
+func newMode(state *state, allowedUsers, allowedKeyIDs []string) *Mode {
+	m := &Mode{
+		allowedUsers:  make(map[string]bool, len(allowedUsers)),
+		allowedKeyIDs: make(map[string]bool, len(allowedKeyIDs)),
+		state:         state,
+	}
+
+	for _, username := range allowedUsers {
+		m.allowedUsers[username] = true
+	}
+	for _, keyID := range allowedKeyIDs {
+		m.allowedKeyIDs[keyID] = true
+	}
+
+	return m
+}
+
+// Set turns maintenance mode on or off. The default message is shown to
+// users when message is empty.
+func (m *Mode) Set(enabled bool, message string) {
+	if message == "" {
+		message = DefaultMessage
+	}
+
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+
+	m.state.enabled = enabled
+	m.state.message = message
+}
+
+// State returns whether maintenance mode is on and the message shown to users
+func (m *Mode) State() (bool, string) {
+	if m == nil {
+		return false, ""
+	}
+
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	return m.state.enabled, m.state.message
+}
+
+// CheckPush returns an error with the maintenance message when the user,
+// identified by their username or the ID of their key, can't push
+func (m *Mode) CheckPush(username, keyID string) error {
+	enabled, message := m.State()
+	if !enabled {
+		return nil
+	}
+
+	if (username != "" && m.allowedUsers[username]) || (keyID != "" && m.allowedKeyIDs[keyID]) {
+		metrics.MaintenancePushesTotal.WithLabelValues("allowed").Inc()
+		return nil
+	}
+
+	metrics.MaintenancePushesTotal.WithLabelValues("denied").Inc()
+
+	return errorcode.New(errorcode.AccessDenied, message)
+}
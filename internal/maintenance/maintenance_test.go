@@ -0,0 +1,72 @@
+package maintenance
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+func TestCheckPush(t *testing.T) {
+	mode := New(true, "Pushes are disabled until 18:00 UTC", []string{"root"}, []string{"1"})
+
+	denied := testutil.ToFloat64(metrics.MaintenancePushesTotal.WithLabelValues("denied"))
+	allowed := testutil.ToFloat64(metrics.MaintenancePushesTotal.WithLabelValues("allowed"))
+
+	err := mode.CheckPush("alex-doe", "2")
+	require.EqualError(t, err, "Pushes are disabled until 18:00 UTC")
+	require.Equal(t, errorcode.AccessDenied, errorcode.Classify(err))
+
+	require.NoError(t, mode.CheckPush("root", "2"))
+	require.NoError(t, mode.CheckPush("alex-doe", "1"))
+	require.NoError(t, mode.CheckPush("", "1"))
+	require.Error(t, mode.CheckPush("", ""))
+
+	require.Equal(t, denied+2, testutil.ToFloat64(metrics.MaintenancePushesTotal.WithLabelValues("denied")))
+	require.Equal(t, allowed+3, testutil.ToFloat64(metrics.MaintenancePushesTotal.WithLabelValues("allowed")))
+}
+
+func TestSet(t *testing.T) {
+	mode := New(false, "", nil, nil)
+	require.NoError(t, mode.CheckPush("alex-doe", "1"))
+
+	mode.Set(true, "")
+	enabled, message := mode.State()
+	require.True(t, enabled)
+	require.Equal(t, DefaultMessage, message)
+	require.EqualError(t, mode.CheckPush("alex-doe", "1"), DefaultMessage)
+
+	mode.Set(true, "Back at 18:00 UTC")
+	require.EqualError(t, mode.CheckPush("alex-doe", "1"), "Back at 18:00 UTC")
+
+	mode.Set(false, "")
+	require.NoError(t, mode.CheckPush("alex-doe", "1"))
+}
+
+func TestWithAllowed(t *testing.T) {
+	mode := New(false, "", []string{"root"}, []string{"1"})
+	tenant := mode.WithAllowed([]string{"admin"}, []string{"2"})
+
+	mode.Set(true, "")
+	enabled, _ := tenant.State()
+	require.True(t, enabled)
+
+	require.Error(t, tenant.CheckPush("root", "1"))
+	require.NoError(t, tenant.CheckPush("admin", ""))
+	require.NoError(t, tenant.CheckPush("", "2"))
+	require.NoError(t, mode.CheckPush("root", ""))
+
+	tenant.Set(false, "")
+	require.NoError(t, mode.CheckPush("alex-doe", ""))
+}
+
+func TestNilMode(t *testing.T) {
+	var mode *Mode
+
+	enabled, _ := mode.State()
+	require.False(t, enabled)
+	require.NoError(t, mode.CheckPush("alex-doe", "1"))
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"gitlab.com/gitlab-org/labkit/tracing"
+)
+
+const traceIDExemplarLabel = "trace_id"
+
+// traceExemplar labels an observation with the ID of the trace of ctx when
+// it's sampled, for the dashboards to link the latency spikes to their traces
+func traceExemplar(ctx context.Context) prometheus.Labels {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil || !tracing.IsSampled(span) {
+		return nil
+	}
+
+	traceID := spanTraceID(span)
+	if traceID == "" {
+		return nil
+	}
+
+	return prometheus.Labels{traceIDExemplarLabel: traceID}
+}
+
+// spanTraceID returns the ID of the trace of span as propagated by its
+// tracer, the tracers of labkit not sharing an interface for it
+func spanTraceID(span opentracing.Span) string {
+	carrier := opentracing.TextMapCarrier{}
+	if err := span.Tracer().Inject(span.Context(), opentracing.TextMap, carrier); err != nil {
+		return ""
+	}
+
+	for key, value := range carrier {
+		switch strings.ToLower(key) {
+		case "uber-trace-id":
+			// Jaeger: trace-id:span-id:parent-span-id:flags
+			traceID, _, _ := strings.Cut(value, ":")
+			return traceID
+		case "ot-tracer-traceid", "x-datadog-trace-id":
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/uber/jaeger-client-go"
+)
+
+func TestRoundTripperExemplars(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("gitlab-shell", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+
+	span := tracer.StartSpan("request")
+	defer span.Finish()
+	traceID := span.Context().(jaeger.SpanContext).TraceID().String()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	request, err := http.NewRequestWithContext(opentracing.ContextWithSpan(context.Background(), span), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	response, err := NewRoundTripper(http.DefaultTransport).RoundTrip(request)
+	require.NoError(t, err)
+	response.Body.Close()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+
+	var found bool
+	for _, family := range families {
+		if family.GetName() != "gitlab_shell_http_request_duration_seconds" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			histogram := metric.GetHistogram()
+			// The native histogram is exposed along the buckets
+			require.NotZero(t, histogram.GetSchema()+int32(len(histogram.GetPositiveSpan())))
+
+			for _, bucket := range histogram.GetBucket() {
+				for _, label := range bucket.GetExemplar().GetLabel() {
+					found = found || (label.GetName() == "trace_id" && label.GetValue() == traceID)
+				}
+			}
+		}
+	}
+	require.True(t, found, "no exemplar of the trace")
+}
+
+func TestTraceExemplarUnsampled(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("gitlab-shell", jaeger.NewConstSampler(false), jaeger.NewNullReporter())
+	defer closer.Close()
+
+	span := tracer.StartSpan("request")
+	defer span.Finish()
+
+	require.Nil(t, traceExemplar(opentracing.ContextWithSpan(context.Background(), span)))
+	require.Nil(t, traceExemplar(context.Background()))
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler serves the metrics of the default registry, in the OpenMetrics
+// format when the scraper accepts it so that exemplars are exposed
+func Handler() http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	)
+}
+
+// RegisterBuildInfo registers the gitlab_build_info metric, labeled with
+// the version and build time of the binary
+func RegisterBuildInfo(version, buildTime string) {
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "gitlab_build_info",
+		Help:        "Current build info for this GitLab Service",
+		ConstLabels: prometheus.Labels{"version": version, "built": buildTime},
+	})
+	prometheus.MustRegister(buildInfo)
+	buildInfo.Set(1)
+}
@@ -2,8 +2,10 @@ package metrics
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -13,21 +15,75 @@ const (
 	sshdSubsystem   = "sshd"
 	httpSubsystem   = "http"
 	gitalySubsystem = "gitaly"
+	loggerSubsystem = "logger"
+	gitSubsystem    = "git"
+
+	sessionRecordingSubsystem = "session_recording"
+	eventsSubsystem           = "events"
+	accessCacheSubsystem      = "access_cache"
+	preauthorizationSubsystem = "preauthorization"
+	pushOptionsSubsystem      = "push_options"
+	sessionHooksSubsystem     = "session_hooks"
+	maintenanceSubsystem      = "maintenance"
 
 	httpInFlightRequestsMetricName       = "in_flight_requests"
 	httpRequestsTotalMetricName          = "requests_total"
 	httpRequestDurationSecondsMetricName = "request_duration_seconds"
+	httpFailoversTotalName               = "failovers_total"
+	httpGitlabURLHealthyName             = "gitlab_url_healthy"
+	httpHedgedRequestsTotalName          = "hedged_requests_total"
 
 	sshdConnectionsInFlightName               = "in_flight_connections"
 	sshdHitMaxSessionsName                    = "concurrent_limited_sessions_total"
+	sshdQueuedSessionsTotalName               = "queued_sessions_total"
+	sshdSessionClassSessionsName              = "session_class_sessions"
+	sshdSessionClassLimitedTotalName          = "session_class_limited_sessions_total"
+	sshdSlowClientEvictionsTotalName          = "slow_client_evictions_total"
+	sshdKeyExchangesTotalName                 = "key_exchanges_total"
+	sshdTCPRTTSecondsName                     = "tcp_rtt_seconds"
+	sshdAuthProbesTotalName                   = "auth_probes_total"
+	sshdTCPRetransmitsName                    = "tcp_retransmits"
 	sshdSessionDurationSecondsName            = "session_duration_seconds"
 	sshdSessionEstablishedDurationSecondsName = "session_established_duration_seconds"
 	sshdCanceledSessionsName                  = "canceled_sessions"
+	sshdDenyListedKeysTotalName               = "deny_listed_keys_total"
+	sshdOfferedKeysName                       = "offered_keys"
+	sshdKeyFilterChecksTotalName              = "key_filter_checks_total"
+	sshdKeyFilterRefreshesTotalName           = "key_filter_refreshes_total"
+	sshdForwardingRequestsTotalName           = "forwarding_requests_total"
+	sshdSessionForwardingRequestsTotalName    = "session_forwarding_requests_total"
+	sshdWatchdogBreachesTotalName             = "watchdog_breaches_total"
+	sshdDependencyUpName                      = "dependency_up"
+	sshdPanicsTotalName                       = "panics_total"
+	sshdHandshakesInFlightName                = "in_flight_handshakes"
+	sshdDroppedStartupsTotalName              = "dropped_startups_total"
 
 	sliSshdSessionsTotalName       = "gitlab_sli:shell_sshd_sessions:total"
 	sliSshdSessionsErrorsTotalName = "gitlab_sli:shell_sshd_sessions:errors_total"
 
-	gitalyConnectionsTotalName = "connections_total"
+	gitalyConnectionsTotalName       = "connections_total"
+	gitalyConnectionCacheTotalName   = "connection_cache_total"
+	gitalyConnectionsReapedTotalName = "connections_reaped_total"
+	gitalyTransferThroughputName     = "transfer_throughput_bytes_per_second"
+
+	loggerRotationsTotalName = "rotations_total"
+
+	gitTransferredBytesTotalName = "transferred_bytes_total"
+	gitProtocolRequestsTotalName = "protocol_requests_total"
+	gitFetchFiltersTotalName     = "fetch_filters_total"
+
+	sessionRecordsTotalName = "records_total"
+	eventsTotalName         = "total"
+
+	accessCacheRequestsTotalName = "requests_total"
+
+	preauthorizationsTotalName = "tokens_total"
+
+	pushOptionsTotalName = "options_total"
+
+	sessionHooksRunsTotalName = "runs_total"
+
+	maintenancePushesTotalName = "pushes_total"
 )
 
 var (
@@ -77,6 +133,194 @@ var (
 		},
 	)
 
+	SshdQueuedSessionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdQueuedSessionsTotalName,
+			Help:      "Number of sessions queued because of the concurrent sessions limit, by whether they started or timed out",
+		},
+		[]string{"result"},
+	)
+
+	SshdSessionClassSessions = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdSessionClassSessionsName,
+			Help:      "Number of sessions in progress, by session class",
+		},
+		[]string{"class"},
+	)
+
+	SshdSessionClassLimitedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdSessionClassLimitedTotalName,
+			Help:      "Number of sessions rejected because of the concurrent sessions limit of their class",
+		},
+		[]string{"class"},
+	)
+
+	SshdAuthProbesTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdAuthProbesTotalName,
+			Help:      "The number of \"none\" authentication attempts probing the methods supported by gitlab-shell sshd",
+		},
+	)
+
+	SshdTCPRTT = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdTCPRTTSecondsName,
+			Help:      "A histogram of the smoothed round-trip time of the TCP connection when gitlab-shell sshd sessions close.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12), // 1ms to 2s
+		},
+	)
+
+	SshdTCPRetransmits = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdTCPRetransmitsName,
+			Help:      "A histogram of the number of segments retransmitted on the TCP connection when gitlab-shell sshd sessions close.",
+			Buckets:   []float64{0, 1, 5, 10, 50, 100, 500, 1000},
+		},
+	)
+
+	SshdSlowClientEvictionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdSlowClientEvictionsTotalName,
+			Help:      "Number of sessions terminated because the client transferred data too slowly, by direction",
+		},
+		[]string{"direction"},
+	)
+
+	SshdKeyExchangesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdKeyExchangesTotalName,
+			Help:      "Number of connections to gitlab-shell sshd by negotiated key exchange algorithm",
+		},
+		[]string{"algorithm"},
+	)
+
+	SshdHandshakesInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdHandshakesInFlightName,
+			Help:      "A gauge of connections to gitlab-shell sshd that haven't completed their handshake and authentication yet",
+		},
+	)
+
+	SshdDroppedStartupsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdDroppedStartupsTotalName,
+			Help:      "Number of connections to gitlab-shell sshd dropped as too many connections were in their handshake",
+		},
+	)
+
+	SshdPanicsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdPanicsTotalName,
+			Help:      "Number of panics recovered by gitlab-shell sshd by what they terminated, channel or connection, and fingerprint of their site",
+		},
+		[]string{"scope", "fingerprint"},
+	)
+
+	SshdDenyListedKeysTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdDenyListedKeysTotalName,
+			Help:      "Number of authentication attempts made with a key the internal API reported as compromised, by key type",
+		},
+		[]string{"key_type"},
+	)
+
+	SshdOfferedKeys = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdOfferedKeysName,
+			Help:      "A histogram of the number of public keys offered per connection to gitlab-shell sshd, by authentication result.",
+			Buckets:   []float64{0, 1, 2, 3, 6, 10, 20},
+		},
+		[]string{"result"},
+	)
+
+	SshdKeyFilterChecksTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdKeyFilterChecksTotalName,
+			Help:      "Number of keys checked against the key filter before being looked up through the internal API, by result",
+		},
+		[]string{"result"},
+	)
+
+	SshdKeyFilterRefreshesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdKeyFilterRefreshesTotalName,
+			Help:      "Number of times the key filter fingerprints have been fetched, by status",
+		},
+		[]string{"status"},
+	)
+
+	SshdForwardingRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdForwardingRequestsTotalName,
+			Help:      "Number of port forwarding channels requested from gitlab-shell sshd, by channel type and result",
+		},
+		[]string{"channel_type", "result"},
+	)
+
+	SshdSessionForwardingRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdSessionForwardingRequestsTotalName,
+			Help:      "Number of agent and X11 forwarding requests rejected by gitlab-shell sshd sessions, by request type",
+		},
+		[]string{"request_type"},
+	)
+
+	SshdWatchdogBreachesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdWatchdogBreachesTotalName,
+			Help:      "Number of watchdog checks that found a resource above its threshold, by resource",
+		},
+		[]string{"resource"},
+	)
+
+	SshdDependencyUp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: sshdSubsystem,
+			Name:      sshdDependencyUpName,
+			Help:      "Whether the last readiness check of a dependency of gitlab-shell sshd succeeded",
+		},
+		[]string{"dependency"},
+	)
+
 	SliSshdSessionsTotal = promauto.NewCounter(
 		prometheus.CounterOpts{
 			Name: sliSshdSessionsTotalName,
@@ -101,6 +345,181 @@ var (
 		[]string{"status"},
 	)
 
+	GitalyConnectionCacheTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: gitalySubsystem,
+			Name:      gitalyConnectionCacheTotalName,
+			Help:      "Number of Gitaly connection lookups served from the connection pool (hit) or requiring a new connection (miss)",
+		},
+		[]string{"result"},
+	)
+
+	GitalyConnectionsReapedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: gitalySubsystem,
+			Name:      gitalyConnectionsReapedTotalName,
+			Help:      "Number of pooled Gitaly connections that have been closed",
+		},
+		[]string{"reason"},
+	)
+
+	GitalyTransferThroughput = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: gitalySubsystem,
+			Name:      gitalyTransferThroughputName,
+			Help:      "A histogram of the throughput of data transferred from Gitaly to the client, by transport.",
+			Buckets:   prometheus.ExponentialBuckets(64*1024, 4, 8), // 64KiB/s to 1GiB/s
+		},
+		[]string{"command", "transport"},
+	)
+
+	GitTransferredBytesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: gitSubsystem,
+			Name:      gitTransferredBytesTotalName,
+			Help:      "Number of bytes transferred over SSH, by command and direction (in: read from the client, out: written to the client)",
+		},
+		[]string{"command", "direction"},
+	)
+
+	GitProtocolRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: gitSubsystem,
+			Name:      gitProtocolRequestsTotalName,
+			Help:      "Number of git commands run, by command and Git protocol version requested (0, 1, 2 or invalid)",
+		},
+		[]string{"command", "version"},
+	)
+
+	GitFetchFiltersTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: gitSubsystem,
+			Name:      gitFetchFiltersTotalName,
+			Help:      "Number of fetches, by kind of object filter (none when not a partial clone) and result",
+		},
+		[]string{"filter", "result"},
+	)
+
+	SessionRecordsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sessionRecordingSubsystem,
+			Name:      sessionRecordsTotalName,
+			Help:      "Number of session records delivered, by sink and status",
+		},
+		[]string{"sink", "status"},
+	)
+
+	EventsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: eventsSubsystem,
+			Name:      eventsTotalName,
+			Help:      "Number of lifecycle events published, by sink, type and status",
+		},
+		[]string{"sink", "type", "status"},
+	)
+
+	AccessCacheRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: accessCacheSubsystem,
+			Name:      accessCacheRequestsTotalName,
+			Help:      "Number of access checks looked up in the access cache, by result",
+		},
+		[]string{"result"},
+	)
+
+	PreauthorizationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: preauthorizationSubsystem,
+			Name:      preauthorizationsTotalName,
+			Help:      "Number of fetch pre-authorization tokens validated, by result",
+		},
+		[]string{"result"},
+	)
+
+	PushOptionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: pushOptionsSubsystem,
+			Name:      pushOptionsTotalName,
+			Help:      "Number of push options received, by result",
+		},
+		[]string{"result"},
+	)
+
+	SessionHooksRunsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: sessionHooksSubsystem,
+			Name:      sessionHooksRunsTotalName,
+			Help:      "Number of session hooks run, by hook and result",
+		},
+		[]string{"hook", "result"},
+	)
+
+	MaintenancePushesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: maintenanceSubsystem,
+			Name:      maintenancePushesTotalName,
+			Help:      "Number of pushes attempted during maintenance mode, by result",
+		},
+		[]string{"result"},
+	)
+
+	LoggerRotationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: loggerSubsystem,
+			Name:      loggerRotationsTotalName,
+			Help:      "Number of times the log file has been rotated",
+		},
+		[]string{"status"},
+	)
+
+	// HttpFailoversTotal counts the moves of the requests to the internal API
+	// from a GitLab URL to another
+	HttpFailoversTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: httpSubsystem,
+			Name:      httpFailoversTotalName,
+			Help:      "Number of times the requests to the internal API failed over from a GitLab URL to another",
+		},
+		[]string{"from", "to"},
+	)
+
+	HttpGitlabURLHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: httpSubsystem,
+			Name:      httpGitlabURLHealthyName,
+			Help:      "Whether a GitLab URL of the failover is healthy",
+		},
+		[]string{"url"},
+	)
+
+	// HttpHedgedRequestsTotal counts the requests to the internal API sent
+	// again after the hedging delay, by the request answering first
+	HttpHedgedRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: httpSubsystem,
+			Name:      httpHedgedRequestsTotalName,
+			Help:      "Number of requests to the internal API hedged, by path and by whether the original request or the hedge answered first",
+		},
+		[]string{"path", "winner"},
+	)
+
 	// The metrics and the buckets size are similar to the ones we have for handlers in Labkit
 	// When the MR: https://gitlab.com/gitlab-org/labkit/-/merge_requests/150 is merged,
 	// these metrics can be refactored out of Gitlab Shell code by using the helper function from Labkit
@@ -131,6 +550,11 @@ var (
 				60.0,  /* 1m */
 				300.0, /* 5m */
 			},
+			// Native histograms are exposed alongside the buckets to the
+			// scrapers asking for them
+			NativeHistogramBucketFactor:     1.1,
+			NativeHistogramMaxBucketNumber:  100,
+			NativeHistogramMinResetDuration: time.Hour,
 		},
 		[]string{"code", "method"},
 	)
@@ -145,10 +569,23 @@ var (
 	)
 )
 
+// RegisterRuntimeMetrics replaces the default Go collector with one that also
+// exports the GC, memory and scheduler metrics of the Go runtime
+func RegisterRuntimeMetrics() error {
+	prometheus.Unregister(collectors.NewGoCollector())
+
+	return prometheus.Register(collectors.NewGoCollector(
+		collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsGC, collectors.MetricsMemory, collectors.MetricsScheduler),
+	))
+}
+
+// NewRoundTripper instruments the requests made through next. Their
+// observations carry the ID of their trace as exemplar when it's sampled.
 func NewRoundTripper(next http.RoundTripper) promhttp.RoundTripperFunc {
 	rt := next
+	exemplar := promhttp.WithExemplarFromContext(traceExemplar)
 
-	rt = promhttp.InstrumentRoundTripperCounter(httpRequestsTotal, rt)
-	rt = promhttp.InstrumentRoundTripperDuration(httpRequestDurationSeconds, rt)
+	rt = promhttp.InstrumentRoundTripperCounter(httpRequestsTotal, rt, exemplar)
+	rt = promhttp.InstrumentRoundTripperDuration(httpRequestDurationSeconds, rt, exemplar)
 	return promhttp.InstrumentRoundTripperInFlight(httpInFlightRequests, rt)
 }
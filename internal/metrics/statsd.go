@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	defaultStatsdInterval = 10 * time.Second
+	// maxStatsdDatagramSize keeps the datagrams within the MTU of most
+	// networks, as advised by the DogStatsD documentation
+	maxStatsdDatagramSize = 1432
+)
+
+// StatsdOpts configures a StatsdSink
+type StatsdOpts struct {
+	// Address is the host:port of the DogStatsD agent, reached over UDP
+	Address  string
+	Interval time.Duration
+	// Tags are added to all the metrics, e.g. env:production
+	Tags []string
+}
+
+// StatsdSink sends the metrics of the Prometheus registry to a DogStatsD
+// agent, for deployments that don't scrape Prometheus metrics. Counters are
+// sent as counts of their increase since the previous flush, gauges as
+// gauges, and histograms as the counts of their observations, of their sum
+// and of their buckets, tagged with their upper bound le.
+type StatsdSink struct {
+	opts     StatsdOpts
+	conn     net.Conn
+	gatherer prometheus.Gatherer
+	// previous holds the values of the counters at the previous flush
+	previous map[string]float64
+}
+
+func NewStatsdSink(opts StatsdOpts) (*StatsdSink, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultStatsdInterval
+	}
+
+	conn, err := net.Dial("udp", opts.Address)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+
+	return &StatsdSink{opts: opts, conn: conn, gatherer: prometheus.DefaultGatherer, previous: map[string]float64{}}, nil
+}
+
+// Run flushes the metrics every interval until ctx is done, and once more
+// before returning
+func (s *StatsdSink) Run(ctx context.Context) {
+	defer s.conn.Close()
+
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logFlush()
+			return
+		case <-ticker.C:
+			s.logFlush()
+		}
+	}
+}
+
+func (s *StatsdSink) logFlush() {
+	if err := s.Flush(); err != nil {
+		log.WithError(err).Warn("statsd: failed to send the metrics")
+	}
+}
+
+// Flush sends the current metrics
+func (s *StatsdSink) Flush() error {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			lines = append(lines, s.lines(family, metric)...)
+		}
+	}
+
+	return s.send(lines)
+}
+
+func (s *StatsdSink) lines(family *dto.MetricFamily, metric *dto.Metric) []string {
+	name := family.GetName()
+	tags := s.tags(metric.GetLabel())
+
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		return s.count(nil, name, tags, metric.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		return []string{statsdLine(name, metric.GetGauge().GetValue(), "g", tags)}
+	case dto.MetricType_UNTYPED:
+		return []string{statsdLine(name, metric.GetUntyped().GetValue(), "g", tags)}
+	case dto.MetricType_HISTOGRAM:
+		histogram := metric.GetHistogram()
+
+		var lines []string
+		lines = s.count(lines, name+".count", tags, float64(histogram.GetSampleCount()))
+		lines = s.count(lines, name+".sum", tags, histogram.GetSampleSum())
+		for _, bucket := range histogram.GetBucket() {
+			le := "le:" + strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)
+			lines = s.count(lines, name+".bucket", append(tags[:len(tags):len(tags)], le), float64(bucket.GetCumulativeCount()))
+		}
+
+		return lines
+	case dto.MetricType_SUMMARY:
+		summary := metric.GetSummary()
+
+		var lines []string
+		lines = s.count(lines, name+".count", tags, float64(summary.GetSampleCount()))
+		return s.count(lines, name+".sum", tags, summary.GetSampleSum())
+	}
+
+	return nil
+}
+
+// count appends the line of the increase of a counter since the previous
+// flush, if any. A counter lower than before was reset, e.g. by a restart.
+func (s *StatsdSink) count(lines []string, name string, tags []string, value float64) []string {
+	key := name + "|" + strings.Join(tags, ",")
+	delta := value - s.previous[key]
+	if delta < 0 {
+		delta = value
+	}
+	s.previous[key] = value
+
+	if delta == 0 {
+		return lines
+	}
+
+	return append(lines, statsdLine(name, delta, "c", tags))
+}
+
+func (s *StatsdSink) tags(labels []*dto.LabelPair) []string {
+	tags := make([]string, 0, len(s.opts.Tags)+len(labels))
+	tags = append(tags, s.opts.Tags...)
+	for _, label := range labels {
+		tags = append(tags, label.GetName()+":"+sanitizeStatsdTag(label.GetValue()))
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+// sanitizeStatsdTag replaces the characters separating the fields of the
+// protocol
+func sanitizeStatsdTag(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', '\n':
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+func statsdLine(name string, value float64, kind string, tags []string) string {
+	line := name + ":" + strconv.FormatFloat(value, 'g', -1, 64) + "|" + kind
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+
+	return line
+}
+
+// send sends the lines in as few datagrams as they fit in
+func (s *StatsdSink) send(lines []string) error {
+	var datagram []byte
+	for _, line := range lines {
+		if len(datagram) > 0 && len(datagram)+1+len(line) > maxStatsdDatagramSize {
+			if _, err := s.conn.Write(datagram); err != nil {
+				return err
+			}
+			datagram = datagram[:0]
+		}
+
+		if len(datagram) > 0 {
+			datagram = append(datagram, '\n')
+		}
+		datagram = append(datagram, line...)
+	}
+
+	if len(datagram) == 0 {
+		return nil
+	}
+
+	_, err := s.conn.Write(datagram)
+
+	return err
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsdSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total"}, []string{"code"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "in_flight"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration_seconds", Buckets: []float64{0.1, 1}})
+	registry.MustRegister(counter, gauge, histogram)
+
+	sink, err := NewStatsdSink(StatsdOpts{Address: conn.LocalAddr().String(), Tags: []string{"env:test"}})
+	require.NoError(t, err)
+	sink.gatherer = registry
+
+	receive := func() []string {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		buf := make([]byte, maxStatsdDatagramSize)
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+
+		lines := strings.Split(string(buf[:n]), "\n")
+		sort.Strings(lines)
+
+		return lines
+	}
+
+	counter.WithLabelValues("200").Add(3)
+	gauge.Set(2)
+	histogram.Observe(0.5)
+	require.NoError(t, sink.Flush())
+	require.Equal(t, []string{
+		"duration_seconds.bucket:1|c|#env:test,le:1",
+		"duration_seconds.count:1|c|#env:test",
+		"duration_seconds.sum:0.5|c|#env:test",
+		"in_flight:2|g|#env:test",
+		"requests_total:3|c|#code:200,env:test",
+	}, receive())
+
+	// Only the increase of the counters is sent
+	counter.WithLabelValues("200").Add(2)
+	require.NoError(t, sink.Flush())
+	require.Equal(t, []string{
+		"in_flight:2|g|#env:test",
+		"requests_total:2|c|#code:200,env:test",
+	}, receive())
+}
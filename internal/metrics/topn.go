@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// TopNOther is the label the values outside of the top N are rolled up
+	// under
+	TopNOther = "other"
+	// topNCapacityFactor is how many more labels than exposed are tracked,
+	// for the counts of the top N to be accurate
+	topNCapacityFactor = 10
+)
+
+var (
+	// TopProjectFetchedBytes counts the bytes fetched from the projects
+	// fetched the most, nil unless enabled with EnableLabeledMetrics
+	TopProjectFetchedBytes *TopN
+	// TopUserSessions counts the sessions of the users with the most
+	// sessions, nil unless enabled with EnableLabeledMetrics
+	TopUserSessions *TopN
+)
+
+// EnableLabeledMetrics registers the metrics labeled by project and by user,
+// exposing topProjects projects and topUsers users. Zero leaves a metric
+// disabled.
+func EnableLabeledMetrics(topProjects, topUsers int) {
+	if topProjects > 0 {
+		TopProjectFetchedBytes = NewTopN(
+			prometheus.BuildFQName(namespace, gitSubsystem, "top_project_fetched_bytes_total"),
+			"Number of bytes fetched from the projects fetched the most, the other projects rolled up under the project \"other\"",
+			"project", topProjects,
+		)
+		prometheus.MustRegister(TopProjectFetchedBytes)
+	}
+
+	if topUsers > 0 {
+		TopUserSessions = NewTopN(
+			prometheus.BuildFQName(namespace, sshdSubsystem, "top_user_sessions_total"),
+			"Number of sessions of the users with the most sessions, the other users rolled up under the user \"other\"",
+			"user", topUsers,
+		)
+		prometheus.MustRegister(TopUserSessions)
+	}
+}
+
+// TopN counts by a label of high cardinality, such as the project, and
+// exposes the counts of the N label values counted the most, rolling the
+// other ones up under TopNOther for the cardinality of the metric to stay
+// bounded. It tracks a multiple of N values with the Space-Saving algorithm:
+// a value met once all the tracked ones are taken replaces the one counted
+// the least and inherits its count, so that the counts may be overestimated
+// by the count of the value replaced. The series of a value leaving the top N
+// disappear, and the rollup decreases when a value enters it. A nil *TopN
+// counts nothing.
+type TopN struct {
+	desc     *prometheus.Desc
+	n        int
+	capacity int
+
+	mu     sync.Mutex
+	counts map[string]float64
+	total  float64
+}
+
+func NewTopN(name, help, label string, n int) *TopN {
+	return &TopN{
+		desc:     prometheus.NewDesc(name, help, []string{label}, nil),
+		n:        n,
+		capacity: n * topNCapacityFactor,
+		counts:   make(map[string]float64),
+	}
+}
+
+// Add adds value to the count of key
+func (t *TopN) Add(key string, value float64) {
+	if t == nil || key == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.total += value
+
+	if _, ok := t.counts[key]; !ok && len(t.counts) >= t.capacity {
+		var minKey string
+		var minCount float64
+		for k, count := range t.counts {
+			if minKey == "" || count < minCount {
+				minKey, minCount = k, count
+			}
+		}
+
+		delete(t.counts, minKey)
+		t.counts[key] = minCount
+	}
+
+	t.counts[key] += value
+}
+
+func (t *TopN) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.desc
+}
+
+func (t *TopN) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]string, 0, len(t.counts))
+	for key := range t.counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if t.counts[keys[i]] != t.counts[keys[j]] {
+			return t.counts[keys[i]] > t.counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > t.n {
+		keys = keys[:t.n]
+	}
+
+	other := t.total
+	for _, key := range keys {
+		ch <- prometheus.MustNewConstMetric(t.desc, prometheus.CounterValue, t.counts[key], key)
+		other -= t.counts[key]
+	}
+
+	if other < 0 {
+		other = 0
+	}
+	ch <- prometheus.MustNewConstMetric(t.desc, prometheus.CounterValue, other, TopNOther)
+}
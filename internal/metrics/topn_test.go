@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopN(t *testing.T) {
+	top := NewTopN("top_project_fetched_bytes_total", "Bytes fetched", "project", 2)
+
+	top.Add("group/a", 100)
+	top.Add("group/b", 300)
+	top.Add("group/c", 50)
+	top.Add("group/a", 100)
+	top.Add("", 1000)
+
+	require.NoError(t, testutil.CollectAndCompare(top, strings.NewReader(`
+# HELP top_project_fetched_bytes_total Bytes fetched
+# TYPE top_project_fetched_bytes_total counter
+top_project_fetched_bytes_total{project="group/a"} 200
+top_project_fetched_bytes_total{project="group/b"} 300
+top_project_fetched_bytes_total{project="other"} 50
+`)))
+
+	var nilTop *TopN
+	nilTop.Add("group/a", 1)
+}
+
+func TestTopNCapacity(t *testing.T) {
+	top := NewTopN("top_user_sessions_total", "Sessions", "user", 1)
+
+	for i := 0; i < topNCapacityFactor; i++ {
+		top.Add(string(rune('a'+i)), float64(i+1))
+	}
+
+	// The user counted the least is replaced, its count inherited
+	top.Add("z", 1)
+	require.Len(t, top.counts, topNCapacityFactor)
+	require.NotContains(t, top.counts, "a")
+	require.Equal(t, float64(2), top.counts["z"])
+
+	require.NoError(t, testutil.CollectAndCompare(top, strings.NewReader(`
+# HELP top_user_sessions_total Sessions
+# TYPE top_user_sessions_total counter
+top_user_sessions_total{user="j"} 10
+top_user_sessions_total{user="other"} 46
+`)))
+}
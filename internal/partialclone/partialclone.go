@@ -0,0 +1,141 @@
+// Package partialclone inspects the object filters requested by fetches,
+// e.g. `git clone --filter=blob:none`, to count partial clones and reject the
+// filters the instance doesn't allow.
+// https://git-scm.com/docs/git-rev-list#Documentation/git-rev-list.txt---filterltfilter-specgt
+package partialclone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
+)
+
+// ErrFilterDenied is returned when a fetch requests a filter that is denied
+var ErrFilterDenied = errors.New("this object filter is not allowed on this GitLab instance")
+
+// kinds are the filters counted by kind, the others are counted as other to
+// bound the cardinality of the metrics
+var kinds = map[string]bool{
+	"blob:none":   true,
+	"blob:limit":  true,
+	"tree":        true,
+	"sparse:oid":  true,
+	"object:type": true,
+	"combine":     true,
+}
+
+// maxInspected bounds the data inspected for the first fetch request, the
+// filter of larger requests may not be seen
+const maxInspected = 4 * 1024 * 1024
+
+// Reader inspects the first fetch request of a session while passing the data
+// through, and fails when it requests a denied filter. The fetch is counted,
+// by filter.
+type Reader struct {
+	*pktline.TeeReader
+
+	denied  []string
+	sawWant bool
+	filter  string
+}
+
+// NewReader returns a Reader rejecting the denied filters, which are either
+// filter specs such as tree:0, or kinds of filters such as blob:limit or tree
+func NewReader(r io.Reader, denied []string) *Reader {
+	reader := &Reader{denied: denied}
+	reader.TeeReader = pktline.NewTeeReader(r, maxInspected, reader.parse)
+
+	return reader
+}
+
+// Filter returns the filter of the first fetch, empty when it isn't a partial
+// clone
+func (r *Reader) Filter() string {
+	return r.filter
+}
+
+func (r *Reader) parse(pkt []byte) error {
+	// Protocol v0 ends the wants with a flush, v2 the fetch arguments. The
+	// requests preceding the wants, e.g. ls-refs with v2, are skipped.
+	if pktline.IsFlush(pkt) {
+		if !r.sawWant {
+			return nil
+		}
+
+		metrics.GitFetchFiltersTotal.WithLabelValues(Kind(r.filter), "accepted").Inc()
+		return pktline.ErrStop
+	}
+
+	payload := bytes.TrimSuffix(pktline.Payload(pkt), []byte("\n"))
+	switch {
+	case bytes.HasPrefix(payload, []byte("want ")):
+		r.sawWant = true
+	case bytes.HasPrefix(payload, []byte("filter ")):
+		r.filter = string(bytes.TrimPrefix(payload, []byte("filter ")))
+		if r.isDenied(r.filter) {
+			metrics.GitFetchFiltersTotal.WithLabelValues(Kind(r.filter), "denied").Inc()
+			return fmt.Errorf("%w: %s", ErrFilterDenied, r.filter)
+		}
+	}
+
+	return nil
+}
+
+func (r *Reader) isDenied(spec string) bool {
+	if len(r.denied) == 0 {
+		return false
+	}
+
+	specs := []string{spec}
+	if combined, found := strings.CutPrefix(spec, "combine:"); found {
+		for _, sub := range strings.Split(combined, "+") {
+			if decoded, err := url.PathUnescape(sub); err == nil {
+				sub = decoded
+			}
+			specs = append(specs, sub)
+		}
+	}
+
+	for _, s := range specs {
+		for _, denied := range r.denied {
+			if denied == s || denied == kind(s) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Kind returns the kind of a filter spec, e.g. blob:limit for
+// blob:limit=1m, none without a filter and other for unknown filters
+func Kind(spec string) string {
+	if spec == "" {
+		return "none"
+	}
+
+	if k := kind(spec); kinds[k] {
+		return k
+	}
+
+	return "other"
+}
+
+func kind(spec string) string {
+	if strings.HasPrefix(spec, "tree:") {
+		return "tree"
+	}
+	if strings.HasPrefix(spec, "combine:") {
+		return "combine"
+	}
+
+	k, _, _ := strings.Cut(spec, "=")
+
+	return k
+}
@@ -0,0 +1,133 @@
+package partialclone
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
+)
+
+const oid = "1111111111111111111111111111111111111111"
+
+func fetchV0(t *testing.T, filter string) []byte {
+	var buf bytes.Buffer
+
+	require.NoError(t, pktline.WriteString(&buf, "want "+oid+" multi_ack side-band-64k filter\n"))
+	if filter != "" {
+		require.NoError(t, pktline.WriteString(&buf, "filter "+filter+"\n"))
+	}
+	require.NoError(t, pktline.WriteFlush(&buf))
+	require.NoError(t, pktline.WriteString(&buf, "done\n"))
+
+	return buf.Bytes()
+}
+
+func fetchV2(t *testing.T, filter string) []byte {
+	var buf bytes.Buffer
+
+	require.NoError(t, pktline.WriteString(&buf, "command=ls-refs\n"))
+	require.NoError(t, pktline.WriteFlush(&buf))
+	require.NoError(t, pktline.WriteString(&buf, "command=fetch\n"))
+	require.NoError(t, pktline.WriteString(&buf, "agent=git/2.43.0\n"))
+	require.NoError(t, pktline.WriteDelim(&buf))
+	require.NoError(t, pktline.WriteString(&buf, "want "+oid+"\n"))
+	if filter != "" {
+		require.NoError(t, pktline.WriteString(&buf, "filter "+filter+"\n"))
+	}
+	require.NoError(t, pktline.WriteString(&buf, "done\n"))
+	require.NoError(t, pktline.WriteFlush(&buf))
+
+	return buf.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		input  []byte
+		filter string
+	}{
+		{desc: "v0 full clone", input: fetchV0(t, "")},
+		{desc: "v0 blobless clone", input: fetchV0(t, "blob:none"), filter: "blob:none"},
+		{desc: "v2 full clone", input: fetchV2(t, "")},
+		{desc: "v2 treeless clone", input: fetchV2(t, "tree:0"), filter: "tree:0"},
+		{desc: "not a pkt-line stream", input: []byte("not a fetch")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			counter := metrics.GitFetchFiltersTotal.WithLabelValues(Kind(tc.filter), "accepted")
+			initial := testutil.ToFloat64(counter)
+
+			// Reading a byte at a time checks that packets split across reads
+			// are parsed
+			r := NewReader(iotest.OneByteReader(bytes.NewReader(tc.input)), []string{"blob:limit"})
+
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, tc.input, data)
+			require.Equal(t, tc.filter, r.Filter())
+
+			if tc.desc != "not a pkt-line stream" {
+				require.Equal(t, initial+1, testutil.ToFloat64(counter))
+			}
+		})
+	}
+}
+
+func TestReaderDenied(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		denied []string
+		filter string
+		err    bool
+	}{
+		{desc: "spec denied", denied: []string{"tree:0"}, filter: "tree:0", err: true},
+		{desc: "other spec of the kind", denied: []string{"tree:0"}, filter: "tree:1"},
+		{desc: "kind denied", denied: []string{"blob:limit"}, filter: "blob:limit=1m", err: true},
+		{desc: "other kind", denied: []string{"blob:limit"}, filter: "blob:none"},
+		{desc: "combined filter denied", denied: []string{"tree"}, filter: "combine:blob%3Anone+tree%3A0", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := NewReader(bytes.NewReader(fetchV0(t, tc.filter)), tc.denied)
+
+			_, err := io.ReadAll(r)
+			if !tc.err {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, ErrFilterDenied)
+			require.EqualError(t, err, "this object filter is not allowed on this GitLab instance: "+tc.filter)
+		})
+	}
+}
+
+func TestReaderStopsAfterFirstFetch(t *testing.T) {
+	input := append(fetchV2(t, "blob:none"), fetchV2(t, "tree:0")...)
+
+	r := NewReader(bytes.NewReader(input), []string{"tree:0"})
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, input, data)
+	require.Equal(t, "blob:none", r.Filter())
+	require.False(t, r.Truncated())
+}
+
+func TestKind(t *testing.T) {
+	require.Equal(t, "none", Kind(""))
+	require.Equal(t, "blob:none", Kind("blob:none"))
+	require.Equal(t, "blob:limit", Kind("blob:limit=1m"))
+	require.Equal(t, "tree", Kind("tree:0"))
+	require.Equal(t, "sparse:oid", Kind("sparse:oid=main:.sparse"))
+	require.Equal(t, "object:type", Kind("object:type=blob"))
+	require.Equal(t, "combine", Kind("combine:blob%3Anone+tree%3A0"))
+	require.Equal(t, "other", Kind("sparse:path=/etc/passwd"))
+}
@@ -14,7 +14,11 @@ import (
 
 const (
 	maxPktSize = 0xffff
+	pktFlush   = "0000"
 	pktDelim   = "0001"
+
+	// MaxPayloadSize is the largest amount of data a single packet can carry
+	MaxPayloadSize = maxPktSize - 4
 )
 
 var branchRemovalPktRegexp = regexp.MustCompile(`\A[a-f0-9]{4}[a-f0-9]{40} 0{40} `)
@@ -36,6 +40,11 @@ func IsFlush(pkt []byte) bool {
 	return bytes.Equal(pkt, []byte("0000"))
 }
 
+// IsDelim detects the special delimiter packet '0001'
+func IsDelim(pkt []byte) bool {
+	return bytes.Equal(pkt, []byte(pktDelim))
+}
+
 // IsDone detects the special done packet '0009done\n'
 func IsDone(pkt []byte) bool {
 	return bytes.Equal(pkt, PktDone())
@@ -46,6 +55,73 @@ func PktDone() []byte {
 	return []byte("0009done\n")
 }
 
+// Payload returns the data carried by pkt without its length prefix
+func Payload(pkt []byte) []byte {
+	if len(pkt) < 4 {
+		return nil
+	}
+
+	return pkt[4:]
+}
+
+// Next returns the first packet of data and whether it's complete, for
+// parsing a stream that is read in chunks
+func Next(data []byte) ([]byte, bool, error) {
+	if len(data) < 4 {
+		return nil, false, nil
+	}
+
+	length, err := strconv.ParseUint(string(data[:4]), 16, 16)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if length < 4 {
+		// Special packets such as flush
+		return data[:4], true, nil
+	}
+
+	if uint64(len(data)) < length {
+		return nil, false, nil
+	}
+
+	return data[:length], true, nil
+}
+
+// Write writes data as a single packet. Data must not exceed MaxPayloadSize.
+func Write(w io.Writer, data []byte) error {
+	if len(data) > MaxPayloadSize {
+		return fmt.Errorf("pktline: payload of %d bytes is too large", len(data))
+	}
+
+	if _, err := fmt.Fprintf(w, "%04x", len(data)+4); err != nil {
+		return err
+	}
+
+	_, err := w.Write(data)
+
+	return err
+}
+
+// WriteString writes s as a single packet
+func WriteString(w io.Writer, s string) error {
+	return Write(w, []byte(s))
+}
+
+// WriteFlush writes the special flush packet '0000'
+func WriteFlush(w io.Writer) error {
+	_, err := io.WriteString(w, pktFlush)
+
+	return err
+}
+
+// WriteDelim writes the special delimiter packet '0001'
+func WriteDelim(w io.Writer) error {
+	_, err := io.WriteString(w, pktDelim)
+
+	return err
+}
+
 func pktLineSplitter(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if len(data) < 4 {
 		if atEOF && len(data) > 0 {
@@ -1,6 +1,7 @@
 package pktline
 
 import (
+	"bytes"
 	"strings"
 	"testing"
 
@@ -119,3 +120,44 @@ func TestIsDone(t *testing.T) {
 		})
 	}
 }
+
+func TestIsDelim(t *testing.T) {
+	require.True(t, IsDelim([]byte("0001")))
+	require.False(t, IsDelim([]byte("0000")))
+	require.False(t, IsDelim([]byte("0008abcd")))
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+
+	require.NoError(t, WriteString(&buf, "version 1\n"))
+	require.NoError(t, WriteDelim(&buf))
+	require.NoError(t, Write(&buf, []byte("data")))
+	require.NoError(t, WriteFlush(&buf))
+	require.Equal(t, "000eversion 1\n00010008data0000", buf.String())
+
+	require.Error(t, Write(&buf, []byte(largestString+"z")))
+
+	scanner := NewScanner(&buf)
+	require.True(t, scanner.Scan())
+	require.Equal(t, "version 1\n", string(Payload(scanner.Bytes())))
+}
+
+func TestNext(t *testing.T) {
+	pkt, ok, err := Next([]byte("0008abcd0000"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "0008abcd", string(pkt))
+
+	pkt, ok, err = Next([]byte("00000008"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "0000", string(pkt))
+
+	_, ok, err = Next([]byte("0008ab"))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, _, err = Next([]byte("zzzz"))
+	require.Error(t, err)
+}
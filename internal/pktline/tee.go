@@ -0,0 +1,99 @@
+package pktline
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrStop is returned by the callback of a TeeReader once it has seen the
+// packets it was looking for
+var ErrStop = errors.New("pktline: stop inspecting")
+
+// TeeReader passes the data read from r through while calling fn with each
+// of its packets, e.g. to inspect the commands sent at the beginning of a
+// session. The inspection stops when fn returns ErrStop, when the data isn't
+// a pkt-line stream, or once limit bytes were inspected. Any other error
+// returned by fn fails the reads.
+type TeeReader struct {
+	r     io.Reader
+	fn    func(pkt []byte) error
+	limit int
+
+	buf       []byte
+	inspected int
+	done      bool
+	truncated bool
+	err       error
+}
+
+// NewTeeReader returns a TeeReader inspecting up to limit bytes, which must
+// be positive
+func NewTeeReader(r io.Reader, limit int, fn func(pkt []byte) error) *TeeReader {
+	return &TeeReader{r: r, fn: fn, limit: limit}
+}
+
+func (t *TeeReader) Read(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+
+	n, err := t.r.Read(p)
+
+	if !t.done && n > 0 {
+		if t.err = t.inspect(p[:n]); t.err != nil {
+			return 0, t.err
+		}
+	}
+
+	return n, err
+}
+
+// Truncated tells whether the inspection stopped at the limit, before fn
+// was done
+func (t *TeeReader) Truncated() bool {
+	return t.truncated
+}
+
+func (t *TeeReader) inspect(data []byte) error {
+	atLimit := false
+	if remaining := t.limit - t.inspected; len(data) >= remaining {
+		data = data[:remaining]
+		atLimit = true
+	}
+	t.inspected += len(data)
+	t.buf = append(t.buf, data...)
+
+	for !t.done {
+		pkt, ok, err := Next(t.buf)
+		if err != nil {
+			// Not a pkt-line stream, there is nothing to inspect
+			t.stop()
+			return nil
+		}
+		if !ok {
+			break
+		}
+		t.buf = t.buf[len(pkt):]
+
+		if err := t.fn(pkt); err != nil {
+			t.stop()
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	if atLimit && !t.done {
+		t.truncated = true
+		t.stop()
+	}
+
+	return nil
+}
+
+func (t *TeeReader) stop() {
+	t.done = true
+	t.buf = nil
+}
@@ -0,0 +1,93 @@
+package pktline
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func teeData(t *testing.T) []byte {
+	var buf bytes.Buffer
+
+	require.NoError(t, WriteString(&buf, "first\n"))
+	require.NoError(t, WriteString(&buf, "second\n"))
+	require.NoError(t, WriteFlush(&buf))
+	require.NoError(t, WriteString(&buf, "third\n"))
+	buf.WriteString("PACK" + strings.Repeat("x", 100))
+
+	return buf.Bytes()
+}
+
+func TestTeeReader(t *testing.T) {
+	data := teeData(t)
+
+	var payloads []string
+	r := NewTeeReader(iotest.OneByteReader(bytes.NewReader(data)), 1024, func(pkt []byte) error {
+		if IsFlush(pkt) {
+			return ErrStop
+		}
+
+		payloads = append(payloads, string(Payload(pkt)))
+		return nil
+	})
+
+	read, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+	require.Equal(t, []string{"first\n", "second\n"}, payloads)
+	require.False(t, r.Truncated())
+	require.Nil(t, r.buf)
+}
+
+func TestTeeReaderError(t *testing.T) {
+	denied := errors.New("denied")
+	r := NewTeeReader(bytes.NewReader(teeData(t)), 1024, func(pkt []byte) error {
+		if string(Payload(pkt)) == "second\n" {
+			return denied
+		}
+
+		return nil
+	})
+
+	_, err := io.ReadAll(r)
+	require.Equal(t, denied, err)
+
+	// The error sticks
+	_, err = r.Read(make([]byte, 10))
+	require.Equal(t, denied, err)
+}
+
+func TestTeeReaderLimit(t *testing.T) {
+	data := teeData(t)
+
+	var payloads []string
+	r := NewTeeReader(bytes.NewReader(data), 20, func(pkt []byte) error {
+		payloads = append(payloads, string(Payload(pkt)))
+		return nil
+	})
+
+	read, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+	require.Equal(t, []string{"first\n"}, payloads)
+	require.True(t, r.Truncated())
+}
+
+func TestTeeReaderInvalidData(t *testing.T) {
+	called := false
+	r := NewTeeReader(strings.NewReader("not a pkt-line stream"), 1024, func(pkt []byte) error {
+		called = true
+		return nil
+	})
+
+	read, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "not a pkt-line stream", string(read))
+	require.False(t, called)
+	require.False(t, r.Truncated())
+}
@@ -0,0 +1,126 @@
+// Package pushoptions captures the push options sent with `git push -o`,
+// which follow the ref update commands when the push-options capability was
+// requested: https://git-scm.com/docs/pack-protocol#_reference_update_request_and_packfile_transfer
+package pushoptions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
+)
+
+const (
+	capability = "push-options"
+
+	// maxInspected bounds the data inspected for the commands and the options
+	maxInspected = 4 * 1024 * 1024
+)
+
+// ErrLimitExceeded is returned when the push options exceed the limits
+var ErrLimitExceeded = errors.New("push options exceed the limits")
+
+// Limits bound the push options accepted. Zero means no limit.
+type Limits struct {
+	// MaxCount is the number of options
+	MaxCount int
+	// MaxSize is the size of each option, in bytes
+	MaxSize int
+}
+
+// Reader collects the push options of a git-receive-pack session while
+// passing the data through, and fails once they exceed the limits. The
+// options reach the server hooks through Gitaly along with the rest of the
+// data.
+type Reader struct {
+	tee    *pktline.TeeReader
+	limits Limits
+
+	readingOptions bool
+	enabled        bool
+	options        []string
+	err            error
+}
+
+func NewReader(r io.Reader, limits Limits) *Reader {
+	reader := &Reader{limits: limits}
+	reader.tee = pktline.NewTeeReader(r, maxInspected, reader.parse)
+
+	return reader
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.tee.Read(p)
+
+	// The commands are only partially inspected when they are too many, the
+	// options following them can't be left unchecked
+	if r.enabled && r.tee.Truncated() {
+		metrics.PushOptionsTotal.WithLabelValues("rejected").Inc()
+		r.err = fmt.Errorf("%w: the commands and push options must not exceed %d bytes", ErrLimitExceeded, maxInspected)
+		return 0, r.err
+	}
+
+	return n, err
+}
+
+// Options returns the push options read so far
+func (r *Reader) Options() []string {
+	return r.options
+}
+
+func (r *Reader) parse(pkt []byte) error {
+	if pktline.IsFlush(pkt) {
+		if !r.readingOptions && r.enabled {
+			r.readingOptions = true
+			return nil
+		}
+
+		return pktline.ErrStop
+	}
+
+	payload := pktline.Payload(pkt)
+	if !r.readingOptions {
+		if _, caps, found := bytes.Cut(payload, []byte{0}); found {
+			r.enabled = hasCapability(caps)
+		}
+
+		return nil
+	}
+
+	return r.add(strings.TrimSuffix(string(payload), "\n"))
+}
+
+func (r *Reader) add(option string) error {
+	if r.limits.MaxCount > 0 && len(r.options) >= r.limits.MaxCount {
+		metrics.PushOptionsTotal.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("%w: no more than %d push options are allowed", ErrLimitExceeded, r.limits.MaxCount)
+	}
+
+	if r.limits.MaxSize > 0 && len(option) > r.limits.MaxSize {
+		metrics.PushOptionsTotal.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("%w: push options must not exceed %d bytes", ErrLimitExceeded, r.limits.MaxSize)
+	}
+
+	metrics.PushOptionsTotal.WithLabelValues("accepted").Inc()
+	r.options = append(r.options, option)
+
+	return nil
+}
+
+func hasCapability(caps []byte) bool {
+	for _, c := range strings.Fields(string(caps)) {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
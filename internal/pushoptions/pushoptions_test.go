@@ -0,0 +1,121 @@
+package pushoptions
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
+)
+
+const (
+	oldOID = "0000000000000000000000000000000000000000"
+	newOID = "1111111111111111111111111111111111111111"
+)
+
+func push(t *testing.T, caps string, options ...string) []byte {
+	var buf bytes.Buffer
+
+	require.NoError(t, pktline.WriteString(&buf, oldOID+" "+newOID+" refs/heads/main\x00 report-status "+caps+"\n"))
+	require.NoError(t, pktline.WriteString(&buf, oldOID+" "+newOID+" refs/heads/feature\n"))
+	require.NoError(t, pktline.WriteFlush(&buf))
+
+	if options != nil {
+		for _, option := range options {
+			require.NoError(t, pktline.WriteString(&buf, option+"\n"))
+		}
+		require.NoError(t, pktline.WriteFlush(&buf))
+	}
+
+	buf.WriteString("PACK")
+
+	return buf.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		input   []byte
+		options []string
+	}{
+		{
+			desc:    "with push options",
+			input:   push(t, "push-options", "ci.skip", "merge_request.create"),
+			options: []string{"ci.skip", "merge_request.create"},
+		},
+		{
+			desc:  "without the push-options capability",
+			input: push(t, "side-band-64k"),
+		},
+		{
+			desc:  "not a pkt-line stream",
+			input: []byte("not a push"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			// Reading a byte at a time checks that packets split across reads
+			// are parsed
+			r := NewReader(iotest.OneByteReader(bytes.NewReader(tc.input)), Limits{})
+
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, tc.input, data)
+			require.Equal(t, tc.options, r.Options())
+		})
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	accepted := metrics.PushOptionsTotal.WithLabelValues("accepted")
+	rejected := metrics.PushOptionsTotal.WithLabelValues("rejected")
+	initialAccepted, initialRejected := testutil.ToFloat64(accepted), testutil.ToFloat64(rejected)
+
+	input := push(t, "push-options", "ci.skip", "ci.variable=NAME=value")
+
+	r := NewReader(bytes.NewReader(input), Limits{MaxCount: 2, MaxSize: 22})
+	_, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Len(t, r.Options(), 2)
+
+	r = NewReader(bytes.NewReader(input), Limits{MaxCount: 1})
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	require.EqualError(t, err, "push options exceed the limits: no more than 1 push options are allowed")
+
+	r = NewReader(bytes.NewReader(input), Limits{MaxSize: 10})
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	require.True(t, strings.HasSuffix(err.Error(), "push options must not exceed 10 bytes"))
+
+	// Subsequent reads keep failing
+	_, err = r.Read(make([]byte, 10))
+	require.ErrorIs(t, err, ErrLimitExceeded)
+
+	require.Equal(t, initialAccepted+4, testutil.ToFloat64(accepted))
+	require.Equal(t, initialRejected+2, testutil.ToFloat64(rejected))
+}
+
+func TestReaderTooManyCommands(t *testing.T) {
+	var buf bytes.Buffer
+
+	require.NoError(t, pktline.WriteString(&buf, oldOID+" "+newOID+" refs/heads/main\x00 report-status push-options\n"))
+	for buf.Len() <= maxInspected {
+		require.NoError(t, pktline.WriteString(&buf, oldOID+" "+newOID+" refs/heads/feature\n"))
+	}
+	require.NoError(t, pktline.WriteFlush(&buf))
+	require.NoError(t, pktline.WriteString(&buf, "ci.skip\n"))
+	require.NoError(t, pktline.WriteFlush(&buf))
+
+	r := NewReader(&buf, Limits{})
+	_, err := io.ReadAll(r)
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	require.EqualError(t, err, "push options exceed the limits: the commands and push options must not exceed 4194304 bytes")
+}
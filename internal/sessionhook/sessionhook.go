@@ -0,0 +1,241 @@
+// Package sessionhook runs the programs configured to be executed before and
+// after every command, so that sites can enforce local policies such as
+// maintenance freeze windows without patching gitlab-shell.
+package sessionhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/correlation"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/cgroups"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	// waitDelay is how long the output of a hook is waited on once it was
+	// killed, in case it left children holding it open
+	waitDelay = time.Second
+	// maxOutputSize is how much of the output of a hook is kept, to be
+	// shown to the user or logged
+	maxOutputSize = 4096
+
+	deniedMessage = "The command was denied by the pre-session hook of the server"
+	failedMessage = "The command could not be checked by the pre-session hook of the server"
+)
+
+type Hook string
+
+const (
+	PreSession  Hook = "pre_session"
+	PostSession Hook = "post_session"
+)
+
+// Payload is the JSON document written to the stdin of the hooks
+type Payload struct {
+	Hook          Hook      `json:"hook"`
+	Time          time.Time `json:"time"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Command       string    `json:"command"`
+	Args          []string  `json:"args,omitempty"`
+	Username      string    `json:"username,omitempty"`
+	KeyID         string    `json:"key_id,omitempty"`
+	Krb5Principal string    `json:"krb5principal,omitempty"`
+	RemoteAddr    string    `json:"remote_addr,omitempty"`
+	// Env holds the variables of the sshd allowed_env the client sent
+	Env map[string]string `json:"env,omitempty"`
+	// Only set for post-session hooks
+	Project   string  `json:"project,omitempty"`
+	DurationS float64 `json:"duration_s,omitempty"`
+	Result    string  `json:"result,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Runner runs the configured hooks. A nil *Runner is valid and doesn't run
+// anything.
+type Runner struct {
+	cfg     config.SessionHooksConfig
+	timeout time.Duration
+}
+
+// New returns a Runner, or nil if no hook is configured
+func New(cfg config.SessionHooksConfig) *Runner {
+	if cfg.PreSession == "" && cfg.PostSession == "" {
+		return nil
+	}
+
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &Runner{cfg: cfg, timeout: timeout}
+}
+
+// Session tracks a command being executed until the post-session hook is run
+type Session struct {
+	runner  *Runner
+	payload Payload
+	started time.Time
+	cgroup  *cgroups.Cgroup
+}
+
+// Start runs the pre-session hook for the command described by args, in
+// cgroup like the post-session hook. The command must not be executed when an
+// error is returned: the hook exited with a non-zero status or couldn't be
+// run.
+func (r *Runner) Start(ctx context.Context, args *commandargs.Shell, cgroup *cgroups.Cgroup) (*Session, error) {
+	if r == nil || args == nil {
+		return nil, nil
+	}
+
+	s := &Session{
+		runner:  r,
+		started: time.Now(),
+		cgroup:  cgroup,
+		payload: Payload{
+			CorrelationID: correlation.ExtractFromContext(ctx),
+			Command:       string(args.CommandType),
+			Args:          args.SshArgs,
+			Username:      args.GitlabUsername,
+			KeyID:         args.GitlabKeyId,
+			Krb5Principal: args.GitlabKrb5Principal,
+			RemoteAddr:    args.Env.RemoteAddr,
+			Env:           args.Env.Vars,
+		},
+	}
+
+	if r.cfg.PreSession == "" {
+		return s, nil
+	}
+
+	payload := s.payload
+	payload.Hook = PreSession
+	payload.Time = s.started.UTC()
+
+	output, err := r.run(ctx, ctx, r.cfg.PreSession, &payload, cgroup)
+	if err == nil {
+		return s, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		message := strings.TrimSpace(output)
+		if message == "" {
+			message = deniedMessage
+		}
+
+		return nil, errorcode.New(errorcode.AccessDenied, message)
+	}
+
+	return nil, errorcode.New(errorcode.Internal, failedMessage)
+}
+
+// Finish runs the post-session hook with the outcome of the command. It's a
+// no-op on a nil Session.
+func (s *Session) Finish(ctx context.Context, logData command.LogData, err error) {
+	if s == nil || s.runner.cfg.PostSession == "" {
+		return
+	}
+
+	payload := s.payload
+	payload.Hook = PostSession
+	payload.Time = time.Now().UTC()
+	payload.DurationS = time.Since(s.started).Seconds()
+	payload.Project = logData.Meta.Project
+	if logData.Username != "" {
+		payload.Username = logData.Username
+	}
+
+	payload.Result = "success"
+	if err != nil {
+		payload.Result = "failure"
+		payload.Error = err.Error()
+	}
+
+	// The client may have disconnected already, which cancels ctx
+	s.runner.run(ctx, context.Background(), s.runner.cfg.PostSession, &payload, s.cgroup)
+}
+
+// run executes program in cgroup with payload on stdin and returns what it
+// wrote to stdout. ctx is only used for logging, the program is killed once
+// execCtx is done or the timeout elapsed.
+func (r *Runner) run(ctx, execCtx context.Context, program string, payload *Payload, cgroup *cgroups.Cgroup) (string, error) {
+	ctxlog := logger.WithContextFields(ctx, log.Fields{"hook": payload.Hook, "program": program})
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		ctxlog.WithError(err).Error("sessionhook: failed to encode the payload")
+		metrics.SessionHooksRunsTotal.WithLabelValues(string(payload.Hook), "error").Inc()
+		return "", err
+	}
+
+	execCtx, cancel := context.WithTimeout(execCtx, r.timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: maxOutputSize}
+	stderr := &limitedBuffer{limit: maxOutputSize}
+
+	cmd := exec.CommandContext(execCtx, program)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = waitDelay
+	cgroup.Apply(cmd)
+
+	started := time.Now()
+	err = cmd.Run()
+
+	ctxlog = ctxlog.WithFields(log.Fields{"duration_s": time.Since(started).Seconds(), "stderr": stderr.String()})
+
+	if err != nil && execCtx.Err() != nil {
+		err = execCtx.Err()
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		ctxlog.Info("sessionhook: hook succeeded")
+		metrics.SessionHooksRunsTotal.WithLabelValues(string(payload.Hook), "success").Inc()
+	case errors.As(err, &exitErr) && exitErr.Exited():
+		ctxlog.WithField("exit_status", exitErr.ExitCode()).Warn("sessionhook: hook failed")
+		metrics.SessionHooksRunsTotal.WithLabelValues(string(payload.Hook), "failure").Inc()
+	default:
+		ctxlog.WithError(err).Error("sessionhook: failed to run the hook")
+		metrics.SessionHooksRunsTotal.WithLabelValues(string(payload.Hook), "error").Inc()
+	}
+
+	return stdout.String(), err
+}
+
+// limitedBuffer keeps the first bytes written to it and discards the rest,
+// so that a hook doesn't block on a full pipe
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.Buffer.Write(p[:remaining])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,176 @@
+package sessionhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/correlation"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+var args = &commandargs.Shell{
+	CommandType: commandargs.ReceivePack,
+	SshArgs:     []string{"git-receive-pack", "group/project.git"},
+	GitlabKeyId: "1",
+	Env:         sshenv.Env{RemoteAddr: "127.0.0.1", Vars: map[string]string{"GIT_TRACE_ID": "abc"}},
+}
+
+// writeHook writes a hook saving its payload to a file, then running script
+func writeHook(t *testing.T, script string) (string, string) {
+	dir := t.TempDir()
+	program := filepath.Join(dir, "hook")
+	payload := filepath.Join(dir, "payload.json")
+
+	require.NoError(t, os.WriteFile(program, []byte("#!/bin/sh\ncat > "+payload+"\n"+script+"\n"), 0755))
+
+	return program, payload
+}
+
+func readPayload(t *testing.T, path string) *Payload {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	payload := &Payload{}
+	require.NoError(t, json.Unmarshal(data, payload))
+
+	return payload
+}
+
+func TestNilRunner(t *testing.T) {
+	runner := New(config.SessionHooksConfig{Timeout: config.YamlDuration(time.Second)})
+	require.Nil(t, runner)
+
+	session, err := runner.Start(context.Background(), args, nil)
+	require.NoError(t, err)
+	require.Nil(t, session)
+
+	session.Finish(context.Background(), command.LogData{}, nil)
+}
+
+func TestPreSessionAllowed(t *testing.T) {
+	program, payloadPath := writeHook(t, "exit 0")
+	runner := New(config.SessionHooksConfig{PreSession: program})
+
+	initial := testutil.ToFloat64(metrics.SessionHooksRunsTotal.WithLabelValues("pre_session", "success"))
+
+	ctx := correlation.ContextWithCorrelation(context.Background(), "abc123")
+	session, err := runner.Start(ctx, args, nil)
+	require.NoError(t, err)
+	require.NotNil(t, session)
+
+	payload := readPayload(t, payloadPath)
+	require.Equal(t, PreSession, payload.Hook)
+	require.Equal(t, "abc123", payload.CorrelationID)
+	require.Equal(t, "git-receive-pack", payload.Command)
+	require.Equal(t, []string{"git-receive-pack", "group/project.git"}, payload.Args)
+	require.Equal(t, "1", payload.KeyID)
+	require.Equal(t, "127.0.0.1", payload.RemoteAddr)
+	require.Equal(t, map[string]string{"GIT_TRACE_ID": "abc"}, payload.Env)
+	require.Empty(t, payload.Result)
+
+	require.Equal(t, initial+1, testutil.ToFloat64(metrics.SessionHooksRunsTotal.WithLabelValues("pre_session", "success")))
+}
+
+func TestPreSessionDenied(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		script  string
+		message string
+	}{
+		{
+			desc:    "with a message",
+			script:  "echo 'Pushes are frozen until Monday'\nexit 1",
+			message: "Pushes are frozen until Monday",
+		},
+		{
+			desc:    "without a message",
+			script:  "exit 3",
+			message: deniedMessage,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			program, _ := writeHook(t, tc.script)
+			runner := New(config.SessionHooksConfig{PreSession: program})
+
+			initial := testutil.ToFloat64(metrics.SessionHooksRunsTotal.WithLabelValues("pre_session", "failure"))
+
+			session, err := runner.Start(context.Background(), args, nil)
+			require.Nil(t, session)
+			require.EqualError(t, err, tc.message)
+			require.Equal(t, errorcode.AccessDenied, errorcode.Classify(err))
+
+			require.Equal(t, initial+1, testutil.ToFloat64(metrics.SessionHooksRunsTotal.WithLabelValues("pre_session", "failure")))
+		})
+	}
+}
+
+func TestPreSessionFailed(t *testing.T) {
+	slow, _ := writeHook(t, "sleep 10")
+
+	testCases := []struct {
+		desc    string
+		program string
+	}{
+		{desc: "missing program", program: filepath.Join(t.TempDir(), "missing")},
+		{desc: "timeout", program: slow},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			runner := New(config.SessionHooksConfig{PreSession: tc.program, Timeout: config.YamlDuration(100 * time.Millisecond)})
+
+			initial := testutil.ToFloat64(metrics.SessionHooksRunsTotal.WithLabelValues("pre_session", "error"))
+
+			session, err := runner.Start(context.Background(), args, nil)
+			require.Nil(t, session)
+			require.EqualError(t, err, failedMessage)
+			require.Equal(t, errorcode.Internal, errorcode.Classify(err))
+
+			require.Equal(t, initial+1, testutil.ToFloat64(metrics.SessionHooksRunsTotal.WithLabelValues("pre_session", "error")))
+		})
+	}
+}
+
+func TestPostSession(t *testing.T) {
+	program, payloadPath := writeHook(t, "exit 1")
+	runner := New(config.SessionHooksConfig{PostSession: program})
+
+	session, err := runner.Start(context.Background(), args, nil)
+	require.NoError(t, err)
+	_, err = os.Stat(payloadPath)
+	require.True(t, os.IsNotExist(err), "only the post-session hook is configured")
+
+	// The hook is run even though the session is over
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	initial := testutil.ToFloat64(metrics.SessionHooksRunsTotal.WithLabelValues("post_session", "failure"))
+
+	session.Finish(ctx, command.NewLogData("group/project", "alex-doe"), errors.New("push rejected"))
+
+	payload := readPayload(t, payloadPath)
+	require.Equal(t, PostSession, payload.Hook)
+	require.Equal(t, "git-receive-pack", payload.Command)
+	require.Equal(t, "group/project", payload.Project)
+	require.Equal(t, "alex-doe", payload.Username)
+	require.Equal(t, "failure", payload.Result)
+	require.Equal(t, "push rejected", payload.Error)
+	require.Positive(t, payload.DurationS)
+
+	require.Equal(t, initial+1, testutil.ToFloat64(metrics.SessionHooksRunsTotal.WithLabelValues("post_session", "failure")))
+}
@@ -0,0 +1,48 @@
+package sessionrecord
+
+import (
+	"io"
+	"regexp"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
+)
+
+// maxCommandsSize bounds the data inspected while looking for the ref update
+// commands, pushes updating more refs than fit are only partially recorded
+const maxCommandsSize = 1024 * 1024
+
+var refUpdateRegexp = regexp.MustCompile(`\A([0-9a-f]{40}|[0-9a-f]{64}) ([0-9a-f]{40}|[0-9a-f]{64}) ([^\x00\n]+)`)
+
+// RefUpdate is a ref update command sent by git push
+type RefUpdate struct {
+	Ref    string `json:"ref"`
+	OldOID string `json:"old_oid"`
+	NewOID string `json:"new_oid"`
+}
+
+// refUpdatesReader collects the ref update commands sent at the beginning of
+// a git-receive-pack session, which end with a flush packet, while passing
+// the data through
+type refUpdatesReader struct {
+	*pktline.TeeReader
+	updates []RefUpdate
+}
+
+func newRefUpdatesReader(r io.Reader) *refUpdatesReader {
+	reader := &refUpdatesReader{}
+	reader.TeeReader = pktline.NewTeeReader(r, maxCommandsSize, reader.add)
+
+	return reader
+}
+
+func (r *refUpdatesReader) add(pkt []byte) error {
+	if pktline.IsFlush(pkt) {
+		return pktline.ErrStop
+	}
+
+	if m := refUpdateRegexp.FindSubmatch(pktline.Payload(pkt)); m != nil {
+		r.updates = append(r.updates, RefUpdate{OldOID: string(m[1]), NewOID: string(m[2]), Ref: string(m[3])})
+	}
+
+	return nil
+}
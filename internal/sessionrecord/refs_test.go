@@ -0,0 +1,55 @@
+package sessionrecord
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/pktline"
+)
+
+const (
+	zeroOID = "0000000000000000000000000000000000000000"
+	oldOID  = "1111111111111111111111111111111111111111"
+	newOID  = "2222222222222222222222222222222222222222"
+)
+
+func pushData(t *testing.T) []byte {
+	var buf bytes.Buffer
+
+	require.NoError(t, pktline.WriteString(&buf, oldOID+" "+newOID+" refs/heads/main\x00report-status side-band-64k\n"))
+	require.NoError(t, pktline.WriteString(&buf, zeroOID+" "+newOID+" refs/tags/v1.0\n"))
+	require.NoError(t, pktline.WriteString(&buf, oldOID+" "+zeroOID+" refs/heads/feature\n"))
+	require.NoError(t, pktline.WriteFlush(&buf))
+	buf.WriteString("PACK" + strings.Repeat("x", 100))
+
+	return buf.Bytes()
+}
+
+func TestRefUpdatesReader(t *testing.T) {
+	data := pushData(t)
+	r := newRefUpdatesReader(iotest.OneByteReader(bytes.NewReader(data)))
+
+	read, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+
+	require.Equal(t, []RefUpdate{
+		{Ref: "refs/heads/main", OldOID: oldOID, NewOID: newOID},
+		{Ref: "refs/tags/v1.0", OldOID: zeroOID, NewOID: newOID},
+		{Ref: "refs/heads/feature", OldOID: oldOID, NewOID: zeroOID},
+	}, r.updates)
+	require.False(t, r.Truncated())
+}
+
+func TestRefUpdatesReaderInvalidData(t *testing.T) {
+	r := newRefUpdatesReader(strings.NewReader("not a pkt-line stream"))
+
+	_, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Empty(t, r.updates)
+}
@@ -0,0 +1,236 @@
+// Package sessionrecord writes a JSON record of every git command executed,
+// including the refs pushed, for ingestion by SIEM systems. The records are
+// written to a spool directory or posted to a webhook. Kafka isn't supported
+// as a sink, the spool directory is meant to be shipped by a log collector.
+package sessionrecord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/correlation"
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/cgroups"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+const defaultWebhookTimeout = 5 * time.Second
+
+// Record describes a single git command executed over SSH
+type Record struct {
+	Time          time.Time   `json:"time"`
+	CorrelationID string      `json:"correlation_id,omitempty"`
+	Command       string      `json:"command"`
+	Project       string      `json:"project,omitempty"`
+	Username      string      `json:"username,omitempty"`
+	KeyID         string      `json:"key_id,omitempty"`
+	Krb5Principal string      `json:"krb5principal,omitempty"`
+	ActorType     string      `json:"actor_type,omitempty"`
+	RemoteAddr    string      `json:"remote_addr,omitempty"`
+	RefUpdates    []RefUpdate `json:"ref_updates,omitempty"`
+	// ReadBytes is the data sent by the client, mostly the pack of a push.
+	// WrittenBytes is the data sent to it, mostly the pack of a fetch.
+	ReadBytes    int64   `json:"read_bytes"`
+	WrittenBytes int64   `json:"written_bytes"`
+	DurationS    float64 `json:"duration_s"`
+	Result       string  `json:"result"`
+	Error        string  `json:"error,omitempty"`
+	// Resources is what the helper processes of the session used in their
+	// cgroup, when session cgroups are enabled
+	Resources *cgroups.Usage `json:"resources,omitempty"`
+}
+
+// Recorder delivers records to the configured sinks. A nil *Recorder is valid
+// and doesn't record anything.
+type Recorder struct {
+	cfg    config.SessionRecordingConfig
+	client *http.Client
+}
+
+// New returns a Recorder posting to the webhook with transport, e.g.
+// config.Config.HTTPTransport, or nil if session recording isn't enabled
+func New(cfg config.SessionRecordingConfig, transport http.RoundTripper) *Recorder {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	timeout := time.Duration(cfg.WebhookTimeout)
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	return &Recorder{cfg: cfg, client: &http.Client{Transport: transport, Timeout: timeout}}
+}
+
+// Session tracks a command being executed until it's recorded
+type Session struct {
+	recorder *Recorder
+	record   *Record
+	started  time.Time
+	refs     *refUpdatesReader
+}
+
+// Start begins recording the command described by args when it's a git
+// command. The data sent by the client must be read through the returned
+// reader, so that the refs pushed are recorded.
+func (r *Recorder) Start(ctx context.Context, args *commandargs.Shell, in io.Reader) (*Session, io.Reader) {
+	if r == nil || args == nil || !isGitCommand(args.CommandType) {
+		return nil, in
+	}
+
+	s := &Session{
+		recorder: r,
+		started:  time.Now(),
+		record: &Record{
+			CorrelationID: correlation.ExtractFromContext(ctx),
+			Command:       string(args.CommandType),
+			Username:      args.GitlabUsername,
+			KeyID:         args.GitlabKeyId,
+			Krb5Principal: args.GitlabKrb5Principal,
+			ActorType:     string(args.ActorType),
+			RemoteAddr:    args.Env.RemoteAddr,
+		},
+	}
+
+	if args.CommandType == commandargs.ReceivePack {
+		s.refs = newRefUpdatesReader(in)
+		in = s.refs
+	}
+
+	return s, in
+}
+
+// SetResources records what the helper processes of the session used. It's a
+// no-op on a nil Session.
+func (s *Session) SetResources(usage *cgroups.Usage) {
+	if s == nil {
+		return
+	}
+
+	s.record.Resources = usage
+}
+
+// Finish records the outcome of the command. It's a no-op on a nil Session.
+func (s *Session) Finish(ctx context.Context, logData command.LogData, readBytes, writtenBytes int64, err error) {
+	if s == nil {
+		return
+	}
+
+	record := s.record
+	record.Time = s.started.UTC()
+	record.DurationS = time.Since(s.started).Seconds()
+	record.Project = logData.Meta.Project
+	if logData.Username != "" {
+		record.Username = logData.Username
+	}
+	record.ReadBytes = readBytes
+	record.WrittenBytes = writtenBytes
+	if s.refs != nil {
+		record.RefUpdates = s.refs.updates
+	}
+
+	record.Result = "success"
+	if err != nil {
+		record.Result = "failure"
+		record.Error = err.Error()
+	}
+
+	s.recorder.write(ctx, record)
+}
+
+func (r *Recorder) write(ctx context.Context, record *Record) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		logger.WithContextFields(ctx, log.Fields{}).WithError(err).Error("sessionrecord: failed to encode the record")
+		return
+	}
+
+	if r.cfg.SpoolDir != "" {
+		r.deliver(ctx, "spool", func() error { return r.spool(record, data) })
+	}
+
+	if r.cfg.WebhookURL != "" {
+		r.deliver(ctx, "webhook", func() error { return r.post(data) })
+	}
+}
+
+func (r *Recorder) deliver(ctx context.Context, sink string, write func() error) {
+	if err := write(); err != nil {
+		logger.WithContextFields(ctx, log.Fields{"sink": sink}).WithError(err).Error("sessionrecord: failed to write the record")
+		metrics.SessionRecordsTotal.WithLabelValues(sink, "failure").Inc()
+		return
+	}
+
+	metrics.SessionRecordsTotal.WithLabelValues(sink, "success").Inc()
+}
+
+// spool writes the record to its own file, renamed into place once complete
+// so that collectors never pick up partial records
+func (r *Recorder) spool(record *Record, data []byte) error {
+	name := fmt.Sprintf("%s-%s.json", record.Time.Format("20060102T150405.000000000Z"), record.CorrelationID)
+
+	tmp, err := os.CreateTemp(r.cfg.SpoolDir, ".record-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(r.cfg.SpoolDir, name))
+}
+
+// post isn't bound to the context of the session, which may be canceled by
+// the time the record is delivered
+func (r *Recorder) post(data []byte) error {
+	request, err := http.NewRequest(http.MethodPost, r.cfg.WebhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	if r.cfg.WebhookToken != "" {
+		request.Header.Set("Authorization", "Bearer "+r.cfg.WebhookToken)
+	}
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+func isGitCommand(commandType commandargs.CommandType) bool {
+	for _, gitCmd := range commandargs.GitCommands {
+		if commandType == gitCmd {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,112 @@
+package sessionrecord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/gitlab-org/labkit/correlation"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
+)
+
+func TestRecordPush(t *testing.T) {
+	records := make(chan *Record, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		record := &Record{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(record))
+		records <- record
+	}))
+	defer server.Close()
+
+	spoolDir := t.TempDir()
+	recorder := New(config.SessionRecordingConfig{Enabled: true, SpoolDir: spoolDir, WebhookURL: server.URL, WebhookToken: "token"}, nil)
+
+	ctx := correlation.ContextWithCorrelation(context.Background(), "abc123")
+	args := &commandargs.Shell{
+		CommandType: commandargs.ReceivePack,
+		GitlabKeyId: "1",
+		Env:         sshenv.Env{RemoteAddr: "127.0.0.1"},
+	}
+
+	data := pushData(t)
+	session, in := recorder.Start(ctx, args, bytes.NewReader(data))
+	_, err := io.ReadAll(in)
+	require.NoError(t, err)
+
+	session.Finish(ctx, command.NewLogData("group/project", "alex-doe"), int64(len(data)), 10, nil)
+
+	posted := <-records
+	require.Equal(t, "abc123", posted.CorrelationID)
+	require.Equal(t, "git-receive-pack", posted.Command)
+	require.Equal(t, "group/project", posted.Project)
+	require.Equal(t, "alex-doe", posted.Username)
+	require.Equal(t, "1", posted.KeyID)
+	require.Equal(t, "127.0.0.1", posted.RemoteAddr)
+	require.Len(t, posted.RefUpdates, 3)
+	require.Equal(t, int64(len(data)), posted.ReadBytes)
+	require.Equal(t, int64(10), posted.WrittenBytes)
+	require.Equal(t, "success", posted.Result)
+
+	files, err := filepath.Glob(filepath.Join(spoolDir, "*-abc123.json"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	spooled, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+
+	expected, err := json.Marshal(posted)
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(spooled))
+}
+
+func TestRecordFailure(t *testing.T) {
+	spoolDir := t.TempDir()
+	recorder := New(config.SessionRecordingConfig{Enabled: true, SpoolDir: spoolDir}, nil)
+
+	session, in := recorder.Start(context.Background(), &commandargs.Shell{CommandType: commandargs.UploadPack}, bytes.NewReader(nil))
+	_, isRefReader := in.(*refUpdatesReader)
+	require.False(t, isRefReader)
+
+	session.Finish(context.Background(), command.LogData{}, 0, 0, errors.New("access denied"))
+
+	files, err := filepath.Glob(filepath.Join(spoolDir, "*.json"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	record := &Record{}
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, record))
+	require.Equal(t, "failure", record.Result)
+	require.Equal(t, "access denied", record.Error)
+}
+
+func TestNotRecorded(t *testing.T) {
+	in := bytes.NewReader(nil)
+
+	session, reader := New(config.SessionRecordingConfig{}, nil).Start(context.Background(), &commandargs.Shell{CommandType: commandargs.ReceivePack}, in)
+	require.Nil(t, session)
+	require.Same(t, in, reader)
+
+	recorder := New(config.SessionRecordingConfig{Enabled: true, SpoolDir: t.TempDir()}, nil)
+	session, reader = recorder.Start(context.Background(), &commandargs.Shell{CommandType: commandargs.Discover}, in)
+	require.Nil(t, session)
+	require.Same(t, in, reader)
+
+	// Finishing a session that isn't recorded is a no-op
+	session.Finish(context.Background(), command.LogData{}, 0, 0, nil)
+}
@@ -14,8 +14,14 @@ import (
 	grpcstatus "google.golang.org/grpc/status"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bandwidth"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disabledcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/events"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 
 	"gitlab.com/gitlab-org/labkit/log"
@@ -34,6 +40,11 @@ type connection struct {
 	nconn              net.Conn
 	maxSessions        int64
 	remoteAddr         string
+	events             *events.Publisher
+	sessionClasses     *sessionClasses
+	// handshakeDone is called once the handshake and authentication of the
+	// connection are done, successfully or not
+	handshakeDone func()
 }
 
 type channelHandler func(context.Context, *ssh.ServerConn, ssh.Channel, <-chan *ssh.Request) error
@@ -71,17 +82,33 @@ func (c *connection) handle(ctx context.Context, srvCfg *ssh.ServerConfig, handl
 }
 
 func (c *connection) initServerConn(ctx context.Context, srvCfg *ssh.ServerConfig) (*ssh.ServerConn, <-chan ssh.NewChannel, error) {
+	if c.handshakeDone != nil {
+		defer c.handshakeDone()
+	}
+
 	if c.cfg.Server.LoginGraceTime > 0 {
 		c.nconn.SetDeadline(time.Now().Add(time.Duration(c.cfg.Server.LoginGraceTime)))
 		defer c.nconn.SetDeadline(time.Time{})
 	}
 
-	sconn, chans, reqs, err := ssh.NewServerConn(c.nconn, srvCfg)
+	srvCfg, offeredKeys := countOfferedKeys(srvCfg)
+
+	sniffer := newKexSniffer(c.nconn)
+	sconn, chans, reqs, err := ssh.NewServerConn(sniffer, srvCfg)
+	if kex := negotiatedKeyExchange(sniffer.clientKeyExchanges(), srvCfg.KeyExchanges); kex != "" {
+		metrics.SshdKeyExchangesTotal.WithLabelValues(kex).Inc()
+	}
+
+	result := authResult(err)
+	metrics.SshdOfferedKeys.WithLabelValues(result).Observe(float64(*offeredKeys))
+	if result == "failure" || result == "max_auth_tries" {
+		c.events.Publish(ctx, events.AuthFailed, map[string]interface{}{"remote_addr": c.remoteAddr, "reason": result})
+	}
 	if err != nil {
 		msg := "connection: initServerConn: failed to initialize SSH connection"
 		logger := log.WithContextFields(ctx, log.Fields{"remote_addr": c.remoteAddr}).WithError(err)
 
-		if strings.Contains(err.Error(), "no common algorithm for host key") || err.Error() == "EOF" {
+		if result == "probe" || strings.Contains(err.Error(), "no common algorithm for host key") || err.Error() == "EOF" {
 			logger.Debug(msg)
 		} else {
 			logger.Warn(msg)
@@ -94,22 +121,105 @@ func (c *connection) initServerConn(ctx context.Context, srvCfg *ssh.ServerConfi
 	return sconn, chans, err
 }
 
+// countOfferedKeys returns a copy of srvCfg that counts the public keys the
+// client offers while authenticating
+func countOfferedKeys(srvCfg *ssh.ServerConfig) (*ssh.ServerConfig, *int) {
+	offeredKeys := 0
+
+	callback := srvCfg.PublicKeyCallback
+	if callback == nil {
+		return srvCfg, &offeredKeys
+	}
+
+	counting := *srvCfg
+	counting.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		offeredKeys++
+
+		return callback(conn, key)
+	}
+
+	return &counting, &offeredKeys
+}
+
+// onlyProbed returns whether the client only attempted the "none" method,
+// which clients and scanners use to find out the supported methods
+func onlyProbed(err *ssh.ServerAuthError) bool {
+	if len(err.Errors) == 0 {
+		return false
+	}
+
+	for _, err := range err.Errors {
+		if !errors.Is(err, ssh.ErrNoAuth) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func authResult(err error) string {
+	var authErr *ssh.ServerAuthError
+	switch {
+	case err == nil:
+		return "success"
+	case strings.Contains(err.Error(), "too many authentication failures"):
+		return "max_auth_tries"
+	case errors.As(err, &authErr) && onlyProbed(authErr):
+		return "probe"
+	case errors.As(err, &authErr):
+		return "failure"
+	default:
+		return "error"
+	}
+}
+
 func (c *connection) handleRequests(ctx context.Context, sconn *ssh.ServerConn, chans <-chan ssh.NewChannel, handler channelHandler) {
 	ctxlog := log.WithContextFields(ctx, log.Fields{"remote_addr": c.remoteAddr})
+	class := c.sessionClasses.forConn(sconn)
 
 	for newChannel := range chans {
 		ctxlog.WithField("channel_type", newChannel.ChannelType()).Info("connection: handle: new channel requested")
 
-		if newChannel.ChannelType() != "session" {
+		// Sessions are still accepted for the user to be told why every
+		// command is refused
+		if isDenyListed(sconn) && newChannel.ChannelType() != "session" {
+			ctxlog.Info("connection: handleRequests: channel of a deny-listed key rejected")
+			newChannel.Reject(ssh.Prohibited, "the key is deny-listed")
+			continue
+		}
+
+		switch newChannel.ChannelType() {
+		case "session":
+		case "direct-tcpip":
+			c.handleDirectTCPIP(ctx, ctxlog, sconn, newChannel)
+			continue
+		case "forwarded-tcpip":
+			// Only servers open these channels, for ports forwarded to them
+			c.rejectForwarding(ctxlog, newChannel)
+			continue
+		default:
 			ctxlog.Info("connection: handleRequests: unknown channel type")
 			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
 			continue
 		}
 
+		if !class.tryAcquire() {
+			ctxlog.WithField("session_class", class.name).Info("connection: handleRequests: too many concurrent sessions of the class")
+			newChannel.Reject(ssh.ResourceShortage, "too many concurrent sessions")
+			continue
+		}
+
 		if !c.concurrentSessions.TryAcquire(1) {
+			metrics.SshdHitMaxSessions.Inc()
+
+			if c.cfg.Server.ConcurrentSessionsWait > 0 {
+				c.queueSession(ctx, ctxlog, sconn, newChannel, class, handler)
+				continue
+			}
+
+			class.release()
 			ctxlog.Info("connection: handleRequests: too many concurrent sessions")
 			newChannel.Reject(ssh.ResourceShortage, "too many concurrent sessions")
-			metrics.SshdHitMaxSessions.Inc()
 			continue
 		}
 
@@ -117,31 +227,11 @@ func (c *connection) handleRequests(ctx context.Context, sconn *ssh.ServerConn,
 		if err != nil {
 			ctxlog.WithError(err).Error("connection: handleRequests: accepting channel failed")
 			c.concurrentSessions.Release(1)
+			class.release()
 			continue
 		}
 
-		go func() {
-			defer func(started time.Time) {
-				duration := time.Since(started).Seconds()
-				metrics.SshdSessionDuration.Observe(duration)
-				ctxlog.WithFields(log.Fields{"duration_s": duration}).Info("connection: handleRequests: done")
-			}(time.Now())
-
-			defer c.concurrentSessions.Release(1)
-
-			// Prevent a panic in a single session from taking out the whole server
-			defer func() {
-				if err := recover(); err != nil {
-					ctxlog.WithField("recovered_error", err).Error("panic handling session")
-				}
-			}()
-
-			metrics.SliSshdSessionsTotal.Inc()
-			err := handler(ctx, sconn, channel, requests)
-			if err != nil {
-				c.trackError(ctxlog, err)
-			}
-		}()
+		go c.handleSession(ctx, ctxlog, sconn, channel, requests, class, handler)
 	}
 
 	// When a connection has been prematurely closed we block execution until all concurrent sessions are released
@@ -153,6 +243,77 @@ func (c *connection) handleRequests(ctx context.Context, sconn *ssh.ServerConn,
 	c.concurrentSessions.Acquire(ctx, c.maxSessions)
 }
 
+// queueSession accepts a session exceeding the concurrent sessions limit and
+// runs it once another session of the connection completed, or rejects it
+// once the configured wait elapsed. The user is told they are waiting.
+func (c *connection) queueSession(ctx context.Context, ctxlog *logrus.Entry, sconn *ssh.ServerConn, newChannel ssh.NewChannel, class *sessionClass, handler channelHandler) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		ctxlog.WithError(err).Error("connection: queueSession: accepting channel failed")
+		class.release()
+		return
+	}
+
+	go func() {
+		ctxlog.Info("connection: queueSession: too many concurrent sessions, waiting for an available slot")
+		console.DisplayProgressMessage(i18n.Translate(c.cfg.Locale, "Too many concurrent sessions, waiting for an available slot..."), channel.Stderr())
+
+		waitCtx, cancel := context.WithTimeout(ctx, time.Duration(c.cfg.Server.ConcurrentSessionsWait))
+		defer cancel()
+
+		started := time.Now()
+		if err := c.concurrentSessions.Acquire(waitCtx, 1); err != nil {
+			console.DisplayWarningMessage(i18n.Translate(c.cfg.Locale, "ERROR: Too many concurrent sessions, please try again later."), channel.Stderr())
+			channel.SendRequest("exit-status", false, ssh.Marshal(exitStatusReq{ExitStatus: 1}))
+			channel.Close()
+			class.release()
+
+			ctxlog.Info("connection: queueSession: no slot became available")
+			metrics.SshdQueuedSessionsTotal.WithLabelValues("timeout").Inc()
+			return
+		}
+
+		ctxlog.WithField("wait_s", time.Since(started).Seconds()).Info("connection: queueSession: slot available")
+		metrics.SshdQueuedSessionsTotal.WithLabelValues("started").Inc()
+
+		c.handleSession(ctx, ctxlog, sconn, channel, requests, class, handler)
+	}()
+}
+
+// handleSession runs the handler of a session holding a slot of the
+// concurrent sessions limits of the connection and of its class, and
+// releases them
+func (c *connection) handleSession(ctx context.Context, ctxlog *logrus.Entry, sconn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request, class *sessionClass, handler channelHandler) {
+	defer func(started time.Time) {
+		duration := time.Since(started).Seconds()
+		metrics.SshdSessionDuration.Observe(duration)
+
+		info, err := connTCPInfo(c.nconn)
+		if err != nil {
+			ctxlog.WithError(err).Debug("connection: handleRequests: failed to get TCP info")
+		}
+		info.observe()
+
+		ctxlog.WithFields(log.Fields{"duration_s": duration}).WithFields(info.logFields()).Info("connection: handleRequests: done")
+	}(time.Now())
+
+	defer c.concurrentSessions.Release(1)
+	defer class.release()
+
+	// The transfers of the class share its bandwidth
+	ctx = bandwidth.ContextWithLimiter(ctx, class.bandwidthLimiter())
+
+	// Prevent a panic in a single session from taking out the whole
+	// connection, or server
+	defer recoverChannelPanic(ctxlog, channel, true)
+
+	metrics.SliSshdSessionsTotal.Inc()
+	err := handler(ctx, sconn, channel, requests)
+	if err != nil {
+		c.trackError(ctxlog, err)
+	}
+}
+
 func (c *connection) sendKeepAliveMsg(ctx context.Context, sconn *ssh.ServerConn, ticker *time.Ticker) {
 	ctxlog := log.WithContextFields(ctx, log.Fields{"remote_addr": c.remoteAddr})
 
@@ -178,6 +339,16 @@ func (c *connection) trackError(ctxlog *logrus.Entry, err error) {
 		return
 	}
 
+	var limitErr *accessverifier.LimitExceededError
+	if errors.As(err, &limitErr) {
+		return
+	}
+
+	var disabledErr *disabledcommand.Error
+	if errors.As(err, &disabledErr) {
+		return
+	}
+
 	grpcCode := grpcstatus.Code(err)
 	if grpcCode == grpccodes.Canceled || grpcCode == grpccodes.Unavailable {
 		return
@@ -188,3 +359,9 @@ func (c *connection) trackError(ctxlog *logrus.Entry, err error) {
 	metrics.SliSshdSessionsErrorsTotal.Inc()
 	ctxlog.WithError(err).Warn("connection: session error")
 }
+
+// isDenyListed returns whether the key the connection was authenticated with
+// is deny-listed, see handleUserKey
+func isDenyListed(sconn *ssh.ServerConn) bool {
+	return sconn != nil && sconn.Permissions != nil && sconn.Permissions.Extensions["key-deny-listed"] != ""
+}
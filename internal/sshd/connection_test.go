@@ -1,8 +1,12 @@
 package sshd
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -15,6 +19,7 @@ import (
 	grpcstatus "google.golang.org/grpc/status"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/accessverifier"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
@@ -29,6 +34,7 @@ type fakeNewChannel struct {
 	channelType string
 	extraData   []byte
 	acceptErr   error
+	channel     ssh.Channel
 
 	acceptCh chan struct{}
 	rejectCh chan rejectCall
@@ -39,7 +45,7 @@ func (f *fakeNewChannel) Accept() (ssh.Channel, <-chan *ssh.Request, error) {
 		f.acceptCh <- struct{}{}
 	}
 
-	return nil, nil, f.acceptErr
+	return f.channel, nil, f.acceptErr
 }
 
 func (f *fakeNewChannel) Reject(reason ssh.RejectionReason, message string) error {
@@ -91,20 +97,40 @@ func setup(sessionsNum int64, newChannel *fakeNewChannel) (*connection, chan ssh
 	return conn, chans
 }
 
+// raisePanic panics after setting site to where it does
+func raisePanic(site *string) {
+	pc, file, line, _ := runtime.Caller(0)
+	*site = fmt.Sprintf("%s (%s:%d)", runtime.FuncForPC(pc).Name(), filepath.Base(file), line+3)
+
+	panic("This is a panic")
+}
+
 func TestPanicDuringSessionIsRecovered(t *testing.T) {
-	newChannel := &fakeNewChannel{channelType: "session"}
+	stdErr := &bytes.Buffer{}
+	channel := &fakeChannel{stdErr: stdErr, stdOut: &bytes.Buffer{}}
+	newChannel := &fakeNewChannel{channelType: "session", channel: channel}
 	conn, chans := setup(1, newChannel)
 
+	var site string
 	numSessions := 0
 	require.NotPanics(t, func() {
 		conn.handleRequests(context.Background(), nil, chans, func(context.Context, *ssh.ServerConn, ssh.Channel, <-chan *ssh.Request) error {
 			numSessions += 1
 			close(chans)
-			panic("This is a panic")
+			raisePanic(&site)
+			return nil
 		})
 	})
 
 	require.Equal(t, numSessions, 1)
+
+	// Only the channel is terminated, with an explanation and an exit status
+	require.Equal(t, "remote: \nremote: ========================================================================\nremote: \nremote: "+sessionPanicMessage+"\nremote: \nremote: ========================================================================\nremote: \n", stdErr.String())
+	require.Equal(t, "exit-status", channel.sentRequestName)
+	require.Equal(t, ssh.Marshal(exitStatusReq{ExitStatus: panicExitStatus}), channel.sentRequestPayload)
+
+	require.Contains(t, site, "sshd.raisePanic (connection_test.go:")
+	require.InDelta(t, 1, testutil.ToFloat64(metrics.SshdPanicsTotal.WithLabelValues("channel", panicFingerprint(site))), 0.1)
 }
 
 func TestUnknownChannelType(t *testing.T) {
@@ -124,6 +150,80 @@ func TestUnknownChannelType(t *testing.T) {
 	require.Equal(t, expectedRejection, rejectionData)
 }
 
+func TestForwardingRejected(t *testing.T) {
+	for _, channelType := range []string{"direct-tcpip", "forwarded-tcpip"} {
+		t.Run(channelType, func(t *testing.T) {
+			rejectCh := make(chan rejectCall)
+			defer close(rejectCh)
+
+			newChannel := &fakeNewChannel{channelType: channelType, rejectCh: rejectCh}
+			conn, chans := setup(1, newChannel)
+
+			rejected := metrics.SshdForwardingRequestsTotal.WithLabelValues(channelType, "rejected")
+			initialRejected := testutil.ToFloat64(rejected)
+
+			go func() {
+				conn.handleRequests(context.Background(), nil, chans, nil)
+			}()
+
+			rejectionData := <-rejectCh
+
+			expectedRejection := rejectCall{reason: ssh.Prohibited, message: "port forwarding is not supported"}
+			require.Equal(t, expectedRejection, rejectionData)
+			require.InDelta(t, initialRejected+1, testutil.ToFloat64(rejected), 0.1)
+		})
+	}
+}
+
+func TestDenyListedKeyChannels(t *testing.T) {
+	sconn := &ssh.ServerConn{Permissions: &ssh.Permissions{Extensions: map[string]string{"key-deny-listed": "revoked"}}}
+
+	for _, channelType := range []string{"direct-tcpip", "unknown session"} {
+		t.Run(channelType, func(t *testing.T) {
+			rejectCh := make(chan rejectCall)
+			defer close(rejectCh)
+
+			newChannel := &fakeNewChannel{channelType: channelType, rejectCh: rejectCh}
+			conn, chans := setup(1, newChannel)
+
+			go func() {
+				conn.handleRequests(context.Background(), sconn, chans, nil)
+			}()
+
+			require.Equal(t, rejectCall{reason: ssh.Prohibited, message: "the key is deny-listed"}, <-rejectCh)
+		})
+	}
+
+	// Sessions are accepted to tell the user why
+	newChannel := &fakeNewChannel{channelType: "session", channel: &fakeChannel{stdErr: &bytes.Buffer{}, stdOut: &bytes.Buffer{}}}
+	conn, chans := setup(1, newChannel)
+
+	handled := make(chan struct{})
+	go conn.handleRequests(context.Background(), sconn, chans, func(context.Context, *ssh.ServerConn, ssh.Channel, <-chan *ssh.Request) error {
+		close(chans)
+		close(handled)
+		return nil
+	})
+	<-handled
+}
+
+func TestForwardingTargetOfDenyListedKey(t *testing.T) {
+	conn := &connection{cfg: &config.Config{Server: config.ServerConfig{PortForwarding: config.PortForwardingConfig{
+		AllowedTargets: []string{"127.0.0.1:8075"},
+		AllowedKeyIDs:  []string{"1"},
+	}}}}
+	newChannel := &fakeNewChannel{channelType: "direct-tcpip", extraData: ssh.Marshal(directTCPIPPayload{Host: "127.0.0.1", Port: 8075})}
+
+	sconn := &ssh.ServerConn{Permissions: &ssh.Permissions{Extensions: map[string]string{"key-id": "1"}}}
+	target, ok := conn.forwardingTarget(sconn, newChannel)
+	require.True(t, ok)
+	require.Equal(t, "127.0.0.1:8075", target)
+
+	sconn.Permissions.Extensions["key-deny-listed"] = "revoked"
+	_, ok = conn.forwardingTarget(sconn, newChannel)
+	require.False(t, ok)
+}
+
 func TestTooManySessions(t *testing.T) {
 	rejectCh := make(chan rejectCall)
 	defer close(rejectCh)
@@ -145,6 +245,77 @@ func TestTooManySessions(t *testing.T) {
 	require.Equal(t, <-rejectCh, rejectCall{reason: ssh.ResourceShortage, message: "too many concurrent sessions"})
 }
 
+func TestQueuedSessions(t *testing.T) {
+	first := &fakeNewChannel{channelType: "session"}
+	conn, chans := setup(1, first)
+	conn.cfg.Server.ConcurrentSessionsWait = config.YamlDuration(time.Minute)
+
+	started := metrics.SshdQueuedSessionsTotal.WithLabelValues("started")
+	initialStarted := testutil.ToFloat64(started)
+
+	release := make(chan struct{})
+	handled := make(chan ssh.Channel)
+	go func() {
+		conn.handleRequests(context.Background(), nil, chans, func(_ context.Context, _ *ssh.ServerConn, channel ssh.Channel, _ <-chan *ssh.Request) error {
+			handled <- channel
+			<-release
+			return nil
+		})
+	}()
+	require.Nil(t, <-handled)
+
+	stdErr := &bytes.Buffer{}
+	queued := &fakeNewChannel{channelType: "session", channel: &fakeChannel{stdErr: stdErr, stdOut: &bytes.Buffer{}}}
+	chans <- queued
+
+	// The queued session only runs once the first one completed
+	select {
+	case <-handled:
+		t.Fatal("the queued session ran before a slot was available")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	require.Equal(t, queued.channel, <-handled)
+	require.Equal(t, "remote: Too many concurrent sessions, waiting for an available slot...\n", stdErr.String())
+	require.InDelta(t, initialStarted+1, testutil.ToFloat64(started), 0.1)
+
+	close(chans)
+}
+
+func TestQueuedSessionTimesOut(t *testing.T) {
+	first := &fakeNewChannel{channelType: "session"}
+	conn, chans := setup(1, first)
+	conn.cfg.Server.ConcurrentSessionsWait = config.YamlDuration(10 * time.Millisecond)
+
+	timeouts := metrics.SshdQueuedSessionsTotal.WithLabelValues("timeout")
+	initialTimeouts := testutil.ToFloat64(timeouts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handled := make(chan struct{}, 2)
+	go func() {
+		conn.handleRequests(context.Background(), nil, chans, func(context.Context, *ssh.ServerConn, ssh.Channel, <-chan *ssh.Request) error {
+			handled <- struct{}{}
+			<-ctx.Done() // Keep the first session open until the end of the test
+			return nil
+		})
+	}()
+	<-handled
+
+	stdErr := &bytes.Buffer{}
+	channel := &fakeChannel{stdErr: stdErr, stdOut: &bytes.Buffer{}}
+	chans <- &fakeNewChannel{channelType: "session", channel: channel}
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(timeouts) == initialTimeouts+1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, handled, 0)
+	require.Equal(t, "exit-status", channel.sentRequestName)
+	require.Contains(t, stdErr.String(), "ERROR: Too many concurrent sessions, please try again later.")
+}
+
 func TestAcceptSessionSucceeds(t *testing.T) {
 	newChannel := &fakeNewChannel{channelType: "session"}
 	conn, chans := setup(1, newChannel)
@@ -224,6 +395,7 @@ func TestSessionsMetrics(t *testing.T) {
 		{"unavailable Gitaly", grpcstatus.Error(grpccodes.Unavailable, "unavailable")},
 		{"api error", &client.ApiError{"api error"}},
 		{"disallowed command", disallowedcommand.Error},
+		{"limit exceeded", &accessverifier.LimitExceededError{Message: "size limit exceeded"}},
 		{"not our ref", grpcstatus.Error(grpccodes.Internal, `rpc error: code = Internal desc = cmd wait: exit status 128, stderr: "fatal: git upload-pack: not our ref 9106d18f6a1b8022f6517f479696f3e3ea5e68c1"`)},
 	} {
 		t.Run(ignoredError.desc, func(t *testing.T) {
@@ -247,3 +419,84 @@ func eventuallyInDelta(t *testing.T, expected, actual, delta float64) {
 		return ((expected - actual) < delta)
 	}, 1*time.Second, time.Millisecond)
 }
+
+func TestCountOfferedKeys(t *testing.T) {
+	srvCfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, errors.New("unknown key")
+		},
+	}
+
+	counting, offeredKeys := countOfferedKeys(srvCfg)
+	require.NotSame(t, srvCfg, counting)
+
+	for i := 0; i < 3; i++ {
+		_, err := counting.PublicKeyCallback(nil, nil)
+		require.EqualError(t, err, "unknown key")
+	}
+	require.Equal(t, 3, *offeredKeys)
+
+	withoutKeys := &ssh.ServerConfig{}
+	counting, offeredKeys = countOfferedKeys(withoutKeys)
+	require.Same(t, withoutKeys, counting)
+	require.Zero(t, *offeredKeys)
+}
+
+func TestAuthResult(t *testing.T) {
+	require.Equal(t, "success", authResult(nil))
+	require.Equal(t, "failure", authResult(&ssh.ServerAuthError{}))
+	require.Equal(t, "failure", authResult(&ssh.ServerAuthError{Errors: []error{ssh.ErrNoAuth, errors.New("unknown key")}}))
+	require.Equal(t, "probe", authResult(&ssh.ServerAuthError{Errors: []error{ssh.ErrNoAuth}}))
+	require.Equal(t, "max_auth_tries", authResult(errors.New("ssh: disconnect, reason 2: too many authentication failures")))
+	require.Equal(t, "error", authResult(errors.New("EOF")))
+}
+
+func TestPanicSite(t *testing.T) {
+	var site string
+	func() {
+		defer func() {
+			recover()
+			site = panicSite()
+		}()
+
+		var m map[string]int
+		m["runtime error"] = 1
+	}()
+
+	require.Regexp(t, `^gitlab\.com/gitlab-org/gitlab-shell/v14/internal/sshd\.TestPanicSite\.func1 \(connection_test\.go:\d+\)$`, site)
+	require.Len(t, panicFingerprint(site), 12)
+}
+
+func TestStartupLimiter(t *testing.T) {
+	limiter := newStartupLimiter(config.MaxStartups{Start: 2, Rate: 30, Full: 4})
+	var roll int
+	limiter.random = func() int { return roll }
+
+	require.False(t, limiter.drop(1))
+	// 30% of the connections are dropped at start, rising to 65% halfway
+	roll = 29
+	require.True(t, limiter.drop(2))
+	roll = 30
+	require.False(t, limiter.drop(2))
+	roll = 64
+	require.True(t, limiter.drop(3))
+	roll = 65
+	require.False(t, limiter.drop(3))
+	roll = 99
+	require.True(t, limiter.drop(4))
+
+	require.False(t, newStartupLimiter(config.MaxStartups{}).drop(1000))
+
+	hardLimit := newStartupLimiter(config.MaxStartups{Start: 1, Rate: 100, Full: 1})
+	release, ok := hardLimit.tryAcquire()
+	require.True(t, ok)
+	_, ok = hardLimit.tryAcquire()
+	require.False(t, ok)
+
+	// Releasing more than once frees a single slot
+	release()
+	release()
+	require.Zero(t, hardLimit.inProgress.Load())
+	_, ok = hardLimit.tryAcquire()
+	require.True(t, ok)
+}
@@ -0,0 +1,223 @@
+package sshd
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const controlShutdownTimeout = 5 * time.Second
+
+// ControlOpts are the admin actions of the control socket implemented
+// outside of the server
+type ControlOpts struct {
+	// Reload reads the configuration again and applies the settings that
+	// can change at runtime
+	Reload func() error
+	// Drain gracefully shuts the server down
+	Drain func(reason string)
+}
+
+// ControlListener listens on the control socket, readable and writable by
+// the user running gitlab-sshd only. A socket left behind by a previous
+// process is replaced.
+func ControlListener(cfg *config.Config) (net.Listener, error) {
+	path := cfg.Server.ControlSocket.Path
+
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// ServeControl serves the admin RPCs on listener until ctx is done. Each RPC
+// requires the token of the control socket as a bearer token.
+func (s *Server) ServeControl(ctx context.Context, listener net.Listener, opts ControlOpts) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		if err := opts.Reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		log.WithContextFields(r.Context(), nil).Info("control: configuration reloaded")
+		writeJSONResponse(w, http.StatusOK, map[string]interface{}{"reloaded": true})
+	})
+	mux.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		opts.Drain("control socket")
+		writeJSONResponse(w, http.StatusAccepted, map[string]interface{}{"draining": true})
+	})
+	mux.HandleFunc("/sessions", s.handleSessions)
+	mux.HandleFunc("/maintenance", s.handleMaintenance)
+	mux.HandleFunc("/access_cache", s.handleAccessCache)
+	mux.HandleFunc("/capture_api", s.handleAPICapture)
+	mux.HandleFunc("/log_level", handleLogLevel)
+
+	server := &http.Server{Handler: s.controlAuth(mux), ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), controlShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// controlAuth requires the token of the control socket
+func (s *Server) controlAuth(next http.Handler) http.Handler {
+	token := s.Config.Server.ControlSocket.Token
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	w.WriteHeader(http.StatusMethodNotAllowed)
+
+	return false
+}
+
+// handleSessions lists the active sessions, the oldest first
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	sessions := s.sessions.list()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].started.Before(sessions[j].started) })
+
+	list := make([]map[string]interface{}, 0, len(sessions))
+	for _, session := range sessions {
+		fields := session.logFields()
+		fields["started_at"] = session.started.UTC().Format(time.RFC3339)
+		fields["duration_s"] = time.Since(session.started).Seconds()
+		list = append(list, fields)
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"sessions": list})
+}
+
+// handleLogLevel changes the log level with a POST request of level, for
+// the duration given, if any, after which the level is reverted
+func handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var duration time.Duration
+		if value := r.FormValue("duration"); value != "" {
+			var err error
+			duration, err = time.ParseDuration(value)
+			if err != nil || duration < 0 {
+				http.Error(w, "duration must be a positive duration, e.g. 10m", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := logger.SetLevelFor(r.FormValue("level"), duration); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.WithContextFields(r.Context(), log.Fields{"level": logger.Level(), "duration_s": duration.Seconds()}).Info("control: log level changed")
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := map[string]interface{}{"level": logger.Level()}
+	if revertAt := logger.LevelRevertAt(); !revertAt.IsZero() {
+		body["revert_at"] = revertAt.UTC().Format(time.RFC3339)
+	}
+
+	writeJSONResponse(w, http.StatusOK, body)
+}
+
+// sessionRegistry tracks the active sessions for the control socket to list
+// them
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+}
+
+// add tracks session until the returned func is called
+func (r *sessionRegistry) add(s *session) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions == nil {
+		r.sessions = make(map[*session]struct{})
+	}
+	r.sessions[s] = struct{}{}
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		delete(r.sessions, s)
+	}
+}
+
+func (r *sessionRegistry) list() []*session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions := make([]*session, 0, len(r.sessions))
+	for session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+
+	return sessions
+}
@@ -0,0 +1,163 @@
+package sshd
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+)
+
+type controlClient struct {
+	t      *testing.T
+	client *http.Client
+}
+
+func (c *controlClient) do(method, path, token string, form url.Values) (int, map[string]interface{}) {
+	request, err := http.NewRequest(method, "http://gitlab-sshd"+path, strings.NewReader(form.Encode()))
+	require.NoError(c.t, err)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := c.client.Do(request)
+	require.NoError(c.t, err)
+	defer response.Body.Close()
+
+	var body map[string]interface{}
+	json.NewDecoder(response.Body).Decode(&body)
+
+	return response.StatusCode, body
+}
+
+func TestControlSocket(t *testing.T) {
+	defer logger.SetLevel(logger.Level())
+
+	cfg := &config.Config{Server: config.DefaultServerConfig}
+	cfg.Server.ControlSocket = config.ControlSocketConfig{Path: filepath.Join(t.TempDir(), "control.sock"), Token: "token"}
+
+	s := &Server{Config: cfg}
+	s.sessions.add(&session{cfg: cfg, gitlabUsername: "alex", remoteAddr: "127.0.0.1:2222", started: time.Now()})
+
+	listener, err := ControlListener(cfg)
+	require.NoError(t, err)
+
+	info, err := os.Stat(cfg.Server.ControlSocket.Path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloads := 0
+	drained := make(chan string, 1)
+	go s.ServeControl(ctx, listener, ControlOpts{
+		Reload: func() error { reloads++; return nil },
+		Drain:  func(reason string) { drained <- reason },
+	})
+
+	c := &controlClient{t: t, client: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", cfg.Server.ControlSocket.Path)
+		},
+	}}}
+
+	code, _ := c.do(http.MethodGet, "/sessions", "", nil)
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = c.do(http.MethodGet, "/sessions", "wrong", nil)
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	code, body := c.do(http.MethodGet, "/sessions", "token", nil)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, body["sessions"], 1)
+	session := body["sessions"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, "alex", session["username"])
+	require.Equal(t, "127.0.0.1:2222", session["remote_addr"])
+
+	code, body = c.do(http.MethodPost, "/log_level", "token", url.Values{"level": {"debug"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "debug", body["level"])
+	require.Equal(t, "debug", logger.Level())
+
+	code, _ = c.do(http.MethodPost, "/log_level", "token", url.Values{"level": {"verbose"}})
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, body = c.do(http.MethodPost, "/log_level", "token", url.Values{"level": {"trace"}, "duration": {"10m"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "trace", body["level"])
+	require.NotEmpty(t, body["revert_at"])
+
+	logger.RevertLevel()
+	require.Equal(t, "debug", logger.Level())
+
+	code, _ = c.do(http.MethodPost, "/log_level", "token", url.Values{"level": {"trace"}, "duration": {"soon"}})
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, body = c.do(http.MethodPost, "/maintenance", "token", url.Values{"enabled": {"true"}, "message": {"Upgrading"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, map[string]interface{}{"enabled": true, "message": "Upgrading"}, body)
+
+	code, body = c.do(http.MethodPost, "/capture_api", "token", url.Values{"enabled": {"true"}})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, map[string]interface{}{"enabled": true}, body)
+
+	code, body = c.do(http.MethodDelete, "/access_cache", "token", nil)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, map[string]interface{}{"invalidated": 0.0}, body)
+
+	code, _ = c.do(http.MethodGet, "/reload", "token", nil)
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+
+	code, _ = c.do(http.MethodPost, "/reload", "token", nil)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 1, reloads)
+
+	code, _ = c.do(http.MethodPost, "/drain", "token", nil)
+	require.Equal(t, http.StatusAccepted, code)
+	require.Equal(t, "control socket", <-drained)
+}
+
+func TestStateChangingEndpointsMoveToControlSocket(t *testing.T) {
+	cfg := &config.Config{Server: config.DefaultServerConfig}
+	cfg.Server.Profiling = config.ProfilingConfig{Username: "admin", Password: "secret"}
+	cfg.Server.ControlSocket = config.ControlSocketConfig{Path: "/run/gitlab-shell/control.sock", Token: "token"}
+
+	mux := (&Server{Config: cfg}).MonitoringServeMux()
+
+	for _, target := range []string{"/debug/maintenance?enabled=true", "/debug/capture_api?enabled=true", "/debug/access_cache"} {
+		r := httptest.NewRecorder()
+		mux.ServeHTTP(r, adminRequest(http.MethodPost, target))
+		require.Equal(t, http.StatusNotFound, r.Result().StatusCode, target)
+	}
+
+	r := httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest(http.MethodGet, "/debug/config"))
+	require.Equal(t, http.StatusOK, r.Result().StatusCode)
+}
+
+func TestControlListenerReplacesStaleSocket(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.ControlSocket.Path = filepath.Join(t.TempDir(), "control.sock")
+
+	stale, err := net.Listen("unix", cfg.Server.ControlSocket.Path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := ControlListener(cfg)
+	require.NoError(t, err)
+	listener.Close()
+}
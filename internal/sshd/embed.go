@@ -0,0 +1,20 @@
+package sshd
+
+import (
+	"context"
+
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+)
+
+// AuthFunc authenticates the key offered by user before it's looked up in
+// GitLab, for programs embedding the server to authenticate keys unknown to
+// GitLab. It returns nil permissions for the key to be looked up in GitLab,
+// and permissions with the extensions set by handleUserKey, e.g. key-id, to
+// authenticate it.
+type AuthFunc func(ctx context.Context, user string, key ssh.PublicKey) (*ssh.Permissions, error)
+
+// CommandHook is called with the arguments of every command before it runs,
+// and rejects it by returning an error, which is shown to the user
+type CommandHook func(ctx context.Context, args *commandargs.Shell) error
@@ -0,0 +1,150 @@
+package sshd
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+
+	"gitlab.com/gitlab-org/labkit/fips"
+)
+
+// fipsMinRSABits is the smallest RSA modulus approved for signatures
+const fipsMinRSABits = 2048
+
+var (
+	fipsMACs = []string{
+		"hmac-sha2-256-etm@openssh.com",
+		"hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256",
+		"hmac-sha2-512",
+	}
+
+	fipsKeyExchanges = []string{
+		"ecdh-sha2-nistp256",
+		"ecdh-sha2-nistp384",
+		"ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256",
+		"diffie-hellman-group16-sha512",
+	}
+
+	fipsCiphers = []string{
+		"aes128-gcm@openssh.com",
+		"aes256-gcm@openssh.com",
+		"aes128-ctr",
+		"aes192-ctr",
+		"aes256-ctr",
+	}
+
+	// fipsPublicKeyAlgorithms are the signature algorithms accepted for the
+	// keys and certificates of the users
+	fipsPublicKeyAlgorithms = []string{
+		ssh.CertAlgoECDSA256v01,
+		ssh.CertAlgoECDSA384v01,
+		ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01,
+		ssh.CertAlgoRSASHA256v01,
+		ssh.KeyAlgoECDSA256,
+		ssh.KeyAlgoECDSA384,
+		ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512,
+		ssh.KeyAlgoRSASHA256,
+	}
+
+	// fipsModuleEnabled reports whether the crypto of the binary is provided
+	// by a FIPS validated module
+	fipsModuleEnabled = fips.Enabled
+)
+
+// checkFIPSCompliance reports every setting of the server that isn't FIPS
+// compliant, so that they can all be fixed at once
+func checkFIPSCompliance(cfg *config.Config, hostKeys []ssh.Signer) error {
+	var violations []string
+
+	if !fipsModuleEnabled() {
+		violations = append(violations, "the binary doesn't use a FIPS validated crypto module, build it with FIPS_MODE=1 and enable FIPS on the host")
+	}
+
+	violations = append(violations, unapprovedAlgorithms("macs", cfg.Server.MACs, fipsMACs)...)
+	violations = append(violations, unapprovedAlgorithms("kex_algorithms", cfg.Server.KexAlgorithms, fipsKeyExchanges)...)
+	violations = append(violations, unapprovedAlgorithms("ciphers", cfg.Server.Ciphers, fipsCiphers)...)
+
+	for _, hostKey := range hostKeys {
+		if err := checkFIPSHostKey(hostKey.PublicKey()); err != nil {
+			violations = append(violations, err.Error())
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("fips_mode: the configuration isn't FIPS compliant: %s", strings.Join(violations, "; "))
+}
+
+func unapprovedAlgorithms(setting string, configured, approved []string) []string {
+	var violations []string
+	for _, algorithm := range configured {
+		if !contains(approved, algorithm) {
+			violations = append(violations, fmt.Sprintf("sshd %s %q isn't FIPS approved", setting, algorithm))
+		}
+	}
+
+	return violations
+}
+
+func checkFIPSHostKey(key ssh.PublicKey) error {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+
+	switch key.Type() {
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return nil
+	case ssh.KeyAlgoRSA:
+		if cryptoKey, ok := key.(ssh.CryptoPublicKey); ok {
+			if rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey); ok && rsaKey.N.BitLen() >= fipsMinRSABits {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("host key %s is an RSA key of less than %d bits", ssh.FingerprintSHA256(key), fipsMinRSABits)
+	default:
+		return fmt.Errorf("host key %s of type %s isn't FIPS approved", ssh.FingerprintSHA256(key), key.Type())
+	}
+}
+
+// fipsHostKeys restricts the RSA host keys to SHA-2 signatures, as SHA-1
+// ones aren't approved
+func fipsHostKeys(hostKeys []ssh.Signer) ([]ssh.Signer, error) {
+	restricted := make([]ssh.Signer, 0, len(hostKeys))
+	for _, hostKey := range hostKeys {
+		var algorithms []string
+		switch hostKey.PublicKey().Type() {
+		case ssh.KeyAlgoRSA:
+			algorithms = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}
+		case ssh.CertAlgoRSAv01:
+			algorithms = []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01}
+		default:
+			restricted = append(restricted, hostKey)
+			continue
+		}
+
+		algorithmSigner, ok := hostKey.(ssh.AlgorithmSigner)
+		if !ok {
+			return nil, fmt.Errorf("fips_mode: host key %s can't sign with SHA-2", ssh.FingerprintSHA256(hostKey.PublicKey()))
+		}
+
+		signer, err := ssh.NewSignerWithAlgorithms(algorithmSigner, algorithms)
+		if err != nil {
+			return nil, fmt.Errorf("fips_mode: %w", err)
+		}
+
+		restricted = append(restricted, signer)
+	}
+
+	return restricted, nil
+}
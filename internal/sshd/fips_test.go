@@ -0,0 +1,85 @@
+package sshd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
+)
+
+func stubFIPSModule(t *testing.T, enabled bool) {
+	previous := fipsModuleEnabled
+	fipsModuleEnabled = func() bool { return enabled }
+	t.Cleanup(func() { fipsModuleEnabled = previous })
+}
+
+func TestFIPSAlgorithms(t *testing.T) {
+	srvCfg := &serverConfig{cfg: &config.Config{FIPSMode: true}}
+	sshServerConfig := srvCfg.get(context.Background())
+
+	require.Equal(t, fipsMACs, sshServerConfig.MACs)
+	require.Equal(t, fipsKeyExchanges, sshServerConfig.KeyExchanges)
+	require.Equal(t, fipsCiphers, sshServerConfig.Ciphers)
+	require.Equal(t, fipsPublicKeyAlgorithms, sshServerConfig.PublicKeyAuthAlgorithms)
+
+	srvCfg.cfg.Server.Ciphers = []string{"aes256-ctr"}
+	require.Equal(t, []string{"aes256-ctr"}, srvCfg.get(context.Background()).Ciphers)
+}
+
+func TestCheckFIPSCompliance(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ed25519Key, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+
+	cfg := &config.Config{
+		FIPSMode: true,
+		Server: config.ServerConfig{
+			MACs:          []string{"hmac-sha2-256", "hmac-sha1"},
+			KexAlgorithms: []string{"curve25519-sha256"},
+			Ciphers:       []string{"chacha20-poly1305@openssh.com"},
+		},
+	}
+
+	stubFIPSModule(t, false)
+	err = checkFIPSCompliance(cfg, []ssh.Signer{ed25519Key})
+	require.EqualError(t, err, "fips_mode: the configuration isn't FIPS compliant: "+
+		"the binary doesn't use a FIPS validated crypto module, build it with FIPS_MODE=1 and enable FIPS on the host; "+
+		`sshd macs "hmac-sha1" isn't FIPS approved; `+
+		`sshd kex_algorithms "curve25519-sha256" isn't FIPS approved; `+
+		`sshd ciphers "chacha20-poly1305@openssh.com" isn't FIPS approved; `+
+		"host key "+ssh.FingerprintSHA256(ed25519Key.PublicKey())+" of type ssh-ed25519 isn't FIPS approved")
+
+	stubFIPSModule(t, true)
+	require.NoError(t, checkFIPSCompliance(&config.Config{FIPSMode: true}, nil))
+}
+
+func TestNewServerConfigFIPS(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+	cfg := &config.Config{
+		GitlabUrl: "http://localhost",
+		FIPSMode:  true,
+		Server:    config.ServerConfig{HostKeyFiles: []string{path.Join(testRoot, "certs/valid/server.key")}},
+	}
+
+	stubFIPSModule(t, false)
+	_, err := newServerConfig(cfg)
+	require.ErrorContains(t, err, "the binary doesn't use a FIPS validated crypto module")
+
+	stubFIPSModule(t, true)
+	srvCfg, err := newServerConfig(cfg)
+	require.NoError(t, err)
+
+	// The RSA host key doesn't sign with SHA-1
+	require.Len(t, srvCfg.hostKeys, 1)
+	signer, ok := srvCfg.hostKeys[0].(ssh.MultiAlgorithmSigner)
+	require.True(t, ok)
+	require.Equal(t, []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}, signer.Algorithms())
+}
@@ -0,0 +1,186 @@
+package sshd
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	portForwardingNotSupported = "port forwarding is not supported"
+
+	x11ForwardingRequest   = "x11-req"
+	agentForwardingRequest = "auth-agent-req@openssh.com"
+
+	x11ForwardingNotSupported   = "X11 forwarding is not supported by this server"
+	agentForwardingNotSupported = "Agent forwarding is not supported by this server"
+)
+
+var forwardingDialTimeout = 10 * time.Second
+
+// directTCPIPPayload is the extra data of a direct-tcpip channel, see
+// RFC 4254 section 7.2
+type directTCPIPPayload struct {
+	Host       string
+	Port       uint32
+	OriginHost string
+	OriginPort uint32
+}
+
+// handleDirectTCPIP connects the channel to the requested target when
+// forwarding to it is allowed for the key used to authenticate
+func (c *connection) handleDirectTCPIP(ctx context.Context, ctxlog *logrus.Entry, sconn *ssh.ServerConn, newChannel ssh.NewChannel) {
+	target, ok := c.forwardingTarget(sconn, newChannel)
+	if !ok {
+		c.rejectForwarding(ctxlog, newChannel)
+		return
+	}
+
+	ctxlog = ctxlog.WithField("forwarding_target", target)
+
+	if !c.concurrentSessions.TryAcquire(1) {
+		ctxlog.Info("connection: handleDirectTCPIP: too many concurrent sessions")
+		newChannel.Reject(ssh.ResourceShortage, "too many concurrent sessions")
+		metrics.SshdHitMaxSessions.Inc()
+		return
+	}
+
+	dialer := &net.Dialer{Timeout: forwardingDialTimeout}
+	targetConn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		ctxlog.WithError(err).Warn("connection: handleDirectTCPIP: failed to connect to the forwarding target")
+		newChannel.Reject(ssh.ConnectionFailed, "failed to connect to "+target)
+		metrics.SshdForwardingRequestsTotal.WithLabelValues(newChannel.ChannelType(), "failed").Inc()
+		c.concurrentSessions.Release(1)
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		ctxlog.WithError(err).Error("connection: handleDirectTCPIP: accepting channel failed")
+		targetConn.Close()
+		c.concurrentSessions.Release(1)
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	ctxlog.Info("connection: handleDirectTCPIP: forwarding")
+	metrics.SshdForwardingRequestsTotal.WithLabelValues(newChannel.ChannelType(), "allowed").Inc()
+
+	go func() {
+		defer c.concurrentSessions.Release(1)
+		defer recoverChannelPanic(ctxlog, channel, false)
+
+		forward(ctx, channel, targetConn)
+	}()
+}
+
+func (c *connection) forwardingTarget(sconn *ssh.ServerConn, newChannel ssh.NewChannel) (string, bool) {
+	forwarding := c.cfg.Server.PortForwarding
+	if len(forwarding.AllowedTargets) == 0 || sconn == nil || sconn.Permissions == nil {
+		return "", false
+	}
+
+	// A compromised key doesn't forward ports, even when it's allowed to
+	if isDenyListed(sconn) {
+		return "", false
+	}
+
+	if !contains(forwarding.AllowedKeyIDs, sconn.Permissions.Extensions["key-id"]) {
+		return "", false
+	}
+
+	var payload directTCPIPPayload
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		return "", false
+	}
+
+	target := net.JoinHostPort(payload.Host, strconv.FormatUint(uint64(payload.Port), 10))
+
+	return target, contains(forwarding.AllowedTargets, target)
+}
+
+func (c *connection) rejectForwarding(ctxlog *logrus.Entry, newChannel ssh.NewChannel) {
+	ctxlog.Info("connection: handleRequests: port forwarding rejected")
+	newChannel.Reject(ssh.Prohibited, portForwardingNotSupported)
+	metrics.SshdForwardingRequestsTotal.WithLabelValues(newChannel.ChannelType(), "rejected").Inc()
+}
+
+// handleForwardingReq rejects the X11 and agent forwarding requests, as
+// gitlab-sshd opens neither X11 nor agent channels. OpenSSH doesn't report the
+// rejections, the user is told about them when no command is run, for Git not
+// to show them on every fetch.
+func (s *session) handleForwardingReq(ctx context.Context, req *ssh.Request) bool {
+	requestType, notice := "x11", x11ForwardingNotSupported
+	if req.Type == agentForwardingRequest {
+		requestType, notice = "agent", agentForwardingNotSupported
+	}
+
+	if req.WantReply {
+		if err := req.Reply(false, []byte{}); err != nil {
+			logger.ContextLogger(ctx).WithError(err).Debug("session: handleForwardingReq: Failed to reply")
+		}
+	}
+
+	s.forwardingNotices = append(s.forwardingNotices, notice)
+
+	logger.WithContextFields(ctx, log.Fields{"request_type": requestType}).Info("session: handleForwardingReq: forwarding request rejected")
+	metrics.SshdSessionForwardingRequestsTotal.WithLabelValues(requestType).Inc()
+
+	return true
+}
+
+// forward copies data both ways until both sides are done, or the
+// connection is closed
+func forward(ctx context.Context, channel ssh.Channel, targetConn net.Conn) {
+	defer channel.Close()
+	defer targetConn.Close()
+
+	go func() {
+		<-ctx.Done()
+		targetConn.Close()
+	}()
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		if _, err := io.Copy(targetConn, channel); err != nil {
+			logger.WithContextFields(ctx, log.Fields{}).WithError(err).Debug("connection: forward: copying to the target failed")
+		}
+		if tcpConn, ok := targetConn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+
+	go func() {
+		if _, err := io.Copy(channel, targetConn); err != nil {
+			logger.WithContextFields(ctx, log.Fields{}).WithError(err).Debug("connection: forward: copying from the target failed")
+		}
+		channel.CloseWrite()
+		done <- struct{}{}
+	}()
+
+	<-done
+	<-done
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,175 @@
+package sshd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+const (
+	msgKexInit = 20
+	// maxKexInitSniff bounds the bytes buffered to find the KEXINIT of the
+	// client, which is at most 35000 bytes long (RFC 4253, section 6.1)
+	maxKexInitSniff = 35000
+)
+
+var (
+	// postQuantumKeyExchanges are the hybrid post-quantum key exchanges, in
+	// the order OpenSSH prefers them
+	postQuantumKeyExchanges = []string{
+		"mlkem768x25519-sha256",
+		"sntrup761x25519-sha512",
+		"sntrup761x25519-sha512@openssh.com",
+	}
+
+	// implementedKeyExchanges are the key exchanges golang.org/x/crypto/ssh
+	// implements for servers. The others fail the handshake once negotiated,
+	// so they are never advertised. TestImplementedKeyExchanges tells when an
+	// upgrade of x/crypto implements more.
+	implementedKeyExchanges = []string{
+		"curve25519-sha256",
+		"curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256",
+		"ecdh-sha2-nistp384",
+		"ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256",
+		"diffie-hellman-group16-sha512",
+		"diffie-hellman-group14-sha1",
+		"diffie-hellman-group1-sha1",
+	}
+)
+
+// keyExchanges returns the key exchanges advertised by the server, leaving
+// out the ones that aren't implemented
+func keyExchanges(cfg *config.Config) []string {
+	var candidates []string
+	switch {
+	case len(cfg.Server.KexAlgorithms) > 0:
+		candidates = cfg.Server.KexAlgorithms
+	case cfg.FIPSMode:
+		candidates = fipsKeyExchanges
+	case cfg.Server.PostQuantumKex == config.PostQuantumKexDisabled:
+		candidates = supportedKeyExchanges
+	default:
+		candidates = append(append([]string{}, postQuantumKeyExchanges...), supportedKeyExchanges...)
+	}
+
+	var kex []string
+	for _, algorithm := range candidates {
+		if !contains(implementedKeyExchanges, algorithm) {
+			continue
+		}
+		if cfg.Server.PostQuantumKex == config.PostQuantumKexDisabled && contains(postQuantumKeyExchanges, algorithm) {
+			continue
+		}
+
+		kex = append(kex, algorithm)
+	}
+
+	return kex
+}
+
+// unimplementedKeyExchanges returns the configured key exchanges that aren't
+// implemented, and so aren't advertised
+func unimplementedKeyExchanges(cfg *config.Config) []string {
+	var unimplemented []string
+	for _, algorithm := range cfg.Server.KexAlgorithms {
+		if !contains(implementedKeyExchanges, algorithm) {
+			unimplemented = append(unimplemented, algorithm)
+		}
+	}
+
+	return unimplemented
+}
+
+// negotiatedKeyExchange returns the key exchange negotiated for the
+// algorithms of the client and the server: the first algorithm of the client
+// the server supports (RFC 4253, section 7.1)
+func negotiatedKeyExchange(client, server []string) string {
+	for _, algorithm := range client {
+		if contains(server, algorithm) {
+			return algorithm
+		}
+	}
+
+	return ""
+}
+
+// kexSniffer records the key exchange algorithms of the client from its
+// KEXINIT, which is sent in the clear before the first key exchange
+type kexSniffer struct {
+	net.Conn
+
+	buf        []byte
+	algorithms []string
+	// done is set once the KEXINIT was read, as the connection keeps being
+	// read from by the SSH transport after the handshake returned
+	done atomic.Bool
+}
+
+func newKexSniffer(conn net.Conn) *kexSniffer {
+	return &kexSniffer{Conn: conn}
+}
+
+func (s *kexSniffer) Read(p []byte) (int, error) {
+	n, err := s.Conn.Read(p)
+	if n > 0 && !s.done.Load() {
+		s.buf = append(s.buf, p[:n]...)
+
+		algorithms, complete := parseKexInit(s.buf)
+		if complete || len(s.buf) > maxKexInitSniff {
+			s.algorithms = algorithms
+			s.buf = nil
+			s.done.Store(true)
+		}
+	}
+
+	return n, err
+}
+
+// clientKeyExchanges returns the key exchange algorithms of the client, or
+// nil when its KEXINIT wasn't read
+func (s *kexSniffer) clientKeyExchanges() []string {
+	if !s.done.Load() {
+		return nil
+	}
+
+	return s.algorithms
+}
+
+// parseKexInit parses the key exchange algorithms out of the beginning of the
+// stream of the client: its version line followed by its KEXINIT. It reports
+// whether there is nothing more to read, either because they were parsed or
+// because the stream is unexpected.
+func parseKexInit(stream []byte) ([]string, bool) {
+	versionEnd := bytes.IndexByte(stream, '\n')
+	if versionEnd < 0 {
+		return nil, false
+	}
+	packet := stream[versionEnd+1:]
+
+	// packet_length, padding_length, message number and cookie
+	const headerLen = 4 + 1 + 1 + 16
+	if len(packet) < headerLen+4 {
+		return nil, false
+	}
+	if packet[5] != msgKexInit {
+		return nil, true
+	}
+
+	namesLen := int(binary.BigEndian.Uint32(packet[headerLen:]))
+	if namesLen > maxKexInitSniff {
+		return nil, true
+	}
+
+	names := packet[headerLen+4:]
+	if len(names) < namesLen {
+		return nil, false
+	}
+
+	return strings.Split(string(names[:namesLen]), ","), true
+}
@@ -0,0 +1,123 @@
+package sshd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// handshake connects a client offering clientKex to a server advertising
+// serverKex, returning the error of the client
+func handshake(t *testing.T, serverKex, clientKex []string) error {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+
+	srvCfg := &ssh.ServerConfig{NoClientAuth: true, Config: ssh.Config{KeyExchanges: serverKex}}
+	srvCfg.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		serverConn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer serverConn.Close()
+
+		conn := newConnection(&config.Config{}, serverConn)
+		if sconn, _, err := conn.initServerConn(context.Background(), srvCfg); err == nil {
+			sconn.Close()
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "git",
+		Config:          ssh.Config{KeyExchanges: clientKex},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		client.Close()
+	}
+	<-done
+
+	return err
+}
+
+func TestImplementedKeyExchanges(t *testing.T) {
+	for _, kex := range implementedKeyExchanges {
+		require.NoError(t, handshake(t, []string{kex}, []string{kex}), kex)
+	}
+
+	// Implemented post-quantum key exchanges must be added to
+	// implementedKeyExchanges to be advertised
+	for _, kex := range postQuantumKeyExchanges {
+		require.Error(t, handshake(t, []string{kex}, []string{kex}), kex)
+	}
+}
+
+func TestNegotiatedKeyExchangeMetric(t *testing.T) {
+	before := testutil.ToFloat64(metrics.SshdKeyExchangesTotal.WithLabelValues("ecdh-sha2-nistp384"))
+
+	// Like OpenSSH 9, the client prefers a post-quantum key exchange
+	clientKex := []string{"sntrup761x25519-sha512@openssh.com", "ecdh-sha2-nistp384", "curve25519-sha256"}
+	require.NoError(t, handshake(t, keyExchanges(&config.Config{}), clientKex))
+
+	require.Equal(t, before+1, testutil.ToFloat64(metrics.SshdKeyExchangesTotal.WithLabelValues("ecdh-sha2-nistp384")))
+}
+
+func TestKeyExchanges(t *testing.T) {
+	require.Equal(t, supportedKeyExchanges, keyExchanges(&config.Config{}))
+
+	cfg := &config.Config{Server: config.ServerConfig{KexAlgorithms: []string{"sntrup761x25519-sha512@openssh.com", "curve25519-sha256"}}}
+	require.Equal(t, []string{"curve25519-sha256"}, keyExchanges(cfg))
+	require.Equal(t, []string{"sntrup761x25519-sha512@openssh.com"}, unimplementedKeyExchanges(cfg))
+
+	// Once implemented, post-quantum key exchanges are preferred unless disabled
+	implemented := implementedKeyExchanges
+	implementedKeyExchanges = append([]string{"sntrup761x25519-sha512@openssh.com"}, implemented...)
+	defer func() { implementedKeyExchanges = implemented }()
+
+	require.Equal(t, append([]string{"sntrup761x25519-sha512@openssh.com"}, supportedKeyExchanges...), keyExchanges(&config.Config{}))
+	require.Equal(t, supportedKeyExchanges, keyExchanges(&config.Config{Server: config.ServerConfig{PostQuantumKex: config.PostQuantumKexDisabled}}))
+
+	cfg.Server.PostQuantumKex = config.PostQuantumKexDisabled
+	require.Equal(t, []string{"curve25519-sha256"}, keyExchanges(cfg))
+	require.Equal(t, fipsKeyExchanges, keyExchanges(&config.Config{FIPSMode: true}))
+}
+
+func TestParseKexInit(t *testing.T) {
+	names := "curve25519-sha256,ext-info-c"
+	packet := append([]byte{0, 0, 1, 0, 4, msgKexInit}, make([]byte, 16)...)
+	packet = append(packet, 0, 0, 0, byte(len(names)))
+	stream := append([]byte("SSH-2.0-OpenSSH_9.6\r\n"), append(packet, names...)...)
+
+	for i := 0; i < len(stream); i++ {
+		_, complete := parseKexInit(stream[:i])
+		require.False(t, complete, i)
+	}
+
+	algorithms, complete := parseKexInit(stream)
+	require.True(t, complete)
+	require.Equal(t, []string{"curve25519-sha256", "ext-info-c"}, algorithms)
+
+	stream[len("SSH-2.0-OpenSSH_9.6\r\n")+5] = 21
+	algorithms, complete = parseKexInit(stream)
+	require.True(t, complete)
+	require.Nil(t, algorithms)
+}
@@ -0,0 +1,127 @@
+package sshd
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const sha1SignatureMessage = "Your SSH client signs with ssh-rsa, which uses SHA-1 and is no longer accepted. " +
+	"Upgrade it to a client supporting rsa-sha2-256 or rsa-sha2-512 signatures, e.g. OpenSSH 7.2 or later, " +
+	"or use an ED25519 or ECDSA key instead."
+
+// sha2PublicKeyAuthAlgorithms are the algorithms accepted for the keys of
+// the users when SHA-1 signatures are rejected: the ones of x/crypto but
+// ssh-rsa. It covers the certificate algorithms as well.
+var sha2PublicKeyAuthAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoSKED25519,
+	ssh.KeyAlgoSKECDSA256,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA256,
+	ssh.KeyAlgoRSASHA512,
+	ssh.KeyAlgoDSA,
+}
+
+// keyRejectedError is returned for the keys refused by the key policy, with
+// a message telling the user how to fix their client
+type keyRejectedError struct {
+	message string
+}
+
+func (e *keyRejectedError) Error() string {
+	return e.message
+}
+
+// checkRSAKeySize rejects the RSA keys, and the certificates of RSA keys,
+// whose modulus is shorter than minBits. Zero accepts every size.
+func checkRSAKeySize(key ssh.PublicKey, minBits int) error {
+	if minBits <= 0 {
+		return nil
+	}
+
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil
+	}
+
+	rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey)
+	if !ok {
+		return nil
+	}
+
+	if bits := rsaKey.N.BitLen(); bits < minBits {
+		return &keyRejectedError{message: fmt.Sprintf(
+			"Your RSA key is %d bits long, but keys of at least %d bits are required. "+
+				"Generate a new key, e.g. with `ssh-keygen -t ed25519` or `ssh-keygen -t rsa -b 4096`, and add it to your account.",
+			bits, minBits,
+		)}
+	}
+
+	return nil
+}
+
+// isSHA1SignatureRejection tells whether x/crypto refused an authentication
+// attempt because ssh-rsa isn't among the accepted algorithms
+func isSHA1SignatureRejection(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch err.Error() {
+	case fmt.Sprintf("ssh: algorithm %q not accepted", ssh.KeyAlgoRSA),
+		fmt.Sprintf("ssh: algorithm %q not accepted", ssh.CertAlgoRSAv01):
+		return true
+	default:
+		return false
+	}
+}
+
+// keyRejections holds the message of the last key of a connection refused by
+// the key policy. Failed authentications can't carry a message to the client,
+// so it's sent as the instruction of a keyboard-interactive challenge asking
+// no question, which clients display before giving up.
+type keyRejections struct {
+	message string
+}
+
+// record keeps the message of a publickey authentication attempt refused by
+// the key policy, and reports whether it was
+func (r *keyRejections) record(method string, err error) bool {
+	if method != "publickey" {
+		return false
+	}
+
+	var rejected *keyRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		r.message = rejected.message
+	case isSHA1SignatureRejection(err):
+		r.message = sha1SignatureMessage
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (r *keyRejections) keyboardInteractive(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	if r.message != "" {
+		message := r.message
+		r.message = ""
+
+		if _, err := challenge("", message, nil, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, errors.New("keyboard-interactive authentication is only used to explain rejected keys")
+}
@@ -0,0 +1,107 @@
+package sshd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// authenticate connects to a server configured with srvCfg using signer,
+// returning the instructions the client was shown and its error
+func authenticate(t *testing.T, srvCfg config.ServerConfig, signer ssh.Signer) ([]string, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+
+	// The keys passing the key policy are refused as the user is unknown
+	cfg := &serverConfig{cfg: &config.Config{User: "gitlab", Server: srvCfg}, hostKeys: []ssh.Signer{hostKey}}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		serverConn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer serverConn.Close()
+
+		ssh.NewServerConn(serverConn, cfg.get(context.Background()))
+	}()
+
+	var instructions []string
+	_, err = ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User: "git",
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+			ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				instructions = append(instructions, instruction)
+				return nil, nil
+			}),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	<-done
+
+	return instructions, err
+}
+
+func rsaSigner(t *testing.T, bits int) ssh.Signer {
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	require.NoError(t, err)
+
+	return signer
+}
+
+func TestMinRSAKeySize(t *testing.T) {
+	instructions, err := authenticate(t, config.ServerConfig{MinRSAKeySize: 3072}, rsaSigner(t, 2048))
+	require.ErrorContains(t, err, "unable to authenticate")
+	require.Equal(t, []string{
+		"Your RSA key is 2048 bits long, but keys of at least 3072 bits are required. " +
+			"Generate a new key, e.g. with `ssh-keygen -t ed25519` or `ssh-keygen -t rsa -b 4096`, and add it to your account.",
+	}, instructions)
+
+	require.NoError(t, checkRSAKeySize(rsaSigner(t, 2048).PublicKey(), 2048))
+	require.NoError(t, checkRSAKeySize(rsaSigner(t, 1024).PublicKey(), 0))
+}
+
+func TestRejectSHA1Signatures(t *testing.T) {
+	rsaKey := rsaSigner(t, 2048)
+	sha1Signer, err := ssh.NewSignerWithAlgorithms(rsaKey.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoRSA})
+	require.NoError(t, err)
+
+	instructions, err := authenticate(t, config.ServerConfig{RejectSHA1Signatures: true}, sha1Signer)
+	require.ErrorContains(t, err, "unable to authenticate")
+	require.Equal(t, []string{sha1SignatureMessage}, instructions)
+
+	// rsa-sha2-512 signatures of keys long enough pass the key policy
+	instructions, err = authenticate(t, config.ServerConfig{RejectSHA1Signatures: true, MinRSAKeySize: 2048}, rsaKey)
+	require.ErrorContains(t, err, "unable to authenticate")
+	require.Empty(t, instructions)
+}
+
+func TestKeyRejectionsRecord(t *testing.T) {
+	rejections := &keyRejections{}
+
+	require.False(t, rejections.record("publickey", errors.New("unknown key")))
+	require.False(t, rejections.record("password", &keyRejectedError{message: "rejected"}))
+	require.True(t, rejections.record("publickey", errors.New(`ssh: algorithm "ssh-rsa-cert-v01@openssh.com" not accepted`)))
+	require.Equal(t, sha1SignatureMessage, rejections.message)
+}
@@ -0,0 +1,65 @@
+package sshd
+
+import (
+	"math/rand"
+	"sync/atomic"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+// startupLimiter limits the connections in their handshake, before they
+// authenticated, separately from the sessions of authenticated connections,
+// so that clients opening connections without authenticating can't exhaust
+// the server. Beyond start connections, new ones are dropped at random with a
+// probability rising linearly from rate percent to 100% at full, like the
+// MaxStartups of OpenSSH.
+type startupLimiter struct {
+	limits     config.MaxStartups
+	inProgress atomic.Int64
+	// random returns a number in [0, 100)
+	random func() int
+}
+
+func newStartupLimiter(limits config.MaxStartups) *startupLimiter {
+	return &startupLimiter{limits: limits, random: func() int { return rand.Intn(100) }}
+}
+
+// tryAcquire reports whether a new connection may start its handshake. If it
+// may, release must be called once the handshake is done; it can be called
+// more than once.
+func (l *startupLimiter) tryAcquire() (release func(), ok bool) {
+	inProgress := l.inProgress.Add(1) - 1
+	if l.drop(int(inProgress)) {
+		l.inProgress.Add(-1)
+		metrics.SshdDroppedStartupsTotal.Inc()
+
+		return nil, false
+	}
+
+	metrics.SshdHandshakesInFlight.Inc()
+
+	var released atomic.Bool
+	return func() {
+		if released.CompareAndSwap(false, true) {
+			l.inProgress.Add(-1)
+			metrics.SshdHandshakesInFlight.Dec()
+		}
+	}, true
+}
+
+// drop tells whether a new connection is dropped while inProgress other ones
+// are in their handshake
+func (l *startupLimiter) drop(inProgress int) bool {
+	limits := l.limits
+	switch {
+	case limits.Start == 0 || inProgress < limits.Start:
+		return false
+	case inProgress >= limits.Full:
+		return true
+	}
+
+	probability := limits.Rate + (100-limits.Rate)*(inProgress-limits.Start)/(limits.Full-limits.Start)
+
+	return l.random() < probability
+}
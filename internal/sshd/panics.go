@@ -0,0 +1,113 @@
+package sshd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+
+	"gitlab.com/gitlab-org/labkit/correlation"
+)
+
+const (
+	sessionPanicMessage = "Internal error handling the session, please try again later."
+	// panicReportTimeout bounds how long the client of a channel whose
+	// handler panicked is being told so, as the channel may be stuck, e.g.
+	// with its window full
+	panicReportTimeout = 5 * time.Second
+	panicExitStatus    = 1
+)
+
+// recoverChannelPanic recovers a panic of the handler of a channel, so that
+// only the channel terminates rather than the whole connection. The client
+// of a session is told about the error and gets an exit status before the
+// channel is closed. It must be deferred by the handler.
+func recoverChannelPanic(ctxlog *logrus.Entry, channel ssh.Channel, isSession bool) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	recordPanic(ctxlog, recovered, "channel")
+	metrics.SliSshdSessionsErrorsTotal.Inc()
+
+	if isSession {
+		reported := make(chan struct{})
+		go func() {
+			defer close(reported)
+
+			console.DisplayWarningMessage(sessionPanicMessage, channel.Stderr())
+			if correlationID, ok := ctxlog.Data[correlation.FieldName].(string); ok {
+				console.DisplayReference(correlationID, channel.Stderr())
+			}
+			channel.SendRequest("exit-status", false, ssh.Marshal(exitStatusReq{ExitStatus: panicExitStatus}))
+		}()
+
+		timer := time.NewTimer(panicReportTimeout)
+		select {
+		case <-reported:
+			timer.Stop()
+		case <-timer.C:
+			ctxlog.Warn("panic: timed out telling the client about the error")
+		}
+	}
+
+	channel.Close()
+}
+
+// recordPanic logs a recovered panic with its stack and counts it by the
+// fingerprint of the place it happened at, scope being what it terminated.
+// It must be called by the deferred function that recovered the panic.
+func recordPanic(ctxlog *logrus.Entry, recovered interface{}, scope string) {
+	site := panicSite()
+	fingerprint := panicFingerprint(site)
+
+	ctxlog.WithFields(logrus.Fields{
+		"recovered_error":   recovered,
+		"panic_site":        site,
+		"panic_fingerprint": fingerprint,
+		"panic_scope":       scope,
+		"stack":             string(debug.Stack()),
+	}).Error("panic handling session")
+
+	metrics.SshdPanicsTotal.WithLabelValues(scope, fingerprint).Inc()
+}
+
+// panicSite returns the function, file and line the panic being recovered
+// was raised at: the first frame outside of the runtime below runtime.gopanic
+func panicSite() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(0, pcs)])
+
+	panicking := false
+	for {
+		frame, more := frames.Next()
+		if frame.Function == "runtime.gopanic" {
+			panicking = true
+		} else if panicking && !strings.HasPrefix(frame.Function, "runtime.") {
+			return fmt.Sprintf("%s (%s:%d)", frame.Function, filepath.Base(frame.File), frame.Line)
+		}
+
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// panicFingerprint returns a short stable identifier of a panic site, for
+// the panics to be told apart in the metrics without unbounded labels
+func panicFingerprint(site string) string {
+	sum := sha256.Sum256([]byte(site))
+
+	return hex.EncodeToString(sum[:6])
+}
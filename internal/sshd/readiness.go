@@ -0,0 +1,191 @@
+package sshd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitaly"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/healthcheck"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	defaultReadinessInterval = 10 * time.Second
+	defaultReadinessTimeout  = 5 * time.Second
+)
+
+// readinessChecker periodically checks that the dependencies of the server
+// can be reached, so that the readiness probe doesn't wait on them. A nil
+// *readinessChecker has no dependencies to check.
+type readinessChecker struct {
+	checks   []dependencyCheck
+	interval time.Duration
+	timeout  time.Duration
+
+	mu       sync.RWMutex
+	statuses []dependencyStatus
+}
+
+type dependencyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+type dependencyStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newReadinessChecker(cfg *config.Config) *readinessChecker {
+	checksCfg := cfg.Server.ReadinessChecks
+
+	var checks []dependencyCheck
+	if checksCfg.API {
+		checks = append(checks, dependencyCheck{name: "gitlab_api", check: func(ctx context.Context) error {
+			return checkAPI(ctx, cfg)
+		}})
+	}
+	for _, address := range checksCfg.GitalyAddresses {
+		address := address
+		checks = append(checks, dependencyCheck{name: "gitaly:" + address, check: func(ctx context.Context) error {
+			return checkGitaly(ctx, cfg, address, checksCfg.GitalyToken)
+		}})
+	}
+
+	if len(checks) == 0 {
+		return nil
+	}
+
+	interval := time.Duration(checksCfg.Interval)
+	if interval <= 0 {
+		interval = defaultReadinessInterval
+	}
+
+	timeout := time.Duration(checksCfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultReadinessTimeout
+	}
+
+	return &readinessChecker{checks: checks, interval: interval, timeout: timeout}
+}
+
+func checkAPI(ctx context.Context, cfg *config.Config) error {
+	client, err := healthcheck.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Check(ctx)
+
+	return err
+}
+
+func checkGitaly(ctx context.Context, cfg *config.Config, address, token string) error {
+	conn, err := cfg.GitalyClient.GetConnection(ctx, gitaly.Command{ServiceName: "readiness", Address: address, Token: token})
+	if err != nil {
+		return err
+	}
+
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+
+	if response.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("Gitaly is %s", response.Status)
+	}
+
+	return nil
+}
+
+// Run checks the dependencies every interval until ctx is done
+func (r *readinessChecker) Run(ctx context.Context) {
+	if r == nil {
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *readinessChecker) check(ctx context.Context) {
+	statuses := make([]dependencyStatus, len(r.checks))
+
+	var wg sync.WaitGroup
+	for i, dependency := range r.checks {
+		wg.Add(1)
+		go func(i int, dependency dependencyCheck) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, r.timeout)
+			defer cancel()
+
+			statuses[i] = dependencyStatus{Name: dependency.name, Healthy: true}
+			if err := dependency.check(checkCtx); err != nil {
+				statuses[i].Healthy = false
+				statuses[i].Error = err.Error()
+			}
+		}(i, dependency)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	previous := r.statuses
+	r.statuses = statuses
+	r.mu.Unlock()
+
+	for i, status := range statuses {
+		up := 0.0
+		if status.Healthy {
+			up = 1
+		}
+		metrics.SshdDependencyUp.WithLabelValues(status.Name).Set(up)
+
+		if !status.Healthy && (previous == nil || previous[i].Healthy) {
+			log.WithContextFields(ctx, log.Fields{"dependency": status.Name, "error": status.Error}).Warn("readiness: dependency is unreachable")
+		} else if status.Healthy && previous != nil && !previous[i].Healthy {
+			log.WithContextFields(ctx, log.Fields{"dependency": status.Name}).Info("readiness: dependency is reachable again")
+		}
+	}
+}
+
+// result returns whether every dependency was reachable when last checked,
+// which is false until they have been checked once
+func (r *readinessChecker) result() (bool, []dependencyStatus) {
+	if r == nil {
+		return true, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.statuses == nil {
+		return false, []dependencyStatus{}
+	}
+
+	for _, status := range r.statuses {
+		if !status.Healthy {
+			return false, r.statuses
+		}
+	}
+
+	return true, r.statuses
+}
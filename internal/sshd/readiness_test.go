@@ -0,0 +1,112 @@
+package sshd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+func TestNewReadinessChecker(t *testing.T) {
+	require.Nil(t, newReadinessChecker(&config.Config{}))
+
+	checker := newReadinessChecker(&config.Config{Server: config.ServerConfig{
+		ReadinessChecks: config.ReadinessChecksConfig{API: true, GitalyAddresses: []string{"tcp://gitaly-1:8075", "tcp://gitaly-2:8075"}},
+	}})
+	require.Len(t, checker.checks, 3)
+	require.Equal(t, "gitlab_api", checker.checks[0].name)
+	require.Equal(t, "gitaly:tcp://gitaly-1:8075", checker.checks[1].name)
+	require.Equal(t, defaultReadinessInterval, checker.interval)
+	require.Equal(t, defaultReadinessTimeout, checker.timeout)
+}
+
+func TestReadinessChecker(t *testing.T) {
+	var gitalyErr error
+	checker := &readinessChecker{
+		checks: []dependencyCheck{
+			{name: "gitlab_api", check: func(context.Context) error { return nil }},
+			{name: "gitaly:test", check: func(context.Context) error { return gitalyErr }},
+		},
+		timeout: defaultReadinessTimeout,
+	}
+
+	ready, dependencies := checker.result()
+	require.False(t, ready, "dependencies haven't been checked yet")
+	require.Empty(t, dependencies)
+
+	checker.check(context.Background())
+	ready, dependencies = checker.result()
+	require.True(t, ready)
+	require.Equal(t, []dependencyStatus{{Name: "gitlab_api", Healthy: true}, {Name: "gitaly:test", Healthy: true}}, dependencies)
+	require.InDelta(t, 1, testutil.ToFloat64(metrics.SshdDependencyUp.WithLabelValues("gitaly:test")), 0.1)
+
+	gitalyErr = errors.New("connection refused")
+	checker.check(context.Background())
+	ready, dependencies = checker.result()
+	require.False(t, ready)
+	require.Equal(t, dependencyStatus{Name: "gitaly:test", Error: "connection refused"}, dependencies[1])
+	require.InDelta(t, 0, testutil.ToFloat64(metrics.SshdDependencyUp.WithLabelValues("gitaly:test")), 0.1)
+}
+
+func TestReadinessProbeWithDependencies(t *testing.T) {
+	apiUp := true
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/check",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				if !apiUp {
+					w.WriteHeader(http.StatusForbidden)
+					json.NewEncoder(w).Encode(map[string]string{"message": "API is down"})
+					return
+				}
+
+				json.NewEncoder(w).Encode(map[string]interface{}{"api_version": "v4", "redis": true})
+			},
+		},
+	}
+	url := testserver.StartSocketHttpServer(t, requests)
+	gitalyAddress, _ := testserver.StartGitalyServer(t, "tcp")
+
+	cfg := &config.Config{GitlabUrl: url, Server: config.DefaultServerConfig}
+	cfg.Server.ReadinessChecks = config.ReadinessChecksConfig{API: true, GitalyAddresses: []string{gitalyAddress}}
+	cfg.GitalyClient.InitSidechannelRegistry(context.Background())
+
+	s := &Server{Config: cfg, readiness: newReadinessChecker(cfg)}
+	s.changeStatus(StatusReady)
+	mux := s.MonitoringServeMux()
+
+	s.readiness.check(context.Background())
+
+	r := httptest.NewRecorder()
+	mux.ServeHTTP(r, httptest.NewRequest("GET", "/start", nil))
+	require.Equal(t, 200, r.Result().StatusCode)
+	require.JSONEq(t, `{"ready":true,"dependencies":[{"name":"gitlab_api","healthy":true},{"name":"gitaly:`+gitalyAddress+`","healthy":true}]}`, r.Body.String())
+
+	apiUp = false
+	s.readiness.check(context.Background())
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, httptest.NewRequest("GET", "/start", nil))
+	require.Equal(t, 503, r.Result().StatusCode)
+	require.JSONEq(t, `{"ready":false,"dependencies":[{"name":"gitlab_api","healthy":false,"error":"API is down"},{"name":"gitaly:`+gitalyAddress+`","healthy":true}]}`, r.Body.String())
+}
+
+func TestCheckGitalyUnreachable(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.GitalyClient.InitSidechannelRegistry(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.Error(t, checkGitaly(ctx, cfg, "tcp://127.0.0.1:1", ""))
+}
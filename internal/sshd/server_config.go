@@ -11,9 +11,12 @@ import (
 
 	"golang.org/x/crypto/ssh"
 
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/authorizedcerts"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet/authorizedkeys"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/keyfilter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 
 	"gitlab.com/gitlab-org/labkit/log"
 )
@@ -42,8 +45,16 @@ type serverConfig struct {
 	cfg                   *config.Config
 	hostKeys              []ssh.Signer
 	hostKeyToCertMap      map[string]*ssh.Certificate
-	authorizedKeysClient  *authorizedkeys.Client
+	authorizedKeysClient  authorizedkeys.Getter
 	authorizedCertsClient *authorizedcerts.Client
+	keyFilter             *keyfilter.Filter
+	loginBanner           string
+	// authenticate authenticates keys before they're looked up in GitLab
+	authenticate AuthFunc
+
+	// tenants authenticate the users of the additional GitLab instances,
+	// keyed by the SSH user they serve
+	tenants map[string]*serverConfig
 }
 
 func parseHostKeys(keyFiles []string) []ssh.Signer {
@@ -114,7 +125,12 @@ func parseHostCerts(hostKeys []ssh.Signer, certFiles []string) map[string]*ssh.C
 }
 
 func newServerConfig(cfg *config.Config) (*serverConfig, error) {
-	authorizedKeysClient, err := authorizedkeys.NewClient(cfg)
+	authorizedKeysClient, err := authorizedkeys.NewGetter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize authorized keys client: %w", err)
+	}
+
+	fingerprintsClient, err := authorizedkeys.NewClient(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize authorized keys client: %w", err)
 	}
@@ -131,15 +147,93 @@ func newServerConfig(cfg *config.Config) (*serverConfig, error) {
 
 	hostKeyToCertMap := parseHostCerts(hostKeys, cfg.Server.HostCertFiles)
 
+	for _, algorithm := range unimplementedKeyExchanges(cfg) {
+		log.WithFields(log.Fields{"kex_algorithm": algorithm}).Warn("Key exchange algorithm isn't implemented, not advertising it")
+	}
+
+	if cfg.FIPSMode {
+		if err := checkFIPSCompliance(cfg, hostKeys); err != nil {
+			return nil, err
+		}
+
+		if hostKeys, err = fipsHostKeys(hostKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	var loginBanner string
+	if cfg.Server.LoginBannerFile != "" {
+		banner, err := os.ReadFile(cfg.Server.LoginBannerFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read login banner: %w", err)
+		}
+
+		loginBanner = string(banner)
+	}
+
+	tenants := map[string]*serverConfig{}
+	for _, tenantCfg := range cfg.TenantConfigs() {
+		tenant, err := newTenantServerConfig(tenantCfg)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", tenantCfg.TenantName(), err)
+		}
+
+		tenants[tenantCfg.User] = tenant
+	}
+
 	return &serverConfig{
 		cfg:                   cfg,
 		authorizedKeysClient:  authorizedKeysClient,
 		authorizedCertsClient: authorizedCertsClient,
 		hostKeys:              hostKeys,
 		hostKeyToCertMap:      hostKeyToCertMap,
+		keyFilter:             keyfilter.New(cfg.Server.KeyFilter, fingerprintsClient),
+		loginBanner:           loginBanner,
+		tenants:               tenants,
+	}, nil
+}
+
+// newTenantServerConfig only authenticates users, the SSH server itself is
+// configured by the default instance. Its keys are not filtered.
+func newTenantServerConfig(cfg *config.Config) (*serverConfig, error) {
+	authorizedKeysClient, err := authorizedkeys.NewGetter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize authorized keys client: %w", err)
+	}
+
+	authorizedCertsClient, err := authorizedcerts.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize authorized certs client: %w", err)
+	}
+
+	return &serverConfig{
+		cfg:                   cfg,
+		authorizedKeysClient:  authorizedKeysClient,
+		authorizedCertsClient: authorizedCertsClient,
 	}, nil
 }
 
+// forUser returns the config authenticating user against its GitLab instance
+func (s *serverConfig) forUser(user string) *serverConfig {
+	if tenant, ok := s.tenants[user]; ok {
+		return tenant
+	}
+
+	return s
+}
+
+// withTenant records the tenant the user was authenticated against, so that
+// the session is handled by the same GitLab instance
+func (s *serverConfig) withTenant(permissions *ssh.Permissions, err error) (*ssh.Permissions, error) {
+	if err != nil || s.cfg.TenantName() == "" {
+		return permissions, err
+	}
+
+	permissions.Extensions["tenant"] = s.cfg.TenantName()
+
+	return permissions, nil
+}
+
 func (s *serverConfig) handleUserKey(ctx context.Context, user string, key ssh.PublicKey) (*ssh.Permissions, error) {
 	if user != s.cfg.User {
 		return nil, fmt.Errorf("unknown user")
@@ -147,18 +241,59 @@ func (s *serverConfig) handleUserKey(ctx context.Context, user string, key ssh.P
 	if key.Type() == ssh.KeyAlgoDSA {
 		return nil, fmt.Errorf("DSA is prohibited")
 	}
+	if !s.keyFilter.MayContain(key) {
+		return nil, fmt.Errorf("unknown key")
+	}
 
 	res, err := s.authorizedKeysClient.GetByKey(ctx, base64.RawStdEncoding.EncodeToString(key.Marshal()))
 	if err != nil {
 		return nil, err
 	}
 
-	return &ssh.Permissions{
+	permissions := &ssh.Permissions{
 		// Record the public key used for authentication.
 		Extensions: map[string]string{
 			"key-id": strconv.FormatInt(res.Id, 10),
 		},
-	}, nil
+	}
+
+	if res.Banner != "" {
+		permissions.Extensions["motd"] = res.Banner
+	}
+	if res.ExpiresAt != "" {
+		permissions.Extensions["key-expires-at"] = res.ExpiresAt
+	}
+	if res.SessionClass != "" {
+		permissions.Extensions["session-class"] = res.SessionClass
+	}
+	if res.ActorType != "" {
+		permissions.Extensions["actor-type"] = res.ActorType
+	}
+	if res.DenyListed {
+		// Failed authentications can't carry a message to the client, so the
+		// connection is let through but only sessions are accepted, every
+		// command of which is refused with the message
+		permissions.Extensions["key-deny-listed"] = denyListedKeyMessage(res.DenyMessage)
+
+		metrics.SshdDenyListedKeysTotal.WithLabelValues(key.Type()).Inc()
+		log.WithContextFields(ctx, log.Fields{
+			"audit_event": "deny_listed_key",
+			"severity":    "high",
+			"key_id":      res.Id,
+			"key_type":    key.Type(),
+			"fingerprint": ssh.FingerprintSHA256(key),
+		}).Error("Authentication attempted with a deny-listed key")
+	}
+
+	return permissions, nil
+}
+
+func denyListedKeyMessage(message string) string {
+	if message != "" {
+		return message
+	}
+
+	return "Your SSH key has been found in a public data breach and can no longer be used. Remove it and add a new key to regain access."
 }
 
 func (s *serverConfig) handleUserCertificate(ctx context.Context, user string, cert *ssh.Certificate) (*ssh.Permissions, error) {
@@ -202,8 +337,9 @@ func (s *serverConfig) handleUserCertificate(ctx context.Context, user string, c
 
 	return &ssh.Permissions{
 		Extensions: map[string]string{
-			"username":  res.Username,
-			"namespace": res.Namespace,
+			"username":   res.Username,
+			"namespace":  res.Namespace,
+			"actor-type": string(commandargs.ActorUser),
 		},
 	}, nil
 }
@@ -213,16 +349,18 @@ func (s *serverConfig) get(ctx context.Context) *ssh.ServerConfig {
 	if s.cfg.Server.GSSAPI.Enabled {
 		gssapiWithMICConfig = &ssh.GSSAPIWithMICConfig{
 			AllowLogin: func(conn ssh.ConnMetadata, srcName string) (*ssh.Permissions, error) {
-				if conn.User() != s.cfg.User {
+				user := s.forUser(conn.User())
+				if conn.User() != user.cfg.User {
 					return nil, fmt.Errorf("unknown user")
 				}
 
-				return &ssh.Permissions{
+				return user.withTenant(&ssh.Permissions{
 					// Record the Kerberos principal used for authentication.
 					Extensions: map[string]string{
 						"krb5principal": srcName,
+						"actor-type":    string(commandargs.ActorUser),
 					},
-				}, nil
+				}, nil)
 			},
 			Server: &OSGSSAPIServer{
 				ServicePrincipalName: s.cfg.Server.GSSAPI.ServicePrincipalName,
@@ -230,6 +368,8 @@ func (s *serverConfig) get(ctx context.Context) *ssh.ServerConfig {
 		}
 	}
 
+	rejections := &keyRejections{}
+
 	sshCfg := &ssh.ServerConfig{
 		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 			ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
@@ -237,31 +377,70 @@ func (s *serverConfig) get(ctx context.Context) *ssh.ServerConfig {
 
 			log.WithContextFields(ctx, log.Fields{"ssh_key_type": key.Type()}).Info("public key authentication")
 
+			if err := checkRSAKeySize(key, s.cfg.Server.MinRSAKeySize); err != nil {
+				return nil, err
+			}
+
+			if s.authenticate != nil {
+				permissions, err := s.authenticate(ctx, conn.User(), key)
+				if err != nil || permissions != nil {
+					return permissions, err
+				}
+			}
+
+			user := s.forUser(conn.User())
+
 			cert, ok := key.(*ssh.Certificate)
 			if ok {
-				return s.handleUserCertificate(ctx, conn.User(), cert)
+				return user.withTenant(user.handleUserCertificate(ctx, conn.User(), cert))
 			}
 
-			return s.handleUserKey(ctx, conn.User(), key)
+			return user.withTenant(user.handleUserKey(ctx, conn.User(), key))
+		},
+		// The supported methods are advertised in reply to "none" probes
+		AuthLogCallback: func(conn ssh.ConnMetadata, method string, err error) {
+			if method == "none" {
+				metrics.SshdAuthProbesTotal.Inc()
+			}
+			if rejections.record(method, err) {
+				log.WithContextFields(ctx, log.Fields{"remote_addr": conn.RemoteAddr().String(), "reason": err.Error()}).Info("public key authentication: key rejected by the key policy")
+			}
 		},
 		GSSAPIWithMICConfig: gssapiWithMICConfig,
 		ServerVersion:       "SSH-2.0-GitLab-SSHD",
+		MaxAuthTries:        s.cfg.Server.MaxAuthTries,
+	}
+
+	if s.loginBanner != "" {
+		sshCfg.BannerCallback = func(conn ssh.ConnMetadata) string {
+			return s.loginBanner
+		}
 	}
 
 	if len(s.cfg.Server.MACs) > 0 {
 		sshCfg.MACs = s.cfg.Server.MACs
+	} else if s.cfg.FIPSMode {
+		sshCfg.MACs = fipsMACs
 	} else {
 		sshCfg.MACs = supportedMACs
 	}
 
-	if len(s.cfg.Server.KexAlgorithms) > 0 {
-		sshCfg.KeyExchanges = s.cfg.Server.KexAlgorithms
-	} else {
-		sshCfg.KeyExchanges = supportedKeyExchanges
-	}
+	sshCfg.KeyExchanges = keyExchanges(s.cfg)
 
 	if len(s.cfg.Server.Ciphers) > 0 {
 		sshCfg.Ciphers = s.cfg.Server.Ciphers
+	} else if s.cfg.FIPSMode {
+		sshCfg.Ciphers = fipsCiphers
+	}
+
+	if s.cfg.FIPSMode {
+		sshCfg.PublicKeyAuthAlgorithms = fipsPublicKeyAlgorithms
+	} else if s.cfg.Server.RejectSHA1Signatures {
+		sshCfg.PublicKeyAuthAlgorithms = sha2PublicKeyAuthAlgorithms
+	}
+
+	if s.cfg.Server.MinRSAKeySize > 0 || s.cfg.Server.RejectSHA1Signatures {
+		sshCfg.KeyboardInteractiveCallback = rejections.keyboardInteractive
 	}
 
 	for _, key := range s.hostKeys {
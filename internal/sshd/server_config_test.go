@@ -7,6 +7,8 @@ import (
 	"crypto/rsa"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"path"
@@ -14,12 +16,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/ssh"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
 )
 
@@ -69,6 +73,62 @@ func TestHostKeyAndCerts(t *testing.T) {
 	require.Equal(t, cert, cfg.hostKeys[0].PublicKey())
 }
 
+func TestLoginBanner(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+	bannerFile := path.Join(t.TempDir(), "banner.txt")
+	require.NoError(t, os.WriteFile(bannerFile, []byte("Authorized use only\n"), 0o644))
+
+	srvCfg := config.ServerConfig{
+		HostKeyFiles:    []string{path.Join(testRoot, "certs/valid/server.key")},
+		LoginBannerFile: bannerFile,
+	}
+
+	cfg, err := newServerConfig(&config.Config{GitlabUrl: "http://localhost", Server: srvCfg})
+	require.NoError(t, err)
+
+	sshServerConfig := cfg.get(context.Background())
+	require.NotNil(t, sshServerConfig.BannerCallback)
+	require.Equal(t, "Authorized use only\n", sshServerConfig.BannerCallback(nil))
+
+	srvCfg.LoginBannerFile = path.Join(t.TempDir(), "missing.txt")
+	_, err = newServerConfig(&config.Config{GitlabUrl: "http://localhost", Server: srvCfg})
+	require.ErrorContains(t, err, "failed to read login banner")
+}
+
+func TestNoneAuthProbe(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+	srvCfg := config.ServerConfig{HostKeyFiles: []string{path.Join(testRoot, "certs/valid/server.key")}}
+
+	cfg, err := newServerConfig(&config.Config{GitlabUrl: "http://localhost", Server: srvCfg})
+	require.NoError(t, err)
+
+	before := testutil.ToFloat64(metrics.SshdAuthProbesTotal)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	serverErr := make(chan error)
+	go func() {
+		serverConn, err := l.Accept()
+		if err == nil {
+			_, _, _, err = ssh.NewServerConn(serverConn, cfg.get(context.Background()))
+			serverConn.Close()
+		}
+		serverErr <- err
+	}()
+
+	// Without any auth method, the client only probes the server with "none"
+	_, err = ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "git",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.ErrorContains(t, err, "attempted methods [none]")
+
+	require.Equal(t, "probe", authResult(<-serverErr))
+	require.Equal(t, before+1, testutil.ToFloat64(metrics.SshdAuthProbesTotal))
+}
+
 func TestFailedAuthorizedKeysClient(t *testing.T) {
 	_, err := newServerConfig(&config.Config{GitlabUrl: "ftp://localhost"})
 
@@ -80,14 +140,30 @@ func TestUserKeyHandling(t *testing.T) {
 	testRoot := testhelper.PrepareTestRootDir(t)
 
 	validRSAKey := rsaPublicKey(t)
+	expiringRSAKey := rsaPublicKey(t)
+	compromisedRSAKey := rsaPublicKey(t)
+	denyListedRSAKey := rsaPublicKey(t)
+	deployRSAKey := rsaPublicKey(t)
 
 	requests := []testserver.TestRequestHandler{
 		{
 			Path: "/api/v4/internal/authorized_keys",
 			Handler: func(w http.ResponseWriter, r *http.Request) {
 				key := base64.RawStdEncoding.EncodeToString(validRSAKey.Marshal())
+				expiringKey := base64.RawStdEncoding.EncodeToString(expiringRSAKey.Marshal())
+				compromisedKey := base64.RawStdEncoding.EncodeToString(compromisedRSAKey.Marshal())
+				denyListedKey := base64.RawStdEncoding.EncodeToString(denyListedRSAKey.Marshal())
+				deployKey := base64.RawStdEncoding.EncodeToString(deployRSAKey.Marshal())
 				if key == r.URL.Query().Get("key") {
 					w.Write([]byte(`{ "id": 1, "key": "key" }`))
+				} else if expiringKey == r.URL.Query().Get("key") {
+					w.Write([]byte(`{ "id": 2, "key": "key", "banner": "Your SSH key expires in 3 days", "expires_at": "2023-06-04T12:00:00Z" }`))
+				} else if compromisedKey == r.URL.Query().Get("key") {
+					w.Write([]byte(`{ "id": 3, "key": "key", "deny_listed": true }`))
+				} else if denyListedKey == r.URL.Query().Get("key") {
+					w.Write([]byte(`{ "id": 4, "key": "key", "deny_listed": true, "deny_message": "This key was revoked by an administrator" }`))
+				} else if deployKey == r.URL.Query().Get("key") {
+					w.Write([]byte(`{ "id": 5, "key": "key", "actor_type": "deploy_key" }`))
 				} else {
 					w.WriteHeader(http.StatusInternalServerError)
 				}
@@ -141,9 +217,40 @@ func TestUserKeyHandling(t *testing.T) {
 			expectedPermissions: &ssh.Permissions{
 				Extensions: map[string]string{"key-id": "1"},
 			},
+		}, {
+			desc: "successful request with a banner",
+			user: "user",
+			key:  expiringRSAKey,
+			expectedPermissions: &ssh.Permissions{
+				Extensions: map[string]string{"key-id": "2", "motd": "Your SSH key expires in 3 days", "key-expires-at": "2023-06-04T12:00:00Z"},
+			},
+		}, {
+			desc: "deny-listed key",
+			user: "user",
+			key:  compromisedRSAKey,
+			expectedPermissions: &ssh.Permissions{
+				Extensions: map[string]string{"key-id": "3", "key-deny-listed": denyListedKeyMessage("")},
+			},
+		}, {
+			desc: "deny-listed key with a message",
+			user: "user",
+			key:  denyListedRSAKey,
+			expectedPermissions: &ssh.Permissions{
+				Extensions: map[string]string{"key-id": "4", "key-deny-listed": "This key was revoked by an administrator"},
+			},
+		}, {
+			desc: "deploy key",
+			user: "user",
+			key:  deployRSAKey,
+			expectedPermissions: &ssh.Permissions{
+				Extensions: map[string]string{"key-id": "5", "actor-type": "deploy_key"},
+			},
 		},
 	}
 
+	denyListed := metrics.SshdDenyListedKeysTotal.WithLabelValues(ssh.KeyAlgoRSA)
+	initialDenyListed := testutil.ToFloat64(denyListed)
+
 	for _, tc := range testCases {
 		t.Run(tc.desc, func(t *testing.T) {
 			permissions, err := cfg.handleUserKey(context.Background(), tc.user, tc.key)
@@ -151,6 +258,50 @@ func TestUserKeyHandling(t *testing.T) {
 			require.Equal(t, tc.expectedPermissions, permissions)
 		})
 	}
+
+	require.InDelta(t, initialDenyListed+2, testutil.ToFloat64(denyListed), 0.1)
+}
+
+func TestUserKeyHandlingWithKeyFilter(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+
+	knownRSAKey := rsaPublicKey(t)
+	var lookups int
+
+	requests := []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/authorized_keys",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				lookups++
+				w.Write([]byte(`{ "id": 1, "key": "key" }`))
+			},
+		}, {
+			Path: "/api/v4/internal/authorized_keys/fingerprints",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{ "fingerprints": [%q] }`, ssh.FingerprintSHA256(knownRSAKey))
+			},
+		},
+	}
+
+	cfg, err := newServerConfig(&config.Config{
+		GitlabUrl: testserver.StartSocketHttpServer(t, requests),
+		User:      "user",
+		Server: config.ServerConfig{
+			HostKeyFiles: []string{path.Join(testRoot, "certs/valid/server.key")},
+			KeyFilter:    config.KeyFilterConfig{Enabled: true},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, cfg.keyFilter.Refresh(context.Background()))
+
+	_, err = cfg.handleUserKey(context.Background(), "user", rsaPublicKey(t))
+	require.EqualError(t, err, "unknown key")
+	require.Zero(t, lookups)
+
+	permissions, err := cfg.handleUserKey(context.Background(), "user", knownRSAKey)
+	require.NoError(t, err)
+	require.Equal(t, "1", permissions.Extensions["key-id"])
+	require.Equal(t, 1, lookups)
 }
 
 func TestUserCertificateHandling(t *testing.T) {
@@ -217,8 +368,9 @@ func TestUserCertificateHandling(t *testing.T) {
 			featureFlagValue: "1",
 			expectedPermissions: &ssh.Permissions{
 				Extensions: map[string]string{
-					"username":  "root",
-					"namespace": "namespace",
+					"username":   "root",
+					"namespace":  "namespace",
+					"actor-type": "user",
 				},
 			},
 		}, {
@@ -249,6 +401,8 @@ func TestDefaultAlgorithms(t *testing.T) {
 	srvCfg := &serverConfig{cfg: &config.Config{}}
 	sshServerConfig := srvCfg.get(context.Background())
 
+	require.Nil(t, sshServerConfig.BannerCallback)
+	require.Zero(t, sshServerConfig.MaxAuthTries)
 	require.Equal(t, supportedMACs, sshServerConfig.MACs)
 	require.Equal(t, supportedKeyExchanges, sshServerConfig.KeyExchanges)
 	require.Nil(t, sshServerConfig.Ciphers)
@@ -386,3 +540,52 @@ func userCert(t *testing.T, certType uint32, validBefore time.Time) *ssh.Certifi
 
 	return cert
 }
+
+func TestUserKeyHandlingWithTenants(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+	key := rsaPublicKey(t)
+
+	authorizedKeys := func(id int) []testserver.TestRequestHandler {
+		return []testserver.TestRequestHandler{
+			{
+				Path: "/api/v4/internal/authorized_keys",
+				Handler: func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprintf(w, `{ "id": %d, "key": "key" }`, id)
+				},
+			},
+		}
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(dir, "config.yml"), []byte(fmt.Sprintf(`user: git
+gitlab_url: %q
+secret: secret-a
+sshd:
+  host_key_files: [%q]
+tenants:
+  - name: gitlab-b
+    username_suffix: "+b"
+    gitlab_url: %q
+    secret: secret-b
+`, testserver.StartSocketHttpServer(t, authorizedKeys(1)), path.Join(testRoot, "certs/valid/server.key"), testserver.StartSocketHttpServer(t, authorizedKeys(2)))), 0644))
+
+	cfg, err := config.NewFromDir(dir)
+	require.NoError(t, err)
+
+	srvCfg, err := newServerConfig(cfg)
+	require.NoError(t, err)
+
+	user := srvCfg.forUser("git")
+	permissions, err := user.withTenant(user.handleUserKey(context.Background(), "git", key))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"key-id": "1"}, permissions.Extensions)
+
+	user = srvCfg.forUser("git+b")
+	permissions, err = user.withTenant(user.handleUserKey(context.Background(), "git+b", key))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"key-id": "2", "tenant": "gitlab-b"}, permissions.Extensions)
+
+	user = srvCfg.forUser("git+c")
+	_, err = user.withTenant(user.handleUserKey(context.Background(), "git+c", key))
+	require.EqualError(t, err, "unknown user")
+}
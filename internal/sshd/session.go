@@ -4,24 +4,61 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"strings"
 	"time"
 
+	"gitlab.com/gitlab-org/labkit/correlation"
 	"gitlab.com/gitlab-org/labkit/log"
 	"golang.org/x/crypto/ssh"
 	grpccodes "google.golang.org/grpc/codes"
 	grpcstatus "google.golang.org/grpc/status"
 
 	shellCmd "gitlab.com/gitlab-org/gitlab-shell/v14/cmd/gitlab-shell/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/cgroups"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/help"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/menu"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/readwriter"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/accessverifier"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disabledcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/shared/disallowedcommand"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sessionhook"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sessionrecord"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshenv"
 )
 
+const (
+	// defaultKeyExpiryWarningPeriod is how long before their key expires users
+	// are warned about it when the sshd key_expiry_warning_period isn't set
+	defaultKeyExpiryWarningPeriod = 7 * 24 * time.Hour
+	// defaultMaxEnvSize bounds the env requests of a session when the sshd
+	// max_env_size isn't set
+	defaultMaxEnvSize = 8 * 1024
+	// unknownCommandLabel is the command of the metrics of the sessions
+	// whose command couldn't be parsed
+	unknownCommandLabel = "unknown"
+)
+
+// commandLabel returns the command label of the metrics of a session
+func commandLabel(commandType commandargs.CommandType) string {
+	if commandType == "" {
+		return unknownCommandLabel
+	}
+
+	return string(commandType)
+}
+
+var errDenyListedKey = errorcode.New(errorcode.AuthFailed, "the key is deny-listed")
+
 type session struct {
 	// State set up by the connection
 	cfg                 *config.Config
@@ -30,12 +67,38 @@ type session struct {
 	gitlabKrb5Principal string
 	gitlabUsername      string
 	namespace           string
+	actorType           commandargs.ActorType
 	remoteAddr          string
+	// motd is shown to users opening an interactive session
+	motd         string
+	keyExpiresAt string
+	// denyListedKeyMessage is set when the key used to authenticate is known
+	// to be compromised, no command is run for such sessions
+	denyListedKeyMessage string
+	recorder             *sessionrecord.Recorder
+	hooks                *sessionhook.Runner
+	cgroups              *cgroups.Manager
+	commandHook          CommandHook
+	// deliveries tracks the delivery of the record and post-session hook
+	deliveries *backgroundTasks
 
 	// State managed by the session
 	execCmd            string
 	gitProtocolVersion string
-	started            time.Time
+	preauthToken       string
+	// localeEnv holds the values of i18n.LocaleEnvs the client sent
+	localeEnv map[string]string
+	// extraEnv holds the values of the sshd allowed_env the client sent
+	extraEnv map[string]string
+	// envSize is the size of the env requests received, bounded by the sshd
+	// max_env_size
+	envSize int
+	// terminal is the terminal of the client, if it requested a pty
+	terminal *console.Terminal
+	// forwardingNotices tell the user why their forwarding requests were
+	// rejected, shown when no command is run
+	forwardingNotices []string
+	started           time.Time
 }
 
 type execRequest struct {
@@ -47,13 +110,24 @@ type envRequest struct {
 	Value string
 }
 
+// ptyRequest is the payload of pty-req, see RFC 4254, section 6.2
+type ptyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
 type exitStatusReq struct {
 	ExitStatus uint32
 }
 
 func (s *session) handle(ctx context.Context, requests <-chan *ssh.Request) (context.Context, error) {
+	ctx = logger.ContextWithSessionFields(ctx, s.logFields())
 	ctxWithLogData := ctx
-	ctxlog := log.ContextLogger(ctx)
+	ctxlog := logger.ContextLogger(ctx)
 
 	ctxlog.Debug("session: handle: entering request loop")
 
@@ -70,6 +144,10 @@ func (s *session) handle(ctx context.Context, requests <-chan *ssh.Request) (con
 		switch req.Type {
 		case "env":
 			shouldContinue, err = s.handleEnv(ctx, req)
+		case "pty-req":
+			shouldContinue = s.handlePtyReq(ctx, req)
+		case x11ForwardingRequest, agentForwardingRequest:
+			shouldContinue = s.handleForwardingReq(ctx, req)
 		case "exec":
 			// The command has been executed as `ssh user@host command` or `exec` channel has been used
 			// in the app implementation
@@ -106,35 +184,131 @@ func (s *session) handle(ctx context.Context, requests <-chan *ssh.Request) (con
 }
 
 func (s *session) handleEnv(ctx context.Context, req *ssh.Request) (bool, error) {
-	var accepted bool
 	var envRequest envRequest
 
 	if err := ssh.Unmarshal(req.Payload, &envRequest); err != nil {
-		log.ContextLogger(ctx).WithError(err).Error("session: handleEnv: failed to unmarshal request")
+		logger.ContextLogger(ctx).WithError(err).Error("session: handleEnv: failed to unmarshal request")
 		return false, err
 	}
 
-	switch envRequest.Name {
-	case sshenv.GitProtocolEnv:
+	logged := envRequest
+	if envRequest.Name == sshenv.PreauthTokenEnv {
+		logged.Value = "[REDACTED]"
+	}
+
+	// Rejected requests count as well, for the clients not to send any
+	// number of them
+	s.envSize += len(envRequest.Name) + len(envRequest.Value)
+
+	var rejection string
+	switch {
+	case s.envSize > s.maxEnvSize():
+		rejection = "max_env_size exceeded"
+	case envRequest.Name == sshenv.GitProtocolEnv:
+		if _, err := sshenv.ProtocolVersion(envRequest.Value); err != nil {
+			rejection = err.Error()
+			break
+		}
 		s.gitProtocolVersion = envRequest.Value
-		accepted = true
+	case envRequest.Name == sshenv.PreauthTokenEnv:
+		s.preauthToken = envRequest.Value
+	case isLocaleEnv(envRequest.Name):
+		if s.localeEnv == nil {
+			s.localeEnv = make(map[string]string)
+		}
+		s.localeEnv[envRequest.Name] = envRequest.Value
+	case s.isAllowedEnv(envRequest.Name):
+		if s.extraEnv == nil {
+			s.extraEnv = make(map[string]string)
+		}
+		s.extraEnv[envRequest.Name] = envRequest.Value
 	default:
-		// Client requested a forbidden envvar, nothing to do
+		rejection = "not allowed"
 	}
+	accepted := rejection == ""
 
 	if req.WantReply {
 		if err := req.Reply(accepted, []byte{}); err != nil {
-			log.ContextLogger(ctx).WithError(err).Debug("session: handleEnv: Failed to reply")
+			logger.ContextLogger(ctx).WithError(err).Debug("session: handleEnv: Failed to reply")
 		}
 	}
 
-	log.WithContextFields(
-		ctx, log.Fields{"accepted": accepted, "env_request": envRequest},
+	if !accepted {
+		// The value isn't logged, it may be a secret sent to another server
+		logger.WithContextFields(
+			ctx, log.Fields{"env_name": envRequest.Name, "env_size": s.envSize, "reason": rejection},
+		).Info("session: handleEnv: rejected")
+
+		return true, nil
+	}
+
+	logger.WithContextFields(
+		ctx, log.Fields{"accepted": accepted, "env_request": logged},
 	).Debug("session: handleEnv: processed")
 
 	return true, nil
 }
 
+func (s *session) keyExpiryWarningPeriod() time.Duration {
+	if s.cfg != nil && s.cfg.Server.KeyExpiryWarningPeriod > 0 {
+		return time.Duration(s.cfg.Server.KeyExpiryWarningPeriod)
+	}
+
+	return defaultKeyExpiryWarningPeriod
+}
+
+func (s *session) maxEnvSize() int {
+	if s.cfg != nil && s.cfg.Server.MaxEnvSize > 0 {
+		return s.cfg.Server.MaxEnvSize
+	}
+
+	return defaultMaxEnvSize
+}
+
+func (s *session) isAllowedEnv(name string) bool {
+	if s.cfg == nil {
+		return false
+	}
+
+	for _, allowed := range s.cfg.Server.AllowedEnv {
+		if name == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isLocaleEnv(name string) bool {
+	for _, env := range i18n.LocaleEnvs {
+		if name == env {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handlePtyReq declines the pty, none being allocated, but keeps its terminal
+// to format the messages shown to the user
+func (s *session) handlePtyReq(ctx context.Context, req *ssh.Request) bool {
+	var ptyRequest ptyRequest
+	if err := ssh.Unmarshal(req.Payload, &ptyRequest); err != nil {
+		logger.ContextLogger(ctx).WithError(err).Debug("session: handlePtyReq: failed to unmarshal request")
+	} else {
+		terminal := console.NewTerminal(ptyRequest.Term, int(ptyRequest.Columns), s.cfg.ColorWarnings)
+		s.terminal = &terminal
+	}
+
+	if req.WantReply {
+		if err := req.Reply(false, []byte{}); err != nil {
+			logger.ContextLogger(ctx).WithError(err).Debug("session: handlePtyReq: Failed to reply")
+		}
+	}
+
+	return true
+}
+
 func (s *session) handleExec(ctx context.Context, req *ssh.Request) (context.Context, bool, error) {
 	var execRequest execRequest
 
@@ -151,7 +325,7 @@ func (s *session) handleExec(ctx context.Context, req *ssh.Request) (context.Con
 }
 
 func (s *session) handleShell(ctx context.Context, req *ssh.Request) (context.Context, uint32, error) {
-	ctxlog := log.ContextLogger(ctx)
+	ctxlog := logger.ContextLogger(ctx)
 
 	if req.WantReply {
 		if err := req.Reply(true, []byte{}); err != nil {
@@ -159,26 +333,76 @@ func (s *session) handleShell(ctx context.Context, req *ssh.Request) (context.Co
 		}
 	}
 
+	ctx = i18n.ContextWithLocale(ctx, i18n.Select(s.cfg.Locale, func(name string) string { return s.localeEnv[name] }))
+
+	if s.denyListedKeyMessage != "" {
+		s.toStderr(ctx, "ERROR: %v\n", s.denyListedKeyMessage)
+		s.writeErrorTrailer(ctx, errDenyListedKey)
+
+		return ctx, uint32(errorcode.ExitCode(errDenyListedKey)), errDenyListedKey
+	}
+
 	env := sshenv.Env{
 		IsSSHConnection:    true,
 		OriginalCommand:    s.execCmd,
 		GitProtocolVersion: s.gitProtocolVersion,
 		RemoteAddr:         s.remoteAddr,
 		NamespacePath:      s.namespace,
+		PreauthToken:       s.preauthToken,
+		Vars:               s.extraEnv,
 	}
 
-	countingWriter := &readwriter.CountingWriter{W: s.channel}
+	args := &commandargs.Shell{
+		GitlabKeyId:         s.gitlabKeyId,
+		GitlabUsername:      s.gitlabUsername,
+		GitlabKrb5Principal: s.gitlabKrb5Principal,
+		Env:                 env,
+		ActorType:           s.actorType,
+	}
+	if parsed, err := shellCmd.Parse(nil, env); err == nil {
+		args.CommandType = parsed.CommandType
+		args.SshArgs = parsed.SshArgs
+		logger.AddSessionFields(ctx, parsed.LogFields())
+	}
+	commandType := args.CommandType
+	timeout := command.Timeout(s.cfg, commandType)
+
+	// The menu waits on the user, whose input isn't a transfer to monitor,
+	// and runs its commands with their own timeouts
+	interactive := s.interactiveMenu()
+	monitor := newThroughputMonitor(s.cfg.Server.SlowClients)
+	if interactive {
+		monitor = nil
+		timeout = 0
+	}
+	countingWriter := &readwriter.CountingWriter{W: monitor.Writer(s.channel)}
+	countingReader := &readwriter.CountingReader{R: monitor.Reader(s.channel)}
+
+	recording, in := s.recorder.Start(ctx, args, countingReader)
 
 	rw := &readwriter.ReadWriter{
 		Out:    countingWriter,
-		In:     s.channel,
-		ErrOut: s.channel.Stderr(),
+		In:     in,
+		ErrOut: s.stderr(),
+	}
+
+	if s.commandHook != nil {
+		if err := s.commandHook(ctx, args); err != nil {
+			s.toStderr(ctx, "ERROR: %v\n", err)
+			s.writeErrorTrailer(ctx, err)
+
+			return ctx, uint32(errorcode.ExitCode(err)), err
+		}
 	}
 
 	var cmd command.Command
 	var err error
 
-	if s.gitlabKrb5Principal != "" {
+	if interactive {
+		cmd = &menu.Command{Config: s.cfg, Args: args, ReadWriter: rw, Build: func(args *commandargs.Shell, rw *readwriter.ReadWriter) (command.Command, error) {
+			return shellCmd.NewFromArgs(args, s.cfg, rw)
+		}}
+	} else if s.gitlabKrb5Principal != "" {
 		cmd, err = shellCmd.NewWithKrb5Principal(s.gitlabKrb5Principal, env, s.cfg, rw)
 	} else if s.gitlabUsername != "" {
 		cmd, err = shellCmd.NewWithUsername(s.gitlabUsername, env, s.cfg, rw)
@@ -187,37 +411,105 @@ func (s *session) handleShell(ctx context.Context, req *ssh.Request) (context.Co
 	}
 
 	if err != nil {
+		var disabledErr *disabledcommand.Error
 		if errors.Is(err, disallowedcommand.Error) {
 			s.toStderr(ctx, "ERROR: Unknown command: %v\n", s.execCmd)
+			help.Write(ctx, s.stderr(), s.cfg, &commandargs.Shell{
+				GitlabKeyId:         s.gitlabKeyId,
+				GitlabUsername:      s.gitlabUsername,
+				GitlabKrb5Principal: s.gitlabKrb5Principal,
+				ActorType:           s.actorType,
+			})
+		} else if errors.As(err, &disabledErr) {
+			s.toStderr(ctx, "ERROR: %v\n", disabledErr.Message)
 		} else {
 			s.toStderr(ctx, "ERROR: Failed to parse command: %v\n", err.Error())
 		}
+		s.writeErrorTrailer(ctx, err)
 
 		return ctx, 128, err
 	}
 
+	cgroup, err := s.cgroups.Create()
+	if err != nil {
+		// The helper processes of the session run without limits
+		ctxlog.WithError(err).Error("session: handleShell: failed to create the session cgroup")
+	}
+
+	hooked, err := s.hooks.Start(ctx, args, cgroup)
+	if err != nil {
+		s.removeCgroup(ctx, cgroup)
+		s.toStderr(ctx, "ERROR: %v\n", err)
+		s.writeErrorTrailer(ctx, err)
+
+		return ctx, uint32(errorcode.ExitCode(err)), err
+	}
+
 	cmdName := reflect.TypeOf(cmd).String()
 
 	establishSessionDuration := time.Since(s.started).Seconds()
 	ctxlog.WithFields(log.Fields{
-		"env": env, "command": cmdName, "established_session_duration_s": establishSessionDuration,
+		"env": env.Redacted(), "command": cmdName, "established_session_duration_s": establishSessionDuration,
 	}).Info("session: handleShell: executing command")
 	metrics.SshdSessionEstablishedDuration.Observe(establishSessionDuration)
 
-	ctxWithLogData, err := cmd.Execute(ctx)
+	if s.execCmd == "" && s.motd != "" {
+		fmt.Fprintln(s.channel, strings.TrimRight(s.motd, "\n"))
+	}
+
+	if s.execCmd == "" {
+		console.DisplayWarningMessages(s.forwardingNotices, s.stderr())
+	}
+
+	if warning := keyExpiryWarning(ctx, s.keyExpiresAt, s.keyExpiryWarningPeriod(), time.Now()); warning != "" {
+		console.DisplayWarningMessage(warning, s.stderr())
+	}
+
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+	go monitor.Run(monitorCtx, func(direction string) { s.evictSlowClient(ctx, direction) })
+
+	ctxWithLogData, err := command.ExecuteWithTimeout(ctx, cmd, timeout)
+	stopMonitor()
+	if monitor.Evicted() {
+		err = errSlowClient
+	}
+
+	metrics.GitTransferredBytesTotal.WithLabelValues(commandLabel(commandType), "in").Add(float64(countingReader.N))
+	metrics.GitTransferredBytesTotal.WithLabelValues(commandLabel(commandType), "out").Add(float64(countingWriter.N))
 
 	logData := extractDataFromContext(ctxWithLogData)
 	logData.WrittenBytes = countingWriter.N
+	logData.ReadBytes = countingReader.N
+
+	metrics.TopUserSessions.Add(logData.Username, 1)
+	if commandType == commandargs.UploadPack {
+		metrics.TopProjectFetchedBytes.Add(logData.Meta.Project, float64(countingWriter.N))
+	}
 
 	ctxWithLogData = context.WithValue(ctx, "logData", logData)
 
+	// Records are delivered in the background to not delay the exit status,
+	// after the post-session hook for the usage of the session cgroup to
+	// include it
+	sessionErr := err
+	s.deliveries.Go(func() {
+		hooked.Finish(ctx, logData, sessionErr)
+		recording.SetResources(s.removeCgroup(ctx, cgroup))
+		recording.Finish(ctx, logData, countingReader.N, countingWriter.N, sessionErr)
+	})
+
 	if err != nil {
-		grpcStatus := grpcstatus.Convert(err)
-		if grpcStatus.Code() != grpccodes.Internal {
+		var limitErr *accessverifier.LimitExceededError
+		if errors.As(err, &limitErr) {
+			console.DisplayWarningMessages(limitErr.Messages(), s.stderr())
+		} else if monitor.Evicted() {
+			// The client was told already
+		} else if grpcStatus := grpcstatus.Convert(err); grpcStatus.Code() != grpccodes.Internal {
 			s.toStderr(ctx, "ERROR: %v\n", grpcStatus.Message())
 		}
+		s.writeErrorTrailer(ctx, err)
 
-		return ctx, 1, err
+		return ctx, uint32(errorcode.ExitCode(err)), err
 	}
 
 	ctxlog.Info("session: handleShell: command executed successfully")
@@ -225,14 +517,116 @@ func (s *session) handleShell(ctx context.Context, req *ssh.Request) (context.Co
 	return ctxWithLogData, 0, nil
 }
 
+// interactiveMenu returns whether the user opened a session without a command
+// on a terminal, to be presented the menu of commands if it's enabled. The
+// sessions restricted to the Git commands of a namespace aren't.
+func (s *session) interactiveMenu() bool {
+	return s.cfg.Server.InteractiveMenu && s.execCmd == "" && s.terminal != nil && s.namespace == ""
+}
+
+// keyExpiryWarning returns the warning shown to users whose key expires within
+// period, or an empty string.
+func keyExpiryWarning(ctx context.Context, expiresAt string, period time.Duration, now time.Time) string {
+	if expiresAt == "" {
+		return ""
+	}
+
+	expiry, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return ""
+	}
+
+	remaining := expiry.Sub(now)
+	if remaining < 0 || remaining > period {
+		return ""
+	}
+
+	if remaining < 24*time.Hour {
+		return i18n.T(ctx, "Your SSH key expires today. Add a new key to avoid losing access.")
+	}
+
+	days := int(remaining / (24 * time.Hour))
+	if days == 1 {
+		return i18n.T(ctx, "Your SSH key expires in 1 day. Add a new key to avoid losing access.")
+	}
+
+	return i18n.Sprintf(ctx, "Your SSH key expires in %d days. Add a new key to avoid losing access.", days)
+}
+
+func (s *session) logFields() log.Fields {
+	fields := log.Fields{"remote_addr": s.remoteAddr}
+
+	if s.gitlabKeyId != "" {
+		fields["gl_key_id"] = s.gitlabKeyId
+	}
+	if s.gitlabUsername != "" {
+		fields["username"] = s.gitlabUsername
+	}
+	if s.gitlabKrb5Principal != "" {
+		fields["krb5principal"] = s.gitlabKrb5Principal
+	}
+	if s.actorType != "" {
+		fields["actor_type"] = s.actorType
+	}
+	if tenant := s.cfg.TenantName(); tenant != "" {
+		fields["tenant"] = tenant
+	}
+
+	return fields
+}
+
 func (s *session) toStderr(ctx context.Context, format string, args ...interface{}) {
-	out := fmt.Sprintf(format, args...)
-	log.WithContextFields(ctx, log.Fields{"stderr": out}).Debug("session: toStderr: output")
-	console.DisplayWarningMessage(out, s.channel.Stderr())
+	out := i18n.Sprintf(ctx, format, args...)
+	logger.WithContextFields(ctx, log.Fields{"stderr": out}).Debug("session: toStderr: output")
+	console.DisplayWarningMessage(out, s.stderr())
+}
+
+// stderr returns the stderr of the channel, the messages displayed to it
+// being formatted for the terminal of the client if it has one
+func (s *session) stderr() io.Writer {
+	if s.terminal == nil {
+		return s.channel.Stderr()
+	}
+
+	return &console.TerminalWriter{Writer: s.channel.Stderr(), Terminal: *s.terminal}
+}
+
+// writeErrorTrailer tells the user the reference of the session, to match
+// their report to the logs, and writes the trailer identifying the failure
+func (s *session) writeErrorTrailer(ctx context.Context, err error) {
+	console.DisplayReference(correlation.ExtractFromContext(ctx), s.stderr())
+	errorcode.WriteTrailer(s.stderr(), errorcode.Classify(err))
+}
+
+// removeCgroup logs the usage of the session cgroup, which is returned, and
+// removes it
+func (s *session) removeCgroup(ctx context.Context, cgroup *cgroups.Cgroup) *cgroups.Usage {
+	if cgroup == nil {
+		return nil
+	}
+
+	ctxlog := logger.ContextLogger(ctx)
+
+	usage, err := cgroup.Usage()
+	if err != nil {
+		ctxlog.WithError(err).Warn("session: removeCgroup: failed to read the usage of the session cgroup")
+	} else {
+		ctxlog.WithFields(log.Fields{
+			"cgroup_cpu_s":             usage.CPUS,
+			"cgroup_memory_peak_bytes": usage.MemoryPeakBytes,
+			"cgroup_oom_kills":         usage.OOMKills,
+		}).Info("session: removeCgroup: session cgroup usage")
+	}
+
+	if err := cgroup.Remove(); err != nil {
+		ctxlog.WithError(err).Warn("session: removeCgroup: failed to remove the session cgroup")
+	}
+
+	return usage
 }
 
 func (s *session) exit(ctx context.Context, status uint32) {
-	log.WithContextFields(ctx, log.Fields{"exit_status": status}).Info("session: exit: exiting")
+	logger.WithContextFields(ctx, log.Fields{"exit_status": status}).Info("session: exit: exiting")
 	req := exitStatusReq{ExitStatus: status}
 
 	s.channel.CloseWrite()
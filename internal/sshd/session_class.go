@@ -0,0 +1,96 @@
+package sshd
+
+import (
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sync/semaphore"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bandwidth"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+const defaultSessionClass = "default"
+
+// sessionClasses holds the concurrency pool and the bandwidth limiter shared
+// by the sessions of each class across all connections. A nil
+// *sessionClasses has no classes configured.
+type sessionClasses struct {
+	classes map[string]*sessionClass
+}
+
+// sessionClass limits the sessions of a class. A nil *sessionClass doesn't
+// limit anything.
+type sessionClass struct {
+	name     string
+	sessions *semaphore.Weighted
+	limiter  *bandwidth.Limiter
+}
+
+func newSessionClasses(cfg map[string]config.SessionClassConfig) *sessionClasses {
+	if len(cfg) == 0 {
+		return nil
+	}
+
+	classes := make(map[string]*sessionClass, len(cfg))
+	for name, classCfg := range cfg {
+		class := &sessionClass{name: name, limiter: bandwidth.NewLimiter(classCfg.Bandwidth)}
+		if classCfg.ConcurrentSessionsLimit > 0 {
+			class.sessions = semaphore.NewWeighted(classCfg.ConcurrentSessionsLimit)
+		}
+
+		classes[name] = class
+	}
+
+	return &sessionClasses{classes: classes}
+}
+
+// forConn returns the class of the sessions of sconn, the default one when
+// its class isn't configured
+func (c *sessionClasses) forConn(sconn *ssh.ServerConn) *sessionClass {
+	if c == nil {
+		return nil
+	}
+
+	if sconn != nil && sconn.Permissions != nil {
+		if class, ok := c.classes[sconn.Permissions.Extensions["session-class"]]; ok {
+			return class
+		}
+	}
+
+	return c.classes[defaultSessionClass]
+}
+
+func (c *sessionClass) tryAcquire() bool {
+	if c == nil {
+		return true
+	}
+
+	if c.sessions != nil && !c.sessions.TryAcquire(1) {
+		metrics.SshdSessionClassLimitedTotal.WithLabelValues(c.name).Inc()
+		return false
+	}
+
+	metrics.SshdSessionClassSessions.WithLabelValues(c.name).Inc()
+
+	return true
+}
+
+func (c *sessionClass) release() {
+	if c == nil {
+		return
+	}
+
+	if c.sessions != nil {
+		c.sessions.Release(1)
+	}
+
+	metrics.SshdSessionClassSessions.WithLabelValues(c.name).Dec()
+}
+
+func (c *sessionClass) bandwidthLimiter() *bandwidth.Limiter {
+	if c == nil {
+		return nil
+	}
+
+	return c.limiter
+}
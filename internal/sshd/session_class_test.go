@@ -0,0 +1,82 @@
+package sshd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sync/semaphore"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bandwidth"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+func connWithClass(class string) *ssh.ServerConn {
+	return &ssh.ServerConn{Permissions: &ssh.Permissions{Extensions: map[string]string{"session-class": class}}}
+}
+
+func TestSessionClassesForConn(t *testing.T) {
+	require.Nil(t, newSessionClasses(nil).forConn(connWithClass("ci")))
+
+	classes := newSessionClasses(map[string]config.SessionClassConfig{"ci": {}})
+	require.Equal(t, "ci", classes.forConn(connWithClass("ci")).name)
+	require.Nil(t, classes.forConn(connWithClass("unknown")))
+	require.Nil(t, classes.forConn(&ssh.ServerConn{Permissions: &ssh.Permissions{}}))
+
+	classes = newSessionClasses(map[string]config.SessionClassConfig{"ci": {}, "default": {}})
+	require.Equal(t, "default", classes.forConn(connWithClass("unknown")).name)
+	require.Equal(t, "default", classes.forConn(nil).name)
+}
+
+func TestSessionClassLimits(t *testing.T) {
+	classes := newSessionClasses(map[string]config.SessionClassConfig{
+		"ci": {ConcurrentSessionsLimit: 1, Bandwidth: bandwidth.Limits{Download: 1024 * 1024}},
+	})
+
+	newConn := func() *connection {
+		cfg := &config.Config{Server: config.ServerConfig{ConcurrentSessionsLimit: 10}}
+		return &connection{cfg: cfg, concurrentSessions: semaphore.NewWeighted(10), sessionClasses: classes}
+	}
+
+	limited := metrics.SshdSessionClassLimitedTotal.WithLabelValues("ci")
+	initialLimited := testutil.ToFloat64(limited)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first CI session holds the only slot of the class, with the
+	// bandwidth of the class
+	limiters := make(chan []*bandwidth.Limiter)
+	firstChans := make(chan ssh.NewChannel, 1)
+	firstChans <- &fakeNewChannel{channelType: "session"}
+	go newConn().handleRequests(ctx, connWithClass("ci"), firstChans, func(ctx context.Context, _ *ssh.ServerConn, _ ssh.Channel, _ <-chan *ssh.Request) error {
+		limiters <- bandwidth.LimitersFromContext(ctx)
+		<-ctx.Done()
+		return nil
+	})
+	require.Equal(t, []*bandwidth.Limiter{classes.classes["ci"].limiter}, <-limiters)
+
+	// A CI session of another connection is rejected
+	rejectCh := make(chan rejectCall, 1)
+	secondChans := make(chan ssh.NewChannel, 1)
+	secondChans <- &fakeNewChannel{channelType: "session", rejectCh: rejectCh}
+	close(secondChans)
+	newConn().handleRequests(ctx, connWithClass("ci"), secondChans, nil)
+
+	require.Equal(t, rejectCall{reason: ssh.ResourceShortage, message: "too many concurrent sessions"}, <-rejectCh)
+	require.InDelta(t, initialLimited+1, testutil.ToFloat64(limited), 0.1)
+
+	// Sessions of users aren't limited by the CI sessions
+	handled := false
+	userChans := make(chan ssh.NewChannel, 1)
+	userChans <- &fakeNewChannel{channelType: "session"}
+	newConn().handleRequests(ctx, connWithClass(""), userChans, func(context.Context, *ssh.ServerConn, ssh.Channel, <-chan *ssh.Request) error {
+		handled = true
+		close(userChans)
+		return nil
+	})
+	require.True(t, handled)
+}
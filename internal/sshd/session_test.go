@@ -6,14 +6,25 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/ssh"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/help"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/console"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sessionhook"
+
+	"gitlab.com/gitlab-org/labkit/correlation"
 )
 
 type fakeChannel struct {
@@ -79,6 +90,12 @@ func TestHandleEnv(t *testing.T) {
 			expectedErr:             nil,
 			expectedProtocolVersion: "2",
 			expectedResult:          true,
+		}, {
+			desc:                    "invalid Git protocol",
+			payload:                 ssh.Marshal(envRequest{Name: "GIT_PROTOCOL", Value: "version=3"}),
+			expectedErr:             nil,
+			expectedProtocolVersion: "1",
+			expectedResult:          true,
 		}, {
 			desc:                    "valid payload with forbidden env var",
 			payload:                 ssh.Marshal(envRequest{Name: "GIT_PROTOCOL_ENV", Value: "2"}),
@@ -102,6 +119,120 @@ func TestHandleEnv(t *testing.T) {
 	}
 }
 
+func TestHandleEnvPreauthToken(t *testing.T) {
+	s := &session{}
+	r := &ssh.Request{Payload: ssh.Marshal(envRequest{Name: "GITLAB_PREAUTH_TOKEN", Value: "token"})}
+
+	shouldContinue, err := s.handleEnv(context.Background(), r)
+	require.NoError(t, err)
+	require.True(t, shouldContinue)
+	require.Equal(t, "token", s.preauthToken)
+}
+
+func TestHandleEnvAllowlist(t *testing.T) {
+	cfg := &config.Config{Server: config.ServerConfig{AllowedEnv: []string{"GIT_TRACE_ID"}, MaxEnvSize: 80}}
+	s := &session{cfg: cfg}
+
+	for _, env := range []envRequest{
+		{Name: "GIT_TRACE_ID", Value: "abc"},
+		{Name: "LANG", Value: "fr_FR.UTF-8"},
+		{Name: "LD_PRELOAD", Value: "/tmp/evil.so"},
+		{Name: "GIT_PROTOCOL", Value: "version=2"},
+		// Over the 80 bytes of max_env_size
+		{Name: "GIT_TRACE_ID", Value: "abcdefgh"},
+		{Name: "LANG", Value: "de_DE.UTF-8"},
+	} {
+		shouldContinue, err := s.handleEnv(context.Background(), &ssh.Request{Payload: ssh.Marshal(env)})
+		require.NoError(t, err)
+		require.True(t, shouldContinue)
+	}
+
+	require.Equal(t, map[string]string{"GIT_TRACE_ID": "abc"}, s.extraEnv)
+	require.Equal(t, map[string]string{"LANG": "fr_FR.UTF-8"}, s.localeEnv)
+	require.Equal(t, "version=2", s.gitProtocolVersion)
+}
+
+func TestHandleShellLocale(t *testing.T) {
+	url := testserver.StartHttpServer(t, requests)
+
+	for _, tc := range []struct {
+		desc          string
+		locale        string
+		lang          string
+		expectedError string
+	}{
+		{desc: "default", expectedError: "ERROR: Unknown command: unknown-command"},
+		{desc: "instance default", locale: "fr", expectedError: "ERREUR : commande inconnue : unknown-command"},
+		{desc: "client locale", locale: "fr", lang: "de_DE.UTF-8", expectedError: "FEHLER: Unbekannter Befehl: unknown-command"},
+		{desc: "unsupported client locale", locale: "fr", lang: "pt_PT.UTF-8", expectedError: "ERREUR : commande inconnue : unknown-command"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			stdErr := &bytes.Buffer{}
+			s := &session{
+				gitlabKeyId: "root",
+				execCmd:     "unknown-command",
+				channel:     &fakeChannel{stdErr: stdErr, stdOut: &bytes.Buffer{}},
+				cfg:         &config.Config{GitlabUrl: url, Locale: tc.locale},
+			}
+
+			if tc.lang != "" {
+				_, err := s.handleEnv(context.Background(), &ssh.Request{Payload: ssh.Marshal(envRequest{Name: "LANG", Value: tc.lang})})
+				require.NoError(t, err)
+			}
+
+			_, _, err := s.handleShell(context.Background(), &ssh.Request{})
+			require.Error(t, err)
+			require.Contains(t, stdErr.String(), tc.expectedError)
+		})
+	}
+}
+
+func TestHandleShellWithTerminal(t *testing.T) {
+	stdErr := &bytes.Buffer{}
+	s := &session{
+		gitlabKeyId:          "root",
+		execCmd:              "discover",
+		denyListedKeyMessage: "Your SSH key has been revoked because it was found in a public repository",
+		channel:              &fakeChannel{stdErr: stdErr, stdOut: &bytes.Buffer{}},
+		cfg:                  &config.Config{ColorWarnings: true},
+	}
+
+	payload := ssh.Marshal(ptyRequest{Term: "xterm-256color", Columns: 40, Rows: 24})
+	require.True(t, s.handlePtyReq(context.Background(), &ssh.Request{Payload: payload}))
+	require.Equal(t, &console.Terminal{Width: 40, Color: true}, s.terminal)
+
+	_, _, err := s.handleShell(context.Background(), &ssh.Request{})
+	require.ErrorIs(t, err, errDenyListedKey)
+	require.Contains(t, stdErr.String(), "remote: \x1b[33mERROR: Your SSH key has been\x1b[0m\n"+
+		"remote: \x1b[33mrevoked because it was found in\x1b[0m\n"+
+		"remote: \x1b[33ma public repository\x1b[0m\n")
+}
+
+func TestInteractiveMenu(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		enabled  bool
+		execCmd  string
+		terminal *console.Terminal
+		expected bool
+	}{
+		{desc: "no command on a terminal", enabled: true, terminal: &console.Terminal{}, expected: true},
+		{desc: "disabled", terminal: &console.Terminal{}},
+		{desc: "command", enabled: true, execCmd: "whoami", terminal: &console.Terminal{}},
+		{desc: "no terminal", enabled: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &session{
+				cfg:      &config.Config{Server: config.ServerConfig{InteractiveMenu: tc.enabled}},
+				execCmd:  tc.execCmd,
+				terminal: tc.terminal,
+			}
+
+			require.Equal(t, tc.expected, s.interactiveMenu())
+		})
+	}
+}
+
 func TestHandleExec(t *testing.T) {
 	testCases := []struct {
 		desc               string
@@ -168,16 +299,19 @@ func TestHandleShell(t *testing.T) {
 		desc                 string
 		cmd                  string
 		errMsg               string
+		errCode              errorcode.Code
 		gitlabKeyId          string
 		expectedOutString    string
 		expectedErrString    string
 		expectedExitCode     uint32
 		expectedWrittenBytes int64
+		withHelp             bool
 	}{
 		{
 			desc:              "fails to parse command",
 			cmd:               `\`,
 			errMsg:            "ERROR: Failed to parse command: Invalid SSH command: invalid command line string\n",
+			errCode:           errorcode.Internal,
 			gitlabKeyId:       "root",
 			expectedErrString: "Invalid SSH command: invalid command line string",
 			expectedExitCode:  128,
@@ -186,6 +320,8 @@ func TestHandleShell(t *testing.T) {
 			desc:              "specified command is unknown",
 			cmd:               "unknown-command",
 			errMsg:            "ERROR: Unknown command: unknown-command\n",
+			withHelp:          true,
+			errCode:           errorcode.AccessDenied,
 			gitlabKeyId:       "root",
 			expectedErrString: "Disallowed command",
 			expectedExitCode:  128,
@@ -195,6 +331,7 @@ func TestHandleShell(t *testing.T) {
 			cmd:               "discover",
 			gitlabKeyId:       "",
 			errMsg:            "ERROR: Failed to get username: who='' is invalid\n",
+			errCode:           errorcode.Internal,
 			expectedErrString: "Failed to get username: who='' is invalid",
 			expectedExitCode:  1,
 		},
@@ -224,7 +361,11 @@ func TestHandleShell(t *testing.T) {
 			}
 			r := &ssh.Request{}
 
-			ctxWithLogData, exitCode, err := s.handleShell(context.Background(), r)
+			transferred := metrics.GitTransferredBytesTotal.WithLabelValues(tc.cmd, "out")
+			before := testutil.ToFloat64(transferred)
+
+			ctx := correlation.ContextWithCorrelation(context.Background(), "01HV2K5XSESSION")
+			ctxWithLogData, exitCode, err := s.handleShell(ctx, r)
 
 			logData := extractDataFromContext(ctxWithLogData)
 
@@ -238,10 +379,17 @@ func TestHandleShell(t *testing.T) {
 
 			require.Equal(t, tc.expectedExitCode, exitCode)
 			require.Equal(t, tc.expectedWrittenBytes, logData.WrittenBytes)
+			require.Equal(t, int64(0), logData.ReadBytes)
+			require.InDelta(t, float64(tc.expectedWrittenBytes), testutil.ToFloat64(transferred)-before, 0.1)
 
 			formattedErr := &bytes.Buffer{}
 			if tc.errMsg != "" {
 				console.DisplayWarningMessage(tc.errMsg, formattedErr)
+				if tc.withHelp {
+					help.Write(context.Background(), formattedErr, s.cfg, nil)
+				}
+				console.DisplayReference("01HV2K5XSESSION", formattedErr)
+				errorcode.WriteTrailer(formattedErr, tc.errCode)
 				require.Equal(t, formattedErr.String(), stdErr.String())
 			} else {
 				require.Equal(t, tc.errMsg, stdErr.String())
@@ -249,3 +397,225 @@ func TestHandleShell(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandLabel(t *testing.T) {
+	require.Equal(t, "git-upload-pack", commandLabel(commandargs.UploadPack))
+	require.Equal(t, "unknown", commandLabel(""))
+}
+
+func TestHandleShellWithMotd(t *testing.T) {
+	url := testserver.StartHttpServer(t, requests)
+
+	testCases := []struct {
+		desc              string
+		cmd               string
+		expectedOutString string
+	}{
+		{
+			desc:              "interactive session",
+			cmd:               "",
+			expectedOutString: "Your SSH key expires in 3 days\nWelcome to GitLab, @test-user!\n",
+		},
+		{
+			desc:              "command",
+			cmd:               "discover",
+			expectedOutString: "Welcome to GitLab, @test-user!\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			stdOut := &bytes.Buffer{}
+			s := &session{
+				gitlabKeyId: "root",
+				execCmd:     tc.cmd,
+				motd:        "Your SSH key expires in 3 days\n",
+				channel:     &fakeChannel{stdErr: &bytes.Buffer{}, stdOut: stdOut},
+				cfg:         &config.Config{GitlabUrl: url},
+			}
+
+			_, exitCode, err := s.handleShell(context.Background(), &ssh.Request{})
+			require.NoError(t, err)
+			require.Equal(t, uint32(0), exitCode)
+			require.Equal(t, tc.expectedOutString, stdOut.String())
+		})
+	}
+}
+
+func TestHandleForwardingReq(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		requestType     string
+		expectedNotices []string
+	}{
+		{
+			desc:            "X11 forwarding",
+			requestType:     x11ForwardingRequest,
+			expectedNotices: []string{x11ForwardingNotSupported},
+		}, {
+			desc:            "agent forwarding",
+			requestType:     agentForwardingRequest,
+			expectedNotices: []string{agentForwardingNotSupported},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &session{gitlabKeyId: "1"}
+
+			require.True(t, s.handleForwardingReq(context.Background(), &ssh.Request{Type: tc.requestType}))
+			require.Equal(t, tc.expectedNotices, s.forwardingNotices)
+		})
+	}
+}
+
+func TestHandleShellWithForwardingNotices(t *testing.T) {
+	url := testserver.StartHttpServer(t, requests)
+
+	for _, cmd := range []string{"", "discover"} {
+		stdErr := &bytes.Buffer{}
+		s := &session{
+			gitlabKeyId:       "root",
+			execCmd:           cmd,
+			forwardingNotices: []string{agentForwardingNotSupported},
+			channel:           &fakeChannel{stdErr: stdErr, stdOut: &bytes.Buffer{}},
+			cfg:               &config.Config{GitlabUrl: url},
+		}
+
+		_, _, err := s.handleShell(context.Background(), &ssh.Request{})
+		require.NoError(t, err)
+
+		if cmd == "" {
+			require.Contains(t, stdErr.String(), "remote: "+agentForwardingNotSupported)
+		} else {
+			require.Empty(t, stdErr.String())
+		}
+	}
+}
+
+func TestKeyExpiryWarning(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		desc      string
+		expiresAt string
+		period    time.Duration
+		expected  string
+	}{
+		{desc: "key without expiry", expiresAt: ""},
+		{desc: "invalid expiry", expiresAt: "tomorrow"},
+		{desc: "expired key", expiresAt: "2023-06-01T11:00:00Z"},
+		{desc: "key expiring later", expiresAt: "2023-07-01T12:00:00Z"},
+		{
+			desc:      "key expiring today",
+			expiresAt: "2023-06-01T23:00:00Z",
+			expected:  "Your SSH key expires today. Add a new key to avoid losing access.",
+		},
+		{
+			desc:      "key expiring in a day",
+			expiresAt: "2023-06-02T12:00:00Z",
+			expected:  "Your SSH key expires in 1 day. Add a new key to avoid losing access.",
+		},
+		{
+			desc:      "key expiring in 25 hours",
+			expiresAt: "2023-06-02T13:00:00Z",
+			expected:  "Your SSH key expires in 1 day. Add a new key to avoid losing access.",
+		},
+		{
+			desc:      "key expiring in 47 hours",
+			expiresAt: "2023-06-03T11:00:00Z",
+			expected:  "Your SSH key expires in 1 day. Add a new key to avoid losing access.",
+		},
+		{
+			desc:      "key expiring in a few days",
+			expiresAt: "2023-06-06T00:00:00Z",
+			expected:  "Your SSH key expires in 4 days. Add a new key to avoid losing access.",
+		},
+		{
+			desc:      "key expiring within a longer period",
+			expiresAt: "2023-07-01T12:00:00Z",
+			period:    60 * 24 * time.Hour,
+			expected:  "Your SSH key expires in 30 days. Add a new key to avoid losing access.",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if tc.period == 0 {
+				tc.period = defaultKeyExpiryWarningPeriod
+			}
+
+			require.Equal(t, tc.expected, keyExpiryWarning(context.Background(), tc.expiresAt, tc.period, now))
+		})
+	}
+}
+
+func TestHandleShellWithExpiringKey(t *testing.T) {
+	url := testserver.StartHttpServer(t, requests)
+
+	stdOut := &bytes.Buffer{}
+	stdErr := &bytes.Buffer{}
+	s := &session{
+		gitlabKeyId:  "root",
+		execCmd:      "discover",
+		keyExpiresAt: time.Now().Add(5*24*time.Hour + time.Minute).Format(time.RFC3339),
+		channel:      &fakeChannel{stdErr: stdErr, stdOut: stdOut},
+		cfg:          &config.Config{GitlabUrl: url},
+	}
+
+	_, exitCode, err := s.handleShell(context.Background(), &ssh.Request{})
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), exitCode)
+	require.Equal(t, "Welcome to GitLab, @test-user!\n", stdOut.String())
+
+	expectedErr := &bytes.Buffer{}
+	console.DisplayWarningMessage("Your SSH key expires in 5 days. Add a new key to avoid losing access.", expectedErr)
+	require.Equal(t, expectedErr.String(), stdErr.String())
+}
+
+func TestHandleShellWithDenyListedKey(t *testing.T) {
+	stdOut := &bytes.Buffer{}
+	stdErr := &bytes.Buffer{}
+	s := &session{
+		gitlabKeyId:          "root",
+		execCmd:              "discover",
+		denyListedKeyMessage: "Your SSH key has been revoked",
+		channel:              &fakeChannel{stdErr: stdErr, stdOut: stdOut},
+		cfg:                  &config.Config{},
+	}
+
+	_, exitCode, err := s.handleShell(context.Background(), &ssh.Request{})
+	require.ErrorIs(t, err, errDenyListedKey)
+	require.Equal(t, uint32(errorcode.AuthFailed.ExitCode()), exitCode)
+	require.Empty(t, stdOut.String())
+
+	expectedErr := &bytes.Buffer{}
+	console.DisplayWarningMessage("ERROR: Your SSH key has been revoked\n", expectedErr)
+	errorcode.WriteTrailer(expectedErr, errorcode.AuthFailed)
+	require.Equal(t, expectedErr.String(), stdErr.String())
+}
+
+func TestHandleShellDeniedByPreSessionHook(t *testing.T) {
+	hook := filepath.Join(t.TempDir(), "pre-session")
+	require.NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\necho 'GitLab is in a maintenance window'\nexit 1\n"), 0755))
+
+	stdOut := &bytes.Buffer{}
+	stdErr := &bytes.Buffer{}
+	s := &session{
+		gitlabKeyId: "root",
+		execCmd:     "discover",
+		channel:     &fakeChannel{stdErr: stdErr, stdOut: stdOut},
+		cfg:         &config.Config{GitlabUrl: testserver.StartHttpServer(t, requests)},
+		hooks:       sessionhook.New(config.SessionHooksConfig{PreSession: hook}),
+	}
+
+	_, exitCode, err := s.handleShell(context.Background(), &ssh.Request{})
+	require.EqualError(t, err, "GitLab is in a maintenance window")
+	require.Equal(t, uint32(errorcode.AccessDenied.ExitCode()), exitCode)
+	require.Empty(t, stdOut.String())
+
+	expectedErr := &bytes.Buffer{}
+	console.DisplayWarningMessage("ERROR: GitLab is in a maintenance window\n", expectedErr)
+	errorcode.WriteTrailer(expectedErr, errorcode.AccessDenied)
+	require.Equal(t, expectedErr.String(), stdErr.String())
+}
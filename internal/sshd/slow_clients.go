@@ -0,0 +1,206 @@
+package sshd
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/errorcode"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/i18n"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	defaultSlowClientsPeriod   = 30 * time.Second
+	slowClientsInterval        = time.Second
+	slowClientsMessageDeadline = time.Second
+)
+
+var errSlowClient = errorcode.New(errorcode.Timeout, "The session was terminated because the connection is too slow. Please try again from a faster network.")
+
+// throughputMonitor detects clients transferring data slower than the
+// minimum throughput for a period, e.g. on stalled mobile networks, so that
+// their sessions can be terminated to free their slot. A nil
+// *throughputMonitor doesn't monitor anything.
+//
+// The data sent to the client is slow while writing it is blocked on the
+// client. The data sent by the client is slow while it trickles in: the
+// client not sending anything can't be told apart from it waiting on the
+// server, e.g. while the hooks of a push run.
+type throughputMonitor struct {
+	// minBytes is the data that must be transferred every interval
+	minBytes  int64
+	slowTicks int
+	interval  time.Duration
+
+	in      *monitoredDirection
+	out     *monitoredDirection
+	evicted atomic.Bool
+}
+
+type monitoredDirection struct {
+	name    string
+	bytes   atomic.Int64
+	pending atomic.Int64
+
+	// Only accessed by the monitor
+	last      int64
+	slowTicks int
+}
+
+func newThroughputMonitor(cfg config.SlowClientsConfig) *throughputMonitor {
+	if cfg.MinThroughput <= 0 {
+		return nil
+	}
+
+	period := time.Duration(cfg.Period)
+	if period <= 0 {
+		period = defaultSlowClientsPeriod
+	}
+
+	return newThroughputMonitorWithInterval(cfg.MinThroughput, period, slowClientsInterval)
+}
+
+func newThroughputMonitorWithInterval(minThroughput int64, period, interval time.Duration) *throughputMonitor {
+	minBytes := int64(float64(minThroughput) * interval.Seconds())
+	if minBytes < 1 {
+		minBytes = 1
+	}
+
+	slowTicks := int((period + interval - 1) / interval)
+
+	return &throughputMonitor{
+		minBytes:  minBytes,
+		slowTicks: slowTicks,
+		interval:  interval,
+		in:        &monitoredDirection{name: "in"},
+		out:       &monitoredDirection{name: "out"},
+	}
+}
+
+// Reader monitors the data read from the client through r
+func (m *throughputMonitor) Reader(r io.Reader) io.Reader {
+	if m == nil {
+		return r
+	}
+
+	return &monitoredReader{r: r, d: m.in}
+}
+
+// Writer monitors the data written to the client through w
+func (m *throughputMonitor) Writer(w io.Writer) io.Writer {
+	if m == nil {
+		return w
+	}
+
+	return &monitoredWriter{w: w, d: m.out}
+}
+
+// Run checks the throughput every interval until ctx is done. evict is
+// called with the slow direction, in or out, once the client was too slow
+// for the period.
+func (m *throughputMonitor) Run(ctx context.Context, evict func(direction string)) {
+	if m == nil {
+		return
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, d := range []*monitoredDirection{m.out, m.in} {
+			if m.tick(d) {
+				m.evicted.Store(true)
+				evict(d.name)
+				return
+			}
+		}
+	}
+}
+
+// Evicted returns whether the client was found too slow
+func (m *throughputMonitor) Evicted() bool {
+	return m != nil && m.evicted.Load()
+}
+
+// tick returns whether the client has been too slow in direction d for the
+// whole period
+func (m *throughputMonitor) tick(d *monitoredDirection) bool {
+	bytes := d.bytes.Load()
+	transferred := bytes - d.last
+	d.last = bytes
+
+	switch {
+	case d.pending.Load() == 0 || transferred >= m.minBytes:
+		// Nothing is waiting on the client, or it's fast enough
+		d.slowTicks = 0
+	case transferred == 0 && d == m.in:
+		// The client may be waiting on the server
+	default:
+		d.slowTicks++
+	}
+
+	return d.slowTicks >= m.slowTicks
+}
+
+type monitoredReader struct {
+	r io.Reader
+	d *monitoredDirection
+}
+
+func (r *monitoredReader) Read(p []byte) (int, error) {
+	r.d.pending.Add(1)
+	defer r.d.pending.Add(-1)
+
+	n, err := r.r.Read(p)
+	r.d.bytes.Add(int64(n))
+
+	return n, err
+}
+
+type monitoredWriter struct {
+	w io.Writer
+	d *monitoredDirection
+}
+
+func (w *monitoredWriter) Write(p []byte) (int, error) {
+	w.d.pending.Add(1)
+	defer w.d.pending.Add(-1)
+
+	n, err := w.w.Write(p)
+	w.d.bytes.Add(int64(n))
+
+	return n, err
+}
+
+// evictSlowClient tells the client why its session is terminated and closes
+// the channel to unblock the command, which may be waiting on the client
+func (s *session) evictSlowClient(ctx context.Context, direction string) {
+	logger.WithContextFields(ctx, log.Fields{"direction": direction}).Warn("session: slow client evicted")
+	metrics.SshdSlowClientEvictionsTotal.WithLabelValues(direction).Inc()
+
+	// The client may be too slow to read the message as well
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		s.toStderr(ctx, "ERROR: %v\n", i18n.T(ctx, errSlowClient.Error()))
+	}()
+
+	select {
+	case <-written:
+	case <-time.After(slowClientsMessageDeadline):
+	}
+
+	s.channel.Close()
+}
@@ -0,0 +1,97 @@
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+func TestNewThroughputMonitor(t *testing.T) {
+	require.Nil(t, newThroughputMonitor(config.SlowClientsConfig{}))
+
+	monitor := newThroughputMonitor(config.SlowClientsConfig{MinThroughput: 1024})
+	require.Equal(t, int64(1024), monitor.minBytes)
+	require.Equal(t, 30, monitor.slowTicks)
+
+	monitor = newThroughputMonitor(config.SlowClientsConfig{MinThroughput: 1024, Period: config.YamlDuration(2500 * time.Millisecond)})
+	require.Equal(t, 3, monitor.slowTicks)
+
+	var nilMonitor *throughputMonitor
+	r := bytes.NewReader(nil)
+	require.Equal(t, io.Reader(r), nilMonitor.Reader(r))
+	require.False(t, nilMonitor.Evicted())
+}
+
+func TestThroughputMonitorTicks(t *testing.T) {
+	monitor := newThroughputMonitorWithInterval(100, 3*time.Second, time.Second)
+
+	// Idle clients aren't slow
+	require.False(t, monitor.tick(monitor.out))
+	require.False(t, monitor.tick(monitor.in))
+
+	// Writes blocked on the client are slow
+	monitor.out.pending.Store(1)
+	require.False(t, monitor.tick(monitor.out))
+	monitor.out.bytes.Add(100)
+	require.False(t, monitor.tick(monitor.out))
+	require.False(t, monitor.tick(monitor.out))
+	monitor.out.bytes.Add(10)
+	require.False(t, monitor.tick(monitor.out))
+	require.True(t, monitor.tick(monitor.out))
+
+	// Reads are only slow when the data trickles in, the client may be
+	// waiting on the server otherwise
+	monitor.in.pending.Store(1)
+	for i := 0; i < 5; i++ {
+		require.False(t, monitor.tick(monitor.in))
+	}
+	for i := 0; i < 2; i++ {
+		monitor.in.bytes.Add(10)
+		require.False(t, monitor.tick(monitor.in))
+	}
+	monitor.in.bytes.Add(10)
+	require.True(t, monitor.tick(monitor.in))
+}
+
+func TestThroughputMonitorEvictsStalledClient(t *testing.T) {
+	monitor := newThroughputMonitorWithInterval(1024, 50*time.Millisecond, 10*time.Millisecond)
+
+	// Nothing reads the data written to the pipe
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	writeErr := make(chan error)
+	go func() {
+		_, err := monitor.Writer(pw).Write([]byte("data"))
+		writeErr <- err
+	}()
+
+	evicted := make(chan string, 1)
+	go monitor.Run(context.Background(), func(direction string) {
+		evicted <- direction
+		pw.CloseWithError(errSlowClient)
+	})
+
+	require.Equal(t, "out", <-evicted)
+	require.ErrorIs(t, <-writeErr, io.ErrClosedPipe)
+	require.True(t, monitor.Evicted())
+}
+
+func TestEvictSlowClient(t *testing.T) {
+	before := testutil.ToFloat64(metrics.SshdSlowClientEvictionsTotal.WithLabelValues("in"))
+
+	out := &bytes.Buffer{}
+	s := &session{channel: &fakeChannel{stdErr: out}}
+	s.evictSlowClient(context.Background(), "in")
+
+	require.Contains(t, out.String(), "ERROR: "+errSlowClient.Error())
+	require.Equal(t, before+1, testutil.ToFloat64(metrics.SshdSlowClientEvictionsTotal.WithLabelValues("in")))
+}
@@ -0,0 +1,83 @@
+package sshd
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// listenTCP listens on address with the socket options of cfg
+func listenTCP(ctx context.Context, cfg config.SocketConfig, address string) (net.Listener, error) {
+	listener, err := bindTCP(ctx, cfg, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return tuneListener(cfg, listener), nil
+}
+
+// bindTCP creates the listening socket with the options of cfg that apply to
+// the socket itself, leaving the ones of the accepted connections to
+// tuneListener
+func bindTCP(ctx context.Context, cfg config.SocketConfig, address string) (*net.TCPListener, error) {
+	lc := net.ListenConfig{Control: socketControl(cfg)}
+
+	listener, err := lc.Listen(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Backlog > 0 {
+		if err := setBacklog(listener, cfg.Backlog); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener.(*net.TCPListener), nil
+}
+
+// tuneListener applies the options of cfg to the connections listener
+// accepts, when they differ from the defaults of Go
+func tuneListener(cfg config.SocketConfig, listener net.Listener) net.Listener {
+	if !cfg.DisableNoDelay && cfg.KeepAlivePeriod == 0 {
+		return listener
+	}
+
+	return &tunedListener{Listener: listener, cfg: cfg}
+}
+
+// tunedListener sets the keepalive period of the connections it accepts and
+// turns Nagle's algorithm back on for them, as Go sets TCP_NODELAY on all
+// of them
+type tunedListener struct {
+	net.Listener
+	cfg config.SocketConfig
+}
+
+func (l *tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+
+	if l.cfg.DisableNoDelay {
+		tcpConn.SetNoDelay(false)
+	}
+
+	switch period := time.Duration(l.cfg.KeepAlivePeriod); {
+	case period < 0:
+		tcpConn.SetKeepAlive(false)
+	case period > 0:
+		tcpConn.SetKeepAlivePeriod(period)
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,61 @@
+package sshd
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// socketControl sets the options of the listening socket that must be set
+// before it's bound
+func socketControl(cfg config.SocketConfig) func(network, address string, c syscall.RawConn) error {
+	if !cfg.ReusePort {
+		return nil
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		})
+		if err != nil {
+			return err
+		}
+		if sockErr != nil {
+			return fmt.Errorf("failed to set SO_REUSEPORT: %w", sockErr)
+		}
+
+		return nil
+	}
+}
+
+// setBacklog changes the backlog of a listening socket, which Go sets to the
+// limit of the system, by calling listen again as Linux allows it
+func setBacklog(listener net.Listener, backlog int) error {
+	tcpListener, ok := listener.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("unexpected listener %T", listener)
+	}
+
+	rawConn, err := tcpListener.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var listenErr error
+	err = rawConn.Control(func(fd uintptr) {
+		listenErr = unix.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	if listenErr != nil {
+		return fmt.Errorf("failed to set the backlog: %w", listenErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package sshd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func TestListenTCPReusePort(t *testing.T) {
+	cfg := config.SocketConfig{ReusePort: true, Backlog: 16}
+
+	first, err := listenTCP(context.Background(), cfg, "127.0.0.1:0")
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := listenTCP(context.Background(), cfg, first.Addr().String())
+	require.NoError(t, err)
+	second.Close()
+
+	_, err = listenTCP(context.Background(), config.SocketConfig{}, first.Addr().String())
+	require.ErrorContains(t, err, "address already in use")
+}
+
+func TestListenTCPNoDelay(t *testing.T) {
+	for _, disableNoDelay := range []bool{false, true} {
+		listener, err := listenTCP(context.Background(), config.SocketConfig{DisableNoDelay: disableNoDelay}, "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		client, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		defer client.Close()
+
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		rawConn, err := conn.(*net.TCPConn).SyscallConn()
+		require.NoError(t, err)
+
+		var noDelay int
+		var sockErr error
+		require.NoError(t, rawConn.Control(func(fd uintptr) {
+			noDelay, sockErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_NODELAY)
+		}))
+		require.NoError(t, sockErr)
+
+		if disableNoDelay {
+			require.Zero(t, noDelay)
+		} else {
+			require.NotZero(t, noDelay)
+		}
+	}
+}
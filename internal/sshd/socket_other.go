@@ -0,0 +1,25 @@
+//go:build !linux
+
+package sshd
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+func socketControl(cfg config.SocketConfig) func(network, address string, c syscall.RawConn) error {
+	if !cfg.ReusePort {
+		return nil
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		return errors.New("sshd socket reuseport is only supported on Linux")
+	}
+}
+
+func setBacklog(listener net.Listener, backlog int) error {
+	return errors.New("sshd socket backlog is only supported on Linux")
+}
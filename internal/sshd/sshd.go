@@ -2,21 +2,33 @@ package sshd
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/pprof"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	proxyproto "github.com/pires/go-proxyproto"
 	"golang.org/x/crypto/ssh"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/bandwidth"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/cgroups"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/events"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/gitlabnet"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logger"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sessionhook"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sessionrecord"
 
 	"gitlab.com/gitlab-org/labkit/correlation"
 	"gitlab.com/gitlab-org/labkit/log"
@@ -24,6 +36,23 @@ import (
 
 type status int
 
+const (
+	apiCapturePath  = "/debug/capture_api"
+	configPath      = "/debug/config"
+	metricsPath     = "/metrics"
+	accessCachePath = "/debug/access_cache"
+	maintenancePath = "/debug/maintenance"
+)
+
+// eventsFlushTimeout bounds how long pending events are delivered for once the
+// server is stopped
+const eventsFlushTimeout = 5 * time.Second
+
+// deliveriesTimeout bounds how long the server waits for the records and
+// post-session hooks of the last sessions once they are closed, which are
+// each bound by a 5 seconds timeout by default
+const deliveriesTimeout = 15 * time.Second
+
 const (
 	StatusStarting status = iota
 	StatusReady
@@ -33,12 +62,31 @@ const (
 
 type Server struct {
 	Config *config.Config
+	// Version and BuildTime are reported by the probes
+	Version   string
+	BuildTime string
+	// Authenticate and CommandHook customize the server for programs
+	// embedding it, see AuthFunc and CommandHook
+	Authenticate AuthFunc
+	CommandHook  CommandHook
 
 	status       status
 	statusMu     sync.RWMutex
 	wg           sync.WaitGroup
+	deliveries   backgroundTasks
 	listener     net.Listener
 	serverConfig *serverConfig
+	recorder     *sessionrecord.Recorder
+	hooks        *sessionhook.Runner
+	cgroups      *cgroups.Manager
+	events       *events.Publisher
+	readiness    *readinessChecker
+	classes      *sessionClasses
+	startups     *startupLimiter
+
+	started        time.Time
+	activeSessions atomic.Int64
+	sessions       sessionRegistry
 }
 
 func NewServer(cfg *config.Config) (*Server, error) {
@@ -47,14 +95,64 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		return nil, err
 	}
 
-	return &Server{Config: cfg, serverConfig: serverConfig}, nil
+	maxStartups, err := config.ParseMaxStartups(cfg.Server.MaxStartups)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionCgroups, err := cgroups.New(cfg.SessionCgroups)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := cfg.HTTPTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		Config:       cfg,
+		started:      time.Now(),
+		serverConfig: serverConfig,
+		recorder:     sessionrecord.New(cfg.SessionRecording, transport),
+		hooks:        sessionhook.New(cfg.SessionHooks),
+		cgroups:      sessionCgroups,
+		events:       events.New(cfg.Events, transport),
+		readiness:    newReadinessChecker(cfg),
+		classes:      newSessionClasses(cfg.Server.SessionClasses),
+		startups:     newStartupLimiter(maxStartups),
+	}, nil
 }
 
 func (s *Server) ListenAndServe(ctx context.Context) error {
-	if err := s.listen(ctx); err != nil {
+	listener, err := s.listen(ctx)
+	if err != nil {
 		return err
 	}
+
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts the SSH connections of listener until Shutdown is called,
+// e.g. of a listener set up by a program embedding the server. The PROXY
+// protocol is handled when enabled.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	listener, err := s.withProxyProtocol(ctx, listener)
+	if err != nil {
+		return err
+	}
+
+	log.WithContextFields(ctx, log.Fields{"tcp_address": listener.Addr().String()}).Info("Listening for SSH connections")
+
+	s.listener = listener
+	s.serverConfig.authenticate = s.Authenticate
 	defer s.listener.Close()
+	defer s.events.Close(eventsFlushTimeout)
+
+	backgroundCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.serverConfig.keyFilter.Run(backgroundCtx)
+	go s.readiness.Run(backgroundCtx)
 
 	s.serve(ctx)
 
@@ -67,6 +165,7 @@ func (s *Server) Shutdown() error {
 	}
 
 	s.changeStatus(StatusOnShutdown)
+	s.events.Publish(context.Background(), events.ShutdownStarted, nil)
 
 	return s.listener.Close()
 }
@@ -74,47 +173,280 @@ func (s *Server) Shutdown() error {
 func (s *Server) MonitoringServeMux() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc(s.Config.Server.ReadinessProbe, func(w http.ResponseWriter, r *http.Request) {
-		if s.getStatus() == StatusReady {
+	mux.HandleFunc(s.Config.Server.ReadinessProbe, s.handleReadinessProbe)
+
+	mux.HandleFunc(s.Config.Server.LivenessProbe, func(w http.ResponseWriter, r *http.Request) {
+		if !s.Config.Server.DetailedProbes {
 			w.WriteHeader(http.StatusOK)
-		} else {
-			w.WriteHeader(http.StatusServiceUnavailable)
+			return
 		}
+
+		writeJSONResponse(w, http.StatusOK, s.probeDetails())
 	})
 
-	mux.HandleFunc(s.Config.Server.LivenessProbe, func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
+	mux.Handle(configPath, s.adminAuth(http.HandlerFunc(s.handleConfig)))
+
+	// The endpoints changing the state are served by the control socket
+	// instead when enabled
+	if s.Config.Server.ControlSocket.Path == "" {
+		mux.Handle(apiCapturePath, s.adminAuth(http.HandlerFunc(s.handleAPICapture)))
+		mux.Handle(accessCachePath, s.adminAuth(http.HandlerFunc(s.handleAccessCache)))
+		mux.Handle(maintenancePath, s.adminAuth(http.HandlerFunc(s.handleMaintenance)))
+	}
+
+	mux.Handle(metricsPath, metrics.Handler())
+
+	if s.ServesPprof() {
+		s.handleProfiling(mux)
+	}
+
+	return s.webAuth(mux)
+}
+
+func (s *Server) handleReadinessProbe(w http.ResponseWriter, r *http.Request) {
+	ready := s.getStatus() == StatusReady
+
+	var dependencies []dependencyStatus
+	if ready && s.readiness != nil {
+		ready, dependencies = s.readiness.result()
+	}
+
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	var body map[string]interface{}
+	if s.Config.Server.DetailedProbes {
+		body = s.probeDetails()
+	}
+	if dependencies != nil {
+		if body == nil {
+			body = map[string]interface{}{}
+		}
+		body["dependencies"] = dependencies
+	}
+
+	if body == nil {
+		w.WriteHeader(code)
+		return
+	}
+
+	body["ready"] = ready
+	writeJSONResponse(w, code, body)
+}
+
+func (s *Server) probeDetails() map[string]interface{} {
+	return map[string]interface{}{
+		"status":          s.getStatus().String(),
+		"version":         s.Version,
+		"build_time":      s.BuildTime,
+		"uptime_s":        time.Since(s.started).Seconds(),
+		"active_sessions": s.activeSessions.Load(),
+	}
+}
+
+func writeJSONResponse(w http.ResponseWriter, code int, body map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) handleProfiling(mux *http.ServeMux) {
+	handlers := map[string]http.HandlerFunc{
+		"/debug/pprof/":        pprof.Index,
+		"/debug/pprof/cmdline": pprof.Cmdline,
+		"/debug/pprof/profile": pprof.Profile,
+		"/debug/pprof/symbol":  pprof.Symbol,
+		"/debug/pprof/trace":   pprof.Trace,
+	}
+
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, s.profilingAuth(handler))
+	}
+}
+
+// ServesPprof tells whether pprof is served by the monitoring mux, behind
+// the profiling credentials or web_auth, instead of by labkit. It isn't
+// served at all unless profiling is enabled.
+func (s *Server) ServesPprof() bool {
+	profiling := s.Config.Server.Profiling
+	webAuth := s.Config.Server.WebAuth
+
+	if !profiling.Enabled {
+		return false
+	}
+
+	return profiling.Username != "" || profiling.Password != "" ||
+		webAuth.Username != "" || webAuth.Password != "" || webAuth.BearerToken != ""
+}
+
+// profilingAuth requires the configured basic auth credentials, if any
+func (s *Server) profilingAuth(next http.Handler) http.Handler {
+	profiling := s.Config.Server.Profiling
+	if profiling.Username == "" && profiling.Password == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(profiling.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(profiling.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gitlab-sshd profiling"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
 	})
+}
 
-	return mux
+// adminAuth requires the profiling credentials, and forbids the admin
+// endpoints when none are configured
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	profiling := s.Config.Server.Profiling
+	if profiling.Username == "" && profiling.Password == "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "the admin endpoints require sshd profiling credentials", http.StatusForbidden)
+		})
+	}
+
+	return s.profilingAuth(next)
 }
 
-func (s *Server) listen(ctx context.Context) error {
-	sshListener, err := net.Listen("tcp", s.Config.Server.Listen)
+// handleConfig serves the configuration in effect, with secrets redacted,
+// and where each setting comes from
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	values, sources, err := s.Config.Sanitized()
 	if err != nil {
-		return fmt.Errorf("failed to listen for connection: %w", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"config": values, "sources": sources})
+}
+
+// handleAccessCache invalidates the cached access checks on DELETE, e.g. when
+// a user lost access to a project. The `project` and `user` parameters
+// restrict the checks invalidated, `user` being key-<id> or user-<username>.
+func (s *Server) handleAccessCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
 	}
 
-	if s.Config.Server.ProxyProtocol {
-		policy, err := s.proxyPolicy()
+	project := r.FormValue("project")
+	user := r.FormValue("user")
+
+	invalidated := 0
+	for _, cfg := range append([]*config.Config{s.Config}, s.Config.TenantConfigs()...) {
+		invalidated += cfg.AccessCheckCache().Invalidate(project, user)
+	}
+
+	logger.WithContextFields(r.Context(), log.Fields{"project": project, "user": user, "invalidated": invalidated}).Info("access cache invalidated")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"invalidated": invalidated})
+}
+
+// handleAPICapture reports whether internal API interactions are captured.
+// A POST with an `enabled` parameter turns the capture on or off.
+func (s *Server) handleAPICapture(w http.ResponseWriter, r *http.Request) {
+	recorder := s.Config.APICapture()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		enabled, err := strconv.ParseBool(r.FormValue("enabled"))
 		if err != nil {
-			return fmt.Errorf("invalid policy configuration: %w", err)
+			http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+			return
 		}
 
-		sshListener = &proxyproto.Listener{
-			Listener:          sshListener,
-			Policy:            policy,
-			ReadHeaderTimeout: time.Duration(s.Config.Server.ProxyHeaderTimeout),
+		recorder.SetEnabled(enabled)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"enabled": recorder.Enabled()})
+}
+
+// handleMaintenance reports whether pushes are rejected for maintenance. A
+// POST with an `enabled` parameter turns maintenance mode on or off, with the
+// `message` parameter shown to users.
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	mode := s.Config.Maintenance()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+		if err != nil {
+			http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+			return
 		}
 
-		log.ContextLogger(ctx).Info("Proxy protocol is enabled")
+		mode.Set(enabled, r.FormValue("message"))
+
+		logger.WithContextFields(r.Context(), log.Fields{"enabled": enabled}).Info("maintenance mode changed")
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
 	}
 
-	log.WithContextFields(ctx, log.Fields{"tcp_address": sshListener.Addr().String()}).Info("Listening for SSH connections")
+	enabled, message := mode.State()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"enabled": enabled, "message": message})
+}
 
-	s.listener = sshListener
+func (s *Server) listen(ctx context.Context) (net.Listener, error) {
+	var sshListener net.Listener
+	var err error
+	if _, ok := WorkerIndex(); ok {
+		sshListener, err = workerListener(s.Config.Server.Socket)
+	} else {
+		sshListener, err = listenTCP(ctx, s.Config.Server.Socket, s.Config.Server.Listen)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for connection: %w", err)
+	}
 
-	return nil
+	return sshListener, nil
+}
+
+// withProxyProtocol wraps listener to read the PROXY protocol header of the
+// connections when it's enabled. listener is closed on errors.
+func (s *Server) withProxyProtocol(ctx context.Context, listener net.Listener) (net.Listener, error) {
+	if !s.Config.Server.ProxyProtocol {
+		return listener, nil
+	}
+
+	policy, err := s.proxyPolicy()
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("invalid policy configuration: %w", err)
+	}
+
+	log.ContextLogger(ctx).Info("Proxy protocol is enabled")
+
+	return &proxyproto.Listener{
+		Listener:          listener,
+		Policy:            policy,
+		ReadHeaderTimeout: time.Duration(s.Config.Server.ProxyHeaderTimeout),
+	}, nil
 }
 
 func (s *Server) serve(ctx context.Context) {
@@ -137,9 +469,65 @@ func (s *Server) serve(ctx context.Context) {
 
 	s.wg.Wait()
 
+	if !s.deliveries.Wait(deliveriesTimeout) {
+		logger.ContextLogger(ctx).Warn("Timed out delivering the session records and post-session hooks")
+	}
+
 	s.changeStatus(StatusClosed)
 }
 
+// backgroundTasks tracks the work left once a session is closed, for the
+// server to complete it before exiting
+type backgroundTasks struct {
+	wg sync.WaitGroup
+}
+
+// Go runs fn in the background, untracked when t is nil
+func (t *backgroundTasks) Go(fn func()) {
+	if t == nil {
+		go fn()
+		return
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		fn()
+	}()
+}
+
+// Wait waits up to timeout for the tasks to complete, and returns whether
+// they did
+func (t *backgroundTasks) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (st status) String() string {
+	switch st {
+	case StatusStarting:
+		return "starting"
+	case StatusReady:
+		return "ready"
+	case StatusOnShutdown:
+		return "shutting_down"
+	case StatusClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
 func (s *Server) changeStatus(st status) {
 	s.statusMu.Lock()
 	s.status = st
@@ -169,11 +557,23 @@ func contextWithValues(parent context.Context, nconn net.Conn) context.Context {
 func (s *Server) handleConn(ctx context.Context, nconn net.Conn) {
 	defer s.wg.Done()
 
+	// Connections are dropped before reading anything from them, e.g. the
+	// PROXY protocol header, when too many are in their handshake
+	releaseStartup, ok := s.startups.tryAcquire()
+	if !ok {
+		log.WithContextFields(ctx, log.Fields{}).Info("server: handleConn: too many connections in their handshake, dropping connection")
+		nconn.Close()
+		return
+	}
+	defer releaseStartup()
+
 	metrics.SshdConnectionsInFlight.Inc()
 	defer metrics.SshdConnectionsInFlight.Dec()
 
 	ctx, cancel := context.WithCancel(contextWithValues(ctx, nconn))
 	defer cancel()
+
+	ctx = bandwidth.ContextWithLimiter(ctx, bandwidth.NewLimiter(s.Config.Bandwidth.PerConnection))
 	go func() {
 		<-ctx.Done()
 		nconn.Close() // Close the connection when context is cancelled
@@ -185,7 +585,7 @@ func (s *Server) handleConn(ctx context.Context, nconn net.Conn) {
 	// Prevent a panic in a single connection from taking out the whole server
 	defer func() {
 		if err := recover(); err != nil {
-			ctxlog.WithField("recovered_error", err).Error("panic handling session")
+			recordPanic(ctxlog, err, "connection")
 
 			metrics.SliSshdSessionsErrorsTotal.Inc()
 		}
@@ -193,32 +593,66 @@ func (s *Server) handleConn(ctx context.Context, nconn net.Conn) {
 
 	started := time.Now()
 	conn := newConnection(s.Config, nconn)
+	conn.events = s.events
+	conn.sessionClasses = s.classes
+	conn.handshakeDone = releaseStartup
 
 	var ctxWithLogData context.Context
 
 	conn.handle(ctx, s.serverConfig.get(ctx), func(ctx context.Context, sconn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request) error {
+		cfg := s.Config
+		if tenant := sconn.Permissions.Extensions["tenant"]; tenant != "" {
+			cfg = s.Config.Tenant(tenant)
+		}
+
 		session := &session{
-			cfg:                 s.Config,
-			channel:             channel,
-			gitlabKeyId:         sconn.Permissions.Extensions["key-id"],
-			gitlabKrb5Principal: sconn.Permissions.Extensions["krb5principal"],
-			gitlabUsername:      sconn.Permissions.Extensions["username"],
-			namespace:           sconn.Permissions.Extensions["namespace"],
-			remoteAddr:          remoteAddr,
-			started:             time.Now(),
+			cfg:                  cfg,
+			channel:              channel,
+			gitlabKeyId:          sconn.Permissions.Extensions["key-id"],
+			gitlabKrb5Principal:  sconn.Permissions.Extensions["krb5principal"],
+			gitlabUsername:       sconn.Permissions.Extensions["username"],
+			namespace:            sconn.Permissions.Extensions["namespace"],
+			actorType:            commandargs.ActorType(sconn.Permissions.Extensions["actor-type"]),
+			motd:                 sconn.Permissions.Extensions["motd"],
+			keyExpiresAt:         sconn.Permissions.Extensions["key-expires-at"],
+			denyListedKeyMessage: sconn.Permissions.Extensions["key-deny-listed"],
+			recorder:             s.recorder,
+			commandHook:          s.CommandHook,
+			hooks:                s.hooks,
+			deliveries:           &s.deliveries,
+			cgroups:              s.cgroups,
+			remoteAddr:           remoteAddr,
+			started:              time.Now(),
 		}
 
+		s.activeSessions.Add(1)
+		defer s.activeSessions.Add(-1)
+		defer s.sessions.add(session)()
+
+		eventData := session.logFields()
+		s.events.Publish(ctx, events.SessionStarted, eventData)
+
 		var err error
 		ctxWithLogData, err = session.handle(ctx, requests)
 
+		endedData := log.Fields{"duration_s": time.Since(session.started).Seconds()}
+		for k, v := range eventData {
+			endedData[k] = v
+		}
+		if err != nil {
+			endedData["error"] = err.Error()
+		}
+		s.events.Publish(ctx, events.SessionEnded, endedData)
+
 		return err
 	})
 
 	logData := extractDataFromContext(ctxWithLogData)
 
-	ctxlog.WithFields(log.Fields{
+	ctxlog.WithFields(logger.SessionFields(ctxWithLogData)).WithFields(log.Fields{
 		"duration_s":    time.Since(started).Seconds(),
 		"written_bytes": logData.WrittenBytes,
+		"read_bytes":    logData.ReadBytes,
 		"meta":          logData.Meta,
 	}).Info("access: finish")
 }
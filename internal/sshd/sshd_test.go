@@ -1,23 +1,34 @@
 package sshd
 
 import (
+	"bufio"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/pires/go-proxyproto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/ssh"
 
 	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/accesscache"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/events"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/maintenance"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
 	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
 )
 
@@ -271,6 +282,190 @@ func TestLivenessProbe(t *testing.T) {
 	require.Equal(t, 200, r.Result().StatusCode)
 }
 
+func TestDetailedProbes(t *testing.T) {
+	cfg := &config.Config{Server: config.DefaultServerConfig}
+	cfg.Server.DetailedProbes = true
+
+	s := &Server{Config: cfg, Version: "v14.0.0", BuildTime: "20240101.000000", started: time.Now().Add(-time.Minute)}
+	s.activeSessions.Add(2)
+	mux := s.MonitoringServeMux()
+
+	for _, tc := range []struct {
+		path           string
+		status         status
+		expectedCode   int
+		expectedStatus string
+		expectedReady  interface{}
+	}{
+		{path: "/start", status: StatusStarting, expectedCode: 503, expectedStatus: "starting", expectedReady: false},
+		{path: "/start", status: StatusReady, expectedCode: 200, expectedStatus: "ready", expectedReady: true},
+		{path: "/start", status: StatusOnShutdown, expectedCode: 503, expectedStatus: "shutting_down", expectedReady: false},
+		{path: "/health", status: StatusOnShutdown, expectedCode: 200, expectedStatus: "shutting_down"},
+	} {
+		t.Run(tc.path+" "+tc.expectedStatus, func(t *testing.T) {
+			s.changeStatus(tc.status)
+
+			r := httptest.NewRecorder()
+			mux.ServeHTTP(r, httptest.NewRequest("GET", tc.path, nil))
+			require.Equal(t, tc.expectedCode, r.Result().StatusCode)
+			require.Equal(t, "application/json", r.Result().Header.Get("Content-Type"))
+
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			require.Equal(t, tc.expectedStatus, body["status"])
+			require.Equal(t, "v14.0.0", body["version"])
+			require.Equal(t, "20240101.000000", body["build_time"])
+			require.InDelta(t, 2, body["active_sessions"], 0.1)
+			require.GreaterOrEqual(t, body["uptime_s"], 60.0)
+			require.Equal(t, tc.expectedReady, body["ready"])
+		})
+	}
+}
+
+func TestAPICaptureToggle(t *testing.T) {
+	s := &Server{Config: &config.Config{Server: config.DefaultServerConfig}}
+	s.Config.Server.Profiling = config.ProfilingConfig{Username: "admin", Password: "secret"}
+	mux := s.MonitoringServeMux()
+
+	r := httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("GET", "/debug/capture_api"))
+	require.Equal(t, 200, r.Result().StatusCode)
+	require.JSONEq(t, `{"enabled":false}`, r.Body.String())
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("POST", "/debug/capture_api?enabled=true"))
+	require.Equal(t, 200, r.Result().StatusCode)
+	require.JSONEq(t, `{"enabled":true}`, r.Body.String())
+	require.True(t, s.Config.APICapture().Enabled())
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("POST", "/debug/capture_api?enabled=maybe"))
+	require.Equal(t, 400, r.Result().StatusCode)
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("DELETE", "/debug/capture_api"))
+	require.Equal(t, 405, r.Result().StatusCode)
+	require.True(t, s.Config.APICapture().Enabled())
+}
+
+func TestMaintenanceEndpoint(t *testing.T) {
+	s := &Server{Config: &config.Config{Server: config.DefaultServerConfig}}
+	s.Config.Server.Profiling = config.ProfilingConfig{Username: "admin", Password: "secret"}
+	mux := s.MonitoringServeMux()
+
+	r := httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("GET", "/debug/maintenance"))
+	require.Equal(t, 200, r.Result().StatusCode)
+	require.JSONEq(t, `{"enabled":false,"message":"`+maintenance.DefaultMessage+`"}`, r.Body.String())
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("POST", "/debug/maintenance?enabled=true&message=Back+at+18:00+UTC"))
+	require.Equal(t, 200, r.Result().StatusCode)
+	require.JSONEq(t, `{"enabled":true,"message":"Back at 18:00 UTC"}`, r.Body.String())
+	require.EqualError(t, s.Config.Maintenance().CheckPush("alex-doe", "1"), "Back at 18:00 UTC")
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("POST", "/debug/maintenance?enabled=maybe"))
+	require.Equal(t, 400, r.Result().StatusCode)
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("DELETE", "/debug/maintenance"))
+	require.Equal(t, 405, r.Result().StatusCode)
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("POST", "/debug/maintenance?enabled=false"))
+	require.JSONEq(t, `{"enabled":false,"message":"`+maintenance.DefaultMessage+`"}`, r.Body.String())
+	require.NoError(t, s.Config.Maintenance().CheckPush("alex-doe", "1"))
+}
+
+func TestProfiling(t *testing.T) {
+	s := &Server{Config: &config.Config{Server: config.DefaultServerConfig}}
+
+	// Served by labkit, as before
+	require.True(t, s.Config.Server.Profiling.Enabled)
+	require.False(t, s.ServesPprof())
+	r := httptest.NewRecorder()
+	s.MonitoringServeMux().ServeHTTP(r, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	require.Equal(t, 404, r.Result().StatusCode)
+
+	s.Config.Server.WebAuth = config.WebAuthConfig{BearerToken: "token"}
+	require.True(t, s.ServesPprof())
+
+	req := httptest.NewRequest("GET", "/debug/pprof/", nil)
+	req.Header.Set("Authorization", "Bearer token")
+
+	s.Config.Server.Profiling = config.ProfilingConfig{Enabled: false}
+	require.False(t, s.ServesPprof())
+	r = httptest.NewRecorder()
+	s.MonitoringServeMux().ServeHTTP(r, req)
+	require.Equal(t, 404, r.Result().StatusCode)
+
+	s.Config.Server.Profiling = config.ProfilingConfig{Enabled: true}
+	r = httptest.NewRecorder()
+	s.MonitoringServeMux().ServeHTTP(r, req)
+	require.Equal(t, 200, r.Result().StatusCode)
+
+	s.Config.Server.WebAuth = config.WebAuthConfig{}
+	s.Config.Server.Profiling = config.ProfilingConfig{Enabled: true, Username: "admin", Password: "secret"}
+	require.True(t, s.ServesPprof())
+	mux := s.MonitoringServeMux()
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, httptest.NewRequest("GET", "/debug/pprof/cmdline", nil))
+	require.Equal(t, 401, r.Result().StatusCode)
+	require.NotEmpty(t, r.Header().Get("WWW-Authenticate"))
+
+	req = httptest.NewRequest("GET", "/debug/pprof/cmdline", nil)
+	req.SetBasicAuth("admin", "wrong")
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, req)
+	require.Equal(t, 401, r.Result().StatusCode)
+
+	req.SetBasicAuth("admin", "secret")
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, req)
+	require.Equal(t, 200, r.Result().StatusCode)
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	received := make(chan events.Type, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- events.Type(r.Header.Get(events.EventHeader))
+	}))
+	defer webhook.Close()
+
+	s, testRoot := setupServerWithConfig(t, &config.Config{Events: config.EventsConfig{WebhookURL: webhook.URL}})
+
+	client, err := ssh.Dial("tcp", serverUrl, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	holdSession(t, client)
+	require.NoError(t, client.Close())
+
+	require.Equal(t, events.SessionStarted, <-received)
+	require.Equal(t, events.SessionEnded, <-received)
+
+	cfg := clientConfig(t, testRoot)
+	cfg.User = "unknown"
+	_, err = ssh.Dial("tcp", serverUrl, cfg)
+	require.Error(t, err)
+
+	require.Equal(t, events.AuthFailed, <-received)
+
+	require.NoError(t, s.Shutdown())
+	require.Equal(t, events.ShutdownStarted, <-received)
+}
+
+func TestBackgroundTasks(t *testing.T) {
+	var tasks backgroundTasks
+	release := make(chan struct{})
+	tasks.Go(func() { <-release })
+
+	require.False(t, tasks.Wait(10*time.Millisecond))
+
+	close(release)
+	require.True(t, tasks.Wait(time.Second))
+}
+
 func TestInvalidClientConfig(t *testing.T) {
 	_, testRoot := setupServer(t)
 
@@ -351,6 +546,103 @@ func TestLoginGraceTime(t *testing.T) {
 	verifyStatus(t, s, StatusClosed)
 }
 
+func TestMaxAuthTries(t *testing.T) {
+	_, testRoot := setupServerWithConfig(t, &config.Config{Server: config.ServerConfig{MaxAuthTries: 2}})
+
+	var signers []ssh.Signer
+	for i := 0; i < 3; i++ {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		signer, err := ssh.NewSignerFromKey(key)
+		require.NoError(t, err)
+		signers = append(signers, signer)
+	}
+
+	// Keys are rejected for unknown users, so that each of them counts as a
+	// failed attempt
+	cfg := clientConfig(t, testRoot)
+	cfg.User = "unknown"
+	cfg.Auth = []ssh.AuthMethod{ssh.PublicKeys(signers...)}
+
+	_, err := ssh.Dial("tcp", serverUrl, cfg)
+	require.ErrorContains(t, err, "too many authentication failures")
+}
+
+func TestMaxStartups(t *testing.T) {
+	_, testRoot := setupServerWithConfig(t, &config.Config{Server: config.ServerConfig{MaxStartups: "1"}})
+	droppedBefore := testutil.ToFloat64(metrics.SshdDroppedStartupsTotal)
+
+	// The server sent its version once the connection holds the only slot
+	inHandshake, err := net.Dial("tcp", serverUrl)
+	require.NoError(t, err)
+	version, err := bufio.NewReader(inHandshake).ReadString('\n')
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(version, "SSH-2.0-"))
+
+	dropped, err := net.Dial("tcp", serverUrl)
+	require.NoError(t, err)
+	defer dropped.Close()
+	_, err = dropped.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+	require.InDelta(t, droppedBefore+1, testutil.ToFloat64(metrics.SshdDroppedStartupsTotal), 0.1)
+
+	// The slot is released once the handshake is over
+	inHandshake.Close()
+	require.Eventually(t, func() bool {
+		client, err := ssh.Dial("tcp", serverUrl, clientConfig(t, testRoot))
+		if err != nil {
+			return false
+		}
+
+		return client.Close() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPortForwarding(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		io.Copy(conn, conn)
+	}()
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			PortForwarding: config.PortForwardingConfig{
+				AllowedTargets: []string{listener.Addr().String()},
+				AllowedKeyIDs:  []string{"1000"},
+			},
+		},
+	}
+	_, testRoot := setupServerWithConfig(t, cfg)
+
+	client, err := ssh.Dial("tcp", serverUrl, clientConfig(t, testRoot))
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := client.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+
+	_, err = io.WriteString(conn, "ping")
+	require.NoError(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	require.NoError(t, conn.Close())
+
+	_, err = client.Dial("tcp", "127.0.0.1:1")
+	require.ErrorContains(t, err, "port forwarding is not supported")
+}
+
 func TestExtractMetaDataFromContext(t *testing.T) {
 	username := "alex-doe"
 	rootNameSpace := "flightjs"
@@ -473,3 +765,83 @@ func holdSession(t *testing.T, c *ssh.Client) {
 func verifyStatus(t *testing.T, s *Server, st status) {
 	require.Eventually(t, func() bool { return s.getStatus() == st }, 2*time.Second, time.Millisecond)
 }
+
+func TestConfigEndpoint(t *testing.T) {
+	s := &Server{Config: &config.Config{Secret: "secret", Server: config.DefaultServerConfig}}
+
+	r := httptest.NewRecorder()
+	s.MonitoringServeMux().ServeHTTP(r, adminRequest("GET", "/debug/config"))
+	require.Equal(t, 403, r.Result().StatusCode)
+
+	s.Config.Server.Profiling = config.ProfilingConfig{Username: "admin", Password: "secret"}
+	mux := s.MonitoringServeMux()
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, httptest.NewRequest("GET", "/debug/config", nil))
+	require.Equal(t, 401, r.Result().StatusCode)
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("GET", "/debug/config"))
+	require.Equal(t, 200, r.Result().StatusCode)
+	require.Equal(t, "application/json", r.Header().Get("Content-Type"))
+
+	var body struct {
+		Config  map[string]interface{} `json:"config"`
+		Sources map[string]string      `json:"sources"`
+	}
+	require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	require.Equal(t, "[REDACTED]", body.Config["secret"])
+	require.Equal(t, config.SourceDefault, body.Sources["secret"])
+}
+
+func TestAdminEndpointsWithoutCredentials(t *testing.T) {
+	s := &Server{Config: &config.Config{Server: config.DefaultServerConfig}}
+	mux := s.MonitoringServeMux()
+
+	for _, tc := range []struct{ method, target string }{
+		{method: "GET", target: "/debug/config"},
+		{method: "DELETE", target: "/debug/access_cache"},
+		{method: "POST", target: "/debug/maintenance?enabled=true"},
+		{method: "POST", target: "/debug/capture_api?enabled=true"},
+	} {
+		r := httptest.NewRecorder()
+		mux.ServeHTTP(r, httptest.NewRequest(tc.method, tc.target, nil))
+		require.Equal(t, 403, r.Result().StatusCode, tc.target)
+	}
+
+	require.NoError(t, s.Config.Maintenance().CheckPush("alex-doe", "1"))
+	require.False(t, s.Config.APICapture().Enabled())
+}
+
+func adminRequest(method, target string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.SetBasicAuth("admin", "secret")
+
+	return req
+}
+
+func TestAccessCacheEndpoint(t *testing.T) {
+	s := &Server{Config: &config.Config{
+		Server:      config.DefaultServerConfig,
+		AccessCache: config.AccessCacheConfig{TTL: config.YamlDuration(time.Minute)},
+	}}
+	s.Config.Server.Profiling = config.ProfilingConfig{Username: "admin", Password: "secret"}
+	cache := s.Config.AccessCheckCache()
+	cache.Add(accesscache.Key{Repo: "group/a", Who: "key-1"}, []byte("{}"))
+	cache.Add(accesscache.Key{Repo: "group/b", Who: "key-1"}, []byte("{}"))
+
+	mux := s.MonitoringServeMux()
+
+	r := httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("GET", "/debug/access_cache"))
+	require.Equal(t, 405, r.Result().StatusCode)
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("DELETE", "/debug/access_cache?project=group/a"))
+	require.Equal(t, 200, r.Result().StatusCode)
+	require.JSONEq(t, `{"invalidated": 1}`, r.Body.String())
+
+	r = httptest.NewRecorder()
+	mux.ServeHTTP(r, adminRequest("DELETE", "/debug/access_cache"))
+	require.JSONEq(t, `{"invalidated": 1}`, r.Body.String())
+}
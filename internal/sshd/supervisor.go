@@ -0,0 +1,204 @@
+package sshd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	// workerEnv holds the index of the worker processes started by the
+	// supervisor
+	workerEnv = "GITLAB_SSHD_WORKER"
+	// workerListenerFd is the descriptor of the listening socket the workers
+	// inherit, the first one after stdin, stdout and stderr
+	workerListenerFd = 3
+	// The restart delay of a worker doubles each time it exits, up to
+	// workerMaxRestartDelay, unless it ran for workerStableAfter
+	workerRestartDelay    = time.Second
+	workerMaxRestartDelay = time.Minute
+	workerStableAfter     = time.Minute
+	// workerStopMargin is how long after the grace period a worker that
+	// didn't shut down is killed
+	workerStopMargin = 5 * time.Second
+)
+
+// Supervisor runs the worker processes of gitlab-sshd, which serve the
+// connections of the listening socket it shares with them, and restarts the
+// ones that exit until it's stopped. Running several processes uses more
+// CPUs than one and contains the damage of a crash or of memory growth.
+type Supervisor struct {
+	Config *config.Config
+
+	// path and args are the command of the workers
+	path         string
+	args         []string
+	restartDelay time.Duration
+}
+
+// NewSupervisor returns a supervisor of workers running the executable of
+// the process with args
+func NewSupervisor(cfg *config.Config, args []string) (*Supervisor, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the executable of the workers: %w", err)
+	}
+
+	return &Supervisor{Config: cfg, path: path, args: args, restartDelay: workerRestartDelay}, nil
+}
+
+// WorkerIndex returns the index of the worker the process is, if it was
+// started by a supervisor
+func WorkerIndex() (int, bool) {
+	value, ok := os.LookupEnv(workerEnv)
+	if !ok {
+		return 0, false
+	}
+
+	index, err := strconv.Atoi(value)
+
+	return index, err == nil
+}
+
+// ConfigureWorker adapts cfg to the worker of index, whose monitoring
+// endpoint listens on the port of web_listen + index and whose control socket
+// is suffixed with .index for the workers not to compete for them
+func ConfigureWorker(cfg *config.Config, index int) error {
+	if index == 0 {
+		return nil
+	}
+
+	if cfg.Server.ControlSocket.Path != "" {
+		cfg.Server.ControlSocket.Path += "." + strconv.Itoa(index)
+	}
+
+	if cfg.Server.WebListen == "" {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(cfg.Server.WebListen)
+	if err != nil {
+		return fmt.Errorf("invalid sshd web_listen: %w", err)
+	}
+
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("invalid sshd web_listen port %q", port)
+	}
+	if portNumber == 0 {
+		return nil
+	}
+
+	cfg.Server.WebListen = net.JoinHostPort(host, strconv.Itoa(portNumber+index))
+
+	return nil
+}
+
+// Run listens and runs the workers until ctx is done, then shuts them down
+// gracefully
+func (s *Supervisor) Run(ctx context.Context) error {
+	listener, err := bindTCP(ctx, s.Config.Server.Socket, s.Config.Server.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for connection: %w", err)
+	}
+
+	// The socket stays open through its duplicate passed to the workers
+	file, err := listener.File()
+	listener.Close()
+	if err != nil {
+		return fmt.Errorf("failed to share the listener: %w", err)
+	}
+	defer file.Close()
+
+	log.WithContextFields(ctx, log.Fields{
+		"tcp_address": listener.Addr().String(),
+		"workers":     s.Config.Server.Workers,
+	}).Info("Listening for SSH connections with workers")
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.Config.Server.Workers; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+
+			s.superviseWorker(ctx, index, file)
+		}(i)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func (s *Supervisor) superviseWorker(ctx context.Context, index int, listener *os.File) {
+	ctxlog := log.WithContextFields(ctx, log.Fields{"worker": index})
+	delay := s.restartDelay
+
+	for {
+		started := time.Now()
+		err := s.runWorker(ctx, index, listener)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) >= workerStableAfter {
+			delay = s.restartDelay
+		}
+
+		ctxlog.WithError(err).WithField("restart_delay_s", delay.Seconds()).Warn("sshd worker exited, restarting it")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > workerMaxRestartDelay {
+			delay = workerMaxRestartDelay
+		}
+	}
+}
+
+func (s *Supervisor) runWorker(ctx context.Context, index int, listener *os.File) error {
+	cmd := exec.CommandContext(ctx, s.path, s.args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", workerEnv, index))
+	cmd.ExtraFiles = []*os.File{listener}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// The workers are shut down like gitlab-sshd, and killed if they're
+	// still running after the grace period
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = time.Duration(s.Config.Server.GracePeriod) + workerStopMargin
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	return cmd.Wait()
+}
+
+// workerListener returns the listening socket a worker inherited from the
+// supervisor
+func workerListener(cfg config.SocketConfig) (net.Listener, error) {
+	file := os.NewFile(workerListenerFd, "sshd-listener")
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the listener of the supervisor: %w", err)
+	}
+
+	return tuneListener(cfg, listener), nil
+}
@@ -0,0 +1,117 @@
+package sshd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// TestSupervisorWorker is the worker of TestSupervisor: it answers a single
+// connection with its index and pid, then exits
+func TestSupervisorWorker(t *testing.T) {
+	index, ok := WorkerIndex()
+	if !ok {
+		t.Skip("only run as a worker of TestSupervisor")
+	}
+
+	listener, err := workerListener(config.SocketConfig{})
+	require.NoError(t, err)
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+
+	fmt.Fprintf(conn, "%d %d\n", index, os.Getpid())
+	conn.Close()
+
+	os.Exit(0)
+}
+
+func TestSupervisor(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := l.Addr().String()
+	l.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.Listen = address
+	cfg.Server.Workers = 1
+
+	supervisor := &Supervisor{
+		Config:       cfg,
+		path:         os.Args[0],
+		args:         []string{"-test.run=^TestSupervisorWorker$"},
+		restartDelay: 10 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- supervisor.Run(ctx) }()
+
+	answer := func() string {
+		var conn net.Conn
+		require.Eventually(t, func() bool {
+			conn, err = net.Dial("tcp", address)
+			return err == nil
+		}, 10*time.Second, 10*time.Millisecond)
+		defer conn.Close()
+
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+
+		return line
+	}
+
+	// The worker exits after each connection and is restarted
+	first := answer()
+	require.Regexp(t, `^0 \d+\n$`, first)
+	second := answer()
+	require.Regexp(t, `^0 \d+\n$`, second)
+	require.NotEqual(t, first, second)
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the supervisor didn't stop its workers")
+	}
+}
+
+func TestConfigureWorker(t *testing.T) {
+	testCases := []struct {
+		webListen string
+		index     int
+		expected  string
+	}{
+		{webListen: "", index: 1, expected: ""},
+		{webListen: "localhost:9122", index: 0, expected: "localhost:9122"},
+		{webListen: "localhost:9122", index: 3, expected: "localhost:9125"},
+		{webListen: "[::1]:9122", index: 1, expected: "[::1]:9123"},
+		{webListen: ":0", index: 2, expected: ":0"},
+	}
+
+	for _, tc := range testCases {
+		cfg := &config.Config{}
+		cfg.Server.WebListen = tc.webListen
+
+		require.NoError(t, ConfigureWorker(cfg, tc.index))
+		require.Equal(t, tc.expected, cfg.Server.WebListen)
+	}
+
+	cfg := &config.Config{}
+	cfg.Server.WebListen = "localhost"
+	require.ErrorContains(t, ConfigureWorker(cfg, 1), "invalid sshd web_listen")
+
+	cfg = &config.Config{}
+	cfg.Server.ControlSocket.Path = "/run/gitlab-shell/control.sock"
+	require.NoError(t, ConfigureWorker(cfg, 2))
+	require.Equal(t, "/run/gitlab-shell/control.sock.2", cfg.Server.ControlSocket.Path)
+}
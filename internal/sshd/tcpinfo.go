@@ -0,0 +1,61 @@
+package sshd
+
+import (
+	"net"
+	"time"
+
+	proxyproto "github.com/pires/go-proxyproto"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// tcpInfo is the state of the TCP connection of a session as seen by the
+// kernel, telling network problems apart from server problems when clients
+// are slow
+type tcpInfo struct {
+	RTT         time.Duration
+	RTTVar      time.Duration
+	MinRTT      time.Duration
+	Retransmits uint32
+	SegmentsOut uint32
+}
+
+// connTCPInfo returns the TCP info of nconn, or nil when it isn't a TCP
+// connection or the platform doesn't support it
+func connTCPInfo(nconn net.Conn) (*tcpInfo, error) {
+	if proxied, ok := nconn.(*proxyproto.Conn); ok {
+		nconn = proxied.Raw()
+	}
+
+	tcpConn, ok := nconn.(*net.TCPConn)
+	if !ok {
+		return nil, nil
+	}
+
+	return readTCPInfo(tcpConn)
+}
+
+func (i *tcpInfo) logFields() log.Fields {
+	if i == nil {
+		return log.Fields{}
+	}
+
+	return log.Fields{
+		"tcp_rtt_s":        i.RTT.Seconds(),
+		"tcp_rtt_var_s":    i.RTTVar.Seconds(),
+		"tcp_min_rtt_s":    i.MinRTT.Seconds(),
+		"tcp_retransmits":  i.Retransmits,
+		"tcp_segments_out": i.SegmentsOut,
+	}
+}
+
+func (i *tcpInfo) observe() {
+	if i == nil {
+		return
+	}
+
+	metrics.SshdTCPRTT.Observe(i.RTT.Seconds())
+	metrics.SshdTCPRetransmits.Observe(float64(i.Retransmits))
+}
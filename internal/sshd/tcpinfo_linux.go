@@ -0,0 +1,36 @@
+package sshd
+
+import (
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func readTCPInfo(conn *net.TCPConn) (*tcpInfo, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var info *unix.TCPInfo
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+
+	// The kernel reports the round-trip times in microseconds
+	return &tcpInfo{
+		RTT:         time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:      time.Duration(info.Rttvar) * time.Microsecond,
+		MinRTT:      time.Duration(info.Min_rtt) * time.Microsecond,
+		Retransmits: info.Total_retrans,
+		SegmentsOut: info.Segs_out,
+	}, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package sshd
+
+import "net"
+
+func readTCPInfo(conn *net.TCPConn) (*tcpInfo, error) {
+	return nil, nil
+}
@@ -0,0 +1,44 @@
+package sshd
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnTCPInfo(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	info, err := connTCPInfo(server)
+	require.NoError(t, err)
+	require.Nil(t, info)
+	require.Empty(t, info.logFields())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err == nil {
+			defer conn.Close()
+			conn.Write([]byte("data"))
+		}
+	}()
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	info, err = connTCPInfo(conn)
+	require.NoError(t, err)
+	if info == nil {
+		t.Skip("TCP info is not supported on this platform")
+	}
+
+	require.Contains(t, info.logFields(), "tcp_rtt_s")
+	require.Contains(t, info.logFields(), "tcp_retransmits")
+}
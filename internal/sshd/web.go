@@ -0,0 +1,105 @@
+package sshd
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+)
+
+// MonitoringListener listens on WebListen, over TLS when configured
+func MonitoringListener(cfg *config.Config) (net.Listener, error) {
+	webTLS := cfg.Server.WebTLS
+	if webTLS.CertFile == "" {
+		return net.Listen("tcp", cfg.Server.WebListen)
+	}
+
+	tlsConfig, err := webTLSConfig(webTLS)
+	if err != nil {
+		return nil, fmt.Errorf("web_tls: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", cfg.Server.WebListen)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.NewListener(listener, tlsConfig), nil
+}
+
+func webTLSConfig(webTLS config.WebTLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(webTLS.CertFile, webTLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if webTLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(webTLS.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("client_ca_file holds no certificate")
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// webAuth requires the configured basic auth credentials or bearer token,
+// if any, for all the requests to the monitoring endpoints. The probes are
+// served without credentials when exempted.
+func (s *Server) webAuth(next *http.ServeMux) *http.ServeMux {
+	webAuth := s.Config.Server.WebAuth
+	if webAuth.Username == "" && webAuth.Password == "" && webAuth.BearerToken == "" {
+		return next
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !webAuthorized(webAuth, r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gitlab-sshd"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+
+	if webAuth.ExemptProbes {
+		mux.Handle(s.Config.Server.ReadinessProbe, next)
+		mux.Handle(s.Config.Server.LivenessProbe, next)
+	}
+
+	return mux
+}
+
+func webAuthorized(webAuth config.WebAuthConfig, r *http.Request) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return webAuth.BearerToken != "" &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(webAuth.BearerToken)) == 1
+	}
+
+	username, password, ok := r.BasicAuth()
+
+	return ok && webAuth.Username != "" &&
+		subtle.ConstantTimeCompare([]byte(username), []byte(webAuth.Username)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(webAuth.Password)) == 1
+}
@@ -0,0 +1,105 @@
+package sshd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
+)
+
+func TestWebAuth(t *testing.T) {
+	cfg := &config.Config{Server: config.DefaultServerConfig}
+	cfg.Server.WebAuth = config.WebAuthConfig{Username: "prometheus", Password: "secret", BearerToken: "token"}
+
+	s := &Server{Config: cfg}
+	s.changeStatus(StatusReady)
+
+	for _, tc := range []struct {
+		desc          string
+		path          string
+		exemptProbes  bool
+		authorization func(*http.Request)
+		expectedCode  int
+	}{
+		{desc: "no credentials", path: "/metrics", expectedCode: 401},
+		{
+			desc:          "wrong password",
+			path:          "/metrics",
+			authorization: func(r *http.Request) { r.SetBasicAuth("prometheus", "wrong") },
+			expectedCode:  401,
+		},
+		{
+			desc:          "wrong token",
+			path:          "/metrics",
+			authorization: func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") },
+			expectedCode:  401,
+		},
+		{
+			desc:          "basic auth",
+			path:          "/metrics",
+			authorization: func(r *http.Request) { r.SetBasicAuth("prometheus", "secret") },
+			expectedCode:  200,
+		},
+		{
+			desc:          "bearer token",
+			path:          "/metrics",
+			authorization: func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") },
+			expectedCode:  200,
+		},
+		{desc: "probe", path: "/start", expectedCode: 401},
+		{desc: "exempt probe", path: "/start", exemptProbes: true, expectedCode: 200},
+		{desc: "metrics with exempt probes", path: "/metrics", exemptProbes: true, expectedCode: 401},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			cfg.Server.WebAuth.ExemptProbes = tc.exemptProbes
+
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.authorization != nil {
+				tc.authorization(req)
+			}
+
+			r := httptest.NewRecorder()
+			s.MonitoringServeMux().ServeHTTP(r, req)
+			require.Equal(t, tc.expectedCode, r.Result().StatusCode)
+		})
+	}
+}
+
+func TestMonitoringListenerTLS(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+	certFile := path.Join(testRoot, "certs/valid/server.crt")
+
+	cfg := &config.Config{Server: config.DefaultServerConfig}
+	cfg.Server.WebListen = "127.0.0.1:0"
+	cfg.Server.WebTLS = config.WebTLSConfig{CertFile: certFile, KeyFile: path.Join(testRoot, "certs/valid/server.key")}
+
+	listener, err := MonitoringListener(cfg)
+	require.NoError(t, err)
+
+	server := &http.Server{Handler: (&Server{Config: cfg}).MonitoringServeMux()}
+	go server.Serve(listener)
+	defer server.Close()
+
+	pem, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(pem))
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	response, err := client.Get("https://" + listener.Addr().String() + "/health")
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, 200, response.StatusCode)
+
+	cfg.Server.WebTLS.KeyFile = path.Join(testRoot, "certs/valid/missing.key")
+	_, err = MonitoringListener(cfg)
+	require.ErrorContains(t, err, "web_tls: open")
+}
@@ -1,7 +1,10 @@
 package sshenv
 
 import (
+	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -12,14 +15,22 @@ const (
 	SSHConnectionEnv = "SSH_CONNECTION"
 	// SSHOriginalCommandEnv defines the ENV containing the original SSH command
 	SSHOriginalCommandEnv = "SSH_ORIGINAL_COMMAND"
+	// PreauthTokenEnv defines the ENV holding a token pre-authorizing a fetch
+	PreauthTokenEnv = "GITLAB_PREAUTH_TOKEN"
 )
 
+var protocolKeyRegexp = regexp.MustCompile(`\A[A-Za-z0-9.-]+\z`)
+
 type Env struct {
 	GitProtocolVersion string
 	IsSSHConnection    bool
 	OriginalCommand    string
 	RemoteAddr         string
 	NamespacePath      string
+	PreauthToken       string
+	// Vars are the other variables the client sent, allowed by the sshd
+	// allowed_env
+	Vars map[string]string
 }
 
 func NewFromEnv() Env {
@@ -33,7 +44,48 @@ func NewFromEnv() Env {
 		IsSSHConnection:    isSSHConnection,
 		RemoteAddr:         remoteAddrFromEnv(),
 		OriginalCommand:    os.Getenv(SSHOriginalCommandEnv),
+		PreauthToken:       os.Getenv(PreauthTokenEnv),
+	}
+}
+
+// Redacted returns the env without its secrets, to be logged
+func (e Env) Redacted() Env {
+	if e.PreauthToken != "" {
+		e.PreauthToken = "[REDACTED]"
+	}
+
+	return e
+}
+
+// ProtocolVersion returns the version of the Git protocol requested by a
+// GIT_PROTOCOL value, a colon-separated list of key[=value] parameters. It's
+// 0 when none is requested.
+func ProtocolVersion(value string) (int, error) {
+	version := 0
+	if value == "" {
+		return version, nil
+	}
+
+	for _, param := range strings.Split(value, ":") {
+		key, val, _ := strings.Cut(param, "=")
+		if !protocolKeyRegexp.MatchString(key) {
+			return 0, fmt.Errorf("invalid %s parameter %q", GitProtocolEnv, param)
+		}
+		if key != "version" {
+			continue
+		}
+
+		v, err := strconv.Atoi(val)
+		if err != nil || v < 0 || v > 2 {
+			return 0, fmt.Errorf("unknown %s version %q", GitProtocolEnv, val)
+		}
+		// Like Git, the highest version requested wins
+		if v > version {
+			version = v
+		}
 	}
+
+	return version, nil
 }
 
 // remoteAddrFromEnv returns the connection address from ENV string
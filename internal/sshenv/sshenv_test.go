@@ -28,6 +28,11 @@ func TestNewFromEnv(t *testing.T) {
 			environment: map[string]string{SSHOriginalCommandEnv: "git-receive-pack"},
 			want:        Env{OriginalCommand: "git-receive-pack"},
 		},
+		{
+			desc:        "It parses GITLAB_PREAUTH_TOKEN",
+			environment: map[string]string{PreauthTokenEnv: "token"},
+			want:        Env{PreauthToken: "token"},
+		},
 	}
 
 	for _, tc := range tests {
@@ -40,6 +45,45 @@ func TestNewFromEnv(t *testing.T) {
 	}
 }
 
+func TestRedacted(t *testing.T) {
+	env := Env{OriginalCommand: "git-upload-pack", PreauthToken: "token"}
+
+	require.Equal(t, Env{OriginalCommand: "git-upload-pack", PreauthToken: "[REDACTED]"}, env.Redacted())
+	require.Equal(t, "token", env.PreauthToken)
+	require.Equal(t, Env{}, Env{}.Redacted())
+}
+
+func TestProtocolVersion(t *testing.T) {
+	testCases := []struct {
+		value           string
+		expectedVersion int
+		expectedErr     string
+	}{
+		{value: "", expectedVersion: 0},
+		{value: "version=1", expectedVersion: 1},
+		{value: "version=2", expectedVersion: 2},
+		{value: "object-format=sha256:version=2", expectedVersion: 2},
+		{value: "version=2:version=1", expectedVersion: 2},
+		{value: "version=3", expectedErr: `unknown GIT_PROTOCOL version "3"`},
+		{value: "version=two", expectedErr: `unknown GIT_PROTOCOL version "two"`},
+		{value: "version=2:", expectedErr: `invalid GIT_PROTOCOL parameter ""`},
+		{value: "version 2", expectedErr: `invalid GIT_PROTOCOL parameter "version 2"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			version, err := ProtocolVersion(tc.value)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedVersion, version)
+		})
+	}
+}
+
 func TestRemoteAddrFromEnv(t *testing.T) {
 	cleanup, err := testhelper.Setenv(SSHConnectionEnv, "127.0.0.1 0")
 	require.NoError(t, err)
@@ -0,0 +1,116 @@
+// Package usermessage lets admins override the text of the main messages
+// shown to users, e.g. to brand them or to point to a support policy, with
+// the messages section of the config. The overrides are text/template
+// templates executed with Data, and take precedence over the translations.
+package usermessage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gitlab.com/gitlab-org/labkit/log"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/logcontext"
+)
+
+// ID identifies a message that can be overridden
+type ID string
+
+const (
+	// Welcome greets the users running the discover command
+	Welcome ID = "welcome"
+	// AccessDenied is shown when GitLab denies access to a repository,
+	// Message holding the reason given by GitLab
+	AccessDenied ID = "access_denied"
+	// OTPPrompt asks for the one-time password of the two-factor
+	// authentication
+	OTPPrompt ID = "otp_prompt"
+	// OTPSuccess is shown once the one-time password is validated
+	OTPSuccess ID = "otp_success"
+	// OTPPushSuccess is shown once a push notification was approved
+	OTPPushSuccess ID = "otp_push_success"
+	// OTPFailed is shown when the one-time password was rejected, Error
+	// holding the reason
+	OTPFailed ID = "otp_failed"
+)
+
+var ids = []ID{Welcome, AccessDenied, OTPPrompt, OTPSuccess, OTPPushSuccess, OTPFailed}
+
+// Data are the variables of the templates
+type Data struct {
+	// Username is the username of the user, empty when unknown or anonymous
+	Username string
+	// Message is the message of GitLab
+	Message string
+	// Error is the reason of the failure
+	Error string
+}
+
+// Validate returns an error when overrides has messages that don't exist or
+// invalid templates
+func Validate(overrides map[string]string) error {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !known(ID(key)) {
+			names := make([]string, 0, len(ids))
+			for _, id := range ids {
+				names = append(names, string(id))
+			}
+
+			return fmt.Errorf("unknown message %q, supported: %s", key, strings.Join(names, ", "))
+		}
+
+		tmpl, err := parse(key, overrides[key])
+		if err != nil {
+			return fmt.Errorf("message %s: %w", key, err)
+		}
+		if err := tmpl.Execute(&strings.Builder{}, Data{}); err != nil {
+			return fmt.Errorf("message %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// Render returns the override of the message id executed with data, or
+// message when it isn't overridden or its template fails
+func Render(ctx context.Context, overrides map[string]string, id ID, data Data, message string) string {
+	text, ok := overrides[string(id)]
+	if !ok {
+		return message
+	}
+
+	var out strings.Builder
+	tmpl, err := parse(string(id), text)
+	if err == nil {
+		err = tmpl.Execute(&out, data)
+	}
+	if err != nil {
+		logcontext.WithContextFields(ctx, log.Fields{"message": id}).WithError(err).Warn("usermessage: failed to render the message override")
+		return message
+	}
+
+	return out.String()
+}
+
+func known(id ID) bool {
+	for _, known := range ids {
+		if id == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parse(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
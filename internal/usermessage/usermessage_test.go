@@ -0,0 +1,32 @@
+package usermessage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Validate(nil))
+	require.NoError(t, Validate(map[string]string{
+		"welcome":       "Welcome to ACME Git, {{if .Username}}@{{.Username}}{{else}}stranger{{end}}!",
+		"access_denied": "{{.Message}}\nRequest access at https://it.example.com.",
+	}))
+
+	require.EqualError(t, Validate(map[string]string{"goodbye": "Bye"}),
+		`unknown message "goodbye", supported: welcome, access_denied, otp_prompt, otp_success, otp_push_success, otp_failed`)
+	require.ErrorContains(t, Validate(map[string]string{"welcome": "Welcome {{.Username"}), "message welcome: template: welcome:1: unclosed action")
+	require.ErrorContains(t, Validate(map[string]string{"welcome": "Welcome {{.Name}}"}), "can't evaluate field Name")
+}
+
+func TestRender(t *testing.T) {
+	overrides := map[string]string{
+		"welcome":    "Welcome to ACME Git, @{{.Username}}!",
+		"otp_failed": "{{.Missing}}",
+	}
+
+	require.Equal(t, "Welcome to ACME Git, @alex!", Render(context.Background(), overrides, Welcome, Data{Username: "alex"}, "Welcome to GitLab, @alex!"))
+	require.Equal(t, "Access denied", Render(context.Background(), overrides, AccessDenied, Data{Message: "Access denied"}, "Access denied"))
+	require.Equal(t, "OTP validation failed", Render(context.Background(), overrides, OTPFailed, Data{}, "OTP validation failed"))
+}
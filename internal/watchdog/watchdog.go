@@ -0,0 +1,189 @@
+// Package watchdog monitors the resources used by gitlab-sshd, so that slow
+// leaks are diagnosed and optionally recovered from by restarting, instead of
+// requiring manual intervention.
+package watchdog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+
+	// breachesBeforeDrain is the number of consecutive checks exceeding a
+	// threshold that trigger a drain, so that short spikes don't
+	breachesBeforeDrain = 3
+
+	// maxDumpSize bounds the goroutine dump written to the log
+	maxDumpSize = 64 * 1024
+)
+
+// Watchdog periodically compares the number of goroutines, open files and the
+// resident memory of the process against the configured thresholds
+type Watchdog struct {
+	cfg      config.WatchdogConfig
+	interval time.Duration
+	drain    func(reason string)
+
+	breaches int
+	dumped   bool
+
+	// Samplers, overridden in tests
+	goroutines func() int
+	openFiles  func() (int, error)
+	rss        func() (int64, error)
+}
+
+// New returns a Watchdog calling drain when thresholds are exceeded and
+// draining is enabled, or nil if the watchdog isn't enabled
+func New(cfg config.WatchdogConfig, drain func(reason string)) *Watchdog {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	interval := time.Duration(cfg.Interval)
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	return &Watchdog{
+		cfg:        cfg,
+		interval:   interval,
+		drain:      drain,
+		goroutines: runtime.NumGoroutine,
+		openFiles:  openFiles,
+		rss:        rss,
+	}
+}
+
+// Run checks the resources until ctx is done or a drain is triggered
+func (w *Watchdog) Run(ctx context.Context) {
+	if w == nil {
+		return
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if w.check(ctx) {
+				return
+			}
+		}
+	}
+}
+
+// check returns true when a drain has been triggered
+func (w *Watchdog) check(ctx context.Context) bool {
+	fields := log.Fields{}
+	var exceeded []string
+
+	goroutines := w.goroutines()
+	fields["goroutines"] = goroutines
+	if w.cfg.MaxGoroutines > 0 && goroutines > w.cfg.MaxGoroutines {
+		exceeded = append(exceeded, "goroutines")
+	}
+
+	if files, err := w.openFiles(); err == nil {
+		fields["open_files"] = files
+		if w.cfg.MaxOpenFiles > 0 && files > w.cfg.MaxOpenFiles {
+			exceeded = append(exceeded, "open_files")
+		}
+	}
+
+	if rss, err := w.rss(); err == nil {
+		fields["rss_bytes"] = rss
+		if w.cfg.MaxRSS > 0 && rss > w.cfg.MaxRSS*1024*1024 {
+			exceeded = append(exceeded, "rss")
+		}
+	}
+
+	if len(exceeded) == 0 {
+		w.breaches = 0
+		w.dumped = false
+		return false
+	}
+
+	w.breaches++
+	for _, resource := range exceeded {
+		metrics.SshdWatchdogBreachesTotal.WithLabelValues(resource).Inc()
+	}
+
+	fields["exceeded"] = exceeded
+	fields["consecutive_breaches"] = w.breaches
+
+	// A single dump per streak of breaches is enough to diagnose the leak
+	if !w.dumped {
+		fields["goroutine_dump"] = goroutineDump()
+		w.dumped = true
+	}
+
+	log.WithContextFields(ctx, fields).Warn("watchdog: resource thresholds exceeded")
+
+	if !w.cfg.Drain || w.breaches < breachesBeforeDrain {
+		return false
+	}
+
+	w.drain(fmt.Sprintf("watchdog: %s exceeded", strings.Join(exceeded, ", ")))
+
+	return true
+}
+
+func goroutineDump() string {
+	var buf bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buf, 1)
+
+	if buf.Len() > maxDumpSize {
+		buf.Truncate(maxDumpSize)
+		buf.WriteString("\n[truncated]")
+	}
+
+	return buf.String()
+}
+
+// openFiles counts the file descriptors of the process, only on Linux
+func openFiles() (int, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+
+	return len(entries), nil
+}
+
+// rss returns the resident memory of the process in bytes, only on Linux
+func rss() (int64, error) {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected /proc/self/statm format")
+	}
+
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return pages * int64(os.Getpagesize()), nil
+}
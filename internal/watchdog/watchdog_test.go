@@ -0,0 +1,89 @@
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/metrics"
+)
+
+func newWatchdog(cfg config.WatchdogConfig, goroutines *int) (*Watchdog, *[]string) {
+	var drains []string
+
+	cfg.Enabled = true
+	w := New(cfg, func(reason string) { drains = append(drains, reason) })
+	w.goroutines = func() int { return *goroutines }
+	w.openFiles = func() (int, error) { return 10, nil }
+	w.rss = func() (int64, error) { return 0, errors.New("not supported") }
+
+	return w, &drains
+}
+
+func TestCheck(t *testing.T) {
+	goroutines := 50
+	w, drains := newWatchdog(config.WatchdogConfig{MaxGoroutines: 100, MaxOpenFiles: 100, Drain: true}, &goroutines)
+
+	breaches := metrics.SshdWatchdogBreachesTotal.WithLabelValues("goroutines")
+	initialBreaches := testutil.ToFloat64(breaches)
+
+	require.False(t, w.check(context.Background()))
+
+	goroutines = 150
+	require.False(t, w.check(context.Background()))
+	require.True(t, w.dumped)
+	require.False(t, w.check(context.Background()))
+
+	// The streak of breaches is reset once the resources are back to normal
+	goroutines = 50
+	require.False(t, w.check(context.Background()))
+	require.False(t, w.dumped)
+
+	goroutines = 150
+	for i := 1; i < breachesBeforeDrain; i++ {
+		require.False(t, w.check(context.Background()))
+	}
+	require.Empty(t, *drains)
+
+	require.True(t, w.check(context.Background()))
+	require.Equal(t, []string{"watchdog: goroutines exceeded"}, *drains)
+	require.InDelta(t, initialBreaches+2+breachesBeforeDrain, testutil.ToFloat64(breaches), 0.1)
+}
+
+func TestCheckWithoutDrain(t *testing.T) {
+	goroutines := 150
+	w, drains := newWatchdog(config.WatchdogConfig{MaxGoroutines: 100}, &goroutines)
+
+	for i := 0; i < 2*breachesBeforeDrain; i++ {
+		require.False(t, w.check(context.Background()))
+	}
+	require.Empty(t, *drains)
+}
+
+func TestDisabled(t *testing.T) {
+	w := New(config.WatchdogConfig{}, nil)
+
+	require.Nil(t, w)
+	w.Run(context.Background())
+}
+
+func TestSamplers(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only supported on Linux")
+	}
+
+	files, err := openFiles()
+	require.NoError(t, err)
+	require.Positive(t, files)
+
+	rss, err := rss()
+	require.NoError(t, err)
+	require.Positive(t, rss)
+
+	require.Contains(t, goroutineDump(), "goroutine profile")
+}
@@ -0,0 +1,114 @@
+// Package sshcmd parses the commands sent to GitLab over SSH, such as the
+// SSH_ORIGINAL_COMMAND of OpenSSH, the way gitlab-shell does. Tools fronting
+// gitlab-shell, e.g. bastions, can use it to see the same command that it
+// will run.
+//
+// Commands are split into words with the quoting rules of a POSIX shell:
+// single quotes, double quotes and backslashes are supported, while
+// variables, command substitutions and globs are kept as literal text. The
+// words following an unquoted shell operator, such as ; or |, are ignored.
+// The commands holding newlines or NUL bytes, which could be run as several
+// commands or truncated, are rejected.
+package sshcmd
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mattn/go-shellwords"
+)
+
+var (
+	// ErrNewline is returned for commands holding a line feed or a carriage
+	// return, which shells would run as several commands
+	ErrNewline = errors.New("newlines aren't allowed in commands")
+	// ErrNUL is returned for commands holding a NUL byte, which would
+	// truncate them when passed to a process
+	ErrNUL = errors.New("NUL bytes aren't allowed in commands")
+	// ErrEmptyName is returned for commands whose name is an empty word,
+	// e.g. ''
+	ErrEmptyName = errors.New("the command name is empty")
+)
+
+// Command is a command sent over SSH
+type Command struct {
+	// Name is the name of the command, e.g. git-upload-pack. It's empty when
+	// no command was sent, which GitLab answers with a welcome message.
+	Name string
+	// Args are the arguments of the command, e.g. the path of the repository
+	Args []string
+}
+
+// Parse parses command. The commands sent by Git for Windows 2.14, e.g. git
+// upload-pack, are parsed as the command Git sends otherwise, e.g.
+// git-upload-pack.
+func Parse(command string) (Command, error) {
+	if strings.ContainsAny(command, "\n\r") {
+		return Command{}, ErrNewline
+	}
+	if strings.ContainsRune(command, 0) {
+		return Command{}, ErrNUL
+	}
+
+	// The parser stops at the first unquoted operator
+	words, err := shellwords.Parse(command)
+	if err != nil {
+		return Command{}, err
+	}
+
+	if len(words) == 0 {
+		return Command{}, nil
+	}
+	if words[0] == "" {
+		return Command{}, ErrEmptyName
+	}
+
+	if len(words) > 1 && words[0] == "git" {
+		words = append([]string{words[0] + "-" + words[1]}, words[2:]...)
+	}
+
+	return Command{Name: words[0], Args: words[1:]}, nil
+}
+
+// Argv returns the name of the command followed by its arguments, or no
+// words when no command was sent
+func (c Command) Argv() []string {
+	if c.Name == "" {
+		return []string{}
+	}
+
+	return append([]string{c.Name}, c.Args...)
+}
+
+// String returns the command quoted for Parse to parse it back
+func (c Command) String() string {
+	return Quote(c.Argv()...)
+}
+
+// Quote returns words quoted for Parse to parse them back, e.g. to build the
+// command to send over SSH
+func Quote(words ...string) string {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		quoted = append(quoted, quoteWord(word))
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+func quoteWord(word string) string {
+	if word != "" && strings.IndexFunc(word, needsQuoting) < 0 {
+		return word
+	}
+
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+func needsQuoting(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+
+	return !strings.ContainsRune("-_./:@%+=,", r)
+}
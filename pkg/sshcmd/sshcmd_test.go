@@ -0,0 +1,105 @@
+package sshcmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		command  string
+		expected Command
+	}{
+		{desc: "no command", command: "", expected: Command{}},
+		{desc: "blank command", command: "  \t ", expected: Command{}},
+		{desc: "git command", command: "git-upload-pack 'group/project.git'", expected: Command{Name: "git-upload-pack", Args: []string{"group/project.git"}}},
+		{desc: "Git for Windows 2.14", command: "git upload-pack 'group/project.git'", expected: Command{Name: "git-upload-pack", Args: []string{"group/project.git"}}},
+		{desc: "without arguments", command: "whoami", expected: Command{Name: "whoami", Args: []string{}}},
+		{desc: "double quotes", command: `git-receive-pack "group/my \"project\".git"`, expected: Command{Name: "git-receive-pack", Args: []string{`group/my "project".git`}}},
+		{desc: "escaped single quote", command: `personal_access_token 'it'\''s' api`, expected: Command{Name: "personal_access_token", Args: []string{"it's", "api"}}},
+		{desc: "escaped space", command: `git-upload-pack group/my\ project.git`, expected: Command{Name: "git-upload-pack", Args: []string{"group/my project.git"}}},
+		{desc: "empty argument", command: "personal_access_token '' api", expected: Command{Name: "personal_access_token", Args: []string{"", "api"}}},
+		{desc: "literal substitutions", command: "git-upload-pack '$(id)' `id` $HOME *", expected: Command{Name: "git-upload-pack", Args: []string{"$(id)", "`id`", "$HOME", "*"}}},
+		{desc: "quoted operators", command: "git-upload-pack 'a;b' \"c|d\" e\\&f", expected: Command{Name: "git-upload-pack", Args: []string{"a;b", "c|d", "e&f"}}},
+		{desc: "unquoted operator", command: "git-upload-pack 'group/project.git'; rm -rf /", expected: Command{Name: "git-upload-pack", Args: []string{"group/project.git"}}},
+		{desc: "pipe", command: "whoami | cat", expected: Command{Name: "whoami", Args: []string{}}},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			command, err := Parse(tc.command)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, command)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		command  string
+		expected error
+	}{
+		{desc: "newline", command: "git-upload-pack 'group/project.git'\nrm -rf /", expected: ErrNewline},
+		{desc: "quoted newline", command: "git-upload-pack 'group/\nproject.git'", expected: ErrNewline},
+		{desc: "carriage return", command: "whoami\r", expected: ErrNewline},
+		{desc: "NUL", command: "git-upload-pack group/project.git\x00", expected: ErrNUL},
+		{desc: "empty name", command: "'' whoami", expected: ErrEmptyName},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := Parse(tc.command)
+			require.ErrorIs(t, err, tc.expected)
+		})
+	}
+
+	_, err := Parse("git-upload-pack 'group/project.git")
+	require.EqualError(t, err, "invalid command line string")
+
+	_, err = Parse(`whoami \`)
+	require.EqualError(t, err, "invalid command line string")
+}
+
+func TestArgv(t *testing.T) {
+	require.Equal(t, []string{}, Command{}.Argv())
+	require.Equal(t, []string{"git-upload-pack", "group/project.git"}, Command{Name: "git-upload-pack", Args: []string{"group/project.git"}}.Argv())
+}
+
+func TestQuote(t *testing.T) {
+	require.Equal(t, "git-upload-pack group/project.git", Quote("git-upload-pack", "group/project.git"))
+	require.Equal(t, `personal_access_token 'it'\''s' '' 'a b' '$(id)'`, Quote("personal_access_token", "it's", "", "a b", "$(id)"))
+	require.Equal(t, "", Quote())
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"git-upload-pack 'group/project.git'",
+		"git upload-pack 'group/project.git'",
+		`git-receive-pack "group/my \"project\".git"`,
+		`personal_access_token 'it'\''s' '' api 30`,
+		"git-upload-pack 'a;b' \"c|d\" e\\&f",
+		"git-upload-pack '$(id)' `id` $HOME",
+		"whoami; id",
+		"whoami\nid",
+		"'unterminated",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		command, err := Parse(input)
+		if err != nil {
+			return
+		}
+
+		for _, word := range command.Argv() {
+			require.NotContains(t, word, "\n")
+			require.NotContains(t, word, "\x00")
+		}
+
+		// Commands quoted back are parsed as the same command
+		reparsed, err := Parse(command.String())
+		require.NoError(t, err, command.String())
+		require.Equal(t, command.Argv(), reparsed.Argv())
+	})
+}
@@ -0,0 +1,218 @@
+// Package sshd embeds the SSH server of gitlab-sshd in other programs, e.g.
+// to serve it on listeners they set up, to authenticate keys unknown to
+// GitLab or to audit and restrict the commands run.
+//
+// The server is configured like gitlab-sshd, with a config.yml, while the
+// options customize it:
+//
+//	cfg, err := sshd.LoadConfig("/etc/gitlab-shell")
+//	if err != nil {
+//		return err
+//	}
+//
+//	server, err := sshd.New(cfg,
+//		sshd.WithListener(listener),
+//		sshd.WithCommandHook(func(ctx context.Context, command sshd.Command) error {
+//			if command.ActorType == "deploy_key" && command.Name == "git-receive-pack" {
+//				return errors.New("deploy keys can't push to this instance")
+//			}
+//			return nil
+//		}),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//
+//	go server.Serve(ctx)
+//	defer server.Shutdown()
+//
+// Programs embedding the server set up logging and metrics themselves.
+package sshd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/command/commandargs"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/sshd"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/pkg/sshcmd"
+)
+
+// Config is the configuration of the server, read from config.yml
+type Config = config.Config
+
+// LoadConfig reads the config.yml, config.toml or config.json in dir and
+// checks it
+func LoadConfig(dir string) (*Config, error) {
+	cfg, err := config.NewFromDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.IsSane(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Identity is the GitLab identity a key authenticates as
+type Identity struct {
+	// KeyID is the ID of a key known to GitLab
+	KeyID int64
+	// Username is the GitLab user, for keys unknown to GitLab. Either KeyID
+	// or Username must be set.
+	Username string
+	// ActorType is the type of the owner of the key, e.g. user or
+	// deploy_key, see Command
+	ActorType string
+}
+
+// AuthCallback authenticates the key offered by the SSH user before it's
+// looked up in GitLab. It returns a nil Identity for the key to be looked
+// up, and an error to reject it.
+type AuthCallback func(ctx context.Context, user string, key ssh.PublicKey) (*Identity, error)
+
+// Command describes a command about to be run
+type Command struct {
+	sshcmd.Command
+	// KeyID and Username are who authenticated, KeyID being empty for users
+	// authenticated by a certificate or by Kerberos
+	KeyID    string
+	Username string
+	// ActorType is the type of the owner of the key, empty when GitLab
+	// doesn't tell it
+	ActorType  string
+	RemoteAddr string
+	// Env holds the variables of the sshd allowed_env the client sent
+	Env map[string]string
+}
+
+// CommandHook is called before every command runs, and rejects it by
+// returning an error, which is shown to the user
+type CommandHook func(ctx context.Context, command Command) error
+
+// Option customizes the server
+type Option func(*Server)
+
+// WithListener serves the SSH connections of listener rather than of the
+// sshd listen address of the configuration
+func WithListener(listener net.Listener) Option {
+	return func(s *Server) {
+		s.listener = listener
+	}
+}
+
+// WithAuthCallback authenticates keys with callback before they're looked up
+// in GitLab
+func WithAuthCallback(callback AuthCallback) Option {
+	return func(s *Server) {
+		s.server.Authenticate = func(ctx context.Context, user string, key ssh.PublicKey) (*ssh.Permissions, error) {
+			identity, err := callback(ctx, user, key)
+			if err != nil || identity == nil {
+				return nil, err
+			}
+
+			return identity.permissions()
+		}
+	}
+}
+
+// WithCommandHook calls hook before every command runs
+func WithCommandHook(hook CommandHook) Option {
+	return func(s *Server) {
+		s.server.CommandHook = func(ctx context.Context, args *commandargs.Shell) error {
+			return hook(ctx, Command{
+				Command:    command(args.SshArgs),
+				KeyID:      args.GitlabKeyId,
+				Username:   args.GitlabUsername,
+				ActorType:  string(args.ActorType),
+				RemoteAddr: args.Env.RemoteAddr,
+				Env:        args.Env.Vars,
+			})
+		}
+	}
+}
+
+// WithVersion sets the version reported by the readiness probe
+func WithVersion(version, buildTime string) Option {
+	return func(s *Server) {
+		s.server.Version = version
+		s.server.BuildTime = buildTime
+	}
+}
+
+// Server is an embedded SSH server
+type Server struct {
+	server   *sshd.Server
+	listener net.Listener
+}
+
+// New returns a server configured by cfg, customized by opts
+func New(cfg *Config, opts ...Option) (*Server, error) {
+	server, err := sshd.NewServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{server: server}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+// Serve serves the SSH connections until Shutdown is called and the
+// sessions in progress ended
+func (s *Server) Serve(ctx context.Context) error {
+	if s.listener != nil {
+		return s.server.Serve(ctx, s.listener)
+	}
+
+	return s.server.ListenAndServe(ctx)
+}
+
+// Shutdown stops accepting connections, letting the sessions in progress end
+func (s *Server) Shutdown() error {
+	return s.server.Shutdown()
+}
+
+// MonitoringHandler serves the readiness and liveness probes of the server,
+// along with the other endpoints of the sshd web_listen address
+func (s *Server) MonitoringHandler() http.Handler {
+	return s.server.MonitoringServeMux()
+}
+
+func (i *Identity) permissions() (*ssh.Permissions, error) {
+	extensions := map[string]string{}
+	switch {
+	case i.KeyID > 0:
+		extensions["key-id"] = strconv.FormatInt(i.KeyID, 10)
+	case i.Username != "":
+		extensions["username"] = i.Username
+	default:
+		return nil, errors.New("sshd: the identity has neither a key ID nor a username")
+	}
+
+	if i.ActorType != "" {
+		extensions["actor-type"] = i.ActorType
+	}
+
+	return &ssh.Permissions{Extensions: extensions}, nil
+}
+
+// command returns the command of sshArgs, parsed by sshcmd.Parse
+func command(sshArgs []string) sshcmd.Command {
+	if len(sshArgs) == 0 {
+		return sshcmd.Command{}
+	}
+
+	return sshcmd.Command{Name: sshArgs[0], Args: sshArgs[1:]}
+}
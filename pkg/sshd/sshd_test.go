@@ -0,0 +1,123 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/gitlab-org/gitlab-shell/v14/client/testserver"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/config"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/internal/testhelper"
+	"gitlab.com/gitlab-org/gitlab-shell/v14/pkg/sshcmd"
+)
+
+func TestServer(t *testing.T) {
+	testRoot := testhelper.PrepareTestRootDir(t)
+
+	url := testserver.StartSocketHttpServer(t, []testserver.TestRequestHandler{
+		{
+			Path: "/api/v4/internal/discover",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "alex-doe", r.URL.Query().Get("username"))
+				fmt.Fprint(w, `{"id": 1000, "name": "Alex Doe", "username": "alex-doe"}`)
+			},
+		},
+	})
+
+	cfg := &Config{GitlabUrl: url, User: "git", Server: config.DefaultServerConfig}
+	cfg.Server.HostKeyFiles = []string{path.Join(testRoot, "certs/valid/server.key")}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var commands []Command
+	server, err := New(cfg,
+		WithListener(listener),
+		WithAuthCallback(func(ctx context.Context, user string, key ssh.PublicKey) (*Identity, error) {
+			return &Identity{Username: "alex-doe", ActorType: "user"}, nil
+		}),
+		WithCommandHook(func(ctx context.Context, command Command) error {
+			mu.Lock()
+			defer mu.Unlock()
+			commands = append(commands, command)
+
+			if command.Name == "git-receive-pack" {
+				return errors.New("pushes are disabled")
+			}
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+
+	served := make(chan error)
+	go func() { served <- server.Serve(context.Background()) }()
+
+	client := dial(t, testRoot, listener.Addr().String())
+
+	require.Equal(t, "Welcome to GitLab, @alex-doe!\n", run(t, client, "discover", nil))
+
+	var exitErr *ssh.ExitError
+	run(t, client, "git-receive-pack 'group/project.git'", &exitErr)
+	require.NotNil(t, exitErr)
+
+	mu.Lock()
+	require.Equal(t, []Command{
+		{Command: sshcmd.Command{Name: "discover", Args: []string{}}, Username: "alex-doe", ActorType: "user", RemoteAddr: client.LocalAddr().String()},
+		{Command: sshcmd.Command{Name: "git-receive-pack", Args: []string{"group/project.git"}}, Username: "alex-doe", ActorType: "user", RemoteAddr: client.LocalAddr().String()},
+	}, commands)
+	mu.Unlock()
+
+	require.NoError(t, client.Close())
+	require.NoError(t, server.Shutdown())
+	require.NoError(t, <-served)
+}
+
+func TestIdentityPermissions(t *testing.T) {
+	permissions, err := (&Identity{KeyID: 1, ActorType: "deploy_key"}).permissions()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"key-id": "1", "actor-type": "deploy_key"}, permissions.Extensions)
+
+	_, err = (&Identity{}).permissions()
+	require.EqualError(t, err, "sshd: the identity has neither a key ID nor a username")
+}
+
+func dial(t *testing.T, testRoot, addr string) *ssh.Client {
+	key, err := os.ReadFile(path.Join(testRoot, "certs/client/key.pem"))
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(key)
+	require.NoError(t, err)
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "git",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+
+	return client
+}
+
+func run(t *testing.T, client *ssh.Client, command string, exitErr **ssh.ExitError) string {
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+
+	output, err := session.Output(command)
+	if exitErr != nil {
+		require.ErrorAs(t, err, exitErr)
+	} else {
+		require.NoError(t, err)
+	}
+
+	return string(output)
+}
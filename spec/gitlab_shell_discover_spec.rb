@@ -110,7 +110,7 @@ describe 'bin/gitlab-shell' do
     it 'outputs "Only SSH allowed"' do
       _, stderr, status = run!(["-c/usr/share/webapps/gitlab-shell/bin/gitlab-shell", "username-someuser"], env: {'SSH_CONNECTION' => ''})
 
-      expect(stderr).to eq("Only SSH allowed\n")
+      expect(stderr).to eq("Only SSH allowed\nGITLAB-SHELL-ERROR: internal\n")
       expect(status).not_to be_success
     end
 
@@ -118,6 +118,7 @@ describe 'bin/gitlab-shell' do
       _, stderr, status = run!(["-c/usr/share/webapps/gitlab-shell/bin/gitlab-shell", "username-broken_message"])
 
       expect(stderr).to match(/Failed to get username: Forbidden!/)
+      expect(stderr).to end_with("GITLAB-SHELL-ERROR: access_denied\n")
       expect(status).not_to be_success
     end
 
@@ -125,6 +126,7 @@ describe 'bin/gitlab-shell' do
       _, stderr, status = run!(["-c/usr/share/webapps/gitlab-shell/bin/gitlab-shell", "username-broken"])
 
       expect(stderr).to match(/Failed to get username: Internal API unreachable/)
+      expect(stderr).to end_with("GITLAB-SHELL-ERROR: api_unavailable\n")
       expect(status).not_to be_success
     end
   end
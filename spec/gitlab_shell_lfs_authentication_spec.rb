@@ -120,7 +120,8 @@ describe 'bin/gitlab-shell git-lfs-authentication' do
         divider = "remote: \nremote: ========================================================================\nremote: \n"
         _, stderr, status = Open3.capture3(env, cmd)
 
-        expect(stderr).to eq("#{divider}remote: Disallowed command\n#{divider}")
+        expect(stderr).to eq("#{divider}remote: Disallowed command\n#{divider}GITLAB-SHELL-ERROR: access_denied\n")
+        expect(status.exitstatus).to eq(3)
         expect(status).not_to be_success
       end
     end